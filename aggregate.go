@@ -0,0 +1,383 @@
+package graphsplit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"sort"
+
+	commcid "github.com/filecoin-project/go-fil-commcid"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/ipfs/go-cid"
+)
+
+// aggregateEntrySize is the size of an entry of the data segment index of an
+// aggregate, see FRC-0058.
+const aggregateEntrySize = 64
+
+// AggregatePiece is a piece of an aggregate with its inclusion proof.
+type AggregatePiece struct {
+	Path       string              `json:"path,omitempty"`
+	PayloadCid string              `json:"payload_cid,omitempty"`
+	PieceCid   string              `json:"piece_cid"`
+	PieceSize  abi.PaddedPieceSize `json:"piece_size"`
+	// Offset is the offset of the piece in the padded aggregate
+	Offset uint64         `json:"offset"`
+	Proof  InclusionProof `json:"inclusion_proof"`
+}
+
+// InclusionProof proves that a piece is part of an aggregate at its offset
+// and that the data segment index of the aggregate has an entry for it.
+type InclusionProof struct {
+	ProofSubtree ProofData `json:"proof_subtree"`
+	ProofIndex   ProofData `json:"proof_index"`
+}
+
+// ProofData is a merkle proof of a node of the aggregate tree: the index of
+// the node at its level and the hex encoded siblings up to the root.
+type ProofData struct {
+	Index uint64   `json:"index"`
+	Path  []string `json:"path"`
+}
+
+// AggregateManifest describes an aggregate piece built by NewAggregate.
+type AggregateManifest struct {
+	PieceCid  string              `json:"piece_cid"`
+	PieceSize abi.PaddedPieceSize `json:"piece_size"`
+	// IndexOffset is the offset of the data segment index in the padded
+	// aggregate
+	IndexOffset uint64           `json:"index_offset"`
+	Pieces      []AggregatePiece `json:"pieces"`
+}
+
+// aggregateIndexOffset returns the offset of the data segment index at the
+// end of an aggregate of dealSize and the number of entries it has room for.
+func aggregateIndexOffset(dealSize abi.PaddedPieceSize) (uint64, int) {
+	entries := uint64(dealSize) / 2048 / aggregateEntrySize
+	maxEntries := 4
+	if entries > 4 {
+		maxEntries = 1 << bits.Len64(entries-1)
+	}
+	return uint64(dealSize) - uint64(maxEntries)*aggregateEntrySize, maxEntries
+}
+
+// AggregateCars computes the piece cids of the CAR files at paths, CAR files
+// or directories of them, looked up in cache first if it is not nil, and
+// aggregates them with NewAggregate.
+func AggregateCars(ctx context.Context, paths []string, dealSize abi.PaddedPieceSize, cache *CommPCache) (*AggregateManifest, error) {
+	var cars []string
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			cars = append(cars, p)
+			continue
+		}
+		files, err := carFiles(p)
+		if err != nil {
+			return nil, err
+		}
+		cars = append(cars, files...)
+	}
+	pieces := make([]AggregatePiece, len(cars))
+	for i, p := range cars {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		ret, err := cache.CalcCommP(ctx, p, false, false)
+		if err != nil {
+			return nil, fmt.Errorf("commP of %s failed: %w", p, err)
+		}
+		pieces[i] = AggregatePiece{Path: p, PieceCid: ret.Root.String(), PieceSize: ret.Size.Padded()}
+		if ret.PayloadCid.Defined() {
+			pieces[i].PayloadCid = ret.PayloadCid.String()
+		}
+	}
+	return NewAggregate(dealSize, pieces)
+}
+
+// NewAggregate places pieces, whose PieceCid and PieceSize are set, in an
+// aggregate piece of dealSize following FRC-0058 (PODSI): pieces are placed
+// biggest first, each aligned to its size, and the data segment index listing
+// them is placed at the end. The pieces of the returned manifest carry their
+// offsets and inclusion proofs.
+func NewAggregate(dealSize abi.PaddedPieceSize, pieces []AggregatePiece) (*AggregateManifest, error) {
+	if err := dealSize.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid aggregate size %d: %w", dealSize, err)
+	}
+	indexOffset, maxEntries := aggregateIndexOffset(dealSize)
+	if len(pieces) == 0 {
+		return nil, fmt.Errorf("no pieces to aggregate")
+	}
+	if len(pieces) > maxEntries {
+		return nil, fmt.Errorf("an aggregate of %d bytes holds %d pieces at most, got %d", dealSize, maxEntries, len(pieces))
+	}
+	pieces = append([]AggregatePiece(nil), pieces...)
+	sort.SliceStable(pieces, func(i, j int) bool { return pieces[i].PieceSize > pieces[j].PieceSize })
+
+	depth := bits.TrailingZeros64(uint64(dealSize) / 32)
+	tree := newAggregateTree(depth)
+	var offset uint64
+	for i := range pieces {
+		p := &pieces[i]
+		if err := p.PieceSize.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid size of piece %s: %w", p.PieceCid, err)
+		}
+		commD, err := pieceCommitment(p.PieceCid)
+		if err != nil {
+			return nil, err
+		}
+		// sizes decrease, so the offset is aligned to the size of the piece
+		p.Offset = offset
+		offset += uint64(p.PieceSize)
+		if offset > indexOffset {
+			return nil, fmt.Errorf("pieces of %d bytes do not fit an aggregate of %d bytes with its index", offset, dealSize)
+		}
+		tree.set(bits.TrailingZeros64(uint64(p.PieceSize)/32), p.Offset/uint64(p.PieceSize), commD)
+		entry := aggregateEntry(commD, p.Offset, uint64(p.PieceSize))
+		tree.set(1, indexOffset/aggregateEntrySize+uint64(i), hashNode(entry[:32], entry[32:]))
+	}
+	tree.build()
+
+	for i := range pieces {
+		p := &pieces[i]
+		level := bits.TrailingZeros64(uint64(p.PieceSize) / 32)
+		p.Proof.ProofSubtree = tree.proof(level, p.Offset/uint64(p.PieceSize))
+		p.Proof.ProofIndex = tree.proof(1, indexOffset/aggregateEntrySize+uint64(i))
+	}
+	root := tree.root()
+	pieceCid, err := commcid.DataCommitmentV1ToCID(root[:])
+	if err != nil {
+		return nil, err
+	}
+	return &AggregateManifest{PieceCid: pieceCid.String(), PieceSize: dealSize, IndexOffset: indexOffset, Pieces: pieces}, nil
+}
+
+// VerifyInclusion checks the inclusion proof of p against the aggregate
+// piece cid and size of m.
+func (m *AggregateManifest) VerifyInclusion(p AggregatePiece) error {
+	aggCid, err := cid.Decode(m.PieceCid)
+	if err != nil {
+		return err
+	}
+	b, err := commcid.CIDToDataCommitmentV1(aggCid)
+	if err != nil {
+		return err
+	}
+	var root [32]byte
+	copy(root[:], b)
+	commD, err := pieceCommitment(p.PieceCid)
+	if err != nil {
+		return err
+	}
+	if p.PieceSize == 0 || p.Offset != p.Proof.ProofSubtree.Index*uint64(p.PieceSize) {
+		return fmt.Errorf("piece %s is not at offset %d", p.PieceCid, p.Offset)
+	}
+	depth := bits.TrailingZeros64(uint64(m.PieceSize) / 32)
+	level := bits.TrailingZeros64(uint64(p.PieceSize) / 32)
+	if err := verifyProof(p.Proof.ProofSubtree, commD, depth-level, root); err != nil {
+		return fmt.Errorf("piece %s: %w", p.PieceCid, err)
+	}
+	indexOffset, _ := aggregateIndexOffset(m.PieceSize)
+	if p.Proof.ProofIndex.Index < indexOffset/aggregateEntrySize {
+		return fmt.Errorf("index entry of piece %s is outside of the index", p.PieceCid)
+	}
+	entry := aggregateEntry(commD, p.Offset, uint64(p.PieceSize))
+	if err := verifyProof(p.Proof.ProofIndex, hashNode(entry[:32], entry[32:]), depth-1, root); err != nil {
+		return fmt.Errorf("index entry of piece %s: %w", p.PieceCid, err)
+	}
+	return nil
+}
+
+// WriteAggregate writes the unpadded data of the aggregate m to w: the CAR
+// files of its pieces, uncompressed, at their offsets and the data segment
+// index, zero filled in between. The pieces need their Path.
+func WriteAggregate(w io.Writer, m *AggregateManifest) error {
+	pieces := append([]AggregatePiece(nil), m.Pieces...)
+	sort.Slice(pieces, func(i, j int) bool { return pieces[i].Offset < pieces[j].Offset })
+	var written uint64
+	fill := func(to uint64) error {
+		_, err := io.CopyN(w, NullReader{}, int64(to-written))
+		written = to
+		return err
+	}
+	entries := make([]byte, uint64(m.PieceSize)-m.IndexOffset)
+	for i, p := range pieces {
+		commD, err := pieceCommitment(p.PieceCid)
+		if err != nil {
+			return err
+		}
+		entry := aggregateEntry(commD, p.Offset, uint64(p.PieceSize))
+		copy(entries[i*aggregateEntrySize:], entry[:])
+
+		if err := fill(unpaddedOffset(p.Offset)); err != nil {
+			return err
+		}
+		f, err := openCar(p.Path)
+		if err != nil {
+			return err
+		}
+		size := uint64(p.PieceSize.Unpadded())
+		n, err := io.Copy(w, io.LimitReader(f, int64(size)+1))
+		f.Close()
+		if err != nil {
+			return err
+		}
+		if uint64(n) > size {
+			return fmt.Errorf("%s does not fit its piece of %d bytes", p.Path, p.PieceSize)
+		}
+		written += uint64(n)
+	}
+	if err := fill(unpaddedOffset(m.IndexOffset)); err != nil {
+		return err
+	}
+	_, err := w.Write(unpadFr32(entries))
+	return err
+}
+
+// unpaddedOffset maps an offset of padded data, a multiple of 128, to the
+// offset of the unpadded data.
+func unpaddedOffset(offset uint64) uint64 {
+	return offset / 128 * 127
+}
+
+// aggregateEntry returns the index entry of a piece with commitment commD at
+// offset of size: commD, offset and size little endian and a checksum.
+func aggregateEntry(commD [32]byte, offset, size uint64) [aggregateEntrySize]byte {
+	var entry [aggregateEntrySize]byte
+	copy(entry[:32], commD[:])
+	binary.LittleEndian.PutUint64(entry[32:40], offset)
+	binary.LittleEndian.PutUint64(entry[40:48], size)
+	sum := sha256.Sum256(entry[:48])
+	copy(entry[48:], sum[:16])
+	entry[63] &= 0x3f
+	return entry
+}
+
+func pieceCommitment(pieceCid string) ([32]byte, error) {
+	var commD [32]byte
+	c, err := cid.Decode(pieceCid)
+	if err != nil {
+		return commD, fmt.Errorf("invalid piece cid %q: %w", pieceCid, err)
+	}
+	b, err := commcid.CIDToDataCommitmentV1(c)
+	if err != nil {
+		return commD, fmt.Errorf("invalid piece cid %q: %w", pieceCid, err)
+	}
+	copy(commD[:], b)
+	return commD, nil
+}
+
+// hashNode is the sha256 of two nodes of a piece tree truncated to 254 bits.
+func hashNode(left, right []byte) [32]byte {
+	h := sha256.New()
+	h.Write(left)
+	h.Write(right)
+	var out [32]byte
+	h.Sum(out[:0])
+	out[31] &= 0x3f
+	return out
+}
+
+func verifyProof(proof ProofData, node [32]byte, levels int, root [32]byte) error {
+	if len(proof.Path) != levels {
+		return fmt.Errorf("proof has %d nodes, expected %d", len(proof.Path), levels)
+	}
+	idx := proof.Index
+	for _, s := range proof.Path {
+		sib, err := hex.DecodeString(s)
+		if err != nil || len(sib) != 32 {
+			return fmt.Errorf("invalid proof node %q", s)
+		}
+		if idx&1 == 0 {
+			node = hashNode(node[:], sib)
+		} else {
+			node = hashNode(sib, node[:])
+		}
+		idx >>= 1
+	}
+	if idx != 0 || node != root {
+		return fmt.Errorf("proof does not match the aggregate")
+	}
+	return nil
+}
+
+// aggregateTree is a sparse piece tree, nodes which are not set are the
+// commitments of zeros.
+type aggregateTree struct {
+	levels []map[uint64][32]byte
+	zeros  [][32]byte
+}
+
+func newAggregateTree(depth int) *aggregateTree {
+	t := &aggregateTree{levels: make([]map[uint64][32]byte, depth+1), zeros: make([][32]byte, depth+1)}
+	for l := range t.levels {
+		t.levels[l] = make(map[uint64][32]byte)
+		if l > 0 {
+			t.zeros[l] = hashNode(t.zeros[l-1][:], t.zeros[l-1][:])
+		}
+	}
+	return t
+}
+
+func (t *aggregateTree) set(level int, index uint64, node [32]byte) {
+	t.levels[level][index] = node
+}
+
+func (t *aggregateTree) node(level int, index uint64) [32]byte {
+	if n, ok := t.levels[level][index]; ok {
+		return n
+	}
+	return t.zeros[level]
+}
+
+// build computes the parents of the nodes set, level by level.
+func (t *aggregateTree) build() {
+	for l := 0; l < len(t.levels)-1; l++ {
+		for idx := range t.levels[l] {
+			parent := idx >> 1
+			if _, ok := t.levels[l+1][parent]; ok {
+				continue
+			}
+			left, right := t.node(l, idx&^1), t.node(l, idx|1)
+			t.levels[l+1][parent] = hashNode(left[:], right[:])
+		}
+	}
+}
+
+func (t *aggregateTree) root() [32]byte {
+	return t.node(len(t.levels)-1, 0)
+}
+
+func (t *aggregateTree) proof(level int, index uint64) ProofData {
+	proof := ProofData{Index: index}
+	for l := level; l < len(t.levels)-1; l++ {
+		sib := t.node(l, index^1)
+		proof.Path = append(proof.Path, hex.EncodeToString(sib[:]))
+		index >>= 1
+	}
+	return proof
+}
+
+// unpadFr32 reverses the fr32 padding of data, a multiple of 128 bytes: every
+// 32 bytes hold 254 bits of the unpadded data.
+func unpadFr32(data []byte) []byte {
+	out := make([]byte, len(data)/128*127)
+	for c := 0; c < len(data)/128; c++ {
+		src, dst := data[c*128:(c+1)*128], out[c*127:]
+		for bit := 0; bit < 127*8; bit++ {
+			b := bit % 254
+			if src[bit/254*32+b/8]>>(b%8)&1 != 0 {
+				dst[bit/8] |= 1 << (bit % 8)
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,49 @@
+package graphsplit
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/go-commp-utils/v2/writer"
+	"github.com/filecoin-project/go-state-types/abi"
+	blocks "github.com/ipfs/go-block-format"
+)
+
+func TestAggregateCars(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	for i, size := range []int{1000, 5000, 3000} {
+		p := filepath.Join(dir, string(rune('a'+i))+".car")
+		writeTestCar(t, p, []blocks.Block{blocks.NewBlock(bytes.Repeat([]byte{byte(i + 1)}, size))})
+		paths = append(paths, p)
+	}
+	m, err := AggregateCars(context.Background(), paths, abi.PaddedPieceSize(32<<10), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range m.Pieces {
+		if err := m.VerifyInclusion(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bad := m.Pieces[1]
+	bad.Offset, bad.Proof.ProofSubtree.Index = m.Pieces[0].Offset, m.Pieces[0].Proof.ProofSubtree.Index
+	if err := m.VerifyInclusion(bad); err == nil {
+		t.Fatal("expected a proof at another offset to fail")
+	}
+
+	// the piece cid of the written aggregate is the one of the manifest
+	w := new(writer.Writer)
+	if err := WriteAggregate(w, m); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := w.Sum()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.PieceCID.String() != m.PieceCid || sum.PieceSize != m.PieceSize {
+		t.Fatalf("expected %s of %d bytes, got %s of %d bytes", m.PieceCid, m.PieceSize, sum.PieceCID, sum.PieceSize)
+	}
+}
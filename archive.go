@@ -0,0 +1,358 @@
+package graphsplit
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// archiveKind returns the kind of archive name is, empty if it is none.
+func archiveKind(name string) string {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return "tar.gz"
+	case strings.HasSuffix(lower, ".tar"):
+		return "tar"
+	case strings.HasSuffix(lower, ".zip"):
+		return "zip"
+	}
+	return ""
+}
+
+// archiveMember is a regular file inside an archive, it is read without
+// extracting the archive.
+type archiveMember struct {
+	archive string
+	kind    string
+	name    string
+	// offset is the position of the data in the archive file for members
+	// stored uncompressed, -1 if the member has to be decompressed
+	offset int64
+}
+
+// open reads the bytes [start, end) of the member.
+func (m *archiveMember) open(start, end int64) (io.ReadCloser, error) {
+	f, err := os.Open(m.archive)
+	if err != nil {
+		return nil, err
+	}
+	if m.offset >= 0 {
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.NewSectionReader(f, m.offset+start, end-start), f}, nil
+	}
+	var r io.Reader
+	closers := multiCloser{f}
+	switch m.kind {
+	case "zip":
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		zr, err := zip.NewReader(f, fi.Size())
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		for _, zf := range zr.File {
+			if zf.Name != m.name {
+				continue
+			}
+			rc, err := zf.Open()
+			if err != nil {
+				f.Close()
+				return nil, err
+			}
+			r = rc
+			closers = append(closers, rc)
+			break
+		}
+	case "tar.gz":
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		closers = append(closers, gz)
+		tr := tar.NewReader(gz)
+		for {
+			hdr, err := tr.Next()
+			if err != nil {
+				closers.Close()
+				return nil, fmt.Errorf("failed to find %s in %s: %w", m.name, m.archive, err)
+			}
+			if hdr.Name == m.name {
+				r = tr
+				break
+			}
+		}
+	}
+	if r == nil {
+		closers.Close()
+		return nil, fmt.Errorf("%s not found in %s", m.name, m.archive)
+	}
+	if _, err := io.CopyN(io.Discard, r, start); err != nil {
+		closers.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(r, end-start), closers}, nil
+}
+
+type multiCloser []io.Closer
+
+func (mc multiCloser) Close() error {
+	var firstErr error
+	for i := len(mc) - 1; i >= 0; i-- {
+		if err := mc[i].Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// memberPath returns the path of a member below the directory which takes the
+// place of the archive, false for names escaping it.
+func memberPath(archive, name string) (string, bool) {
+	for _, el := range strings.Split(name, "/") {
+		if el == ".." {
+			return "", false
+		}
+	}
+	clean := path.Clean("/" + name)
+	if clean == "/" {
+		return "", false
+	}
+	return archive + clean, true
+}
+
+// expandArchives replaces the archives among files by their regular files,
+// which are placed below a directory named like the archive.
+func expandArchives(files []Finfo) ([]Finfo, error) {
+	var expanded []Finfo
+	for _, item := range files {
+		kind := archiveKind(item.Info.Name())
+		if kind == "" {
+			expanded = append(expanded, item)
+			continue
+		}
+		members, err := listArchive(item.Path, kind)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list archive %s: %w", item.Path, err)
+		}
+		log.Infof("expanded %s into %d files", item.Path, len(members))
+		expanded = append(expanded, members...)
+	}
+	return expanded, nil
+}
+
+func listArchive(archive, kind string) ([]Finfo, error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var members []Finfo
+	add := func(name string, info os.FileInfo, offset int64) {
+		p, ok := memberPath(archive, name)
+		if !ok {
+			log.Warnf("skip %s of %s, it is outside of the archive", name, archive)
+			return
+		}
+		members = append(members, Finfo{
+			Path:   p,
+			Name:   path.Base(p),
+			Info:   info,
+			member: &archiveMember{archive: archive, kind: kind, name: name, offset: offset},
+		})
+	}
+	switch kind {
+	case "zip":
+		fi, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		zr, err := zip.NewReader(f, fi.Size())
+		if err != nil {
+			return nil, err
+		}
+		for _, zf := range zr.File {
+			if !zf.Mode().IsRegular() {
+				continue
+			}
+			offset := int64(-1)
+			if zf.Method == zip.Store {
+				if offset, err = zf.DataOffset(); err != nil {
+					return nil, err
+				}
+			}
+			add(zf.Name, zf.FileInfo(), offset)
+		}
+	case "tar", "tar.gz":
+		var r io.Reader = f
+		if kind == "tar.gz" {
+			gz, err := gzip.NewReader(f)
+			if err != nil {
+				return nil, err
+			}
+			defer gz.Close()
+			r = gz
+		}
+		tr := tar.NewReader(r)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if hdr.Typeflag != tar.TypeReg {
+				continue
+			}
+			offset := int64(-1)
+			if kind == "tar" {
+				// the tar reader seeks over file data, so the archive is
+				// positioned at the data of the member
+				if offset, err = f.Seek(0, io.SeekCurrent); err != nil {
+					return nil, err
+				}
+			}
+			add(hdr.Name, hdr.FileInfo(), offset)
+		}
+	}
+	return members, nil
+}
+
+// RepackArchives packs the directories below dir which are named like
+// archives, e.g. restored from chunking with expanded archives, back into
+// archive files. The archives hold the same files but are not byte for byte
+// the original ones.
+func RepackArchives(dir string) error {
+	var dirs []string
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() && p != dir && archiveKind(fi.Name()) != "" {
+			dirs = append(dirs, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// pack nested archives first
+	sort.Slice(dirs, func(i, j int) bool { return len(dirs[i]) > len(dirs[j]) })
+	for _, d := range dirs {
+		log.Infof("repack %s", d)
+		if err := repackArchive(d, archiveKind(filepath.Base(d))); err != nil {
+			return fmt.Errorf("failed to repack %s: %w", d, err)
+		}
+	}
+	return nil
+}
+
+func repackArchive(dir, kind string) error {
+	af, err := createAtomic(dir + ".repack")
+	if err != nil {
+		return err
+	}
+	if err := writeArchive(af, dir, kind); err != nil {
+		af.Abort()
+		return err
+	}
+	if err := af.Commit(); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	return os.Rename(dir+".repack", dir)
+}
+
+func writeArchive(w io.Writer, dir, kind string) error {
+	var (
+		addFile func(name string, fi os.FileInfo, r io.Reader) error
+		finish  func() error
+	)
+	switch kind {
+	case "zip":
+		zw := zip.NewWriter(w)
+		addFile = func(name string, fi os.FileInfo, r io.Reader) error {
+			hdr, err := zip.FileInfoHeader(fi)
+			if err != nil {
+				return err
+			}
+			hdr.Name = name
+			hdr.Method = zip.Deflate
+			fw, err := zw.CreateHeader(hdr)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(fw, r)
+			return err
+		}
+		finish = zw.Close
+	default:
+		var gz *gzip.Writer
+		if kind == "tar.gz" {
+			gz = gzip.NewWriter(w)
+			w = gz
+		}
+		tw := tar.NewWriter(w)
+		addFile = func(name string, fi os.FileInfo, r io.Reader) error {
+			hdr, err := tar.FileInfoHeader(fi, "")
+			if err != nil {
+				return err
+			}
+			hdr.Name = name
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			_, err = io.Copy(tw, r)
+			return err
+		}
+		finish = func() error {
+			if err := tw.Close(); err != nil {
+				return err
+			}
+			if gz != nil {
+				return gz.Close()
+			}
+			return nil
+		}
+	}
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return addFile(filepath.ToSlash(rel), fi, f)
+	})
+	if err != nil {
+		return err
+	}
+	return finish()
+}
@@ -0,0 +1,88 @@
+package graphsplit
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExpandArchives(t *testing.T) {
+	dir := t.TempDir()
+	content := map[string][]byte{
+		"a.txt":     []byte("hello archive"),
+		"sub/b.bin": bytes.Repeat([]byte("0123456789"), 100),
+	}
+	var tarBuf, zipBuf bytes.Buffer
+	tw := tar.NewWriter(&tarBuf)
+	zw := zip.NewWriter(&zipBuf)
+	for name, data := range content {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(data)
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+	}
+	tw.Close()
+	zw.Close()
+	os.WriteFile(filepath.Join(dir, "data.tar"), tarBuf.Bytes(), 0o644)
+	os.WriteFile(filepath.Join(dir, "data.zip"), zipBuf.Bytes(), 0o644)
+
+	var files []Finfo
+	for item := range GetFileListAsync([]string{dir}) {
+		files = append(files, item)
+	}
+	files, err := expandArchives(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 4 {
+		t.Fatalf("expected 4 members, got %d", len(files))
+	}
+	for _, item := range files {
+		rel := filepath.ToSlash(strings.TrimPrefix(item.Path, dir+"/"))
+		want, ok := content[rel[strings.Index(rel, "/")+1:]]
+		if !ok {
+			t.Fatalf("unexpected member %s", item.Path)
+		}
+		item.SeekStart, item.SeekEnd = 5, int64(len(want))-1
+		r, err := openItem(item, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want[5:]) {
+			t.Fatalf("unexpected content of %s: %q", item.Path, got)
+		}
+	}
+}
+
+func TestRepackArchives(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "data.zip", "sub"), 0o755)
+	os.WriteFile(filepath.Join(dir, "data.zip", "sub", "b.txt"), []byte("repacked"), 0o644)
+
+	if err := RepackArchives(dir); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.OpenReader(filepath.Join(dir, "data.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	if len(zr.File) != 1 || zr.File[0].Name != "sub/b.txt" {
+		t.Fatalf("unexpected archive files %v", zr.File)
+	}
+}
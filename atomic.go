@@ -0,0 +1,91 @@
+package graphsplit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// TmpSuffix marks files which are still being written.
+const TmpSuffix = ".tmp"
+
+// atomicFile is written to path+TmpSuffix and only shows up under path once
+// Commit has synced and renamed it, so a crash never leaves a truncated file
+// under its final name.
+type atomicFile struct {
+	*os.File
+	path string
+}
+
+// pendingWrites are the temporary files of the atomic writes in progress.
+var pendingWrites sync.Map
+
+func createAtomic(path string) (*atomicFile, error) {
+	f, err := os.OpenFile(path+TmpSuffix, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	pendingWrites.Store(f.Name(), struct{}{})
+	return &atomicFile{File: f, path: path}, nil
+}
+
+// RemovePendingWrites removes the temporary files of the writes in progress,
+// before the process exits in the middle of them.
+func RemovePendingWrites() {
+	pendingWrites.Range(func(name, _ interface{}) bool {
+		if err := os.Remove(name.(string)); err == nil {
+			log.Infof("removed unfinished %s", name)
+		}
+		pendingWrites.Delete(name)
+		return true
+	})
+}
+
+func (f *atomicFile) Commit() error {
+	defer pendingWrites.Delete(f.Name())
+	if err := f.File.Sync(); err != nil {
+		f.Abort()
+		return err
+	}
+	if err := f.File.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), f.path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	// persist the rename itself
+	dir, err := os.Open(filepath.Dir(f.path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// Abort drops the partially written file.
+func (f *atomicFile) Abort() {
+	pendingWrites.Delete(f.Name())
+	f.File.Close()
+	os.Remove(f.Name())
+}
+
+// writeFileAtomic replaces the file at path with data, see atomicFile.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := createAtomic(path)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Abort()
+		return err
+	}
+	return f.Commit()
+}
+
+// isTmpFile reports whether path is a leftover of an interrupted write.
+func isTmpFile(path string) bool {
+	return strings.HasSuffix(path, TmpSuffix)
+}
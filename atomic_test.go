@@ -0,0 +1,103 @@
+package graphsplit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAtomicFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "piece.car")
+	if err := os.WriteFile(path, []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := createAtomic(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Name() != path+TmpSuffix || !isTmpFile(f.Name()) {
+		t.Fatalf("expected to write to %s, got %s", path+TmpSuffix, f.Name())
+	}
+	if _, err := f.Write([]byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "old" {
+		t.Fatalf("expected the old file until the commit, got %q", data)
+	}
+	if err := f.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "new" {
+		t.Fatalf("expected the new file after the commit, got %q", data)
+	}
+	if _, err := os.Stat(path + TmpSuffix); !os.IsNotExist(err) {
+		t.Fatal("expected the temporary file to be renamed")
+	}
+
+	f, err = createAtomic(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("partial"))
+	f.Abort()
+	if data, _ := os.ReadFile(path); string(data) != "new" {
+		t.Fatalf("expected an aborted write to keep the file, got %q", data)
+	}
+	if _, err := os.Stat(path + TmpSuffix); !os.IsNotExist(err) {
+		t.Fatal("expected an aborted write to remove the temporary file")
+	}
+}
+
+func TestAtomicFileRenameFailure(t *testing.T) {
+	dir := t.TempDir()
+	// a non-empty directory cannot be replaced by the file
+	path := filepath.Join(dir, "piece.car")
+	if err := os.MkdirAll(filepath.Join(path, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := createAtomic(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Commit(); err == nil {
+		t.Fatal("expected the rename to fail")
+	}
+	if _, err := os.Stat(path + TmpSuffix); !os.IsNotExist(err) {
+		t.Fatal("expected a failed commit to remove the temporary file")
+	}
+	if err := writeFileAtomic(path, []byte("new")); err == nil {
+		t.Fatal("expected writeFileAtomic to fail")
+	}
+}
+
+func TestChunkLeavesNoTmpFiles(t *testing.T) {
+	dir := writeTestTree(t, 100<<10, 100<<10)
+	carDir := t.TempDir()
+	rows := chunkTestTree(t, dir, &ChunkParams{
+		ExpectSliceSize: 64 << 10,
+		CarDir:          carDir,
+		Cb:              CommPCallback(carDir, false, false),
+	})
+	if len(rows) == 0 {
+		t.Fatal("expected slices")
+	}
+	err := filepath.Walk(carDir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && isTmpFile(path) {
+			t.Errorf("unexpected temporary file %s", path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if _, err := os.Stat(filepath.Join(carDir, row["piece_cid"]+".car")); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package graphsplit
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// backupFiles are the files of car-dir mapping pieces to their content.
+var backupFiles = []string{ManifestFileName, ManifestNDJSON.FileName(), ManifestJSON.FileName(), provenanceFileName(ManifestCSV), provenanceFileName(ManifestNDJSON), BatchFileName, PackStateFileName, SliceSizeStateFileName}
+
+// ManifestBackup snapshots the manifest and state files of a car dir into
+// timestamped directories under Dir, e.g. on another disk.
+type ManifestBackup struct {
+	Dir string
+	// Keep is the number of snapshots kept, 0 keeps all of them
+	Keep int
+}
+
+// Snapshot copies the manifest and state files of carDir into a new snapshot.
+func (mb *ManifestBackup) Snapshot(carDir string) error {
+	snapshot := filepath.Join(mb.Dir, time.Now().UTC().Format("20060102T150405Z"))
+	if err := os.MkdirAll(snapshot, 0o755); err != nil {
+		return err
+	}
+	for _, name := range backupFiles {
+		if err := copyFileAtomic(filepath.Join(carDir, name), filepath.Join(snapshot, name)); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+	}
+	log.Infof("manifest of %s backed up to %s", carDir, snapshot)
+	return mb.prune()
+}
+
+// Run takes a snapshot every interval until ctx is done.
+func (mb *ManifestBackup) Run(ctx context.Context, carDir string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := mb.Snapshot(carDir); err != nil {
+				log.Errorf("failed to back up manifest: %s", err)
+			}
+		}
+	}
+}
+
+func (mb *ManifestBackup) prune() error {
+	if mb.Keep <= 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(mb.Dir)
+	if err != nil {
+		return err
+	}
+	var snapshots []string
+	for _, e := range entries {
+		if e.IsDir() {
+			snapshots = append(snapshots, e.Name())
+		}
+	}
+	sort.Strings(snapshots)
+	for len(snapshots) > mb.Keep {
+		if err := os.RemoveAll(filepath.Join(mb.Dir, snapshots[0])); err != nil {
+			return err
+		}
+		snapshots = snapshots[1:]
+	}
+	return nil
+}
+
+func copyFileAtomic(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := createAtomic(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Abort()
+		return err
+	}
+	return out.Commit()
+}
@@ -0,0 +1,88 @@
+package graphsplit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestManifestBackup(t *testing.T) {
+	carDir := t.TempDir()
+	files := map[string]string{
+		ManifestFileName:  "payload_cid,filename,piece_cid,piece_size\nbafy1,a.car,baga1,254\n",
+		PackStateFileName: `{"files":{},"pieces":{}}`,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(carDir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dir := t.TempDir()
+	for _, old := range []string{"20200101T000000Z", "20200102T000000Z"} {
+		if err := os.Mkdir(filepath.Join(dir, old), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mb := &ManifestBackup{Dir: dir, Keep: 2}
+	if err := mb.Snapshot(carDir); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Name() != "20200102T000000Z" {
+		t.Fatalf("expected the oldest snapshot pruned, got %v", entries)
+	}
+	snapshot := filepath.Join(dir, entries[1].Name())
+	for name, want := range files {
+		data, err := os.ReadFile(filepath.Join(snapshot, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Fatalf("%s holds %q, expected %q", name, data, want)
+		}
+	}
+	// files missing in the car dir are skipped
+	got, err := os.ReadDir(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(files) {
+		t.Fatalf("expected %d files in the snapshot, got %v", len(files), got)
+	}
+}
+
+func TestManifestBackupRun(t *testing.T) {
+	carDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(carDir, ManifestFileName), []byte("payload_cid\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(t.TempDir(), "backup")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		(&ManifestBackup{Dir: dir}).Run(ctx, carDir, 10*time.Millisecond)
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if entries, _ := os.ReadDir(dir); len(entries) > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no snapshot taken")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+}
@@ -0,0 +1,183 @@
+package graphsplit
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const BatchFileName = "batches.json"
+
+// Batch groups the pieces handed to storage providers as one tranche.
+type Batch struct {
+	ID        int        `json:"id"`
+	Pieces    []string   `json:"pieces"`
+	Closed    bool       `json:"closed"`
+	CreatedAt time.Time  `json:"created_at"`
+	ClosedAt  *time.Time `json:"closed_at,omitempty"`
+}
+
+// BatchStore keeps track of batches in batches.json under car-dir. Pieces are
+// added to the open batch, which gets closed once it holds size pieces or
+// when it is closed by hand.
+type BatchStore struct {
+	mu      sync.Mutex
+	path    string
+	size    int
+	Batches []*Batch `json:"batches"`
+}
+
+// OpenBatchStore loads the batches of carDir, size is the number of pieces
+// of a batch, 0 means batches are only closed by hand.
+func OpenBatchStore(carDir string, size int) (*BatchStore, error) {
+	bs := &BatchStore{path: filepath.Join(carDir, BatchFileName), size: size}
+	if err := bs.load(); err != nil {
+		return nil, err
+	}
+	return bs, nil
+}
+
+// load reads batches.json again, a batch may have been closed by the batch
+// command while chunking is running.
+func (bs *BatchStore) load() error {
+	data, err := os.ReadFile(bs.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	bs.Batches = nil
+	if err := json.Unmarshal(data, bs); err != nil {
+		return fmt.Errorf("failed to load %s: %w", bs.path, err)
+	}
+	return nil
+}
+
+// Add puts the piece into the open batch and returns the batch id.
+func (bs *BatchStore) Add(piece string) (int, error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	if err := bs.load(); err != nil {
+		return 0, err
+	}
+	b := bs.open()
+	if b == nil {
+		b = &Batch{ID: len(bs.Batches) + 1, CreatedAt: time.Now()}
+		bs.Batches = append(bs.Batches, b)
+		log.Infof("batch %d opened", b.ID)
+	}
+	b.Pieces = append(b.Pieces, piece)
+	if bs.size > 0 && len(b.Pieces) >= bs.size {
+		bs.close(b)
+	}
+	return b.ID, bs.save()
+}
+
+// Close closes the open batch and returns it, nil if there is none.
+func (bs *BatchStore) Close() (*Batch, error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	if err := bs.load(); err != nil {
+		return nil, err
+	}
+	b := bs.open()
+	if b == nil {
+		return nil, nil
+	}
+	bs.close(b)
+	return b, bs.save()
+}
+
+// Get returns the batch with the id, nil if it does not exist.
+func (bs *BatchStore) Get(id int) *Batch {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	if id <= 0 || id > len(bs.Batches) {
+		return nil
+	}
+	return bs.Batches[id-1]
+}
+
+func (bs *BatchStore) open() *Batch {
+	if len(bs.Batches) == 0 {
+		return nil
+	}
+	if b := bs.Batches[len(bs.Batches)-1]; !b.Closed {
+		return b
+	}
+	return nil
+}
+
+func (bs *BatchStore) close(b *Batch) {
+	now := time.Now()
+	b.Closed = true
+	b.ClosedAt = &now
+	log.Infof("batch %d closed with %d pieces", b.ID, len(b.Pieces))
+}
+
+func (bs *BatchStore) save() error {
+	data, err := json.MarshalIndent(bs, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(bs.path, data)
+}
+
+// ExportBatch writes the manifest rows of the batch in carDir to w as CSV.
+func ExportBatch(carDir string, id int, w io.Writer) (int, error) {
+	f, err := os.Open(filepath.Join(carDir, ManifestFileName))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read manifest header: %w", err)
+	}
+	col := -1
+	for i, name := range header {
+		if name == "batch_id" {
+			col = i
+		}
+	}
+	if col < 0 {
+		return 0, fmt.Errorf("manifest has no batch_id column")
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return 0, err
+	}
+	var count int
+	batchID := strconv.Itoa(id)
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return count, err
+		}
+		if col < len(record) && record[col] == batchID {
+			if err := cw.Write(record); err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+	cw.Flush()
+	return count, cw.Error()
+}
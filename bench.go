@@ -0,0 +1,88 @@
+package graphsplit
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"time"
+
+	"github.com/filecoin-project/go-commp-utils/v2/writer"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filedrive-team/go-graphsplit/commp"
+)
+
+// CommPBenchResult is the time a commP implementation took, see BenchCommP.
+type CommPBenchResult struct {
+	Name     string
+	Bytes    int64
+	Duration time.Duration
+}
+
+// Throughput returns the bytes hashed per second.
+func (r CommPBenchResult) Throughput() float64 {
+	return float64(r.Bytes) / r.Duration.Seconds()
+}
+
+// BenchCommP computes the piece cid of size bytes of random data with each
+// commP implementation of graphsplit: the 8MiB leaves hashed by workers
+// goroutines as chunk and commP do, the stream of commP - and the resumable
+// commp.Hasher.
+func BenchCommP(size int64, workers int) ([]CommPBenchResult, error) {
+	if size < 1<<20 {
+		return nil, fmt.Errorf("benchmark at least 1MiB")
+	}
+	data := make([]byte, 8<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	src := repeatReaderAt(data)
+
+	var results []CommPBenchResult
+	run := func(name string, f func() error) error {
+		start := time.Now()
+		if err := f(); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		results = append(results, CommPBenchResult{Name: name, Bytes: size, Duration: time.Since(start)})
+		return nil
+	}
+	err := run(fmt.Sprintf("parallel leaves (%d workers)", workers), func() error {
+		_, _, err := generatePieceCID(abi.RegisteredSealProof_StackedDrg32GiBV1_1, src, size, workers)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = run("stream", func() error {
+		w := new(writer.Writer)
+		if _, err := io.Copy(w, io.NewSectionReader(src, 0, size)); err != nil {
+			return err
+		}
+		_, err := w.Sum()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = run("resumable commp.Hasher", func() error {
+		h := &commp.Hasher{}
+		if _, err := io.Copy(h, io.NewSectionReader(src, 0, size)); err != nil {
+			return err
+		}
+		_, _, err := h.Sum()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// repeatReaderAt reads the same data over and over.
+type repeatReaderAt []byte
+
+func (r repeatReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		n += copy(p[n:], r[(off+int64(n))%int64(len(r)):])
+	}
+	return n, nil
+}
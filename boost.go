@@ -0,0 +1,158 @@
+package graphsplit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BoostDealConfig describes the deals proposed for every finished piece.
+type BoostDealConfig struct {
+	// Providers are the miner addresses of the storage providers, e.g. f01234
+	Providers []string
+	// Price is the storage price in attoFIL per epoch per GiB
+	Price string
+	// Duration is the deal duration in epochs
+	Duration int
+	// Wallet is the client wallet, the default wallet of the boost client if empty
+	Wallet   string
+	Verified bool
+	// Offline proposes offline deals, the storage providers import the CAR
+	// files themselves, e.g. from the list of export-deals
+	Offline bool
+	// URL is the template of the URL the storage providers fetch a CAR file
+	// from with online deals, {name}, {payload_cid} and {piece_cid} are
+	// replaced. The url or upload_url column of the manifest is used if empty
+	URL string
+	// Boost is the boost client binary, boost if empty
+	Boost string
+}
+
+// BoostDealer is a callback proposing a deal to every provider for every
+// finished piece with the boost client, which signs the proposal with the
+// client wallet and sends it over the boost libp2p deal protocol. The deal
+// UUIDs are recorded in the deals column of the manifest as
+// provider:uuid, separated by spaces.
+type BoostDealer struct {
+	cfg    BoostDealConfig
+	carDir string
+}
+
+// BoostDealCallback has to follow the callback writing the manifest with
+// pieceCID and the uploaders, the CAR has to be served at its URL when an
+// online deal is proposed. The boost binary has to be installed.
+func BoostDealCallback(carDir string, cfg BoostDealConfig) (*BoostDealer, error) {
+	if len(cfg.Providers) == 0 {
+		return nil, fmt.Errorf("no storage providers to make deals with")
+	}
+	if cfg.Duration <= 0 {
+		return nil, fmt.Errorf("deal duration has to be greater than 0")
+	}
+	if cfg.Price == "" {
+		cfg.Price = "0"
+	}
+	if cfg.Boost == "" {
+		cfg.Boost = "boost"
+	}
+	if _, err := exec.LookPath(cfg.Boost); err != nil {
+		return nil, fmt.Errorf("boost client %q not found, install boost or set its path in deals.boost: %w", cfg.Boost, err)
+	}
+	return &BoostDealer{cfg: cfg, carDir: carDir}, nil
+}
+
+func (bd *BoostDealer) OnSuccess(buf *Buffer, slice *GraphSlice) {
+	row, err := findManifestRow(bd.carDir, slice.PayloadCid)
+	if err != nil {
+		log.Fatalf("failed to read manifest: %s", err)
+	}
+	if row == nil || row["piece_cid"] == "" {
+		log.Errorf("no piece cid of %s in the manifest, no deals proposed", slice.PayloadCid)
+		return
+	}
+	rec, err := dealRecord(bd.carDir, row, bd.cfg.URL)
+	if err != nil {
+		log.Errorf("failed to propose deals: %s", err)
+		return
+	}
+	if rec.URL == "" {
+		rec.URL = row["upload_url"]
+	}
+	if !bd.cfg.Offline && rec.URL == "" {
+		log.Errorf("no URL of %s for online deals, set the deal URL template", rec.CarFile)
+		return
+	}
+	var deals []string
+	for _, provider := range bd.cfg.Providers {
+		start := time.Now()
+		uuid, err := bd.propose(provider, rec)
+		if err != nil {
+			// the piece can be proposed again from export-deals
+			log.Errorf("failed to propose a deal of %s to %s: %s", rec.PieceCid, provider, err)
+			continue
+		}
+		log.Infow("deal proposed", slice.logFields("provider", provider, "deal_uuid", uuid, "duration", time.Since(start))...)
+		deals = append(deals, provider+":"+uuid)
+	}
+	if len(deals) == 0 {
+		return
+	}
+	if err := updateManifest(bd.carDir, slice.PayloadCid, map[string]string{"deals": strings.Join(deals, " ")}); err != nil {
+		log.Fatalf("failed to record the deals of %s: %s", rec.PieceCid, err)
+	}
+}
+
+func (bd *BoostDealer) OnError(err error) {
+	log.Fatal(err)
+}
+
+var dealUUIDPattern = regexp.MustCompile(`(?i)deal uuid:\s*(\S+)`)
+
+// propose runs boost deal, or boost offline-deal, and returns the deal UUID.
+func (bd *BoostDealer) propose(provider string, rec DealRecord) (string, error) {
+	args := bd.args(provider, rec)
+	cmd := exec.Command(bd.cfg.Boost, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s %s: %v: %s", bd.cfg.Boost, args[1], err, strings.TrimSpace(stderr.String()))
+	}
+	var out struct {
+		DealUUID string `json:"dealUuid"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &out); err == nil && out.DealUUID != "" {
+		return out.DealUUID, nil
+	}
+	if m := dealUUIDPattern.FindSubmatch(stdout.Bytes()); m != nil {
+		return string(m[1]), nil
+	}
+	return "", fmt.Errorf("no deal uuid in the output of %s: %s", bd.cfg.Boost, strings.TrimSpace(stdout.String()))
+}
+
+func (bd *BoostDealer) args(provider string, rec DealRecord) []string {
+	cmd := "deal"
+	if bd.cfg.Offline {
+		cmd = "offline-deal"
+	}
+	args := []string{
+		"--json", cmd,
+		"--provider=" + provider,
+		"--commp=" + rec.PieceCid,
+		"--piece-size=" + strconv.FormatUint(rec.PieceSize, 10),
+		"--payload-cid=" + rec.PayloadCid,
+		"--duration=" + strconv.Itoa(bd.cfg.Duration),
+		"--storage-price=" + bd.cfg.Price,
+		"--verified=" + strconv.FormatBool(bd.cfg.Verified),
+	}
+	if !bd.cfg.Offline {
+		args = append(args, "--http-url="+rec.URL, "--car-size="+strconv.FormatInt(rec.CarSize, 10))
+	}
+	if bd.cfg.Wallet != "" {
+		args = append(args, "--wallet="+bd.cfg.Wallet)
+	}
+	return args
+}
@@ -0,0 +1,108 @@
+package graphsplit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBoostDeals(t *testing.T) {
+	dir := t.TempDir()
+	manifest := "payload_cid,filename,piece_cid,payload_size,piece_size\nbafy1,a.car,baga1,100,254\n"
+	if err := os.WriteFile(filepath.Join(dir, ManifestFileName), []byte(manifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "baga1.car"), make([]byte, 100), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// a boost client recording its arguments
+	boost := filepath.Join(dir, "boost")
+	script := "#!/bin/sh\necho \"$@\" >> " + filepath.Join(dir, "args") + "\n" +
+		"case \"$*\" in *f0bad*) echo rejected >&2; exit 1;; esac\n" +
+		"echo '{\"dealUuid\": \"uuid-1\"}'\n"
+	if err := os.WriteFile(boost, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	dealer, err := BoostDealCallback(dir, BoostDealConfig{
+		Providers: []string{"f01000", "f0bad"},
+		Duration:  518400,
+		URL:       "https://host/{name}",
+		Boost:     boost,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dealer.OnSuccess(nil, &GraphSlice{PayloadCid: "bafy1"})
+
+	rows, err := ReadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows[0]["deals"] != "f01000:uuid-1" {
+		t.Fatalf("unexpected deals %q", rows[0]["deals"])
+	}
+	args, err := os.ReadFile(filepath.Join(dir, "args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(args), "deal --provider=f01000 --commp=baga1 --piece-size=256 --payload-cid=bafy1") ||
+		!strings.Contains(string(args), "--http-url=https://host/baga1.car --car-size=100") {
+		t.Fatalf("unexpected boost arguments %s", args)
+	}
+}
+
+// fakeBoost writes a boost client script with the given body to dir.
+func fakeBoost(t *testing.T, dir, body string) string {
+	t.Helper()
+	path := filepath.Join(dir, "boost")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBoostPropose(t *testing.T) {
+	dir := t.TempDir()
+	rec := DealRecord{PayloadCid: "bafy1", PieceCid: "baga1", PieceSize: 2048, CarSize: 1500, URL: "https://host/baga1.car"}
+
+	dealer, err := BoostDealCallback(dir, BoostDealConfig{
+		Providers: []string{"f01000"},
+		Duration:  518400,
+		Price:     "100",
+		Wallet:    "f1wallet",
+		Offline:   true,
+		Boost:     fakeBoost(t, dir, "echo 'sent deal proposal'\necho 'deal uuid: 0b5a-offline'\n"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"--json", "offline-deal", "--provider=f01000", "--commp=baga1", "--piece-size=2048", "--payload-cid=bafy1",
+		"--duration=518400", "--storage-price=100", "--verified=false", "--wallet=f1wallet"}
+	if args := dealer.args("f01000", rec); strings.Join(args, " ") != strings.Join(want, " ") {
+		t.Fatalf("unexpected offline deal arguments %v", args)
+	}
+	// the text output of older boost releases
+	if uuid, err := dealer.propose("f01000", rec); err != nil || uuid != "0b5a-offline" {
+		t.Fatalf("expected the uuid of the text output, got %q, %v", uuid, err)
+	}
+
+	dealer.cfg.Offline = false
+	if args := dealer.args("f01000", rec); args[1] != "deal" || !strings.Contains(strings.Join(args, " "), "--http-url=https://host/baga1.car --car-size=1500") {
+		t.Fatalf("unexpected online deal arguments %v", args)
+	}
+
+	dealer.cfg.Boost = fakeBoost(t, dir, "echo 'provider rejected the deal' >&2\nexit 1\n")
+	if _, err := dealer.propose("f01000", rec); err == nil || !strings.Contains(err.Error(), "provider rejected the deal") {
+		t.Fatalf("expected the stderr of boost in the error, got %v", err)
+	}
+	dealer.cfg.Boost = fakeBoost(t, dir, "echo '{}'\n")
+	if _, err := dealer.propose("f01000", rec); err == nil {
+		t.Fatal("expected an error without a deal uuid")
+	}
+
+	if _, err := BoostDealCallback(dir, BoostDealConfig{Providers: []string{"f01000"}, Duration: 1, Boost: filepath.Join(dir, "missing")}); err == nil {
+		t.Fatal("expected an error for a missing boost binary")
+	}
+}
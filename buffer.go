@@ -157,8 +157,8 @@ func growSlice(b []byte, n int) []byte {
 func (b *Buffer) Read(p []byte) (n int, err error) {
 	b.lastRead = opInvalid
 	if b.empty() {
-		// Buffer is empty, reset to recover space.
-		b.Reset()
+		// Unlike bytes.Buffer the contents are kept, so the callbacks of a
+		// slice read them again after SeekStart.
 		if len(p) == 0 {
 			return 0, nil
 		}
@@ -0,0 +1,44 @@
+package graphsplit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCancelRemovesPendingWrites(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &ctxReader{ctx: ctx, r: bytes.NewReader(make([]byte, 100))}
+	if _, err := r.Read(make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, err := io.ReadAll(r); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a canceled read, got %v", err)
+	}
+
+	dir := t.TempDir()
+	done, err := createAtomic(filepath.Join(dir, "done.car"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := done.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := createAtomic(filepath.Join(dir, "pending.car"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pending.Close()
+	RemovePendingWrites()
+	if _, err := os.Stat(pending.Name()); !os.IsNotExist(err) {
+		t.Fatalf("expected %s to be removed", pending.Name())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "done.car")); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,180 @@
+package graphsplit
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// VerifyExtra is the status of a CAR file no manifest row refers to.
+const VerifyExtra VerifyStatus = "extra"
+
+// CarDirResult is the result of checking a manifest row, or a CAR file
+// without one, of a car dir.
+type CarDirResult struct {
+	Path       string       `json:"path,omitempty"`
+	PayloadCid string       `json:"payload_cid,omitempty"`
+	PieceCid   string       `json:"piece_cid,omitempty"`
+	Status     VerifyStatus `json:"status"`
+	// CommPChecked is set when the piece cid was recomputed
+	CommPChecked bool   `json:"commp_checked,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// CarDirReport is the result of VerifyCarDir.
+type CarDirReport struct {
+	Results []CarDirResult `json:"results"`
+}
+
+// Failed returns the results which are not ok.
+func (r *CarDirReport) Failed() []CarDirResult {
+	var failed []CarDirResult
+	for _, res := range r.Results {
+		if res.Status != VerifyOK {
+			failed = append(failed, res)
+		}
+	}
+	return failed
+}
+
+// VerifyCarDir checks that every row of manifest.csv in carDir has its CAR
+// file, in carDir or the car dir of the row, of the size the row records, and
+// reports the CAR files of the car dirs no row refers to. The piece cid of
+// sample rows chosen at random is recomputed and compared, all of them if
+// sample is negative, parallel at a time.
+func VerifyCarDir(ctx context.Context, carDir string, sample, parallel int) (*CarDirReport, error) {
+	rows, err := ReadManifest(carDir)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]CarDirResult, len(rows))
+	dirs := map[string]bool{carDir: true}
+	known := make(map[string]bool)
+	var present []int
+	for i, row := range rows {
+		res := CarDirResult{PayloadCid: row["payload_cid"], PieceCid: row["piece_cid"], Status: VerifyOK}
+		if row["car_dir"] != "" {
+			dirs[row["car_dir"]] = true
+		}
+		res.Path = locateCarExt(carDir, row, compressionExt(row["compression"]))
+		if res.Path == "" {
+			res.Status = VerifyMissing
+			res.Error = "no CAR file for this manifest row"
+		} else {
+			known[res.Path] = true
+			if err := checkCarSize(res.Path, row); err != nil {
+				res.Status = VerifyCorrupt
+				res.Error = err.Error()
+			} else if row["piece_cid"] != "" {
+				present = append(present, i)
+			}
+		}
+		results[i] = res
+	}
+
+	if sample >= 0 && sample < len(present) {
+		rand.Shuffle(len(present), func(i, j int) { present[i], present[j] = present[j], present[i] })
+		present = present[:sample]
+	}
+	var wg sync.WaitGroup
+	limitCh := make(chan struct{}, parallel)
+	for _, i := range present {
+		if ctx.Err() != nil {
+			break
+		}
+		limitCh <- struct{}{}
+		wg.Add(1)
+		go func(res *CarDirResult, row ManifestRow) {
+			defer func() {
+				<-limitCh
+				wg.Done()
+			}()
+			res.CommPChecked = true
+			if err := checkCarCommP(ctx, res.Path, row); err != nil {
+				res.Status = VerifyCorrupt
+				res.Error = err.Error()
+			}
+		}(&results[i], rows[i])
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	extra, err := unlistedCars(dirs, known)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range extra {
+		results = append(results, CarDirResult{Path: p, Status: VerifyExtra, Error: "no manifest row refers to this file"})
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Path < results[j].Path })
+	return &CarDirReport{Results: results}, nil
+}
+
+// unlistedCars returns the CAR files of dirs which are not in known.
+func unlistedCars(dirs, known map[string]bool) ([]string, error) {
+	var extra []string
+	for dir := range dirs {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			continue
+		}
+		cars, err := restorableCars(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range cars {
+			if known[p] || isStitchManifest(p) {
+				continue
+			}
+			known[p] = true
+			extra = append(extra, p)
+		}
+	}
+	sort.Strings(extra)
+	return extra, nil
+}
+
+// checkCarSize compares the size of the uncompressed CAR file at path with
+// the payload size of row, or its unpadded piece size if it was padded.
+// Compressed sizes are not recorded, they are checked with the commP.
+func checkCarSize(path string, row ManifestRow) error {
+	if row["compression"] != "" || row["payload_size"] == "" {
+		return nil
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	payloadSize, err := strconv.ParseInt(row["payload_size"], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid payload_size %q", row["payload_size"])
+	}
+	if fi.Size() == payloadSize || row["piece_size"] == strconv.FormatInt(fi.Size(), 10) {
+		return nil
+	}
+	return fmt.Errorf("file has %d bytes, the manifest records a payload size of %d", fi.Size(), payloadSize)
+}
+
+// checkCarCommP recomputes the piece cid of the CAR file at path and compares
+// it with row.
+func checkCarCommP(ctx context.Context, path string, row ManifestRow) error {
+	res, err := CalcCommP(ctx, path, false, false)
+	if err != nil {
+		return err
+	}
+	if res.Root.String() != row["piece_cid"] {
+		return fmt.Errorf("piece cid is %s", res.Root)
+	}
+	if size := row["piece_size"]; size != "" && size != strconv.FormatUint(uint64(res.Size), 10) {
+		return fmt.Errorf("piece size is %d, the manifest records %s", res.Size, size)
+	}
+	if size := row["car_size"]; size != "" && size != strconv.FormatInt(res.PayloadSize, 10) {
+		return fmt.Errorf("uncompressed size is %d, the manifest records %s", res.PayloadSize, size)
+	}
+	return nil
+}
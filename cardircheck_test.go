@@ -0,0 +1,71 @@
+package graphsplit
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+)
+
+func TestVerifyCarDir(t *testing.T) {
+	dir := t.TempDir()
+	var rows []map[string]string
+	for _, data := range []string{"first", "second", "third"} {
+		blk := blocks.NewBlock(bytes.Repeat([]byte(data), 200))
+		carPath := filepath.Join(dir, blk.Cid().String()+".car")
+		writeTestCar(t, carPath, []blocks.Block{blk})
+		res, err := CalcCommP(context.Background(), carPath, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(carPath, filepath.Join(dir, res.Root.String()+".car")); err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, map[string]string{
+			"payload_cid":  blk.Cid().String(),
+			"piece_cid":    res.Root.String(),
+			"payload_size": strconv.FormatInt(res.PayloadSize, 10),
+			"piece_size":   strconv.FormatUint(uint64(res.Size), 10),
+		})
+	}
+	for _, row := range rows {
+		if err := appendManifest(dir, commPManifestHeader, row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// missing, truncated and extra files
+	os.Remove(filepath.Join(dir, rows[0]["piece_cid"]+".car"))
+	os.Truncate(filepath.Join(dir, rows[1]["piece_cid"]+".car"), 10)
+	writeTestCar(t, filepath.Join(dir, "extra.car"), []blocks.Block{blocks.NewBlock([]byte("extra"))})
+
+	report, err := VerifyCarDir(context.Background(), dir, -1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := make(map[string]VerifyStatus)
+	for _, res := range report.Results {
+		if res.PayloadCid != "" {
+			status[res.PayloadCid] = res.Status
+		} else {
+			status[filepath.Base(res.Path)] = res.Status
+		}
+	}
+	want := map[string]VerifyStatus{
+		rows[0]["payload_cid"]: VerifyMissing,
+		rows[1]["payload_cid"]: VerifyCorrupt,
+		rows[2]["payload_cid"]: VerifyOK,
+		"extra.car":            VerifyExtra,
+	}
+	if len(status) != len(want) {
+		t.Fatalf("unexpected results %+v", report.Results)
+	}
+	for k, v := range want {
+		if status[k] != v {
+			t.Fatalf("%s: expected %s, got %s", k, v, status[k])
+		}
+	}
+}
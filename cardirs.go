@@ -0,0 +1,83 @@
+package graphsplit
+
+import (
+	"fmt"
+	"sync"
+)
+
+// CarDirPolicy decides which directory a CAR file goes to.
+type CarDirPolicy string
+
+const (
+	// CarDirRoundRobin takes turns, skipping directories without room
+	CarDirRoundRobin CarDirPolicy = "round-robin"
+	// CarDirMostFree picks the directory with the most free space
+	CarDirMostFree CarDirPolicy = "free-space"
+)
+
+// CarDirs spreads CAR files across several directories, so an onboarding
+// batch does not have to fit into a single filesystem.
+type CarDirs struct {
+	mu      sync.Mutex
+	dirs    []string
+	policy  CarDirPolicy
+	minFree int64
+	next    int
+}
+
+// NewCarDirs returns a CarDirs for dirs, a directory only gets a CAR file if
+// minFree bytes stay free after writing it.
+func NewCarDirs(dirs []string, policy CarDirPolicy, minFree int64) (*CarDirs, error) {
+	if len(dirs) == 0 {
+		return nil, fmt.Errorf("no car dir given")
+	}
+	for _, dir := range dirs {
+		if !ExistDir(dir) {
+			return nil, fmt.Errorf("car dir %s does not exist", dir)
+		}
+	}
+	switch policy {
+	case "":
+		policy = CarDirRoundRobin
+	case CarDirRoundRobin, CarDirMostFree:
+	default:
+		return nil, fmt.Errorf("unknown car dir policy %q, expect %s or %s", policy, CarDirRoundRobin, CarDirMostFree)
+	}
+	return &CarDirs{dirs: dirs, policy: policy, minFree: minFree}, nil
+}
+
+func (cd *CarDirs) Dirs() []string {
+	return cd.dirs
+}
+
+// Pick returns the directory to write a CAR file of size bytes to.
+func (cd *CarDirs) Pick(size int64) (string, error) {
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+
+	need := spaceNeeded(size, cd.minFree)
+	best := -1
+	var bestFree uint64
+	for i := range cd.dirs {
+		idx := (cd.next + i) % len(cd.dirs)
+		ok, free, err := hasRoom(cd.dirs[idx], need)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			continue
+		}
+		if cd.policy == CarDirRoundRobin {
+			best = idx
+			break
+		}
+		if best < 0 || free > bestFree {
+			best, bestFree = idx, free
+		}
+	}
+	if best < 0 {
+		return "", fmt.Errorf("%w in %v for a CAR of %d bytes", ErrInsufficientSpace, cd.dirs, size)
+	}
+	cd.next = best + 1
+	return cd.dirs[best], nil
+}
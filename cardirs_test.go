@@ -0,0 +1,94 @@
+package graphsplit
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCarDirsPick(t *testing.T) {
+	a, b, c := t.TempDir(), t.TempDir(), t.TempDir()
+	free := map[string]uint64{a: 10 << 20, b: 30 << 20, c: 20 << 20}
+	statFreeSpace = func(path string) (uint64, bool, error) {
+		return free[path], true, nil
+	}
+	defer func() { statFreeSpace = freeSpace }()
+
+	if _, err := NewCarDirs(nil, "", 0); err == nil {
+		t.Fatal("expected an error without car dirs")
+	}
+	if _, err := NewCarDirs([]string{filepath.Join(a, "missing")}, "", 0); err == nil {
+		t.Fatal("expected an error for a missing car dir")
+	}
+	if _, err := NewCarDirs([]string{a}, "random", 0); err == nil {
+		t.Fatal("expected an error for an unknown policy")
+	}
+
+	pick := func(cd *CarDirs, size int64) string {
+		t.Helper()
+		dir, err := cd.Pick(size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+
+	rr, err := NewCarDirs([]string{a, b, c}, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{a, b, c, a} {
+		if got := pick(rr, 1<<20); got != want {
+			t.Fatalf("round-robin pick %d: expected %s, got %s", i, want, got)
+		}
+	}
+	// a has no room for 15MiB, so it is skipped
+	if got := pick(rr, 15<<20); got != b {
+		t.Fatalf("expected the full dir to be skipped for %s, got %s", b, got)
+	}
+
+	mf, err := NewCarDirs([]string{a, b, c}, CarDirMostFree, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := pick(mf, 1<<20); got != b {
+		t.Fatalf("expected the most free dir %s, got %s", b, got)
+	}
+	free[b] = 5 << 20
+	if got := pick(mf, 1<<20); got != c {
+		t.Fatalf("expected the most free dir %s, got %s", c, got)
+	}
+
+	if _, err := mf.Pick(1 << 30); !errors.Is(err, ErrInsufficientSpace) {
+		t.Fatalf("expected insufficient space, got %v", err)
+	}
+}
+
+func TestChunkWithCarDirs(t *testing.T) {
+	dir := writeTestTree(t, 40<<10, 50<<10, 60<<10, 70<<10)
+	a, b := t.TempDir(), t.TempDir()
+	cd, err := NewCarDirs([]string{a, b}, CarDirRoundRobin, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	carDir := t.TempDir()
+	rows := chunkTestTree(t, dir, &ChunkParams{
+		ExpectSliceSize: 64 << 10,
+		CarDir:          carDir,
+		Cb:              CSVCallback(carDir, WithCarDirs(cd)),
+	})
+	if len(rows) < 2 {
+		t.Fatalf("expected at least 2 slices, got %d", len(rows))
+	}
+	used := make(map[string]int)
+	for _, row := range rows {
+		if _, err := os.Stat(filepath.Join(row["car_dir"], row["payload_cid"]+".car")); err != nil {
+			t.Fatalf("CAR file is not in its car dir: %s", err)
+		}
+		used[row["car_dir"]]++
+	}
+	if used[a] == 0 || used[b] == 0 || used[a]+used[b] != len(rows) {
+		t.Fatalf("expected the CAR files to be spread across both dirs, got %v", used)
+	}
+}
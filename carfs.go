@@ -0,0 +1,374 @@
+package graphsplit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/ipfs/go-unixfs"
+)
+
+// CarFS is the directory tree a restore of a set of CAR files writes, as a
+// read only fs.FS. Blocks are read from the CAR files when directories are
+// listed and files are read, the parts of split files are joined into one
+// file. Uncompressed CAR files are indexed, compressed ones and stitch
+// manifests are loaded into memory.
+type CarFS struct {
+	cars []*carBlocks
+	root *carDir
+	// mu guards the lazy loading of directories
+	mu sync.Mutex
+}
+
+// carRef is a DAG node in one of the CAR files.
+type carRef struct {
+	ps  *pathSelector
+	cid cid.Cid
+}
+
+// carDir is a directory merged from the directories at its path in all CAR
+// files.
+type carDir struct {
+	srcs    []carRef
+	loaded  bool
+	entries map[string]*carEntry
+}
+
+// carEntry is a directory or a file, made of the parts of a split file in
+// order.
+type carEntry struct {
+	name  string
+	dir   *carDir
+	parts []carRef
+	// sizes are the sizes of the parts
+	sizes []int64
+	size  int64
+	// indexes are the part numbers of split files, -1 for unsplit ones
+	indexes []int
+}
+
+// OpenCarFS opens the CAR files and stitch manifests below carPath.
+func OpenCarFS(ctx context.Context, carPath string, opts ...RestoreOption) (*CarFS, error) {
+	o := newRestoreOptions(opts)
+	cars, err := restorableCars(carPath)
+	if err != nil {
+		return nil, err
+	}
+	cfs := &CarFS{root: &carDir{}}
+	for _, p := range cars {
+		bs := bstore.NewBlockstore(dss.MutexWrap(datastore.NewMapDatastore()))
+		cb, err := openCarBlocks(ctx, p, bs, o.readLimiter)
+		if err != nil {
+			cfs.Close()
+			return nil, fmt.Errorf("failed to open %s: %w", p, err)
+		}
+		cfs.cars = append(cfs.cars, cb)
+		cfs.root.srcs = append(cfs.root.srcs, carRef{ps: &pathSelector{ctx: ctx, bs: bs, get: cb.get}, cid: cb.root})
+	}
+	if len(cfs.cars) == 0 {
+		return nil, fmt.Errorf("no CAR files in %s", carPath)
+	}
+	return cfs, nil
+}
+
+func (cfs *CarFS) Close() error {
+	var firstErr error
+	for _, cb := range cfs.cars {
+		if err := cb.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// load reads the entries of the directory d from all its CAR files.
+func (cfs *CarFS) load(d *carDir) error {
+	if d.loaded {
+		return nil
+	}
+	entries := make(map[string]*carEntry)
+	for _, src := range d.srcs {
+		nd, _, err := src.ps.node(src.cid)
+		if err != nil {
+			return err
+		}
+		for _, ln := range nd.Links() {
+			name, index := ln.Name, -1
+			if splitPartName.MatchString(name) {
+				index, _ = strconv.Atoi(name[len(name)-8:])
+				name = name[:len(name)-9]
+			}
+			ref := carRef{ps: src.ps, cid: ln.Cid}
+			child, data, err := ref.ps.node(ln.Cid)
+			if err != nil {
+				return err
+			}
+			size := int64(len(data))
+			if child != nil {
+				fsn, err := unixfs.FSNodeFromBytes(child.Data())
+				if err != nil {
+					return err
+				}
+				if fsn.IsDir() && index < 0 {
+					e := entries[name]
+					if e == nil {
+						e = &carEntry{name: name, dir: &carDir{}}
+						entries[name] = e
+					}
+					if e.dir != nil {
+						e.dir.srcs = append(e.dir.srcs, ref)
+					}
+					continue
+				}
+				size = int64(fsn.FileSize())
+			}
+			e := entries[name]
+			if e == nil {
+				e = &carEntry{name: name}
+				entries[name] = e
+			}
+			// the same file in several CAR files is listed once
+			if e.dir != nil || index < 0 && len(e.parts) > 0 {
+				continue
+			}
+			e.parts = append(e.parts, ref)
+			e.sizes = append(e.sizes, size)
+			e.indexes = append(e.indexes, index)
+		}
+	}
+	for _, e := range entries {
+		if e.dir == nil {
+			sort.Sort(byPart{e})
+			for _, s := range e.sizes {
+				e.size += s
+			}
+		}
+	}
+	d.entries = entries
+	d.loaded = true
+	return nil
+}
+
+type byPart struct{ e *carEntry }
+
+func (b byPart) Len() int           { return len(b.e.parts) }
+func (b byPart) Less(i, j int) bool { return b.e.indexes[i] < b.e.indexes[j] }
+func (b byPart) Swap(i, j int) {
+	b.e.parts[i], b.e.parts[j] = b.e.parts[j], b.e.parts[i]
+	b.e.sizes[i], b.e.sizes[j] = b.e.sizes[j], b.e.sizes[i]
+	b.e.indexes[i], b.e.indexes[j] = b.e.indexes[j], b.e.indexes[i]
+}
+
+// lookup returns the entry at name, a path valid for fs.FS.
+func (cfs *CarFS) lookup(name string) (*carEntry, error) {
+	cfs.mu.Lock()
+	defer cfs.mu.Unlock()
+	e := &carEntry{name: ".", dir: cfs.root}
+	if name == "." {
+		return e, nil
+	}
+	for _, seg := range strings.Split(name, "/") {
+		if e.dir == nil {
+			return nil, fs.ErrNotExist
+		}
+		if err := cfs.load(e.dir); err != nil {
+			return nil, err
+		}
+		if e = e.dir.entries[seg]; e == nil {
+			return nil, fs.ErrNotExist
+		}
+	}
+	return e, nil
+}
+
+func (cfs *CarFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	e, err := cfs.lookup(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if e.dir != nil {
+		cfs.mu.Lock()
+		err := cfs.load(e.dir)
+		var entries []fs.DirEntry
+		for _, child := range e.dir.entries {
+			entries = append(entries, fs.FileInfoToDirEntry(child.info()))
+		}
+		cfs.mu.Unlock()
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+		return &carDirFile{e: e, entries: entries}, nil
+	}
+	return &carFile{e: e}, nil
+}
+
+// carFileInfo is the fs.FileInfo of an entry, the modification time is not
+// kept in CAR files.
+type carFileInfo struct {
+	e *carEntry
+}
+
+func (e *carEntry) info() carFileInfo { return carFileInfo{e} }
+
+func (fi carFileInfo) Name() string       { return path.Base(fi.e.name) }
+func (fi carFileInfo) Size() int64        { return fi.e.size }
+func (fi carFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi carFileInfo) IsDir() bool        { return fi.e.dir != nil }
+func (fi carFileInfo) Sys() interface{}   { return nil }
+func (fi carFileInfo) Mode() fs.FileMode {
+	if fi.IsDir() {
+		return fs.ModeDir | 0o555
+	}
+	return 0o444
+}
+
+type carDirFile struct {
+	e       *carEntry
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *carDirFile) Stat() (fs.FileInfo, error) { return d.e.info(), nil }
+func (d *carDirFile) Close() error               { return nil }
+func (d *carDirFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.e.name, Err: fs.ErrInvalid}
+}
+
+func (d *carDirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.offset += n
+	return rest[:n], nil
+}
+
+// carFile reads a file from its blocks, it implements io.ReaderAt and
+// io.Seeker.
+type carFile struct {
+	e      *carEntry
+	offset int64
+}
+
+func (f *carFile) Stat() (fs.FileInfo, error) { return f.e.info(), nil }
+func (f *carFile) Close() error               { return nil }
+
+func (f *carFile) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *carFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.e.size
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.e.name, Err: fs.ErrInvalid}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *carFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= f.e.size {
+		return 0, io.EOF
+	}
+	total := 0
+	var start int64
+	for i, part := range f.e.parts {
+		end := start + f.e.sizes[i]
+		if off < end && total < len(p) {
+			want := int(min(int64(len(p)-total), end-off))
+			n, err := readNodeAt(part.ps, part.cid, p[total:total+want], off-start)
+			total += n
+			off += int64(n)
+			if err != nil {
+				return total, err
+			}
+			if n < want {
+				return total, io.ErrUnexpectedEOF
+			}
+		}
+		start = end
+	}
+	if total < len(p) {
+		return total, io.EOF
+	}
+	return total, nil
+}
+
+// readNodeAt reads the data of the file c at off into p, only the blocks
+// holding the range are read.
+func readNodeAt(ps *pathSelector, c cid.Cid, p []byte, off int64) (int, error) {
+	nd, data, err := ps.node(c)
+	if err != nil {
+		return 0, err
+	}
+	if nd == nil {
+		if off >= int64(len(data)) {
+			return 0, nil
+		}
+		return copy(p, data[off:]), nil
+	}
+	fsn, err := unixfs.FSNodeFromBytes(nd.Data())
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	var start int64
+	if inline := int64(len(fsn.Data())); inline > 0 {
+		if off < inline {
+			total = copy(p, fsn.Data()[off:])
+		}
+		start = inline
+	}
+	for i, ln := range nd.Links() {
+		end := start + int64(fsn.BlockSize(i))
+		pos := off + int64(total)
+		if total < len(p) && pos < end {
+			want := int(min(int64(len(p)-total), end-pos))
+			n, err := readNodeAt(ps, ln.Cid, p[total:total+want], pos-start)
+			total += n
+			if err != nil {
+				return total, err
+			}
+			if n < want {
+				return total, io.ErrUnexpectedEOF
+			}
+		}
+		if total == len(p) {
+			break
+		}
+		start = end
+	}
+	return total, nil
+}
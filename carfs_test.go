@@ -0,0 +1,41 @@
+package graphsplit
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	blocks "github.com/ipfs/go-block-format"
+	dag "github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+)
+
+func TestCarFS(t *testing.T) {
+	dir := t.TempDir()
+	for i, data := range []string{"first part, ", "second part"} {
+		part := dag.NodeWithData(unixfs.FilePBData([]byte(data), uint64(len(data))))
+		other := dag.NodeWithData(unixfs.FilePBData([]byte(fmt.Sprint("file ", i)), 6))
+		sub := unixfs.EmptyDirNode()
+		sub.AddNodeLink("big.bin."+fmt.Sprintf("%08d", i), part)
+		sub.AddNodeLink(fmt.Sprintf("f%d.txt", i), other)
+		root := unixfs.EmptyDirNode()
+		root.AddNodeLink("sub", sub)
+		writeTestCar(t, filepath.Join(dir, fmt.Sprintf("%d.car", i)), []blocks.Block{root, sub, part, other})
+	}
+
+	cfs, err := OpenCarFS(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cfs.Close()
+	if err := fstest.TestFS(cfs, "sub/big.bin", "sub/f0.txt", "sub/f1.txt"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := fs.ReadFile(cfs, "sub/big.bin")
+	if err != nil || string(data) != "first part, second part" {
+		t.Fatalf("unexpected content %q, %v", data, err)
+	}
+}
@@ -0,0 +1,118 @@
+package graphsplit
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	carNamePlaceholder = regexp.MustCompile(`\{([a-z_]+)(?::([^}]*))?\}`)
+	carNameFormat      = regexp.MustCompile(`^[-+# 0]*[0-9]*(\.[0-9]+)?[a-zA-Z]$`)
+)
+
+// carNameValues are sample values of the placeholders of a CAR name
+// template, the template is checked by expanding them.
+var carNameValues = map[string]interface{}{
+	"graph":       "graph",
+	"index":       1,
+	"payload_cid": "bafy",
+	"piece_cid":   "baga",
+	"piece_size":  uint64(2048),
+}
+
+// CarNameTemplate names CAR files after the slice they hold. {graph} is the
+// graph name without .car, {index} the number of the slice from 1,
+// {payload_cid}, {piece_cid} and {piece_size} the cids and the size of the
+// piece like in the manifest. A placeholder takes a printf format after a
+// colon, e.g. "{graph}-{index:05d}-{payload_cid}.car".
+type CarNameTemplate struct {
+	template      string
+	needsPieceCid bool
+}
+
+// ParseCarNameTemplate checks the placeholders and formats of template.
+func ParseCarNameTemplate(template string) (*CarNameTemplate, error) {
+	if template == "" {
+		return nil, fmt.Errorf("car name template is empty")
+	}
+	if strings.ContainsAny(template, `/\`) {
+		return nil, fmt.Errorf("car name template %q must not contain a path separator", template)
+	}
+	t := &CarNameTemplate{template: template}
+	for _, m := range carNamePlaceholder.FindAllStringSubmatch(template, -1) {
+		v, ok := carNameValues[m[1]]
+		if !ok {
+			return nil, fmt.Errorf("unknown placeholder {%s} in car name template, use graph, index, payload_cid, piece_cid or piece_size", m[1])
+		}
+		if m[2] != "" {
+			if !carNameFormat.MatchString(m[2]) {
+				return nil, fmt.Errorf("invalid format %q of {%s}", m[2], m[1])
+			}
+			if s := fmt.Sprintf("%"+m[2], v); strings.HasPrefix(s, "%!") {
+				return nil, fmt.Errorf("format %q does not apply to {%s}", m[2], m[1])
+			}
+		}
+		if m[1] == "piece_cid" || m[1] == "piece_size" {
+			t.needsPieceCid = true
+		}
+	}
+	if strings.ContainsAny(carNamePlaceholder.ReplaceAllString(template, ""), "{}") {
+		return nil, fmt.Errorf("unbalanced braces in car name template %q", template)
+	}
+	return t, nil
+}
+
+// NeedsPieceCid reports whether the template uses {piece_cid} or
+// {piece_size}, which are only known when the pieceCID is calculated.
+func (t *CarNameTemplate) NeedsPieceCid() bool {
+	return t.needsPieceCid
+}
+
+func (t *CarNameTemplate) String() string {
+	return t.template
+}
+
+// Expand returns the CAR name of slice, pieceCid is empty and pieceSize 0
+// if the pieceCID is not calculated.
+func (t *CarNameTemplate) Expand(slice *GraphSlice, pieceCid string, pieceSize uint64) string {
+	values := map[string]interface{}{
+		"graph":       strings.TrimSuffix(slice.Name, ".car"),
+		"index":       slice.Index,
+		"payload_cid": slice.PayloadCid,
+		"piece_cid":   pieceCid,
+		"piece_size":  pieceSize,
+	}
+	return carNamePlaceholder.ReplaceAllStringFunc(t.template, func(p string) string {
+		m := carNamePlaceholder.FindStringSubmatch(p)
+		v := values[m[1]]
+		if m[2] != "" {
+			return fmt.Sprintf("%"+m[2], v)
+		}
+		switch v := v.(type) {
+		case int:
+			return strconv.Itoa(v)
+		case uint64:
+			return strconv.FormatUint(v, 10)
+		}
+		return v.(string)
+	})
+}
+
+// WithCarNameTemplate names the CAR files after t instead of their piece or
+// payload cid.
+func WithCarNameTemplate(t *CarNameTemplate) CallbackOption {
+	return func(o *callbackOptions) {
+		o.carName = t
+	}
+}
+
+// carFileName returns the templated name of the CAR of slice, empty without
+// a template.
+func (o *callbackOptions) carFileName(slice *GraphSlice, pieceCid string, pieceSize uint64) string {
+	if o.carName == nil {
+		return ""
+	}
+	return o.carName.Expand(slice, pieceCid, pieceSize)
+}
@@ -0,0 +1,33 @@
+package graphsplit
+
+import (
+	"testing"
+)
+
+func TestCarNameTemplate(t *testing.T) {
+	tmpl, err := ParseCarNameTemplate("{graph}-{index:05d}-{payload_cid}.car")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tmpl.NeedsPieceCid() {
+		t.Fatal("template does not use the piece cid")
+	}
+	slice := &GraphSlice{Name: "data-total-3-part-2.car", PayloadCid: "bafytest", Index: 2}
+	if name := tmpl.Expand(slice, "", 0); name != "data-total-3-part-2-00002-bafytest.car" {
+		t.Fatalf("unexpected name %s", name)
+	}
+
+	tmpl, err = ParseCarNameTemplate("{piece_cid}_{piece_size}.car")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tmpl.NeedsPieceCid() || tmpl.Expand(slice, "baga", 2032) != "baga_2032.car" {
+		t.Fatalf("unexpected name %s", tmpl.Expand(slice, "baga", 2032))
+	}
+
+	for _, bad := range []string{"", "{name}.car", "{index:05s}.car", "{payload_cid:d}.car", "dir/{payload_cid}.car", "{payload_cid.car"} {
+		if _, err := ParseCarNameTemplate(bad); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
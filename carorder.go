@@ -0,0 +1,163 @@
+package graphsplit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+)
+
+// BlockOrder is the order blocks are written to a CAR file.
+type BlockOrder string
+
+const (
+	// BlockOrderDFS writes blocks in depth-first traversal order from the root
+	BlockOrderDFS BlockOrder = "dfs"
+	// BlockOrderStream writes blocks in the order they were built
+	BlockOrderStream BlockOrder = "stream"
+)
+
+// ParseBlockOrder parses a block order name, empty means BlockOrderDFS.
+func ParseBlockOrder(s string) (BlockOrder, error) {
+	switch BlockOrder(s) {
+	case "", BlockOrderDFS:
+		return BlockOrderDFS, nil
+	case BlockOrderStream:
+		return BlockOrderStream, nil
+	}
+	return "", fmt.Errorf("unknown block order %q, expect %s or %s", s, BlockOrderDFS, BlockOrderStream)
+}
+
+// CarMetaExt is the extension of the metadata sidecar written next to every
+// CAR file, <car>.meta.json.
+const CarMetaExt = ".meta.json"
+
+// CarMeta is the metadata sidecar of a CAR file, it tells retrieval servers
+// and verifiers the order of its blocks without reading the manifest.
+type CarMeta struct {
+	PayloadCid  string     `json:"payload_cid"`
+	PieceCid    string     `json:"piece_cid,omitempty"`
+	BlockOrder  BlockOrder `json:"block_order"`
+	Compression string     `json:"compression,omitempty"`
+}
+
+// writeCarMeta atomically writes the metadata sidecar of the CAR file at
+// carPath.
+func writeCarMeta(carPath string, meta CarMeta) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(carPath+CarMetaExt, append(data, '\n'))
+}
+
+// ReadCarMeta reads the metadata sidecar of the CAR file at carPath.
+func ReadCarMeta(carPath string) (CarMeta, error) {
+	var meta CarMeta
+	data, err := os.ReadFile(carPath + CarMetaExt)
+	if err != nil {
+		return meta, err
+	}
+	err = json.Unmarshal(data, &meta)
+	return meta, err
+}
+
+// isCarMeta reports whether path is the metadata sidecar of a CAR file.
+func isCarMeta(path string) bool {
+	return strings.HasSuffix(path, CarMetaExt)
+}
+
+// orderedBlockstore remembers the order blocks were first put in.
+type orderedBlockstore struct {
+	bstore.Blockstore
+	mu    sync.Mutex
+	order []cid.Cid
+	seen  map[cid.Cid]struct{}
+}
+
+func newOrderedBlockstore(bs bstore.Blockstore) *orderedBlockstore {
+	return &orderedBlockstore{Blockstore: bs, seen: make(map[cid.Cid]struct{})}
+}
+
+func (obs *orderedBlockstore) Put(ctx context.Context, blk blocks.Block) error {
+	if err := obs.Blockstore.Put(ctx, blk); err != nil {
+		return err
+	}
+	obs.record(blk)
+	return nil
+}
+
+func (obs *orderedBlockstore) PutMany(ctx context.Context, blks []blocks.Block) error {
+	if err := obs.Blockstore.PutMany(ctx, blks); err != nil {
+		return err
+	}
+	for _, blk := range blks {
+		obs.record(blk)
+	}
+	return nil
+}
+
+func (obs *orderedBlockstore) record(blk blocks.Block) {
+	obs.mu.Lock()
+	defer obs.mu.Unlock()
+	if _, ok := obs.seen[blk.Cid()]; ok {
+		return
+	}
+	obs.seen[blk.Cid()] = struct{}{}
+	obs.order = append(obs.order, blk.Cid())
+}
+
+// writeCar writes a CARv1 of every block put in obs, in the order they were
+// put. If keep is not nil only the blocks in it are written.
+func (obs *orderedBlockstore) writeCar(ctx context.Context, root cid.Cid, w io.Writer, keep map[cid.Cid]struct{}) error {
+	if err := car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{root}, Version: 1}, w); err != nil {
+		return err
+	}
+	obs.mu.Lock()
+	defer obs.mu.Unlock()
+	for _, c := range obs.order {
+		if _, ok := keep[c]; keep != nil && !ok {
+			continue
+		}
+		blk, err := obs.Blockstore.Get(ctx, c)
+		if err != nil {
+			return err
+		}
+		if err := carutil.LdWrite(w, c.Bytes(), blk.RawData()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reachableBlocks returns the cids of the blocks linked from root.
+func reachableBlocks(ctx context.Context, ds ipld.NodeGetter, root cid.Cid) (map[cid.Cid]struct{}, error) {
+	seen := make(map[cid.Cid]struct{})
+	queue := []cid.Cid{root}
+	for len(queue) > 0 {
+		c := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		if _, ok := seen[c]; ok {
+			continue
+		}
+		seen[c] = struct{}{}
+		nd, err := ds.Get(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range nd.Links() {
+			queue = append(queue, l.Cid)
+		}
+	}
+	return seen, nil
+}
@@ -0,0 +1,60 @@
+package graphsplit
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipld/go-car"
+)
+
+func TestCarMetaSidecar(t *testing.T) {
+	dir := writeTestTree(t, 30<<10, 40<<10, 50<<10)
+	carDir := t.TempDir()
+	rows := chunkTestTree(t, dir, &ChunkParams{
+		ExpectSliceSize: 64 << 10,
+		Parallel:        2,
+		CarDir:          carDir,
+		Cb:              CommPCallback(carDir, false, false),
+		BlockOrder:      BlockOrderStream,
+	})
+	if len(rows) == 0 {
+		t.Fatal("expected manifest rows")
+	}
+	for _, row := range rows {
+		carPath := filepath.Join(carDir, row["piece_cid"]+".car")
+		meta, err := ReadCarMeta(carPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if meta.BlockOrder != BlockOrderStream || meta.PayloadCid != row["payload_cid"] || meta.PieceCid != row["piece_cid"] {
+			t.Fatalf("unexpected metadata %+v for row %v", meta, row)
+		}
+
+		f, err := os.Open(carPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, err := car.ReadHeader(bufio.NewReader(f))
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(header.Roots) != 1 || header.Roots[0].String() != row["payload_cid"] {
+			t.Fatalf("unexpected roots %v of %s", header.Roots, carPath)
+		}
+	}
+
+	carPath := filepath.Join(carDir, rows[0]["piece_cid"]+".car")
+	if err := os.Remove(carPath); err != nil {
+		t.Fatal(err)
+	}
+	removed, err := CleanCarDir(carDir, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != carPath+CarMetaExt {
+		t.Fatalf("expected the sidecar of the removed CAR to be cleaned, got %v", removed)
+	}
+}
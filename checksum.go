@@ -0,0 +1,113 @@
+package graphsplit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"lukechampine.com/blake3"
+)
+
+// ChecksumAlgo names a digest computed over produced CAR files.
+type ChecksumAlgo string
+
+const (
+	ChecksumSha256 ChecksumAlgo = "sha256"
+	ChecksumBlake3 ChecksumAlgo = "blake3"
+)
+
+// ParseChecksums parses a list of checksum algorithms, each is given at most
+// once.
+func ParseChecksums(names []string) ([]ChecksumAlgo, error) {
+	var algos []ChecksumAlgo
+	for _, name := range names {
+		algo := ChecksumAlgo(strings.ToLower(strings.TrimSpace(name)))
+		switch algo {
+		case ChecksumSha256, ChecksumBlake3:
+		default:
+			return nil, fmt.Errorf("unknown checksum %q, expected sha256 or blake3", name)
+		}
+		if !containsAlgo(algos, algo) {
+			algos = append(algos, algo)
+		}
+	}
+	return algos, nil
+}
+
+func containsAlgo(algos []ChecksumAlgo, algo ChecksumAlgo) bool {
+	for _, a := range algos {
+		if a == algo {
+			return true
+		}
+	}
+	return false
+}
+
+// isChecksumSidecar reports whether path is a sidecar written next to a CAR.
+func isChecksumSidecar(path string) bool {
+	ext := filepath.Ext(path)
+	return ext == "."+string(ChecksumSha256) || ext == "."+string(ChecksumBlake3)
+}
+
+// carChecksums hashes a CAR file while it is written.
+type carChecksums struct {
+	algos  []ChecksumAlgo
+	hashes []hash.Hash
+}
+
+func newCarChecksums(algos []ChecksumAlgo) *carChecksums {
+	cs := &carChecksums{algos: algos}
+	for _, algo := range algos {
+		switch algo {
+		case ChecksumSha256:
+			cs.hashes = append(cs.hashes, sha256.New())
+		case ChecksumBlake3:
+			cs.hashes = append(cs.hashes, blake3.New(32, nil))
+		}
+	}
+	return cs
+}
+
+// Writer tees w into the hashes.
+func (cs *carChecksums) Writer(w io.Writer) io.Writer {
+	if len(cs.hashes) == 0 {
+		return w
+	}
+	writers := []io.Writer{w}
+	for _, h := range cs.hashes {
+		writers = append(writers, h)
+	}
+	return io.MultiWriter(writers...)
+}
+
+// Columns returns the hex digests by manifest column.
+func (cs *carChecksums) Columns() map[string]string {
+	cols := make(map[string]string, len(cs.algos))
+	for i, algo := range cs.algos {
+		cols[string(algo)] = hex.EncodeToString(cs.hashes[i].Sum(nil))
+	}
+	return cols
+}
+
+// WriteSidecars writes <car>.<algo> files in the format of sha256sum, so
+// they can be checked with `sha256sum -c` after a transfer.
+func (cs *carChecksums) WriteSidecars(carPath string) error {
+	for algo, sum := range cs.Columns() {
+		f, err := createAtomic(carPath + "." + algo)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(f, "%s  %s\n", sum, filepath.Base(carPath)); err != nil {
+			f.Abort()
+			return err
+		}
+		if err := f.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package graphsplit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCarChecksumSidecars(t *testing.T) {
+	algos, err := ParseChecksums([]string{"sha256", "BLAKE3", "sha256"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(algos) != 2 {
+		t.Fatalf("expected duplicates to be dropped, got %v", algos)
+	}
+	if _, err := ParseChecksums([]string{"md5"}); err == nil {
+		t.Fatal("expected an error for an unknown checksum")
+	}
+
+	data := []byte("car file content")
+	carPath := filepath.Join(t.TempDir(), "piece.car")
+	sums := newCarChecksums(algos)
+	if _, err := io.WriteString(sums.Writer(io.Discard), string(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := sums.WriteSidecars(carPath); err != nil {
+		t.Fatal(err)
+	}
+
+	want := sha256.Sum256(data)
+	sidecar, err := os.ReadFile(carPath + ".sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(sidecar) != hex.EncodeToString(want[:])+"  piece.car\n" {
+		t.Fatalf("unexpected sidecar %q", sidecar)
+	}
+	if blake, err := os.ReadFile(carPath + ".blake3"); err != nil || !strings.HasSuffix(string(blake), "  piece.car\n") {
+		t.Fatalf("unexpected blake3 sidecar %q: %v", blake, err)
+	}
+	if !isChecksumSidecar(carPath+".sha256") || isChecksumSidecar(carPath) {
+		t.Fatal("sidecar detection is wrong")
+	}
+}
@@ -2,12 +2,14 @@ package graphsplit
 
 import (
 	"context"
-	"encoding/csv"
+	"encoding/json"
 	"fmt"
 	"io"
+	"math/rand"
 	"os"
-	"path"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strconv"
 	"time"
 
@@ -16,83 +18,484 @@ import (
 
 var log = logging.Logger("graphsplit")
 
+// GraphSlice describes a built graph slice handed to GraphBuildCallback.
+type GraphSlice struct {
+	Name       string
+	PayloadCid string
+	FsDetail   string
+	// SliceSize is the target size picked for this slice
+	SliceSize  int64
+	BlockOrder BlockOrder
+	// Encryption identifies the cipher and key of encrypted file data
+	Encryption string
+	// Sources are the locations of files read from a Source with a Locator
+	Sources []string
+	// RootPath is the directory the root of the DAG stands for, absolute for
+	// local files
+	RootPath string
+	// Files are the byte ranges of the files in the slice
+	Files []SliceFile
+	// Started is when building the slice began
+	Started time.Time
+	// Index is the number of the slice in the run, from 1
+	Index int
+
+	ctx context.Context
+}
+
+// context returns the context of the slice, which holds its trace span.
+func (slice *GraphSlice) context() context.Context {
+	if slice.ctx == nil {
+		return context.Background()
+	}
+	return slice.ctx
+}
+
+// logFields are the key value pairs identifying the slice in structured logs.
+func (slice *GraphSlice) logFields(kv ...interface{}) []interface{} {
+	return append([]interface{}{"graph", slice.Name, "slice", slice.Index, "payload_cid", slice.PayloadCid}, kv...)
+}
+
+// SliceFile is the byte range of a file held by a graph slice.
+type SliceFile struct {
+	Path     string `json:"path"`
+	FileSize int64  `json:"file_size,omitempty"`
+	Offset   int64  `json:"offset"`
+	Size     int64  `json:"size"`
+	// Cid is the cid of the file node of the range
+	Cid string `json:"cid,omitempty"`
+}
+
+// sources returns the manifest column of the source locations.
+func (slice *GraphSlice) sources() string {
+	if len(slice.Sources) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(slice.Sources)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return string(data)
+}
+
+func (slice *GraphSlice) blockOrder() string {
+	if slice.BlockOrder == "" {
+		return string(BlockOrderDFS)
+	}
+	return string(slice.BlockOrder)
+}
+
+// GraphBuildCallback is called with the CAR of every graph slice built by
+// Chunk. OnSuccess took the graph name, payload cid and fs detail before it
+// took a *GraphSlice, wrap callbacks written for it with AdaptLegacyCallback.
 type GraphBuildCallback interface {
+	OnSuccess(buf *Buffer, slice *GraphSlice)
+	OnError(error)
+}
+
+// LegacyGraphBuildCallback is the GraphBuildCallback of earlier releases.
+type LegacyGraphBuildCallback interface {
 	OnSuccess(buf *Buffer, graphName, payloadCid, fsDetail string)
 	OnError(error)
 }
 
+type legacyCallback struct {
+	LegacyGraphBuildCallback
+}
+
+// AdaptLegacyCallback turns a callback implementing the OnSuccess signature
+// of earlier releases into a GraphBuildCallback.
+func AdaptLegacyCallback(cb LegacyGraphBuildCallback) GraphBuildCallback {
+	return legacyCallback{cb}
+}
+
+func (lc legacyCallback) OnSuccess(buf *Buffer, slice *GraphSlice) {
+	lc.LegacyGraphBuildCallback.OnSuccess(buf, slice.Name, slice.PayloadCid, slice.FsDetail)
+}
+
+// CallbackOption customizes the built-in callbacks.
+type CallbackOption func(*callbackOptions)
+
+type callbackOptions struct {
+	writeLimiter *RateLimiter
+	batches      *BatchStore
+	commPWorkers int
+	carDirs      *CarDirs
+	pieceHook    string
+	checksums    []ChecksumAlgo
+	compression  string
+	pieceFiles   bool
+	manifestVer  int
+	manifestURL  string
+	// manifestFormat is the format of new manifests
+	manifestFormat ManifestFormat
+	manifestDB     *ManifestDB
+	provenance     ManifestFormat
+	carName        *CarNameTemplate
+	shardWidth     int
+	shardKey       ShardKey
+	keepTmp        bool
+	retry          RetryPolicy
+}
+
+// WithWriteRate throttles CAR writes to bytesPerSec, 0 means no limit.
+func WithWriteRate(bytesPerSec int64) CallbackOption {
+	return func(o *callbackOptions) {
+		o.writeLimiter = NewRateLimiter(bytesPerSec)
+	}
+}
+
+// WithBatches assigns every produced piece to the open batch of bs and
+// records the batch id in the manifest.
+func WithBatches(bs *BatchStore) CallbackOption {
+	return func(o *callbackOptions) {
+		o.batches = bs
+	}
+}
+
+// addToBatch returns the batch id of the piece, empty if batches are not used.
+func (o *callbackOptions) addToBatch(piece string) string {
+	if o.batches == nil {
+		return ""
+	}
+	id, err := o.batches.Add(piece)
+	if err != nil {
+		log.Fatalf("failed to add piece %s to batch: %s", piece, err)
+	}
+	log.Infof("piece %s added to batch %d", piece, id)
+	return strconv.Itoa(id)
+}
+
+// WithCommPWorkers sets the number of goroutines computing the piece cid,
+// it defaults to the number of CPUs.
+func WithCommPWorkers(n int) CallbackOption {
+	return func(o *callbackOptions) {
+		if n > 0 {
+			o.commPWorkers = n
+		}
+	}
+}
+
+// WithCarDirs writes the CAR files to the directories of cd instead of the
+// car dir of the callback, which keeps manifest.csv.
+func WithCarDirs(cd *CarDirs) CallbackOption {
+	return func(o *callbackOptions) {
+		o.carDirs = cd
+	}
+}
+
+// WithPostPieceHook runs the hook command through sh after each CAR file is
+// finalized, see runPieceHook for the placeholders.
+func WithPostPieceHook(hook string) CallbackOption {
+	return func(o *callbackOptions) {
+		o.pieceHook = hook
+	}
+}
+
+// WithChecksums computes the digests of every CAR file, writes them to
+// <car>.<algo> sidecars and records them in the manifest.
+func WithChecksums(algos []ChecksumAlgo) CallbackOption {
+	return func(o *callbackOptions) {
+		o.checksums = algos
+	}
+}
+
+// WithCompression compresses CAR files at rest, the only supported
+// compression is zstd, an empty string writes plain CAR files. CAR files of
+// slices mostly holding already compressed or encrypted data are written
+// uncompressed.
+func WithCompression(compression string) CallbackOption {
+	return func(o *callbackOptions) {
+		o.compression = compression
+	}
+}
+
+// WithPieceFiles keeps the CAR files of the commP callback named after their
+// payload cid and writes the padded piece next to each one as
+// <piece-cid>.piece, hardlinked to the CAR file if it is padded already.
+func WithPieceFiles() CallbackOption {
+	return func(o *callbackOptions) {
+		o.pieceFiles = true
+	}
+}
+
+// WithManifestVersion selects the schema of manifest.csv, 1 or 2. Version 2
+// leads with the columns offline deal importers need, see manifestV2Columns,
+// and always computes the sha256 of the CAR files.
+func WithManifestVersion(version int) CallbackOption {
+	return func(o *callbackOptions) {
+		o.manifestVer = version
+	}
+}
+
+// WithManifestFormat writes the manifest as manifest.csv, manifest.json or
+// manifest.ndjson, which appends a JSON object per row.
+func WithManifestFormat(format ManifestFormat) CallbackOption {
+	return func(o *callbackOptions) {
+		o.manifestFormat = format
+	}
+}
+
+// WithManifestDB records every piece and the files it contains in db too.
+func WithManifestDB(db *ManifestDB) CallbackOption {
+	return func(o *callbackOptions) {
+		o.manifestDB = db
+	}
+}
+
+// WithManifestURL sets the template of the url column of a version 2
+// manifest, {name}, {payload_cid} and {piece_cid} are replaced.
+func WithManifestURL(template string) CallbackOption {
+	return func(o *callbackOptions) {
+		o.manifestURL = template
+	}
+}
+
+// WithRetry retries writing CAR and piece files on transient errors.
+func WithRetry(rp RetryPolicy) CallbackOption {
+	return func(o *callbackOptions) {
+		o.retry = rp
+	}
+}
+
+// writeCar atomically writes the CAR read from r to carFilePath, with the
+// compression extension appended if it is compressed, see sliceCompression.
+// It returns the final path and the manifest columns describing the file, the
+// written files are added to art. A *Buffer is written again on transient
+// errors.
+func (o *callbackOptions) writeCar(carFilePath, compression string, r io.Reader, art *sliceArtifacts) (string, map[string]string, error) {
+	if compression != "" {
+		carFilePath += compressionExt(compression)
+	}
+	rp := o.retry
+	buf, ok := r.(*Buffer)
+	if !ok {
+		// r can't be read again
+		rp = RetryPolicy{}
+	}
+	var cols map[string]string
+	err := rp.do(context.Background(), "write of "+carFilePath, func() (err error) {
+		if buf != nil {
+			buf.SeekStart()
+		}
+		cols, err = o.writeCarOnce(carFilePath, compression, r, art)
+		return err
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return carFilePath, cols, nil
+}
+
+func (o *callbackOptions) writeCarOnce(carFilePath, compression string, r io.Reader, art *sliceArtifacts) (map[string]string, error) {
+	carFile, err := createAtomic(carFilePath)
+	if err != nil {
+		return nil, err
+	}
+	sums := newCarChecksums(o.checksums)
+	w, err := compressWriter(compression, sums.Writer(o.writeLimiter.Writer(carFile)))
+	if err != nil {
+		art.abort(carFile)
+		return nil, err
+	}
+	carSize, err := io.Copy(w, r)
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		art.abort(carFile)
+		return nil, err
+	}
+	if err := carFile.Commit(); err != nil {
+		return nil, err
+	}
+	art.add(carFilePath)
+	for _, algo := range o.checksums {
+		art.add(carFilePath + "." + string(algo))
+	}
+	if err := sums.WriteSidecars(carFilePath); err != nil {
+		return nil, err
+	}
+	cols := sums.Columns()
+	if compression != "" {
+		cols["compression"] = compression
+		cols["car_size"] = strconv.FormatInt(carSize, 10)
+	}
+	return cols, nil
+}
+
+// writePieceFile writes the CAR in buf zero padded to its piece as
+// pieceFilePath. The CAR file at carFilePath is linked instead if it holds
+// the padded piece already, which needs the same filesystem.
+func (o *callbackOptions) writePieceFile(pieceFilePath, carFilePath string, buf *Buffer, padded bool, art *sliceArtifacts) error {
+	return o.retry.do(context.Background(), "write of "+pieceFilePath, func() error {
+		return o.writePieceFileOnce(pieceFilePath, carFilePath, buf, padded, art)
+	})
+}
+
+func (o *callbackOptions) writePieceFileOnce(pieceFilePath, carFilePath string, buf *Buffer, padded bool, art *sliceArtifacts) error {
+	if padded && o.compression == "" {
+		err := os.Link(carFilePath, pieceFilePath)
+		if err == nil {
+			art.add(pieceFilePath)
+			return nil
+		}
+		log.Warnf("failed to link %s, copy it: %s", carFilePath, err)
+	}
+	buf.SeekStart()
+	f, err := createAtomic(pieceFilePath)
+	if err != nil {
+		return err
+	}
+	carSize, err := io.Copy(o.writeLimiter.Writer(f), buf)
+	if err == nil {
+		err = PadCar(f, carSize)
+	}
+	if err != nil {
+		art.abort(f)
+		return err
+	}
+	if err := f.Commit(); err != nil {
+		return err
+	}
+	art.add(pieceFilePath)
+	return nil
+}
+
+// pickCarDir returns the directory to write a CAR of size bytes to.
+func (o *callbackOptions) pickCarDir(carDir string, size int64) string {
+	if o.carDirs == nil {
+		return carDir
+	}
+	dir, err := o.carDirs.Pick(size)
+	if err != nil {
+		log.Fatalf("failed to pick car dir: %s", err)
+	}
+	return dir
+}
+
+func newCallbackOptions(opts []CallbackOption) callbackOptions {
+	o := callbackOptions{commPWorkers: runtime.NumCPU(), manifestVer: 1}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.manifestVer >= 2 && !slices.Contains(o.checksums, ChecksumSha256) {
+		o.checksums = append(o.checksums, ChecksumSha256)
+	}
+	return o
+}
+
 type commPCallback struct {
+	callbackOptions
 	carDir     string
 	rename     bool
 	addPadding bool
 }
 
-func (cc *commPCallback) OnSuccess(buf *Buffer, graphName, payloadCid, fsDetail string) {
+func (cc *commPCallback) OnSuccess(buf *Buffer, slice *GraphSlice) {
 	commpStartTime := time.Now()
 
 	log.Info("start to calculate pieceCID")
-	cpRes, err := CalcCommPV2(buf, cc.addPadding)
+	carSize := buf.Len()
+	_, commpSpan := startSpan(slice.context(), "commp", "car_size", carSize)
+	cpRes, err := calcCommPV2(buf, cc.addPadding, cc.commPWorkers)
+	commpSpan.End(err)
 	if err != nil {
 		log.Fatalf("calculation of pieceCID failed: %s", err)
 	}
-	log.Infof("calculation of pieceCID completed, time elapsed: %s", time.Since(commpStartTime))
-	log.Infof("piece cid: %s, payload size: %d, size: %d ", cpRes.Root.String(), cpRes.PayloadSize, cpRes.Size)
+	metricStageSeconds.since("commp", commpStartTime)
+	metricCommPBytes.add("", float64(carSize))
+	metricCommPSeconds.add("", time.Since(commpStartTime).Seconds())
+	log.Infow("calculation of pieceCID completed", slice.logFields("piece_cid", cpRes.Root.String(),
+		"payload_size", cpRes.PayloadSize, "piece_size", cpRes.Size, "duration", time.Since(commpStartTime))...)
+	pieceCidV2, err := cpRes.PieceCidV2()
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	buf.SeekStart()
-	carFilePath := filepath.Join(cc.carDir, cpRes.Root.String())
-	carFileNameWithSuffix := carFilePath + ".car"
+	carDir := cc.pickCarDir(cc.carDir, int64(buf.Len()))
+	outDir := cc.shardDir(carDir, cpRes.Root.String(), slice.Index)
+	carFilePath := filepath.Join(outDir, cpRes.Root.String())
+	if cc.pieceFiles {
+		carFilePath = filepath.Join(outDir, slice.PayloadCid+".car")
+	} else if !cc.rename {
+		carFilePath += ".car"
+	}
+	if name := cc.carFileName(slice, cpRes.Root.String(), uint64(cpRes.Size)); name != "" {
+		carFilePath = filepath.Join(outDir, name)
+	}
 
 	log.Infof("start write car to tile")
 	writeStart := time.Now()
-	carFile, err := os.OpenFile(carFileNameWithSuffix, os.O_RDWR|os.O_CREATE, 0o644)
+	compression, precompressed := cc.sliceCompression(slice)
+	art := cc.newArtifacts()
+	_, writeSpan := startSpan(slice.context(), "car_write", "path", carFilePath)
+	carFilePath, cols, err := cc.writeCar(carFilePath, compression, buf, art)
+	writeSpan.End(err)
 	if err != nil {
-		log.Fatalf("failed to create car file: %s", err)
+		art.fatalf("failed to write car file: %s", err)
 	}
-
-	if _, err = io.Copy(carFile, buf); err != nil {
-		log.Fatalf("failed to write car file: %s", err)
+	if err := writeCarMeta(carFilePath, CarMeta{
+		PayloadCid:  slice.PayloadCid,
+		PieceCid:    cpRes.Root.String(),
+		BlockOrder:  BlockOrder(slice.blockOrder()),
+		Compression: compression,
+	}); err != nil {
+		art.fatalf("failed to write car metadata: %s", err)
 	}
-	buf.Reset()
-	carFile.Close()
-	log.Infof("end write car to file: %v", time.Since(writeStart))
-
-	if cc.rename {
-		if err := os.Rename(carFileNameWithSuffix, carFilePath); err != nil {
-			log.Fatalf("failed to rename car file: %s", err)
+	art.add(carFilePath + CarMetaExt)
+	metricStageSeconds.since("write", writeStart)
+	log.Infow("end write car to file", slice.logFields("path", carFilePath, "duration", time.Since(writeStart))...)
+	var pieceFilePath string
+	if cc.pieceFiles {
+		pieceFilePath = filepath.Join(outDir, cpRes.Root.String()+pieceFileExt)
+		if err := cc.writePieceFile(pieceFilePath, carFilePath, buf, cc.addPadding, art); err != nil {
+			art.fatalf("failed to write piece file: %s", err)
 		}
 	}
 
 	// Add node inof to manifest.csv
-	manifestPath := path.Join(cc.carDir, "manifest.csv")
-	_, err = os.Stat(manifestPath)
-	if err != nil && !os.IsNotExist(err) {
-		log.Fatal(err)
+	row := map[string]string{
+		"payload_cid":         slice.PayloadCid,
+		"filename":            slice.Name,
+		"piece_cid":           cpRes.Root.String(),
+		"payload_size":        strconv.FormatInt(cpRes.PayloadSize, 10),
+		"piece_size":          strconv.FormatUint(uint64(cpRes.Size), 10),
+		"unpadded_piece_size": strconv.FormatUint(uint64(cpRes.Size), 10),
+		"padded_piece_size":   strconv.FormatUint(uint64(cpRes.Size.Padded()), 10),
+		"piece_cid_v2":        pieceCidV2.String(),
+		"detail":              slice.FsDetail,
+		"slice_size":          strconv.FormatInt(slice.SliceSize, 10),
+		"block_order":         slice.blockOrder(),
+		"encryption":          slice.Encryption,
+		"sources":             slice.sources(),
+		"root_path":           slice.RootPath,
+		"car_dir":             carDir,
+		"batch_id":            cc.addToBatch(cpRes.Root.String()),
+		"car_file":            carFilePath,
+		"piece_file":          pieceFilePath,
+		"precompressed":       precompressed,
 	}
-	var isCreateAction bool
-	if err != nil && os.IsNotExist(err) {
-		isCreateAction = true
+	for col, v := range cols {
+		row[col] = v
 	}
-	f, err := os.OpenFile(manifestPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
-	if err != nil {
-		log.Fatal(err)
+	cc.addManifestV2Columns(row, carFilePath, slice)
+	if err := cc.appendManifest(cc.carDir, cc.manifestHeader(commPManifestHeader), row); err != nil {
+		art.fatalf("failed to add %s to the manifest: %s", carFilePath, err)
 	}
-	defer f.Close()
-
-	csvWriter := csv.NewWriter(f)
-	csvWriter.UseCRLF = true
-	defer csvWriter.Flush()
-	if isCreateAction {
-		csvWriter.Write([]string{
-			"payload_cid", "filename", "piece_cid", "payload_size", "piece_size", "detail",
-		})
+	if err := cc.addToManifestDB(row, carFilePath, slice); err != nil {
+		log.Fatalf("failed to add piece to manifest db: %s", err)
 	}
-
-	if err := csvWriter.Write([]string{
-		payloadCid, graphName, cpRes.Root.String(),
-		strconv.FormatInt(cpRes.PayloadSize, 10), strconv.FormatUint(uint64(cpRes.Size), 10), fsDetail,
-	}); err != nil {
-		log.Fatal(err)
+	if err := cc.recordProvenance(cc.carDir, carFilePath, row, slice); err != nil {
+		log.Fatalf("failed to record the files of the piece: %s", err)
 	}
+	runPieceHook(cc.pieceHook, PieceHookInfo{
+		Car:        carFilePath,
+		PieceCid:   cpRes.Root.String(),
+		PayloadCid: slice.PayloadCid,
+		PieceSize:  strconv.FormatUint(uint64(cpRes.Size), 10),
+	})
 }
 
 func (cc *commPCallback) OnError(err error) {
@@ -100,38 +503,69 @@ func (cc *commPCallback) OnError(err error) {
 }
 
 type csvCallback struct {
+	callbackOptions
 	carDir string
 }
 
-func (cc *csvCallback) OnSuccess(buf *Buffer, graphName, payloadCid, fsDetail string) {
-	// Add node inof to manifest.csv
-	manifestPath := path.Join(cc.carDir, "manifest.csv")
-	_, err := os.Stat(manifestPath)
-	if err != nil && !os.IsNotExist(err) {
-		log.Fatal(err)
+func (cc *csvCallback) OnSuccess(buf *Buffer, slice *GraphSlice) {
+	carDir := cc.pickCarDir(cc.carDir, int64(buf.Len()))
+	outDir := cc.shardDir(carDir, slice.PayloadCid, slice.Index)
+	carFilePath := filepath.Join(outDir, slice.PayloadCid+".car")
+	if name := cc.carFileName(slice, "", 0); name != "" {
+		carFilePath = filepath.Join(outDir, name)
 	}
-	var isCreateAction bool
-	if err != nil && os.IsNotExist(err) {
-		isCreateAction = true
-	}
-	f, err := os.OpenFile(manifestPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	compression, precompressed := cc.sliceCompression(slice)
+	writeStart := time.Now()
+	art := cc.newArtifacts()
+	_, writeSpan := startSpan(slice.context(), "car_write", "path", carFilePath)
+	carFilePath, cols, err := cc.writeCar(carFilePath, compression, buf, art)
+	writeSpan.End(err)
 	if err != nil {
-		log.Fatal(err)
+		art.fatalf("failed to write car file: %s", err)
 	}
-	defer f.Close()
-	if isCreateAction {
-		if _, err := f.Write([]byte("payload_cid,filename,detail")); err != nil {
-			log.Fatal(err)
-		}
+	if err := writeCarMeta(carFilePath, CarMeta{
+		PayloadCid:  slice.PayloadCid,
+		BlockOrder:  BlockOrder(slice.blockOrder()),
+		Compression: compression,
+	}); err != nil {
+		art.fatalf("failed to write car metadata: %s", err)
 	}
+	art.add(carFilePath + CarMetaExt)
+	metricStageSeconds.since("write", writeStart)
+	log.Infow("end write car to file", slice.logFields("path", carFilePath, "duration", time.Since(writeStart))...)
 
-	if err := os.WriteFile(path.Join(cc.carDir, payloadCid+".car"), buf.Bytes(), 0o644); err != nil {
-		log.Fatal(err)
+	// Add node inof to manifest.csv
+	row := map[string]string{
+		"payload_cid":   slice.PayloadCid,
+		"filename":      slice.Name,
+		"detail":        slice.FsDetail,
+		"slice_size":    strconv.FormatInt(slice.SliceSize, 10),
+		"block_order":   slice.blockOrder(),
+		"encryption":    slice.Encryption,
+		"sources":       slice.sources(),
+		"root_path":     slice.RootPath,
+		"car_dir":       carDir,
+		"batch_id":      cc.addToBatch(slice.PayloadCid),
+		"car_file":      carFilePath,
+		"precompressed": precompressed,
 	}
-
-	if _, err := f.Write([]byte(fmt.Sprintf("%s,%s,%s", payloadCid, graphName, fsDetail))); err != nil {
-		log.Fatal(err)
+	for col, v := range cols {
+		row[col] = v
+	}
+	cc.addManifestV2Columns(row, carFilePath, slice)
+	if err := cc.appendManifest(cc.carDir, cc.manifestHeader(csvManifestHeader), row); err != nil {
+		art.fatalf("failed to add %s to the manifest: %s", carFilePath, err)
+	}
+	if err := cc.addToManifestDB(row, carFilePath, slice); err != nil {
+		log.Fatalf("failed to add piece to manifest db: %s", err)
 	}
+	if err := cc.recordProvenance(cc.carDir, carFilePath, row, slice); err != nil {
+		log.Fatalf("failed to record the files of the piece: %s", err)
+	}
+	runPieceHook(cc.pieceHook, PieceHookInfo{
+		Car:        carFilePath,
+		PayloadCid: slice.PayloadCid,
+	})
 }
 
 func (cc *csvCallback) OnError(err error) {
@@ -140,17 +574,44 @@ func (cc *csvCallback) OnError(err error) {
 
 type errCallback struct{}
 
-func (cc *errCallback) OnSuccess(*Buffer, string, string, string) {}
+func (cc *errCallback) OnSuccess(*Buffer, *GraphSlice) {}
 func (cc *errCallback) OnError(err error) {
 	log.Fatal(err)
 }
 
-func CommPCallback(carDir string, rename, addPadding bool) GraphBuildCallback {
-	return &commPCallback{carDir: carDir, rename: rename, addPadding: addPadding}
+type multiCallback []GraphBuildCallback
+
+// MultiCallback chains callbacks. OnSuccess calls them in the given order,
+// each one reading the buffer from its start, so the callbacks writing the
+// CAR file and the manifest should come first. OnError is passed to every
+// callback in order, the built-in callbacks exit the process on errors, so
+// the callbacks after them are not reached.
+func MultiCallback(cbs ...GraphBuildCallback) GraphBuildCallback {
+	if len(cbs) == 1 {
+		return cbs[0]
+	}
+	return multiCallback(cbs)
+}
+
+func (mc multiCallback) OnSuccess(buf *Buffer, slice *GraphSlice) {
+	for _, cb := range mc {
+		buf.SeekStart()
+		cb.OnSuccess(buf, slice)
+	}
+}
+
+func (mc multiCallback) OnError(err error) {
+	for _, cb := range mc {
+		cb.OnError(err)
+	}
+}
+
+func CommPCallback(carDir string, rename, addPadding bool, opts ...CallbackOption) GraphBuildCallback {
+	return &commPCallback{callbackOptions: newCallbackOptions(opts), carDir: carDir, rename: rename, addPadding: addPadding}
 }
 
-func CSVCallback(carDir string) GraphBuildCallback {
-	return &csvCallback{carDir: carDir}
+func CSVCallback(carDir string, opts ...CallbackOption) GraphBuildCallback {
+	return &csvCallback{callbackOptions: newCallbackOptions(opts), carDir: carDir}
 }
 
 func ErrCallback() GraphBuildCallback {
@@ -163,44 +624,294 @@ type ChunkParams struct {
 	TargetPath             string
 	CarDir                 string
 	GraphName              string
-	Parallel               int
+	Parallel               int // 0 picks it from the hardware and the files to chunk
 	Cb                     GraphBuildCallback
 	Ef                     *ExtraFile
 	RandomRenameSourceFile bool
 	RandomSelectFile       bool
 	SkipFilename           bool
+	// MaxMemory bounds the memory used to build a graph slice, 0 means no limit
+	MaxMemory int64
+	// MaxSliceSize enables slice size jitter, the size of every slice is picked
+	// uniformly from [ExpectSliceSize, MaxSliceSize]
+	MaxSliceSize int64
+	// ReadRate throttles reads of source files in bytes per second, 0 means no limit
+	ReadRate int64
+	// State enables incremental chunking, only files that are new or changed
+	// since they were packed get chunked
+	State *PackState
+	// MinFreeSpace is kept free in CarDir, chunking stops with
+	// ErrInsufficientSpace before a slice would use it up
+	MinFreeSpace int64
+	// DedupExtra stops using extra files which duplicate dataset content
+	DedupExtra bool
+	// BlockOrder is the order of blocks in CAR files, empty means BlockOrderDFS
+	BlockOrder BlockOrder
+	// CarDirs are the directories CAR files are spread across, when set the
+	// free space checks consider them instead of CarDir
+	CarDirs *CarDirs
+	// Control pauses, resumes or aborts the run, it may be nil
+	Control *Controller
+	// HashWorkers is the number of goroutines hashing the blocks of a file,
+	// 0 or 1 hashes them on the goroutine building the file
+	HashWorkers int
+	// Encryptor encrypts file data before it is built into the DAG, it may
+	// be nil
+	Encryptor *Encryptor
+	// Source lists and reads the files to chunk instead of TargetPath of the
+	// local filesystem, it may be nil
+	Source Source
+	// Stream is read into consecutive slices until it ends instead of
+	// chunking files, like stdin of a pipeline
+	Stream io.Reader
+	// ExpandArchives chunks the files of tar, tar.gz and zip archives below
+	// a directory named like the archive instead of the archive files
+	ExpandArchives bool
+	// Progress receives the bytes and files read and the slices built, it
+	// may be nil
+	Progress ProgressReporter
+	// OnFileError is what to do with unreadable source files, empty means
+	// FileErrorFail. Skipped files are reported to FileErrorReportName in
+	// CarDir
+	OnFileError FileErrorPolicy
+	// Retry retries opening and reading source files on transient errors
+	Retry RetryPolicy
+	// Partition restricts chunking to the files of one partition of a
+	// PartitionPlan of TargetPath, it may be nil
+	Partition *Partition
+	// Reload is called between slices once Control.RequestReload was
+	// called, it may change the slice sizes, Ef, Parallel and Cb of params,
+	// e.g. from a reloaded config. When it fails the settings are kept
+	Reload func(params *ChunkParams) error
+
+	budget   *memBudget
+	parallel int
+	// sliceIndex is the number of the slice built next
+	sliceIndex int
+	progress   *progressTracker
+	fileErrors *fileErrorReport
+}
+
+// maxSliceSize returns the largest size a slice can have.
+func (params *ChunkParams) maxSliceSize() int64 {
+	if params.MaxSliceSize > params.ExpectSliceSize {
+		return params.MaxSliceSize
+	}
+	return params.ExpectSliceSize
+}
+
+// pickSliceSize returns the target size of the next slice.
+func (params *ChunkParams) pickSliceSize() int64 {
+	if params.MaxSliceSize <= params.ExpectSliceSize {
+		return params.ExpectSliceSize
+	}
+	return params.ExpectSliceSize + rand.Int63n(params.MaxSliceSize-params.ExpectSliceSize+1)
 }
 
-func Chunk(ctx context.Context, params *ChunkParams) error {
+// reload applies a reload requested through Control.
+func (params *ChunkParams) reload() {
+	if params.Reload == nil || !params.Control.takeReload() {
+		return
+	}
+	if err := params.Reload(params); err != nil {
+		log.Errorf("failed to reload, keeping the settings: %s", err)
+		return
+	}
+	if params.Parallel > 0 {
+		params.parallel = params.Parallel
+	}
+}
+
+// rootPath returns the directory the root of the DAG of fileList stands for,
+// the parent path or the directory of a single file target.
+func (params *ChunkParams) rootPath(fileList []Finfo) string {
+	if params.Source != nil {
+		return params.ParentPath
+	}
+	root := filepath.Clean(params.ParentPath)
+	for _, item := range fileList {
+		if filepath.Clean(item.Path) == root {
+			root = filepath.Dir(root)
+			break
+		}
+	}
+	if abs, err := filepath.Abs(root); err == nil {
+		root = abs
+	}
+	return root
+}
+
+func Chunk(ctx context.Context, params *ChunkParams) (err error) {
+	ctx, sp := startSpan(ctx, "chunk", "graph_name", params.GraphName, "target_path", params.TargetPath)
+	defer func() { sp.End(err) }()
 	var cumuSize int64 = 0
 	graphSliceCount := 0
 	graphFiles := make([]Finfo, 0)
 	if params.ExpectSliceSize == 0 {
 		return fmt.Errorf("slice size has been set as 0")
 	}
-	if params.Parallel <= 0 {
-		return fmt.Errorf("parallel has to be greater than 0")
+	if params.Parallel < 0 {
+		return fmt.Errorf("parallel can not be negative")
+	}
+	if _, err := ParseFileErrorPolicy(string(params.OnFileError)); err != nil {
+		return err
+	}
+	if params.Source != nil {
+		if params.DedupExtra {
+			return fmt.Errorf("dedup of extra files needs local source files")
+		}
+		if params.ExpandArchives {
+			return fmt.Errorf("archives can only be expanded from local files")
+		}
+		params.TargetPath = params.Source.Root()
+		params.ParentPath = params.Source.Root()
+	}
+	if params.Partition != nil {
+		if params.Source != nil || params.Stream != nil {
+			return fmt.Errorf("partitions can only be chunked from local files")
+		}
+		params.GraphName = params.Partition.graphName(params.GraphName)
 	}
 	if params.ParentPath == "" {
 		params.ParentPath = params.TargetPath
 	}
+	budget, err := newChunkMemBudget(params.MaxMemory, params.maxSliceSize())
+	if err != nil {
+		return err
+	}
+	params.budget = budget
+	if params.Control != nil {
+		// a paused run has to wake up to see it was canceled
+		stop := context.AfterFunc(ctx, params.Control.Resume)
+		defer stop()
+	}
+	params.progress = newProgressTracker(params.Progress, "chunk")
+	defer params.progress.finish()
+	if err := params.checkFreeSpace(params.maxSliceSize()); err != nil {
+		return err
+	}
+	if params.Stream != nil {
+		return chunkStream(ctx, params)
+	}
+	params.fileErrors = newFileErrorReport(params.OnFileError, params.CarDir)
+	defer func() {
+		if n := params.fileErrors.count(); n > 0 {
+			log.Warnf("skipped %d unreadable files, see %s", n, filepath.Join(params.CarDir, FileErrorReportName))
+		}
+	}()
 
-	partSliceSize := params.ExpectSliceSize - params.Ef.sliceSize
-	args := []string{params.TargetPath}
-	sliceTotal := GetGraphCount(args, params.ExpectSliceSize)
+	params.reload()
+	sliceSize := params.pickSliceSize()
+	partSliceSize := sliceSize - params.Ef.sliceSize
+	var allFiles []Finfo
+	_, walkSpan := startSpan(ctx, "walk")
+	if params.Source != nil {
+		if allFiles, err = params.Source.List(ctx); err != nil {
+			walkSpan.End(err)
+			return fmt.Errorf("failed to list %s: %w", params.Source.Root(), err)
+		}
+	} else {
+		args := []string{params.TargetPath}
+		files := GetFileListAsync(args)
+		for item := range files {
+			allFiles = append(allFiles, item)
+		}
+		if params.Partition != nil {
+			if allFiles, err = params.Partition.filter(params.TargetPath, allFiles); err != nil {
+				walkSpan.End(err)
+				return err
+			}
+		}
+		if params.ExpandArchives {
+			if allFiles, err = expandArchives(allFiles); err != nil {
+				walkSpan.End(err)
+				return err
+			}
+		}
+	}
+	walkSpan.set("files", len(allFiles))
+	walkSpan.End(nil)
+	log.Infof("total files: %d", len(allFiles))
+	if params.State != nil {
+		allFiles, err = params.State.Pending(allFiles)
+		if err != nil {
+			return err
+		}
+		log.Infof("new or changed files: %d", len(allFiles))
+	}
+	if params.DedupExtra && params.ExpandArchives {
+		return fmt.Errorf("dedup of extra files can not be combined with expanded archives")
+	}
+	if params.DedupExtra {
+		overlaps, err := params.Ef.Dedup(allFiles)
+		if err != nil {
+			return fmt.Errorf("failed to dedup extra files: %w", err)
+		}
+		if len(overlaps) > 0 {
+			if err := WriteExtraOverlapReport(params.CarDir, overlaps); err != nil {
+				return fmt.Errorf("failed to write the extra file overlap report: %w", err)
+			}
+			log.Warnf("%d extra files duplicate dataset content, see %s", len(overlaps), filepath.Join(params.CarDir, ExtraOverlapReportName))
+		}
+	}
+	sliceTotal := graphCount(allFiles, (params.ExpectSliceSize+params.maxSliceSize())/2)
 	if sliceTotal == 0 {
 		log.Warn("Empty folder or file!")
 		return nil
 	}
-	var allFiles []Finfo
-	files := GetFileListAsync(args)
-	for item := range files {
-		allFiles = append(allFiles, item)
+	var totalSize int64
+	for _, item := range allFiles {
+		totalSize += item.partSize()
+	}
+	params.progress.setTotals(totalSize, len(allFiles), sliceTotal)
+	params.parallel = params.Parallel
+	if params.parallel == 0 {
+		params.parallel = TuneParallel(params.TargetPath, allFiles).Build
 	}
-	log.Infof("total files: %d", len(allFiles))
 
 	Shuffle(allFiles)
 
+	buildSlice := func(cumuSize int64) error {
+		if err := params.Control.checkpoint(); err != nil {
+			return err
+		}
+		// also when it was canceled while paused
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := params.checkFreeSpace(sliceSize); err != nil {
+			return err
+		}
+		graphName := GenGraphName(params.GraphName, graphSliceCount, sliceTotal)
+		params.sliceIndex = graphSliceCount + 1
+		// todo build ipld from graphFiles
+		payloadCid := BuildIpldGraph(ctx, append(params.Ef.getFiles(), graphFiles...), graphName, sliceSize, params)
+		if params.State != nil && payloadCid != "" {
+			// skipped files are chunked again by the next run
+			var packed []Finfo
+			for _, item := range graphFiles {
+				if !params.fileErrors.isSkipped(item.Path) {
+					packed = append(packed, item)
+				}
+			}
+			if err := params.State.RecordSlice(payloadCid, packed); err != nil {
+				log.Errorf("failed to record slice %s: %s", graphName, err)
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		log.Infof("cumu-size: %d", cumuSize)
+		log.Infof("%s", graphName)
+		log.Infof("=================")
+		graphFiles = make([]Finfo, 0)
+		graphSliceCount++
+		params.reload()
+		sliceSize = params.pickSliceSize()
+		partSliceSize = sliceSize - params.Ef.sliceSize
+		return nil
+	}
+
 	for _, item := range allFiles {
 		item := item
 		if params.RandomRenameSourceFile {
@@ -215,14 +926,10 @@ func Chunk(ctx context.Context, params *ChunkParams) error {
 		case cumuSize+fileSize == partSliceSize:
 			cumuSize += fileSize
 			graphFiles = append(graphFiles, item)
-			// todo build ipld from graphFiles
-			BuildIpldGraph(ctx, append(params.Ef.getFiles(), graphFiles...), GenGraphName(params.GraphName, graphSliceCount, sliceTotal), params)
-			log.Infof("cumu-size: %d", cumuSize)
-			log.Infof("%s", GenGraphName(params.GraphName, graphSliceCount, sliceTotal))
-			log.Infof("=================")
+			if err := buildSlice(cumuSize); err != nil {
+				return err
+			}
 			cumuSize = 0
-			graphFiles = make([]Finfo, 0)
-			graphSliceCount++
 		case cumuSize+fileSize > partSliceSize:
 			fileSliceCount := 0
 			// need to split item to fit graph slice
@@ -239,6 +946,7 @@ func Chunk(ctx context.Context, params *ChunkParams) error {
 				Info:      item.Info,
 				SeekStart: seekStart,
 				SeekEnd:   seekEnd,
+				member:    item.member,
 			}
 			if params.RandomRenameSourceFile {
 				graphFiles = append(graphFiles, tryRenameFileName([]Finfo{fi})...)
@@ -246,14 +954,10 @@ func Chunk(ctx context.Context, params *ChunkParams) error {
 				graphFiles = append(graphFiles, fi)
 			}
 			fileSliceCount++
-			// todo build ipld from graphFiles
-			BuildIpldGraph(ctx, append(params.Ef.getFiles(), graphFiles...), GenGraphName(params.GraphName, graphSliceCount, sliceTotal), params)
-			log.Infof("cumu-size: %d", cumuSize+firstCut)
-			log.Infof("%s", GenGraphName(params.GraphName, graphSliceCount, sliceTotal))
-			log.Infof("=================")
+			if err := buildSlice(cumuSize + firstCut); err != nil {
+				return err
+			}
 			cumuSize = 0
-			graphFiles = make([]Finfo, 0)
-			graphSliceCount++
 			for seekEnd < fileSize-1 {
 				seekStart = seekEnd + 1
 				seekEnd = seekStart + partSliceSize - 1
@@ -269,6 +973,7 @@ func Chunk(ctx context.Context, params *ChunkParams) error {
 					Info:      item.Info,
 					SeekStart: seekStart,
 					SeekEnd:   seekEnd,
+					member:    item.member,
 				}
 				if params.RandomRenameSourceFile {
 					graphFiles = append(graphFiles, tryRenameFileName([]Finfo{fi})...)
@@ -278,24 +983,16 @@ func Chunk(ctx context.Context, params *ChunkParams) error {
 
 				fileSliceCount++
 				if seekEnd-seekStart == partSliceSize-1 {
-					// todo build ipld from graphFiles
-					BuildIpldGraph(ctx, append(params.Ef.getFiles(), graphFiles...), GenGraphName(params.GraphName, graphSliceCount, sliceTotal), params)
-					log.Infof("cumu-size: %d", partSliceSize)
-					log.Infof("%s", GenGraphName(params.GraphName, graphSliceCount, sliceTotal))
-					log.Infof("=================")
+					if err := buildSlice(partSliceSize); err != nil {
+						return err
+					}
 					cumuSize = 0
-					graphFiles = make([]Finfo, 0)
-					graphSliceCount++
 				}
 			}
 		}
 	}
 	if cumuSize > 0 {
-		// todo build ipld from graphFiles
-		BuildIpldGraph(ctx, append(params.Ef.getFiles(), graphFiles...), GenGraphName(params.GraphName, graphSliceCount, sliceTotal), params)
-		log.Infof("cumu-size: %d", cumuSize)
-		log.Infof("%s", GenGraphName(params.GraphName, graphSliceCount, sliceTotal))
-		log.Infof("=================")
+		return buildSlice(cumuSize)
 	}
 	return nil
 }
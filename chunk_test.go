@@ -0,0 +1,152 @@
+package graphsplit
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestTree writes files of random data with the given sizes to a new
+// directory and returns it.
+func writeTestTree(t *testing.T, sizes ...int) string {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "data")
+	for i, size := range sizes {
+		path := filepath.Join(dir, fmt.Sprintf("sub%d", i%2), fmt.Sprintf("file%d", i))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		data := make([]byte, size)
+		if _, err := rand.Read(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// chunkTestTree chunks dir with the CSV callback unless params has a
+// callback and returns the rows of the manifest in the car dir of params.
+func chunkTestTree(t *testing.T, dir string, params *ChunkParams) []ManifestRow {
+	t.Helper()
+	if params.CarDir == "" {
+		params.CarDir = t.TempDir()
+	}
+	if params.Cb == nil {
+		params.Cb = CSVCallback(params.CarDir)
+	}
+	if params.Ef == nil {
+		ef, err := NewExtraFile("", 0, 0, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		params.Ef = ef
+	}
+	if params.Parallel == 0 {
+		params.Parallel = 1
+	}
+	params.TargetPath = dir
+	params.ParentPath = dir
+	if params.GraphName == "" {
+		params.GraphName = "test"
+	}
+	if err := Chunk(context.Background(), params); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := ReadManifest(params.CarDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+func TestChunkWithMaxMemory(t *testing.T) {
+	dir := writeTestTree(t, 40<<10, 50<<10, 60<<10, 70<<10)
+	rows := chunkTestTree(t, dir, &ChunkParams{
+		ExpectSliceSize: 64 << 10,
+		Parallel:        4,
+		MaxMemory:       2*(64<<10) + int64(UnixfsChunkSize),
+	})
+	if len(rows) < 3 {
+		t.Fatalf("expected at least 3 slices of 220KiB, got %d", len(rows))
+	}
+
+	ef, err := NewExtraFile("", 0, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Chunk(context.Background(), &ChunkParams{
+		ExpectSliceSize: 64 << 10,
+		TargetPath:      dir,
+		CarDir:          t.TempDir(),
+		Parallel:        1,
+		Cb:              ErrCallback(),
+		Ef:              ef,
+		MaxMemory:       64 << 10,
+	})
+	if err == nil {
+		t.Fatal("expected an error for max memory below the slice reservation")
+	}
+}
+
+type legacyTestCallback struct {
+	graphName, payloadCid, fsDetail string
+}
+
+func (lc *legacyTestCallback) OnSuccess(buf *Buffer, graphName, payloadCid, fsDetail string) {
+	lc.graphName, lc.payloadCid, lc.fsDetail = graphName, payloadCid, fsDetail
+}
+
+func (lc *legacyTestCallback) OnError(err error) {}
+
+func TestAdaptLegacyCallback(t *testing.T) {
+	lc := &legacyTestCallback{}
+	AdaptLegacyCallback(lc).OnSuccess(NewBuffer(0), &GraphSlice{Name: "graph.car", PayloadCid: "bafytest", FsDetail: "{}"})
+	if lc.graphName != "graph.car" || lc.payloadCid != "bafytest" || lc.fsDetail != "{}" {
+		t.Fatalf("unexpected arguments %+v", lc)
+	}
+}
+
+// recordingCallback reads the whole buffer of every slice and appends its
+// name and what it read to calls.
+type recordingCallback struct {
+	name  string
+	calls *[]string
+}
+
+func (rc *recordingCallback) OnSuccess(buf *Buffer, slice *GraphSlice) {
+	data, _ := io.ReadAll(buf)
+	*rc.calls = append(*rc.calls, rc.name+":"+slice.PayloadCid+":"+string(data))
+}
+
+func (rc *recordingCallback) OnError(err error) {
+	*rc.calls = append(*rc.calls, rc.name+":"+err.Error())
+}
+
+func TestMultiCallback(t *testing.T) {
+	var calls []string
+	first := &recordingCallback{name: "first", calls: &calls}
+	if MultiCallback(first) != GraphBuildCallback(first) {
+		t.Fatal("expected a single callback returned as is")
+	}
+	cb := MultiCallback(first, &recordingCallback{name: "second", calls: &calls})
+
+	buf := NewBuffer(0)
+	if _, err := buf.Write([]byte("car")); err != nil {
+		t.Fatal(err)
+	}
+	cb.OnSuccess(buf, &GraphSlice{PayloadCid: "bafytest"})
+	cb.OnError(errors.New("failed"))
+	want := []string{"first:bafytest:car", "second:bafytest:car", "first:failed", "second:failed"}
+	if strings.Join(calls, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected calls %v, got %v", want, calls)
+	}
+}
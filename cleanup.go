@@ -0,0 +1,197 @@
+package graphsplit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// WithKeepTmp keeps the partial outputs of a slice which failed, instead of
+// removing them, to debug the failure.
+func WithKeepTmp() CallbackOption {
+	return func(o *callbackOptions) {
+		o.keepTmp = true
+	}
+}
+
+// sliceArtifacts are the files written for a slice before its manifest row,
+// they are removed if the slice fails so no unlisted CAR files are left.
+type sliceArtifacts struct {
+	paths []string
+	keep  bool
+}
+
+func (o *callbackOptions) newArtifacts() *sliceArtifacts {
+	return &sliceArtifacts{keep: o.keepTmp}
+}
+
+func (sa *sliceArtifacts) add(paths ...string) {
+	sa.paths = append(sa.paths, paths...)
+}
+
+// abort drops the partially written f, or only closes it with keepTmp.
+func (sa *sliceArtifacts) abort(f *atomicFile) {
+	if !sa.keep {
+		f.Abort()
+		return
+	}
+	pendingWrites.Delete(f.Name())
+	f.File.Close()
+	log.Warnf("keeping partial %s", f.Name())
+}
+
+// remove removes the files written so far, unless they are kept.
+func (sa *sliceArtifacts) remove() {
+	for _, p := range sa.paths {
+		if sa.keep {
+			log.Warnf("keeping %s of the failed slice", p)
+			continue
+		}
+		if err := os.Remove(p); err == nil {
+			log.Infof("removed %s of the failed slice", p)
+		} else if !os.IsNotExist(err) {
+			log.Errorf("failed to remove %s: %s", p, err)
+		}
+	}
+	sa.paths = nil
+}
+
+// fatalf removes the files of the slice and exits.
+func (sa *sliceArtifacts) fatalf(format string, args ...interface{}) {
+	sa.remove()
+	log.Fatalf(format, args...)
+}
+
+// stagingDirPrefixes are the prefixes of the staging directories of repack
+// and parity recovery.
+var stagingDirPrefixes = []string{"repack-", "recover-"}
+
+// CleanCarDir removes the leftovers of interrupted or failed runs below
+// carDir: unfinished writes, checksum and metadata sidecars without their CAR
+// file and staging directories. With unlisted, CAR files and piece files
+// without a manifest row are removed too. It returns the removed paths, which are only
+// listed if dryRun is set.
+func CleanCarDir(carDir string, unlisted, dryRun bool) ([]string, error) {
+	var stale []string
+	err := filepath.Walk(carDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != carDir && isStagingDir(info.Name()) {
+				stale = append(stale, path)
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch {
+		case isTmpFile(path):
+			stale = append(stale, path)
+		case isChecksumSidecar(path):
+			if _, err := os.Stat(strings.TrimSuffix(path, filepath.Ext(path))); os.IsNotExist(err) {
+				stale = append(stale, path)
+			}
+		case isCarMeta(path):
+			if _, err := os.Stat(strings.TrimSuffix(path, CarMetaExt)); os.IsNotExist(err) {
+				stale = append(stale, path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if unlisted {
+		extra, err := unlistedOutputs(carDir)
+		if err != nil {
+			return nil, err
+		}
+		stale = append(stale, extra...)
+	}
+	sort.Strings(stale)
+	if dryRun {
+		return stale, nil
+	}
+	for _, p := range stale {
+		if err := os.RemoveAll(p); err != nil {
+			return nil, err
+		}
+		log.Infof("removed %s", p)
+	}
+	return stale, nil
+}
+
+func isStagingDir(name string) bool {
+	for _, prefix := range stagingDirPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// inStagingDir reports whether path below carDir is in a staging directory.
+func inStagingDir(carDir, path string) bool {
+	rel, err := filepath.Rel(carDir, filepath.Dir(path))
+	if err != nil {
+		return false
+	}
+	for _, name := range strings.Split(rel, string(filepath.Separator)) {
+		if isStagingDir(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// unlistedOutputs returns the CAR files and piece files of carDir without a
+// manifest row, with their checksum and metadata sidecars.
+func unlistedOutputs(carDir string) ([]string, error) {
+	rows, err := ReadManifest(carDir)
+	if err != nil {
+		return nil, fmt.Errorf("unlisted files need the manifest: %w", err)
+	}
+	known := make(map[string]bool)
+	for _, row := range rows {
+		if p := locateCarExt(carDir, row, compressionExt(row["compression"])); p != "" {
+			known[p] = true
+		}
+		if row["piece_file"] != "" {
+			known[row["piece_file"]] = true
+		}
+	}
+	cars, err := unlistedCars(map[string]bool{carDir: true}, known)
+	if err != nil {
+		return nil, err
+	}
+	var extra []string
+	for _, p := range cars {
+		if inStagingDir(carDir, p) {
+			continue
+		}
+		extra = append(extra, p)
+		for _, algo := range []ChecksumAlgo{ChecksumSha256, ChecksumBlake3} {
+			if _, err := os.Stat(p + "." + string(algo)); err == nil {
+				extra = append(extra, p+"."+string(algo))
+			}
+		}
+		if _, err := os.Stat(p + CarMetaExt); err == nil {
+			extra = append(extra, p+CarMetaExt)
+		}
+	}
+	err = filepath.Walk(carDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && path != carDir && isStagingDir(info.Name()) {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() && isPieceFile(path) && !known[path] {
+			extra = append(extra, path)
+		}
+		return nil
+	})
+	return extra, err
+}
@@ -0,0 +1,88 @@
+package graphsplit
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCleanCarDir(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string) string {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	listed := write("listed.car")
+	write("listed.car.sha256")
+	tmp := write("partial.car" + TmpSuffix)
+	orphan := write("gone.car.sha256")
+	unlisted := write("unlisted.car")
+	write("repack-123/file")
+	if err := appendManifest(dir, csvManifestHeader, map[string]string{"payload_cid": "listed", "car_file": listed}); err != nil {
+		t.Fatal(err)
+	}
+
+	stale, err := CleanCarDir(dir, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{orphan, tmp, filepath.Join(dir, "repack-123")}
+	if !reflect.DeepEqual(stale, want) {
+		t.Fatalf("expected %v, got %v", want, stale)
+	}
+	if _, err := os.Stat(tmp); err != nil {
+		t.Fatal("dry run removed a file")
+	}
+
+	stale, err = CleanCarDir(dir, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{orphan, tmp, filepath.Join(dir, "repack-123"), unlisted}
+	if !reflect.DeepEqual(stale, want) {
+		t.Fatalf("expected %v, got %v", want, stale)
+	}
+	for _, p := range want {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Fatalf("%s was not removed", p)
+		}
+	}
+	if _, err := os.Stat(listed); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSliceArtifacts(t *testing.T) {
+	dir := t.TempDir()
+	o := newCallbackOptions([]CallbackOption{WithChecksums([]ChecksumAlgo{ChecksumSha256})})
+	art := o.newArtifacts()
+	carPath, _, err := o.writeCar(filepath.Join(dir, "slice.car"), "", strings.NewReader("car"), art)
+	if err != nil {
+		t.Fatal(err)
+	}
+	art.remove()
+	for _, p := range []string{carPath, carPath + ".sha256"} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Fatalf("%s was not removed", p)
+		}
+	}
+
+	o = newCallbackOptions([]CallbackOption{WithKeepTmp()})
+	art = o.newArtifacts()
+	carPath, _, err = o.writeCar(filepath.Join(dir, "kept.car"), "", strings.NewReader("car"), art)
+	if err != nil {
+		t.Fatal(err)
+	}
+	art.remove()
+	if _, err := os.Stat(carPath); err != nil {
+		t.Fatal(err)
+	}
+}
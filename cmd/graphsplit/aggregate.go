@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filedrive-team/go-graphsplit"
+	"github.com/urfave/cli/v2"
+)
+
+var aggregateCmd = &cli.Command{
+	Name:      "aggregate",
+	Usage:     "Combine CAR files into one aggregate piece of a sector size with PODSI (FRC-0058) inclusion proofs of every sub-piece",
+	ArgsUsage: "<car files or car dirs...>",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "size",
+			Value: "32GiB",
+			Usage: "padded size of the aggregate piece, a power of two like the sector size",
+		},
+		&cli.StringFlag{
+			Name:     "manifest",
+			Required: true,
+			Usage:    "write the aggregate pieceCID, the offsets and inclusion proofs of the sub-pieces as JSON to this file",
+		},
+		&cli.StringFlag{
+			Name:  "out",
+			Usage: "write the data of the aggregate piece, unpadded, to this file",
+		},
+		&cli.StringFlag{
+			Name:  "cache",
+			Usage: "look up and remember the pieceCIDs of the CAR files in this file, see commP --cache",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.Args().Len() == 0 {
+			return fmt.Errorf("no CAR files to aggregate")
+		}
+		size, err := sizeFlag(c, "size")
+		if err != nil {
+			return err
+		}
+		var cache *graphsplit.CommPCache
+		if p := c.String("cache"); p != "" {
+			if cache, err = graphsplit.OpenCommPCache(p, false); err != nil {
+				return err
+			}
+			defer cache.Close()
+		}
+		m, err := graphsplit.AggregateCars(context.Background(), c.Args().Slice(), abi.PaddedPieceSize(size), cache)
+		if err != nil {
+			return err
+		}
+		if out := c.String("out"); out != "" {
+			f, err := os.Create(out)
+			if err != nil {
+				return err
+			}
+			if err := graphsplit.WriteAggregate(f, m); err != nil {
+				f.Close()
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+		}
+		data, err := json.MarshalIndent(m, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(c.String("manifest"), append(data, '\n'), 0o644); err != nil {
+			return err
+		}
+		fmt.Printf("PieceCID: %s, PieceSize: %d, Pieces: %d\n", m.PieceCid, m.PieceSize, len(m.Pieces))
+		return nil
+	},
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/filedrive-team/go-graphsplit"
+	"github.com/urfave/cli/v2"
+)
+
+var batchCmd = &cli.Command{
+	Name:  "batch",
+	Usage: "Manage batches of pieces handed to storage providers",
+	Subcommands: []*cli.Command{
+		batchListCmd,
+		batchCloseCmd,
+		batchExportCmd,
+	},
+}
+
+var carDirFlag = &cli.StringFlag{
+	Name:     "car-dir",
+	Required: true,
+	Usage:    "specify CAR directory holding manifest.csv and batches.json",
+}
+
+var batchListCmd = &cli.Command{
+	Name:  "list",
+	Usage: "List batches",
+	Flags: []cli.Flag{carDirFlag},
+	Action: func(c *cli.Context) error {
+		bs, err := graphsplit.OpenBatchStore(c.String("car-dir"), 0)
+		if err != nil {
+			return err
+		}
+		for _, b := range bs.Batches {
+			state := "open"
+			if b.Closed {
+				state = "closed"
+			}
+			fmt.Printf("%d\t%s\t%d pieces\t%s\n", b.ID, state, len(b.Pieces), b.CreatedAt.Format("2006-01-02 15:04:05"))
+		}
+		return nil
+	},
+}
+
+var batchCloseCmd = &cli.Command{
+	Name:  "close",
+	Usage: "Close the open batch, following pieces go to a new batch",
+	Flags: []cli.Flag{carDirFlag},
+	Action: func(c *cli.Context) error {
+		bs, err := graphsplit.OpenBatchStore(c.String("car-dir"), 0)
+		if err != nil {
+			return err
+		}
+		b, err := bs.Close()
+		if err != nil {
+			return err
+		}
+		if b == nil {
+			return fmt.Errorf("there is no open batch")
+		}
+		fmt.Printf("batch %d closed with %d pieces\n", b.ID, len(b.Pieces))
+		return nil
+	},
+}
+
+var batchExportCmd = &cli.Command{
+	Name:  "export",
+	Usage: "Export manifest rows of a batch as CSV",
+	Flags: []cli.Flag{
+		carDirFlag,
+		&cli.IntFlag{
+			Name:     "id",
+			Required: true,
+			Usage:    "specify batch id",
+		},
+		&cli.StringFlag{
+			Name:  "output",
+			Usage: "specify output file, default is stdout",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		carDir := c.String("car-dir")
+		id := c.Int("id")
+		bs, err := graphsplit.OpenBatchStore(carDir, 0)
+		if err != nil {
+			return err
+		}
+		b := bs.Get(id)
+		if b == nil {
+			return fmt.Errorf("batch %d does not exist", id)
+		}
+		if !b.Closed {
+			log.Warnf("batch %d is still open", id)
+		}
+
+		var w io.Writer = os.Stdout
+		if out := c.String("output"); out != "" {
+			f, err := os.Create(out)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		count, err := graphsplit.ExportBatch(carDir, id, w)
+		if err != nil {
+			return err
+		}
+		log.Infof("exported %d pieces of batch %d", count, id)
+		return nil
+	},
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/filedrive-team/go-graphsplit"
+	"github.com/filedrive-team/go-graphsplit/commp"
+	"github.com/urfave/cli/v2"
+)
+
+var benchCmd = &cli.Command{
+	Name:  "bench",
+	Usage: "Measure the throughput of graphsplit on this host",
+	Subcommands: []*cli.Command{
+		benchCommPCmd,
+	},
+}
+
+var benchCommPCmd = &cli.Command{
+	Name:  "commp",
+	Usage: "Report the pieceCID throughput of every commP implementation and the sha256 instructions they use",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "size",
+			Value: "1GiB",
+			Usage: "bytes of random data hashed by each implementation",
+		},
+		&cli.IntFlag{
+			Name:  "parallel",
+			Value: runtime.NumCPU(),
+			Usage: "goroutines hashing leaves, like chunk --hash-workers",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		size, err := sizeFlag(c, "size")
+		if err != nil {
+			return err
+		}
+		if c.Int("parallel") <= 0 {
+			return fmt.Errorf("Unexpected! Parallel has to be greater than 0")
+		}
+		fmt.Printf("cpus: %d, %s/%s, sha256: %s\n", runtime.NumCPU(), runtime.GOOS, runtime.GOARCH, commp.Implementation())
+		results, err := graphsplit.BenchCommP(size, c.Int("parallel"))
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "IMPLEMENTATION\tSIZE\tTIME\tTHROUGHPUT")
+		for _, r := range results {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s/s\n", r.Name, units.BytesSize(float64(r.Bytes)), r.Duration.Round(time.Millisecond), units.BytesSize(r.Throughput()))
+		}
+		return tw.Flush()
+	},
+}
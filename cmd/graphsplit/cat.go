@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/filedrive-team/go-graphsplit"
+	"github.com/ipfs/go-cid"
+	"github.com/urfave/cli/v2"
+)
+
+var catCmd = &cli.Command{
+	Name:      "cat",
+	Usage:     "Write a single file of a CAR file or car dir to stdout, the parts of a file split across slices in order",
+	ArgsUsage: "<car-path> <path or cid>",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "decrypt",
+			Usage: "key file to decrypt files chunked with encrypt-key",
+		},
+		&cli.StringFlag{
+			Name:  "read-rate",
+			Usage: "throttle reads of CAR files, bytes per second, e.g. 200MiB",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.Args().Len() != 2 {
+			return fmt.Errorf("expect a car path and the path or cid of a file")
+		}
+		carPath, target := c.Args().Get(0), c.Args().Get(1)
+		readRate, err := sizeFlag(c, "read-rate")
+		if err != nil {
+			return err
+		}
+		opts := []graphsplit.RestoreOption{graphsplit.WithRestoreReadRate(readRate)}
+		if keyFile := c.String("decrypt"); keyFile != "" {
+			dec, err := graphsplit.LoadKeyFile(keyFile)
+			if err != nil {
+				return err
+			}
+			opts = append(opts, graphsplit.WithDecryption(dec))
+		}
+		if root, err := cid.Decode(target); err == nil {
+			return graphsplit.RestoreCid(context.Background(), carPath, root, "-", opts...)
+		}
+		opts = append(opts, graphsplit.WithRestorePaths(target))
+		return graphsplit.RestoreFileTo(context.Background(), carPath, os.Stdout, opts...)
+	},
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/filedrive-team/go-graphsplit"
+	"github.com/urfave/cli/v2"
+)
+
+var cleanCmd = &cli.Command{
+	Name:  "clean",
+	Usage: "Remove unfinished writes, orphan checksum files and staging directories left in a car dir by failed runs",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "car-dir",
+			Required: true,
+			Usage:    "directory holding the CAR files",
+		},
+		&cli.BoolFlag{
+			Name:  "unlisted",
+			Usage: "remove carfiles and piece files without a manifest row too",
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "only list what would be removed",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		removed, err := graphsplit.CleanCarDir(c.String("car-dir"), c.Bool("unlisted"), c.Bool("dry-run"))
+		if err != nil {
+			return err
+		}
+		for _, p := range removed {
+			fmt.Println(p)
+		}
+		if c.Bool("dry-run") {
+			fmt.Printf("%d files would be removed\n", len(removed))
+		} else {
+			fmt.Printf("removed %d files\n", len(removed))
+		}
+		return nil
+	},
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestCommPPrinter(t *testing.T) {
+	rows := []commPRow{
+		{Path: "a.car", PayloadCid: "bafy1", PieceCid: "baga1", PieceSize: 254, PaddedSize: 256, CarSize: 200},
+		{Path: "b.car", PayloadCid: "bafy2", PieceCid: "baga2", PieceSize: 508, PaddedSize: 512, CarSize: 400},
+	}
+	render := func(format string, rows ...commPRow) string {
+		var buf bytes.Buffer
+		p, err := newCommPPrinter(&buf, format, "1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, row := range rows {
+			if err := p.print(row); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return buf.String()
+	}
+
+	if out := render("text", rows[0]); out != "a.car PieceCID: baga1, PieceSize: 254\n" {
+		t.Fatalf("unexpected text %q", out)
+	}
+
+	// a single CAR is printed without the path column
+	want := "payloadCid,pieceCid,pieceSize,paddedPieceSize,carSize\nbafy1,baga1,254,256,200\n"
+	if out := render("csv", commPRow{PayloadCid: "bafy1", PieceCid: "baga1", PieceSize: 254, PaddedSize: 256, CarSize: 200}); out != want {
+		t.Fatalf("unexpected csv %q", out)
+	}
+	want = "path,payloadCid,pieceCid,pieceSize,paddedPieceSize,carSize\na.car,bafy1,baga1,254,256,200\nb.car,bafy2,baga2,508,512,400\n"
+	if out := render("csv", rows...); out != want {
+		t.Fatalf("expected the csv header once, got %q", out)
+	}
+
+	lines := strings.Split(strings.TrimSpace(render("json", rows...)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected one json object per line, got %q", lines)
+	}
+	var got commPRow
+	if err := json.Unmarshal([]byte(lines[1]), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != rows[1] {
+		t.Fatalf("expected %+v, got %+v", rows[1], got)
+	}
+	if !strings.Contains(lines[0], `"paddedPieceSize":256`) {
+		t.Fatalf("unexpected json keys %s", lines[0])
+	}
+
+	if _, err := newCommPPrinter(&bytes.Buffer{}, "xml", "1"); err == nil {
+		t.Fatal("expected an error for an unknown output")
+	}
+}
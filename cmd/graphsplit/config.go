@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/BurntSushi/toml"
+	"github.com/filedrive-team/go-graphsplit"
+	"github.com/filedrive-team/go-graphsplit/config"
+	"github.com/urfave/cli/v2"
+)
+
+var configCmd = &cli.Command{
+	Name:  "config",
+	Usage: "Check config files",
+	Subcommands: []*cli.Command{
+		configValidateCmd,
+	},
+}
+
+var configValidateCmd = &cli.Command{
+	Name:      "validate",
+	Usage:     "Check a config file, or the defaults without one, with GRAPHSPLIT_* environment variables and --set applied, and print the effective config",
+	ArgsUsage: "[config path]",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "profile",
+			Usage:   "apply a profile of the config like chunk --profile",
+			EnvVars: []string{"GRAPHSPLIT_PROFILE"},
+		},
+		&cli.StringSliceFlag{
+			Name:  "set",
+			Usage: "override a config value like chunk --set",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() > 1 {
+			return fmt.Errorf("expect at most one config path")
+		}
+		cfg, err := loadConfig(c.Args().First(), c.String("profile"), c.StringSlice("set"))
+		if err != nil {
+			return err
+		}
+		return toml.NewEncoder(os.Stdout).Encode(cfg)
+	},
+}
+
+// configFlags are the flags of chunk overriding a config key, they are
+// applied after --set.
+var configFlags = []struct{ flag, key string }{
+	{"slice-size", "SliceSize"},
+	{"extra-file-path", "ExtraFilePath"},
+	{"extra-file-size", "ExtraFileSizeInOnePiece"},
+}
+
+// configFlagSets returns --set and the config flags of c as key=value pairs.
+func configFlagSets(c *cli.Context) []string {
+	sets := c.StringSlice("set")
+	for _, f := range configFlags {
+		if c.IsSet(f.flag) {
+			sets = append(sets, f.key+"="+c.String(f.flag))
+		}
+	}
+	return sets
+}
+
+// loadConfig loads the config file at path, or the defaults when path is
+// empty, applies the profile, the GRAPHSPLIT_* environment variables and
+// then the key=value pairs of sets, and validates the result.
+func loadConfig(path, profile string, sets []string) (*config.Config, error) {
+	cfg := config.NewConfig()
+	if path != "" {
+		var err error
+		if cfg, err = config.LoadConfig(path); err != nil {
+			return nil, fmt.Errorf("failed to load config file(%s): %v", path, err)
+		}
+	}
+	if err := cfg.ApplyProfile(profile); err != nil {
+		return nil, err
+	}
+	if err := cfg.ApplyEnv(); err != nil {
+		return nil, err
+	}
+	if err := cfg.ApplySets(sets); err != nil {
+		return nil, err
+	}
+	errs := []error{cfg.Validate()}
+	if cfg.SliceSizeRange != "" && cfg.SliceSizeStrategy != "" && cfg.SliceSizeStrategy != graphsplit.SliceSizeFixed {
+		errs = append(errs, fmt.Errorf("SliceSizeRange can not be combined with SliceSizeStrategy %s", cfg.SliceSizeStrategy))
+	}
+	if _, err := graphsplit.ParseSliceSizeStrategy(cfg.SliceSizeStrategy, int64(cfg.SliceSize), cfg.SliceSizes); err != nil {
+		errs = append(errs, err)
+	}
+	registered := make(map[string]bool)
+	for _, name := range graphsplit.RegisteredCallbacks() {
+		registered[name] = true
+	}
+	for _, cb := range cfg.Callbacks {
+		if cb.Name != "" && !registered[cb.Name] {
+			errs = append(errs, fmt.Errorf("unknown callback %q, registered are %v", cb.Name, graphsplit.RegisteredCallbacks()))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		if path == "" {
+			return nil, fmt.Errorf("invalid config:\n%w", err)
+		}
+		return nil, fmt.Errorf("invalid config file(%s):\n%w", path, err)
+	}
+	return cfg, nil
+}
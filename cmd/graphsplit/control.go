@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/filedrive-team/go-graphsplit"
+	"github.com/urfave/cli/v2"
+)
+
+var controlCmd = &cli.Command{
+	Name:      "control",
+	Usage:     "Pause, resume, abort or reload the config of a running chunk through its control socket",
+	ArgsUsage: "<pause|pause-slice|resume|abort|reload|status>",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "socket",
+			Required: true,
+			Usage:    "specify the --control-socket of the chunk run",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		state, err := graphsplit.SendControl(c.Context, c.String("socket"), c.Args().First())
+		if err != nil {
+			return err
+		}
+		fmt.Println(state)
+		return nil
+	},
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/filedrive-team/go-graphsplit"
+	"github.com/urfave/cli/v2"
+)
+
+// daemonJobCmds are the commands run for the job types of the daemon.
+var daemonJobCmds = map[string]*cli.Command{
+	graphsplit.JobChunk:  chunkCmd,
+	graphsplit.JobCommP:  commpCmd,
+	graphsplit.JobVerify: verifyCmd,
+}
+
+// daemonStopTimeout is how long a canceled job may take to finish its
+// current slice before it is killed.
+const daemonStopTimeout = 10 * time.Minute
+
+var daemonCmd = &cli.Command{
+	Name:  "daemon",
+	Usage: "Run chunk, commP and verify jobs submitted over an HTTP API",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "listen",
+			Value: "127.0.0.1:8090",
+			Usage: "specify listen address",
+		},
+		&cli.StringFlag{
+			Name:  "grpc-listen",
+			Usage: "also serve the API over gRPC on this address, see proto/graphsplit/daemon/v1/daemon.proto",
+		},
+		&cli.StringFlag{
+			Name:     "log-dir",
+			Required: true,
+			Usage:    "directory keeping the output of every job as <id>.log",
+		},
+		&cli.IntFlag{
+			Name:  "max-jobs",
+			Value: 1,
+			Usage: "number of jobs running at a time, the others wait in a queue",
+		},
+		&cli.StringFlag{
+			Name:    "token",
+			EnvVars: []string{"GRAPHSPLIT_DAEMON_TOKEN"},
+			Usage:   "require this bearer token with every request",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		exe, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		ctx, stop := signalContext()
+		defer stop()
+		health := graphsplit.NewHealth(stop)
+		d, err := graphsplit.NewDaemon(graphsplit.DaemonConfig{
+			LogDir:   c.String("log-dir"),
+			MaxJobs:  c.Int("max-jobs"),
+			Token:    c.String("token"),
+			Validate: validateJob,
+			Run:      execJob(exe),
+			Health:   health,
+		})
+		if err != nil {
+			return err
+		}
+		srv := &http.Server{Addr: c.String("listen"), Handler: d}
+		if addr := c.String("grpc-listen"); addr != "" {
+			ln, err := net.Listen("tcp", addr)
+			if err != nil {
+				return err
+			}
+			gsrv := graphsplit.NewDaemonGRPCServer(d)
+			defer gsrv.Stop()
+			go func() {
+				if err := gsrv.Serve(ln); err != nil {
+					log.Errorf("grpc on %s: %s", addr, err)
+				}
+			}()
+			log.Infof("daemon serving gRPC on %s", addr)
+		}
+		// the API keeps serving, with /readyz failing, until the jobs finished
+		// their current slice
+		stopped := make(chan error, 1)
+		go func() {
+			<-ctx.Done()
+			health.Drain()
+			log.Info("stopping the daemon and its jobs")
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), daemonStopTimeout)
+			defer cancel()
+			stopped <- d.Shutdown(shutdownCtx)
+			srv.Close()
+		}()
+		log.Infof("daemon listening on %s", srv.Addr)
+		health.SetReady(true)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			return err
+		}
+		return <-stopped
+	},
+}
+
+// validateJob checks that the args of req are flags of its command.
+func validateJob(req graphsplit.JobRequest) error {
+	cmd := daemonJobCmds[req.Type]
+	known := make(map[string]bool)
+	for _, f := range cmd.Flags {
+		for _, name := range f.Names() {
+			known[name] = true
+		}
+	}
+	for name := range req.Args {
+		if !known[name] {
+			return fmt.Errorf("unknown %s flag %q", req.Type, name)
+		}
+	}
+	if req.Type == graphsplit.JobChunk && req.Input == "" {
+		return fmt.Errorf("chunk job needs an input")
+	}
+	return nil
+}
+
+// jobArgs returns the command line of job.
+func jobArgs(job graphsplit.Job) []string {
+	args := []string{daemonJobCmds[job.Type].Name}
+	names := make([]string, 0, len(job.Args))
+	for name := range job.Args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		args = append(args, fmt.Sprintf("--%s=%s", name, job.Args[name]))
+	}
+	if job.Type == graphsplit.JobChunk {
+		if job.Args["control-socket"] == "" && job.ControlSocket != "" {
+			args = append(args, "--control-socket="+job.ControlSocket)
+		}
+		args = append(args, "--progress-json", job.Input)
+	}
+	return args
+}
+
+// execJob runs every job as a child process of exe, canceled jobs get
+// SIGTERM and stop like an interrupted command.
+func execJob(exe string) graphsplit.JobRunner {
+	return func(ctx context.Context, job graphsplit.Job, out io.Writer, progress func(json.RawMessage)) error {
+		cmd := exec.CommandContext(ctx, exe, jobArgs(job)...)
+		cmd.Cancel = func() error {
+			return cmd.Process.Signal(syscall.SIGTERM)
+		}
+		cmd.WaitDelay = daemonStopTimeout
+		cmd.Stderr = out
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, strings.Join(cmd.Args, " "))
+		if err := cmd.Start(); err != nil {
+			return err
+		}
+		sc := bufio.NewScanner(stdout)
+		for sc.Scan() {
+			line := sc.Bytes()
+			if job.Type == graphsplit.JobChunk && json.Valid(line) && bytes.HasPrefix(line, []byte("{")) {
+				progress(line)
+				continue
+			}
+			fmt.Fprintf(out, "%s\n", line)
+		}
+		return cmd.Wait()
+	}
+}
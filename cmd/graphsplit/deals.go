@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/filedrive-team/go-graphsplit"
+	"github.com/urfave/cli/v2"
+)
+
+var exportDealsCmd = &cli.Command{
+	Name:  "export-deals",
+	Usage: "Export the pieces of a car dir for offline deals with boost or venus droplet",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "car-dir",
+			Required: true,
+			Usage:    "directory holding the manifest and the CAR files",
+		},
+		&cli.StringFlag{
+			Name:     "format",
+			Required: true,
+			Usage:    "boost (commp, piece_size, car_size, payload_cid, http_url) or droplet (payload_cid, filename, piece_cid, payload_size, piece_size, url)",
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "write a JSON array instead of CSV",
+		},
+		&cli.StringFlag{
+			Name:  "url-template",
+			Usage: "the URL storage providers fetch a CAR file from, {name}, {payload_cid} and {piece_cid} are replaced, e.g. \"https://host/{name}\", defaults to the url column of the manifest",
+		},
+		&cli.BoolFlag{
+			Name:  "include-stale",
+			Usage: "also export the pieces incremental runs marked as stale",
+		},
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Usage:   "write to a file instead of stdout",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		records, err := graphsplit.DealRecords(c.String("car-dir"), c.String("url-template"), c.Bool("include-stale"))
+		if err != nil {
+			return err
+		}
+		var w io.Writer = os.Stdout
+		if path := c.String("output"); path != "" {
+			f, err := os.Create(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		if err := graphsplit.WriteDeals(w, c.String("format"), c.Bool("json"), records); err != nil {
+			return err
+		}
+		if c.String("output") != "" {
+			fmt.Printf("exported %d pieces to %s\n", len(records), c.String("output"))
+		}
+		return nil
+	},
+}
+
+var dealsCmd = &cli.Command{
+	Name:  "deals",
+	Usage: "Follow the deals of the pieces of a car dir",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "track",
+			Usage: "Look up the on chain deals of the pieces and record their ids, providers and activation epochs in the manifest and the manifest database",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "api",
+					Required: true,
+					Usage:    "JSON-RPC endpoint of a lotus or venus node, e.g. http://127.0.0.1:1234/rpc/v1",
+				},
+				&cli.StringFlag{
+					Name:    "token",
+					Usage:   "API token of the node",
+					EnvVars: []string{"GRAPHSPLIT_API_TOKEN"},
+				},
+				&cli.StringFlag{
+					Name:  "car-dir",
+					Usage: "track the pieces of the manifest of this car dir and record the deals in its chain_deals column",
+				},
+				&cli.StringFlag{
+					Name:  "db",
+					Usage: "record the deals in the deals table of this manifest database, its pieces are tracked without car-dir",
+				},
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "print as JSON",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if c.String("car-dir") == "" && c.String("db") == "" {
+					return fmt.Errorf("car-dir or db is required")
+				}
+				var db *graphsplit.ManifestDB
+				if path := c.String("db"); path != "" {
+					var err error
+					if db, err = graphsplit.OpenManifestDB(path); err != nil {
+						return err
+					}
+					defer db.Close()
+				}
+				deals, err := graphsplit.TrackDeals(c.Context, c.String("api"), c.String("token"), c.String("car-dir"), db)
+				if err != nil {
+					return err
+				}
+				if c.Bool("json") {
+					enc := json.NewEncoder(os.Stdout)
+					enc.SetIndent("", "  ")
+					return enc.Encode(deals)
+				}
+				tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(tw, "DEAL ID\tPIECE CID\tPROVIDER\tSTATE\tACTIVATION EPOCH\tEND EPOCH")
+				for _, d := range deals {
+					fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%d\t%d\n", d.DealID, d.PieceCid, d.Provider, d.State, d.ActivationEpoch, d.EndEpoch)
+				}
+				return tw.Flush()
+			},
+		},
+	},
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/filedrive-team/go-graphsplit"
+	"github.com/urfave/cli/v2"
+)
+
+var diffCmd = &cli.Command{
+	Name:      "diff",
+	Usage:     "Report the files and blocks added, removed or changed between two CAR files or two car dirs",
+	ArgsUsage: "<old car-path> <new car-path>",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "blocks",
+			Usage: "list the cids of the added and removed blocks, not only their number",
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "print as JSON",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.Args().Len() != 2 {
+			return fmt.Errorf("expect two CAR files or car dirs")
+		}
+		diff, err := graphsplit.DiffGraphs(context.Background(), c.Args().Get(0), c.Args().Get(1))
+		if err != nil {
+			return err
+		}
+		if c.Bool("json") {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(diff)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, f := range diff.Files {
+			switch f.Status {
+			case graphsplit.DiffAdded:
+				fmt.Fprintf(tw, "+\t%s\t%d\t%s\n", f.Path, f.NewSize, f.NewCid)
+			case graphsplit.DiffRemoved:
+				fmt.Fprintf(tw, "-\t%s\t%d\t%s\n", f.Path, f.OldSize, f.OldCid)
+			default:
+				fmt.Fprintf(tw, "~\t%s\t%d -> %d\t%s -> %s\n", f.Path, f.OldSize, f.NewSize, f.OldCid, f.NewCid)
+			}
+		}
+		tw.Flush()
+		for _, p := range diff.AddedPayloads {
+			fmt.Printf("+ payload %s\n", p)
+		}
+		for _, p := range diff.RemovedPayloads {
+			fmt.Printf("- payload %s\n", p)
+		}
+		if c.Bool("blocks") {
+			for _, b := range diff.AddedBlocks {
+				fmt.Printf("+ block %s\n", b)
+			}
+			for _, b := range diff.RemovedBlocks {
+				fmt.Printf("- block %s\n", b)
+			}
+		}
+		fmt.Printf("files: %d changed, blocks: %d added, %d removed, %d shared\n", len(diff.Files), len(diff.AddedBlocks), len(diff.RemovedBlocks), diff.SharedBlocks)
+		return nil
+	},
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/filedrive-team/go-graphsplit"
+	"github.com/urfave/cli/v2"
+)
+
+var healthListenFlag = &cli.StringFlag{
+	Name:  "health-listen",
+	Usage: "serve /healthz, /readyz and POST /drain on this address, e.g. :8092. Draining stops after the current slice",
+}
+
+// startHealth serves the probes on --health-listen, drain stops the command.
+// The returned Health is nil without the flag.
+func startHealth(c *cli.Context, drain func()) (*graphsplit.Health, func(), error) {
+	addr := c.String("health-listen")
+	if addr == "" {
+		return nil, func() {}, nil
+	}
+	health := graphsplit.NewHealth(drain)
+	srv, err := graphsplit.ListenHealth(addr, health)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to listen for health probes: %v", err)
+	}
+	return health, func() { srv.Close() }, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/filedrive-team/go-graphsplit"
+	"github.com/urfave/cli/v2"
+)
+
+var inspectCmd = &cli.Command{
+	Name:      "inspect",
+	Usage:     "Show roots, version, block count, sizes, top-level entries and manifest row of a CAR file",
+	ArgsUsage: "<file.car>",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "print as JSON",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.Args().Len() != 1 {
+			return fmt.Errorf("expect the path of one CAR file")
+		}
+		ci, err := graphsplit.InspectCar(c.Args().First())
+		if err != nil {
+			return err
+		}
+		if c.Bool("json") {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(ci)
+		}
+		fmt.Printf("car version:   %d\n", ci.Version)
+		for _, root := range ci.Roots {
+			fmt.Printf("root:          %s\n", root)
+		}
+		fmt.Printf("blocks:        %d\n", ci.Blocks)
+		fmt.Printf("payload size:  %d\n", ci.PayloadSize)
+		fmt.Printf("car size:      %d\n", ci.CarSize)
+		if len(ci.Entries) > 0 {
+			fmt.Println()
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "NAME\tTYPE\tSIZE\tCID")
+			for _, e := range ci.Entries {
+				fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", e.Name, e.Type, e.Size, e.Cid)
+			}
+			tw.Flush()
+		}
+		if ci.Manifest != nil {
+			fmt.Println()
+			fmt.Println("manifest row:")
+			for _, col := range []string{"payload_cid", "filename", "piece_cid", "payload_size", "piece_size", "slice_size", "batch_id", "block_order", "compression", "car_size", "root_path"} {
+				if v := ci.Manifest[col]; v != "" {
+					fmt.Printf("  %-13s %s\n", col+":", v)
+				}
+			}
+		}
+		return nil
+	},
+}
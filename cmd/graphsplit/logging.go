@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/urfave/cli/v2"
+	"go.uber.org/zap"
+)
+
+var logFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "log-level",
+		Value: "info",
+		Usage: "minimum level of the logs: debug, info, warn or error",
+	},
+	&cli.StringFlag{
+		Name:  "log-format",
+		Value: "text",
+		Usage: "text, or json for one JSON object per line with the graph name, slice index, payload cid and durations of slices as fields",
+	},
+	&cli.StringFlag{
+		Name:  "log-file",
+		Usage: "write the logs to this file instead of stderr",
+	},
+	&cli.StringFlag{
+		Name:  "log-max-size",
+		Value: "100MiB",
+		Usage: "rotate the log file when it grows beyond this size, 0 never rotates it",
+	},
+	&cli.IntFlag{
+		Name:  "log-max-backups",
+		Value: 5,
+		Usage: "number of rotated log files kept, as <log-file>.1 (newest) to <log-file>.N",
+	},
+}
+
+// logFile is the log file of --log-file, handed to zap by the sink of
+// rotateScheme.
+var logFile *rotatingFile
+
+const rotateScheme = "graphsplit-log"
+
+func init() {
+	if err := zap.RegisterSink(rotateScheme, func(*url.URL) (zap.Sink, error) {
+		if logFile == nil {
+			return nil, fmt.Errorf("log file is not open")
+		}
+		return logFile, nil
+	}); err != nil {
+		panic(err)
+	}
+}
+
+func setupLogging(c *cli.Context) error {
+	lvl, err := logging.LevelFromString(c.String("log-level"))
+	if err != nil {
+		return fmt.Errorf("invalid log-level %s: %v", c.String("log-level"), err)
+	}
+	cfg := logging.Config{Level: lvl, Format: logging.GetConfig().Format}
+	switch c.String("log-format") {
+	case "text":
+	case "json":
+		cfg.Format = logging.JSONOutput
+	default:
+		return fmt.Errorf("unknown log-format %s, expected text or json", c.String("log-format"))
+	}
+	if path := c.String("log-file"); path != "" {
+		maxSize, err := sizeFlag(c, "log-max-size")
+		if err != nil {
+			return err
+		}
+		if cfg.Format == logging.ColorizedOutput {
+			cfg.Format = logging.PlaintextOutput
+		}
+		logFile, err = openRotatingFile(path, maxSize, c.Int("log-max-backups"))
+		if err != nil {
+			return err
+		}
+		cfg.URL = rotateScheme + ":"
+	} else {
+		cfg.Stderr = true
+	}
+	logging.SetupLogging(cfg)
+	return nil
+}
+
+// rotatingFile is a log file which is renamed to path.1, path.1 to path.2 and
+// so on once it grows beyond maxSize, keeping maxBackups of them.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	mu         sync.Mutex
+	f          *os.File
+	size       int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	rf := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f, rf.size = f, fi.Size()
+	return nil
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to rotate %s: %s\n", rf.path, err)
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// rotate moves the log file to path.1 and opens a new one, which is opened
+// again even if moving the backups failed.
+func (rf *rotatingFile) rotate() error {
+	rf.f.Close()
+	var err error
+	if rf.maxBackups <= 0 {
+		err = os.Remove(rf.path)
+	} else {
+		os.Remove(fmt.Sprintf("%s.%d", rf.path, rf.maxBackups))
+		for i := rf.maxBackups - 1; i > 0 && err == nil; i-- {
+			err = os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+			if os.IsNotExist(err) {
+				err = nil
+			}
+		}
+		if err == nil {
+			err = os.Rename(rf.path, rf.path+".1")
+		}
+	}
+	if openErr := rf.open(); openErr != nil {
+		return openErr
+	}
+	return err
+}
+
+func (rf *rotatingFile) Sync() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.f.Sync()
+}
+
+func (rf *rotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.f.Close()
+}
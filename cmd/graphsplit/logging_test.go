@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/urfave/cli/v2"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "graphsplit.log")
+	rf, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := rf.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rf.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Fatalf("%s holds %q, expected %q", name, data, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("expected only 2 backups, got %v", err)
+	}
+}
+
+// runLogging runs setupLogging with the log flags of args.
+func runLogging(args ...string) error {
+	app := &cli.App{
+		Flags:  logFlags,
+		Action: setupLogging,
+	}
+	return app.Run(append([]string{"graphsplit"}, args...))
+}
+
+func TestSetupLogging(t *testing.T) {
+	defer logging.SetupLogging(logging.Config{Level: logging.LevelInfo, Stderr: true})
+
+	if err := runLogging("--log-level", "loud"); err == nil {
+		t.Fatal("expected an error for an unknown level")
+	}
+	if err := runLogging("--log-format", "xml"); err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+
+	path := filepath.Join(t.TempDir(), "graphsplit.log")
+	if err := runLogging("--log-level", "warn", "--log-format", "json", "--log-file", path); err != nil {
+		t.Fatal(err)
+	}
+	l := logging.Logger("logtest")
+	l.Info("dropped below the level")
+	l.Warnw("slice done", "payload_cid", "bafytest")
+	if err := logFile.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	if len(lines) != 1 || strings.Contains(string(data), "dropped") {
+		t.Fatalf("expected one warning in the log file, got %q", data)
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal(lines[0], &entry); err != nil {
+		t.Fatalf("expected a JSON line: %s", err)
+	}
+	if entry["msg"] != "slice done" || entry["payload_cid"] != "bafytest" {
+		t.Fatalf("unexpected entry %v", entry)
+	}
+}
@@ -2,15 +2,25 @@ package main
 
 import (
 	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/docker/go-units"
+	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filedrive-team/go-graphsplit"
 	"github.com/filedrive-team/go-graphsplit/config"
 	"github.com/filedrive-team/go-graphsplit/dataset"
+	"github.com/ipfs/go-cid"
 	logging "github.com/ipfs/go-log/v2"
 	"github.com/urfave/cli/v2"
 )
@@ -18,22 +28,49 @@ import (
 var log = logging.Logger("graphsplit")
 
 func main() {
-	logging.SetLogLevel("*", "INFO")
 	local := []*cli.Command{
 		chunkCmd,
 		restoreCmd,
 		commpCmd,
 		importDatasetCmd,
+		batchCmd,
+		servePieceCmd,
+		pieceInfoCmd,
+		controlCmd,
+		repackCmd,
+		splitCarCmd,
+		mergeCarCmd,
+		recoverCmd,
+		mountCmd,
+		inspectCmd,
+		verifyCmd,
+		diffCmd,
+		catCmd,
+		aggregateCmd,
+		verifyPieceCmd,
+		benchCmd,
+		manifestDBCmd,
+		manifestCmd,
+		cleanCmd,
+		daemonCmd,
+		queueCmd,
+		partitionCmd,
+		workerCmd,
+		configCmd,
+		exportDealsCmd,
+		dealsCmd,
 	}
 
 	app := &cli.App{
 		Name:     "graphsplit",
+		Flags:    logFlags,
+		Before:   setupLogging,
 		Commands: local,
 	}
 
 	if err := app.Run(os.Args); err != nil {
 		fmt.Println("Error: ", err)
-		os.Exit(1)
+		os.Exit(exitCode(err))
 	}
 }
 
@@ -42,24 +79,31 @@ var chunkCmd = &cli.Command{
 	Usage: "Generate CAR files of the specified size",
 	Flags: []cli.Flag{
 		&cli.UintFlag{
-			Name:  "parallel",
-			Value: 2,
-			Usage: "specify how many number of goroutines runs when generate file node",
+			Name:    "parallel",
+			Value:   0,
+			Usage:   "specify how many number of goroutines runs when generate file node, 0 picks it from cpu count, storage type and file sizes",
+			EnvVars: []string{"GRAPHSPLIT_PARALLEL"},
 		},
 		&cli.StringFlag{
-			Name:     "graph-name",
-			Required: true,
-			Usage:    "specify graph name",
+			Name:    "graph-name",
+			Usage:   "specify graph name, required unless the --profile has a GraphName",
+			EnvVars: []string{"GRAPHSPLIT_GRAPH_NAME"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "car-dir",
+			Usage:   "specify output CAR directory, repeat it to spread CAR files across several directories, manifest.csv is kept in the first one. Required unless the --profile has a CarDir",
+			EnvVars: []string{"GRAPHSPLIT_CAR_DIR"},
 		},
 		&cli.StringFlag{
-			Name:     "car-dir",
-			Required: true,
-			Usage:    "specify output CAR directory",
+			Name:  "car-dir-policy",
+			Value: string(graphsplit.CarDirRoundRobin),
+			Usage: "how CAR files are spread across car dirs, round-robin or free-space",
 		},
 		&cli.StringFlag{
-			Name:  "parent-path",
-			Value: "",
-			Usage: "specify graph parent path",
+			Name:    "parent-path",
+			Value:   "",
+			Usage:   "specify graph parent path",
+			EnvVars: []string{"GRAPHSPLIT_PARENT_PATH"},
 		},
 		&cli.BoolFlag{
 			Name:  "save-manifest",
@@ -67,14 +111,16 @@ var chunkCmd = &cli.Command{
 			Usage: "create a mainfest.csv in car-dir to save mapping of data-cids and slice names",
 		},
 		&cli.BoolFlag{
-			Name:  "calc-commp",
-			Value: true,
-			Usage: "create a mainfest.csv in car-dir to save mapping of data-cids, slice names, piece-cids and piece-sizes",
+			Name:    "calc-commp",
+			Value:   true,
+			Usage:   "create a mainfest.csv in car-dir to save mapping of data-cids, slice names, piece-cids and piece-sizes",
+			EnvVars: []string{"GRAPHSPLIT_CALC_COMMP"},
 		},
 		&cli.BoolFlag{
-			Name:  "rename",
-			Value: false,
-			Usage: "rename carfile to piece",
+			Name:    "rename",
+			Value:   false,
+			Usage:   "rename carfile to piece",
+			EnvVars: []string{"GRAPHSPLIT_RENAME"},
 		},
 		&cli.BoolFlag{
 			Name:  "random-rename-source-file",
@@ -82,18 +128,86 @@ var chunkCmd = &cli.Command{
 			Usage: "random rename source file name",
 		},
 		&cli.BoolFlag{
-			Name:  "add-padding",
+			Name:    "add-padding",
+			Value:   false,
+			Usage:   "add padding to carfile in order to convert it to piece file",
+			EnvVars: []string{"GRAPHSPLIT_ADD_PADDING"},
+		},
+		&cli.IntFlag{
+			Name:  "manifest-version",
+			Value: 1,
+			Usage: "schema of manifest.csv, 2 adds car_file, car_file_size, sha256, padded_piece_size, files, created_at and url columns for offline deal importers",
+		},
+		&cli.StringFlag{
+			Name:  "manifest-format",
+			Value: "csv",
+			Usage: "format of the manifest in car-dir: csv (manifest.csv), json (manifest.json) or ndjson (manifest.ndjson, a JSON object per line)",
+		},
+		&cli.StringFlag{
+			Name:  "file-provenance",
+			Usage: "record the path, size, cid and byte range of every source file and the piece it landed in to files.csv (csv) or files.ndjson (ndjson) in car-dir",
+		},
+		&cli.StringFlag{
+			Name:  "manifest-db",
+			Usage: "also record pieces, the files they contain and their status in this SQLite database, see graphsplit manifest-db",
+		},
+		&cli.StringFlag{
+			Name:  "manifest-url",
+			Usage: "url column of a version 2 manifest, {name}, {payload_cid} and {piece_cid} are replaced",
+		},
+		&cli.BoolFlag{
+			Name:  "piece-file",
 			Value: false,
-			Usage: "add padding to carfile in order to convert it to piece file",
+			Usage: "keep carfile named after the payload cid and write the padded piece next to it as <piece-cid>.piece",
+		},
+		&cli.IntFlag{
+			Name:  "output-sharding",
+			Usage: "write carfiles to subdirectories of car-dir named with this many characters, 0 keeps car-dir flat",
+		},
+		&cli.StringFlag{
+			Name:  "output-sharding-key",
+			Value: "cid",
+			Usage: "name of the subdirectories, cid uses the next to last characters of the piece cid (the payload cid without calc-commp), index puts every 1000 consecutive slices in a directory",
+		},
+		&cli.StringFlag{
+			Name:  "car-name-template",
+			Usage: "name carfiles after a template, {graph}, {index}, {payload_cid}, {piece_cid} and {piece_size} are replaced and take a printf format, e.g. \"{graph}-{index:05d}-{payload_cid}.car\"",
+		},
+		&cli.BoolFlag{
+			Name:  "keep-tmp",
+			Usage: "keep the partial carfiles of a slice which failed, for debugging",
 		},
 		&cli.StringFlag{
 			Name:    "config",
-			Usage:   "config file path",
+			Usage:   "config file path, optional, without it the defaults are used with the environment variables and flags below",
 			Aliases: []string{"c"},
+			EnvVars: []string{"GRAPHSPLIT_CONFIG"},
+		},
+		&cli.StringFlag{
+			Name:    "profile",
+			Usage:   "use a profile of the config, its SourcePath, ParentPath, CarDir, GraphName, UploadS3 and UploadHTTP are the defaults of the input path and the flags, its other values override the config",
+			EnvVars: []string{"GRAPHSPLIT_PROFILE"},
+		},
+		&cli.StringFlag{
+			Name:  "slice-size",
+			Usage: "override SliceSize of the config, e.g. 30GiB",
+		},
+		&cli.StringFlag{
+			Name:  "extra-file-path",
+			Usage: "override ExtraFilePath of the config, the directory of extra files added to every piece",
+		},
+		&cli.StringFlag{
+			Name:  "extra-file-size",
+			Usage: "override ExtraFileSizeInOnePiece of the config, e.g. 500MiB",
+		},
+		&cli.StringSliceFlag{
+			Name:  "set",
+			Usage: "override a config value, e.g. --set SliceSize=32GiB or --set retry.max_attempts=3, lists are comma separated. Flags override GRAPHSPLIT_* environment variables, which override the config file",
 		},
 		&cli.BoolFlag{
-			Name:  "loop",
-			Usage: "loop chunking",
+			Name:    "loop",
+			Usage:   "loop chunking",
+			EnvVars: []string{"GRAPHSPLIT_LOOP"},
 		},
 		&cli.BoolFlag{
 			Name:  "random-select-file",
@@ -104,72 +218,534 @@ var chunkCmd = &cli.Command{
 			Name:  "skip-filename",
 			Usage: "manifest csv detail not contain filename",
 		},
+		&cli.StringFlag{
+			Name:  "max-memory",
+			Usage: "bound the memory used to build a graph slice, e.g. 48GiB, workers wait for memory instead of running out of it",
+		},
+		&cli.StringFlag{
+			Name:  "read-rate",
+			Usage: "throttle reads of source files, bytes per second, e.g. 200MiB",
+		},
+		&cli.StringFlag{
+			Name:  "write-rate",
+			Usage: "throttle writes of CAR files, bytes per second, e.g. 200MiB",
+		},
+		&cli.IntFlag{
+			Name:  "batch-size",
+			Usage: "group produced pieces into batches of this many pieces, see the batch command",
+		},
+		&cli.BoolFlag{
+			Name:  "incremental",
+			Usage: "only chunk files that are new or changed since they were packed, pieces of changed files are flagged as stale in pack-state.json of car-dir",
+		},
+		&cli.BoolFlag{
+			Name:  "incremental-checksum",
+			Usage: "with --incremental, record sha256 of packed files and only treat files whose content changed as changed",
+		},
+		&cli.UintFlag{
+			Name:  "hash-workers",
+			Value: 0,
+			Usage: "specify how many goroutines hash the blocks of a file and compute the piece cid, 0 uses the cpu count",
+		},
+		&cli.StringFlag{
+			Name:  "min-free-space",
+			Usage: "keep this much space free in car-dir, e.g. 10GiB, chunking stops before a slice would use it up",
+		},
+		&cli.BoolFlag{
+			Name:  "dedup-extra",
+			Usage: "hash extra files and dataset files of the same size, extra files duplicating dataset content are reported and not used as filler",
+		},
+		&cli.StringFlag{
+			Name:  "block-order",
+			Value: "dfs",
+			Usage: "order of blocks in CAR files, dfs writes them in depth-first traversal order, stream in the order they were built",
+		},
+		&cli.StringSliceFlag{
+			Name:  "checksum",
+			Usage: "digest of every CAR written to a <car>.<algo> sidecar and the manifest, sha256 or blake3, can be repeated",
+		},
+		&cli.StringFlag{
+			Name:  "compress",
+			Value: "none",
+			Usage: "compress CAR files at rest, zstd writes <car>.zst, restore and commP decompress them transparently",
+		},
+		&cli.StringFlag{
+			Name:  "encrypt-key",
+			Usage: "encrypt file data with AES-256-GCM before building the DAG, the key file holds 32 raw or 64 hex encoded bytes",
+		},
+		&cli.StringFlag{
+			Name:  "post-piece-hook",
+			Usage: "command run through sh after each CAR file is finalized, {car}, {piece_cid}, {payload_cid} and {piece_size} are replaced by quoted values, e.g. \"upload.sh {car} {piece_cid}\"",
+		},
+		&cli.StringFlag{
+			Name:  "upload-s3",
+			Usage: "upload every finished CAR to bucket/prefix of S3 compatible storage, credentials are read from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY",
+		},
+		&cli.StringFlag{
+			Name:  "s3-endpoint",
+			Value: "https://s3.amazonaws.com",
+			Usage: "specify S3 endpoint, e.g. http://127.0.0.1:9000 for MinIO",
+		},
+		&cli.StringFlag{
+			Name:  "s3-region",
+			Value: "us-east-1",
+			Usage: "specify S3 region",
+		},
+		&cli.StringFlag{
+			Name:  "s3-part-size",
+			Value: "64MiB",
+			Usage: "size of multipart upload parts",
+		},
+		&cli.StringFlag{
+			Name:  "upload-http",
+			Usage: "upload every finished CAR to this URL template, {name}, {payload_cid}, {piece_cid} and {piece_size} are replaced, e.g. \"https://archive.example.com/pieces/{piece_cid}\"",
+		},
+		&cli.StringFlag{
+			Name:  "upload-http-method",
+			Value: "PUT",
+			Usage: "HTTP method of uploads, PUT or POST",
+		},
+		&cli.StringSliceFlag{
+			Name:  "upload-http-header",
+			Usage: "header sent with uploads as \"Name: value\", values are templated like the URL, can be repeated",
+		},
+		&cli.StringFlag{
+			Name:    "upload-http-token",
+			Usage:   "bearer token sent with uploads",
+			EnvVars: []string{"GRAPHSPLIT_UPLOAD_TOKEN"},
+		},
+		&cli.IntFlag{
+			Name:  "upload-concurrency",
+			Value: 2,
+			Usage: "number of HTTP uploads in flight",
+		},
+		&cli.IntFlag{
+			Name:  "upload-retries",
+			Value: 3,
+			Usage: "number of times a failed HTTP upload is retried",
+		},
+		&cli.BoolFlag{
+			Name:  "expand-archives",
+			Usage: "chunk the files inside .tar, .tar.gz and .zip inputs below a directory named like the archive, without extracting them",
+		},
+		&cli.StringFlag{
+			Name:  "url-list",
+			Usage: "chunk the files of a list of HTTP(S) URLs instead of the input path, one URL per line optionally followed by its size",
+		},
+		&cli.StringFlag{
+			Name:  "s3-range-size",
+			Value: "16MiB",
+			Usage: "size of the ranged GETs objects are read with when the input path is s3://bucket/prefix",
+		},
+		&cli.IntFlag{
+			Name:  "s3-concurrency",
+			Value: 4,
+			Usage: "number of ranges of an object fetched ahead when the input path is s3://bucket/prefix",
+		},
+		&cli.BoolFlag{
+			Name:  "upload-padded",
+			Usage: "upload the padded piece instead of the CAR",
+		},
+		&cli.BoolFlag{
+			Name:  "delete-after-upload",
+			Usage: "delete the local CAR file once it is uploaded",
+		},
+		&cli.StringFlag{
+			Name:  "webhook",
+			Usage: "POST a JSON event to this URL after every slice and on errors, with the piece and payload CID, sizes, CAR path and duration",
+		},
+		&cli.StringSliceFlag{
+			Name:  "webhook-header",
+			Usage: "header sent with webhook events as \"Name: value\", can be repeated",
+		},
+		&cli.IntFlag{
+			Name:  "webhook-retries",
+			Value: 3,
+			Usage: "number of times a failed webhook post is retried",
+		},
+		&cli.BoolFlag{
+			Name:  "make-deals",
+			Usage: "propose a deal of every finished piece to the storage providers of the deals table of the config, runs the boost client binary which has to be installed (deals.boost), needs calc-commp",
+		},
+		&cli.BoolFlag{
+			Name:  "ipni",
+			Usage: "publish an IPNI advertisement of every finished piece to the indexers of the ipni table of the config, served by graphsplit serve on car-dir, needs calc-commp",
+		},
+		&cli.IntFlag{
+			Name:  "parity-group",
+			Usage: "generate Reed-Solomon parity pieces for every group of this many pieces, 0 disables parity pieces",
+		},
+		&cli.IntFlag{
+			Name:  "parity-pieces",
+			Value: 2,
+			Usage: "number of parity pieces per group, any parity-group pieces of a group recover the others",
+		},
+		&cli.StringFlag{
+			Name:  "control-socket",
+			Usage: "listen on this unix socket for the control command to pause, pause-slice, resume or abort the run",
+		},
+		&cli.StringFlag{
+			Name:  "metrics-listen",
+			Usage: "serve Prometheus metrics at /metrics on this address, e.g. :9090",
+		},
+		&cli.StringFlag{
+			Name:  "otlp-endpoint",
+			Usage: "export OpenTelemetry spans to this OTLP/HTTP collector, e.g. http://localhost:4318. OTEL_EXPORTER_OTLP_ENDPOINT is used if not set",
+		},
+		healthListenFlag,
+		&cli.BoolFlag{
+			Name:  "progress",
+			Usage: "draw a progress bar on stderr",
+		},
+		&cli.BoolFlag{
+			Name:  "progress-json",
+			Usage: "print the progress as a JSON line to stdout every second and after every slice",
+		},
+		&cli.StringFlag{
+			Name:  "on-file-error",
+			Value: "fail",
+			Usage: "what to do with an unreadable source file: fail stops the run, skip leaves it out of its slice, retry reads it again 3 times before skipping it. Skipped files are appended to file-errors.csv in car-dir",
+		},
+		&cli.StringFlag{
+			Name:  "partition-plan",
+			Usage: "chunk only the files of one partition of the plan written by partition plan",
+		},
+		&cli.IntFlag{
+			Name:  "partition",
+			Usage: "specify the index of the partition to chunk, starting at 0",
+		},
 	},
-	ArgsUsage: "<input path>",
+	ArgsUsage: "<input path, s3://bucket/prefix or - for stdin>",
 	Action: func(c *cli.Context) error {
-		ctx := context.Background()
+		ctx, stop := signalContext()
+		defer stop()
+		cfgPath := c.String("config")
+		sets := configFlagSets(c)
+		cfg, err := loadConfig(cfgPath, c.String("profile"), sets)
+		if err != nil {
+			return err
+		}
+		log.Infof("config file: %+v", cfg)
+		profile, err := cfg.Profile(c.String("profile"))
+		if err != nil {
+			return err
+		}
 		parallel := c.Uint("parallel")
-		parentPath := c.String("parent-path")
-		carDir := c.String("car-dir")
-		graphName := c.String("graph-name")
+		if !c.IsSet("parallel") {
+			parallel = uint(cfg.Parallel)
+		}
+		parentPath := stringOr(c.String("parent-path"), profile.ParentPath)
+		carDirs := c.StringSlice("car-dir")
+		if len(carDirs) == 0 && profile.CarDir != "" {
+			carDirs = []string{profile.CarDir}
+		}
+		if len(carDirs) == 0 {
+			return fmt.Errorf("car-dir is required")
+		}
+		carDir := carDirs[0]
+		graphName := stringOr(c.String("graph-name"), profile.GraphName)
+		if graphName == "" {
+			return fmt.Errorf("graph-name is required")
+		}
+		input := stringOr(c.Args().First(), profile.SourcePath)
 		randomRenameSourceFile := c.Bool("random-rename-source-file")
 		randomSelectFile := c.Bool("random-select-file")
 		skipFilename := c.Bool("skip-filename")
+		hashWorkers := int(c.Uint("hash-workers"))
+		if hashWorkers == 0 {
+			hashWorkers = runtime.NumCPU()
+		}
+		maxMemory, err := sizeFlag(c, "max-memory")
+		if err != nil {
+			return err
+		}
+		readRate, err := sizeFlag(c, "read-rate")
+		if err != nil {
+			return err
+		}
+		writeRate, err := sizeFlag(c, "write-rate")
+		if err != nil {
+			return err
+		}
+		minFreeSpace, err := sizeFlag(c, "min-free-space")
+		if err != nil {
+			return err
+		}
+		blockOrder, err := graphsplit.ParseBlockOrder(c.String("block-order"))
+		if err != nil {
+			return err
+		}
 		if !graphsplit.ExistDir(carDir) {
 			return fmt.Errorf("the path of car-dir does not exist")
 		}
 
-		cfgPath := c.String("config")
-		if cfgPath == "" {
-			return fmt.Errorf("config file path is required")
+		strategy, err := graphsplit.ParseSliceSizeStrategy(cfg.SliceSizeStrategy, int64(cfg.SliceSize), cfg.SliceSizes)
+		if err != nil {
+			return err
 		}
-
-		cfg, err := config.LoadConfig(cfgPath)
+		sliceSize, maxSliceSize, err := nextSliceSize(cfg, strategy, carDir)
 		if err != nil {
-			return fmt.Errorf("failed to load config file(%s): %v", cfgPath, err)
+			return err
 		}
-		log.Infof("config file: %+v", cfg)
+		log.Infof("slice size: %d-%d, strategy: %s", sliceSize, maxSliceSize, strategy.Kind)
 
-		log.Infof("old slice size: %d", cfg.SliceSize)
-		cfg.SliceSize++
-		sliceSize := cfg.SliceSize
-		log.Infof("new slice size: %d", sliceSize)
-		if sliceSize <= 0 {
-			return fmt.Errorf("slice size has been set as %v", sliceSize)
+		extraFileSliceSize, err := extraFileSize(cfg)
+		if err != nil {
+			return err
 		}
-		err = cfg.SaveConfig(cfgPath)
+		if maxSliceSize+int(extraFileSliceSize) > 32*graphsplit.Gib {
+			return fmt.Errorf("slice size %d + extra file slice size %d exceeds 32 GiB", maxSliceSize, extraFileSliceSize)
+		}
+		log.Infof("extra file slice size: %d, random rename source file: %v, random select file: %v", extraFileSliceSize, randomRenameSourceFile, randomSelectFile)
+		log.Infof("skip filename: %v", skipFilename)
+		ef, err := graphsplit.NewExtraFile(strings.TrimSuffix(cfg.ExtraFilePath, "/"), int64(extraFileSliceSize), int64(maxSliceSize), randomRenameSourceFile)
+		if err != nil {
+			return err
+		}
+
+		retry, err := retryPolicy(cfg.Retry)
 		if err != nil {
-			return fmt.Errorf("failed to save config file: %v", err)
+			return err
 		}
 
-		var extraFileSliceSize int64
-		if len(cfg.ExtraFilePath) != 0 {
-			if cfg.ExtraFileSizeInOnePiece == "" {
-				return fmt.Errorf("extra file size in one piece is required when extra file path is set")
+		targetPath := strings.TrimSuffix(input, "/")
+		cbOpts := []graphsplit.CallbackOption{
+			graphsplit.WithWriteRate(writeRate),
+			graphsplit.WithCommPWorkers(hashWorkers),
+			graphsplit.WithPostPieceHook(c.String("post-piece-hook")),
+			graphsplit.WithRetry(retry),
+		}
+		checksums, err := graphsplit.ParseChecksums(c.StringSlice("checksum"))
+		if err != nil {
+			return err
+		}
+		if len(checksums) > 0 {
+			cbOpts = append(cbOpts, graphsplit.WithChecksums(checksums))
+		}
+		compression, err := graphsplit.ParseCompression(c.String("compress"))
+		if err != nil {
+			return err
+		}
+		if compression != "" {
+			cbOpts = append(cbOpts, graphsplit.WithCompression(compression))
+		}
+		manifestFormat, err := graphsplit.ParseManifestFormat(c.String("manifest-format"))
+		if err != nil {
+			return err
+		}
+		cbOpts = append(cbOpts, graphsplit.WithManifestFormat(manifestFormat))
+		if format := c.String("file-provenance"); format != "" {
+			provenance, err := graphsplit.ParseManifestFormat(format)
+			if err != nil {
+				return err
+			}
+			if provenance == graphsplit.ManifestJSON {
+				return fmt.Errorf("file provenance is written as csv or ndjson")
+			}
+			cbOpts = append(cbOpts, graphsplit.WithFileProvenance(provenance))
+		}
+		if path := c.String("manifest-db"); path != "" {
+			db, err := graphsplit.OpenManifestDB(path)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+			cbOpts = append(cbOpts, graphsplit.WithManifestDB(db))
+		}
+		switch version := c.Int("manifest-version"); version {
+		case 1:
+		case 2:
+			if err := graphsplit.CheckManifestVersion(carDir, version); err != nil {
+				return err
+			}
+			cbOpts = append(cbOpts, graphsplit.WithManifestVersion(version), graphsplit.WithManifestURL(c.String("manifest-url")))
+		default:
+			return fmt.Errorf("unsupported manifest version %d", version)
+		}
+		if c.Bool("piece-file") {
+			if c.Bool("rename") {
+				return fmt.Errorf("--piece-file and --rename can't be used together")
 			}
-			extraFileSliceSize, err = units.RAMInBytes(cfg.ExtraFileSizeInOnePiece)
+			cbOpts = append(cbOpts, graphsplit.WithPieceFiles())
+		}
+		if template := c.String("car-name-template"); template != "" {
+			carName, err := graphsplit.ParseCarNameTemplate(template)
 			if err != nil {
-				return fmt.Errorf("failed to parse real file size: %v", err)
+				return err
+			}
+			if c.Bool("rename") {
+				return fmt.Errorf("--car-name-template and --rename can't be used together")
+			}
+			if carName.NeedsPieceCid() && !c.Bool("calc-commp") {
+				return fmt.Errorf("{piece_cid} and {piece_size} of --car-name-template need --calc-commp")
 			}
+			cbOpts = append(cbOpts, graphsplit.WithCarNameTemplate(carName))
 		}
-		if sliceSize+int(extraFileSliceSize) > 32*graphsplit.Gib {
-			return fmt.Errorf("slice size %d + extra file slice size %d exceeds 32 GiB", sliceSize, extraFileSliceSize)
+		if width := c.Int("output-sharding"); width != 0 {
+			key, err := graphsplit.ParseShardKey(c.String("output-sharding-key"))
+			if err != nil {
+				return err
+			}
+			if width < 0 || width > 8 {
+				return fmt.Errorf("output-sharding has to be between 0 and 8")
+			}
+			cbOpts = append(cbOpts, graphsplit.WithOutputSharding(width, key))
 		}
-		log.Infof("extra file slice size: %d, random rename source file: %v, random select file: %v", extraFileSliceSize, randomRenameSourceFile, randomSelectFile)
-		log.Infof("skip filename: %v", skipFilename)
-		ef, err := graphsplit.NewExtraFile(strings.TrimSuffix(cfg.ExtraFilePath, "/"), int64(extraFileSliceSize), int64(sliceSize), randomRenameSourceFile)
-		if err != nil {
-			return err
+		if c.Bool("keep-tmp") {
+			cbOpts = append(cbOpts, graphsplit.WithKeepTmp())
 		}
-
-		targetPath := strings.TrimSuffix(c.Args().First(), "/")
-		var cb graphsplit.GraphBuildCallback
+		var outDirs *graphsplit.CarDirs
+		if dirs := append(carDirs, cfg.CarDirs...); len(dirs) > 1 {
+			outDirs, err = graphsplit.NewCarDirs(dirs, graphsplit.CarDirPolicy(c.String("car-dir-policy")), minFreeSpace)
+			if err != nil {
+				return err
+			}
+			cbOpts = append(cbOpts, graphsplit.WithCarDirs(outDirs))
+		}
+		if batchSize := c.Int("batch-size"); batchSize > 0 {
+			batches, err := graphsplit.OpenBatchStore(carDir, batchSize)
+			if err != nil {
+				return err
+			}
+			cbOpts = append(cbOpts, graphsplit.WithBatches(batches))
+		}
+		// the callback writing the CAR file comes first, the others read it
+		var cbs []graphsplit.GraphBuildCallback
 		if c.Bool("calc-commp") {
-			cb = graphsplit.CommPCallback(carDir, c.Bool("rename"), c.Bool("add-padding"))
+			cbs = append(cbs, graphsplit.CommPCallback(carDir, c.Bool("rename"), c.Bool("add-padding"), cbOpts...))
 		} else if c.Bool("save-manifest") {
-			cb = graphsplit.CSVCallback(carDir)
+			cbs = append(cbs, graphsplit.CSVCallback(carDir, cbOpts...))
 		} else {
-			cb = graphsplit.ErrCallback()
+			cbs = append(cbs, graphsplit.ErrCallback())
+		}
+		var parity *graphsplit.ParityCallback
+		if group := c.Int("parity-group"); group > 0 {
+			parity, err = graphsplit.NewParityCallback(carDir, group, c.Int("parity-pieces"))
+			if err != nil {
+				return err
+			}
+			cbs = append(cbs, parity)
+		}
+		if target := stringOr(c.String("upload-s3"), profile.UploadS3); target != "" {
+			s3Cfg := graphsplit.S3ConfigFromEnv(target, c.String("s3-endpoint"), c.String("s3-region"))
+			if s3Cfg.PartSize, err = sizeFlag(c, "s3-part-size"); err != nil {
+				return err
+			}
+			if retry.MaxAttempts > 1 {
+				s3Cfg.Retries, s3Cfg.RetryBackoff = retry.MaxAttempts-1, retry.Backoff
+			}
+			client, err := graphsplit.NewS3Client(s3Cfg)
+			if err != nil {
+				return err
+			}
+			cbs = append(cbs, graphsplit.S3UploadCallback(carDir, client, c.Bool("upload-padded"), c.Bool("delete-after-upload")))
+		}
+		var uploader *graphsplit.HTTPUploader
+		if target := stringOr(c.String("upload-http"), profile.UploadHTTP); target != "" {
+			headers := make(map[string]string)
+			for _, h := range c.StringSlice("upload-http-header") {
+				name, value, ok := strings.Cut(h, ":")
+				if !ok {
+					return fmt.Errorf("invalid upload header %q", h)
+				}
+				headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+			}
+			uploader, err = graphsplit.HTTPUploadCallback(carDir, graphsplit.HTTPUploadConfig{
+				URL:         target,
+				Method:      c.String("upload-http-method"),
+				Headers:     headers,
+				Token:       c.String("upload-http-token"),
+				Concurrency: c.Int("upload-concurrency"),
+				Retries:     c.Int("upload-retries"),
+				Padded:      c.Bool("upload-padded"),
+			})
+			if err != nil {
+				return err
+			}
+			defer uploader.Wait()
+			cbs = append(cbs, uploader)
+		}
+		if cfg.DB != "" {
+			if !c.Bool("calc-commp") && !c.Bool("save-manifest") {
+				return fmt.Errorf("DB needs the manifest, enable calc-commp or save-manifest")
+			}
+			dbCb, err := dataset.NewDBCallback(ctx, carDir, cfg.DB, cfg.DBName, cfg.DBTable)
+			if err != nil {
+				return fmt.Errorf("failed to connect to DB: %v", err)
+			}
+			defer dbCb.Close()
+			cbs = append(cbs, dbCb)
+		}
+		if c.Bool("make-deals") {
+			if !c.Bool("calc-commp") {
+				return fmt.Errorf("make-deals needs the piece cid, enable calc-commp")
+			}
+			// HTTP uploads finish in the background, the URL is not in the manifest yet
+			if uploader != nil && !cfg.Deals.Offline && cfg.Deals.URL == "" {
+				return fmt.Errorf("deals.url is required for online deals with upload-http")
+			}
+			dealer, err := graphsplit.BoostDealCallback(carDir, graphsplit.BoostDealConfig{
+				Providers: cfg.Deals.Providers,
+				Price:     cfg.Deals.Price,
+				Duration:  cfg.Deals.Duration,
+				Wallet:    cfg.Deals.Wallet,
+				Verified:  cfg.Deals.Verified,
+				Offline:   cfg.Deals.Offline,
+				URL:       cfg.Deals.URL,
+				Boost:     cfg.Deals.Boost,
+			})
+			if err != nil {
+				return err
+			}
+			cbs = append(cbs, dealer)
+		}
+		if c.Bool("ipni") {
+			if !c.Bool("calc-commp") {
+				return fmt.Errorf("ipni needs the piece cid as context id, enable calc-commp")
+			}
+			publisher, err := graphsplit.IPNICallback(carDir, graphsplit.IPNIConfig{
+				Announce:      cfg.IPNI.Announce,
+				Publisher:     cfg.IPNI.Publisher,
+				Addrs:         cfg.IPNI.Addrs,
+				Metadata:      cfg.IPNI.Metadata,
+				VerifiedDeal:  cfg.IPNI.VerifiedDeal,
+				FastRetrieval: cfg.IPNI.FastRetrieval,
+				Topic:         cfg.IPNI.Topic,
+			})
+			if err != nil {
+				return err
+			}
+			log.Infof("publishing IPNI advertisements as %s", publisher.PeerID())
+			cbs = append(cbs, publisher)
+		}
+		webhook := graphsplit.WebhookConfig{URL: c.String("webhook"), Retries: c.Int("webhook-retries")}
+		if webhook.URL != "" {
+			webhook.Headers = make(map[string]string)
+			for _, h := range c.StringSlice("webhook-header") {
+				name, value, ok := strings.Cut(h, ":")
+				if !ok {
+					return fmt.Errorf("invalid webhook header %q", h)
+				}
+				webhook.Headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+			}
+		}
+		// newCallback puts the registered callbacks of the config after cbs,
+		// they are replaced when the config is reloaded
+		newCallback := func(registered []graphsplit.GraphBuildCallback) (graphsplit.GraphBuildCallback, error) {
+			cb := graphsplit.MultiCallback(append(cbs[:len(cbs):len(cbs)], registered...)...)
+			if webhook.URL == "" {
+				return cb, nil
+			}
+			return graphsplit.WebhookCallback(cb, carDir, webhook)
+		}
+		registered, err := newRegisteredCallbacks(cfg, carDir)
+		if err != nil {
+			return err
+		}
+		defer func() { closeCallbacks(registered) }()
+		cb, err := newCallback(registered)
+		if err != nil {
+			return err
 		}
 
 		params := graphsplit.ChunkParams{
@@ -184,31 +760,239 @@ var chunkCmd = &cli.Command{
 			RandomRenameSourceFile: randomRenameSourceFile,
 			RandomSelectFile:       randomSelectFile,
 			SkipFilename:           skipFilename,
+			MaxMemory:              maxMemory,
+			MaxSliceSize:           int64(maxSliceSize),
+			ReadRate:               readRate,
+			HashWorkers:            hashWorkers,
+			MinFreeSpace:           minFreeSpace,
+			DedupExtra:             c.Bool("dedup-extra"),
+			BlockOrder:             blockOrder,
+			CarDirs:                outDirs,
+			ExpandArchives:         c.Bool("expand-archives"),
+			Retry:                  retry,
 		}
-
-		loop := c.Bool("loop")
-		fmt.Println("loop: ", loop)
-		if !loop {
-			fmt.Println("chunking once...")
-			return graphsplit.Chunk(ctx, &params)
+		if keyFile := c.String("encrypt-key"); keyFile != "" {
+			if params.Encryptor, err = graphsplit.LoadKeyFile(keyFile); err != nil {
+				return err
+			}
 		}
-		fmt.Println("loop chunking...")
+		if list := c.String("url-list"); list != "" {
+			if params.Source, err = graphsplit.NewHTTPListSource(list); err != nil {
+				return err
+			}
+		} else if input == "-" {
+			if c.Bool("loop") || c.Bool("incremental") {
+				return fmt.Errorf("stdin can not be chunked in loop or incremental mode")
+			}
+			params.Stream = os.Stdin
+		} else if target, ok := graphsplit.ParseS3URL(input); ok {
+			rangeSize, err := sizeFlag(c, "s3-range-size")
+			if err != nil {
+				return err
+			}
+			s3Cfg := graphsplit.S3ConfigFromEnv(target, c.String("s3-endpoint"), c.String("s3-region"))
+			if retry.MaxAttempts > 1 {
+				s3Cfg.Retries, s3Cfg.RetryBackoff = retry.MaxAttempts-1, retry.Backoff
+			}
+			client, err := graphsplit.NewS3Client(s3Cfg)
+			if err != nil {
+				return err
+			}
+			params.Source = graphsplit.NewS3Source(client, rangeSize, c.Int("s3-concurrency"))
+		}
+		if c.Bool("incremental") {
+			if params.ExpandArchives && c.Bool("incremental-checksum") {
+				return fmt.Errorf("incremental-checksum can not be used with expand-archives")
+			}
+			params.State, err = graphsplit.OpenPackState(carDir, c.Bool("incremental-checksum"))
+			if err != nil {
+				return err
+			}
+		}
+		if path := c.String("partition-plan"); path != "" {
+			plan, err := graphsplit.LoadPartitionPlan(path)
+			if err != nil {
+				return err
+			}
+			if params.Partition, err = plan.Partition(c.Int("partition")); err != nil {
+				return err
+			}
+		}
+
+		params.Progress = progressReporter(c)
+		if params.OnFileError, err = graphsplit.ParseFileErrorPolicy(c.String("on-file-error")); err != nil {
+			return err
+		}
+
+		params.Control = graphsplit.NewController()
+		notifyPause(ctx, params.Control)
+		notifyReload(ctx, params.Control)
+		go params.Control.WatchPauseFile(ctx, carDir, 5*time.Second)
+		if c.Bool("loop") && cfgPath != "" {
+			go watchConfigFile(ctx, cfgPath, 5*time.Second, params.Control)
+		}
+		params.Reload = func(params *graphsplit.ChunkParams) error {
+			reloaded, err := loadConfig(cfgPath, c.String("profile"), sets)
+			if err != nil {
+				return err
+			}
+			changed := make(map[string]bool)
+			for _, key := range changedKeys(cfg, reloaded) {
+				if reloadable[key] {
+					changed[key] = true
+				} else {
+					log.Warnf("%s of the config changed, it takes a restart of chunk", key)
+				}
+			}
+			if len(changed) == 0 {
+				log.Info("reloaded the config, nothing to apply")
+				cfg = reloaded
+				return nil
+			}
+			reloadedStrategy, err := graphsplit.ParseSliceSizeStrategy(reloaded.SliceSizeStrategy, int64(reloaded.SliceSize), reloaded.SliceSizes)
+			if err != nil {
+				return err
+			}
+			min, max := sliceSize, maxSliceSize
+			if changed["SliceSize"] || changed["SliceSizeRange"] || changed["SliceSizeStrategy"] || changed["SliceSizes"] {
+				if min, max, err = nextSliceSize(reloaded, reloadedStrategy, carDir); err != nil {
+					return err
+				}
+			}
+			extraSize, ef := extraFileSliceSize, params.Ef
+			if changed["ExtraFilePath"] || changed["ExtraFileSizeInOnePiece"] {
+				if extraSize, err = extraFileSize(reloaded); err != nil {
+					return err
+				}
+				if ef, err = graphsplit.NewExtraFile(strings.TrimSuffix(reloaded.ExtraFilePath, "/"), extraSize, int64(max), randomRenameSourceFile); err != nil {
+					return err
+				}
+			}
+			if max+int(extraSize) > 32*graphsplit.Gib {
+				return fmt.Errorf("slice size %d + extra file slice size %d exceeds 32 GiB", max, extraSize)
+			}
+			cb, reloadedCallbacks := params.Cb, registered
+			if changed["Callbacks"] {
+				if reloadedCallbacks, err = newRegisteredCallbacks(reloaded, carDir); err != nil {
+					return err
+				}
+				if cb, err = newCallback(reloadedCallbacks); err != nil {
+					closeCallbacks(reloadedCallbacks)
+					return err
+				}
+				// the previous callbacks finish their work first
+				closeCallbacks(registered)
+			}
+			cfg, strategy, registered = reloaded, reloadedStrategy, reloadedCallbacks
+			sliceSize, maxSliceSize, extraFileSliceSize = min, max, extraSize
+			params.ExpectSliceSize, params.MaxSliceSize = int64(min), int64(max)
+			params.Ef, params.Cb = ef, cb
+			if !c.IsSet("parallel") {
+				params.Parallel = cfg.Parallel
+			}
+			log.Infof("reloaded the config: slice size %d-%d, strategy %s, extra file slice size %d, parallel %d, %d callbacks",
+				min, max, strategy.Kind, extraSize, params.Parallel, len(registered))
+			return nil
+		}
+		if socket := c.String("control-socket"); socket != "" {
+			srv, err := graphsplit.ListenControl(socket, params.Control)
+			if err != nil {
+				return fmt.Errorf("failed to listen on control socket: %v", err)
+			}
+			defer srv.Close()
+		}
+		if addr := c.String("metrics-listen"); addr != "" {
+			srv, err := graphsplit.ListenMetrics(addr)
+			if err != nil {
+				return fmt.Errorf("failed to listen for metrics: %v", err)
+			}
+			defer srv.Close()
+		}
+		endTracing, err := startTracing(c)
+		if err != nil {
+			return err
+		}
+		defer endTracing()
+		health, endHealth, err := startHealth(c, stop)
+		if err != nil {
+			return err
+		}
+		defer endHealth()
+
+		if cfg.ManifestBackupDir != "" {
+			interval := time.Hour
+			if cfg.ManifestBackupInterval != "" {
+				interval, err = time.ParseDuration(cfg.ManifestBackupInterval)
+				if err != nil {
+					return fmt.Errorf("invalid manifest backup interval: %v", err)
+				}
+			}
+			backup := &graphsplit.ManifestBackup{Dir: cfg.ManifestBackupDir, Keep: cfg.ManifestBackupKeep}
+			go backup.Run(ctx, carDir, interval)
+			defer func() {
+				if err := backup.Snapshot(carDir); err != nil {
+					log.Errorf("failed to back up manifest: %s", err)
+				}
+			}()
+		}
+
+		health.SetReady(true)
+		loop := c.Bool("loop")
+		fmt.Println("loop: ", loop)
+		flushParity := func() error {
+			if parity == nil {
+				return nil
+			}
+			return parity.Flush(ctx)
+		}
+		if !loop {
+			fmt.Println("chunking once...")
+			if err := graphsplit.Chunk(ctx, &params); err != nil {
+				return err
+			}
+			return flushParity()
+		}
+		fmt.Println("loop chunking...")
 		for {
 			err = graphsplit.Chunk(ctx, &params)
+			if health.Draining() && (err == nil || errors.Is(err, context.Canceled)) {
+				log.Info("drained, stop loop chunking")
+				return flushParity()
+			}
+			if errors.Is(err, graphsplit.ErrInsufficientSpace) || errors.Is(err, graphsplit.ErrAborted) || errors.Is(err, context.Canceled) {
+				log.Errorf("stop loop chunking: %s", err)
+				return err
+			}
 			if err != nil {
 				return fmt.Errorf("failed to chunk: %v", err)
 			}
+			if uploader != nil {
+				uploader.Wait()
+			}
+			if err := flushParity(); err != nil {
+				return fmt.Errorf("failed to generate parity pieces: %v", err)
+			}
+			graphsplit.RecordLoopIteration()
 
-			sliceSize++
-			cfg.SliceSize = sliceSize
-			err = cfg.SaveConfig(cfgPath)
-			if err != nil {
-				return fmt.Errorf("failed to save config file: %v", err)
+			if sliceSize, maxSliceSize, err = nextSliceSize(cfg, strategy, carDir); err != nil {
+				return err
+			}
+			if maxSliceSize+int(extraFileSliceSize) > 32*graphsplit.Gib {
+				return fmt.Errorf("slice size %d + extra file slice size %d exceeds 32 GiB", maxSliceSize, extraFileSliceSize)
 			}
-			log.Infof("slice size has been set as %d", sliceSize)
+			params.ExpectSliceSize, params.MaxSliceSize = int64(sliceSize), int64(maxSliceSize)
+			log.Infof("slice size of the next run: %d-%d", sliceSize, maxSliceSize)
 
 			log.Infof("chunking completed! waiting for 60 seconds...")
-			<-time.After(60 * time.Second)
+			select {
+			case <-time.After(60 * time.Second):
+			case <-ctx.Done():
+				if health.Draining() {
+					log.Info("drained, stop loop chunking")
+					return nil
+				}
+				return ctx.Err()
+			}
 		}
 	},
 }
@@ -220,18 +1004,117 @@ var restoreCmd = &cli.Command{
 		&cli.StringFlag{
 			Name:     "car-path",
 			Required: true,
-			Usage:    "specify source car path, directory or file",
+			Usage:    "specify source car path, directory or file, s3://bucket/prefix or the http(s) URL of a car directory",
 		},
 		&cli.StringFlag{
-			Name:     "output-dir",
-			Required: true,
-			Usage:    "specify output directory",
+			Name:  "cache-dir",
+			Usage: "directory the CAR files of a remote car-path are fetched to, fetches continue where an interrupted restore stopped",
+		},
+		&cli.StringFlag{
+			Name:  "s3-endpoint",
+			Value: "https://s3.amazonaws.com",
+			Usage: "specify S3 endpoint, e.g. http://127.0.0.1:9000 for MinIO",
+		},
+		&cli.StringFlag{
+			Name:  "s3-region",
+			Value: "us-east-1",
+			Usage: "specify S3 region",
+		},
+		&cli.StringFlag{
+			Name:  "s3-range-size",
+			Value: "16MiB",
+			Usage: "size of the ranged GETs CAR files are read with when car-path is s3://bucket/prefix",
+		},
+		&cli.IntFlag{
+			Name:  "s3-concurrency",
+			Value: 4,
+			Usage: "number of ranges of a CAR file fetched ahead when car-path is s3://bucket/prefix",
+		},
+		&cli.StringFlag{
+			Name:  "output-dir",
+			Usage: "specify output directory",
+		},
+		&cli.BoolFlag{
+			Name:  "stdout",
+			Usage: "write the single file of the CAR files, or the one selected by path or cid, to stdout instead of output-dir",
 		},
 		&cli.IntFlag{
 			Name:  "parallel",
 			Value: 4,
 			Usage: "specify how many number of goroutines runs when generate file node",
 		},
+		&cli.StringFlag{
+			Name:  "read-rate",
+			Usage: "throttle reads of CAR files, bytes per second, e.g. 200MiB",
+		},
+		&cli.StringFlag{
+			Name:  "write-rate",
+			Usage: "throttle writes of restored files, bytes per second, e.g. 200MiB",
+		},
+		&cli.StringFlag{
+			Name:  "decrypt",
+			Usage: "key file of files encrypted by chunk --encrypt-key",
+		},
+		&cli.StringFlag{
+			Name:  "cid",
+			Usage: "restore only the file or directory of this cid, found in any CAR file of car-path, to output-dir/<cid>; output-dir - writes a file to stdout",
+		},
+		&cli.StringSliceFlag{
+			Name:  "path",
+			Usage: "restore only this file or directory, relative to output-dir, can be repeated",
+		},
+		&cli.BoolFlag{
+			Name:  "list",
+			Usage: "print the files of the CAR files (path, size, cid and CAR file) instead of restoring them",
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "print the list as JSON",
+		},
+		&cli.BoolFlag{
+			Name:  "verify",
+			Usage: "check every block against its cid and every restored file against the cid of its DAG, print the failures and exit with an error on any",
+		},
+		&cli.StringFlag{
+			Name:  "verify-report",
+			Usage: "write the results of verify for every file as JSON to this file",
+		},
+		&cli.BoolFlag{
+			Name:  "resume",
+			Usage: "skip the CAR files a previous, interrupted restore to output-dir has completed",
+		},
+		&cli.BoolFlag{
+			Name:  "repack-archives",
+			Usage: "pack the directories of archives expanded by chunk --expand-archives back into archive files",
+		},
+		&cli.BoolFlag{
+			Name:  "allow-missing",
+			Usage: "restore what is left of lost or corrupt CAR files, missing blocks and parts are zero filled where their size is known, exit with an error listing the unrecoverable files",
+		},
+		&cli.StringFlag{
+			Name:  "missing-report",
+			Usage: "write the unrecoverable files and ranges of allow-missing as JSON to this file",
+		},
+		&cli.BoolFlag{
+			Name:  "original-layout",
+			Usage: "restore every CAR file to the directory its parent-path was in, relative to the dataset root, from the root_path column of manifest.csv",
+		},
+		&cli.StringFlag{
+			Name:  "layout-root",
+			Usage: "the dataset root of original-layout, the deepest directory all recorded parent paths are below by default",
+		},
+		&cli.BoolFlag{
+			Name:  "progress",
+			Usage: "draw a progress bar on stderr",
+		},
+		&cli.BoolFlag{
+			Name:  "progress-json",
+			Usage: "print the progress as a JSON line to stdout every second and after every CAR file",
+		},
+		&cli.StringFlag{
+			Name:  "otlp-endpoint",
+			Usage: "export OpenTelemetry spans to this OTLP/HTTP collector, e.g. http://localhost:4318. OTEL_EXPORTER_OTLP_ENDPOINT is used if not set",
+		},
 	},
 	Action: func(c *cli.Context) error {
 		parallel := c.Int("parallel")
@@ -240,9 +1123,153 @@ var restoreCmd = &cli.Command{
 		if parallel <= 0 {
 			return fmt.Errorf("Unexpected! Parallel has to be greater than 0")
 		}
+		endTracing, err := startTracing(c)
+		if err != nil {
+			return err
+		}
+		defer endTracing()
+		toStdout := c.Bool("stdout") || outputDir == "-"
+		if outputDir == "" && !toStdout && !c.Bool("list") {
+			return fmt.Errorf("output-dir is required")
+		}
+		readRate, err := sizeFlag(c, "read-rate")
+		if err != nil {
+			return err
+		}
+		writeRate, err := sizeFlag(c, "write-rate")
+		if err != nil {
+			return err
+		}
+
+		opts := []graphsplit.RestoreOption{graphsplit.WithRestoreReadRate(readRate), graphsplit.WithRestoreWriteRate(writeRate)}
+		if keyFile := c.String("decrypt"); keyFile != "" {
+			dec, err := graphsplit.LoadKeyFile(keyFile)
+			if err != nil {
+				return err
+			}
+			opts = append(opts, graphsplit.WithDecryption(dec))
+		}
+		if paths := c.StringSlice("path"); len(paths) > 0 {
+			opts = append(opts, graphsplit.WithRestorePaths(paths...))
+		}
+		remote, err := openRemoteCars(c, carPath)
+		if err != nil {
+			return err
+		}
+		if remote != nil {
+			carPath = remote.Dir()
+			if c.Bool("list") || toStdout || c.String("cid") != "" {
+				// these look through the CAR files in turn
+				if err := remote.FetchAll(context.Background(), parallel); err != nil {
+					return err
+				}
+			} else {
+				opts = append(opts, graphsplit.WithRemoteCars(remote))
+			}
+		}
+		if c.Bool("list") {
+			entries, err := graphsplit.ListRestore(context.Background(), carPath, opts...)
+			if err != nil {
+				return err
+			}
+			return printRestoreList(os.Stdout, entries, c.Bool("json"))
+		}
+		if c.Bool("resume") {
+			if toStdout || c.String("cid") != "" || len(c.StringSlice("path")) > 0 {
+				return fmt.Errorf("resume only applies to complete restores to output-dir")
+			}
+			rs, err := graphsplit.OpenRestoreState(outputDir)
+			if err != nil {
+				return err
+			}
+			opts = append(opts, graphsplit.WithRestoreState(rs))
+		}
+		var report *graphsplit.VerifyReport
+		if c.Bool("verify") {
+			if toStdout || c.String("cid") != "" || len(c.StringSlice("path")) > 0 || c.Bool("resume") {
+				return fmt.Errorf("verify only applies to complete restores to output-dir")
+			}
+			report = graphsplit.NewVerifyReport()
+			opts = append(opts, graphsplit.WithVerify(report))
+		}
+		var missing *graphsplit.MissingReport
+		if c.Bool("allow-missing") {
+			if toStdout || c.String("cid") != "" || len(c.StringSlice("path")) > 0 || c.Bool("verify") || c.String("decrypt") != "" {
+				return fmt.Errorf("allow-missing only applies to complete restores to output-dir without verify and decrypt")
+			}
+			missing = graphsplit.NewMissingReport()
+			opts = append(opts, graphsplit.WithAllowMissing(missing))
+		}
+		if c.Bool("original-layout") {
+			if toStdout || c.String("cid") != "" || len(c.StringSlice("path")) > 0 {
+				return fmt.Errorf("original-layout only applies to complete restores to output-dir")
+			}
+			layout, err := graphsplit.OriginalLayout(carPath, c.String("layout-root"))
+			if err != nil {
+				return err
+			}
+			opts = append(opts, graphsplit.WithOriginalLayout(layout))
+		}
+		if s := c.String("cid"); s != "" {
+			root, err := cid.Decode(s)
+			if err != nil {
+				return fmt.Errorf("invalid cid %s: %v", s, err)
+			}
+			out := "-"
+			if !toStdout {
+				out = filepath.Join(outputDir, root.String())
+			}
+			return graphsplit.RestoreCid(context.Background(), carPath, root, out, opts...)
+		}
+		if toStdout {
+			return graphsplit.RestoreFileTo(context.Background(), carPath, os.Stdout, opts...)
+		}
 
-		graphsplit.CarTo(carPath, outputDir, parallel)
-		graphsplit.Merge(outputDir, parallel)
+		if reporter := progressReporter(c); reporter != nil {
+			opts = append(opts, graphsplit.WithRestoreProgress(reporter))
+		}
+		ctx, stop := signalContext()
+		defer stop()
+		opts = append(opts, graphsplit.WithRestoreContext(ctx))
+
+		var failures []graphsplit.RestoreFailure
+		collect := func(err error) error {
+			var re *graphsplit.RestoreError
+			if errors.As(err, &re) {
+				failures = append(failures, re.Failures...)
+				return nil
+			}
+			return err
+		}
+		if err := collect(graphsplit.CarTo(carPath, outputDir, parallel, opts...)); err != nil {
+			return err
+		}
+		mergeOpts := []graphsplit.RestoreOption{graphsplit.WithRestoreWriteRate(writeRate)}
+		if missing != nil {
+			mergeOpts = append(mergeOpts, graphsplit.WithAllowMissing(missing))
+		}
+		if err := collect(graphsplit.Merge(outputDir, parallel, mergeOpts...)); err != nil {
+			return err
+		}
+		printRestoreFailures(failures)
+		if report != nil {
+			if err := verifyRestore(c, report, carPath); err != nil {
+				return err
+			}
+		}
+		if c.Bool("repack-archives") {
+			if err := graphsplit.RepackArchives(outputDir); err != nil {
+				return err
+			}
+		}
+		if missing != nil {
+			if err := reportMissing(c, missing, carPath); err != nil {
+				return err
+			}
+		}
+		if len(failures) > 0 {
+			return fmt.Errorf("%d CAR files or merged files failed to restore", len(failures))
+		}
 
 		fmt.Println("completed!")
 		return nil
@@ -250,8 +1277,9 @@ var restoreCmd = &cli.Command{
 }
 
 var commpCmd = &cli.Command{
-	Name:  "commP",
-	Usage: "PieceCID and PieceSize calculation",
+	Name:      "commP",
+	Usage:     "PieceCID and PieceSize calculation",
+	ArgsUsage: "<car file, http(s):// or s3:// URL, - reads stdin>",
 	Flags: []cli.Flag{
 		&cli.BoolFlag{
 			Name:  "rename",
@@ -263,21 +1291,283 @@ var commpCmd = &cli.Command{
 			Value: false,
 			Usage: "add padding to carfile in order to convert it to piece file",
 		},
+		&cli.StringFlag{
+			Name:  "dir",
+			Usage: "compute the pieceCID of every .car file below this directory instead of a single file",
+		},
+		&cli.IntFlag{
+			Name:  "parallel",
+			Value: 2,
+			Usage: "number of pieceCIDs computed at a time with dir",
+		},
+		&cli.StringFlag{
+			Name:  "results",
+			Usage: "append the results of dir to this .csv or .json (one result per line) file, the CAR files it already holds are skipped",
+		},
+		&cli.StringFlag{
+			Name:  "output",
+			Value: "text",
+			Usage: "print results as text, json (one object per line) or csv",
+		},
+		&cli.StringFlag{
+			Name:  "piece-cid-version",
+			Value: "both",
+			Usage: "print the legacy pieceCID (1), the pieceCID v2 of FRC-0069 which encodes the payload size (2), or both",
+		},
+		&cli.StringFlag{
+			Name:  "cache",
+			Usage: "file caching the pieceCIDs of CAR files by path, size and modification time, unchanged files are not computed again",
+		},
+		&cli.BoolFlag{
+			Name:  "cache-by-hash",
+			Usage: "key the cache by the sha256 of the CAR files instead, which matches moved and copied files but reads every file",
+		},
+		&cli.StringFlag{
+			Name:  "s3-endpoint",
+			Value: "https://s3.amazonaws.com",
+			Usage: "specify S3 endpoint, e.g. http://127.0.0.1:9000 for MinIO",
+		},
+		&cli.StringFlag{
+			Name:  "s3-region",
+			Value: "us-east-1",
+			Usage: "specify S3 region",
+		},
+		&cli.StringFlag{
+			Name:  "range-size",
+			Value: "16MiB",
+			Usage: "size of the ranged GETs a CAR at an http(s):// or s3:// URL is streamed with",
+		},
+		&cli.IntFlag{
+			Name:  "concurrency",
+			Value: 4,
+			Usage: "number of ranges of a CAR at an http(s):// or s3:// URL fetched ahead",
+		},
+		&cli.StringFlag{
+			Name:  "otlp-endpoint",
+			Usage: "export OpenTelemetry spans to this OTLP/HTTP collector, e.g. http://localhost:4318. OTEL_EXPORTER_OTLP_ENDPOINT is used if not set",
+		},
 	},
 	Action: func(c *cli.Context) error {
-		ctx := context.Background()
+		ctx, stop := signalContext()
+		defer stop()
+		printer, err := newCommPPrinter(os.Stdout, c.String("output"), c.String("piece-cid-version"))
+		if err != nil {
+			return err
+		}
+		endTracing, err := startTracing(c)
+		if err != nil {
+			return err
+		}
+		defer endTracing()
+		var cache *graphsplit.CommPCache
+		if p := c.String("cache"); p != "" {
+			if c.Bool("rename") || c.Bool("add-padding") {
+				return fmt.Errorf("cache does not apply to rename and add-padding, they change the CAR files")
+			}
+			if cache, err = graphsplit.OpenCommPCache(p, c.Bool("cache-by-hash")); err != nil {
+				return err
+			}
+			defer cache.Close()
+		}
+		if dir := c.String("dir"); dir != "" {
+			return commPDir(ctx, c, dir, cache, printer)
+		}
 		targetPath := c.Args().First()
 
-		res, err := graphsplit.CalcCommP(ctx, targetPath, c.Bool("rename"), c.Bool("add-padding"))
+		stream, err := openCommPStream(ctx, c, targetPath)
 		if err != nil {
 			return err
 		}
-
-		fmt.Printf("PieceCID: %s, PieceSize: %d\n", res.Root, res.Size)
-		return nil
+		var res *graphsplit.CommPRet
+		if stream != nil {
+			defer stream.Close()
+			if c.Bool("rename") || c.Bool("add-padding") || cache != nil {
+				return fmt.Errorf("rename, add-padding and cache do not apply to %s", targetPath)
+			}
+			res, err = graphsplit.CalcCommPReader(stream)
+		} else {
+			res, err = cache.CalcCommP(ctx, targetPath, c.Bool("rename"), c.Bool("add-padding"))
+		}
+		if err != nil {
+			return err
+		}
+		payloadCid := ""
+		if res.PayloadCid.Defined() {
+			payloadCid = res.PayloadCid.String()
+		}
+		return printer.print(commPRow{
+			PayloadCid: payloadCid,
+			PieceCid:   res.Root.String(),
+			PieceSize:  uint64(res.Size),
+			PaddedSize: uint64(res.Size.Padded()),
+			CarSize:    res.PayloadSize,
+		})
 	},
 }
 
+// openCommPStream opens the CAR commP streams from stdin or from an http(s)://
+// or s3:// URL, nil for local files.
+func openCommPStream(ctx context.Context, c *cli.Context, target string) (io.ReadCloser, error) {
+	if target == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	s3Target, isS3 := graphsplit.ParseS3URL(target)
+	if !isS3 && !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+		return nil, nil
+	}
+	rangeSize, err := sizeFlag(c, "range-size")
+	if err != nil {
+		return nil, err
+	}
+	if !isS3 {
+		r, _, err := graphsplit.OpenHTTPObject(ctx, target, rangeSize, c.Int("concurrency"))
+		return r, err
+	}
+	client, err := graphsplit.NewS3Client(graphsplit.S3ConfigFromEnv(s3Target, c.String("s3-endpoint"), c.String("s3-region")))
+	if err != nil {
+		return nil, err
+	}
+	r, _, err := graphsplit.NewS3Source(client, rangeSize, c.Int("concurrency")).OpenObject()
+	return r, err
+}
+
+// commPRow is a result of commP as printed with --output json or csv.
+type commPRow struct {
+	Path       string `json:"path,omitempty"`
+	PayloadCid string `json:"payloadCid"`
+	PieceCid   string `json:"pieceCid"`
+	PieceCidV2 string `json:"pieceCidV2,omitempty"`
+	PieceSize  uint64 `json:"pieceSize"`
+	PaddedSize uint64 `json:"paddedPieceSize"`
+	CarSize    int64  `json:"carSize"`
+}
+
+type commPPrinter struct {
+	w      io.Writer
+	format string
+	// version is the pieceCID version printed, 1, 2 or both
+	version string
+	csv     *csv.Writer
+	// header is set once the csv header is written
+	header bool
+}
+
+func newCommPPrinter(w io.Writer, format, version string) (*commPPrinter, error) {
+	switch version {
+	case "1", "2", "both":
+	default:
+		return nil, fmt.Errorf("unknown piece-cid-version %q, expected 1, 2 or both", version)
+	}
+	switch format {
+	case "text", "json", "csv":
+		return &commPPrinter{w: w, format: format, version: version, csv: csv.NewWriter(w)}, nil
+	}
+	return nil, fmt.Errorf("unknown output %q, expected text, json or csv", format)
+}
+
+func (p *commPPrinter) print(row commPRow) error {
+	if p.version != "1" {
+		pieceCid, err := cid.Decode(row.PieceCid)
+		if err != nil {
+			return err
+		}
+		v2, err := graphsplit.PieceCidV2(pieceCid, uint64(row.CarSize))
+		if err != nil {
+			return err
+		}
+		if p.version == "2" {
+			row.PieceCid = v2.String()
+		} else {
+			row.PieceCidV2 = v2.String()
+		}
+	}
+	switch p.format {
+	case "json":
+		return json.NewEncoder(p.w).Encode(row)
+	case "csv":
+		header := []string{"payloadCid", "pieceCid", "pieceSize", "paddedPieceSize", "carSize"}
+		record := []string{
+			row.PayloadCid,
+			row.PieceCid,
+			strconv.FormatUint(row.PieceSize, 10),
+			strconv.FormatUint(row.PaddedSize, 10),
+			strconv.FormatInt(row.CarSize, 10),
+		}
+		if p.version == "both" {
+			header = append(header, "pieceCidV2")
+			record = append(record, row.PieceCidV2)
+		}
+		// the path column is only printed for dir
+		if row.Path != "" {
+			header = append([]string{"path"}, header...)
+			record = append([]string{row.Path}, record...)
+		}
+		if !p.header {
+			if err := p.csv.Write(header); err != nil {
+				return err
+			}
+			p.header = true
+		}
+		if err := p.csv.Write(record); err != nil {
+			return err
+		}
+		p.csv.Flush()
+		return p.csv.Error()
+	}
+	if row.Path != "" {
+		fmt.Fprintf(p.w, "%s ", row.Path)
+	}
+	if row.PieceCidV2 != "" {
+		_, err := fmt.Fprintf(p.w, "PieceCID: %s, PieceCIDv2: %s, PieceSize: %d\n", row.PieceCid, row.PieceCidV2, row.PieceSize)
+		return err
+	}
+	_, err := fmt.Fprintf(p.w, "PieceCID: %s, PieceSize: %d\n", row.PieceCid, row.PieceSize)
+	return err
+}
+
+// commPDir runs commP --dir, results are printed as they are computed and
+// appended to the results file if there is one.
+func commPDir(ctx context.Context, c *cli.Context, dir string, cache *graphsplit.CommPCache, printer *commPPrinter) error {
+	if c.Int("parallel") <= 0 {
+		return fmt.Errorf("Unexpected! Parallel has to be greater than 0")
+	}
+	var out *graphsplit.CommPOutput
+	var done map[string]bool
+	if p := c.String("results"); p != "" {
+		var err error
+		if out, done, err = graphsplit.OpenCommPOutput(p); err != nil {
+			return err
+		}
+		defer out.Close()
+		if len(done) > 0 {
+			log.Infof("skip %d CAR files already in %s", len(done), p)
+		}
+	}
+	var writeErr error
+	err := graphsplit.CommPDir(ctx, dir, c.Int("parallel"), c.Bool("rename"), c.Bool("add-padding"), cache, done, func(res graphsplit.CommPResult) {
+		if writeErr != nil {
+			return
+		}
+		if res.Error == "" {
+			writeErr = printer.print(commPRow{
+				Path:       res.Path,
+				PayloadCid: res.PayloadCid,
+				PieceCid:   res.PieceCid,
+				PieceSize:  res.PieceSize,
+				PaddedSize: uint64(abi.UnpaddedPieceSize(res.PieceSize).Padded()),
+				CarSize:    res.PayloadSize,
+			})
+		}
+		if out != nil && writeErr == nil {
+			writeErr = out.Write(res)
+		}
+	})
+	if writeErr != nil {
+		return writeErr
+	}
+	return err
+}
+
 var importDatasetCmd = &cli.Command{
 	Name:  "import-dataset",
 	Usage: "import files from the specified dataset",
@@ -299,3 +1589,169 @@ var importDatasetCmd = &cli.Command{
 		return dataset.Import(ctx, targetPath, c.String("dsmongo"))
 	},
 }
+
+// retryPolicy returns the retry policy of the retry table of the config.
+func retryPolicy(rc config.RetryConfig) (graphsplit.RetryPolicy, error) {
+	rp := graphsplit.RetryPolicy{MaxAttempts: rc.MaxAttempts}
+	if rc.Backoff != "" {
+		backoff, err := time.ParseDuration(rc.Backoff)
+		if err != nil {
+			return rp, fmt.Errorf("invalid retry.backoff %q: %w", rc.Backoff, err)
+		}
+		rp.Backoff = backoff
+	}
+	return rp, nil
+}
+
+// stringOr returns s, or def when s is empty.
+func stringOr(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// sizeFlag parses a human readable size flag like 8GiB, unset flags are 0.
+func sizeFlag(c *cli.Context, name string) (int64, error) {
+	if c.String(name) == "" {
+		return 0, nil
+	}
+	v, err := units.RAMInBytes(c.String(name))
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %s: %v", name, err)
+	}
+	return v, nil
+}
+
+func printRestoreList(w io.Writer, entries []graphsplit.RestoreEntry, asJSON bool) error {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PATH\tSIZE\tCID\tCAR")
+	var total uint64
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", e.Path, e.Size, e.Cid, e.Car)
+		total += e.Size
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%d files, %s\n", len(entries), units.BytesSize(float64(total)))
+	return err
+}
+
+// verifyRestore prints the failures of report and writes it to verify-report.
+func verifyRestore(c *cli.Context, report *graphsplit.VerifyReport, carPath string) error {
+	if graphsplit.ExistDir(carPath) {
+		if err := report.CheckManifest(carPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if path := c.String("verify-report"); path != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			return err
+		}
+	}
+	failed := report.Failed()
+	if len(failed) == 0 {
+		fmt.Printf("verified %d files\n", len(report.Results))
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tPATH\tCID\tERROR")
+	for _, res := range failed {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", res.Status, res.Path, res.Cid, res.Error)
+	}
+	tw.Flush()
+	return fmt.Errorf("verification failed for %d of %d files", len(failed), len(report.Results))
+}
+
+// reportMissing prints the files restore --allow-missing could not recover
+// completely and returns an error if there are any.
+func reportMissing(c *cli.Context, report *graphsplit.MissingReport, carPath string) error {
+	if graphsplit.ExistDir(carPath) {
+		if err := report.CheckManifest(carPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if p := c.String("missing-report"); p != "" {
+		if err := report.WriteFile(p); err != nil {
+			return err
+		}
+	}
+	files := report.Sorted()
+	if len(files) == 0 {
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PATH\tLOST\tCAR\tERROR")
+	for _, f := range files {
+		lost := "whole file"
+		switch {
+		case len(f.Ranges) > 0:
+			var parts []string
+			for _, r := range f.Ranges {
+				parts = append(parts, fmt.Sprintf("%d-%d", r.Offset, r.Offset+r.Length-1))
+			}
+			lost = "zero filled " + strings.Join(parts, ",")
+		case !f.Whole:
+			lost = fmt.Sprintf("truncated at %d", f.TruncatedAt)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", f.Path, lost, f.Car, f.Error)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return fmt.Errorf("%d files could not be restored completely", len(files))
+}
+
+// openRemoteCars lists the CAR files of a car path on S3 or served over HTTP,
+// nil for local car paths.
+func openRemoteCars(c *cli.Context, carPath string) (*graphsplit.RemoteCars, error) {
+	var src graphsplit.Source
+	if target, ok := graphsplit.ParseS3URL(carPath); ok {
+		rangeSize, err := sizeFlag(c, "s3-range-size")
+		if err != nil {
+			return nil, err
+		}
+		client, err := graphsplit.NewS3Client(graphsplit.S3ConfigFromEnv(target, c.String("s3-endpoint"), c.String("s3-region")))
+		if err != nil {
+			return nil, err
+		}
+		src = graphsplit.NewS3Source(client, rangeSize, c.Int("s3-concurrency"))
+	} else if strings.HasPrefix(carPath, "http://") || strings.HasPrefix(carPath, "https://") {
+		hs, err := graphsplit.NewHTTPDirSource(context.Background(), carPath)
+		if err != nil {
+			return nil, err
+		}
+		src = hs
+	} else {
+		return nil, nil
+	}
+	cacheDir := c.String("cache-dir")
+	if cacheDir == "" {
+		return nil, fmt.Errorf("cache-dir is required to restore from %s", carPath)
+	}
+	return graphsplit.NewRemoteCars(context.Background(), src, cacheDir)
+}
+
+// printRestoreFailures prints the CAR files and merged files a restore failed
+// on.
+func printRestoreFailures(failures []graphsplit.RestoreFailure) {
+	if len(failures) == 0 {
+		return
+	}
+	w := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "FAILED\tERROR")
+	for _, f := range failures {
+		fmt.Fprintf(w, "%s\t%s\n", f.Path, f.Error)
+	}
+	w.Flush() //nolint:errcheck
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/filedrive-team/go-graphsplit"
+	"github.com/urfave/cli/v2"
+)
+
+var manifestCmd = &cli.Command{
+	Name:  "manifest",
+	Usage: "Maintain the manifest of a car dir",
+	Subcommands: []*cli.Command{
+		{
+			Name:      "files",
+			Usage:     "List the pieces holding a source file, from files.csv or files.ndjson written by chunk --file-provenance",
+			ArgsUsage: "[file path as passed to chunk]",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "car-dir",
+					Required: true,
+					Usage:    "directory holding the provenance file",
+				},
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "print as JSON",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				rows, err := graphsplit.ReadFileProvenance(c.String("car-dir"), c.Args().First())
+				if err != nil {
+					return err
+				}
+				if c.Bool("json") {
+					enc := json.NewEncoder(os.Stdout)
+					enc.SetIndent("", "  ")
+					return enc.Encode(rows)
+				}
+				tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(tw, "PATH\tOFFSET\tSIZE\tFILE CID\tPAYLOAD CID\tPIECE CID")
+				for _, row := range rows {
+					fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", row["path"], row["offset"], row["size"], row["file_cid"], row["payload_cid"], row["piece_cid"])
+				}
+				return tw.Flush()
+			},
+		},
+		{
+			Name:  "repair",
+			Usage: "Drop truncated and duplicate rows and rows without CAR file, and add rows for CAR files without one",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "car-dir",
+					Required: true,
+					Usage:    "directory holding the manifest",
+				},
+				&cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "only report what would be changed",
+				},
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "print as JSON",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				repair, err := graphsplit.RepairManifest(context.Background(), c.String("car-dir"), c.Bool("dry-run"))
+				if err != nil {
+					return err
+				}
+				if c.Bool("json") {
+					enc := json.NewEncoder(os.Stdout)
+					enc.SetIndent("", "  ")
+					return enc.Encode(repair)
+				}
+				if repair.Truncated {
+					fmt.Println("dropped truncated last row")
+				}
+				for _, p := range repair.Removed {
+					fmt.Printf("- %s: no CAR file\n", p)
+				}
+				for _, p := range repair.Added {
+					fmt.Printf("+ %s\n", p)
+				}
+				for _, p := range repair.Errors {
+					fmt.Printf("! %s: not a readable CAR file\n", p)
+				}
+				fmt.Printf("duplicates: %d, removed: %d, added: %d\n", repair.Duplicates, len(repair.Removed), len(repair.Added))
+				if c.Bool("dry-run") && repair.Changed() {
+					fmt.Println("dry run, manifest not changed")
+				}
+				if len(repair.Errors) > 0 {
+					return fmt.Errorf("%d CAR files could not be added", len(repair.Errors))
+				}
+				return nil
+			},
+		},
+	},
+}
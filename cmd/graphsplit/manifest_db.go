@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/docker/go-units"
+	"github.com/filedrive-team/go-graphsplit"
+	"github.com/urfave/cli/v2"
+)
+
+var manifestDBFlag = &cli.StringFlag{
+	Name:     "db",
+	Required: true,
+	Usage:    "manifest database written by chunk --manifest-db",
+}
+
+var manifestDBCmd = &cli.Command{
+	Name:  "manifest-db",
+	Usage: "Query the SQLite manifest database written by chunk --manifest-db",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "pieces",
+			Usage: "List the recorded pieces",
+			Flags: []cli.Flag{
+				manifestDBFlag,
+				&cli.StringFlag{
+					Name:  "status",
+					Usage: "only list pieces with this status",
+				},
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "print as JSON",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return queryManifestDB(c, func(db *graphsplit.ManifestDB) ([]graphsplit.DBPiece, error) {
+					return db.Pieces(context.Background(), c.String("status"))
+				})
+			},
+		},
+		{
+			Name:      "find",
+			Usage:     "List the pieces containing a file and the byte ranges of the file they hold",
+			ArgsUsage: "<file path as passed to chunk>",
+			Flags: []cli.Flag{
+				manifestDBFlag,
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "print as JSON",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if c.Args().Len() != 1 {
+					return fmt.Errorf("expect a file path")
+				}
+				return queryManifestDB(c, func(db *graphsplit.ManifestDB) ([]graphsplit.DBPiece, error) {
+					return db.PiecesContaining(context.Background(), c.Args().First())
+				})
+			},
+		},
+		{
+			Name:      "set-status",
+			Usage:     "Set the processing status of a piece, e.g. uploaded or sealed",
+			ArgsUsage: "<payload cid> <status>",
+			Flags: []cli.Flag{
+				manifestDBFlag,
+				&cli.StringFlag{
+					Name:  "error",
+					Usage: "describe a failure",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if c.Args().Len() != 2 {
+					return fmt.Errorf("expect a payload cid and a status")
+				}
+				db, err := graphsplit.OpenManifestDB(c.String("db"))
+				if err != nil {
+					return err
+				}
+				defer db.Close()
+				return db.SetStatus(context.Background(), c.Args().Get(0), c.Args().Get(1), c.String("error"))
+			},
+		},
+	},
+}
+
+func queryManifestDB(c *cli.Context, query func(*graphsplit.ManifestDB) ([]graphsplit.DBPiece, error)) error {
+	db, err := graphsplit.OpenManifestDB(c.String("db"))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	pieces, err := query(db)
+	if err != nil {
+		return err
+	}
+	if c.Bool("json") {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(pieces)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PAYLOAD CID\tPIECE CID\tPIECE SIZE\tSTATUS\tCAR FILE")
+	for _, p := range pieces {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n", p.PayloadCid, p.PieceCid, p.PieceSize, p.Status, p.CarFile)
+		for _, f := range p.Files {
+			fmt.Fprintf(tw, "\t%s\t%s at %d\t\t\n", f.Path, units.BytesSize(float64(f.Size)), f.Offset)
+		}
+	}
+	return tw.Flush()
+}
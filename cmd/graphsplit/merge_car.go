@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/filedrive-team/go-graphsplit"
+	"github.com/urfave/cli/v2"
+)
+
+var mergeCarCmd = &cli.Command{
+	Name:      "merge-car",
+	Usage:     "Merge small CAR files into a single CAR file under a new directory root",
+	ArgsUsage: "<car file or directory>...",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "output",
+			Required: true,
+			Usage:    "path of the merged CAR file",
+		},
+		&cli.BoolFlag{
+			Name:  "calc-commp",
+			Value: true,
+			Usage: "calculate the piece cid of the merged CAR file",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.Args().Len() == 0 {
+			return fmt.Errorf("expect CAR files or directories of CAR files")
+		}
+		var carPaths []string
+		for _, arg := range c.Args().Slice() {
+			paths, err := listCarFiles(arg)
+			if err != nil {
+				return err
+			}
+			carPaths = append(carPaths, paths...)
+		}
+
+		ctx := context.Background()
+		output := c.String("output")
+		res, err := graphsplit.MergeCars(ctx, carPaths, output)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("merged %d CAR files into %s, root: %s, blocks: %d, duplicate blocks: %d\n",
+			len(carPaths), output, res.Root, res.Blocks, res.DuplicateBlocks)
+		if c.Bool("calc-commp") {
+			cp, err := graphsplit.CalcCommP(ctx, output, false, false)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("PieceCID: %s, PieceSize: %d\n", cp.Root, cp.Size.Padded())
+		}
+		return nil
+	},
+}
+
+// listCarFiles returns path if it is a file, or the .car and .car.zst files
+// of the directory at path.
+func listCarFiles(path string) ([]string, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !st.IsDir() {
+		return []string{path}, nil
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, e := range entries {
+		if !e.IsDir() && (strings.HasSuffix(e.Name(), ".car") || strings.HasSuffix(e.Name(), ".car.zst")) {
+			paths = append(paths, filepath.Join(path, e.Name()))
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/filedrive-team/go-graphsplit"
+	"github.com/urfave/cli/v2"
+)
+
+var mountCmd = &cli.Command{
+	Name:      "mount",
+	Usage:     "Mount the files of CAR files as a read only filesystem, blocks are read from the CAR files on demand",
+	ArgsUsage: "<mount point>",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "car-path",
+			Required: true,
+			Usage:    "specify source car path, directory or file",
+		},
+		&cli.StringFlag{
+			Name:  "read-rate",
+			Usage: "throttle reads of CAR files, bytes per second, e.g. 200MiB",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.Args().Len() != 1 {
+			return fmt.Errorf("specify the mount point")
+		}
+		readRate, err := sizeFlag(c, "read-rate")
+		if err != nil {
+			return err
+		}
+		cfs, err := graphsplit.OpenCarFS(context.Background(), c.String("car-path"), graphsplit.WithRestoreReadRate(readRate))
+		if err != nil {
+			return err
+		}
+		defer cfs.Close()
+		return mountFS(cfs, c.Args().First())
+	},
+}
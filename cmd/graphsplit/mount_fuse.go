@@ -0,0 +1,142 @@
+//go:build fuse && (linux || darwin)
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	iofs "io/fs"
+	"os"
+	"os/signal"
+	"path"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// fsNode is a file or directory of an fs.FS whose files implement
+// io.ReaderAt.
+type fsNode struct {
+	fs.Inode
+	fsys iofs.FS
+	name string
+}
+
+var (
+	_ fs.NodeLookuper  = (*fsNode)(nil)
+	_ fs.NodeReaddirer = (*fsNode)(nil)
+	_ fs.NodeGetattrer = (*fsNode)(nil)
+	_ fs.NodeOpener    = (*fsNode)(nil)
+	_ fs.NodeReader    = (*fsNode)(nil)
+)
+
+func toErrno(err error) syscall.Errno {
+	if errors.Is(err, iofs.ErrNotExist) {
+		return syscall.ENOENT
+	}
+	return syscall.EIO
+}
+
+func fillAttr(fi iofs.FileInfo, attr *fuse.Attr) {
+	attr.Mode = uint32(fi.Mode().Perm())
+	if fi.IsDir() {
+		attr.Mode |= syscall.S_IFDIR
+	} else {
+		attr.Mode |= syscall.S_IFREG
+		attr.Size = uint64(fi.Size())
+		attr.Blocks = (attr.Size + 511) / 512
+	}
+}
+
+func (n *fsNode) child(name string) string {
+	if n.name == "." {
+		return name
+	}
+	return path.Join(n.name, name)
+}
+
+func (n *fsNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	fi, err := iofs.Stat(n.fsys, n.child(name))
+	if err != nil {
+		return nil, toErrno(err)
+	}
+	fillAttr(fi, &out.Attr)
+	mode := uint32(syscall.S_IFREG)
+	if fi.IsDir() {
+		mode = syscall.S_IFDIR
+	}
+	return n.NewInode(ctx, &fsNode{fsys: n.fsys, name: n.child(name)}, fs.StableAttr{Mode: mode}), 0
+}
+
+func (n *fsNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	entries, err := iofs.ReadDir(n.fsys, n.name)
+	if err != nil {
+		return nil, toErrno(err)
+	}
+	list := make([]fuse.DirEntry, 0, len(entries))
+	for _, e := range entries {
+		mode := uint32(syscall.S_IFREG)
+		if e.IsDir() {
+			mode = syscall.S_IFDIR
+		}
+		list = append(list, fuse.DirEntry{Name: e.Name(), Mode: mode})
+	}
+	return fs.NewListDirStream(list), 0
+}
+
+func (n *fsNode) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	fi, err := iofs.Stat(n.fsys, n.name)
+	if err != nil {
+		return toErrno(err)
+	}
+	fillAttr(fi, &out.Attr)
+	return 0
+}
+
+func (n *fsNode) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+	f, err := n.fsys.Open(n.name)
+	if err != nil {
+		return nil, 0, toErrno(err)
+	}
+	return f, fuse.FOPEN_KEEP_CACHE, 0
+}
+
+func (n *fsNode) Read(ctx context.Context, fh fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	r, ok := fh.(io.ReaderAt)
+	if !ok {
+		return nil, syscall.EIO
+	}
+	read, err := r.ReadAt(dest, off)
+	if err != nil && err != io.EOF {
+		log.Errorf("failed to read %s at %d: %s", n.name, off, err)
+		return nil, syscall.EIO
+	}
+	return fuse.ReadResultData(dest[:read]), 0
+}
+
+// mountFS serves fsys read only at mountPoint until it is unmounted or the
+// process is interrupted.
+func mountFS(fsys iofs.FS, mountPoint string) error {
+	server, err := fs.Mount(mountPoint, &fsNode{fsys: fsys, name: "."}, &fs.Options{
+		MountOptions: fuse.MountOptions{FsName: "graphsplit", Name: "graphsplit"},
+	})
+	if err != nil {
+		return err
+	}
+	log.Infof("mounted at %s, unmount it or press ctrl-c to stop", mountPoint)
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		if err := server.Unmount(); err != nil {
+			log.Errorf("failed to unmount %s: %s", mountPoint, err)
+		}
+	}()
+	server.Wait()
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build !fuse || !(linux || darwin)
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+)
+
+func mountFS(fsys fs.FS, mountPoint string) error {
+	return fmt.Errorf("graphsplit was built without FUSE support, build it with -tags fuse on linux or macOS")
+}
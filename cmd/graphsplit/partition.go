@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/docker/go-units"
+	"github.com/filedrive-team/go-graphsplit"
+	"github.com/urfave/cli/v2"
+)
+
+var partitionCmd = &cli.Command{
+	Name:  "partition",
+	Usage: "Split one dataset into partitions chunked on several machines",
+	Subcommands: []*cli.Command{
+		partitionPlanCmd,
+		partitionMergeCmd,
+	},
+}
+
+var partitionPlanCmd = &cli.Command{
+	Name:      "plan",
+	Usage:     "Assign every file of a dataset to one of n partitions of about the same size",
+	ArgsUsage: "<input path>",
+	Flags: []cli.Flag{
+		&cli.IntFlag{
+			Name:     "partitions",
+			Required: true,
+			Usage:    "specify the number of partitions",
+		},
+		&cli.StringFlag{
+			Name:     "output",
+			Aliases:  []string{"o"},
+			Required: true,
+			Usage:    "specify the plan file to write, chunk reads it with --partition-plan",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 1 {
+			return fmt.Errorf("expect one input path")
+		}
+		plan, err := graphsplit.PlanPartitions(c.Args().First(), c.Int("partitions"))
+		if err != nil {
+			return err
+		}
+		if err := plan.Save(c.String("output")); err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "PARTITION\tFILES\tSIZE")
+		for _, p := range plan.Partitions {
+			fmt.Fprintf(tw, "%d\t%d\t%s\n", p.Index, len(p.Files), units.BytesSize(float64(p.Size)))
+		}
+		return tw.Flush()
+	},
+}
+
+var partitionMergeCmd = &cli.Command{
+	Name:      "merge",
+	Usage:     "Merge the manifests of the car dirs of all partitions into one",
+	ArgsUsage: "<car dir>...",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "out-dir",
+			Required: true,
+			Usage:    "specify the directory of the merged manifest.csv",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() == 0 {
+			return fmt.Errorf("no car dir given")
+		}
+		n, err := graphsplit.MergeManifests(c.String("out-dir"), c.Args().Slice())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("merged %d pieces into %s\n", n, c.String("out-dir"))
+		return nil
+	},
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/filedrive-team/go-graphsplit"
+	"github.com/urfave/cli/v2"
+)
+
+var pieceInfoCmd = &cli.Command{
+	Name:      "piece-info",
+	Usage:     "Show piece cid, sizes and payload root of a piece file",
+	ArgsUsage: "<piece file>",
+	Action: func(c *cli.Context) error {
+		if c.Args().Len() != 1 {
+			return fmt.Errorf("expect the path of one piece file")
+		}
+		info, err := graphsplit.InspectPiece(c.Args().First())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("piece cid:     %s\n", info.PieceCid)
+		fmt.Printf("padded size:   %d\n", info.PaddedSize)
+		fmt.Printf("unpadded size: %d\n", info.UnpaddedSize)
+		fmt.Printf("file size:     %d\n", info.FileSize)
+		if info.CarErr != nil {
+			fmt.Printf("valid car:     false (%s)\n", info.CarErr)
+		} else {
+			fmt.Printf("valid car:     true\n")
+		}
+		fmt.Printf("payload size:  %d\n", info.PayloadSize)
+		for _, root := range info.Roots {
+			fmt.Printf("payload root:  %s\n", root)
+		}
+		return nil
+	},
+}
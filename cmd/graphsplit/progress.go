@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/filedrive-team/go-graphsplit"
+	"github.com/urfave/cli/v2"
+)
+
+// progressReporter returns the reporter selected by the progress flags, nil
+// if there is none.
+func progressReporter(c *cli.Context) graphsplit.ProgressReporter {
+	switch {
+	case c.Bool("progress-json"):
+		return &jsonProgress{enc: json.NewEncoder(os.Stdout)}
+	case c.Bool("progress"):
+		return &barProgress{w: os.Stderr}
+	}
+	return nil
+}
+
+type jsonProgress struct {
+	enc *json.Encoder
+}
+
+func (jp *jsonProgress) Report(p graphsplit.Progress) {
+	jp.enc.Encode(map[string]interface{}{ //nolint:errcheck
+		"op":              p.Op,
+		"bytes_read":      p.BytesRead,
+		"bytes_total":     p.BytesTotal,
+		"files_done":      p.FilesDone,
+		"files_total":     p.FilesTotal,
+		"slice":           p.Slice,
+		"slices_done":     p.SlicesDone,
+		"slices_total":    p.SlicesTotal,
+		"elapsed_seconds": p.Elapsed.Seconds(),
+		"eta_seconds":     p.ETA.Seconds(),
+		"done":            p.Done,
+	})
+}
+
+const progressBarWidth = 30
+
+type barProgress struct {
+	w io.Writer
+}
+
+func (bp *barProgress) Report(p graphsplit.Progress) {
+	var ratio float64
+	if p.BytesTotal > 0 {
+		ratio = float64(p.BytesRead) / float64(p.BytesTotal)
+	} else if p.SlicesTotal > 0 {
+		ratio = float64(p.SlicesDone) / float64(p.SlicesTotal)
+	}
+	if ratio > 1 || p.Done {
+		ratio = 1
+	}
+	filled := int(ratio * progressBarWidth)
+	line := fmt.Sprintf("%s [%s%s] %3.0f%% %s/%s",
+		p.Op, strings.Repeat("=", filled), strings.Repeat(" ", progressBarWidth-filled), ratio*100,
+		units.BytesSize(float64(p.BytesRead)), units.BytesSize(float64(p.BytesTotal)))
+	if p.FilesTotal > 0 {
+		line += fmt.Sprintf(" files %d/%d", p.FilesDone, p.FilesTotal)
+	}
+	if p.SlicesTotal > 0 {
+		line += fmt.Sprintf(" slices %d/%d", p.SlicesDone, p.SlicesTotal)
+	}
+	if p.ETA > 0 {
+		line += " ETA " + p.ETA.Round(time.Second).String()
+	} else if p.Done {
+		line += " in " + p.Elapsed.Round(time.Second).String()
+	}
+	end := ""
+	if p.Done {
+		end = "\n"
+	}
+	// clear the rest of a longer previous line
+	fmt.Fprintf(bp.w, "\r%s\033[K%s", line, end)
+}
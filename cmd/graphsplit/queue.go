@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/filedrive-team/go-graphsplit"
+	"github.com/filedrive-team/go-graphsplit/config"
+	"github.com/filedrive-team/go-graphsplit/dataset"
+	"github.com/urfave/cli/v2"
+)
+
+var queueFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:     "queue",
+		Required: true,
+		EnvVars:  []string{"GRAPHSPLIT_QUEUE"},
+		Usage:    "specify the job queue, a mongodb:// or redis:// uri",
+	},
+	&cli.StringFlag{
+		Name:  "queue-name",
+		Value: "jobs",
+		Usage: "specify the MongoDB collection or Redis key prefix of the queue",
+	},
+}
+
+func openQueue(c *cli.Context) (dataset.JobQueue, error) {
+	return dataset.OpenJobQueue(c.Context, c.String("queue"), c.String("queue-name"))
+}
+
+var queueCmd = &cli.Command{
+	Name:  "queue",
+	Usage: "Manage chunk jobs run by graphsplit workers on several machines",
+	Subcommands: []*cli.Command{
+		queueAddCmd,
+		queueListCmd,
+		queueRetryCmd,
+	},
+}
+
+var queueAddCmd = &cli.Command{
+	Name:      "add",
+	Usage:     "Enqueue a chunk job for each input",
+	ArgsUsage: "<input path>...",
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:     "car-dir",
+			Required: true,
+			Usage:    "specify the CAR directory of the jobs as workers see it, {id} is replaced by the job id",
+		},
+		&cli.StringFlag{
+			Name:     "graph-name",
+			Required: true,
+			Usage:    "specify the graph name of the jobs, {id} is replaced by the job id",
+		},
+		&cli.StringFlag{
+			Name:  "slice-size",
+			Usage: "specify the slice size of the jobs, e.g. 32GiB, defaults to the SliceSize of the worker config",
+		},
+		&cli.StringSliceFlag{
+			Name:  "arg",
+			Usage: "pass a chunk flag to the jobs as name=value, can be repeated",
+		},
+		&cli.BoolFlag{
+			Name:  "per-subdir",
+			Usage: "enqueue a job for each subdirectory of the inputs",
+		},
+		&cli.StringFlag{
+			Name:  "partition-plan",
+			Usage: "enqueue a job for each partition of the plan written by partition plan, instead of inputs. The plan has to be readable by the workers at the same path",
+		},
+	}, queueFlags...),
+	Action: func(c *cli.Context) error {
+		var inputs []string
+		var partitions []map[string]string
+		if path := c.String("partition-plan"); path != "" {
+			if c.NArg() > 0 {
+				return fmt.Errorf("inputs come from the partition plan")
+			}
+			path, err := filepath.Abs(path)
+			if err != nil {
+				return err
+			}
+			plan, err := graphsplit.LoadPartitionPlan(path)
+			if err != nil {
+				return err
+			}
+			for _, p := range plan.Partitions {
+				inputs = append(inputs, plan.Root)
+				partitions = append(partitions, map[string]string{"partition-plan": path, "partition": strconv.Itoa(p.Index)})
+			}
+		} else {
+			var err error
+			if inputs, err = queueInputs(c.Args().Slice(), c.Bool("per-subdir")); err != nil {
+				return err
+			}
+		}
+		if len(inputs) == 0 {
+			return fmt.Errorf("no input to enqueue")
+		}
+		if len(inputs) > 1 && !strings.Contains(c.String("car-dir"), "{id}") {
+			return fmt.Errorf("car-dir needs {id} when enqueueing several jobs, e.g. /cars/{id}")
+		}
+		sliceSize, err := sizeFlag(c, "slice-size")
+		if err != nil {
+			return err
+		}
+		args := make(map[string]string)
+		for _, kv := range c.StringSlice("arg") {
+			k, v, ok := strings.Cut(kv, "=")
+			if !ok {
+				return fmt.Errorf("invalid arg %q, expect name=value", kv)
+			}
+			args[strings.TrimLeft(k, "-")] = v
+		}
+		if err := validateJob(graphsplit.JobRequest{Type: graphsplit.JobChunk, Args: args}); err != nil {
+			return err
+		}
+		q, err := openQueue(c)
+		if err != nil {
+			return err
+		}
+		defer q.Close()
+		for i, input := range inputs {
+			job := &dataset.QueuedJob{
+				Input:     input,
+				CarDir:    c.String("car-dir"),
+				GraphName: c.String("graph-name"),
+				SliceSize: sliceSize,
+				Args:      args,
+			}
+			if partitions != nil {
+				job.Args = make(map[string]string, len(args)+2)
+				for k, v := range args {
+					job.Args[k] = v
+				}
+				for k, v := range partitions[i] {
+					job.Args[k] = v
+				}
+			}
+			if err := q.Enqueue(c.Context, job); err != nil {
+				return err
+			}
+			fmt.Printf("%s\t%s\t%s\n", job.ID, job.Input, job.CarDir)
+		}
+		return nil
+	},
+}
+
+// queueInputs returns the absolute paths of inputs, or of their
+// subdirectories with perSubdir. Remote inputs like s3:// are kept.
+func queueInputs(inputs []string, perSubdir bool) ([]string, error) {
+	var res []string
+	for _, input := range inputs {
+		if strings.Contains(input, "://") {
+			res = append(res, input)
+			continue
+		}
+		abs, err := filepath.Abs(input)
+		if err != nil {
+			return nil, err
+		}
+		if !perSubdir {
+			res = append(res, abs)
+			continue
+		}
+		entries, err := os.ReadDir(abs)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.IsDir() {
+				res = append(res, filepath.Join(abs, e.Name()))
+			}
+		}
+	}
+	return res, nil
+}
+
+var queueListCmd = &cli.Command{
+	Name:  "list",
+	Usage: "List the jobs of the queue",
+	Flags: append([]cli.Flag{
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "print the jobs as JSON",
+		},
+	}, queueFlags...),
+	Action: func(c *cli.Context) error {
+		q, err := openQueue(c)
+		if err != nil {
+			return err
+		}
+		defer q.Close()
+		jobs, err := q.List(c.Context)
+		if err != nil {
+			return err
+		}
+		if c.Bool("json") {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(jobs)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tSTATE\tWORKER\tATTEMPTS\tSLICES\tINPUT\tERROR")
+		for _, job := range jobs {
+			var p struct {
+				SlicesDone int64 `json:"slices_done"`
+			}
+			json.Unmarshal([]byte(job.Progress), &p) //nolint:errcheck
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%s\t%s\n", job.ID, job.State, job.Worker, job.Attempts, p.SlicesDone, job.Input, job.Error)
+		}
+		return tw.Flush()
+	},
+}
+
+var queueRetryCmd = &cli.Command{
+	Name:      "retry",
+	Usage:     "Make failed or stuck jobs pending again",
+	ArgsUsage: "<job id>...",
+	Flags:     queueFlags,
+	Action: func(c *cli.Context) error {
+		if c.NArg() == 0 {
+			return fmt.Errorf("no job id given")
+		}
+		q, err := openQueue(c)
+		if err != nil {
+			return err
+		}
+		defer q.Close()
+		for _, id := range c.Args().Slice() {
+			if err := q.Requeue(c.Context, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+var workerCmd = &cli.Command{
+	Name:  "worker",
+	Usage: "Claim chunk jobs from a job queue and run them",
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:     "config",
+			Aliases:  []string{"c"},
+			Required: true,
+			Usage:    "specify the config of the jobs, set its DB to collect the manifests of all workers",
+		},
+		&cli.StringFlag{
+			Name:  "name",
+			Usage: "specify the worker name holding the leases, defaults to hostname-pid",
+		},
+		&cli.DurationFlag{
+			Name:  "lease",
+			Value: 5 * time.Minute,
+			Usage: "specify how long a job stays claimed without a heartbeat of the worker",
+		},
+		&cli.DurationFlag{
+			Name:  "poll",
+			Value: 30 * time.Second,
+			Usage: "specify how often to look for jobs when the queue is empty",
+		},
+		&cli.BoolFlag{
+			Name:  "exit-when-empty",
+			Usage: "exit when the queue has no job instead of polling",
+		},
+		healthListenFlag,
+	}, queueFlags...),
+	Action: func(c *cli.Context) error {
+		exe, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		worker := c.String("name")
+		if worker == "" {
+			host, _ := os.Hostname()
+			worker = fmt.Sprintf("%s-%d", host, os.Getpid())
+		}
+		q, err := openQueue(c)
+		if err != nil {
+			return err
+		}
+		defer q.Close()
+		ctx, stop := signalContext()
+		defer stop()
+		health, endHealth, err := startHealth(c, stop)
+		if err != nil {
+			return err
+		}
+		defer endHealth()
+		health.SetReady(true)
+		lease := c.Duration("lease")
+		run := execJob(exe)
+		log.Infof("worker %s waiting for jobs", worker)
+		for ctx.Err() == nil {
+			job, err := q.Claim(ctx, worker, lease)
+			if err != nil {
+				log.Errorf("failed to claim a job: %s", err)
+			}
+			if job == nil {
+				if err == nil && c.Bool("exit-when-empty") {
+					return nil
+				}
+				select {
+				case <-ctx.Done():
+				case <-time.After(c.Duration("poll")):
+				}
+				continue
+			}
+			runQueuedJob(ctx, q, worker, lease, run, c.String("config"), job)
+		}
+		return nil
+	},
+}
+
+// runQueuedJob runs job and renews its lease every third of lease until it
+// finishes. The job is left to expire if the worker loses its lease.
+func runQueuedJob(ctx context.Context, q dataset.JobQueue, worker string, lease time.Duration, run graphsplit.JobRunner, cfgPath string, job *dataset.QueuedJob) {
+	log.Infow("running job", "job", job.ID, "input", job.Input, "car_dir", job.CarDir, "attempt", job.Attempts)
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var mu sync.Mutex
+	var progress string
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			mu.Lock()
+			p := progress
+			mu.Unlock()
+			if err := q.Heartbeat(ctx, job.ID, worker, lease, p); err == dataset.ErrLeaseLost {
+				log.Warnf("lost the lease of job %s, stopping it", job.ID)
+				cancel()
+				return
+			} else if err != nil {
+				log.Warnf("failed to renew the lease of job %s: %s", job.ID, err)
+			}
+		}
+	}()
+	runErr := runChunkJob(jobCtx, run, cfgPath, job, func(p json.RawMessage) {
+		mu.Lock()
+		progress = string(p)
+		mu.Unlock()
+	})
+	close(done)
+	if jobCtx.Err() != nil && ctx.Err() == nil {
+		return
+	}
+	if runErr == nil {
+		log.Infow("job done", "job", job.ID, "car_dir", job.CarDir)
+	} else {
+		log.Errorw("job failed", "job", job.ID, "error", runErr)
+	}
+	finishCtx, cancelFinish := context.WithTimeout(context.Background(), time.Minute)
+	defer cancelFinish()
+	mu.Lock()
+	p := progress
+	mu.Unlock()
+	if p != "" {
+		q.Heartbeat(finishCtx, job.ID, worker, lease, p) //nolint:errcheck
+	}
+	if err := q.Finish(finishCtx, job.ID, worker, runErr); err != nil {
+		log.Errorf("failed to finish job %s: %s", job.ID, err)
+	}
+}
+
+// runChunkJob runs chunk for job with the config at cfgPath, its SliceSize
+// replaced by the one of the job.
+func runChunkJob(ctx context.Context, run graphsplit.JobRunner, cfgPath string, job *dataset.QueuedJob, progress func(json.RawMessage)) error {
+	if err := os.MkdirAll(job.CarDir, 0755); err != nil {
+		return err
+	}
+	cfg, err := config.LoadConfig(cfgPath)
+	if err != nil {
+		return err
+	}
+	if job.SliceSize > 0 {
+		cfg.SliceSize = config.Size(job.SliceSize)
+		cfg.SliceSizeRange, cfg.SliceSizeStrategy = "", graphsplit.SliceSizeFixed
+	}
+	f, err := os.CreateTemp("", "graphsplit-job-*.toml")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	if err := cfg.SaveConfig(f.Name()); err != nil {
+		return err
+	}
+	args := map[string]string{
+		"car-dir":    job.CarDir,
+		"graph-name": job.GraphName,
+		"config":     f.Name(),
+	}
+	for k, v := range job.Args {
+		if _, ok := args[k]; !ok {
+			args[k] = v
+		}
+	}
+	return run(ctx, graphsplit.Job{JobRequest: graphsplit.JobRequest{Type: graphsplit.JobChunk, Input: job.Input, Args: args}}, os.Stderr, progress)
+}
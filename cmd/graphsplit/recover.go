@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/filedrive-team/go-graphsplit"
+	"github.com/urfave/cli/v2"
+)
+
+var recoverCmd = &cli.Command{
+	Name:      "recover",
+	Usage:     "Reconstruct lost data pieces of a parity group from the surviving pieces",
+	ArgsUsage: "<recovery manifest>",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "out-dir",
+			Usage: "directory the recovered pieces are written to, defaults to the directory of the manifest",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.Args().Len() != 1 {
+			return fmt.Errorf("expect the path of one recovery manifest")
+		}
+		manifest := c.Args().First()
+		outDir := c.String("out-dir")
+		if outDir == "" {
+			outDir = filepath.Dir(manifest)
+		}
+		recovered, err := graphsplit.RecoverPieces(context.Background(), manifest, outDir)
+		if err != nil {
+			return err
+		}
+		if len(recovered) == 0 {
+			fmt.Println("all data pieces are present")
+		}
+		for _, path := range recovered {
+			fmt.Printf("recovered %s\n", path)
+		}
+		return nil
+	},
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/filedrive-team/go-graphsplit"
+	"github.com/filedrive-team/go-graphsplit/config"
+)
+
+// reloadable are the config keys chunk applies between slices when its
+// config is reloaded, the others take a restart.
+var reloadable = map[string]bool{
+	"SliceSize":               true,
+	"SliceSizeRange":          true,
+	"SliceSizeStrategy":       true,
+	"SliceSizes":              true,
+	"ExtraFilePath":           true,
+	"ExtraFileSizeInOnePiece": true,
+	"Parallel":                true,
+	"Callbacks":               true,
+}
+
+// changedKeys returns the top level keys of which the values of old and cfg
+// differ. Profiles are left out, the values of the profile in use are
+// applied to the other keys.
+func changedKeys(old, cfg *config.Config) []string {
+	var keys []string
+	ov, nv := reflect.ValueOf(old).Elem(), reflect.ValueOf(cfg).Elem()
+	for i := 0; i < ov.NumField(); i++ {
+		key := ov.Type().Field(i).Tag.Get("toml")
+		if key != "Profiles" && !reflect.DeepEqual(ov.Field(i).Interface(), nv.Field(i).Interface()) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// watchConfigFile requests a reload of the run of c whenever the config
+// file at path is modified, until ctx is done.
+func watchConfigFile(ctx context.Context, path string, interval time.Duration, c *graphsplit.Controller) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var last os.FileInfo
+	if fi, err := os.Stat(path); err == nil {
+		last = fi
+	}
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if last == nil || !fi.ModTime().Equal(last.ModTime()) || fi.Size() != last.Size() {
+			log.Infof("%s modified", path)
+			c.RequestReload()
+		}
+		last = fi
+	}
+}
+
+// nextSliceSize returns the slice size range of the next run with cfg.
+func nextSliceSize(cfg *config.Config, strategy *graphsplit.SliceSizeStrategy, carDir string) (int, int, error) {
+	if cfg.SliceSizeRange != "" {
+		min, max, err := config.ParseSizeRange(cfg.SliceSizeRange)
+		return int(min), int(max), err
+	}
+	min, max, err := strategy.Next(carDir)
+	return int(min), int(max), err
+}
+
+// extraFileSize returns the size of the extra files in a slice with cfg.
+func extraFileSize(cfg *config.Config) (int64, error) {
+	if cfg.ExtraFilePath == "" {
+		return 0, nil
+	}
+	if cfg.ExtraFileSizeInOnePiece == "" {
+		return 0, fmt.Errorf("extra file size in one piece is required when extra file path is set")
+	}
+	size, err := units.RAMInBytes(cfg.ExtraFileSizeInOnePiece)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse real file size: %v", err)
+	}
+	return size, nil
+}
+
+// newRegisteredCallbacks creates the callbacks of cfg registered with
+// graphsplit.RegisterCallback.
+func newRegisteredCallbacks(cfg *config.Config, carDir string) ([]graphsplit.GraphBuildCallback, error) {
+	var cbs []graphsplit.GraphBuildCallback
+	for _, cc := range cfg.Callbacks {
+		rcb, err := graphsplit.NewRegisteredCallback(cc.Name, carDir, cc.Options)
+		if err != nil {
+			closeCallbacks(cbs)
+			return nil, err
+		}
+		cbs = append(cbs, rcb)
+	}
+	return cbs, nil
+}
+
+func closeCallbacks(cbs []graphsplit.GraphBuildCallback) {
+	for _, cb := range cbs {
+		if err := graphsplit.CloseCallback(cb); err != nil {
+			log.Errorf("failed to close callback: %s", err)
+		}
+	}
+}
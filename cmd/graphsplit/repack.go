@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/filedrive-team/go-graphsplit"
+	"github.com/urfave/cli/v2"
+)
+
+var repackCmd = &cli.Command{
+	Name:  "repack",
+	Usage: "Re-split the payload of existing CAR files into CAR files of another slice size",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "car-path",
+			Required: true,
+			Usage:    "specify source car path, directory or file",
+		},
+		&cli.StringFlag{
+			Name:     "car-dir",
+			Required: true,
+			Usage:    "specify output CAR directory",
+		},
+		&cli.StringFlag{
+			Name:     "graph-name",
+			Required: true,
+			Usage:    "specify graph name",
+		},
+		&cli.StringFlag{
+			Name:     "slice-size",
+			Required: true,
+			Usage:    "target size of the new slices, e.g. 15GiB",
+		},
+		&cli.StringFlag{
+			Name:  "work-dir",
+			Usage: "directory the payload is unpacked to while repacking, defaults to car-dir",
+		},
+		&cli.UintFlag{
+			Name:  "parallel",
+			Value: 0,
+			Usage: "specify how many number of goroutines runs when generate file node, 0 picks it from cpu count, storage type and file sizes",
+		},
+		&cli.BoolFlag{
+			Name:  "calc-commp",
+			Value: true,
+			Usage: "create a mainfest.csv in car-dir to save mapping of data-cids, slice names, piece-cids and piece-sizes",
+		},
+		&cli.BoolFlag{
+			Name:  "rename",
+			Value: false,
+			Usage: "rename carfile to piece",
+		},
+		&cli.BoolFlag{
+			Name:  "add-padding",
+			Value: false,
+			Usage: "add padding to carfile in order to convert it to piece file",
+		},
+		&cli.StringFlag{
+			Name:  "decrypt",
+			Usage: "key file of source CAR files encrypted by chunk --encrypt-key",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		carDir := c.String("car-dir")
+		if !graphsplit.ExistDir(carDir) {
+			return fmt.Errorf("the path of car-dir does not exist")
+		}
+		workDir := c.String("work-dir")
+		if workDir == "" {
+			workDir = carDir
+		}
+		sliceSize, err := sizeFlag(c, "slice-size")
+		if err != nil {
+			return err
+		}
+		if sliceSize <= 0 {
+			return fmt.Errorf("slice size has to be greater than 0")
+		}
+
+		var cb graphsplit.GraphBuildCallback
+		if c.Bool("calc-commp") {
+			cb = graphsplit.CommPCallback(carDir, c.Bool("rename"), c.Bool("add-padding"))
+		} else {
+			cb = graphsplit.CSVCallback(carDir)
+		}
+		ef, err := graphsplit.NewExtraFile("", 0, sliceSize, false)
+		if err != nil {
+			return err
+		}
+		var opts []graphsplit.RestoreOption
+		if keyFile := c.String("decrypt"); keyFile != "" {
+			dec, err := graphsplit.LoadKeyFile(keyFile)
+			if err != nil {
+				return err
+			}
+			opts = append(opts, graphsplit.WithDecryption(dec))
+		}
+
+		params := &graphsplit.ChunkParams{
+			ExpectSliceSize: sliceSize,
+			MaxSliceSize:    sliceSize,
+			CarDir:          carDir,
+			GraphName:       c.String("graph-name"),
+			Parallel:        int(c.Uint("parallel")),
+			Cb:              cb,
+			Ef:              ef,
+		}
+		ctx, stop := signalContext()
+		defer stop()
+		return graphsplit.Repack(ctx, c.String("car-path"), workDir, params, opts...)
+	},
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/filedrive-team/go-graphsplit"
+	"github.com/urfave/cli/v2"
+)
+
+var servePieceCmd = &cli.Command{
+	Name:    "serve",
+	Aliases: []string{"serve-piece"},
+	Usage:   "Serve pieces and their payloads over HTTP from the CAR files of car-dir, e.g. for boost to fetch",
+	Flags: []cli.Flag{
+		carDirFlag,
+		&cli.StringFlag{
+			Name:  "listen",
+			Value: ":8080",
+			Usage: "specify listen address",
+		},
+		&cli.StringFlag{
+			Name:    "token",
+			Usage:   "require requests to send this bearer token in the Authorization header",
+			EnvVars: []string{"GRAPHSPLIT_SERVE_TOKEN"},
+		},
+	},
+	Action: func(c *cli.Context) error {
+		listen := c.String("listen")
+		var opts []graphsplit.PieceServerOption
+		if token := c.String("token"); token != "" {
+			opts = append(opts, graphsplit.WithServeToken(token))
+		}
+		log.Infof("serving pieces of %s on %s", c.String("car-dir"), listen)
+		return http.ListenAndServe(listen, graphsplit.NewPieceServer(c.String("car-dir"), opts...))
+	},
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/filedrive-team/go-graphsplit"
+)
+
+// exitInterrupted is the exit code of a run stopped by SIGINT or SIGTERM.
+const exitInterrupted = 130
+
+// signalContext returns a context canceled by the first SIGINT or SIGTERM.
+// The run stops building the current slice, or finishes writing it and its
+// manifest row, and returns. A second signal removes unfinished writes and
+// exits at once.
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-sigCh:
+			log.Warnf("received %s, stopping after the current slice, send it again to abort now", sig)
+			cancel()
+		case <-ctx.Done():
+			return
+		}
+		if sig, ok := <-sigCh; ok {
+			log.Warnf("received %s again, aborting", sig)
+			graphsplit.RemovePendingWrites()
+			os.Exit(exitInterrupted)
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(sigCh)
+		cancel()
+	}
+}
+
+// exitCode returns the exit code of a command failing with err.
+func exitCode(err error) int {
+	if errors.Is(err, context.Canceled) {
+		return exitInterrupted
+	}
+	return 1
+}
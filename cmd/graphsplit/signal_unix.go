@@ -0,0 +1,52 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/filedrive-team/go-graphsplit"
+)
+
+// notifyPause pauses the run of c after its current slice on SIGUSR1 and
+// resumes it on SIGUSR2, until ctx is done.
+func notifyPause(ctx context.Context, c *graphsplit.Controller) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		defer signal.Stop(sigCh)
+		for {
+			select {
+			case sig := <-sigCh:
+				if sig == syscall.SIGUSR1 {
+					c.PauseAfterSlice()
+				} else {
+					c.Resume()
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// notifyReload requests a reload of the config of the run of c on SIGHUP,
+// until ctx is done.
+func notifyReload(ctx context.Context, c *graphsplit.Controller) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(sigCh)
+		for {
+			select {
+			case <-sigCh:
+				c.RequestReload()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
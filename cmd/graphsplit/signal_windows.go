@@ -0,0 +1,15 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+
+	"github.com/filedrive-team/go-graphsplit"
+)
+
+// notifyPause does nothing, Windows has no SIGUSR1 and SIGUSR2.
+func notifyPause(ctx context.Context, c *graphsplit.Controller) {}
+
+// notifyReload does nothing, Windows has no SIGHUP.
+func notifyReload(ctx context.Context, c *graphsplit.Controller) {}
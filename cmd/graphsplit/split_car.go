@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/filedrive-team/go-graphsplit"
+	"github.com/urfave/cli/v2"
+)
+
+var splitCarCmd = &cli.Command{
+	Name:      "split-car",
+	Usage:     "Split an oversized CAR file at block boundaries into smaller CAR files with a stitch manifest",
+	ArgsUsage: "<car file>",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "part-size",
+			Required: true,
+			Usage:    "maximum size of the parts, e.g. 30GiB",
+		},
+		&cli.StringFlag{
+			Name:  "out-dir",
+			Usage: "directory the parts and the stitch manifest are written to, defaults to the directory of the CAR file",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.Args().Len() != 1 {
+			return fmt.Errorf("expect the path of one CAR file")
+		}
+		carPath := c.Args().First()
+		outDir := c.String("out-dir")
+		if outDir == "" {
+			outDir = filepath.Dir(carPath)
+		}
+		if !graphsplit.ExistDir(outDir) {
+			return fmt.Errorf("the path of out-dir does not exist")
+		}
+		partSize, err := sizeFlag(c, "part-size")
+		if err != nil {
+			return err
+		}
+
+		sm, err := graphsplit.SplitCar(context.Background(), carPath, outDir, partSize)
+		if err != nil {
+			return err
+		}
+		for _, p := range sm.Parts {
+			fmt.Printf("%s: PieceCID: %s, PieceSize: %d\n", p.File, p.PieceCid, p.PieceSize)
+		}
+		return nil
+	},
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/filedrive-team/go-graphsplit"
+	"github.com/urfave/cli/v2"
+)
+
+// startTracing exports spans to the collector of --otlp-endpoint, or of the
+// OTEL_EXPORTER_OTLP_ENDPOINT environment variable. The returned function
+// exports the pending spans, it does nothing if tracing is off.
+func startTracing(c *cli.Context) (func(), error) {
+	endpoint := c.String("otlp-endpoint")
+	if endpoint == "" {
+		endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	}
+	if endpoint == "" {
+		return func() {}, nil
+	}
+	headers := make(map[string]string)
+	for _, kv := range strings.Split(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	shutdown, err := graphsplit.EnableTracing(graphsplit.TracingConfig{
+		Endpoint:    endpoint,
+		ServiceName: os.Getenv("OTEL_SERVICE_NAME"),
+		Headers:     headers,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+		if err := shutdown(ctx); err != nil {
+			log.Warnf("failed to export the last spans: %s", err)
+		}
+	}, nil
+}
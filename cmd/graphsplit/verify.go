@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/filedrive-team/go-graphsplit"
+	"github.com/urfave/cli/v2"
+)
+
+var verifyCmd = &cli.Command{
+	Name:  "verify",
+	Usage: "Check the CAR files of car-dir against manifest.csv before shipping them, reporting missing, extra and mismatched files",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "car-dir",
+			Required: true,
+			Usage:    "directory holding manifest.csv",
+		},
+		&cli.IntFlag{
+			Name:  "sample",
+			Value: 10,
+			Usage: "recompute the pieceCID of this many CAR files chosen at random",
+		},
+		&cli.BoolFlag{
+			Name:  "full",
+			Usage: "recompute the pieceCID of every CAR file",
+		},
+		&cli.IntFlag{
+			Name:  "parallel",
+			Value: 2,
+			Usage: "number of pieceCIDs computed at a time",
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "print the result of every manifest row and extra file as JSON",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.Int("parallel") <= 0 {
+			return fmt.Errorf("Unexpected! Parallel has to be greater than 0")
+		}
+		sample := c.Int("sample")
+		if c.Bool("full") {
+			sample = -1
+		}
+		report, err := graphsplit.VerifyCarDir(context.Background(), c.String("car-dir"), sample, c.Int("parallel"))
+		if err != nil {
+			return err
+		}
+		failed := report.Failed()
+		if c.Bool("json") {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(report); err != nil {
+				return err
+			}
+		} else if len(failed) > 0 {
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "STATUS\tPATH\tPAYLOAD CID\tPIECE CID\tERROR")
+			for _, res := range failed {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", res.Status, res.Path, res.PayloadCid, res.PieceCid, res.Error)
+			}
+			tw.Flush()
+		}
+		checked := 0
+		for _, res := range report.Results {
+			if res.CommPChecked {
+				checked++
+			}
+		}
+		if len(failed) > 0 {
+			return fmt.Errorf("%d of %d files failed verification, pieceCID recomputed for %d", len(failed), len(report.Results), checked)
+		}
+		fmt.Fprintf(os.Stderr, "%d files ok, pieceCID recomputed for %d\n", len(report.Results), checked)
+		return nil
+	},
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/filedrive-team/go-graphsplit"
+	"github.com/ipfs/go-cid"
+	"github.com/urfave/cli/v2"
+)
+
+var verifyPieceCmd = &cli.Command{
+	Name:      "verify-piece",
+	Usage:     "Recompute the pieceCID of a piece file, padded or not, and compare it with the claimed pieceCID before sealing",
+	ArgsUsage: "<piece file> <piece cid>",
+	Action: func(c *cli.Context) error {
+		if c.Args().Len() != 2 {
+			return fmt.Errorf("expect the path of a piece file and its piece cid")
+		}
+		claimed, err := cid.Decode(c.Args().Get(1))
+		if err != nil {
+			return fmt.Errorf("invalid piece cid: %w", err)
+		}
+		v, err := graphsplit.VerifyPiece(c.Args().First(), claimed)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("claimed:       %s\n", v.Claimed)
+		fmt.Printf("piece cid:     %s\n", v.PieceCid)
+		fmt.Printf("padded size:   %d\n", v.PaddedSize)
+		fmt.Printf("file size:     %d\n", v.FileSize)
+		if !v.Match {
+			fmt.Printf("result:        mismatch\n")
+			return fmt.Errorf("%s does not match %s: %s", c.Args().First(), v.Claimed, v.Mismatch)
+		}
+		fmt.Printf("result:        match\n")
+		return nil
+	},
+}
@@ -2,15 +2,20 @@ package graphsplit
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"fmt"
 	"io"
 	"os"
 	"path"
+	"runtime"
+	"sync"
 
 	"github.com/filecoin-project/go-commp-utils/v2"
+	"github.com/filecoin-project/go-commp-utils/v2/zerocomm"
 	"github.com/filecoin-project/go-padreader"
 	"github.com/filecoin-project/go-state-types/abi"
+	commphash "github.com/filedrive-team/go-graphsplit/commp"
 	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-car"
 )
@@ -19,10 +24,22 @@ type CommPRet struct {
 	Root        cid.Cid
 	PayloadSize int64
 	Size        abi.UnpaddedPieceSize
+	// PayloadCid is the first root of the CAR
+	PayloadCid cid.Cid
+}
+
+// firstRoot returns the first root of a CAR header, cid.Undef if it has none.
+func firstRoot(h *car.CarHeader) cid.Cid {
+	if len(h.Roots) == 0 {
+		return cid.Undef
+	}
+	return h.Roots[0]
 }
 
 // almost copy paste from https://github.com/filecoin-project/lotus/node/impl/client/client.go#L749-L770
-func CalcCommP(ctx context.Context, inpath string, rename, addPadding bool) (*CommPRet, error) {
+func CalcCommP(ctx context.Context, inpath string, rename, addPadding bool) (ret *CommPRet, err error) {
+	ctx, sp := startSpan(ctx, "commp", "path", inpath)
+	defer func() { sp.End(err) }()
 	dir, _ := path.Split(inpath)
 	// Hard-code the sector type to 32GiBV1_1, because:
 	// - ffiwrapper.GeneratePieceCIDFromFile requires a RegisteredSealProof
@@ -42,6 +59,12 @@ func CalcCommP(ctx context.Context, inpath string, rename, addPadding bool) (*Co
 	if st.IsDir() {
 		return nil, fmt.Errorf("path %s is dir", inpath)
 	}
+	if isZstdFile(inpath) {
+		if addPadding {
+			return nil, fmt.Errorf("cannot pad compressed car %s", inpath)
+		}
+		return calcCommPZstd(inpath, rename)
+	}
 	payloadSize := st.Size()
 
 	rdr, err := os.OpenFile(inpath, os.O_RDWR, 0o644)
@@ -56,17 +79,12 @@ func CalcCommP(ctx context.Context, inpath string, rename, addPadding bool) (*Co
 	}
 	carSize := stat.Size()
 	// check that the data is a car file; if it's not, retrieval won't work
-	_, err = car.ReadHeader(bufio.NewReader(rdr))
+	h, err := car.ReadHeader(bufio.NewReader(rdr))
 	if err != nil {
 		return nil, fmt.Errorf("not a car file: %w", err)
 	}
 
-	if _, err := rdr.Seek(0, io.SeekStart); err != nil {
-		return nil, fmt.Errorf("seek to start: %w", err)
-	}
-
-	pieceReader, pieceSize := padreader.New(rdr, uint64(carSize))
-	commP, err := commp.GeneratePieceCIDFromFile(arbitraryProofType, pieceReader, pieceSize)
+	commP, pieceSize, err := generatePieceCID(arbitraryProofType, rdr, carSize, runtime.NumCPU())
 	if err != nil {
 		return nil, fmt.Errorf("computing commP failed: %w", err)
 	}
@@ -83,6 +101,13 @@ func CalcCommP(ctx context.Context, inpath string, rename, addPadding bool) (*Co
 		if err := PadCar(rdr, carSize); err != nil {
 			return nil, fmt.Errorf("failed to pad car file: %w", err)
 		}
+		padded, err := rdr.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+		if err := ValidatePaddedSize(padded); err != nil {
+			return nil, fmt.Errorf("car(%s): %w", inpath, err)
+		}
 	}
 	if rename {
 		piecePath := path.Join(dir, commP.String())
@@ -95,22 +120,71 @@ func CalcCommP(ctx context.Context, inpath string, rename, addPadding bool) (*Co
 		Root:        commP,
 		Size:        pieceSize,
 		PayloadSize: payloadSize,
+		PayloadCid:  firstRoot(h),
+	}, nil
+}
+
+// calcCommPZstd decompresses the CAR at inpath to a temporary file next to it
+// and computes its commP, a renamed file keeps its compression extension.
+func calcCommPZstd(inpath string, rename bool) (*CommPRet, error) {
+	src, err := openCar(inpath)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close() //nolint:errcheck
+
+	tmp, err := os.CreateTemp(path.Dir(inpath), ".commp-*"+TmpSuffix)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck
+	defer tmp.Close()           //nolint:errcheck
+
+	carSize, err := io.Copy(tmp, src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress %s: %w", inpath, err)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	h, err := car.ReadHeader(bufio.NewReader(tmp))
+	if err != nil {
+		return nil, fmt.Errorf("not a car file: %w", err)
+	}
+	commP, pieceSize, err := generatePieceCID(abi.RegisteredSealProof_StackedDrg32GiBV1_1, tmp, carSize, runtime.NumCPU())
+	if err != nil {
+		return nil, fmt.Errorf("computing commP failed: %w", err)
+	}
+	if rename {
+		piecePath := path.Join(path.Dir(inpath), commP.String()+compressionExt(CompressZstd))
+		if err := os.Rename(inpath, piecePath); err != nil {
+			return nil, fmt.Errorf("rename car(%s) file to piece %w", inpath, err)
+		}
+	}
+	return &CommPRet{
+		Root:        commP,
+		Size:        pieceSize,
+		PayloadSize: carSize,
+		PayloadCid:  firstRoot(h),
 	}, nil
 }
 
 func CalcCommPV2(buf *Buffer, addPadding bool) (*CommPRet, error) {
+	return calcCommPV2(buf, addPadding, runtime.NumCPU())
+}
+
+func calcCommPV2(buf *Buffer, addPadding bool, workers int) (*CommPRet, error) {
 	arbitraryProofType := abi.RegisteredSealProof_StackedDrg32GiBV1_1
 
 	// check that the data is a car file; if it's not, retrieval won't work
-	_, err := car.ReadHeader(bufio.NewReader(buf))
+	h, err := car.ReadHeader(bufio.NewReader(buf))
 	if err != nil {
 		return nil, fmt.Errorf("not a car file: %w", err)
 	}
 	buf.SeekStart()
 
 	carSize := int64(buf.Len())
-	pieceReader, pieceSize := padreader.New(buf, uint64(carSize))
-	commP, err := commp.GeneratePieceCIDFromFile(arbitraryProofType, pieceReader, pieceSize)
+	commP, pieceSize, err := generatePieceCID(arbitraryProofType, bytes.NewReader(buf.Bytes()), carSize, workers)
 	if err != nil {
 		return nil, fmt.Errorf("computing commP failed: %w", err)
 	}
@@ -126,11 +200,81 @@ func CalcCommPV2(buf *Buffer, addPadding bool) (*CommPRet, error) {
 		if err := PadCar(buf, carSize); err != nil {
 			return nil, fmt.Errorf("failed to pad car file: %w", err)
 		}
+		if err := ValidatePaddedSize(int64(buf.Len())); err != nil {
+			return nil, err
+		}
 	}
 
 	return &CommPRet{
 		Root:        commP,
 		Size:        pieceSize,
 		PayloadSize: int64(carSize),
+		PayloadCid:  firstRoot(h),
 	}, nil
 }
+
+// commPLeafSize is the padded size of the sub-pieces hashed in parallel.
+const commPLeafSize = abi.PaddedPieceSize(8 << 20)
+
+// generatePieceCID computes the piece cid of the first size bytes of r zero
+// padded to the piece size with commp.Hasher, which hashes with the SHA
+// instructions of the cpu. Pieces bigger than a leaf are split into 8MiB
+// sub-pieces which are hashed by workers goroutines and aggregated, the result
+// is the same as hashing the whole piece at once.
+func generatePieceCID(_ abi.RegisteredSealProof, r io.ReaderAt, size int64, workers int) (cid.Cid, abi.UnpaddedPieceSize, error) {
+	pieceSize := padreader.PaddedSize(uint64(size))
+	if workers <= 1 || pieceSize.Padded() <= commPLeafSize {
+		commP, err := hashPiece(io.NewSectionReader(r, 0, size))
+		return commP, pieceSize, err
+	}
+
+	leafSize := commPLeafSize.Unpadded()
+	leaves := make([]abi.PieceInfo, pieceSize.Padded()/commPLeafSize)
+	errs := make([]error, len(leaves))
+	throttle := make(chan struct{}, workers)
+	wg := sync.WaitGroup{}
+	for i := range leaves {
+		leaves[i].Size = commPLeafSize
+		off := int64(i) * int64(leafSize)
+		if off >= size {
+			leaves[i].PieceCID = zerocomm.ZeroPieceCommitment(leafSize)
+			continue
+		}
+		n := size - off
+		if n > int64(leafSize) {
+			n = int64(leafSize)
+		}
+		wg.Add(1)
+		throttle <- struct{}{}
+		go func(i int, off, n int64) {
+			defer func() {
+				<-throttle
+				wg.Done()
+			}()
+			leaf := io.MultiReader(io.NewSectionReader(r, off, n), io.LimitReader(NullReader{}, int64(leafSize)-n))
+			leaves[i].PieceCID, errs[i] = hashPiece(leaf)
+		}(i, off, n)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return cid.Undef, 0, err
+		}
+	}
+
+	// aggregate with the biggest sector size, the piece itself may be bigger
+	// than a sector of proofType
+	commP, _, err := commp.PieceAggregateCommP(abi.RegisteredSealProof_StackedDrg64GiBV1_1, leaves)
+	return commP, pieceSize, err
+}
+
+// hashPiece returns the piece cid of the data read from r zero padded to its
+// piece size.
+func hashPiece(r io.Reader) (cid.Cid, error) {
+	h := new(commphash.Hasher)
+	if _, err := io.Copy(h, r); err != nil {
+		return cid.Undef, err
+	}
+	commP, _, err := h.Sum()
+	return commP, err
+}
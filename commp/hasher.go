@@ -0,0 +1,246 @@
+// Package commp computes piece commitments of data streamed through a Hasher
+// whose state can be saved and resumed, so the commitment of a transfer
+// lasting hours survives a restart of the process.
+package commp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"runtime"
+
+	commcid "github.com/filecoin-project/go-fil-commcid"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/ipfs/go-cid"
+	"github.com/klauspost/cpuid/v2"
+	sha256simd "github.com/minio/sha256-simd"
+)
+
+const (
+	// chunkSize is the size of the data fr32 padded to 128 bytes, 4 leaves
+	chunkSize = 127
+	// maxLevel is the level of the root of the biggest piece, 64GiB
+	maxLevel = 31
+
+	snapshotVersion = 1
+)
+
+var zeros [maxLevel + 1][32]byte
+
+func init() {
+	for l := 1; l <= maxLevel; l++ {
+		zeros[l] = hashNodes(&zeros[l-1], &zeros[l-1])
+	}
+}
+
+type subtree struct {
+	level int
+	hash  [32]byte
+}
+
+// Hasher computes the piece cid of the data written to it, zero padded to its
+// piece size like a CAR file. Only the roots of the complete subtrees and the
+// data of a chunk of 127 bytes are kept, Snapshot saves them.
+type Hasher struct {
+	size    uint64
+	partial [chunkSize]byte
+	// stack holds the roots of the complete subtrees, levels decreasing
+	stack []subtree
+}
+
+// Write hashes p, it never fails.
+func (h *Hasher) Write(p []byte) (int, error) {
+	n := len(p)
+	if fill := int(h.size % chunkSize); fill > 0 {
+		c := copy(h.partial[fill:], p)
+		h.size += uint64(c)
+		p = p[c:]
+		if fill+c < chunkSize {
+			return n, nil
+		}
+		h.addChunk(h.partial[:])
+	}
+	for len(p) >= chunkSize {
+		h.addChunk(p[:chunkSize])
+		h.size += chunkSize
+		p = p[chunkSize:]
+	}
+	h.size += uint64(copy(h.partial[:], p))
+	return n, nil
+}
+
+// Size returns the number of bytes written, which is where a resumed stream
+// continues.
+func (h *Hasher) Size() uint64 {
+	return h.size
+}
+
+// Sum returns the piece cid and padded piece size of the data written so
+// far, more data can be written afterwards.
+func (h *Hasher) Sum() (cid.Cid, abi.PaddedPieceSize, error) {
+	if h.size == 0 {
+		return cid.Undef, 0, fmt.Errorf("no data written")
+	}
+	chunks := (h.size + chunkSize - 1) / chunkSize
+	level := bits.Len64(chunks*4 - 1)
+	if level > maxLevel {
+		return cid.Undef, 0, fmt.Errorf("%d bytes exceed the biggest piece", h.size)
+	}
+	level = max(level, 2)
+
+	st := &Hasher{stack: append([]subtree(nil), h.stack...)}
+	if rest := h.size % chunkSize; rest > 0 {
+		var last [chunkSize]byte
+		copy(last[:], h.partial[:rest])
+		st.addChunk(last[:])
+	}
+	stack := st.stack
+	// the last subtree is completed with zeros until it merges with the one
+	// before it, up to the root
+	for len(stack) > 1 || stack[0].level < level {
+		top := &stack[len(stack)-1]
+		if len(stack) > 1 && stack[len(stack)-2].level == top.level {
+			stack[len(stack)-2].hash = hashNodes(&stack[len(stack)-2].hash, &top.hash)
+			stack[len(stack)-2].level++
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		top.hash = hashNodes(&top.hash, &zeros[top.level])
+		top.level++
+	}
+	c, err := commcid.DataCommitmentV1ToCID(stack[0].hash[:])
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+	return c, abi.PaddedPieceSize(32) << level, nil
+}
+
+// addChunk pads a chunk of 127 bytes to 4 leaves and adds them.
+func (h *Hasher) addChunk(chunk []byte) {
+	var out [128]byte
+	fr32Pad(chunk, &out)
+	for i := 0; i < 4; i++ {
+		var leaf [32]byte
+		copy(leaf[:], out[i*32:])
+		h.push(subtree{hash: leaf})
+	}
+}
+
+func (h *Hasher) push(t subtree) {
+	for len(h.stack) > 0 && h.stack[len(h.stack)-1].level == t.level {
+		left := h.stack[len(h.stack)-1]
+		h.stack = h.stack[:len(h.stack)-1]
+		t = subtree{level: t.level + 1, hash: hashNodes(&left.hash, &t.hash)}
+	}
+	h.stack = append(h.stack, t)
+}
+
+// Snapshot returns the state of h, Resume continues from it.
+func (h *Hasher) Snapshot() []byte {
+	rest := h.size % chunkSize
+	buf := []byte{snapshotVersion}
+	buf = binary.AppendUvarint(buf, h.size)
+	buf = append(buf, h.partial[:rest]...)
+	buf = append(buf, byte(len(h.stack)))
+	for _, t := range h.stack {
+		buf = append(buf, byte(t.level))
+		buf = append(buf, t.hash[:]...)
+	}
+	return buf
+}
+
+// Resume returns a Hasher in the state saved by Snapshot, the data written
+// to it has to continue at its Size.
+func Resume(snapshot []byte) (*Hasher, error) {
+	if len(snapshot) == 0 || snapshot[0] != snapshotVersion {
+		return nil, fmt.Errorf("unknown commP snapshot version")
+	}
+	size, n := binary.Uvarint(snapshot[1:])
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid commP snapshot")
+	}
+	buf := snapshot[1+n:]
+	rest := int(size % chunkSize)
+	if len(buf) < rest+1 {
+		return nil, fmt.Errorf("commP snapshot is truncated")
+	}
+	h := &Hasher{size: size}
+	copy(h.partial[:], buf[:rest])
+	count := int(buf[rest])
+	buf = buf[rest+1:]
+	if len(buf) != count*33 {
+		return nil, fmt.Errorf("commP snapshot is truncated")
+	}
+	// the complete subtrees are the bits of the number of leaves
+	var leaves uint64
+	for i := 0; i < count; i++ {
+		t := subtree{level: int(buf[i*33])}
+		copy(t.hash[:], buf[i*33+1:(i+1)*33])
+		if t.level > maxLevel || (i > 0 && t.level >= h.stack[i-1].level) {
+			return nil, fmt.Errorf("invalid commP snapshot")
+		}
+		leaves += 1 << t.level
+		h.stack = append(h.stack, t)
+	}
+	if leaves != size/chunkSize*4 {
+		return nil, fmt.Errorf("commP snapshot does not match its size")
+	}
+	return h, nil
+}
+
+// hashNodes is the sha256 of two nodes truncated to 254 bits.
+func hashNodes(left, right *[32]byte) [32]byte {
+	var buf [64]byte
+	copy(buf[:32], left[:])
+	copy(buf[32:], right[:])
+	out := sha256simd.Sum256(buf[:])
+	out[31] &= 0x3f
+	return out
+}
+
+// implementation mirrors the cpu feature checks sha256-simd picks its block
+// function with.
+var implementation = func() string {
+	switch {
+	case runtime.GOARCH == "amd64" && cpuid.CPU.Supports(cpuid.SHA, cpuid.SSSE3, cpuid.SSE4):
+		return "SHA-NI"
+	case runtime.GOARCH == "arm64" && cpuid.CPU.Has(cpuid.SHA2):
+		return "ARM SHA2"
+	}
+	return "go standard library"
+}()
+
+// Implementation names the sha256 implementation nodes are hashed with. It is
+// picked for the cpu at runtime: the SHA extensions of x86 (SHA-NI) or the
+// SHA2 instructions of ARM, or the standard library on cpus without them.
+func Implementation() string {
+	return implementation
+}
+
+// fr32Pad spreads 127 bytes over 4 words of 254 bits.
+func fr32Pad(in []byte, out *[128]byte) {
+	copy(out[:31], in[:31])
+	t := in[31] >> 6
+	out[31] = in[31] & 0x3f
+	var v byte
+	for i := 32; i < 64; i++ {
+		v = in[i]
+		out[i] = v<<2 | t
+		t = v >> 6
+	}
+	t = v >> 4
+	out[63] &= 0x3f
+	for i := 64; i < 96; i++ {
+		v = in[i]
+		out[i] = v<<4 | t
+		t = v >> 4
+	}
+	t = v >> 2
+	out[95] &= 0x3f
+	for i := 96; i < 127; i++ {
+		v = in[i]
+		out[i] = v<<6 | t
+		t = v >> 2
+	}
+	out[127] = t & 0x3f
+}
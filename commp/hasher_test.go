@@ -0,0 +1,51 @@
+package commp
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/filecoin-project/go-commp-utils/v2/writer"
+)
+
+func TestHasherResume(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, size := range []int{1000, 127 * 64, 300000} {
+		data := make([]byte, size)
+		rng.Read(data)
+		w := new(writer.Writer)
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		want, err := w.Sum()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		h := &Hasher{}
+		split := size / 3
+		h.Write(data[:split])
+		resumed, err := Resume(h.Snapshot())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resumed.Size() != uint64(split) {
+			t.Fatalf("expected size %d, got %d", split, resumed.Size())
+		}
+		resumed.Write(data[split:])
+		got, pieceSize, err := resumed.Sum()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equals(want.PieceCID) || pieceSize != want.PieceSize {
+			t.Fatalf("%d bytes: expected %s of %d bytes, got %s of %d bytes", size, want.PieceCID, want.PieceSize, got, pieceSize)
+		}
+	}
+}
+
+func TestImplementation(t *testing.T) {
+	switch impl := Implementation(); impl {
+	case "SHA-NI", "ARM SHA2", "go standard library":
+	default:
+		t.Fatalf("unexpected sha256 implementation %q", impl)
+	}
+}
@@ -0,0 +1,237 @@
+package graphsplit
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CommPResult is the piece cid of a CAR file computed by CommPDir.
+type CommPResult struct {
+	Path        string `json:"path"`
+	PayloadCid  string `json:"payload_cid,omitempty"`
+	PieceCid    string `json:"piece_cid,omitempty"`
+	PieceSize   uint64 `json:"piece_size,omitempty"`
+	PayloadSize int64  `json:"payload_size,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+var commPResultHeader = []string{"path", "payload_cid", "piece_cid", "piece_size", "payload_size", "error"}
+
+// CommPDir computes the piece cids of the CAR files below dir, .car and
+// .car.zst files, parallel at a time. Every result is passed to onResult as
+// soon as it is computed, files in skip are left out. Failures are passed to
+// onResult too and counted in the returned error. Piece cids are looked up
+// in cache first if it is not nil.
+func CommPDir(ctx context.Context, dir string, parallel int, rename, addPadding bool, cache *CommPCache, skip map[string]bool, onResult func(CommPResult)) error {
+	all, err := carFiles(dir)
+	if err != nil {
+		return err
+	}
+	var cars []string
+	for _, p := range all {
+		if !skip[p] {
+			cars = append(cars, p)
+		}
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed int
+	)
+	limitCh := make(chan struct{}, parallel)
+	for _, p := range cars {
+		if ctx.Err() != nil {
+			break
+		}
+		limitCh <- struct{}{}
+		wg.Add(1)
+		go func(p string) {
+			defer func() {
+				<-limitCh
+				wg.Done()
+			}()
+			res := CommPResult{Path: p}
+			ret, err := cache.CalcCommP(ctx, p, rename, addPadding)
+			if err != nil {
+				res.Error = err.Error()
+			} else {
+				res.PieceCid = ret.Root.String()
+				res.PieceSize = uint64(ret.Size)
+				res.PayloadSize = ret.PayloadSize
+				if ret.PayloadCid.Defined() {
+					res.PayloadCid = ret.PayloadCid.String()
+				}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				log.Errorf("commP of %s failed: %s", p, err)
+				failed++
+			}
+			onResult(res)
+		}(p)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("commP of %d of %d CAR files failed", failed, len(cars))
+	}
+	return nil
+}
+
+// carFiles returns the sorted .car and .car.zst files below dir.
+func carFiles(dir string) ([]string, error) {
+	var cars []string
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(fi.Name(), compressionExt(CompressZstd))
+		if !fi.IsDir() && strings.HasSuffix(name, ".car") {
+			cars = append(cars, path)
+		}
+		return nil
+	})
+	sort.Strings(cars)
+	return cars, err
+}
+
+// CommPOutput appends the results of CommPDir to a CSV file, or to a JSON
+// file with one result per line if its name ends with .json.
+type CommPOutput struct {
+	f    *os.File
+	json bool
+	csv  *csv.Writer
+}
+
+// OpenCommPOutput opens the output file at path and returns the paths it
+// holds a piece cid of, so an interrupted run can skip them. Failed files are
+// computed again.
+func OpenCommPOutput(path string) (*CommPOutput, map[string]bool, error) {
+	out := &CommPOutput{json: strings.EqualFold(filepath.Ext(path), ".json")}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+	out.f = f
+	var results []CommPResult
+	if out.json {
+		results, err = readCommPJSON(f)
+	} else {
+		results, err = readCommPCSV(f)
+	}
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	done := make(map[string]bool, len(results))
+	for _, res := range results {
+		if res.Error == "" {
+			done[res.Path] = true
+		}
+	}
+	end, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if out.json && end > 0 {
+		// the last line of an interrupted run may lack its newline
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, end-1); err == nil && last[0] != '\n' {
+			if _, err := f.Write([]byte{'\n'}); err != nil {
+				f.Close()
+				return nil, nil, err
+			}
+		}
+	}
+	if !out.json {
+		out.csv = csv.NewWriter(f)
+		if end == 0 {
+			if err := out.csv.Write(commPResultHeader); err != nil {
+				f.Close()
+				return nil, nil, err
+			}
+			out.csv.Flush()
+		}
+	}
+	return out, done, nil
+}
+
+// Write appends res to the output file.
+func (out *CommPOutput) Write(res CommPResult) error {
+	if out.json {
+		data, err := json.Marshal(res)
+		if err != nil {
+			return err
+		}
+		_, err = out.f.Write(append(data, '\n'))
+		return err
+	}
+	err := out.csv.Write([]string{
+		res.Path,
+		res.PayloadCid,
+		res.PieceCid,
+		strconv.FormatUint(res.PieceSize, 10),
+		strconv.FormatInt(res.PayloadSize, 10),
+		res.Error,
+	})
+	if err != nil {
+		return err
+	}
+	out.csv.Flush()
+	return out.csv.Error()
+}
+
+func (out *CommPOutput) Close() error {
+	return out.f.Close()
+}
+
+func readCommPJSON(r io.Reader) ([]CommPResult, error) {
+	var results []CommPResult
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		var res CommPResult
+		// the last line of an interrupted run may be cut off
+		if err := json.Unmarshal([]byte(line), &res); err != nil {
+			log.Warnf("skip invalid line %q", line)
+			continue
+		}
+		results = append(results, res)
+	}
+	return results, sc.Err()
+}
+
+func readCommPCSV(r io.Reader) ([]CommPResult, error) {
+	rows, err := parseManifest(r)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]CommPResult, 0, len(rows))
+	for _, row := range rows {
+		res := CommPResult{Path: row["path"], PayloadCid: row["payload_cid"], PieceCid: row["piece_cid"], Error: row["error"]}
+		if res.PieceCid == "" && res.Error == "" {
+			res.Error = "incomplete row"
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
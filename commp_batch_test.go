@@ -0,0 +1,60 @@
+package graphsplit
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+)
+
+func TestCommPDirSkipsOutput(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.car", "b.car"} {
+		writeTestCar(t, filepath.Join(dir, name), []blocks.Block{blocks.NewBlock(bytes.Repeat([]byte(name), 200))})
+	}
+	for _, outName := range []string{"out.csv", "out.json"} {
+		outPath := filepath.Join(t.TempDir(), outName)
+		out, done, err := OpenCommPOutput(outPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// only a.car was computed before
+		var first CommPResult
+		if err := CommPDir(context.Background(), dir, 2, false, false, nil, map[string]bool{filepath.Join(dir, "b.car"): true}, func(res CommPResult) {
+			first = res
+			out.Write(res)
+		}); err != nil {
+			t.Fatal(err)
+		}
+		out.Close()
+		if first.Path != filepath.Join(dir, "a.car") || first.PieceCid == "" || len(done) != 0 {
+			t.Fatalf("unexpected result %+v", first)
+		}
+
+		out, done, err = OpenCommPOutput(outPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var computed []string
+		if err := CommPDir(context.Background(), dir, 2, false, false, nil, done, func(res CommPResult) {
+			computed = append(computed, res.Path)
+			out.Write(res)
+		}); err != nil {
+			t.Fatal(err)
+		}
+		out.Close()
+		if len(computed) != 1 || computed[0] != filepath.Join(dir, "b.car") {
+			t.Fatalf("%s: expected only b.car to be computed, got %v", outName, computed)
+		}
+		out, done, err = OpenCommPOutput(outPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out.Close()
+		if len(done) != 2 {
+			t.Fatalf("%s: expected 2 results, got %v", outName, done)
+		}
+	}
+}
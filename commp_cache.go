@@ -0,0 +1,160 @@
+package graphsplit
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/ipfs/go-cid"
+)
+
+// CommPCache remembers the piece cids of CAR files in a file, so commP runs
+// over a car dir only compute the ones of new or changed files. Entries are
+// keyed by the absolute path of the CAR file and checked against its size
+// and modification time, or keyed by the sha256 of its content, which still
+// matches after the file is copied or moved but has to read it.
+type CommPCache struct {
+	mu          sync.Mutex
+	f           *os.File
+	hashContent bool
+	entries     map[string]commPCacheEntry
+}
+
+type commPCacheEntry struct {
+	Key         string `json:"key"`
+	Size        int64  `json:"size"`
+	ModTime     int64  `json:"mod_time,omitempty"`
+	PieceCid    string `json:"piece_cid"`
+	PieceSize   uint64 `json:"piece_size"`
+	PayloadSize int64  `json:"payload_size"`
+	PayloadCid  string `json:"payload_cid,omitempty"`
+}
+
+// OpenCommPCache opens the cache file at path, entries are appended to it
+// one JSON object per line as they are computed.
+func OpenCommPCache(path string, hashContent bool) (*CommPCache, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	cache := &CommPCache{f: f, hashContent: hashContent, entries: make(map[string]commPCacheEntry)}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		var e commPCacheEntry
+		// the last line of an interrupted run may be cut off
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			continue
+		}
+		cache.entries[e.Key] = e
+	}
+	if err := sc.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	end, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if end > 0 {
+		last := []byte{0}
+		if _, err := f.ReadAt(last, end-1); err == nil && last[0] != '\n' {
+			if _, err := f.Write([]byte{'\n'}); err != nil {
+				f.Close()
+				return nil, err
+			}
+		}
+	}
+	return cache, nil
+}
+
+func (cache *CommPCache) Close() error {
+	return cache.f.Close()
+}
+
+// key returns the key of the file at path and the entry it has to match.
+func (cache *CommPCache) key(path string, fi os.FileInfo) (string, commPCacheEntry, error) {
+	e := commPCacheEntry{Size: fi.Size()}
+	if !cache.hashContent {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return "", e, err
+		}
+		e.ModTime = fi.ModTime().UnixNano()
+		return "path:" + abs, e, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", e, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", e, err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), e, nil
+}
+
+// CalcCommP returns the cached piece cid of the CAR file at path, or computes
+// and caches it. Files are renamed or padded by CalcCommP without the cache.
+func (cache *CommPCache) CalcCommP(ctx context.Context, path string, rename, addPadding bool) (*CommPRet, error) {
+	if cache == nil || rename || addPadding {
+		return CalcCommP(ctx, path, rename, addPadding)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	key, want, err := cache.key(path, fi)
+	if err != nil {
+		return nil, err
+	}
+	cache.mu.Lock()
+	e, ok := cache.entries[key]
+	cache.mu.Unlock()
+	if ok && e.Size == want.Size && e.ModTime == want.ModTime {
+		root, err := cid.Decode(e.PieceCid)
+		if err == nil {
+			log.Infof("pieceCID of %s is cached", path)
+			ret := &CommPRet{Root: root, Size: abi.UnpaddedPieceSize(e.PieceSize), PayloadSize: e.PayloadSize}
+			ret.PayloadCid, _ = cid.Decode(e.PayloadCid)
+			return ret, nil
+		}
+	}
+
+	res, err := CalcCommP(ctx, path, false, false)
+	if err != nil {
+		return nil, err
+	}
+	e = want
+	e.Key = key
+	e.PieceCid = res.Root.String()
+	e.PieceSize = uint64(res.Size)
+	e.PayloadSize = res.PayloadSize
+	if res.PayloadCid.Defined() {
+		e.PayloadCid = res.PayloadCid.String()
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.entries[key] = e
+	if _, err := cache.f.Write(append(data, '\n')); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
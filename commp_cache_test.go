@@ -0,0 +1,59 @@
+package graphsplit
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+)
+
+func TestCommPCache(t *testing.T) {
+	dir := t.TempDir()
+	carPath := filepath.Join(dir, "a.car")
+	writeTestCar(t, carPath, []blocks.Block{blocks.NewBlock(bytes.Repeat([]byte("a"), 1000))})
+	cachePath := filepath.Join(dir, "cache.json")
+
+	cache, err := OpenCommPCache(cachePath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := cache.CalcCommP(context.Background(), carPath, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Close()
+
+	// same size and modification time, the cached piece cid is returned
+	fi, _ := os.Stat(carPath)
+	writeTestCar(t, carPath, []blocks.Block{blocks.NewBlock(bytes.Repeat([]byte("b"), 1000))})
+	os.Chtimes(carPath, fi.ModTime(), fi.ModTime())
+	cache, err = OpenCommPCache(cachePath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached, err := cache.CalcCommP(context.Background(), carPath, false, false)
+	cache.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cached.Root.Equals(first.Root) {
+		t.Fatalf("expected the cached piece cid %s, got %s", first.Root, cached.Root)
+	}
+
+	// keyed by content the change is noticed
+	cache, err = OpenCommPCache(cachePath, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	changed, err := cache.CalcCommP(context.Background(), carPath, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed.Root.Equals(first.Root) {
+		t.Fatal("the content changed, the piece cid should be computed again")
+	}
+}
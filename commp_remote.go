@@ -0,0 +1,90 @@
+package graphsplit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+)
+
+// OpenObject opens the object whose key is the prefix of s, like
+// s3://bucket/dir/file.car, and returns a reader streaming it with ranged GETs
+// and its size.
+func (s *S3Source) OpenObject() (io.ReadCloser, int64, error) {
+	resp, err := s.client.do(http.MethodHead, "/"+s.Root(), nil, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	size, err := strconv.ParseInt(resp.header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get the size of s3://%s", s.Root())
+	}
+	name := path.Base(s.Root())
+	r, err := s.Open(Finfo{Path: s.Root(), Name: name, Info: remoteFileInfo{name: name, size: size}}, 0, size)
+	return r, size, err
+}
+
+// OpenHTTPObject opens the file at rawURL and returns a reader streaming it
+// with ranged GETs of rangeSize, concurrency of them fetched ahead, and its
+// size. Files of servers which do not support ranges are read with one GET,
+// their size is -1 if the server does not send it.
+func OpenHTTPObject(ctx context.Context, rawURL string, rangeSize int64, concurrency int) (io.ReadCloser, int64, error) {
+	if rangeSize <= 0 {
+		rangeSize = 16 << 20
+	}
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, rawURL, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("HEAD %s: %s", rawURL, resp.Status)
+	}
+	if resp.ContentLength >= 0 && resp.Header.Get("Accept-Ranges") == "bytes" {
+		size := resp.ContentLength
+		fetch := func(off, n int64) ([]byte, error) {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+n-1))
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusPartialContent {
+				return nil, fmt.Errorf("ranged GET %s: %s", rawURL, resp.Status)
+			}
+			data, err := io.ReadAll(io.LimitReader(resp.Body, n))
+			if err == nil && int64(len(data)) != n {
+				err = fmt.Errorf("ranged GET %s returned %d bytes, expected %d", rawURL, len(data), n)
+			}
+			return data, err
+		}
+		return newRangeReader(0, size, rangeSize, concurrency, fetch), size, nil
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, 0, fmt.Errorf("GET %s: %s", rawURL, resp.Status)
+	}
+	return resp.Body, resp.ContentLength, nil
+}
@@ -0,0 +1,50 @@
+package graphsplit
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+)
+
+func TestCalcCommPHTTP(t *testing.T) {
+	carPath := filepath.Join(t.TempDir(), "a.car")
+	writeTestCar(t, carPath, []blocks.Block{
+		blocks.NewBlock(bytes.Repeat([]byte{1}, 5000)),
+		blocks.NewBlock(bytes.Repeat([]byte{2}, 7000)),
+	})
+	want, err := CalcCommP(context.Background(), carPath, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(carPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "a.car", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	r, size, err := OpenHTTPObject(context.Background(), srv.URL+"/a.car", 1000, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if size != int64(len(data)) {
+		t.Fatalf("expected size %d, got %d", len(data), size)
+	}
+	got, err := CalcCommPReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Root.Equals(want.Root) || got.Size != want.Size {
+		t.Fatalf("expected %s, got %s", want.Root, got.Root)
+	}
+}
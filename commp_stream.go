@@ -0,0 +1,36 @@
+package graphsplit
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/filecoin-project/go-commp-utils/v2/writer"
+	"github.com/ipld/go-car"
+)
+
+// CalcCommPReader computes the piece cid of the CAR read from r, like a pipe
+// from a transfer, as it streams by. Memory is bounded by the 8MiB leaves
+// hashed in parallel, not by the size of the CAR.
+func CalcCommPReader(r io.Reader) (*CommPRet, error) {
+	w := new(writer.Writer)
+	// every byte passes the tee once, the header is read on the way
+	br := bufio.NewReader(io.TeeReader(r, w))
+	h, err := car.ReadHeader(br)
+	if err != nil {
+		return nil, fmt.Errorf("not a car file: %w", err)
+	}
+	if _, err := io.Copy(io.Discard, br); err != nil {
+		return nil, err
+	}
+	sum, err := w.Sum()
+	if err != nil {
+		return nil, fmt.Errorf("computing commP failed: %w", err)
+	}
+	return &CommPRet{
+		Root:        sum.PieceCID,
+		Size:        sum.PieceSize.Unpadded(),
+		PayloadSize: sum.PayloadSize,
+		PayloadCid:  firstRoot(h),
+	}, nil
+}
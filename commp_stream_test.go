@@ -0,0 +1,36 @@
+package graphsplit
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+)
+
+func TestCalcCommPReader(t *testing.T) {
+	carPath := filepath.Join(t.TempDir(), "a.car")
+	var blks []blocks.Block
+	for i := 0; i < 3; i++ {
+		blks = append(blks, blocks.NewBlock(bytes.Repeat([]byte{byte(i)}, 100000)))
+	}
+	writeTestCar(t, carPath, blks)
+	want, err := CalcCommP(context.Background(), carPath, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(carPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got, err := CalcCommPReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Root.Equals(want.Root) || got.Size != want.Size || got.PayloadSize != want.PayloadSize || !got.PayloadCid.Equals(blks[0].Cid()) {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+}
@@ -1,12 +1,18 @@
 package graphsplit
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/base64"
 	"io"
+	"math/rand"
 	"os"
 	"testing"
+
+	"github.com/filecoin-project/go-commp-utils/v2/writer"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/ipld/go-car"
 )
 
 func TestCalcCommP(t *testing.T) {
@@ -38,4 +44,37 @@ func TestCalcCommP(t *testing.T) {
 	if res.Size != 16256 {
 		t.Fatal("Unexpected piece size")
 	}
+	h, err := car.ReadHeader(bufio.NewReader(bytes.NewReader(logob)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.PayloadCid.Equals(h.Roots[0]) {
+		t.Fatalf("expected payload cid %s, got %s", h.Roots[0], res.PayloadCid)
+	}
+}
+
+func TestGeneratePieceCIDParallel(t *testing.T) {
+	data := make([]byte, 20<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	proofType := abi.RegisteredSealProof_StackedDrg32GiBV1_1
+
+	for _, size := range []int{1000, len(data)} {
+		w := new(writer.Writer)
+		if _, err := w.Write(data[:size]); err != nil {
+			t.Fatal(err)
+		}
+		want, err := w.Sum()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, workers := range []int{1, 4} {
+			got, gotSize, err := generatePieceCID(proofType, bytes.NewReader(data), int64(size), workers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equals(want.PieceCID) || gotSize.Padded() != want.PieceSize {
+				t.Fatalf("commP of %d bytes with %d workers %s (%d) differs from %s (%d)", size, workers, got, gotSize.Padded(), want.PieceCID, want.PieceSize)
+			}
+		}
+	}
 }
@@ -0,0 +1,191 @@
+package graphsplit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressZstd compresses CAR files at rest with zstd, they are written as
+// .car.zst and decompressed on the fly by restore and commP.
+const CompressZstd = "zstd"
+
+// ParseCompression checks a CAR compression name, "none" and an empty string
+// mean no compression.
+func ParseCompression(s string) (string, error) {
+	switch strings.ToLower(s) {
+	case "", "none":
+		return "", nil
+	case CompressZstd:
+		return CompressZstd, nil
+	}
+	return "", fmt.Errorf("unknown compression %q, expected zstd or none", s)
+}
+
+func compressionExt(compression string) string {
+	if compression == CompressZstd {
+		return ".zst"
+	}
+	return ""
+}
+
+// isZstdFile reports whether path is a zstd compressed CAR.
+func isZstdFile(path string) bool {
+	return strings.HasSuffix(path, ".zst")
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// compressWriter returns a writer compressing into w, Close flushes it but
+// does not close w.
+func compressWriter(compression string, w io.Writer) (io.WriteCloser, error) {
+	switch compression {
+	case "":
+		return nopWriteCloser{w}, nil
+	case CompressZstd:
+		return zstd.NewWriter(w)
+	}
+	return nil, fmt.Errorf("unknown compression %q", compression)
+}
+
+type zstdReadCloser struct {
+	*zstd.Decoder
+	f *os.File
+}
+
+func (z zstdReadCloser) Close() error {
+	z.Decoder.Close()
+	return z.f.Close()
+}
+
+// openCar opens the CAR file at path, zstd compressed files are decompressed
+// on the fly.
+func openCar(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !isZstdFile(path) {
+		return f, nil
+	}
+	dec, err := zstd.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return zstdReadCloser{Decoder: dec, f: f}, nil
+}
+
+var compressedMagics = []struct {
+	format string
+	offset int
+	magic  []byte
+}{
+	{"zip", 0, []byte("PK\x03\x04")},
+	{"gzip", 0, []byte{0x1f, 0x8b}},
+	{"zstd", 0, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{"xz", 0, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{"bzip2", 0, []byte("BZh")},
+	{"7z", 0, []byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}},
+	{"rar", 0, []byte("Rar!\x1a\x07")},
+	{"lz4", 0, []byte{0x04, 0x22, 0x4d, 0x18}},
+	{"jpeg", 0, []byte{0xff, 0xd8, 0xff}},
+	{"png", 0, []byte("\x89PNG\r\n\x1a\n")},
+	{"gif", 0, []byte("GIF8")},
+	{"webp", 8, []byte("WEBP")},
+	{"mp4", 4, []byte("ftyp")},
+	{"mkv", 0, []byte{0x1a, 0x45, 0xdf, 0xa3}},
+	{"ogg", 0, []byte("OggS")},
+	{"flac", 0, []byte("fLaC")},
+	{"mp3", 0, []byte("ID3")},
+}
+
+var compressedExts = map[string]string{
+	".zip": "zip", ".gz": "gzip", ".tgz": "gzip", ".zst": "zstd", ".xz": "xz",
+	".bz2": "bzip2", ".7z": "7z", ".rar": "rar", ".lz4": "lz4",
+	".jpg": "jpeg", ".jpeg": "jpeg", ".png": "png", ".gif": "gif", ".webp": "webp",
+	".mp4": "mp4", ".mov": "mp4", ".m4a": "mp4", ".mkv": "mkv", ".webm": "mkv",
+	".ogg": "ogg", ".flac": "flac", ".mp3": "mp3",
+}
+
+// SniffCompressed returns the format of already compressed data from the
+// leading bytes of a file, empty if it does not look compressed. Compressing
+// these formats again costs CPU for no gain.
+func SniffCompressed(head []byte) string {
+	for _, m := range compressedMagics {
+		if len(head) >= m.offset+len(m.magic) && bytes.Equal(head[m.offset:m.offset+len(m.magic)], m.magic) {
+			return m.format
+		}
+	}
+	return ""
+}
+
+// DetectCompressed sniffs the file at path and falls back to its extension
+// for formats without a reliable magic number.
+func DetectCompressed(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	head := make([]byte, 16)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	if format := SniffCompressed(head[:n]); format != "" {
+		return format, nil
+	}
+	return compressedExts[strings.ToLower(filepath.Ext(path))], nil
+}
+
+// sliceCompression returns the compression of the CAR of slice and the
+// precompressed manifest column, a JSON object of the already compressed
+// files of the slice by path with their format. Compressing a CAR mostly
+// holding already compressed or encrypted data costs CPU for no gain, it is
+// written uncompressed.
+func (o *callbackOptions) sliceCompression(slice *GraphSlice) (string, string) {
+	if o.compression == "" {
+		return "", ""
+	}
+	if slice.Encryption != "" {
+		log.Infof("skip compression of %s, its file data is encrypted", slice.PayloadCid)
+		return "", ""
+	}
+	formats := make(map[string]string)
+	var total, compressed int64
+	for _, f := range slice.Files {
+		total += f.Size
+		format, err := DetectCompressed(f.Path)
+		if err != nil {
+			// files which can't be sniffed are known by extension
+			format = compressedExts[strings.ToLower(filepath.Ext(f.Path))]
+		}
+		if format != "" {
+			formats[f.Path] = format
+			compressed += f.Size
+		}
+	}
+	var column string
+	if len(formats) > 0 {
+		data, err := json.Marshal(formats)
+		if err != nil {
+			log.Fatal(err)
+		}
+		column = string(data)
+	}
+	if total > 0 && 2*compressed >= total {
+		log.Infof("skip compression of %s, %d of %d bytes are already compressed", slice.PayloadCid, compressed, total)
+		return "", column
+	}
+	return o.compression, column
+}
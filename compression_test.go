@@ -0,0 +1,117 @@
+package graphsplit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectCompressed(t *testing.T) {
+	for head, format := range map[string]string{
+		"PK\x03\x04rest":           "zip",
+		"\xff\xd8\xff\xe0jfif":     "jpeg",
+		"\x00\x00\x00\x18ftypmp42": "mp4",
+		"plain text":               "",
+	} {
+		if got := SniffCompressed([]byte(head)); got != format {
+			t.Errorf("%q: expected %q, got %q", head, format, got)
+		}
+	}
+
+	dir := t.TempDir()
+	movie := filepath.Join(dir, "movie.webm")
+	if err := os.WriteFile(movie, []byte("no magic"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if format, err := DetectCompressed(movie); err != nil || format != "mkv" {
+		t.Fatalf("expected mkv by extension, got %q, %v", format, err)
+	}
+	text := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(text, []byte("notes"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if format, err := DetectCompressed(text); err != nil || format != "" {
+		t.Fatalf("expected no format, got %q, %v", format, err)
+	}
+}
+
+func TestWriteCarZstd(t *testing.T) {
+	o := newCallbackOptions([]CallbackOption{WithCompression(CompressZstd), WithChecksums([]ChecksumAlgo{ChecksumSha256})})
+	data := bytes.Repeat([]byte("graphsplit"), 1000)
+	carPath, cols, err := o.writeCar(filepath.Join(t.TempDir(), "piece.car"), o.compression, bytes.NewReader(data), o.newArtifacts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isZstdFile(carPath) {
+		t.Fatalf("expected a .zst file, got %s", carPath)
+	}
+	if cols["compression"] != CompressZstd || cols["car_size"] != "10000" || cols["sha256"] == "" {
+		t.Fatalf("unexpected manifest columns %v", cols)
+	}
+
+	f, err := openCar(carPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("decompressed data differs")
+	}
+}
+
+func TestSliceCompression(t *testing.T) {
+	dir := t.TempDir()
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(bytes.Repeat([]byte("graphsplit"), 1000))
+	zw.Close()
+	archive := filepath.Join(dir, "logs.gz")
+	text := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(archive, gz.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(text, []byte("notes"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	o := newCallbackOptions([]CallbackOption{WithCompression(CompressZstd)})
+	compression, column := o.sliceCompression(&GraphSlice{Files: []SliceFile{{Path: archive, Size: 900}, {Path: text, Size: 100}}})
+	if compression != "" {
+		t.Fatalf("expected no compression of a slice of gzip data, got %q", compression)
+	}
+	var formats map[string]string
+	if err := json.Unmarshal([]byte(column), &formats); err != nil || len(formats) != 1 || formats[archive] != "gzip" {
+		t.Fatalf("unexpected precompressed column %q, %v", column, err)
+	}
+
+	compression, _ = o.sliceCompression(&GraphSlice{Files: []SliceFile{{Path: archive, Size: 100}, {Path: text, Size: 900}}})
+	if compression != CompressZstd {
+		t.Fatalf("expected zstd for a slice of mostly text, got %q", compression)
+	}
+	compression, _ = o.sliceCompression(&GraphSlice{Encryption: "aes-256-gcm:key", Files: []SliceFile{{Path: text, Size: 900}}})
+	if compression != "" {
+		t.Fatalf("expected no compression of encrypted data, got %q", compression)
+	}
+
+	carDir := t.TempDir()
+	rows := chunkTestTree(t, dir, &ChunkParams{
+		ExpectSliceSize: 1 << 20,
+		CarDir:          carDir,
+		Cb:              CSVCallback(carDir, WithCompression(CompressZstd)),
+	})
+	if len(rows) != 1 || rows[0]["compression"] != "" || rows[0]["precompressed"] == "" {
+		t.Fatalf("expected an uncompressed CAR with the gzip file recorded, got %v", rows)
+	}
+	meta, err := ReadCarMeta(filepath.Join(carDir, rows[0]["payload_cid"]+".car"))
+	if err != nil || meta.Compression != "" {
+		t.Fatalf("expected the uncompressed CAR and its metadata, got %+v, %v", meta, err)
+	}
+}
@@ -8,19 +8,89 @@ import (
 	"strings"
 
 	"github.com/BurntSushi/toml"
+	"github.com/docker/go-units"
 )
 
 type Config struct {
-	SliceSize               int    `toml:"SliceSize" comment:"SliceSize, the size of each slice in bytes, default is 18G"`
-	ExtraFilePath           string `toml:"ExtraFilePath" comment:"ExtraFilePath extra file path, 指向存储了图片、视频等文件的目录"`
-	ExtraFileSizeInOnePiece string `toml:"ExtraFileSizeInOnePiece" comment:"ExtraFileSizeInOnePiece 每个 piece 文件包含图片和视频等文件的大小, 例如：500Mib"`
+	SliceSize               Size     `toml:"SliceSize" comment:"SliceSize, the size of each slice, in bytes or like \"18GiB\", default is 18GiB"`
+	SliceSizeRange          string   `toml:"SliceSizeRange" comment:"SliceSizeRange, pick the size of each slice randomly within the range, e.g. 17GiB-18GiB, SliceSize is ignored when it is set"`
+	SliceSizeStrategy       string   `toml:"SliceSizeStrategy" comment:"SliceSizeStrategy, the slice size of every run, every loop iteration is a run: fixed uses SliceSize, increment adds a byte to SliceSize with every run, list uses SliceSizes in turn, jitter±N, e.g. jitter±1GiB, picks the size of every slice within SliceSize±N. The runs of increment and list are kept in slice-size-state.json in car-dir"`
+	SliceSizes              []string `toml:"SliceSizes" comment:"SliceSizes, the slice sizes of the list strategy, e.g. [\"30GiB\", \"31GiB\"]"`
+	ExtraFilePath           string   `toml:"ExtraFilePath" comment:"ExtraFilePath extra file path, 指向存储了图片、视频等文件的目录"`
+	ExtraFileSizeInOnePiece string   `toml:"ExtraFileSizeInOnePiece" comment:"ExtraFileSizeInOnePiece 每个 piece 文件包含图片和视频等文件的大小, 例如：500Mib"`
+	Parallel                int      `toml:"Parallel" comment:"Parallel, goroutines building file nodes when chunk has no --parallel, 0 picks it from cpu count, storage type and file sizes"`
+	CarDirs                 []string `toml:"CarDirs" comment:"CarDirs, more directories to spread CAR files across besides --car-dir, manifest.csv stays in --car-dir"`
+	ManifestBackupDir       string   `toml:"ManifestBackupDir" comment:"ManifestBackupDir, snapshot manifest.csv and the state files of car-dir into this directory, e.g. on another disk, disabled when empty"`
+	ManifestBackupInterval  string   `toml:"ManifestBackupInterval" comment:"ManifestBackupInterval, time between manifest snapshots, e.g. 1h"`
+	ManifestBackupKeep      int      `toml:"ManifestBackupKeep" comment:"ManifestBackupKeep, number of manifest snapshots kept, 0 keeps all of them"`
+	DB                      string   `toml:"DB" comment:"DB, mongodb:// or postgres:// uri to insert the manifest row of every finished piece into besides manifest.csv, disabled when empty"`
+	DBName                  string   `toml:"DBName" comment:"DBName, the MongoDB database of the pieces"`
+	DBTable                 string   `toml:"DBTable" comment:"DBTable, the MongoDB collection or Postgres table of the pieces"`
+
+	Callbacks []CallbackConfig   `toml:"Callbacks" comment:"Callbacks, registered callbacks run after every piece in order, e.g. [[Callbacks]] with Name = \"http-upload\" and Options = { url = \"https://example.com/{piece_cid}\" }"`
+	Profiles  map[string]Profile `toml:"Profiles" comment:"Profiles, datasets selected with chunk --profile, e.g. [Profiles.ds1] with SourcePath, ParentPath, CarDir, GraphName, UploadS3 and UploadHTTP as the defaults of the chunk flags, and SliceSize, SliceSizeRange, SliceSizeStrategy, SliceSizes, ExtraFilePath, ExtraFileSizeInOnePiece, Parallel, CarDirs, DBTable or Callbacks overriding the values above"`
+	Deals     DealsConfig        `toml:"deals" comment:"deals, the deals chunk --make-deals proposes to every provider for every finished piece with the boost client: providers are miner addresses, price is attoFIL per epoch per GiB, duration is in epochs, wallet is the default wallet of boost if empty, offline proposes offline deals, url is the template of the URL providers fetch the CAR from with {name}, {payload_cid} and {piece_cid}, boost is the path of the boost binary"`
+	IPNI      IPNIConfig         `toml:"ipni" comment:"ipni, the IPNI advertisements chunk --ipni publishes for every finished piece with the piece cid as context id: announce are the announce URLs of the indexers, e.g. https://cid.contact/announce, publisher is the multiaddr of graphsplit serve the indexers fetch the advertisements from, e.g. /dns4/box.example.com/tcp/8080/http, addrs are the multiaddrs the content is retrieved from, the publisher if empty, metadata is the retrieval protocol, http, bitswap or graphsync, topic is /indexer/ingest/mainnet if empty"`
+	Retry     RetryConfig        `toml:"retry" comment:"retry, retries of source file reads, CAR file writes and S3 requests failing with transient errors, max_attempts counts the first attempt, backoff is the wait before the first retry and doubles with every retry"`
+}
+
+// RetryConfig is the retry policy of transient IO errors.
+type RetryConfig struct {
+	MaxAttempts int    `toml:"max_attempts"`
+	Backoff     string `toml:"backoff"`
+}
+
+// DealsConfig is the deals proposed for every finished piece.
+type DealsConfig struct {
+	Providers []string `toml:"providers"`
+	Price     string   `toml:"price"`
+	Duration  int      `toml:"duration"`
+	Wallet    string   `toml:"wallet"`
+	Verified  bool     `toml:"verified"`
+	Offline   bool     `toml:"offline"`
+	URL       string   `toml:"url"`
+	Boost     string   `toml:"boost"`
+}
+
+// IPNIConfig is the IPNI advertisements published for every finished piece.
+type IPNIConfig struct {
+	Announce      []string `toml:"announce"`
+	Publisher     string   `toml:"publisher"`
+	Addrs         []string `toml:"addrs"`
+	Metadata      string   `toml:"metadata"`
+	VerifiedDeal  bool     `toml:"verified_deal"`
+	FastRetrieval bool     `toml:"fast_retrieval"`
+	Topic         string   `toml:"topic"`
+}
+
+// CallbackConfig selects a callback registered with graphsplit.RegisterCallback,
+// Options are passed to its factory.
+type CallbackConfig struct {
+	Name    string            `toml:"Name"`
+	Options map[string]string `toml:"Options"`
 }
 
 func NewConfig() *Config {
 	return &Config{
-		SliceSize:               19327352832, // 18G
+		SliceSize:               18 << 30,
+		SliceSizeRange:          "",
+		SliceSizeStrategy:       "fixed",
+		SliceSizes:              []string{},
 		ExtraFileSizeInOnePiece: "",
 		ExtraFilePath:           "",
+		Parallel:                0,
+		CarDirs:                 []string{},
+		ManifestBackupDir:       "",
+		ManifestBackupInterval:  "1h",
+		ManifestBackupKeep:      24,
+		DB:                      "",
+		DBName:                  "graphsplit",
+		DBTable:                 "pieces",
+		Callbacks:               []CallbackConfig{},
+		Profiles:                map[string]Profile{},
+		Deals:                   DealsConfig{Providers: []string{}, Price: "0", Duration: 1555200, Verified: true, Boost: "boost"},
+		IPNI:                    IPNIConfig{Announce: []string{}, Addrs: []string{}, Metadata: "http", FastRetrieval: true, Topic: "/indexer/ingest/mainnet"},
+		Retry:                   RetryConfig{MaxAttempts: 1, Backoff: "1s"},
 	}
 }
 
@@ -33,13 +103,41 @@ func LoadConfig(filePath string) (*Config, error) {
 	}
 	defer f.Close()
 
-	_, err = toml.NewDecoder(f).Decode(&cfg)
+	md, err := toml.NewDecoder(f).Decode(&cfg)
 	if err != nil {
 		return nil, err
 	}
+	// a misspelled key would otherwise be ignored silently
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, 0, len(undecoded))
+		for _, k := range undecoded {
+			keys = append(keys, k.String())
+		}
+		return nil, fmt.Errorf("unknown config keys: %s", strings.Join(keys, ", "))
+	}
 	return &cfg, nil
 }
 
+// ParseSizeRange parses a size range like "17GiB-18GiB" into its bounds.
+func ParseSizeRange(s string) (int64, int64, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid size range %q, expect min-max, e.g. 17GiB-18GiB", s)
+	}
+	min, err := units.RAMInBytes(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid size range %q: %v", s, err)
+	}
+	max, err := units.RAMInBytes(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid size range %q: %v", s, err)
+	}
+	if min <= 0 || min > max {
+		return 0, 0, fmt.Errorf("invalid size range %q, min has to be greater than 0 and not greater than max", s)
+	}
+	return min, max, nil
+}
+
 func (c *Config) SaveConfig(filePath string) error {
 	f, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
@@ -91,7 +189,7 @@ func generateTOMLWithComments(data any) (string, error) {
 
 		// Check if the line contains a TOML key
 		for key, comment := range comments {
-			if strings.HasPrefix(strings.TrimSpace(line), key+" =") {
+			if strings.HasPrefix(strings.TrimSpace(line), key+" =") || strings.TrimSpace(line) == "["+key+"]" {
 				result = append(result, fmt.Sprintf("# %s", comment))
 			}
 		}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/gozelle/testify/require"
@@ -21,3 +22,113 @@ func TestConfig(t *testing.T) {
 		t.Errorf("expected loaded slice size to be %d, got %d", cfg.SliceSize, loadedCfg.SliceSize)
 	}
 }
+
+func TestParseSizeRange(t *testing.T) {
+	min, max, err := ParseSizeRange("17GiB-18GiB")
+	require.NoError(t, err)
+	require.Equal(t, int64(17<<30), min)
+	require.Equal(t, int64(18<<30), max)
+
+	_, _, err = ParseSizeRange("18GiB-17GiB")
+	require.Error(t, err)
+	_, _, err = ParseSizeRange("18GiB")
+	require.Error(t, err)
+}
+
+func TestOverride(t *testing.T) {
+	require.Equal(t, "GRAPHSPLIT_SLICE_SIZE", EnvName("SliceSize"))
+	require.Equal(t, "GRAPHSPLIT_DB_NAME", EnvName("DBName"))
+	require.Equal(t, "GRAPHSPLIT_RETRY_MAX_ATTEMPTS", EnvName("retry.max_attempts"))
+
+	t.Setenv("GRAPHSPLIT_SLICE_SIZE", "1024")
+	t.Setenv("GRAPHSPLIT_CAR_DIRS", "/a, /b")
+	t.Setenv("GRAPHSPLIT_RETRY_MAX_ATTEMPTS", "3")
+	cfg := NewConfig()
+	require.NoError(t, cfg.ApplyEnv())
+	require.Equal(t, Size(1024), cfg.SliceSize)
+	require.Equal(t, []string{"/a", "/b"}, cfg.CarDirs)
+	require.Equal(t, 3, cfg.Retry.MaxAttempts)
+
+	require.NoError(t, cfg.ApplySets([]string{"SliceSize=17GiB", "retry.backoff=5s"}))
+	require.Equal(t, Size(17<<30), cfg.SliceSize)
+	require.Equal(t, "5s", cfg.Retry.Backoff)
+	// --set CarDirs=/c,/d reaches ApplySets split at the comma
+	require.NoError(t, cfg.ApplySets([]string{"CarDirs=/c", "/d"}))
+	require.Equal(t, []string{"/c", "/d"}, cfg.CarDirs)
+	require.Error(t, cfg.ApplySets([]string{"SliceSise=1"}))
+	require.Error(t, cfg.ApplySets([]string{"Callbacks=x"}))
+}
+
+func TestValidate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	require.NoError(t, os.WriteFile(path, []byte("SliceSize = 1024\nSliceSise = 2048\n[retry]\nmax_atempts = 3\n"), 0644))
+	_, err := LoadConfig(path)
+	require.ErrorContains(t, err, "SliceSise")
+	require.ErrorContains(t, err, "retry.max_atempts")
+
+	cfg := NewConfig()
+	require.NoError(t, cfg.Validate())
+	cfg.SliceSizes = []string{"32GiB", "32GiX"}
+	cfg.ExtraFilePath = filepath.Join(t.TempDir(), "missing")
+	cfg.Retry.Backoff = "2"
+	err = cfg.Validate()
+	require.ErrorContains(t, err, "32GiX")
+	require.ErrorContains(t, err, "ExtraFileSizeInOnePiece is required")
+	require.ErrorContains(t, err, "ExtraFilePath")
+	require.ErrorContains(t, err, "retry.backoff")
+}
+
+func TestSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	for data, want := range map[string]Size{"SliceSize = 1024": 1024, `SliceSize = "17GiB"`: 17 << 30} {
+		require.NoError(t, os.WriteFile(path, []byte(data), 0644))
+		cfg, err := LoadConfig(path)
+		require.NoError(t, err)
+		require.Equal(t, want, cfg.SliceSize)
+	}
+	require.NoError(t, os.WriteFile(path, []byte(`SliceSize = "17GiX"`), 0644))
+	_, err := LoadConfig(path)
+	require.Error(t, err)
+
+	for _, size := range []Size{18 << 30, 18<<30 + 1} {
+		cfg := NewConfig()
+		cfg.SliceSize = size
+		require.NoError(t, cfg.SaveConfig(path))
+		loaded, err := LoadConfig(path)
+		require.NoError(t, err)
+		require.Equal(t, size, loaded.SliceSize)
+	}
+}
+
+func TestProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	data := `SliceSize = "18GiB"
+DBTable = "pieces"
+
+[Profiles.ds1]
+SourcePath = "/data/ds1"
+CarDir = "/cars/ds1"
+SliceSize = "30GiB"
+
+[[Profiles.ds1.Callbacks]]
+Name = "http-upload"
+Options = { url = "https://example.com/{piece_cid}" }
+
+[Profiles.ds2]
+CarDir = "/cars/ds2"
+`
+	require.NoError(t, os.WriteFile(path, []byte(data), 0644))
+	cfg, err := LoadConfig(path)
+	require.NoError(t, err)
+
+	p, err := cfg.Profile("ds1")
+	require.NoError(t, err)
+	require.Equal(t, "/data/ds1", p.SourcePath)
+	require.NoError(t, cfg.ApplyProfile("ds1"))
+	require.Equal(t, Size(30<<30), cfg.SliceSize)
+	require.Equal(t, "pieces", cfg.DBTable)
+	require.Len(t, cfg.Callbacks, 1)
+
+	_, err = cfg.Profile("ds3")
+	require.ErrorContains(t, err, "ds3")
+}
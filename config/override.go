@@ -0,0 +1,169 @@
+package config
+
+import (
+	"encoding"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// EnvPrefix is the prefix of the environment variables overriding config
+// values, e.g. GRAPHSPLIT_SLICE_SIZE for SliceSize and
+// GRAPHSPLIT_RETRY_MAX_ATTEMPTS for retry.max_attempts.
+const EnvPrefix = "GRAPHSPLIT_"
+
+// EnvName returns the environment variable of the config key, a toml key
+// with the table of nested keys, e.g. retry.max_attempts.
+func EnvName(key string) string {
+	var b strings.Builder
+	b.WriteString(EnvPrefix)
+	rs := []rune(key)
+	for i, r := range rs {
+		switch {
+		case r == '.' || r == '-':
+			b.WriteRune('_')
+			continue
+		case i > 0 && unicode.IsUpper(r):
+			prev := rs[i-1]
+			// DBName is DB_NAME and SliceSize SLICE_SIZE
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) ||
+				(unicode.IsUpper(prev) && i+1 < len(rs) && unicode.IsLower(rs[i+1])) {
+				b.WriteRune('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// Set sets the value of the config key from its string form, lists are
+// comma separated.
+func (c *Config) Set(key, value string) error {
+	v, ok := c.field(key)
+	if !ok {
+		return fmt.Errorf("unknown config key %q", key)
+	}
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		if err := u.UnmarshalText([]byte(strings.TrimSpace(value))); err != nil {
+			return fmt.Errorf("invalid value %q of %s: %v", value, key, err)
+		}
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid value %q of %s: %v", value, key, err)
+		}
+		v.SetInt(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("invalid value %q of %s: %v", value, key, err)
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("%s can only be set in the config file", key)
+		}
+		var items []string
+		for _, s := range strings.Split(value, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				items = append(items, s)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("%s can only be set in the config file", key)
+	}
+	return nil
+}
+
+// ApplyEnv overrides the config values of which the environment variable
+// named by EnvName is set.
+func (c *Config) ApplyEnv() error {
+	for _, key := range Keys() {
+		value, ok := os.LookupEnv(EnvName(key))
+		if !ok {
+			continue
+		}
+		if err := c.Set(key, value); err != nil {
+			return fmt.Errorf("%s: %w", EnvName(key), err)
+		}
+	}
+	return nil
+}
+
+// ApplySets overrides config values with key=value pairs, e.g. from flags.
+// A string slice flag splits a list value like CarDirs=/a,/b at the comma,
+// the pieces without a key are joined back to the pair before them.
+func (c *Config) ApplySets(sets []string) error {
+	var pairs []string
+	for _, s := range sets {
+		if !strings.Contains(s, "=") && len(pairs) > 0 {
+			pairs[len(pairs)-1] += "," + s
+			continue
+		}
+		pairs = append(pairs, s)
+	}
+	for _, s := range pairs {
+		key, value, ok := strings.Cut(s, "=")
+		if !ok {
+			return fmt.Errorf("invalid config override %q, expect key=value", s)
+		}
+		if err := c.Set(strings.TrimSpace(key), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Keys returns the keys of the config values which can be overridden.
+func Keys() []string {
+	return keys(reflect.TypeOf(Config{}), "")
+}
+
+func keys(typ reflect.Type, prefix string) []string {
+	var out []string
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		tag := f.Tag.Get("toml")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		switch {
+		case f.Type.Kind() == reflect.Struct:
+			out = append(out, keys(f.Type, prefix+tag+".")...)
+		case f.Type.Kind() == reflect.Slice && f.Type.Elem().Kind() != reflect.String:
+		case f.Type.Kind() == reflect.Map:
+		default:
+			out = append(out, prefix+tag)
+		}
+	}
+	return out
+}
+
+func (c *Config) field(key string) (reflect.Value, bool) {
+	v := reflect.ValueOf(c).Elem()
+	for _, name := range strings.Split(key, ".") {
+		if v.Kind() != reflect.Struct {
+			return reflect.Value{}, false
+		}
+		found := false
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).Tag.Get("toml") == name {
+				v, found = v.Field(i), true
+				break
+			}
+		}
+		if !found {
+			return reflect.Value{}, false
+		}
+	}
+	return v, v.Kind() != reflect.Struct
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Profile is one dataset of a config file shared by several datasets,
+// selected with chunk --profile. Its config values override the top level
+// ones when they are set, the dataset values are the defaults of the chunk
+// flags of the same name.
+type Profile struct {
+	SourcePath string `toml:"SourcePath"`
+	ParentPath string `toml:"ParentPath"`
+	CarDir     string `toml:"CarDir"`
+	GraphName  string `toml:"GraphName"`
+	UploadS3   string `toml:"UploadS3"`
+	UploadHTTP string `toml:"UploadHTTP"`
+
+	SliceSize               Size             `toml:"SliceSize"`
+	SliceSizeRange          string           `toml:"SliceSizeRange"`
+	SliceSizeStrategy       string           `toml:"SliceSizeStrategy"`
+	SliceSizes              []string         `toml:"SliceSizes"`
+	ExtraFilePath           string           `toml:"ExtraFilePath"`
+	ExtraFileSizeInOnePiece string           `toml:"ExtraFileSizeInOnePiece"`
+	Parallel                int              `toml:"Parallel"`
+	CarDirs                 []string         `toml:"CarDirs"`
+	DBTable                 string           `toml:"DBTable"`
+	Callbacks               []CallbackConfig `toml:"Callbacks"`
+}
+
+// Profile returns the profile name, an empty name is no profile.
+func (c *Config) Profile(name string) (*Profile, error) {
+	if name == "" {
+		return &Profile{}, nil
+	}
+	p, ok := c.Profiles[name]
+	if !ok {
+		names := make([]string, 0, len(c.Profiles))
+		for n := range c.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown profile %q, the config has %v", name, names)
+	}
+	return &p, nil
+}
+
+// ApplyProfile overrides the config values with the ones set in the
+// profile name.
+func (c *Config) ApplyProfile(name string) error {
+	p, err := c.Profile(name)
+	if err != nil {
+		return err
+	}
+	pv := reflect.ValueOf(p).Elem()
+	for i := 0; i < pv.NumField(); i++ {
+		if pv.Field(i).IsZero() {
+			continue
+		}
+		if v, ok := c.field(pv.Type().Field(i).Tag.Get("toml")); ok {
+			v.Set(pv.Field(i))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/docker/go-units"
+)
+
+// Size is a size in bytes, written in the config file either as an integer
+// or as a string like "17GiB".
+type Size int64
+
+// ParseSize parses a size like "17GiB" or a number of bytes.
+func ParseSize(s string) (Size, error) {
+	n, err := units.RAMInBytes(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q, expect e.g. 32GiB: %v", s, err)
+	}
+	return Size(n), nil
+}
+
+func (s Size) String() string {
+	return units.BytesSize(float64(s))
+}
+
+func (s *Size) UnmarshalTOML(v any) error {
+	switch v := v.(type) {
+	case int64:
+		*s = Size(v)
+	case string:
+		n, err := ParseSize(v)
+		if err != nil {
+			return err
+		}
+		*s = n
+	default:
+		return fmt.Errorf("invalid size %v, expect an integer or a string like \"32GiB\"", v)
+	}
+	return nil
+}
+
+// MarshalTOML writes s as a string like "18GiB" if that is exact, and as an
+// integer otherwise.
+func (s Size) MarshalTOML() ([]byte, error) {
+	if n, err := units.RAMInBytes(s.String()); err == nil && n == int64(s) {
+		return []byte(strconv.Quote(s.String())), nil
+	}
+	return []byte(strconv.FormatInt(int64(s), 10)), nil
+}
+
+func (s *Size) UnmarshalText(text []byte) error {
+	n, err := ParseSize(string(text))
+	if err != nil {
+		return err
+	}
+	*s = n
+	return nil
+}
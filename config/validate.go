@@ -0,0 +1,120 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/go-units"
+)
+
+// Validate checks the size and duration strings, the paths and the database
+// uri of the config, it returns all the problems found.
+func (c *Config) Validate() error {
+	var errs []error
+	if c.SliceSizeRange != "" {
+		if _, _, err := ParseSizeRange(c.SliceSizeRange); err != nil {
+			errs = append(errs, fmt.Errorf("SliceSizeRange: %w", err))
+		}
+	} else if c.SliceSize <= 0 && c.SliceSizeStrategy != "list" {
+		errs = append(errs, fmt.Errorf("SliceSize has to be greater than 0"))
+	}
+	for _, v := range c.SliceSizes {
+		if err := checkSize(v); err != nil {
+			errs = append(errs, fmt.Errorf("SliceSizes: %w", err))
+		}
+	}
+	if c.ExtraFilePath != "" {
+		if c.ExtraFileSizeInOnePiece == "" {
+			errs = append(errs, fmt.Errorf("ExtraFileSizeInOnePiece is required when ExtraFilePath is set"))
+		}
+		if err := checkDir(c.ExtraFilePath); err != nil {
+			errs = append(errs, fmt.Errorf("ExtraFilePath: %w", err))
+		}
+	}
+	if c.ExtraFileSizeInOnePiece != "" {
+		if err := checkSize(c.ExtraFileSizeInOnePiece); err != nil {
+			errs = append(errs, fmt.Errorf("ExtraFileSizeInOnePiece: %w", err))
+		}
+	}
+	for _, dir := range c.CarDirs {
+		if err := checkDir(dir); err != nil {
+			errs = append(errs, fmt.Errorf("CarDirs: %w", err))
+		}
+	}
+	if c.ManifestBackupDir != "" && c.ManifestBackupInterval != "" {
+		if _, err := time.ParseDuration(c.ManifestBackupInterval); err != nil {
+			errs = append(errs, fmt.Errorf("ManifestBackupInterval: %w", err))
+		}
+	}
+	if c.ManifestBackupKeep < 0 {
+		errs = append(errs, fmt.Errorf("ManifestBackupKeep can not be negative"))
+	}
+	if c.DB != "" && !hasAnyPrefix(c.DB, "mongodb://", "mongodb+srv://", "postgres://", "postgresql://") {
+		errs = append(errs, fmt.Errorf("DB: unsupported database %q, expect a mongodb:// or postgres:// uri", c.DB))
+	}
+	for i, cb := range c.Callbacks {
+		if cb.Name == "" {
+			errs = append(errs, fmt.Errorf("Callbacks[%d]: Name is required", i))
+		}
+	}
+	if c.Deals.Duration < 0 {
+		errs = append(errs, fmt.Errorf("deals.duration can not be negative"))
+	}
+	if c.Deals.Price != "" {
+		if _, err := strconv.ParseUint(c.Deals.Price, 10, 64); err != nil {
+			errs = append(errs, fmt.Errorf("deals.price: invalid attoFIL amount %q", c.Deals.Price))
+		}
+	}
+	switch c.IPNI.Metadata {
+	case "", "http", "bitswap", "graphsync":
+	default:
+		errs = append(errs, fmt.Errorf("ipni.metadata: unsupported retrieval protocol %q, expect http, bitswap or graphsync", c.IPNI.Metadata))
+	}
+	if c.IPNI.Publisher != "" && !strings.HasPrefix(c.IPNI.Publisher, "/") {
+		errs = append(errs, fmt.Errorf("ipni.publisher: %q is not a multiaddr, e.g. /dns4/box.example.com/tcp/8080/http", c.IPNI.Publisher))
+	}
+	if c.Retry.MaxAttempts < 0 {
+		errs = append(errs, fmt.Errorf("retry.max_attempts can not be negative"))
+	}
+	if c.Retry.Backoff != "" {
+		if _, err := time.ParseDuration(c.Retry.Backoff); err != nil {
+			errs = append(errs, fmt.Errorf("retry.backoff: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func checkSize(s string) error {
+	n, err := units.RAMInBytes(s)
+	if err != nil {
+		return fmt.Errorf("invalid size %q, expect e.g. 32GiB: %v", s, err)
+	}
+	if n <= 0 {
+		return fmt.Errorf("invalid size %q, it has to be greater than 0", s)
+	}
+	return nil
+}
+
+func checkDir(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", path)
+	}
+	return nil
+}
+
+func hasAnyPrefix(s string, prefixes ...string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,260 @@
+package graphsplit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrAborted is returned by Chunk when the run was aborted through its
+// Controller.
+var ErrAborted = errors.New("chunking aborted")
+
+// PauseFileName pauses a chunk run after its current slice while it exists
+// in the car dir, see Controller.WatchPauseFile.
+const PauseFileName = "graphsplit.pause"
+
+// Controller pauses, resumes and aborts a chunk run. Pausing takes effect
+// before the next file is read, or with PauseAfterSlice before the next
+// slice is built, aborting before the next slice is built, so no partial
+// CAR file is left behind. A nil Controller never pauses.
+type Controller struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	paused  bool
+	aborted bool
+	// pausing is set until the run reaches the end of its current slice
+	pausing bool
+	// reload is set until the run reloads its settings between slices
+	reload bool
+}
+
+func NewController() *Controller {
+	c := &Controller{}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *Controller) Pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.paused {
+		log.Info("chunking paused")
+	}
+	c.paused, c.pausing = true, false
+}
+
+// PauseAfterSlice pauses the run once the current slice is written, which
+// frees its memory and leaves the car dir complete while paused.
+func (c *Controller) PauseAfterSlice() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.paused && !c.pausing {
+		log.Info("chunking pauses after the current slice")
+	}
+	c.pausing = !c.paused
+}
+
+func (c *Controller) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.paused || c.pausing {
+		log.Info("chunking resumed")
+	}
+	c.paused, c.pausing = false, false
+	c.cond.Broadcast()
+}
+
+func (c *Controller) Abort() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	log.Info("chunking aborted")
+	c.aborted = true
+	c.cond.Broadcast()
+}
+
+// RequestReload asks the run to reload its settings before the next slice,
+// see ChunkParams.Reload.
+func (c *Controller) RequestReload() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	log.Info("reloading the settings before the next slice")
+	c.reload = true
+}
+
+// takeReload reports whether a reload was requested and clears the request.
+func (c *Controller) takeReload() bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	reload := c.reload
+	c.reload = false
+	return reload
+}
+
+// State returns running, pausing, paused or aborted.
+func (c *Controller) State() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case c.aborted:
+		return "aborted"
+	case c.paused:
+		return "paused"
+	case c.pausing:
+		return "pausing"
+	}
+	return "running"
+}
+
+// waitResume blocks while the run is paused.
+func (c *Controller) waitResume() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.paused && !c.aborted {
+		c.cond.Wait()
+	}
+}
+
+// checkpoint blocks while the run is paused and returns ErrAborted once it
+// was aborted.
+func (c *Controller) checkpoint() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	if c.pausing {
+		log.Info("chunking paused")
+		c.paused, c.pausing = true, false
+	}
+	c.mu.Unlock()
+	c.waitResume()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.aborted {
+		return ErrAborted
+	}
+	return nil
+}
+
+// WatchPauseFile pauses the run after its current slice when the file
+// PauseFileName shows up in dir, and resumes it once the file is removed,
+// until ctx is done.
+func (c *Controller) WatchPauseFile(ctx context.Context, dir string, interval time.Duration) {
+	path := filepath.Join(dir, PauseFileName)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	present := false
+	for {
+		_, err := os.Stat(path)
+		switch {
+		case err == nil && !present:
+			log.Infof("found %s", path)
+			present = true
+			c.PauseAfterSlice()
+		case os.IsNotExist(err) && present:
+			log.Infof("%s removed", path)
+			present = false
+			c.Resume()
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// ListenControl serves the controller over HTTP on the unix socket at path:
+//
+//	POST /pause        pause before the next file
+//	POST /pause-slice  pause after the current slice
+//	POST /resume       resume a paused run
+//	POST /abort        stop before the next slice
+//	POST /reload       reload the settings before the next slice
+//	GET  /status       the state of the run
+func ListenControl(path string, c *Controller) (*http.Server, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	for name, action := range map[string]func(){
+		"pause":       c.Pause,
+		"pause-slice": c.PauseAfterSlice,
+		"resume":      c.Resume,
+		"abort":       c.Abort,
+		"reload":      c.RequestReload,
+	} {
+		action := action
+		mux.HandleFunc("/"+name, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			action()
+			fmt.Fprintln(w, c.State())
+		})
+	}
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, c.State())
+	})
+	srv := &http.Server{Handler: mux}
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Errorf("control socket %s: %s", path, err)
+		}
+	}()
+	return srv, nil
+}
+
+// SendControl sends action, pause, pause-slice, resume, abort, reload or
+// status, to the control socket at path and returns the state of the run.
+func SendControl(ctx context.Context, path, action string) (string, error) {
+	method := http.MethodPost
+	switch action {
+	case "pause", "pause-slice", "resume", "abort", "reload":
+	case "status":
+		method = http.MethodGet
+	default:
+		return "", fmt.Errorf("unknown action %q, expect pause, pause-slice, resume, abort, reload or status", action)
+	}
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	req, err := http.NewRequestWithContext(ctx, method, "http://graphsplit/"+action, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", resp.Status, body)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
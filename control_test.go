@@ -0,0 +1,75 @@
+package graphsplit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestControllerPauseFile(t *testing.T) {
+	dir := t.TempDir()
+	c := NewController()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.WatchPauseFile(ctx, dir, 10*time.Millisecond)
+
+	waitState := func(state string) {
+		for i := 0; i < 200; i++ {
+			if c.State() == state {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("expected %s, got %s", state, c.State())
+	}
+	if err := os.WriteFile(filepath.Join(dir, PauseFileName), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitState("pausing")
+	// reading files of the current slice goes on
+	c.waitResume()
+
+	done := make(chan error)
+	go func() { done <- c.checkpoint() }()
+	waitState("paused")
+	select {
+	case <-done:
+		t.Fatal("checkpoint returned while paused")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if err := os.Remove(filepath.Join(dir, PauseFileName)); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if c.State() != "running" {
+		t.Fatalf("expected running, got %s", c.State())
+	}
+}
+
+func TestControllerReload(t *testing.T) {
+	calls := 0
+	params := &ChunkParams{
+		Control: NewController(),
+		Reload: func(p *ChunkParams) error {
+			calls++
+			p.ExpectSliceSize, p.Parallel = 2048, 3
+			return nil
+		},
+		ExpectSliceSize: 1024,
+		parallel:        1,
+	}
+	params.reload()
+	if calls != 0 {
+		t.Fatal("reloaded without a request")
+	}
+	params.Control.RequestReload()
+	params.reload()
+	params.reload()
+	if calls != 1 || params.pickSliceSize() != 2048 || params.parallel != 3 {
+		t.Fatalf("unexpected reload: calls %d, slice size %d, parallel %d", calls, params.pickSliceSize(), params.parallel)
+	}
+}
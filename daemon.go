@@ -0,0 +1,528 @@
+package graphsplit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The job types a Daemon runs.
+const (
+	JobChunk  = "chunk"
+	JobCommP  = "commp"
+	JobVerify = "verify"
+)
+
+// The states of a job.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCanceled  = "canceled"
+)
+
+// ErrNoSuchJob is returned for the id of a job a Daemon doesn't know.
+var ErrNoSuchJob = errors.New("no such job")
+
+// JobRequest submits a job: Args are the flags of the command of Type without
+// the leading dashes, e.g. {"car-dir": "/cars", "slice-size": "30GiB"}, and
+// Input the input path of chunk.
+type JobRequest struct {
+	Type  string            `json:"type"`
+	Input string            `json:"input,omitempty"`
+	Args  map[string]string `json:"args,omitempty"`
+}
+
+// Job is a job of a Daemon. Progress is the last progress report of a chunk
+// job, see Progress. BatchIDs are the batches of the pieces a finished chunk
+// job added to the manifest of its car dir, see ExportBatch.
+type Job struct {
+	JobRequest
+	ID       string          `json:"id"`
+	State    string          `json:"state"`
+	Progress json.RawMessage `json:"progress,omitempty"`
+	Error    string          `json:"error,omitempty"`
+	Created  time.Time       `json:"created"`
+	Started  *time.Time      `json:"started,omitempty"`
+	Finished *time.Time      `json:"finished,omitempty"`
+	BatchIDs []int           `json:"batch_ids,omitempty"`
+	// ControlSocket is the --control-socket of a chunk job
+	ControlSocket string `json:"control_socket,omitempty"`
+	Paused        bool   `json:"paused,omitempty"`
+
+	cancel context.CancelFunc
+}
+
+// CarDir returns the directory holding the manifest of the job, the car-dir
+// argument or the dir argument of commP.
+func (j *Job) CarDir() string {
+	if dir := j.Args["car-dir"]; dir != "" {
+		return strings.Split(dir, ",")[0]
+	}
+	return j.Args["dir"]
+}
+
+// JobRunner runs job until it is done or ctx is canceled. It writes the
+// output of the job to out and hands every progress report to progress.
+type JobRunner func(ctx context.Context, job Job, out io.Writer, progress func(json.RawMessage)) error
+
+// DaemonConfig configures a Daemon.
+type DaemonConfig struct {
+	// LogDir keeps the output of every job as <id>.log
+	LogDir string
+	// MaxJobs is the number of jobs running at a time, 1 if not set
+	MaxJobs int
+	// Token, if set, has to be sent as a bearer token with every request
+	Token string
+	// Validate checks a request before it is queued
+	Validate func(req JobRequest) error
+	Run      JobRunner
+	// Health, if set, is served on /healthz, /readyz and /drain, the probes
+	// without a token. No jobs are submitted while it drains.
+	Health *Health
+}
+
+// Daemon queues submitted jobs and runs MaxJobs of them at a time. It serves
+// the HTTP API:
+//
+//	POST   /jobs              submit a JobRequest
+//	GET    /jobs              list the jobs
+//	GET    /jobs/{id}         status and progress of a job
+//	DELETE /jobs/{id}         cancel a job, also POST /jobs/{id}/cancel
+//	GET    /jobs/{id}/pieces  the manifest rows of the car dir of a job
+//	GET    /jobs/{id}/log     the output of a job
+//	POST   /jobs/{id}/commp   submit a commP job of the car dir of a job
+//	POST   /jobs/{id}/verify  submit a verify job of the car dir of a job
+//	POST   /jobs/{id}/pause   pause a running chunk job, also /resume
+//	POST   /jobs/{id}/reload  reload the config of a running chunk job
+//	GET    /                  the web dashboard
+//
+// and the endpoints of DaemonConfig.Health.
+// Jobs are kept in memory, they are gone once the daemon stops.
+type Daemon struct {
+	cfg   DaemonConfig
+	slots chan struct{}
+	mu    sync.Mutex
+	jobs  []*Job
+	byID  map[string]*Job
+	next  int
+	wg    sync.WaitGroup
+	// changed is closed and replaced whenever a job changed
+	changed chan struct{}
+}
+
+func NewDaemon(cfg DaemonConfig) (*Daemon, error) {
+	if cfg.Run == nil {
+		return nil, fmt.Errorf("daemon needs a job runner")
+	}
+	if cfg.MaxJobs <= 0 {
+		cfg.MaxJobs = 1
+	}
+	if err := os.MkdirAll(cfg.LogDir, 0755); err != nil {
+		return nil, err
+	}
+	return &Daemon{
+		cfg:     cfg,
+		slots:   make(chan struct{}, cfg.MaxJobs),
+		byID:    make(map[string]*Job),
+		changed: make(chan struct{}),
+	}, nil
+}
+
+// Submit queues the job of req and returns it.
+func (d *Daemon) Submit(req JobRequest) (Job, error) {
+	switch req.Type {
+	case JobChunk, JobCommP, JobVerify:
+	default:
+		return Job{}, fmt.Errorf("unknown job type %q, expected %s, %s or %s", req.Type, JobChunk, JobCommP, JobVerify)
+	}
+	if d.cfg.Validate != nil {
+		if err := d.cfg.Validate(req); err != nil {
+			return Job{}, err
+		}
+	}
+	if d.cfg.Health.Draining() {
+		return Job{}, ErrDraining
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	d.mu.Lock()
+	d.next++
+	job := &Job{
+		JobRequest: req,
+		ID:         strconv.Itoa(d.next),
+		State:      JobQueued,
+		Created:    time.Now(),
+		cancel:     cancel,
+	}
+	if req.Type == JobChunk {
+		job.ControlSocket = req.Args["control-socket"]
+		if job.ControlSocket == "" {
+			job.ControlSocket = filepath.Join(d.cfg.LogDir, job.ID+".sock")
+		}
+	}
+	d.jobs = append(d.jobs, job)
+	d.byID[job.ID] = job
+	d.notify()
+	snapshot := *job
+	d.mu.Unlock()
+
+	log.Infof("job %s queued: %s %s", job.ID, req.Type, req.Input)
+	d.wg.Add(1)
+	go d.run(ctx, job)
+	return snapshot, nil
+}
+
+func (d *Daemon) run(ctx context.Context, job *Job) {
+	defer d.wg.Done()
+	defer job.cancel()
+	select {
+	case d.slots <- struct{}{}:
+		defer func() { <-d.slots }()
+	case <-ctx.Done():
+		d.finish(job, ctx.Err())
+		return
+	}
+	if ctx.Err() != nil {
+		d.finish(job, ctx.Err())
+		return
+	}
+	d.mu.Lock()
+	now := time.Now()
+	job.State, job.Started = JobRunning, &now
+	d.notify()
+	snapshot := *job
+	d.mu.Unlock()
+
+	log.Infof("job %s started", job.ID)
+	out, err := os.Create(d.logPath(job.ID))
+	if err != nil {
+		d.finish(job, err)
+		return
+	}
+	defer out.Close()
+	var before map[string]bool
+	if job.Type == JobChunk && job.CarDir() != "" {
+		_, before = manifestBatches(job.CarDir(), nil)
+	}
+	err = d.cfg.Run(ctx, snapshot, out, func(p json.RawMessage) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		job.Progress = append(json.RawMessage(nil), p...)
+		d.notify()
+	})
+	if before != nil {
+		ids, _ := manifestBatches(job.CarDir(), before)
+		d.mu.Lock()
+		job.BatchIDs = ids
+		d.mu.Unlock()
+	}
+	if ctx.Err() != nil {
+		// the exit status of a canceled job doesn't matter
+		err = ctx.Err()
+	}
+	d.finish(job, err)
+}
+
+// manifestBatches returns the sorted batch ids of the rows of the manifest of
+// carDir whose payload is not in skip, and the payloads of all rows. A missing
+// manifest has no rows.
+func manifestBatches(carDir string, skip map[string]bool) ([]int, map[string]bool) {
+	payloads := make(map[string]bool)
+	rows, err := ReadManifest(carDir)
+	if err != nil {
+		return nil, payloads
+	}
+	var ids []int
+	for _, row := range rows {
+		payloads[row["payload_cid"]] = true
+		if skip[row["payload_cid"]] {
+			continue
+		}
+		if id, err := strconv.Atoi(row["batch_id"]); err == nil && !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids, payloads
+}
+
+// batchRows returns the rows of the batch id.
+func batchRows(rows []ManifestRow, id string) []ManifestRow {
+	return slices.DeleteFunc(rows, func(row ManifestRow) bool {
+		return row["batch_id"] != id
+	})
+}
+
+func (d *Daemon) finish(job *Job, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	job.Finished, job.Paused = &now, false
+	switch {
+	case errors.Is(err, context.Canceled):
+		job.State = JobCanceled
+	case err != nil:
+		job.State, job.Error = JobFailed, err.Error()
+	default:
+		job.State = JobSucceeded
+	}
+	d.notify()
+	log.Infof("job %s %s", job.ID, job.State)
+}
+
+// notify wakes up the watchers of jobs, d.mu has to be held.
+func (d *Daemon) notify() {
+	close(d.changed)
+	d.changed = make(chan struct{})
+}
+
+// WatchJob calls fn with the job id, and again whenever its state or progress
+// changed, until the job is finished, fn fails or ctx is done.
+func (d *Daemon) WatchJob(ctx context.Context, id string, fn func(Job) error) error {
+	var last *Job
+	for {
+		d.mu.Lock()
+		job, ok := d.byID[id]
+		if !ok {
+			d.mu.Unlock()
+			return ErrNoSuchJob
+		}
+		snapshot, changed := *job, d.changed
+		d.mu.Unlock()
+		if last == nil || last.State != snapshot.State || !bytes.Equal(last.Progress, snapshot.Progress) {
+			if err := fn(snapshot); err != nil {
+				return err
+			}
+			last = &snapshot
+		}
+		if snapshot.Finished != nil {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Cancel stops the job id, a running job stops like on SIGTERM.
+func (d *Daemon) Cancel(id string) (Job, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	job, ok := d.byID[id]
+	if !ok {
+		return Job{}, false
+	}
+	job.cancel()
+	return *job, true
+}
+
+// Control sends action, pause, resume or reload, to the running chunk job id.
+// Pausing takes effect before the next file is read.
+func (d *Daemon) Control(ctx context.Context, id, action string) (Job, error) {
+	job, ok := d.Job(id)
+	if !ok {
+		return Job{}, ErrNoSuchJob
+	}
+	if job.State != JobRunning || job.ControlSocket == "" {
+		return Job{}, fmt.Errorf("job %s is not a running chunk job", id)
+	}
+	if action != "pause" && action != "resume" && action != "reload" {
+		return Job{}, fmt.Errorf("unknown action %q, expect pause, resume or reload", action)
+	}
+	state, err := SendControl(ctx, job.ControlSocket, action)
+	if err != nil {
+		return Job{}, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.byID[id].Paused = state == "paused"
+	d.notify()
+	return *d.byID[id], nil
+}
+
+// Job returns the job id.
+func (d *Daemon) Job(id string) (Job, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	job, ok := d.byID[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// Jobs returns all jobs in the order they were submitted.
+func (d *Daemon) Jobs() []Job {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	jobs := make([]Job, 0, len(d.jobs))
+	for _, job := range d.jobs {
+		jobs = append(jobs, *job)
+	}
+	return jobs
+}
+
+// Shutdown cancels all jobs and waits until they stopped or ctx is done.
+func (d *Daemon) Shutdown(ctx context.Context) error {
+	d.mu.Lock()
+	for _, job := range d.jobs {
+		job.cancel()
+	}
+	d.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *Daemon) logPath(id string) string {
+	return filepath.Join(d.cfg.LogDir, id+".log")
+}
+
+// ServeHTTP serves the API of the daemon.
+func (d *Daemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if d.cfg.Health != nil && (r.URL.Path == "/healthz" || r.URL.Path == "/readyz") {
+		d.cfg.Health.ServeHTTP(w, r)
+		return
+	}
+	// the dashboard asks for the token itself
+	if r.URL.Path == "/" && r.Method == http.MethodGet {
+		serveDashboard(w, r)
+		return
+	}
+	if d.cfg.Token != "" && r.Header.Get("Authorization") != "Bearer "+d.cfg.Token {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if d.cfg.Health != nil && isHealthPath(r.URL.Path) {
+		d.cfg.Health.ServeHTTP(w, r)
+		return
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] != "jobs" || len(parts) > 3 {
+		http.NotFound(w, r)
+		return
+	}
+	if len(parts) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, d.Jobs())
+		case http.MethodPost:
+			var req JobRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, fmt.Sprintf("invalid job request: %s", err), http.StatusBadRequest)
+				return
+			}
+			d.submit(w, req)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	job, ok := d.Job(parts[1])
+	if !ok {
+		http.Error(w, ErrNoSuchJob.Error(), http.StatusNotFound)
+		return
+	}
+	action := ""
+	if len(parts) == 3 {
+		action = parts[2]
+	}
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, job)
+	case action == "" && r.Method == http.MethodDelete,
+		action == "cancel" && r.Method == http.MethodPost:
+		job, _ = d.Cancel(job.ID)
+		writeJSON(w, http.StatusOK, job)
+	case action == "pieces" && r.Method == http.MethodGet:
+		if job.CarDir() == "" {
+			http.Error(w, "job has no car dir", http.StatusBadRequest)
+			return
+		}
+		rows, err := ReadManifest(job.CarDir())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if id := r.URL.Query().Get("batch_id"); id != "" {
+			rows = batchRows(rows, id)
+		}
+		writeJSON(w, http.StatusOK, rows)
+	case (action == "pause" || action == "resume" || action == "reload") && r.Method == http.MethodPost:
+		job, err := d.Control(r.Context(), job.ID, action)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeJSON(w, http.StatusOK, job)
+	case action == "log" && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		http.ServeFile(w, r, d.logPath(job.ID))
+	case (action == JobCommP || action == JobVerify) && r.Method == http.MethodPost:
+		if job.CarDir() == "" {
+			http.Error(w, "job has no car dir", http.StatusBadRequest)
+			return
+		}
+		req := JobRequest{Type: action, Args: map[string]string{"car-dir": job.CarDir()}}
+		if action == JobCommP {
+			req.Args = map[string]string{"dir": job.CarDir()}
+		}
+		// optional extra flags, e.g. {"full": "true"}
+		if r.ContentLength != 0 {
+			var args map[string]string
+			if err := json.NewDecoder(r.Body).Decode(&args); err != nil && err != io.EOF {
+				http.Error(w, fmt.Sprintf("invalid args: %s", err), http.StatusBadRequest)
+				return
+			}
+			for k, v := range args {
+				req.Args[k] = v
+			}
+		}
+		d.submit(w, req)
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+func (d *Daemon) submit(w http.ResponseWriter, req JobRequest) {
+	job, err := d.Submit(req)
+	if err == ErrDraining {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusCreated, job)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v) //nolint:errcheck
+}
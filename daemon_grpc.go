@@ -0,0 +1,181 @@
+package graphsplit
+
+//go:generate protoc -I proto --go_out=proto --go_opt=paths=source_relative --go-grpc_out=proto --go-grpc_opt=paths=source_relative graphsplit/daemon/v1/daemon.proto
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	daemonv1 "github.com/filedrive-team/go-graphsplit/proto/graphsplit/daemon/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// NewDaemonGRPCServer returns a gRPC server of the API of d, defined in
+// proto/graphsplit/daemon/v1/daemon.proto. With a token it has to be sent as
+// "authorization: Bearer <token>" metadata.
+func NewDaemonGRPCServer(d *Daemon) *grpc.Server {
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := d.grpcAuth(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := d.grpcAuth(ss.Context()); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	)
+	daemonv1.RegisterDaemonServer(srv, &daemonServer{d: d})
+	return srv
+}
+
+func (d *Daemon) grpcAuth(ctx context.Context) error {
+	if d.cfg.Token == "" {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		if v == "Bearer "+d.cfg.Token {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "unauthorized")
+}
+
+// daemonServer implements the Daemon service with a Daemon.
+type daemonServer struct {
+	daemonv1.UnimplementedDaemonServer
+	d *Daemon
+}
+
+func (s *daemonServer) SubmitJob(ctx context.Context, req *daemonv1.SubmitJobRequest) (*daemonv1.Job, error) {
+	job, err := s.d.Submit(JobRequest{Type: req.Type, Input: req.Input, Args: req.Args})
+	if err == ErrDraining {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return jobProto(job), nil
+}
+
+func (s *daemonServer) GetJob(ctx context.Context, req *daemonv1.GetJobRequest) (*daemonv1.Job, error) {
+	job, ok := s.d.Job(req.Id)
+	if !ok {
+		return nil, grpcError(ErrNoSuchJob)
+	}
+	return jobProto(job), nil
+}
+
+func (s *daemonServer) ListJobs(ctx context.Context, req *daemonv1.ListJobsRequest) (*daemonv1.ListJobsResponse, error) {
+	resp := &daemonv1.ListJobsResponse{}
+	for _, job := range s.d.Jobs() {
+		resp.Jobs = append(resp.Jobs, jobProto(job))
+	}
+	return resp, nil
+}
+
+func (s *daemonServer) CancelJob(ctx context.Context, req *daemonv1.CancelJobRequest) (*daemonv1.Job, error) {
+	job, ok := s.d.Cancel(req.Id)
+	if !ok {
+		return nil, grpcError(ErrNoSuchJob)
+	}
+	return jobProto(job), nil
+}
+
+func (s *daemonServer) WatchJob(req *daemonv1.WatchJobRequest, stream daemonv1.Daemon_WatchJobServer) error {
+	return grpcError(s.d.WatchJob(stream.Context(), req.Id, func(job Job) error {
+		return stream.Send(jobProto(job))
+	}))
+}
+
+func (s *daemonServer) ListPieces(ctx context.Context, req *daemonv1.ListPiecesRequest) (*daemonv1.ListPiecesResponse, error) {
+	job, ok := s.d.Job(req.JobId)
+	if !ok {
+		return nil, grpcError(ErrNoSuchJob)
+	}
+	if job.CarDir() == "" {
+		return nil, status.Error(codes.FailedPrecondition, "job has no car dir")
+	}
+	rows, err := ReadManifest(job.CarDir())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if req.BatchId != 0 {
+		rows = batchRows(rows, strconv.FormatInt(req.BatchId, 10))
+	}
+	resp := &daemonv1.ListPiecesResponse{}
+	for _, row := range rows {
+		resp.Pieces = append(resp.Pieces, pieceProto(row))
+	}
+	return resp, nil
+}
+
+// jobProto converts job to its message, Progress from the JSON of chunk
+// --progress-json.
+func jobProto(job Job) *daemonv1.Job {
+	m := &daemonv1.Job{
+		Id:      job.ID,
+		Type:    job.Type,
+		Input:   job.Input,
+		Args:    job.Args,
+		State:   job.State,
+		Error:   job.Error,
+		Created: timestamppb.New(job.Created),
+	}
+	if len(job.Progress) > 0 {
+		p := &daemonv1.Progress{}
+		if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(job.Progress, p); err == nil {
+			m.Progress = p
+		}
+	}
+	if job.Started != nil {
+		m.Started = timestamppb.New(*job.Started)
+	}
+	if job.Finished != nil {
+		m.Finished = timestamppb.New(*job.Finished)
+	}
+	for _, id := range job.BatchIDs {
+		m.BatchIds = append(m.BatchIds, int64(id))
+	}
+	return m
+}
+
+// pieceProto converts a manifest row to its message, all columns are in its
+// columns map.
+func pieceProto(row ManifestRow) *daemonv1.Piece {
+	m := &daemonv1.Piece{
+		PayloadCid: row["payload_cid"],
+		PieceCid:   row["piece_cid"],
+		CarFile:    row["car_file"],
+		Columns:    row,
+	}
+	size := row["piece_size"]
+	if size == "" {
+		size = row["padded_piece_size"]
+	}
+	m.PieceSize, _ = strconv.ParseUint(size, 10, 64)
+	m.BatchId, _ = strconv.ParseInt(row["batch_id"], 10, 64)
+	return m
+}
+
+func grpcError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, ErrNoSuchJob):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
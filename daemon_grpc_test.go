@@ -0,0 +1,106 @@
+package graphsplit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	daemonv1 "github.com/filedrive-team/go-graphsplit/proto/graphsplit/daemon/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestDaemonGRPC(t *testing.T) {
+	carDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(carDir, "manifest.csv"), []byte("payload_cid,filename,piece_cid,piece_size,detail,batch_id\nbafy1,a.car,baga1,2048,{},1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDaemon(DaemonConfig{
+		LogDir: t.TempDir(),
+		Token:  "secret",
+		Run: func(ctx context.Context, job Job, out io.Writer, progress func(json.RawMessage)) error {
+			row := map[string]string{"payload_cid": "bafy2", "filename": "b.car", "piece_cid": "baga2", "piece_size": "4096", "batch_id": "2"}
+			if err := appendManifest(job.CarDir(), []string{"payload_cid", "filename", "piece_cid", "piece_size", "detail", "batch_id"}, row); err != nil {
+				return err
+			}
+			progress(json.RawMessage(`{"op":"chunk","bytes_read":10,"slices_done":1,"elapsed_seconds":1.5}`))
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewDaemonGRPCServer(d)
+	go srv.Serve(ln) //nolint:errcheck
+	defer srv.Stop()
+
+	conn, err := grpc.Dial(ln.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := daemonv1.NewDaemonClient(conn)
+
+	_, err = client.SubmitJob(context.Background(), &daemonv1.SubmitJobRequest{Type: JobChunk, Input: "/data"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated without token, got %v", err)
+	}
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer secret")
+	job, err := client.SubmitJob(ctx, &daemonv1.SubmitJobRequest{Type: JobChunk, Input: "/data", Args: map[string]string{"car-dir": carDir}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Id == "" || job.Args["car-dir"] != carDir || job.Created.AsTime().IsZero() {
+		t.Fatalf("unexpected job %v", job)
+	}
+
+	stream, err := client.WatchJob(ctx, &daemonv1.WatchJobRequest{Id: job.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last *daemonv1.Job
+	for {
+		ev, err := stream.Recv()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		last = ev
+	}
+	if last.State != JobSucceeded || last.Finished == nil || last.Progress.GetBytesRead() != 10 || last.Progress.GetElapsedSeconds() != 1.5 {
+		t.Fatalf("unexpected last event %v", last)
+	}
+	if len(last.BatchIds) != 1 || last.BatchIds[0] != 2 {
+		t.Fatalf("expected the batch of the added piece, got %v", last.BatchIds)
+	}
+
+	pieces, err := client.ListPieces(ctx, &daemonv1.ListPiecesRequest{JobId: job.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pieces.Pieces) != 2 || pieces.Pieces[0].PieceCid != "baga1" || pieces.Pieces[0].PieceSize != 2048 || pieces.Pieces[0].BatchId != 1 {
+		t.Fatalf("unexpected pieces %v", pieces.Pieces)
+	}
+	pieces, err = client.ListPieces(ctx, &daemonv1.ListPiecesRequest{JobId: job.Id, BatchId: 2})
+	if err != nil || len(pieces.Pieces) != 1 || pieces.Pieces[0].PayloadCid != "bafy2" {
+		t.Fatalf("unexpected pieces of batch 2 %v %v", pieces, err)
+	}
+	jobs, err := client.ListJobs(ctx, &daemonv1.ListJobsRequest{})
+	if err != nil || len(jobs.Jobs) != 1 {
+		t.Fatalf("expected 1 job, got %v %v", jobs, err)
+	}
+	if _, err := client.GetJob(ctx, &daemonv1.GetJobRequest{Id: "42"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("expected NotFound, got %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+package graphsplit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDaemon(t *testing.T) {
+	carDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(carDir, "manifest.csv"), []byte("payload_cid,filename,detail,batch_id\nbafy1,a.car,{},1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	block := make(chan struct{})
+	d, err := NewDaemon(DaemonConfig{
+		LogDir: t.TempDir(),
+		Token:  "secret",
+		Run: func(ctx context.Context, job Job, out io.Writer, progress func(json.RawMessage)) error {
+			fmt.Fprintf(out, "running %s\n", job.Type)
+			if job.Type == JobChunk && job.CarDir() != "" {
+				row := map[string]string{"payload_cid": "bafy2", "filename": "b.car", "batch_id": "2"}
+				if err := appendManifest(job.CarDir(), []string{"payload_cid", "filename", "detail", "batch_id"}, row); err != nil {
+					return err
+				}
+			}
+			progress(json.RawMessage(`{"slices_done":1}`))
+			if job.Input == "block" {
+				select {
+				case <-block:
+				case <-ctx.Done():
+				}
+				return fmt.Errorf("exit status 130")
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(d)
+	defer srv.Close()
+
+	call := func(method, path, body string, v interface{}) int {
+		req, _ := http.NewRequest(method, srv.URL+path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if v != nil {
+			json.NewDecoder(resp.Body).Decode(v) //nolint:errcheck
+		}
+		return resp.StatusCode
+	}
+	wait := func(id, state string) Job {
+		for i := 0; i < 200; i++ {
+			if job, _ := d.Job(id); job.State == state {
+				return job
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("job %s did not get %s", id, state)
+		return Job{}
+	}
+
+	if resp, err := http.Get(srv.URL + "/jobs"); err != nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without token, got %v %v", resp, err)
+	}
+	if code := call("POST", "/jobs", `{"type":"pack"}`, nil); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown type, got %d", code)
+	}
+
+	var job Job
+	if code := call("POST", "/jobs", `{"type":"chunk","input":"/data","args":{"car-dir":"`+carDir+`"}}`, &job); code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", code)
+	}
+	job = wait(job.ID, JobSucceeded)
+	if string(job.Progress) != `{"slices_done":1}` {
+		t.Fatalf("unexpected progress %s", job.Progress)
+	}
+	if len(job.BatchIDs) != 1 || job.BatchIDs[0] != 2 {
+		t.Fatalf("expected the batch of the added piece, got %v", job.BatchIDs)
+	}
+	var rows []ManifestRow
+	if call("GET", "/jobs/"+job.ID+"/pieces", "", &rows); len(rows) != 2 || rows[0]["payload_cid"] != "bafy1" || rows[0]["batch_id"] != "1" {
+		t.Fatalf("unexpected pieces %v", rows)
+	}
+	if call("GET", "/jobs/"+job.ID+"/pieces?batch_id=2", "", &rows); len(rows) != 1 || rows[0]["payload_cid"] != "bafy2" {
+		t.Fatalf("unexpected pieces of batch 2 %v", rows)
+	}
+	var verify Job
+	if call("POST", "/jobs/"+job.ID+"/verify", `{"full":"true"}`, &verify); verify.Type != JobVerify || verify.Args["car-dir"] != carDir || verify.Args["full"] != "true" {
+		t.Fatalf("unexpected verify job %+v", verify)
+	}
+	wait(verify.ID, JobSucceeded)
+
+	if resp, err := http.Get(srv.URL + "/"); err != nil || resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Fatalf("expected the dashboard without token, got %v %v", resp, err)
+	}
+	if code := call("POST", "/jobs/"+job.ID+"/pause", "", nil); code != http.StatusConflict {
+		t.Fatalf("expected 409 pausing a finished job, got %d", code)
+	}
+
+	var blocked Job
+	call("POST", "/jobs", `{"type":"chunk","input":"block"}`, &blocked)
+	wait(blocked.ID, JobRunning)
+	if blocked.ControlSocket == "" {
+		t.Fatal("expected a control socket for a chunk job")
+	}
+	call("DELETE", "/jobs/"+blocked.ID, "", nil)
+	wait(blocked.ID, JobCanceled)
+
+	var jobs []Job
+	if call("GET", "/jobs", "", &jobs); len(jobs) != 3 {
+		t.Fatalf("expected 3 jobs, got %d", len(jobs))
+	}
+	if err := d.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
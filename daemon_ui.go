@@ -0,0 +1,16 @@
+package graphsplit
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+//go:embed webui/index.html
+var dashboardHTML []byte
+
+// serveDashboard serves the web dashboard of the daemon, a single page
+// polling the API of the daemon.
+func serveDashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(dashboardHTML) //nolint:errcheck
+}
@@ -0,0 +1,208 @@
+package dataset
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/filedrive-team/go-graphsplit"
+	"github.com/lib/pq"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// PieceRecord is the metadata of a finished piece inserted by DBCallback.
+type PieceRecord struct {
+	PayloadCid  string                 `bson:"payload_cid" json:"payload_cid"`
+	PieceCid    string                 `bson:"piece_cid" json:"piece_cid"`
+	PieceSize   uint64                 `bson:"piece_size" json:"piece_size"`
+	PayloadSize int64                  `bson:"payload_size" json:"payload_size"`
+	Filename    string                 `bson:"filename" json:"filename"`
+	CarFile     string                 `bson:"car_file" json:"car_file"`
+	Columns     map[string]string      `bson:"columns" json:"columns"`
+	Files       []graphsplit.SliceFile `bson:"files" json:"files"`
+	CreatedAt   time.Time              `bson:"created_at" json:"created_at"`
+}
+
+// pieceStore keeps piece records, a record of the same payload and piece
+// cid replaces the earlier one so retried slices are stored once.
+type pieceStore interface {
+	Put(ctx context.Context, rec *PieceRecord) error
+	Close() error
+}
+
+// DBCallback inserts the manifest row of every finished piece into MongoDB,
+// the database Import stores blocks in, or Postgres. It has to follow the
+// callback writing the CAR file and the manifest.
+type DBCallback struct {
+	carDir string
+	store  pieceStore
+}
+
+// NewDBCallback connects to the database of uri, mongodb:// or
+// postgres://. Records go to the collection or table of the given name, in
+// database db for MongoDB.
+func NewDBCallback(ctx context.Context, carDir, uri, db, table string) (*DBCallback, error) {
+	var (
+		store pieceStore
+		err   error
+	)
+	switch {
+	case strings.HasPrefix(uri, "mongodb://") || strings.HasPrefix(uri, "mongodb+srv://"):
+		store, err = newMongoStore(ctx, uri, db, table)
+	case strings.HasPrefix(uri, "postgres://") || strings.HasPrefix(uri, "postgresql://"):
+		store, err = newPostgresStore(ctx, uri, table)
+	default:
+		return nil, fmt.Errorf("unsupported database %q, expect a mongodb:// or postgres:// uri", uri)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &DBCallback{carDir: carDir, store: store}, nil
+}
+
+func (dc *DBCallback) OnSuccess(buf *graphsplit.Buffer, slice *graphsplit.GraphSlice) {
+	rows, err := graphsplit.ReadManifest(dc.carDir)
+	if err != nil {
+		log.Fatalf("failed to read manifest: %s", err)
+	}
+	var row graphsplit.ManifestRow
+	for _, r := range rows {
+		if r["payload_cid"] == slice.PayloadCid {
+			row = r
+		}
+	}
+	if row == nil {
+		log.Fatalf("no manifest row of %s", slice.PayloadCid)
+	}
+	rec := &PieceRecord{
+		PayloadCid: slice.PayloadCid,
+		PieceCid:   row["piece_cid"],
+		Filename:   row["filename"],
+		CarFile:    row["car_file"],
+		Columns:    row,
+		Files:      slice.Files,
+		CreatedAt:  time.Now().UTC(),
+	}
+	rec.PieceSize, _ = strconv.ParseUint(row["piece_size"], 10, 64)
+	rec.PayloadSize, _ = strconv.ParseInt(row["payload_size"], 10, 64)
+	if err := dc.store.Put(context.Background(), rec); err != nil {
+		log.Fatalf("failed to insert piece %s into the database: %s", slice.PayloadCid, err)
+	}
+}
+
+func (dc *DBCallback) OnError(err error) {
+	log.Fatal(err)
+}
+
+func (dc *DBCallback) Close() error {
+	return dc.store.Close()
+}
+
+type mongoStore struct {
+	client *mongo.Client
+	coll   *mongo.Collection
+}
+
+func newMongoStore(ctx context.Context, uri, db, collection string) (*mongoStore, error) {
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		client.Disconnect(ctx) //nolint:errcheck
+		return nil, err
+	}
+	return &mongoStore{client: client, coll: client.Database(db).Collection(collection)}, nil
+}
+
+func (ms *mongoStore) Put(ctx context.Context, rec *PieceRecord) error {
+	filter := bson.M{"payload_cid": rec.PayloadCid, "piece_cid": rec.PieceCid}
+	_, err := ms.coll.ReplaceOne(ctx, filter, rec, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (ms *mongoStore) Close() error {
+	return ms.client.Disconnect(context.Background())
+}
+
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+type postgresStore struct {
+	db    *sql.DB
+	table string
+}
+
+func newPostgresStore(ctx context.Context, uri, table string) (*postgresStore, error) {
+	if !tableName.MatchString(table) {
+		return nil, fmt.Errorf("invalid table name %q", table)
+	}
+	db, err := sql.Open("postgres", uri)
+	if err != nil {
+		return nil, err
+	}
+	table = pq.QuoteIdentifier(table)
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+table+` (
+		payload_cid  TEXT NOT NULL,
+		piece_cid    TEXT NOT NULL,
+		piece_size   BIGINT NOT NULL,
+		payload_size BIGINT NOT NULL,
+		filename     TEXT NOT NULL,
+		car_file     TEXT NOT NULL,
+		columns      JSONB NOT NULL,
+		files        JSONB NOT NULL,
+		created_at   TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (payload_cid, piece_cid)
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &postgresStore{db: db, table: table}, nil
+}
+
+func (ps *postgresStore) Put(ctx context.Context, rec *PieceRecord) error {
+	columns, err := json.Marshal(rec.Columns)
+	if err != nil {
+		return err
+	}
+	files, err := json.Marshal(rec.Files)
+	if err != nil {
+		return err
+	}
+	_, err = ps.db.ExecContext(ctx, `INSERT INTO `+ps.table+`
+		(payload_cid, piece_cid, piece_size, payload_size, filename, car_file, columns, files, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (payload_cid, piece_cid) DO UPDATE SET
+		piece_size = EXCLUDED.piece_size, payload_size = EXCLUDED.payload_size, filename = EXCLUDED.filename,
+		car_file = EXCLUDED.car_file, columns = EXCLUDED.columns, files = EXCLUDED.files, created_at = EXCLUDED.created_at`,
+		rec.PayloadCid, rec.PieceCid, int64(rec.PieceSize), rec.PayloadSize, rec.Filename, rec.CarFile,
+		string(columns), string(files), rec.CreatedAt)
+	return err
+}
+
+func (ps *postgresStore) Close() error {
+	return ps.db.Close()
+}
+
+func init() {
+	graphsplit.RegisterCallback("db", func(carDir string, options map[string]string) (graphsplit.GraphBuildCallback, error) {
+		if options["uri"] == "" {
+			return nil, fmt.Errorf("uri is required")
+		}
+		db, table := options["database"], options["table"]
+		if db == "" {
+			db = "graphsplit"
+		}
+		if table == "" {
+			table = "pieces"
+		}
+		return NewDBCallback(context.Background(), carDir, options["uri"], db, table)
+	})
+}
@@ -0,0 +1,70 @@
+package dataset
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/filedrive-team/go-graphsplit"
+)
+
+// memStore keeps piece records in memory by payload and piece cid.
+type memStore struct {
+	records map[[2]string]*PieceRecord
+	closed  bool
+}
+
+func (ms *memStore) Put(ctx context.Context, rec *PieceRecord) error {
+	ms.records[[2]string{rec.PayloadCid, rec.PieceCid}] = rec
+	return nil
+}
+
+func (ms *memStore) Close() error {
+	ms.closed = true
+	return nil
+}
+
+func TestDBCallback(t *testing.T) {
+	carDir := t.TempDir()
+	manifest := "payload_cid,filename,piece_cid,payload_size,piece_size,car_file\n" +
+		"bafy1,graph-1.car,baga1,100,254,/cars/baga1.car\n" +
+		"bafy2,graph-2.car,baga2,200,508,/cars/baga2.car\n"
+	if err := os.WriteFile(filepath.Join(carDir, graphsplit.ManifestFileName), []byte(manifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	store := &memStore{records: make(map[[2]string]*PieceRecord)}
+	cb := &DBCallback{carDir: carDir, store: store}
+
+	files := []graphsplit.SliceFile{{Path: "dir/a.txt", Offset: 0, Size: 100}}
+	cb.OnSuccess(nil, &graphsplit.GraphSlice{PayloadCid: "bafy2", Files: files})
+	// a retried slice replaces its record
+	cb.OnSuccess(nil, &graphsplit.GraphSlice{PayloadCid: "bafy2", Files: files})
+	if len(store.records) != 1 {
+		t.Fatalf("expected one record, got %d", len(store.records))
+	}
+	rec := store.records[[2]string{"bafy2", "baga2"}]
+	if rec == nil {
+		t.Fatalf("no record of bafy2 in %v", store.records)
+	}
+	if rec.PieceSize != 508 || rec.PayloadSize != 200 || rec.Filename != "graph-2.car" || rec.CarFile != "/cars/baga2.car" {
+		t.Fatalf("unexpected record %+v", rec)
+	}
+	if rec.Columns["piece_cid"] != "baga2" || len(rec.Files) != 1 || rec.Files[0].Path != "dir/a.txt" || rec.CreatedAt.IsZero() {
+		t.Fatalf("unexpected columns or files of %+v", rec)
+	}
+	if err := cb.Close(); err != nil || !store.closed {
+		t.Fatalf("expected the store closed, got %v", err)
+	}
+}
+
+func TestNewDBCallbackErrors(t *testing.T) {
+	ctx := context.Background()
+	if _, err := NewDBCallback(ctx, t.TempDir(), "mysql://localhost/graphsplit", "graphsplit", "pieces"); err == nil {
+		t.Fatal("expected an error for a mysql uri")
+	}
+	// the table name is checked before connecting
+	if _, err := NewDBCallback(ctx, t.TempDir(), "postgres://localhost/graphsplit", "", "pieces; DROP TABLE x"); err == nil {
+		t.Fatal("expected an error for an invalid table name")
+	}
+}
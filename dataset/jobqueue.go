@@ -0,0 +1,197 @@
+package dataset
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The states of a queued job.
+const (
+	QueuedJobPending = "pending"
+	QueuedJobRunning = "running"
+	QueuedJobDone    = "done"
+	QueuedJobFailed  = "failed"
+)
+
+// ErrLeaseLost is returned to a worker whose job was finished, requeued or
+// claimed by another worker after its lease expired.
+var ErrLeaseLost = errors.New("lease of the job lost")
+
+// QueuedJob is a chunk job of a JobQueue. {id} in CarDir and GraphName is
+// replaced by the id of the job when it is enqueued, so jobs of one dataset
+// write to their own car dirs.
+type QueuedJob struct {
+	ID        string `bson:"_id" json:"id"`
+	Input     string `bson:"input" json:"input"`
+	CarDir    string `bson:"car_dir" json:"car_dir"`
+	GraphName string `bson:"graph_name" json:"graph_name"`
+	SliceSize int64  `bson:"slice_size" json:"slice_size"`
+	// Args are more chunk flags without the leading dashes
+	Args map[string]string `bson:"args" json:"args,omitempty"`
+
+	State      string    `bson:"state" json:"state"`
+	Worker     string    `bson:"worker" json:"worker,omitempty"`
+	LeaseUntil time.Time `bson:"lease_until" json:"lease_until,omitempty"`
+	Attempts   int       `bson:"attempts" json:"attempts"`
+	// Progress is the last chunk --progress-json report of the worker
+	Progress string    `bson:"progress" json:"progress,omitempty"`
+	Error    string    `bson:"error" json:"error,omitempty"`
+	Created  time.Time `bson:"created" json:"created"`
+	Updated  time.Time `bson:"updated" json:"updated"`
+}
+
+func (j *QueuedJob) expandID() {
+	j.CarDir = strings.ReplaceAll(j.CarDir, "{id}", j.ID)
+	j.GraphName = strings.ReplaceAll(j.GraphName, "{id}", j.ID)
+}
+
+// JobQueue holds chunk jobs which workers on several machines claim with
+// leases. A worker has to renew the lease of its job with Heartbeat, a job
+// whose lease expired is claimed again by the next worker.
+type JobQueue interface {
+	// Enqueue adds job as pending and sets its ID
+	Enqueue(ctx context.Context, job *QueuedJob) error
+	// Claim leases the oldest pending job, or a running one whose lease
+	// expired, to worker. It returns nil if there is none.
+	Claim(ctx context.Context, worker string, lease time.Duration) (*QueuedJob, error)
+	// Heartbeat renews the lease of the job and records its progress
+	Heartbeat(ctx context.Context, id, worker string, lease time.Duration, progress string) error
+	// Finish marks the job done, or failed with jobErr
+	Finish(ctx context.Context, id, worker string, jobErr error) error
+	// Requeue makes a failed or running job pending again
+	Requeue(ctx context.Context, id string) error
+	// List returns all jobs in the order they were enqueued
+	List(ctx context.Context) ([]QueuedJob, error)
+	Close() error
+}
+
+// OpenJobQueue connects to the queue of uri, mongodb:// or redis://. name
+// is the MongoDB collection, in the database of the uri or graphsplit, or
+// the prefix of the Redis keys.
+func OpenJobQueue(ctx context.Context, uri, name string) (JobQueue, error) {
+	switch {
+	case strings.HasPrefix(uri, "mongodb://") || strings.HasPrefix(uri, "mongodb+srv://"):
+		return newMongoJobQueue(ctx, uri, name)
+	case strings.HasPrefix(uri, "redis://") || strings.HasPrefix(uri, "rediss://"):
+		return newRedisJobQueue(ctx, uri, name)
+	}
+	return nil, fmt.Errorf("unsupported job queue %q, expect a mongodb:// or redis:// uri", uri)
+}
+
+type mongoJobQueue struct {
+	client *mongo.Client
+	coll   *mongo.Collection
+}
+
+func newMongoJobQueue(ctx context.Context, uri, collection string) (*mongoJobQueue, error) {
+	db := "graphsplit"
+	if u, err := url.Parse(uri); err == nil && strings.Trim(u.Path, "/") != "" {
+		db = strings.Trim(u.Path, "/")
+	}
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		client.Disconnect(ctx) //nolint:errcheck
+		return nil, err
+	}
+	return &mongoJobQueue{client: client, coll: client.Database(db).Collection(collection)}, nil
+}
+
+func (mq *mongoJobQueue) Enqueue(ctx context.Context, job *QueuedJob) error {
+	now := time.Now().UTC()
+	job.ID = primitive.NewObjectID().Hex()
+	job.expandID()
+	job.State, job.Created, job.Updated = QueuedJobPending, now, now
+	_, err := mq.coll.InsertOne(ctx, job)
+	return err
+}
+
+func (mq *mongoJobQueue) Claim(ctx context.Context, worker string, lease time.Duration) (*QueuedJob, error) {
+	now := time.Now().UTC()
+	filter := bson.M{"$or": bson.A{
+		bson.M{"state": QueuedJobPending},
+		bson.M{"state": QueuedJobRunning, "lease_until": bson.M{"$lt": now}},
+	}}
+	update := bson.M{
+		"$set": bson.M{"state": QueuedJobRunning, "worker": worker, "lease_until": now.Add(lease), "error": "", "updated": now},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "created", Value: 1}}).SetReturnDocument(options.After)
+	var job QueuedJob
+	err := mq.coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// updateLeased updates the job if worker still holds its lease.
+func (mq *mongoJobQueue) updateLeased(ctx context.Context, id, worker string, set bson.M) error {
+	set["updated"] = time.Now().UTC()
+	res, err := mq.coll.UpdateOne(ctx, bson.M{"_id": id, "worker": worker, "state": QueuedJobRunning}, bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+func (mq *mongoJobQueue) Heartbeat(ctx context.Context, id, worker string, lease time.Duration, progress string) error {
+	set := bson.M{"lease_until": time.Now().UTC().Add(lease)}
+	if progress != "" {
+		set["progress"] = progress
+	}
+	return mq.updateLeased(ctx, id, worker, set)
+}
+
+func (mq *mongoJobQueue) Finish(ctx context.Context, id, worker string, jobErr error) error {
+	set := bson.M{"state": QueuedJobDone, "lease_until": time.Time{}}
+	if jobErr != nil {
+		set["state"], set["error"] = QueuedJobFailed, jobErr.Error()
+	}
+	return mq.updateLeased(ctx, id, worker, set)
+}
+
+func (mq *mongoJobQueue) Requeue(ctx context.Context, id string) error {
+	res, err := mq.coll.UpdateOne(ctx, bson.M{"_id": id, "state": bson.M{"$in": bson.A{QueuedJobFailed, QueuedJobRunning}}},
+		bson.M{"$set": bson.M{"state": QueuedJobPending, "worker": "", "lease_until": time.Time{}, "updated": time.Now().UTC()}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("no failed or running job %s", id)
+	}
+	return nil
+}
+
+func (mq *mongoJobQueue) List(ctx context.Context) ([]QueuedJob, error) {
+	cur, err := mq.coll.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "created", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	var jobs []QueuedJob
+	if err := cur.All(ctx, &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+func (mq *mongoJobQueue) Close() error {
+	return mq.client.Disconnect(context.Background())
+}
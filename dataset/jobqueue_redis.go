@@ -0,0 +1,363 @@
+package dataset
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redisConn is a minimal RESP client, enough for the job queue. It
+// reconnects on the next command after a network error.
+type redisConn struct {
+	uri  *url.URL
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// redisError is an error reply of the server.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+func dialRedis(ctx context.Context, uri string) (*redisConn, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	rc := &redisConn{uri: u}
+	if _, err := rc.do(ctx, "PING"); err != nil {
+		return nil, err
+	}
+	return rc, nil
+}
+
+func (rc *redisConn) connect(ctx context.Context) error {
+	host := rc.uri.Host
+	if rc.uri.Port() == "" {
+		host = net.JoinHostPort(rc.uri.Hostname(), "6379")
+	}
+	d := &net.Dialer{Timeout: 10 * time.Second}
+	var (
+		conn net.Conn
+		err  error
+	)
+	if rc.uri.Scheme == "rediss" {
+		conn, err = (&tls.Dialer{NetDialer: d, Config: &tls.Config{ServerName: rc.uri.Hostname()}}).DialContext(ctx, "tcp", host)
+	} else {
+		conn, err = d.DialContext(ctx, "tcp", host)
+	}
+	if err != nil {
+		return err
+	}
+	rc.conn, rc.r = conn, bufio.NewReader(conn)
+	var setup [][]string
+	if pw, ok := rc.uri.User.Password(); ok {
+		if user := rc.uri.User.Username(); user != "" {
+			setup = append(setup, []string{"AUTH", user, pw})
+		} else {
+			setup = append(setup, []string{"AUTH", pw})
+		}
+	}
+	if db := strings.Trim(rc.uri.Path, "/"); db != "" && db != "0" {
+		setup = append(setup, []string{"SELECT", db})
+	}
+	for _, args := range setup {
+		if _, err := rc.roundTrip(ctx, args); err != nil {
+			rc.close()
+			return err
+		}
+	}
+	return nil
+}
+
+func (rc *redisConn) close() {
+	if rc.conn != nil {
+		rc.conn.Close()
+		rc.conn = nil
+	}
+}
+
+// do sends the command args and returns its reply: a string, an int64, nil
+// or a []interface{} of them.
+func (rc *redisConn) do(ctx context.Context, args ...string) (interface{}, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.conn == nil {
+		if err := rc.connect(ctx); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := rc.roundTrip(ctx, args)
+	var re redisError
+	if err != nil && !errors.As(err, &re) {
+		rc.close()
+	}
+	return reply, err
+}
+
+func (rc *redisConn) roundTrip(ctx context.Context, args []string) (interface{}, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		rc.conn.SetDeadline(deadline) //nolint:errcheck
+	} else {
+		rc.conn.SetDeadline(time.Now().Add(time.Minute)) //nolint:errcheck
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := io.WriteString(rc.conn, b.String()); err != nil {
+		return nil, err
+	}
+	return readRedisReply(rc.r)
+}
+
+func readRedisReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readRedisReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+// redisJobQueue keeps every job in the hash <prefix>:job:<id>, the ids of
+// pending jobs in the list <prefix>:pending, the lease expiry of running jobs
+// in the sorted set <prefix>:leases and all ids in the sorted set
+// <prefix>:jobs. Claims and updates are Lua scripts, so they are atomic.
+type redisJobQueue struct {
+	rc     *redisConn
+	prefix string
+}
+
+func newRedisJobQueue(ctx context.Context, uri, prefix string) (*redisJobQueue, error) {
+	rc, err := dialRedis(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	return &redisJobQueue{rc: rc, prefix: "graphsplit:" + prefix}, nil
+}
+
+func (rq *redisJobQueue) key(parts ...string) string {
+	return rq.prefix + ":" + strings.Join(parts, ":")
+}
+
+func (rq *redisJobQueue) eval(ctx context.Context, script string, keys []string, args ...string) (interface{}, error) {
+	cmd := append([]string{"EVAL", script, strconv.Itoa(len(keys))}, keys...)
+	return rq.rc.do(ctx, append(cmd, args...)...)
+}
+
+func unixMilli(t time.Time) string {
+	return strconv.FormatInt(t.UnixMilli(), 10)
+}
+
+const redisEnqueueScript = `
+redis.call('HSET', KEYS[1], 'spec', ARGV[1], 'state', 'pending', 'attempts', 0, 'created', ARGV[2], 'updated', ARGV[2])
+redis.call('ZADD', KEYS[2], ARGV[3], ARGV[4])
+redis.call('RPUSH', KEYS[3], ARGV[4])
+return 1`
+
+func (rq *redisJobQueue) Enqueue(ctx context.Context, job *QueuedJob) error {
+	id, err := rq.rc.do(ctx, "INCR", rq.key("next"))
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	job.ID = strconv.FormatInt(id.(int64), 10)
+	job.expandID()
+	job.State, job.Created, job.Updated = QueuedJobPending, now, now
+	spec, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	_, err = rq.eval(ctx, redisEnqueueScript, []string{rq.key("job", job.ID), rq.key("jobs"), rq.key("pending")},
+		string(spec), unixMilli(now), strconv.FormatInt(id.(int64), 10), job.ID)
+	return err
+}
+
+const redisClaimScript = `
+local id = redis.call('LPOP', KEYS[1])
+while id and redis.call('HGET', ARGV[4] .. id, 'state') ~= 'pending' do
+	id = redis.call('LPOP', KEYS[1])
+end
+if not id then
+	id = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', '(' .. ARGV[1], 'LIMIT', 0, 1)[1]
+end
+if not id then
+	return false
+end
+redis.call('ZADD', KEYS[2], ARGV[2], id)
+local key = ARGV[4] .. id
+redis.call('HSET', key, 'state', 'running', 'worker', ARGV[3], 'lease_until', ARGV[2], 'error', '', 'updated', ARGV[1])
+redis.call('HINCRBY', key, 'attempts', 1)
+return id`
+
+func (rq *redisJobQueue) Claim(ctx context.Context, worker string, lease time.Duration) (*QueuedJob, error) {
+	now := time.Now().UTC()
+	id, err := rq.eval(ctx, redisClaimScript, []string{rq.key("pending"), rq.key("leases")},
+		unixMilli(now), unixMilli(now.Add(lease)), worker, rq.key("job", ""))
+	if err != nil || id == nil {
+		return nil, err
+	}
+	return rq.get(ctx, id.(string))
+}
+
+// redisLeasedScript runs the update of ARGV[3:] on the job if worker
+// ARGV[2] holds its lease, ARGV[1] is the new lease expiry, or 0 to end it.
+const redisLeasedScript = `
+if redis.call('HGET', KEYS[1], 'state') ~= 'running' or redis.call('HGET', KEYS[1], 'worker') ~= ARGV[2] then
+	return 0
+end
+if ARGV[1] == '0' then
+	redis.call('ZREM', KEYS[2], ARGV[3])
+else
+	redis.call('ZADD', KEYS[2], ARGV[1], ARGV[3])
+end
+redis.call('HSET', KEYS[1], 'lease_until', ARGV[1], unpack(ARGV, 4))
+return 1`
+
+func (rq *redisJobQueue) updateLeased(ctx context.Context, id, worker, leaseUntil string, fields ...string) error {
+	args := append([]string{leaseUntil, worker, id, "updated", unixMilli(time.Now())}, fields...)
+	ok, err := rq.eval(ctx, redisLeasedScript, []string{rq.key("job", id), rq.key("leases")}, args...)
+	if err != nil {
+		return err
+	}
+	if ok != int64(1) {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+func (rq *redisJobQueue) Heartbeat(ctx context.Context, id, worker string, lease time.Duration, progress string) error {
+	var fields []string
+	if progress != "" {
+		fields = []string{"progress", progress}
+	}
+	return rq.updateLeased(ctx, id, worker, unixMilli(time.Now().Add(lease)), fields...)
+}
+
+func (rq *redisJobQueue) Finish(ctx context.Context, id, worker string, jobErr error) error {
+	fields := []string{"state", QueuedJobDone}
+	if jobErr != nil {
+		fields = []string{"state", QueuedJobFailed, "error", jobErr.Error()}
+	}
+	return rq.updateLeased(ctx, id, worker, "0", fields...)
+}
+
+const redisRequeueScript = `
+local state = redis.call('HGET', KEYS[1], 'state')
+if state ~= 'failed' and state ~= 'running' then
+	return 0
+end
+redis.call('ZREM', KEYS[2], ARGV[1])
+redis.call('HSET', KEYS[1], 'state', 'pending', 'worker', '', 'lease_until', 0, 'updated', ARGV[2])
+redis.call('RPUSH', KEYS[3], ARGV[1])
+return 1`
+
+func (rq *redisJobQueue) Requeue(ctx context.Context, id string) error {
+	ok, err := rq.eval(ctx, redisRequeueScript, []string{rq.key("job", id), rq.key("leases"), rq.key("pending")},
+		id, unixMilli(time.Now()))
+	if err != nil {
+		return err
+	}
+	if ok != int64(1) {
+		return fmt.Errorf("no failed or running job %s", id)
+	}
+	return nil
+}
+
+func (rq *redisJobQueue) List(ctx context.Context) ([]QueuedJob, error) {
+	ids, err := rq.rc.do(ctx, "ZRANGE", rq.key("jobs"), "0", "-1")
+	if err != nil {
+		return nil, err
+	}
+	var jobs []QueuedJob
+	for _, id := range ids.([]interface{}) {
+		job, err := rq.get(ctx, id.(string))
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+	return jobs, nil
+}
+
+// get reads the job id from its hash.
+func (rq *redisJobQueue) get(ctx context.Context, id string) (*QueuedJob, error) {
+	reply, err := rq.rc.do(ctx, "HGETALL", rq.key("job", id))
+	if err != nil {
+		return nil, err
+	}
+	items := reply.([]interface{})
+	fields := make(map[string]string, len(items)/2)
+	for i := 0; i+1 < len(items); i += 2 {
+		fields[items[i].(string)], _ = items[i+1].(string)
+	}
+	var job QueuedJob
+	if err := json.Unmarshal([]byte(fields["spec"]), &job); err != nil {
+		return nil, fmt.Errorf("invalid job %s: %v", id, err)
+	}
+	millis := func(name string) time.Time {
+		ms, _ := strconv.ParseInt(fields[name], 10, 64)
+		if ms == 0 {
+			return time.Time{}
+		}
+		return time.UnixMilli(ms).UTC()
+	}
+	job.State, job.Worker = fields["state"], fields["worker"]
+	job.Progress, job.Error = fields["progress"], fields["error"]
+	job.Attempts, _ = strconv.Atoi(fields["attempts"])
+	job.LeaseUntil, job.Created, job.Updated = millis("lease_until"), millis("created"), millis("updated")
+	return &job, nil
+}
+
+func (rq *redisJobQueue) Close() error {
+	rq.rc.mu.Lock()
+	defer rq.rc.mu.Unlock()
+	rq.rc.close()
+	return nil
+}
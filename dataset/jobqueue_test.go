@@ -0,0 +1,190 @@
+package dataset
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// testRedisQueue returns a queue on an in-memory Redis server, which runs
+// the Lua scripts of the queue.
+func testRedisQueue(t *testing.T) (JobQueue, *miniredis.Miniredis) {
+	t.Helper()
+	srv := miniredis.RunT(t)
+	q, err := OpenJobQueue(context.Background(), "redis://"+srv.Addr(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { q.Close() })
+	return q, srv
+}
+
+func TestOpenJobQueueUnsupported(t *testing.T) {
+	if _, err := OpenJobQueue(context.Background(), "postgres://localhost/graphsplit", "jobs"); err == nil {
+		t.Fatal("expected an error for a postgres uri")
+	}
+}
+
+func TestRedisJobQueueClaim(t *testing.T) {
+	ctx := context.Background()
+	q, _ := testRedisQueue(t)
+
+	for _, input := range []string{"/data/a", "/data/b"} {
+		job := &QueuedJob{Input: input, CarDir: "/cars/{id}", GraphName: "ds-{id}", SliceSize: 1 << 30}
+		if err := q.Enqueue(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+		if job.ID == "" || job.CarDir != "/cars/"+job.ID || job.GraphName != "ds-"+job.ID {
+			t.Fatalf("expected {id} replaced in %+v", job)
+		}
+	}
+
+	job, err := q.Claim(ctx, "w1", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job == nil || job.Input != "/data/a" || job.State != QueuedJobRunning || job.Worker != "w1" || job.Attempts != 1 {
+		t.Fatalf("expected the oldest job claimed by w1, got %+v", job)
+	}
+	if job.LeaseUntil.Before(time.Now()) || job.CarDir != "/cars/"+job.ID || job.SliceSize != 1<<30 {
+		t.Fatalf("unexpected claimed job %+v", job)
+	}
+
+	if err := q.Heartbeat(ctx, job.ID, "w2", time.Minute, ""); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("expected ErrLeaseLost for a heartbeat of another worker, got %v", err)
+	}
+	if err := q.Heartbeat(ctx, job.ID, "w1", time.Minute, `{"slices_done":1}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Finish(ctx, job.ID, "w1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Heartbeat(ctx, job.ID, "w1", time.Minute, ""); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("expected ErrLeaseLost after finish, got %v", err)
+	}
+
+	second, err := q.Claim(ctx, "w2", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second == nil || second.Input != "/data/b" {
+		t.Fatalf("expected the second job, got %+v", second)
+	}
+	if err := q.Finish(ctx, second.ID, "w2", errors.New("disk full")); err != nil {
+		t.Fatal(err)
+	}
+	if none, err := q.Claim(ctx, "w3", time.Minute); err != nil || none != nil {
+		t.Fatalf("expected no job to claim, got %+v %v", none, err)
+	}
+
+	jobs, err := q.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 || jobs[0].ID != job.ID || jobs[1].ID != second.ID {
+		t.Fatalf("expected the jobs in enqueue order, got %+v", jobs)
+	}
+	if jobs[0].State != QueuedJobDone || jobs[0].Progress != `{"slices_done":1}` || !jobs[0].LeaseUntil.IsZero() {
+		t.Fatalf("unexpected done job %+v", jobs[0])
+	}
+	if jobs[1].State != QueuedJobFailed || jobs[1].Error != "disk full" {
+		t.Fatalf("unexpected failed job %+v", jobs[1])
+	}
+
+	if err := q.Requeue(ctx, job.ID); err == nil {
+		t.Fatal("expected an error requeuing a done job")
+	}
+	if err := q.Requeue(ctx, second.ID); err != nil {
+		t.Fatal(err)
+	}
+	again, err := q.Claim(ctx, "w3", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == nil || again.ID != second.ID || again.Attempts != 2 || again.Error != "" {
+		t.Fatalf("expected the requeued job claimed again, got %+v", again)
+	}
+}
+
+func TestRedisJobQueueLeaseExpiry(t *testing.T) {
+	ctx := context.Background()
+	q, _ := testRedisQueue(t)
+
+	if err := q.Enqueue(ctx, &QueuedJob{Input: "/data"}); err != nil {
+		t.Fatal(err)
+	}
+	job, err := q.Claim(ctx, "w1", 50*time.Millisecond)
+	if err != nil || job == nil {
+		t.Fatalf("expected a job, got %+v %v", job, err)
+	}
+	if none, err := q.Claim(ctx, "w2", time.Minute); err != nil || none != nil {
+		t.Fatalf("expected no job while the lease holds, got %+v %v", none, err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	reclaimed, err := q.Claim(ctx, "w2", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reclaimed == nil || reclaimed.ID != job.ID || reclaimed.Worker != "w2" || reclaimed.Attempts != 2 {
+		t.Fatalf("expected the expired job claimed by w2, got %+v", reclaimed)
+	}
+	if err := q.Heartbeat(ctx, job.ID, "w1", time.Minute, ""); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("expected ErrLeaseLost for the first worker, got %v", err)
+	}
+	if err := q.Finish(ctx, job.ID, "w1", nil); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("expected ErrLeaseLost finishing with the first worker, got %v", err)
+	}
+	if err := q.Finish(ctx, job.ID, "w2", nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRedisJobQueueClaimRace(t *testing.T) {
+	ctx := context.Background()
+	_, srv := testRedisQueue(t)
+
+	// each worker has its own connection, as on separate machines
+	var queues []JobQueue
+	for i := 0; i < 2; i++ {
+		q, err := OpenJobQueue(ctx, "redis://"+srv.Addr(), "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer q.Close()
+		queues = append(queues, q)
+	}
+	if err := queues[0].Enqueue(ctx, &QueuedJob{Input: "/data"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		claimed []string
+	)
+	for i, q := range queues {
+		wg.Add(1)
+		go func(worker string, q JobQueue) {
+			defer wg.Done()
+			job, err := q.Claim(ctx, worker, time.Minute)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if job != nil {
+				mu.Lock()
+				claimed = append(claimed, job.Worker)
+				mu.Unlock()
+			}
+		}([]string{"w1", "w2"}[i], q)
+	}
+	wg.Wait()
+	if len(claimed) != 1 {
+		t.Fatalf("expected exactly one worker to claim the job, got %v", claimed)
+	}
+}
@@ -0,0 +1,230 @@
+package graphsplit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// The states of a ChainDeal.
+const (
+	DealPublished = "published"
+	DealActive    = "active"
+	DealSlashed   = "slashed"
+)
+
+// ChainDeal is a storage deal of a piece published on chain.
+type ChainDeal struct {
+	DealID     uint64 `json:"deal_id"`
+	PieceCid   string `json:"piece_cid"`
+	Provider   string `json:"provider"`
+	Client     string `json:"client"`
+	Verified   bool   `json:"verified"`
+	State      string `json:"state"`
+	StartEpoch int64  `json:"start_epoch"`
+	EndEpoch   int64  `json:"end_epoch"`
+	// ActivationEpoch is the epoch the sector of the deal was proven in,
+	// -1 until the deal is active
+	ActivationEpoch int64 `json:"activation_epoch"`
+}
+
+type marketDeal struct {
+	Proposal struct {
+		PieceCID struct {
+			Root string `json:"/"`
+		}
+		VerifiedDeal bool
+		Client       string
+		Provider     string
+		StartEpoch   int64
+		EndEpoch     int64
+	}
+	State struct {
+		SectorStartEpoch int64
+		SlashEpoch       int64
+	}
+}
+
+// MarketDeals returns the deals of the market actor of which the piece cid
+// is one of pieceCids, looked up with StateMarketDeals of the lotus or venus
+// JSON-RPC API at api, e.g. http://127.0.0.1:1234/rpc/v1. The market of
+// mainnet is gigabytes of JSON, it is decoded as it is read.
+func MarketDeals(ctx context.Context, api, token string, pieceCids map[string]bool) ([]ChainDeal, error) {
+	body, err := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "method": "Filecoin.StateMarketDeals", "params": []interface{}{nil}})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, api, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("StateMarketDeals: %s", resp.Status)
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, err
+	}
+	var deals []ChainDeal
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch tok {
+		case "result":
+			if deals, err = decodeMarketDeals(dec, pieceCids); err != nil {
+				return nil, err
+			}
+		case "error":
+			var rpcErr struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			}
+			if err := dec.Decode(&rpcErr); err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("StateMarketDeals: %s (%d)", rpcErr.Message, rpcErr.Code)
+		default:
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return nil, err
+			}
+		}
+	}
+	sort.Slice(deals, func(i, j int) bool { return deals[i].DealID < deals[j].DealID })
+	return deals, nil
+}
+
+func decodeMarketDeals(dec *json.Decoder, pieceCids map[string]bool) ([]ChainDeal, error) {
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, err
+	}
+	var deals []ChainDeal
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		var md marketDeal
+		if err := dec.Decode(&md); err != nil {
+			return nil, err
+		}
+		if !pieceCids[md.Proposal.PieceCID.Root] {
+			continue
+		}
+		id, err := strconv.ParseUint(fmt.Sprint(tok), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid deal id %v", tok)
+		}
+		deal := ChainDeal{
+			DealID:          id,
+			PieceCid:        md.Proposal.PieceCID.Root,
+			Provider:        md.Proposal.Provider,
+			Client:          md.Proposal.Client,
+			Verified:        md.Proposal.VerifiedDeal,
+			State:           DealPublished,
+			StartEpoch:      md.Proposal.StartEpoch,
+			EndEpoch:        md.Proposal.EndEpoch,
+			ActivationEpoch: -1,
+		}
+		switch {
+		case md.State.SlashEpoch > 0:
+			deal.State = DealSlashed
+		case md.State.SectorStartEpoch > 0:
+			deal.State, deal.ActivationEpoch = DealActive, md.State.SectorStartEpoch
+		}
+		deals = append(deals, deal)
+	}
+	_, err := dec.Token()
+	return deals, err
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != delim {
+		return fmt.Errorf("unexpected %v in StateMarketDeals response, expect %v", tok, delim)
+	}
+	return nil
+}
+
+// TrackDeals looks up the deals of the pieces of the manifest of carDir, or
+// of db if carDir is empty, and records them in the chain_deals column of
+// the manifest, a JSON list of ChainDeal, and in db if it is not nil.
+func TrackDeals(ctx context.Context, api, token, carDir string, db *ManifestDB) ([]ChainDeal, error) {
+	// piece cid to the payload cids of its manifest rows
+	pieces := make(map[string][]string)
+	if carDir != "" {
+		rows, err := ReadManifest(carDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			if row["piece_cid"] != "" {
+				pieces[row["piece_cid"]] = append(pieces[row["piece_cid"]], row["payload_cid"])
+			}
+		}
+	} else if db != nil {
+		dbPieces, err := db.Pieces(ctx, "")
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range dbPieces {
+			if p.PieceCid != "" {
+				pieces[p.PieceCid] = append(pieces[p.PieceCid], p.PayloadCid)
+			}
+		}
+	}
+	if len(pieces) == 0 {
+		return nil, fmt.Errorf("no pieces with a piece cid to track")
+	}
+	pieceCids := make(map[string]bool, len(pieces))
+	for pieceCid := range pieces {
+		pieceCids[pieceCid] = true
+	}
+	deals, err := MarketDeals(ctx, api, token, pieceCids)
+	if err != nil {
+		return nil, err
+	}
+
+	if db != nil {
+		if err := db.SetDeals(ctx, deals); err != nil {
+			return nil, err
+		}
+	}
+	if carDir != "" {
+		byPiece := make(map[string][]ChainDeal)
+		for _, d := range deals {
+			byPiece[d.PieceCid] = append(byPiece[d.PieceCid], d)
+		}
+		for pieceCid, ds := range byPiece {
+			data, err := json.Marshal(ds)
+			if err != nil {
+				return nil, err
+			}
+			for _, payloadCid := range pieces[pieceCid] {
+				if err := updateManifest(carDir, payloadCid, map[string]string{"chain_deals": string(data)}); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return deals, nil
+}
@@ -0,0 +1,57 @@
+package graphsplit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTrackDeals(t *testing.T) {
+	dir := t.TempDir()
+	manifest := "payload_cid,filename,piece_cid,payload_size,piece_size\nbafy1,a.car,baga1,100,256\nbafy2,b.car,baga2,100,256\n"
+	if err := os.WriteFile(filepath.Join(dir, ManifestFileName), []byte(manifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","result":{
+			"12":{"Proposal":{"PieceCID":{"/":"baga1"},"VerifiedDeal":true,"Client":"f1client","Provider":"f01000","StartEpoch":100,"EndEpoch":200},"State":{"SectorStartEpoch":90,"SlashEpoch":-1}},
+			"13":{"Proposal":{"PieceCID":{"/":"bagaother"},"Provider":"f01000"},"State":{"SectorStartEpoch":-1,"SlashEpoch":-1}},
+			"11":{"Proposal":{"PieceCID":{"/":"baga1"},"Provider":"f02000","StartEpoch":100,"EndEpoch":200},"State":{"SectorStartEpoch":-1,"SlashEpoch":-1}}
+		},"id":1}`))
+	}))
+	defer srv.Close()
+
+	db, err := OpenManifestDB(filepath.Join(dir, "manifest.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	deals, err := TrackDeals(context.Background(), srv.URL, "", dir, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deals) != 2 || deals[0].DealID != 11 || deals[0].State != DealPublished ||
+		deals[1].State != DealActive || deals[1].ActivationEpoch != 90 || deals[1].Provider != "f01000" {
+		t.Fatalf("unexpected deals %+v", deals)
+	}
+
+	rows, err := ReadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recorded []ChainDeal
+	if err := json.Unmarshal([]byte(rows[0]["chain_deals"]), &recorded); err != nil || len(recorded) != 2 {
+		t.Fatalf("unexpected chain_deals %q, %v", rows[0]["chain_deals"], err)
+	}
+	if rows[1]["chain_deals"] != "" {
+		t.Fatalf("expected no deals of baga2, got %q", rows[1]["chain_deals"])
+	}
+	stored, err := db.Deals(context.Background(), "baga1")
+	if err != nil || len(stored) != 2 || stored[1].ActivationEpoch != 90 || !stored[1].Verified {
+		t.Fatalf("unexpected stored deals %+v, %v", stored, err)
+	}
+}
@@ -0,0 +1,172 @@
+package graphsplit
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/filecoin-project/go-state-types/abi"
+)
+
+// The formats of WriteDeals.
+const (
+	// DealsBoost has the columns named after the flags of boost deal:
+	// commp, piece_size, car_size, payload_cid and http_url
+	DealsBoost = "boost"
+	// DealsDroplet has the columns of the manifest the venus droplet
+	// offline deal tools read: payload_cid, filename, piece_cid,
+	// payload_size, piece_size and url
+	DealsDroplet = "droplet"
+)
+
+// DealRecord is a piece of a car dir ready to be proposed in an offline deal.
+type DealRecord struct {
+	PayloadCid string
+	PieceCid   string
+	// PieceSize is the padded piece size
+	PieceSize uint64
+	// CarSize is the size of the CAR file the storage provider imports
+	CarSize int64
+	CarFile string
+	URL     string
+}
+
+// DealRecords returns the pieces of the manifest of carDir. Their URL is
+// urlTemplate with {name}, {payload_cid} and {piece_cid} replaced, or the
+// url column of the manifest without a template. Stale pieces of
+// incremental runs are left out unless includeStale is set.
+func DealRecords(carDir, urlTemplate string, includeStale bool) ([]DealRecord, error) {
+	rows, err := ReadManifest(carDir)
+	if err != nil {
+		return nil, err
+	}
+	state, err := OpenPackState(carDir, false)
+	if err != nil {
+		return nil, err
+	}
+	var records []DealRecord
+	seen := make(map[string]bool)
+	for _, row := range rows {
+		if row["piece_cid"] == "" {
+			return nil, fmt.Errorf("piece %s has no piece cid, chunk with --calc-commp or run commP first", row["payload_cid"])
+		}
+		if seen[row["piece_cid"]] {
+			continue
+		}
+		seen[row["piece_cid"]] = true
+		if p := state.Pieces[row["payload_cid"]]; p != nil && p.Stale && !includeStale {
+			continue
+		}
+		rec, err := dealRecord(carDir, row, urlTemplate)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// dealRecord returns the deal record of a manifest row with a piece cid.
+func dealRecord(carDir string, row ManifestRow, urlTemplate string) (DealRecord, error) {
+	pieceSize, err := paddedPieceSize(row)
+	if err != nil {
+		return DealRecord{}, fmt.Errorf("piece %s: %w", row["piece_cid"], err)
+	}
+	carFile := locateCar(carDir, row)
+	if carFile == "" {
+		return DealRecord{}, fmt.Errorf("piece %s: CAR file not found", row["piece_cid"])
+	}
+	fi, err := os.Stat(carFile)
+	if err != nil {
+		return DealRecord{}, err
+	}
+	rec := DealRecord{
+		PayloadCid: row["payload_cid"],
+		PieceCid:   row["piece_cid"],
+		PieceSize:  pieceSize,
+		CarSize:    fi.Size(),
+		CarFile:    carFile,
+		URL:        row["url"],
+	}
+	if urlTemplate != "" {
+		rec.URL = strings.NewReplacer(
+			"{name}", url.PathEscape(filepath.Base(carFile)),
+			"{payload_cid}", rec.PayloadCid,
+			"{piece_cid}", rec.PieceCid,
+		).Replace(urlTemplate)
+	}
+	return rec, nil
+}
+
+// paddedPieceSize returns the padded_piece_size of row, or pads the unpadded
+// piece_size of manifests written before schema 2.
+func paddedPieceSize(row ManifestRow) (uint64, error) {
+	if size := row["padded_piece_size"]; size != "" {
+		n, err := strconv.ParseUint(size, 10, 64)
+		if err != nil || abi.PaddedPieceSize(n).Validate() != nil {
+			return 0, fmt.Errorf("invalid padded piece size %q", size)
+		}
+		return n, nil
+	}
+	n, err := strconv.ParseUint(row["piece_size"], 10, 64)
+	if err != nil || abi.UnpaddedPieceSize(n).Validate() != nil {
+		return 0, fmt.Errorf("invalid piece size %q", row["piece_size"])
+	}
+	return uint64(abi.UnpaddedPieceSize(n).Padded()), nil
+}
+
+// WriteDeals writes records to w in format, DealsBoost or DealsDroplet, as
+// CSV or with asJSON as a JSON array of objects with the same keys.
+func WriteDeals(w io.Writer, format string, asJSON bool, records []DealRecord) error {
+	var header []string
+	var values func(rec DealRecord) []string
+	switch format {
+	case DealsBoost:
+		header = []string{"commp", "piece_size", "car_size", "payload_cid", "http_url"}
+		values = func(rec DealRecord) []string {
+			return []string{rec.PieceCid, strconv.FormatUint(rec.PieceSize, 10), strconv.FormatInt(rec.CarSize, 10), rec.PayloadCid, rec.URL}
+		}
+	case DealsDroplet:
+		header = []string{"payload_cid", "filename", "piece_cid", "payload_size", "piece_size", "url"}
+		values = func(rec DealRecord) []string {
+			return []string{rec.PayloadCid, rec.CarFile, rec.PieceCid, strconv.FormatInt(rec.CarSize, 10), strconv.FormatUint(rec.PieceSize, 10), rec.URL}
+		}
+	default:
+		return fmt.Errorf("unknown deal format %q, expect boost or droplet", format)
+	}
+	if asJSON {
+		out := make([]map[string]interface{}, 0, len(records))
+		for _, rec := range records {
+			obj := make(map[string]interface{}, len(header))
+			for i, v := range values(rec) {
+				// sizes are numbers in JSON
+				if n, err := strconv.ParseInt(v, 10, 64); err == nil && strings.HasSuffix(header[i], "_size") {
+					obj[header[i]] = n
+				} else {
+					obj[header[i]] = v
+				}
+			}
+			out = append(out, obj)
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, rec := range records {
+		if err := cw.Write(values(rec)); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
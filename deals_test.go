@@ -0,0 +1,87 @@
+package graphsplit
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExportDeals(t *testing.T) {
+	dir := t.TempDir()
+	// a manifest of schema 1 has the unpadded piece size only
+	manifest := "payload_cid,filename,piece_cid,payload_size,piece_size\n" +
+		"bafy1,a.car,baga1,100,254\n" +
+		"bafy2,b.car,baga2,100,254\n"
+	if err := os.WriteFile(filepath.Join(dir, ManifestFileName), []byte(manifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"baga1.car", "baga2.car"} {
+		if err := os.WriteFile(filepath.Join(dir, name), make([]byte, 100), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	state := `{"files":{},"pieces":{"bafy2":{"created_at":"2024-01-01T00:00:00Z","stale":true}}}`
+	if err := os.WriteFile(filepath.Join(dir, PackStateFileName), []byte(state), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := DealRecords(dir, "https://host/{name}", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].PieceCid != "baga1" || records[0].PieceSize != 256 || records[0].CarSize != 100 || records[0].URL != "https://host/baga1.car" {
+		t.Fatalf("unexpected records %+v", records)
+	}
+	var buf bytes.Buffer
+	if err := WriteDeals(&buf, DealsBoost, false, records); err != nil {
+		t.Fatal(err)
+	}
+	if want := "commp,piece_size,car_size,payload_cid,http_url\nbaga1,256,100,bafy1,https://host/baga1.car\n"; buf.String() != want {
+		t.Fatalf("unexpected boost csv %q", buf.String())
+	}
+	buf.Reset()
+	if err := WriteDeals(&buf, DealsDroplet, true, records); err != nil {
+		t.Fatal(err)
+	}
+	var out []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 1 || out[0]["piece_size"] != float64(256) || !strings.HasSuffix(out[0]["filename"].(string), "baga1.car") {
+		t.Fatalf("unexpected droplet json %s", buf.String())
+	}
+
+	if records, err = DealRecords(dir, "", true); err != nil || len(records) != 2 {
+		t.Fatalf("expected the stale piece too, got %+v, %v", records, err)
+	}
+}
+
+func TestDealRecordsPaddedSize(t *testing.T) {
+	dir := t.TempDir()
+	manifest := "payload_cid,filename,piece_cid,padded_piece_size,unpadded_piece_size,piece_size\n" +
+		"bafy1,a.car,baga1,2048,2032,2032\n"
+	if err := os.WriteFile(filepath.Join(dir, ManifestFileName), []byte(manifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "baga1.car"), make([]byte, 100), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	records, err := DealRecords(dir, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].PieceSize != 2048 {
+		t.Fatalf("expected the padded piece size 2048, got %+v", records)
+	}
+
+	manifest = "payload_cid,filename,piece_cid,piece_size\nbafy1,a.car,baga1,256\n"
+	if err := os.WriteFile(filepath.Join(dir, ManifestFileName), []byte(manifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DealRecords(dir, "", false); err == nil {
+		t.Fatal("expected an error for a piece size which is not an unpadded size")
+	}
+}
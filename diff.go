@@ -0,0 +1,215 @@
+package graphsplit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+)
+
+type DiffStatus string
+
+const (
+	DiffAdded   DiffStatus = "added"
+	DiffRemoved DiffStatus = "removed"
+	DiffChanged DiffStatus = "changed"
+)
+
+// DiffEntry is a file which differs between two graphs. The cids of a file
+// split across CAR files are the cids of its parts joined by commas.
+type DiffEntry struct {
+	Path    string     `json:"path"`
+	Status  DiffStatus `json:"status"`
+	OldCid  string     `json:"old_cid,omitempty"`
+	NewCid  string     `json:"new_cid,omitempty"`
+	OldSize uint64     `json:"old_size,omitempty"`
+	NewSize uint64     `json:"new_size,omitempty"`
+}
+
+// GraphDiff is the difference between two graphs, see DiffGraphs.
+type GraphDiff struct {
+	Files         []DiffEntry `json:"files"`
+	AddedBlocks   []string    `json:"added_blocks"`
+	RemovedBlocks []string    `json:"removed_blocks"`
+	SharedBlocks  int         `json:"shared_blocks"`
+	// AddedPayloads and RemovedPayloads are the payload cids of the rows of
+	// manifest.csv of two car dirs which are only in one of them
+	AddedPayloads   []string `json:"added_payloads,omitempty"`
+	RemovedPayloads []string `json:"removed_payloads,omitempty"`
+}
+
+// diffFile is a file of a graph with the parts of split files joined.
+type diffFile struct {
+	cids []string
+	size uint64
+}
+
+// DiffGraphs compares the files and blocks of the CAR files at oldPath and
+// newPath, each a CAR file or a car dir. Files are compared by path, the
+// parts of files split across CAR files are joined, and changed files are
+// the ones whose content cid differs. Car dirs with a manifest.csv are
+// compared by payload cid too.
+func DiffGraphs(ctx context.Context, oldPath, newPath string, opts ...RestoreOption) (*GraphDiff, error) {
+	oldFiles, err := graphFiles(ctx, oldPath, opts)
+	if err != nil {
+		return nil, err
+	}
+	newFiles, err := graphFiles(ctx, newPath, opts)
+	if err != nil {
+		return nil, err
+	}
+	diff := &GraphDiff{}
+	for p, of := range oldFiles {
+		nf, ok := newFiles[p]
+		switch {
+		case !ok:
+			diff.Files = append(diff.Files, DiffEntry{Path: p, Status: DiffRemoved, OldCid: strings.Join(of.cids, ","), OldSize: of.size})
+		case strings.Join(of.cids, ",") != strings.Join(nf.cids, ","):
+			diff.Files = append(diff.Files, DiffEntry{
+				Path:    p,
+				Status:  DiffChanged,
+				OldCid:  strings.Join(of.cids, ","),
+				NewCid:  strings.Join(nf.cids, ","),
+				OldSize: of.size,
+				NewSize: nf.size,
+			})
+		}
+	}
+	for p, nf := range newFiles {
+		if _, ok := oldFiles[p]; !ok {
+			diff.Files = append(diff.Files, DiffEntry{Path: p, Status: DiffAdded, NewCid: strings.Join(nf.cids, ","), NewSize: nf.size})
+		}
+	}
+	sort.Slice(diff.Files, func(i, j int) bool { return diff.Files[i].Path < diff.Files[j].Path })
+
+	oldBlocks, err := graphBlocks(oldPath)
+	if err != nil {
+		return nil, err
+	}
+	newBlocks, err := graphBlocks(newPath)
+	if err != nil {
+		return nil, err
+	}
+	diff.AddedBlocks, diff.RemovedBlocks, diff.SharedBlocks = diffSets(oldBlocks, newBlocks)
+
+	oldPayloads, err := manifestPayloads(oldPath)
+	if err != nil {
+		return nil, err
+	}
+	newPayloads, err := manifestPayloads(newPath)
+	if err != nil {
+		return nil, err
+	}
+	if oldPayloads != nil && newPayloads != nil {
+		diff.AddedPayloads, diff.RemovedPayloads, _ = diffSets(oldPayloads, newPayloads)
+	}
+	return diff, nil
+}
+
+// graphFiles returns the files of the CAR files at carPath by path.
+func graphFiles(ctx context.Context, carPath string, opts []RestoreOption) (map[string]diffFile, error) {
+	entries, err := ListRestore(ctx, carPath, opts...)
+	if err != nil {
+		return nil, err
+	}
+	type part struct {
+		index int
+		cid   string
+		size  uint64
+	}
+	parts := make(map[string][]part)
+	for _, e := range entries {
+		p, index := e.Path, -1
+		if splitPartName.MatchString(p) {
+			index, _ = strconv.Atoi(p[len(p)-8:])
+			p = p[:len(p)-9]
+		}
+		parts[p] = append(parts[p], part{index: index, cid: e.Cid, size: e.Size})
+	}
+	files := make(map[string]diffFile, len(parts))
+	for p, pts := range parts {
+		sort.Slice(pts, func(i, j int) bool { return pts[i].index < pts[j].index })
+		var f diffFile
+		for _, pt := range pts {
+			f.cids = append(f.cids, pt.cid)
+			f.size += pt.size
+		}
+		files[p] = f
+	}
+	return files, nil
+}
+
+// graphBlocks returns the cids of the blocks of the CAR files at carPath,
+// the parts of stitch manifests included.
+func graphBlocks(carPath string) (map[string]bool, error) {
+	cars, err := restorableCars(carPath)
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[string]bool)
+	add := func(c cid.Cid, _ []byte) { set[c.String()] = true }
+	for _, p := range cars {
+		files := []string{p}
+		if isStitchManifest(p) {
+			sm, err := ReadStitchManifest(p)
+			if err != nil {
+				return nil, err
+			}
+			files = files[:0]
+			for _, part := range sm.Parts {
+				files = append(files, filepath.Join(filepath.Dir(p), part.File))
+			}
+		}
+		for _, f := range files {
+			var size int64
+			if err := scanCarBlocks(f, nil, add, &size); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return set, nil
+}
+
+// manifestPayloads returns the payload cids of manifest.csv in carPath, nil
+// if carPath is a CAR file or has no manifest.
+func manifestPayloads(carPath string) (map[string]bool, error) {
+	if fi, err := os.Stat(carPath); err != nil || !fi.IsDir() {
+		return nil, err
+	}
+	rows, err := ReadManifest(carPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		set[row["payload_cid"]] = true
+	}
+	return set, nil
+}
+
+// diffSets returns the sorted keys only in b, the ones only in a and the
+// number of keys in both.
+func diffSets(a, b map[string]bool) (added, removed []string, shared int) {
+	for k := range b {
+		if a[k] {
+			shared++
+		} else {
+			added = append(added, k)
+		}
+	}
+	for k := range a {
+		if !b[k] {
+			removed = append(removed, k)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed, shared
+}
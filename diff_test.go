@@ -0,0 +1,53 @@
+package graphsplit
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	dag "github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+)
+
+func TestDiffGraphs(t *testing.T) {
+	dir := t.TempDir()
+	file := func(data string) *dag.ProtoNode {
+		return dag.NodeWithData(unixfs.FilePBData([]byte(data), uint64(len(data))))
+	}
+	same, old, changed, added := file("same"), file("old"), file("changed"), file("added")
+
+	oldRoot := unixfs.EmptyDirNode()
+	oldRoot.AddNodeLink("same.txt", same)
+	oldRoot.AddNodeLink("removed.txt", old)
+	oldRoot.AddNodeLink("changed.txt", old)
+	writeTestCar(t, filepath.Join(dir, "old.car"), []blocks.Block{oldRoot, same, old})
+
+	newRoot := unixfs.EmptyDirNode()
+	newRoot.AddNodeLink("same.txt", same)
+	newRoot.AddNodeLink("changed.txt", changed)
+	newRoot.AddNodeLink("added.txt", added)
+	writeTestCar(t, filepath.Join(dir, "new.car"), []blocks.Block{newRoot, same, changed, added})
+
+	diff, err := DiffGraphs(context.Background(), filepath.Join(dir, "old.car"), filepath.Join(dir, "new.car"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []DiffEntry{
+		{Path: "added.txt", Status: DiffAdded, NewCid: added.Cid().String(), NewSize: 5},
+		{Path: "changed.txt", Status: DiffChanged, OldCid: old.Cid().String(), NewCid: changed.Cid().String(), OldSize: 3, NewSize: 7},
+		{Path: "removed.txt", Status: DiffRemoved, OldCid: old.Cid().String(), OldSize: 3},
+	}
+	if len(diff.Files) != len(want) {
+		t.Fatalf("unexpected files %+v", diff.Files)
+	}
+	for i := range want {
+		if diff.Files[i] != want[i] {
+			t.Fatalf("expected %+v, got %+v", want[i], diff.Files[i])
+		}
+	}
+	// the roots differ, old.txt is gone and two new files are added
+	if len(diff.AddedBlocks) != 3 || len(diff.RemovedBlocks) != 2 || diff.SharedBlocks != 1 {
+		t.Fatalf("unexpected blocks %+v", diff)
+	}
+}
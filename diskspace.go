@@ -0,0 +1,59 @@
+package graphsplit
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/docker/go-units"
+	"github.com/filecoin-project/go-padreader"
+)
+
+// ErrInsufficientSpace is returned by Chunk when car-dir does not have room
+// for the next slice, before anything of the slice is written.
+var ErrInsufficientSpace = errors.New("insufficient free space")
+
+// statFreeSpace returns the free space of a directory, tests replace it.
+var statFreeSpace = freeSpace
+
+// spaceNeeded is the room a piece of size bytes takes while keeping minFree
+// free.
+func spaceNeeded(size, minFree int64) uint64 {
+	return uint64(padreader.PaddedSize(uint64(size))) + uint64(minFree)
+}
+
+// hasRoom reports whether dir has need bytes free, along with the free bytes.
+// Platforms without free space information always have room.
+func hasRoom(dir string, need uint64) (bool, uint64, error) {
+	free, ok, err := statFreeSpace(dir)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to get free space of %s: %w", dir, err)
+	}
+	if !ok {
+		return true, free, nil
+	}
+	return free >= need, free, nil
+}
+
+// checkFreeSpace makes sure one of the car dirs can hold a piece of sliceSize
+// while keeping MinFreeSpace free.
+func (params *ChunkParams) checkFreeSpace(sliceSize int64) error {
+	dirs := []string{params.CarDir}
+	if params.CarDirs != nil {
+		dirs = params.CarDirs.Dirs()
+	}
+	need := spaceNeeded(sliceSize, params.MinFreeSpace)
+	var free uint64
+	for _, dir := range dirs {
+		if dir == "" {
+			return nil
+		}
+		ok, dirFree, err := hasRoom(dir, need)
+		if err != nil || ok {
+			return err
+		}
+		free = dirFree
+	}
+	return fmt.Errorf("%w in %v: %s free, %s needed for the next slice with %s kept free",
+		ErrInsufficientSpace, dirs, units.BytesSize(float64(free)),
+		units.BytesSize(float64(need)), units.BytesSize(float64(params.MinFreeSpace)))
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package graphsplit
+
+func freeSpace(path string) (uint64, bool, error) {
+	return 0, false, nil
+}
@@ -0,0 +1,85 @@
+package graphsplit
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestChunkStopsOnInsufficientSpace(t *testing.T) {
+	dir := writeTestTree(t, 100<<10, 100<<10, 100<<10)
+	if err := chunkWithFreeSpace(t, dir, 1<<40, 1<<50); !errors.Is(err, ErrInsufficientSpace) {
+		t.Fatalf("expected insufficient space before the first slice, got %v", err)
+	}
+
+	// room for the check before the run and the first slice only
+	carDir := t.TempDir()
+	calls := 0
+	statFreeSpace = func(path string) (uint64, bool, error) {
+		calls++
+		if calls <= 2 {
+			return 1 << 40, true, nil
+		}
+		return 1 << 20, true, nil
+	}
+	defer func() { statFreeSpace = freeSpace }()
+	ef, err := NewExtraFile("", 0, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Chunk(context.Background(), &ChunkParams{
+		ExpectSliceSize: 128 << 10,
+		TargetPath:      dir,
+		CarDir:          carDir,
+		Cb:              CSVCallback(carDir),
+		Ef:              ef,
+		MinFreeSpace:    1 << 30,
+	})
+	if !errors.Is(err, ErrInsufficientSpace) {
+		t.Fatalf("expected insufficient space after the first slice, got %v", err)
+	}
+	rows, err := ReadManifest(carDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("expected only the first slice to be written, got %d", len(rows))
+	}
+	entries, err := os.ReadDir(carDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the CAR file, its metadata sidecar and manifest.csv
+	if len(entries) != 3 {
+		t.Fatalf("expected no partial output, got %v", entries)
+	}
+}
+
+// chunkWithFreeSpace chunks dir into a new car dir with free bytes free
+// and minFree to keep free.
+func chunkWithFreeSpace(t *testing.T, dir string, free uint64, minFree int64) error {
+	statFreeSpace = func(path string) (uint64, bool, error) {
+		return free, true, nil
+	}
+	defer func() { statFreeSpace = freeSpace }()
+	carDir := t.TempDir()
+	return Chunk(context.Background(), &ChunkParams{
+		ExpectSliceSize: 128 << 10,
+		TargetPath:      dir,
+		CarDir:          carDir,
+		Cb:              CSVCallback(carDir),
+		MinFreeSpace:    minFree,
+	})
+}
+
+func TestSpaceNeeded(t *testing.T) {
+	// a 100KiB CAR is padded to the 127KiB payload of a 128KiB piece
+	if n := spaceNeeded(100<<10, 1<<20); n != 127<<10+1<<20 {
+		t.Fatalf("expected the padded piece and the kept space, got %d", n)
+	}
+	ok, _, err := hasRoom(t.TempDir(), 1)
+	if err != nil || !ok {
+		t.Fatalf("expected room for a byte, got %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,147 @@
+package graphsplit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DropletConfig is the droplet (venus-market) instance the deals of finished
+// pieces are imported into.
+type DropletConfig struct {
+	// URL is the JSON-RPC endpoint of droplet, e.g. http://127.0.0.1:41235/rpc/v0
+	URL   string
+	Token string
+	// CarDir is the car dir as droplet sees it, e.g. on a shared mount, the
+	// local car dir if empty
+	CarDir string
+	// SkipCommP skips droplet computing the pieceCID of the CAR again
+	SkipCommP bool
+}
+
+// DropletImporter is a callback importing the CAR of every finished piece
+// into the deals proposed to a droplet instance, so droplet seals the pieces
+// without the storage provider importing them by hand. The deals are the
+// provider:uuid pairs of the deals column of the manifest, which
+// BoostDealCallback records, so it has to follow it.
+type DropletImporter struct {
+	cfg    DropletConfig
+	carDir string
+	client *http.Client
+}
+
+func DropletCallback(carDir string, cfg DropletConfig) (*DropletImporter, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("droplet url is required")
+	}
+	return &DropletImporter{cfg: cfg, carDir: carDir, client: &http.Client{Timeout: time.Minute}}, nil
+}
+
+type dropletImportRef struct {
+	// ProposalCID is null, the deals are found by UUID
+	ProposalCID interface{}
+	UUID        string
+	File        string
+}
+
+type dropletImportResult struct {
+	Target  string
+	Message string
+}
+
+func (di *DropletImporter) OnSuccess(buf *Buffer, slice *GraphSlice) {
+	row, err := findManifestRow(di.carDir, slice.PayloadCid)
+	if err != nil {
+		log.Fatalf("failed to read manifest: %s", err)
+	}
+	if row == nil || row["deals"] == "" {
+		log.Warnf("no deals of %s to import into droplet", slice.PayloadCid)
+		return
+	}
+	carFile := locateCar(di.carDir, row)
+	if carFile == "" {
+		log.Errorf("no CAR file of %s to import into droplet", slice.PayloadCid)
+		return
+	}
+	file, err := di.remotePath(carFile)
+	if err != nil {
+		log.Errorf("failed to import %s into droplet: %s", carFile, err)
+		return
+	}
+	var refs []dropletImportRef
+	for _, deal := range strings.Fields(row["deals"]) {
+		_, uuid, _ := strings.Cut(deal, ":")
+		refs = append(refs, dropletImportRef{UUID: uuid, File: file})
+	}
+	var results []dropletImportResult
+	params := map[string]interface{}{"Refs": refs, "SkipCommP": di.cfg.SkipCommP}
+	if err := di.call("VENUS_MARKET.DealsBatchImportData", []interface{}{params}, &results); err != nil {
+		log.Errorf("failed to import %s into droplet: %s", carFile, err)
+		return
+	}
+	for _, res := range results {
+		if res.Message != "" {
+			log.Errorf("droplet failed to import %s into deal %s: %s", carFile, res.Target, res.Message)
+			continue
+		}
+		log.Infow("imported into droplet", slice.logFields("deal_uuid", res.Target, "file", file)...)
+	}
+}
+
+func (di *DropletImporter) OnError(err error) {
+	log.Fatal(err)
+}
+
+func (di *DropletImporter) remotePath(carFile string) (string, error) {
+	abs, err := filepath.Abs(carFile)
+	if err != nil || di.cfg.CarDir == "" {
+		return abs, err
+	}
+	rel, err := filepath.Rel(di.carDir, carFile)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(di.cfg.CarDir, rel), nil
+}
+
+// call calls method of the droplet JSON-RPC API.
+func (di *DropletImporter) call(method string, params []interface{}, result interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "method": method, "params": params})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, di.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if di.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+di.cfg.Token)
+	}
+	resp, err := di.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", method, resp.Status)
+	}
+	var out struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return fmt.Errorf("%s: %v", method, err)
+	}
+	if out.Error != nil {
+		return fmt.Errorf("%s: %s (%d)", method, out.Error.Message, out.Error.Code)
+	}
+	return json.Unmarshal(out.Result, result)
+}
@@ -0,0 +1,51 @@
+package graphsplit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDropletImport(t *testing.T) {
+	dir := t.TempDir()
+	manifest := "payload_cid,filename,piece_cid,payload_size,piece_size,deals\nbafy1,a.car,baga1,100,256,f01000:uuid-1 f02000:uuid-2\n"
+	if err := os.WriteFile(filepath.Join(dir, ManifestFileName), []byte(manifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "baga1.car"), make([]byte, 100), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Method string
+		Params []struct {
+			Refs      []dropletImportRef
+			SkipCommP bool
+		}
+	}
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":[{"Target":"uuid-1"},{"Target":"uuid-2","Message":"unknown deal"}]}`))
+	}))
+	defer srv.Close()
+
+	cb, err := NewRegisteredCallback("droplet", dir, map[string]string{"url": srv.URL, "token": "secret", "car-dir": "/mnt/cars", "skip-commp": "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb.OnSuccess(nil, &GraphSlice{PayloadCid: "bafy1"})
+
+	if got.Method != "VENUS_MARKET.DealsBatchImportData" || auth != "Bearer secret" || len(got.Params) != 1 || !got.Params[0].SkipCommP {
+		t.Fatalf("unexpected request %+v, %s", got, auth)
+	}
+	refs := got.Params[0].Refs
+	if len(refs) != 2 || refs[0].UUID != "uuid-1" || refs[1].UUID != "uuid-2" || refs[0].File != "/mnt/cars/baga1.car" || refs[0].ProposalCID != nil {
+		t.Fatalf("unexpected import refs %+v", refs)
+	}
+}
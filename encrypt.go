@@ -0,0 +1,227 @@
+package graphsplit
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	encryptionMagic   = "GSE1"
+	encryptionSegment = 64 << 10
+	// nonce prefix, then a 4 byte segment counter and a last segment flag
+	encryptionPrefixLen = 7
+	encryptionHeaderLen = len(encryptionMagic) + encryptionPrefixLen
+)
+
+// ErrNotEncrypted is returned when decrypting data without the header of
+// encrypted file data.
+var ErrNotEncrypted = errors.New("data is not encrypted by graphsplit")
+
+// Encryptor encrypts file data with AES-256-GCM before it is built into the
+// DAG. Every file, or part of a file, is sealed as a stream of 64KiB segments
+// under a random nonce prefix, so truncated or reordered segments are
+// detected when decrypting.
+type Encryptor struct {
+	aead cipher.AEAD
+	id   string
+}
+
+// LoadKeyFile reads a 32 byte AES-256 key, raw or hex encoded.
+func LoadKeyFile(path string) (*Encryptor, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key := data
+	if trimmed := bytes.TrimSpace(data); len(trimmed) == 64 {
+		if key, err = hex.DecodeString(string(trimmed)); err != nil {
+			return nil, fmt.Errorf("invalid hex key in %s: %w", path, err)
+		}
+	}
+	return NewEncryptor(key)
+}
+
+func NewEncryptor(key []byte) (*Encryptor, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("AES-256 key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(key)
+	return &Encryptor{aead: aead, id: hex.EncodeToString(sum[:8])}, nil
+}
+
+// ID describes the cipher and identifies the key without revealing it, it is
+// recorded in the manifest of every encrypted piece.
+func (e *Encryptor) ID() string {
+	if e == nil {
+		return ""
+	}
+	return "aes-256-gcm:" + e.id
+}
+
+// EncryptReader returns the encrypted stream of r, nil e returns r.
+func (e *Encryptor) EncryptReader(r io.Reader) io.Reader {
+	if e == nil {
+		return r
+	}
+	return &sealReader{e: e, r: r}
+}
+
+// DecryptReader returns the plaintext of the encrypted stream r, nil e
+// returns r.
+func (e *Encryptor) DecryptReader(r io.Reader) io.Reader {
+	if e == nil {
+		return r
+	}
+	return &openReader{e: e, r: r}
+}
+
+func (e *Encryptor) nonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, e.aead.NonceSize())
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encryptionPrefixLen:], counter)
+	if last {
+		nonce[encryptionPrefixLen+4] = 1
+	}
+	return nonce
+}
+
+type sealReader struct {
+	e       *Encryptor
+	r       io.Reader
+	prefix  []byte
+	counter uint32
+	// next holds the plaintext segment read ahead to detect the last one
+	next []byte
+	out  []byte
+	done bool
+	err  error
+}
+
+func (s *sealReader) Read(p []byte) (int, error) {
+	for len(s.out) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		if s.done {
+			return 0, io.EOF
+		}
+		s.err = s.fill()
+	}
+	n := copy(p, s.out)
+	s.out = s.out[n:]
+	return n, nil
+}
+
+func (s *sealReader) fill() error {
+	if s.prefix == nil {
+		s.prefix = make([]byte, encryptionPrefixLen)
+		if _, err := rand.Read(s.prefix); err != nil {
+			return err
+		}
+		s.out = append([]byte(encryptionMagic), s.prefix...)
+		seg, err := readSegment(s.r, encryptionSegment)
+		if err != nil {
+			return err
+		}
+		s.next = seg
+		return nil
+	}
+	seg := s.next
+	following, err := readSegment(s.r, encryptionSegment)
+	if err != nil {
+		return err
+	}
+	last := len(following) == 0
+	s.out = s.e.aead.Seal(nil, s.e.nonce(s.prefix, s.counter, last), seg, nil)
+	s.counter++
+	s.next = following
+	s.done = last
+	return nil
+}
+
+// readSegment reads size bytes, fewer only at the end of r.
+func readSegment(r io.Reader, size int) ([]byte, error) {
+	seg := make([]byte, size)
+	n, err := io.ReadFull(r, seg)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return seg[:n], err
+}
+
+type openReader struct {
+	e       *Encryptor
+	r       io.Reader
+	prefix  []byte
+	counter uint32
+	next    []byte
+	out     []byte
+	done    bool
+	err     error
+}
+
+func (o *openReader) Read(p []byte) (int, error) {
+	for len(o.out) == 0 {
+		if o.err != nil {
+			return 0, o.err
+		}
+		if o.done {
+			return 0, io.EOF
+		}
+		o.err = o.fill()
+	}
+	n := copy(p, o.out)
+	o.out = o.out[n:]
+	return n, nil
+}
+
+func (o *openReader) fill() error {
+	sealedSize := encryptionSegment + o.e.aead.Overhead()
+	if o.prefix == nil {
+		header := make([]byte, encryptionHeaderLen)
+		if _, err := io.ReadFull(o.r, header); err != nil || string(header[:len(encryptionMagic)]) != encryptionMagic {
+			return ErrNotEncrypted
+		}
+		o.prefix = header[len(encryptionMagic):]
+		seg, err := readSegment(o.r, sealedSize)
+		if err != nil {
+			return err
+		}
+		o.next = seg
+	}
+	seg := o.next
+	if len(seg) == 0 {
+		return io.ErrUnexpectedEOF
+	}
+	following, err := readSegment(o.r, sealedSize)
+	if err != nil {
+		return err
+	}
+	last := len(following) == 0
+	out, err := o.e.aead.Open(nil, o.e.nonce(o.prefix, o.counter, last), seg, nil)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt segment %d: %w", o.counter, err)
+	}
+	o.out = out
+	o.counter++
+	o.next = following
+	o.done = last
+	return nil
+}
@@ -0,0 +1,43 @@
+package graphsplit
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestEncryptRoundTrip(t *testing.T) {
+	enc, err := NewEncryptor(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range []int{0, 1, encryptionSegment, 3*encryptionSegment + 5} {
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = byte(i)
+		}
+		sealed, err := io.ReadAll(enc.EncryptReader(bytes.NewReader(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain, err := io.ReadAll(enc.DecryptReader(bytes.NewReader(sealed)))
+		if err != nil {
+			t.Fatalf("size %d: %s", size, err)
+		}
+		if !bytes.Equal(plain, data) {
+			t.Fatalf("size %d: decrypted data differs", size)
+		}
+		if size > encryptionSegment {
+			// dropping the last segment must not go unnoticed
+			truncated := sealed[:encryptionHeaderLen+encryptionSegment+enc.aead.Overhead()]
+			if _, err := io.ReadAll(enc.DecryptReader(bytes.NewReader(truncated))); err == nil {
+				t.Fatalf("size %d: truncated data decrypted", size)
+			}
+		}
+	}
+
+	if _, err := io.ReadAll(enc.DecryptReader(bytes.NewReader([]byte("plain file")))); !errors.Is(err, ErrNotEncrypted) {
+		t.Fatalf("expected ErrNotEncrypted, got %v", err)
+	}
+}
@@ -0,0 +1,201 @@
+package graphsplit
+
+import "fmt"
+
+// gf256 arithmetic over the field of x^8 + x^4 + x^3 + x^2 + 1, the one used
+// by most Reed-Solomon implementations.
+var (
+	gfExp [510]byte
+	gfLog [256]byte
+	gfMul [256][256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfExp[i+255] = byte(x)
+		gfLog[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	for a := 1; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			gfMul[a][b] = gfExp[int(gfLog[a])+int(gfLog[b])]
+		}
+	}
+}
+
+func gfInv(a byte) byte {
+	return gfExp[255-int(gfLog[a])]
+}
+
+func gfPow(a byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+	if a == 0 {
+		return 0
+	}
+	return gfExp[(int(gfLog[a])*n)%255]
+}
+
+type gfMatrix [][]byte
+
+func newGFMatrix(rows, cols int) gfMatrix {
+	m := make(gfMatrix, rows)
+	for i := range m {
+		m[i] = make([]byte, cols)
+	}
+	return m
+}
+
+func (m gfMatrix) mul(o gfMatrix) gfMatrix {
+	res := newGFMatrix(len(m), len(o[0]))
+	for i := range m {
+		for j := range o[0] {
+			var v byte
+			for k := range o {
+				v ^= gfMul[m[i][k]][o[k][j]]
+			}
+			res[i][j] = v
+		}
+	}
+	return res
+}
+
+// invert returns the inverse of the square matrix m by Gauss-Jordan elimination.
+func (m gfMatrix) invert() (gfMatrix, error) {
+	n := len(m)
+	work := newGFMatrix(n, 2*n)
+	for i := range m {
+		copy(work[i], m[i])
+		work[i][n+i] = 1
+	}
+	for col := 0; col < n; col++ {
+		pivot := -1
+		for r := col; r < n; r++ {
+			if work[r][col] != 0 {
+				pivot = r
+				break
+			}
+		}
+		if pivot < 0 {
+			return nil, fmt.Errorf("matrix is singular")
+		}
+		work[col], work[pivot] = work[pivot], work[col]
+		if f := gfInv(work[col][col]); f != 1 {
+			for j := range work[col] {
+				work[col][j] = gfMul[f][work[col][j]]
+			}
+		}
+		for r := 0; r < n; r++ {
+			if r == col || work[r][col] == 0 {
+				continue
+			}
+			f := work[r][col]
+			for j := range work[r] {
+				work[r][j] ^= gfMul[f][work[col][j]]
+			}
+		}
+	}
+	inv := newGFMatrix(n, n)
+	for i := range inv {
+		copy(inv[i], work[i][n:])
+	}
+	return inv, nil
+}
+
+// rsCodec is a systematic Reed-Solomon erasure code with dataShards data and
+// parityShards parity shards, any dataShards of them recover the others.
+type rsCodec struct {
+	dataShards   int
+	parityShards int
+	// matrix has the identity on top of the parity rows
+	matrix gfMatrix
+}
+
+func newRSCodec(dataShards, parityShards int) (*rsCodec, error) {
+	if dataShards <= 0 || parityShards <= 0 {
+		return nil, fmt.Errorf("data and parity shards have to be positive")
+	}
+	if dataShards+parityShards > 256 {
+		return nil, fmt.Errorf("at most 256 shards are supported")
+	}
+	total := dataShards + parityShards
+	vm := newGFMatrix(total, dataShards)
+	for r := range vm {
+		for c := range vm[r] {
+			vm[r][c] = gfPow(byte(r), c)
+		}
+	}
+	top, err := vm[:dataShards].invert()
+	if err != nil {
+		return nil, err
+	}
+	return &rsCodec{dataShards: dataShards, parityShards: parityShards, matrix: vm.mul(top)}, nil
+}
+
+// encode computes the parity shards from the data shards, all of equal size.
+func (rs *rsCodec) encode(data, parity [][]byte) {
+	for i, p := range parity {
+		row := rs.matrix[rs.dataShards+i]
+		for j := range p {
+			p[j] = 0
+		}
+		for c, d := range data {
+			mulAdd(p, d, row[c])
+		}
+	}
+}
+
+// reconstruct fills the missing data shards from the present ones, shards
+// holds data shards followed by parity shards.
+func (rs *rsCodec) reconstruct(shards [][]byte, present []bool) error {
+	var rows []int
+	for i := range shards {
+		if present[i] {
+			rows = append(rows, i)
+		}
+		if len(rows) == rs.dataShards {
+			break
+		}
+	}
+	if len(rows) < rs.dataShards {
+		return fmt.Errorf("%d shards are present, at least %d are needed", len(rows), rs.dataShards)
+	}
+	sub := newGFMatrix(rs.dataShards, rs.dataShards)
+	for i, r := range rows {
+		copy(sub[i], rs.matrix[r])
+	}
+	dec, err := sub.invert()
+	if err != nil {
+		return err
+	}
+	for c := 0; c < rs.dataShards; c++ {
+		if present[c] {
+			continue
+		}
+		out := shards[c]
+		for j := range out {
+			out[j] = 0
+		}
+		for i, r := range rows {
+			mulAdd(out, shards[r], dec[c][i])
+		}
+	}
+	return nil
+}
+
+// mulAdd adds c times src to dst.
+func mulAdd(dst, src []byte, c byte) {
+	if c == 0 {
+		return
+	}
+	t := &gfMul[c]
+	for i, v := range src {
+		dst[i] ^= t[v]
+	}
+}
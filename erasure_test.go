@@ -0,0 +1,49 @@
+package graphsplit
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestRSCodecReconstruct(t *testing.T) {
+	rs, err := newRSCodec(4, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shards := make([][]byte, 7)
+	for i := range shards {
+		shards[i] = make([]byte, 1000)
+	}
+	for i := 0; i < 4; i++ {
+		rand.Read(shards[i])
+	}
+	rs.encode(shards[:4], shards[4:])
+
+	original := make([][]byte, 4)
+	for i := range original {
+		original[i] = append([]byte(nil), shards[i]...)
+	}
+	// lose three of the seven shards, two of them data
+	present := []bool{false, true, false, true, true, false, true}
+	for i, ok := range present {
+		if !ok {
+			for j := range shards[i] {
+				shards[i][j] = 0xff
+			}
+		}
+	}
+	if err := rs.reconstruct(shards, present); err != nil {
+		t.Fatal(err)
+	}
+	for i := range original {
+		if !bytes.Equal(shards[i], original[i]) {
+			t.Fatalf("data shard %d was not recovered", i)
+		}
+	}
+
+	present = []bool{false, true, false, true, false, false, true}
+	if err := rs.reconstruct(shards, present); err == nil {
+		t.Fatal("expected an error with too few shards")
+	}
+}
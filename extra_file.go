@@ -1,8 +1,11 @@
 package graphsplit
 
 import (
+	"encoding/csv"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
 )
 
 const Gib = 1024 * 1024 * 1024
@@ -65,3 +68,103 @@ func (rf *ExtraFile) getFiles() []Finfo {
 
 	return files
 }
+
+// ExtraOverlap is an extra file whose content is also part of the dataset.
+type ExtraOverlap struct {
+	ExtraPath   string
+	DatasetPath string
+	Sha256      string
+	Size        int64
+	// Dropped is set when the extra file is no longer used as filler
+	Dropped bool
+}
+
+// ExtraOverlapReportName is the report of the extra files duplicating
+// dataset content in the car dir, it is replaced by every run.
+const ExtraOverlapReportName = "extra-overlap.csv"
+
+// WriteExtraOverlapReport writes the overlaps found by Dedup to
+// ExtraOverlapReportName in carDir.
+func WriteExtraOverlapReport(carDir string, overlaps []ExtraOverlap) error {
+	f, err := createAtomic(filepath.Join(carDir, ExtraOverlapReportName))
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"extra_file", "dataset_file", "sha256", "size", "dropped"})
+	for _, o := range overlaps {
+		w.Write([]string{o.ExtraPath, o.DatasetPath, o.Sha256, strconv.FormatInt(o.Size, 10), strconv.FormatBool(o.Dropped)})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Abort()
+		return err
+	}
+	return f.Commit()
+}
+
+// Dedup finds extra files duplicating content of the dataset files, only
+// files of equal size get hashed. Duplicates are dropped from the pool as long
+// as the remaining extra files can still fill a slice.
+func (rf *ExtraFile) Dedup(dataset []Finfo) ([]ExtraOverlap, error) {
+	if len(rf.files) == 0 {
+		return nil, nil
+	}
+	bySize := make(map[int64][]Finfo)
+	for _, item := range rf.files {
+		bySize[item.Info.Size()] = append(bySize[item.Info.Size()], item)
+	}
+	datasetSums := make(map[string]string)
+	for _, item := range dataset {
+		if _, ok := bySize[item.Info.Size()]; !ok {
+			continue
+		}
+		sum, err := fileSha256(item.Path)
+		if err != nil {
+			return nil, err
+		}
+		datasetSums[sum] = item.Path
+	}
+
+	var overlaps []ExtraOverlap
+	var unique []Finfo
+	var uniqueSize int64
+	for _, item := range rf.files {
+		if len(datasetSums) > 0 {
+			sum, err := fileSha256(item.Path)
+			if err != nil {
+				return nil, err
+			}
+			if path, ok := datasetSums[sum]; ok {
+				overlaps = append(overlaps, ExtraOverlap{
+					ExtraPath:   item.Path,
+					DatasetPath: path,
+					Sha256:      sum,
+					Size:        item.Info.Size(),
+				})
+				continue
+			}
+		}
+		unique = append(unique, item)
+		uniqueSize += item.Info.Size()
+	}
+
+	for _, o := range overlaps {
+		log.Warnf("extra file %s duplicates dataset file %s (sha256 %s, %d bytes)", o.ExtraPath, o.DatasetPath, o.Sha256, o.Size)
+	}
+	if len(overlaps) == 0 {
+		return nil, nil
+	}
+	if uniqueSize < rf.sliceSize {
+		log.Warnf("%d extra files duplicate dataset content, keeping them since the other %d extra files hold %d bytes, less than the extra slice size %d",
+			len(overlaps), len(unique), uniqueSize, rf.sliceSize)
+		return overlaps, nil
+	}
+	log.Infof("%d extra files duplicate dataset content and are not used as filler", len(overlaps))
+	for i := range overlaps {
+		overlaps[i].Dropped = true
+	}
+	rf.files = unique
+	rf.idx = 0
+	return overlaps, nil
+}
@@ -0,0 +1,62 @@
+package graphsplit
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtraFileDedup(t *testing.T) {
+	dataset := writeTestTree(t, 1000, 2000)
+	extraDir := writeTestTree(t, 3000, 4000)
+	dup, err := os.ReadFile(filepath.Join(dataset, "sub1", "file1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(extraDir, "copy"), dup, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	carDir := t.TempDir()
+	ef, err := NewExtraFile(extraDir, 5000, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunkTestTree(t, dataset, &ChunkParams{ExpectSliceSize: 1 << 20, CarDir: carDir, Ef: ef, DedupExtra: true})
+	for _, item := range ef.files {
+		if filepath.Base(item.Path) == "copy" {
+			t.Fatal("expected the duplicate to be dropped from the extra files")
+		}
+	}
+	f, err := os.Open(filepath.Join(carDir, ExtraOverlapReportName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[1][0] != filepath.Join(extraDir, "copy") ||
+		records[1][1] != filepath.Join(dataset, "sub1", "file1") || records[1][3] != "2000" || records[1][4] != "true" {
+		t.Fatalf("unexpected report %v", records)
+	}
+
+	// the duplicate is kept when the other extra files can't fill a slice
+	ef, err = NewExtraFile(extraDir, 8000, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []Finfo
+	for item := range GetFileListAsync([]string{dataset}) {
+		files = append(files, item)
+	}
+	overlaps, err := ef.Dedup(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(overlaps) != 1 || overlaps[0].Dropped || len(ef.files) != 3 {
+		t.Fatalf("expected the duplicate to be reported and kept, got %+v and %d extra files", overlaps, len(ef.files))
+	}
+}
@@ -0,0 +1,137 @@
+package graphsplit
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// FileErrorPolicy is what chunking does with a source file it can't read.
+type FileErrorPolicy string
+
+const (
+	// FileErrorFail fails the slice, which stops the run, it is the default
+	FileErrorFail FileErrorPolicy = "fail"
+	// FileErrorSkip leaves the file out of its slice and reports it
+	FileErrorSkip FileErrorPolicy = "skip"
+	// FileErrorRetry reads the file again a few times before skipping it
+	FileErrorRetry FileErrorPolicy = "retry"
+)
+
+// ParseFileErrorPolicy parses fail, skip or retry, empty is fail.
+func ParseFileErrorPolicy(s string) (FileErrorPolicy, error) {
+	switch p := FileErrorPolicy(s); p {
+	case "":
+		return FileErrorFail, nil
+	case FileErrorFail, FileErrorSkip, FileErrorRetry:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown file error policy %q, expected fail, skip or retry", s)
+}
+
+// FileErrorReportName is the report of the skipped files in the car dir,
+// every run appends to it.
+const FileErrorReportName = "file-errors.csv"
+
+var fileErrorHeader = []string{"time", "file", "error", "slice"}
+
+const fileErrorRetries = 3
+
+// fileErrorRetryDelay is doubled after every retry.
+var fileErrorRetryDelay = time.Second
+
+// fileErrorReport applies the file error policy of a run and reports the
+// skipped files, a nil report fails on every error.
+type fileErrorReport struct {
+	policy  FileErrorPolicy
+	carDir  string
+	mu      sync.Mutex
+	skipped map[string]bool
+}
+
+func newFileErrorReport(policy FileErrorPolicy, carDir string) *fileErrorReport {
+	if policy == "" || policy == FileErrorFail {
+		return nil
+	}
+	return &fileErrorReport{policy: policy, carDir: carDir, skipped: make(map[string]bool)}
+}
+
+// build runs build for the file at path of slice. It returns a nil node
+// without error if the file is skipped.
+func (fr *fileErrorReport) build(ctx context.Context, path, slice string, build func() (ipld.Node, error)) (ipld.Node, error) {
+	node, err := build()
+	if err == nil || fr == nil || ctx.Err() != nil {
+		return node, err
+	}
+	if fr.policy == FileErrorRetry {
+		delay := fileErrorRetryDelay
+		for i := 0; i < fileErrorRetries && err != nil; i++ {
+			log.Warnf("failed to read %s, retry in %s: %s", path, delay, err)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			delay *= 2
+			if node, err = build(); err == nil {
+				return node, nil
+			}
+		}
+	}
+	log.Errorf("skipping %s: %s", path, err)
+	if err := fr.record(path, slice, err); err != nil {
+		return nil, fmt.Errorf("failed to report skipped %s: %w", path, err)
+	}
+	return nil, nil
+}
+
+// record appends the skipped file to the report.
+func (fr *fileErrorReport) record(path, slice string, fileErr error) error {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	fr.skipped[path] = true
+	metricErrors.add("file_skipped", 1)
+	reportPath := filepath.Join(fr.carDir, FileErrorReportName)
+	_, statErr := os.Stat(reportPath)
+	f, err := os.OpenFile(reportPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	if os.IsNotExist(statErr) {
+		w.Write(fileErrorHeader) //nolint:errcheck
+	}
+	w.Write([]string{time.Now().Format(time.RFC3339), path, fileErr.Error(), slice}) //nolint:errcheck
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// isSkipped reports whether the file at path was skipped.
+func (fr *fileErrorReport) isSkipped(path string) bool {
+	if fr == nil {
+		return false
+	}
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	return fr.skipped[path]
+}
+
+// count returns the number of skipped files.
+func (fr *fileErrorReport) count() int {
+	if fr == nil {
+		return 0
+	}
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	return len(fr.skipped)
+}
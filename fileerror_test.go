@@ -0,0 +1,62 @@
+package graphsplit
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+func TestFileErrorReport(t *testing.T) {
+	ctx := context.Background()
+	errRead := errors.New("permission denied")
+	failing := func() (ipld.Node, error) { return nil, errRead }
+
+	var fail *fileErrorReport
+	if _, err := fail.build(ctx, "a", "slice", failing); !errors.Is(err, errRead) {
+		t.Fatalf("expected the read error, got %v", err)
+	}
+
+	dir := t.TempDir()
+	skip := newFileErrorReport(FileErrorSkip, dir)
+	node, err := skip.build(ctx, "/data/a", "graph.car", failing)
+	if err != nil || node != nil {
+		t.Fatalf("expected a skipped file, got %v %v", node, err)
+	}
+	if !skip.isSkipped("/data/a") || skip.count() != 1 {
+		t.Fatal("skipped file not recorded")
+	}
+	f, err := os.Open(filepath.Join(dir, FileErrorReportName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[1][1] != "/data/a" || records[1][2] != errRead.Error() || records[1][3] != "graph.car" {
+		t.Fatalf("unexpected report %v", records)
+	}
+
+	fileErrorRetryDelay = time.Millisecond
+	defer func() { fileErrorRetryDelay = time.Second }()
+	retry := newFileErrorReport(FileErrorRetry, dir)
+	attempts := 0
+	node, err = retry.build(ctx, "/data/b", "graph.car", func() (ipld.Node, error) {
+		attempts++
+		if attempts < 3 {
+			return nil, errRead
+		}
+		return dag.NodeWithData(nil), nil
+	})
+	if err != nil || node == nil || attempts != 3 || retry.count() != 0 {
+		t.Fatalf("expected the third attempt to succeed, got %v %v after %d", node, err, attempts)
+	}
+}
@@ -0,0 +1,128 @@
+package graphsplit
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// ErrDraining is returned for work handed to a process which is draining.
+var ErrDraining = errors.New("draining, not taking new work")
+
+// Health serves the probes of a long running process the way Kubernetes
+// expects them, and drains it before a restart:
+//
+//	GET  /healthz  200 while the process is alive
+//	GET  /readyz   200 once it is ready until it drains, 503 otherwise
+//	POST /drain    stop taking work, finish the current slice and exit
+//
+// A nil Health is never ready nor draining.
+type Health struct {
+	mu       sync.Mutex
+	ready    bool
+	draining bool
+	drain    func()
+}
+
+// NewHealth returns a Health calling drain once it is drained, which stops
+// the process after its current slice.
+func NewHealth(drain func()) *Health {
+	return &Health{drain: drain}
+}
+
+func (h *Health) SetReady(ready bool) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ready = ready
+}
+
+// Ready reports whether the process is ready and not draining.
+func (h *Health) Ready() bool {
+	if h == nil {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.ready && !h.draining
+}
+
+func (h *Health) Drain() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	first := !h.draining
+	h.draining = true
+	h.mu.Unlock()
+	if first {
+		log.Info("draining, stopping after the current slice")
+		if h.drain != nil {
+			h.drain()
+		}
+	}
+}
+
+func (h *Health) Draining() bool {
+	if h == nil {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.draining
+}
+
+func (h *Health) status() string {
+	switch {
+	case h.Draining():
+		return "draining"
+	case h.Ready():
+		return "ready"
+	}
+	return "starting"
+}
+
+func (h *Health) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status := http.StatusOK
+	switch r.URL.Path {
+	case "/healthz":
+	case "/readyz":
+		if !h.Ready() {
+			status = http.StatusServiceUnavailable
+		}
+	case "/drain":
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h.Drain()
+		status = http.StatusAccepted
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, status, map[string]string{"status": h.status()})
+}
+
+// isHealthPath reports whether path is served by Health.
+func isHealthPath(path string) bool {
+	return path == "/healthz" || path == "/readyz" || path == "/drain"
+}
+
+// ListenHealth serves h on addr.
+func ListenHealth(addr string, h *Health) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{Handler: h}
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Errorf("health on %s: %s", addr, err)
+		}
+	}()
+	return srv, nil
+}
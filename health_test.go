@@ -0,0 +1,48 @@
+package graphsplit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	drained := 0
+	h := NewHealth(func() { drained++ })
+	probe := func(method, path string) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec.Code
+	}
+	if code := probe(http.MethodGet, "/healthz"); code != http.StatusOK {
+		t.Fatalf("expected healthz 200, got %d", code)
+	}
+	if code := probe(http.MethodGet, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected readyz 503 before ready, got %d", code)
+	}
+	h.SetReady(true)
+	if code := probe(http.MethodGet, "/readyz"); code != http.StatusOK {
+		t.Fatalf("expected readyz 200, got %d", code)
+	}
+	if code := probe(http.MethodGet, "/drain"); code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected GET drain 405, got %d", code)
+	}
+	for i := 0; i < 2; i++ {
+		if code := probe(http.MethodPost, "/drain"); code != http.StatusAccepted {
+			t.Fatalf("expected drain 202, got %d", code)
+		}
+	}
+	if drained != 1 || !h.Draining() {
+		t.Fatalf("expected to be drained once, got %d", drained)
+	}
+	if code := probe(http.MethodGet, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected readyz 503 while draining, got %d", code)
+	}
+	if code := probe(http.MethodGet, "/healthz"); code != http.StatusOK {
+		t.Fatalf("expected healthz 200 while draining, got %d", code)
+	}
+	var nilHealth *Health
+	if nilHealth.Ready() || nilHealth.Draining() {
+		t.Fatal("expected a nil Health to be neither ready nor draining")
+	}
+}
@@ -0,0 +1,41 @@
+package graphsplit
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// PieceHookInfo holds the values substituted into a post piece hook.
+type PieceHookInfo struct {
+	Car        string
+	PieceCid   string
+	PayloadCid string
+	PieceSize  string
+}
+
+// runPieceHook runs the hook command template through sh once a CAR file is
+// finalized. {car}, {piece_cid}, {payload_cid} and {piece_size} are replaced
+// by the shell quoted values of info.
+func runPieceHook(hook string, info PieceHookInfo) {
+	if hook == "" {
+		return
+	}
+	cmdline := strings.NewReplacer(
+		"{car}", shellQuote(info.Car),
+		"{piece_cid}", shellQuote(info.PieceCid),
+		"{payload_cid}", shellQuote(info.PayloadCid),
+		"{piece_size}", shellQuote(info.PieceSize),
+	).Replace(hook)
+	log.Infof("running post piece hook: %s", cmdline)
+	cmd := exec.Command("sh", "-c", cmdline)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		log.Errorf("post piece hook for %s failed: %s", info.Car, err)
+	}
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
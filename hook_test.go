@@ -0,0 +1,69 @@
+package graphsplit
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunPieceHook(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("the hook needs sh")
+	}
+	out := filepath.Join(t.TempDir(), "hook.out")
+	hook := "printf '%s\\n' {car} {piece_cid} {payload_cid} {piece_size} > " + shellQuote(out)
+	info := PieceHookInfo{
+		Car:        "/cars/it's $(touch pwned).car",
+		PieceCid:   "baga6ea4seaq",
+		PayloadCid: "bafybeig",
+		PieceSize:  "2048",
+	}
+	runPieceHook(hook, info)
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{info.Car, info.PieceCid, info.PayloadCid, info.PieceSize}, "\n") + "\n"
+	if string(data) != want {
+		t.Fatalf("expected the quoted values %q, got %q", want, data)
+	}
+
+	// a failing hook is only logged
+	runPieceHook("exit 1", info)
+	runPieceHook("", info)
+}
+
+func TestChunkWithPostPieceHook(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("the hook needs sh")
+	}
+	dir := writeTestTree(t, 40<<10, 50<<10, 60<<10)
+	carDir := t.TempDir()
+	out := filepath.Join(t.TempDir(), "hook.out")
+	hook := "echo {car} {piece_cid} {payload_cid} {piece_size} >> " + shellQuote(out)
+	rows := chunkTestTree(t, dir, &ChunkParams{
+		ExpectSliceSize: 64 << 10,
+		CarDir:          carDir,
+		Cb:              CommPCallback(carDir, false, false, WithPostPieceHook(hook)),
+	})
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != len(rows) {
+		t.Fatalf("expected the hook to run once per piece, got %d runs for %d pieces", len(lines), len(rows))
+	}
+	runs := make(map[string]bool)
+	for _, line := range lines {
+		runs[line] = true
+	}
+	for _, row := range rows {
+		line := strings.Join([]string{filepath.Join(carDir, row["piece_cid"]+".car"), row["piece_cid"], row["payload_cid"], row["piece_size"]}, " ")
+		if !runs[line] {
+			t.Fatalf("expected a hook run %q, got %v", line, lines)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package graphsplit
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// httpSourceRoot is the root of the paths of files listed by an
+// HTTPListSource, files are below root/host/path of their URL.
+const httpSourceRoot = "http"
+
+// HTTPListSource reads files from the URLs of a list, every line of which is
+// a URL optionally followed by its size, separated by a comma or spaces.
+// Sizes which are not listed are requested with HEAD.
+type HTTPListSource struct {
+	client *http.Client
+	urls   map[string]string
+	files  []Finfo
+	// root is set for the files of a directory URL
+	root string
+}
+
+func NewHTTPListSource(listPath string) (*HTTPListSource, error) {
+	f, err := os.Open(listPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hs := &HTTPListSource{client: http.DefaultClient, urls: make(map[string]string)}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+		if line == 1 && fields[0] == "url" {
+			continue
+		}
+		u, err := url.Parse(fields[0])
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("%s:%d: invalid url %q", listPath, line, fields[0])
+		}
+		size := int64(-1)
+		if len(fields) > 1 {
+			if size, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid size %q", listPath, line, fields[1])
+			}
+		}
+		p := path.Join(httpSourceRoot, u.Host, u.Path)
+		if _, ok := hs.urls[p]; ok {
+			return nil, fmt.Errorf("%s:%d: %s is listed twice", listPath, line, fields[0])
+		}
+		hs.urls[p] = u.String()
+		hs.files = append(hs.files, Finfo{
+			Path: p,
+			Name: path.Base(p),
+			Info: remoteFileInfo{name: path.Base(p), size: size},
+		})
+	}
+	return hs, scanner.Err()
+}
+
+func (hs *HTTPListSource) Root() string {
+	if hs.root != "" {
+		return hs.root
+	}
+	return httpSourceRoot
+}
+
+// List returns the listed files, missing sizes are requested with HEAD.
+func (hs *HTTPListSource) List(ctx context.Context) ([]Finfo, error) {
+	for i, item := range hs.files {
+		if item.Info.Size() >= 0 {
+			continue
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, hs.urls[item.Path], nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := hs.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || resp.ContentLength < 0 {
+			return nil, fmt.Errorf("failed to get the size of %s: %s", hs.urls[item.Path], resp.Status)
+		}
+		hs.files[i].Info = remoteFileInfo{name: item.Name, size: resp.ContentLength}
+	}
+	return hs.files, nil
+}
+
+func (hs *HTTPListSource) Open(item Finfo, start, end int64) (io.ReadCloser, error) {
+	u, ok := hs.urls[item.Path]
+	if !ok {
+		return nil, fmt.Errorf("%s is not listed", item.Path)
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	ranged := start > 0 || end < item.Info.Size()
+	if ranged {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end-1))
+	}
+	resp, err := hs.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+	case resp.StatusCode == http.StatusOK:
+		// the server ignored the range
+		if _, err := io.CopyN(io.Discard, resp.Body, start); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(resp.Body, end-start), resp.Body}, nil
+}
+
+// Locate returns the URL of a listed file.
+func (hs *HTTPListSource) Locate(item Finfo) string {
+	return hs.urls[item.Path]
+}
@@ -0,0 +1,59 @@
+package graphsplit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPListSource(t *testing.T) {
+	content := []byte("0123456789abcdefghij")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, r.URL.Path, time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	list := filepath.Join(t.TempDir(), "urls.csv")
+	lines := []string{"url,size", srv.URL + "/data/a.bin,20", "# comment", srv.URL + "/data/b.bin"}
+	if err := os.WriteFile(list, []byte(strings.Join(lines, "\n")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	hs, err := NewHTTPListSource(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := hs.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[1].Info.Size() != 20 || !strings.HasPrefix(files[0].Path, "http/127.0.0.1") {
+		t.Fatalf("unexpected files %+v", files)
+	}
+
+	item := files[1]
+	item.SeekStart, item.SeekEnd = 10, 19
+	r, err := openItem(item, hs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content[10:]) {
+		t.Fatalf("unexpected content %q", got)
+	}
+
+	locations := sourceLocations(hs, files)
+	if len(locations) != 2 || locations[1] != srv.URL+"/data/b.bin" {
+		t.Fatalf("unexpected locations %v", locations)
+	}
+}
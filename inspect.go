@@ -0,0 +1,207 @@
+package graphsplit
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+	"github.com/ipld/go-car"
+)
+
+// CarInspection describes a CAR file, see InspectCar.
+type CarInspection struct {
+	Path    string   `json:"path"`
+	Version uint64   `json:"version"`
+	Roots   []string `json:"roots"`
+	Blocks  int      `json:"blocks"`
+	// PayloadSize is the size of the block data, without cids and section
+	// lengths
+	PayloadSize int64 `json:"payload_size"`
+	// CarSize is the size of the CARv1 data, uncompressed and without zero
+	// padding
+	CarSize int64 `json:"car_size"`
+	// Entries are the top-level entries of the UnixFS DAG of the first root
+	Entries []InspectEntry `json:"entries,omitempty"`
+	// Manifest is the row of manifest.csv next to the CAR file with its
+	// payload cid
+	Manifest ManifestRow `json:"manifest,omitempty"`
+}
+
+type InspectEntry struct {
+	Name string `json:"name"`
+	Cid  string `json:"cid"`
+	// Type is file or directory, empty when the block is not in the CAR file
+	Type string `json:"type"`
+	Size uint64 `json:"size"`
+}
+
+// InspectCar reads the CAR file at path, CARv1 or CARv2, zstd compressed or
+// zero padded to a piece, and returns its roots, block count and sizes and
+// the top-level entries of its UnixFS DAG. Blocks are not checked against
+// their cid, the file is read a second time for the blocks of the entries.
+func InspectCar(path string) (*CarInspection, error) {
+	ci := &CarInspection{Path: path}
+	var root cid.Cid
+	var rootData []byte
+	err := scanCarBlocks(path, func(h *car.CarHeader, version uint64) {
+		ci.Version = version
+		for _, r := range h.Roots {
+			ci.Roots = append(ci.Roots, r.String())
+		}
+		if len(h.Roots) > 0 {
+			root = h.Roots[0]
+		}
+	}, func(c cid.Cid, data []byte) {
+		ci.Blocks++
+		ci.PayloadSize += int64(len(data))
+		if c.Equals(root) {
+			rootData = data
+		}
+	}, &ci.CarSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	if rootData != nil && root.Prefix().Codec == cid.DagProtobuf {
+		if ci.Entries, err = inspectEntries(path, rootData); err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := ReadManifest(filepath.Dir(path))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, row := range rows {
+		if root.Defined() && row["payload_cid"] == root.String() {
+			ci.Manifest = row
+			break
+		}
+	}
+	return ci, nil
+}
+
+// inspectEntries lists the links of the directory block rootData, the blocks
+// of the entries are read from the CAR file at path for their type and size.
+func inspectEntries(path string, rootData []byte) ([]InspectEntry, error) {
+	nd, err := merkledag.DecodeProtobuf(rootData)
+	if err != nil {
+		return nil, err
+	}
+	fsn, err := unixfs.FSNodeFromBytes(nd.Data())
+	if err != nil {
+		return nil, err
+	}
+	if !fsn.IsDir() {
+		return nil, nil
+	}
+	entries := make([]InspectEntry, len(nd.Links()))
+	byCid := make(map[cid.Cid][]int)
+	for i, ln := range nd.Links() {
+		entries[i] = InspectEntry{Name: ln.Name, Cid: ln.Cid.String(), Size: ln.Size}
+		byCid[ln.Cid] = append(byCid[ln.Cid], i)
+	}
+	var n int64
+	err = scanCarBlocks(path, nil, func(c cid.Cid, data []byte) {
+		idx, ok := byCid[c]
+		if !ok {
+			return
+		}
+		typ, size := "file", uint64(len(data))
+		if c.Prefix().Codec == cid.DagProtobuf {
+			child, err := merkledag.DecodeProtobuf(data)
+			if err != nil {
+				return
+			}
+			childFsn, err := unixfs.FSNodeFromBytes(child.Data())
+			if err != nil {
+				return
+			}
+			size = childFsn.FileSize()
+			if childFsn.IsDir() {
+				typ, size = "directory", 0
+			}
+		}
+		for _, i := range idx {
+			entries[i].Type, entries[i].Size = typ, size
+		}
+	}, &n)
+	return entries, err
+}
+
+// carV2PragmaSize is the size of the CARv2 pragma, a CARv1 header of
+// version 2, which the CARv2 header of 40 bytes follows.
+const carV2PragmaSize = 11
+
+// scanCarBlocks reads the CAR file at path and calls onHeader with the
+// header of its CARv1 data and the version of the file, then onBlock for
+// every block. size is set to the size of the CARv1 data up to its end or to
+// zero padding.
+func scanCarBlocks(path string, onHeader func(*car.CarHeader, uint64), onBlock func(cid.Cid, []byte), size *int64) error {
+	f, err := openCar(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	h, err := car.ReadHeader(br)
+	if err != nil {
+		return err
+	}
+	version := h.Version
+	switch version {
+	case 1:
+	case 2:
+		var v2 [40]byte
+		if _, err := io.ReadFull(br, v2[:]); err != nil {
+			return fmt.Errorf("invalid CARv2 header: %w", err)
+		}
+		dataOffset := binary.LittleEndian.Uint64(v2[16:24])
+		dataSize := binary.LittleEndian.Uint64(v2[24:32])
+		if dataOffset < carV2PragmaSize+40 {
+			return fmt.Errorf("invalid CARv2 data offset %d", dataOffset)
+		}
+		if _, err := br.Discard(int(dataOffset - carV2PragmaSize - 40)); err != nil {
+			return err
+		}
+		br = bufio.NewReader(io.LimitReader(br, int64(dataSize)))
+		if h, err = car.ReadHeader(br); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported car version %d", version)
+	}
+	if onHeader != nil {
+		onHeader(h, version)
+	}
+	hsize, err := car.HeaderSize(h)
+	if err != nil {
+		return err
+	}
+	*size = int64(hsize)
+	for {
+		l, err := binary.ReadUvarint(br)
+		if err == io.EOF || (err == nil && l == 0) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		data := make([]byte, l)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return fmt.Errorf("block at offset %d is truncated: %w", *size, err)
+		}
+		n, c, err := cid.CidFromBytes(data)
+		if err != nil {
+			return fmt.Errorf("invalid cid at offset %d: %w", *size, err)
+		}
+		onBlock(c, data[n:])
+		*size += int64(uvarintSize(l)) + int64(l)
+	}
+}
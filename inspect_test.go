@@ -0,0 +1,41 @@
+package graphsplit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	dag "github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+)
+
+func TestInspectCar(t *testing.T) {
+	dir := t.TempDir()
+	file := dag.NodeWithData(unixfs.FilePBData([]byte("hello"), 5))
+	sub := unixfs.EmptyDirNode()
+	root := unixfs.EmptyDirNode()
+	root.AddNodeLink("hello.txt", file)
+	root.AddNodeLink("sub", sub)
+	carPath := filepath.Join(dir, "test.car")
+	writeTestCar(t, carPath, []blocks.Block{root, file, sub})
+	manifest := "payload_cid,filename\r\n" + root.Cid().String() + ",test.car\r\n"
+	if err := os.WriteFile(filepath.Join(dir, ManifestFileName), []byte(manifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ci, err := InspectCar(carPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ci.Version != 1 || len(ci.Roots) != 1 || ci.Roots[0] != root.Cid().String() || ci.Blocks != 3 {
+		t.Fatalf("unexpected inspection %+v", ci)
+	}
+	if len(ci.Entries) != 2 || ci.Entries[0] != (InspectEntry{Name: "hello.txt", Cid: file.Cid().String(), Type: "file", Size: 5}) ||
+		ci.Entries[1].Type != "directory" {
+		t.Fatalf("unexpected entries %+v", ci.Entries)
+	}
+	if ci.Manifest["filename"] != "test.car" {
+		t.Fatalf("manifest row not found: %v", ci.Manifest)
+	}
+}
@@ -0,0 +1,584 @@
+package graphsplit
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	mh "github.com/multiformats/go-multihash"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The retrieval protocols of the IPNI metadata.
+const (
+	IPNIMetadataHTTP      = "http"
+	IPNIMetadataBitswap   = "bitswap"
+	IPNIMetadataGraphsync = "graphsync"
+)
+
+const (
+	ipniDefaultTopic = "/indexer/ingest/mainnet"
+	// ipniChunkSize is the number of multihashes of an entry chunk
+	ipniChunkSize = 16384
+
+	ipniSignatureDomain = "indexer"
+	ipniSignatureCodec  = "/indexer/ingest/adSignature"
+)
+
+// IPNIConfig describes the advertisements published for every finished piece.
+type IPNIConfig struct {
+	// Announce are the HTTP announce URLs of the indexers, e.g.
+	// https://cid.contact/announce
+	Announce []string
+	// Publisher is the multiaddr of graphsplit serve the indexers fetch the
+	// advertisements from, e.g. /dns4/box.example.com/tcp/8080/http
+	Publisher string
+	// Addrs are the multiaddrs the content is retrieved from, Publisher if
+	// empty
+	Addrs []string
+	// Metadata is the retrieval protocol of the content, http (an IPFS
+	// trustless gateway), bitswap or graphsync, http if empty
+	Metadata string
+	// VerifiedDeal and FastRetrieval go into the graphsync metadata
+	VerifiedDeal  bool
+	FastRetrieval bool
+	// Topic is the topic of the advertisement chain, /indexer/ingest/mainnet
+	// if empty
+	Topic string
+}
+
+// IPNIPublisher is a callback publishing an IPNI advertisement of the blocks
+// of every finished piece, with the piece cid as the context id, so the
+// content can be found at the indexers before the deals of the piece are
+// active. The advertisements are signed with the ed25519 key of
+// car-dir/ipni/key, created on first use, which is the provider identity,
+// chained and kept in car-dir/ipni, where graphsplit serve serves them to
+// the indexers. The advertisement cid is recorded in the ipni_ad column of
+// the manifest.
+type IPNIPublisher struct {
+	cfg    IPNIConfig
+	carDir string
+	dir    string
+	key    ed25519.PrivateKey
+	peerID mh.Multihash
+	// announceAddr is the publisher multiaddr with the peer id
+	announceAddr []byte
+	client       *http.Client
+
+	// mu serializes the updates of the head of the chain
+	mu sync.Mutex
+}
+
+// IPNICallback has to follow the callback writing the manifest with
+// pieceCID.
+func IPNICallback(carDir string, cfg IPNIConfig) (*IPNIPublisher, error) {
+	if len(cfg.Announce) == 0 {
+		return nil, fmt.Errorf("no indexer announce urls")
+	}
+	for _, u := range cfg.Announce {
+		if _, err := url.Parse(u); err != nil {
+			return nil, fmt.Errorf("invalid announce url %q: %v", u, err)
+		}
+	}
+	if cfg.Publisher == "" {
+		return nil, fmt.Errorf("the publisher multiaddr is required")
+	}
+	if len(cfg.Addrs) == 0 {
+		cfg.Addrs = []string{cfg.Publisher}
+	}
+	for _, addr := range cfg.Addrs {
+		if _, err := multiaddrBytes(addr); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Metadata == "" {
+		cfg.Metadata = IPNIMetadataHTTP
+	}
+	if cfg.Metadata != IPNIMetadataHTTP && cfg.Metadata != IPNIMetadataBitswap && cfg.Metadata != IPNIMetadataGraphsync {
+		return nil, fmt.Errorf("unsupported ipni metadata %q, expect http, bitswap or graphsync", cfg.Metadata)
+	}
+	if cfg.Topic == "" {
+		cfg.Topic = ipniDefaultTopic
+	}
+	ip := &IPNIPublisher{
+		cfg:    cfg,
+		carDir: carDir,
+		dir:    filepath.Join(carDir, "ipni"),
+		client: &http.Client{Timeout: time.Minute},
+	}
+	if err := os.MkdirAll(filepath.Join(ip.dir, "blocks"), 0o755); err != nil {
+		return nil, err
+	}
+	key, err := loadIPNIKey(filepath.Join(ip.dir, "key"))
+	if err != nil {
+		return nil, err
+	}
+	ip.key = key
+	if ip.peerID, err = mh.Sum(ed25519PublicKeyProto(key), mh.IDENTITY, -1); err != nil {
+		return nil, err
+	}
+	if ip.announceAddr, err = multiaddrBytes(cfg.Publisher + "/p2p/" + ip.PeerID()); err != nil {
+		return nil, err
+	}
+	return ip, nil
+}
+
+// PeerID is the provider identity of the advertisements.
+func (ip *IPNIPublisher) PeerID() string {
+	return ip.peerID.B58String()
+}
+
+func (ip *IPNIPublisher) OnSuccess(buf *Buffer, slice *GraphSlice) {
+	row, err := findManifestRow(ip.carDir, slice.PayloadCid)
+	if err != nil {
+		log.Fatalf("failed to read manifest: %s", err)
+	}
+	if row == nil || row["piece_cid"] == "" {
+		log.Errorf("no piece cid of %s in the manifest, no advertisement published", slice.PayloadCid)
+		return
+	}
+	carFile := locateCarExt(ip.carDir, row, compressionExt(row["compression"]))
+	if carFile == "" {
+		log.Errorf("no CAR file of %s to advertise", slice.PayloadCid)
+		return
+	}
+	start := time.Now()
+	ad, err := ip.Publish(carFile, row["piece_cid"])
+	if !ad.Defined() {
+		// the content is still retrievable, it is only not found by the indexers
+		log.Errorf("failed to publish the advertisement of %s: %s", row["piece_cid"], err)
+		return
+	}
+	if err != nil {
+		log.Warnf("failed to announce the advertisement of %s, the indexers get it with the next one: %s", row["piece_cid"], err)
+	}
+	log.Infow("advertisement published", slice.logFields("ipni_ad", ad, "duration", time.Since(start))...)
+	if err := updateManifest(ip.carDir, slice.PayloadCid, map[string]string{"ipni_ad": ad.String()}); err != nil {
+		log.Fatalf("failed to record the advertisement of %s: %s", row["piece_cid"], err)
+	}
+}
+
+func (ip *IPNIPublisher) OnError(err error) {
+	log.Fatal(err)
+}
+
+// Publish appends an advertisement of the blocks of carFile with the context
+// id pieceCid to the chain and announces it to the indexers. The advertisement
+// is returned with the error of the announcement too, it is in the chain.
+func (ip *IPNIPublisher) Publish(carFile, pieceCid string) (cid.Cid, error) {
+	piece, err := cid.Decode(pieceCid)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("invalid piece cid %s: %v", pieceCid, err)
+	}
+	mhs, err := carMultihashes(carFile)
+	if err != nil {
+		return cid.Undef, err
+	}
+	metadata, err := ip.metadataFor(piece)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	ip.mu.Lock()
+	defer ip.mu.Unlock()
+	entries, err := ip.storeEntries(mhs)
+	if err != nil {
+		return cid.Undef, err
+	}
+	prev, err := readIPNIHead(ip.dir)
+	if err != nil {
+		return cid.Undef, err
+	}
+	sig, err := ip.signAd(prev, entries, metadata)
+	if err != nil {
+		return cid.Undef, err
+	}
+	ad, err := ip.store(func(ma datamodel.MapAssembler) {
+		if prev.Defined() {
+			qp.MapEntry(ma, "PreviousID", qp.Link(cidlink.Link{Cid: prev}))
+		}
+		qp.MapEntry(ma, "Provider", qp.String(ip.PeerID()))
+		qp.MapEntry(ma, "Addresses", qp.List(int64(len(ip.cfg.Addrs)), func(la datamodel.ListAssembler) {
+			for _, addr := range ip.cfg.Addrs {
+				qp.ListEntry(la, qp.String(addr))
+			}
+		}))
+		qp.MapEntry(ma, "Signature", qp.Bytes(sig))
+		qp.MapEntry(ma, "Entries", qp.Link(cidlink.Link{Cid: entries}))
+		qp.MapEntry(ma, "ContextID", qp.Bytes(piece.Bytes()))
+		qp.MapEntry(ma, "Metadata", qp.Bytes(metadata))
+		qp.MapEntry(ma, "IsRm", qp.Bool(false))
+	})
+	if err != nil {
+		return cid.Undef, err
+	}
+	if err := ip.writeHead(ad); err != nil {
+		return cid.Undef, err
+	}
+	return ad, ip.announce(ad)
+}
+
+// carMultihashes returns the multihashes of the blocks of a CAR file.
+func carMultihashes(path string) ([]mh.Multihash, error) {
+	f, err := openCar(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() //nolint:errcheck
+	cr, err := car.NewCarReader(f)
+	if err != nil {
+		return nil, err
+	}
+	var mhs []mh.Multihash
+	for {
+		blk, err := cr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return mhs, nil
+			}
+			return nil, err
+		}
+		mhs = append(mhs, blk.Cid().Hash())
+	}
+}
+
+// storeEntries stores the multihashes as a chain of entry chunks and returns
+// the cid of the first one.
+func (ip *IPNIPublisher) storeEntries(mhs []mh.Multihash) (cid.Cid, error) {
+	next := cid.Undef
+	for end := len(mhs); end > 0; end -= ipniChunkSize {
+		chunk := mhs[max(0, end-ipniChunkSize):end]
+		c, err := ip.store(func(ma datamodel.MapAssembler) {
+			qp.MapEntry(ma, "Entries", qp.List(int64(len(chunk)), func(la datamodel.ListAssembler) {
+				for _, h := range chunk {
+					qp.ListEntry(la, qp.Bytes(h))
+				}
+			}))
+			if next.Defined() {
+				qp.MapEntry(ma, "Next", qp.Link(cidlink.Link{Cid: next}))
+			}
+		})
+		if err != nil {
+			return cid.Undef, err
+		}
+		next = c
+	}
+	if !next.Defined() {
+		return cid.Undef, fmt.Errorf("no blocks to advertise")
+	}
+	return next, nil
+}
+
+// store encodes a map node as dag-json into car-dir/ipni/blocks, the bytes
+// are served as they are, so they match the cid.
+func (ip *IPNIPublisher) store(fn func(ma datamodel.MapAssembler)) (cid.Cid, error) {
+	n, err := qp.BuildMap(basicnode.Prototype.Any, -1, fn)
+	if err != nil {
+		return cid.Undef, err
+	}
+	var buf bytes.Buffer
+	if err := dagjson.Encode(n, &buf); err != nil {
+		return cid.Undef, err
+	}
+	hash, err := mh.Sum(buf.Bytes(), mh.SHA2_256, -1)
+	if err != nil {
+		return cid.Undef, err
+	}
+	c := cid.NewCidV1(cid.DagJSON, hash)
+	return c, writeFileAtomic(filepath.Join(ip.dir, "blocks", c.String()), buf.Bytes())
+}
+
+func (ip *IPNIPublisher) metadataFor(piece cid.Cid) ([]byte, error) {
+	switch ip.cfg.Metadata {
+	case IPNIMetadataBitswap:
+		return binary.AppendUvarint(nil, 0x0900), nil
+	case IPNIMetadataGraphsync:
+		n, err := qp.BuildMap(basicnode.Prototype.Any, 3, func(ma datamodel.MapAssembler) {
+			qp.MapEntry(ma, "PieceCID", qp.Link(cidlink.Link{Cid: piece}))
+			qp.MapEntry(ma, "VerifiedDeal", qp.Bool(ip.cfg.VerifiedDeal))
+			qp.MapEntry(ma, "FastRetrieval", qp.Bool(ip.cfg.FastRetrieval))
+		})
+		if err != nil {
+			return nil, err
+		}
+		buf := bytes.NewBuffer(binary.AppendUvarint(nil, 0x0910))
+		if err := dagcbor.Encode(n, buf); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return binary.AppendUvarint(nil, 0x0920), nil
+	}
+}
+
+// signAd returns the signature envelope of an advertisement, which signs the
+// hash of the previous and the entries cid, the provider, the addresses, the
+// metadata and IsRm.
+func (ip *IPNIPublisher) signAd(prev, entries cid.Cid, metadata []byte) ([]byte, error) {
+	var data []byte
+	data = append(data, prev.Bytes()...)
+	data = append(data, entries.Bytes()...)
+	data = append(data, ip.PeerID()...)
+	for _, addr := range ip.cfg.Addrs {
+		data = append(data, addr...)
+	}
+	data = append(data, metadata...)
+	// IsRm
+	data = append(data, 0)
+	payload, err := mh.Sum(data, mh.SHA2_256, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	var unsigned []byte
+	for _, field := range [][]byte{[]byte(ipniSignatureDomain), []byte(ipniSignatureCodec), payload} {
+		unsigned = binary.AppendUvarint(unsigned, uint64(len(field)))
+		unsigned = append(unsigned, field...)
+	}
+	env := appendProtoBytes(nil, 1, ed25519PublicKeyProto(ip.key))
+	env = appendProtoBytes(env, 2, []byte(ipniSignatureCodec))
+	env = appendProtoBytes(env, 3, payload)
+	env = appendProtoBytes(env, 5, ed25519.Sign(ip.key, unsigned))
+	return env, nil
+}
+
+// writeHead writes the signed head of the chain, which graphsplit serve
+// serves as it is.
+func (ip *IPNIPublisher) writeHead(head cid.Cid) error {
+	pub := ed25519PublicKeyProto(ip.key)
+	sig := ed25519.Sign(ip.key, append(head.Bytes(), ip.cfg.Topic...))
+	n, err := qp.BuildMap(basicnode.Prototype.Any, 4, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "head", qp.Link(cidlink.Link{Cid: head}))
+		qp.MapEntry(ma, "topic", qp.String(ip.cfg.Topic))
+		qp.MapEntry(ma, "pubkey", qp.Bytes(pub))
+		qp.MapEntry(ma, "sig", qp.Bytes(sig))
+	})
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := dagjson.Encode(n, &buf); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(ip.dir, "head"), buf.Bytes())
+}
+
+// readIPNIHead returns the head of the chain of dir, cid.Undef if nothing is
+// published yet.
+func readIPNIHead(dir string) (cid.Cid, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "head"))
+	if os.IsNotExist(err) {
+		return cid.Undef, nil
+	}
+	if err != nil {
+		return cid.Undef, err
+	}
+	var head struct {
+		Head cid.Cid `json:"head"`
+	}
+	if err := json.Unmarshal(data, &head); err != nil {
+		return cid.Undef, fmt.Errorf("invalid ipni head: %v", err)
+	}
+	return head.Head, nil
+}
+
+// announce sends the head to the announce urls of the indexers, which then
+// fetch the new advertisements from the publisher.
+func (ip *IPNIPublisher) announce(head cid.Cid) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"Cid":       head,
+		"Addrs":     [][]byte{ip.announceAddr},
+		"ExtraData": nil,
+		"OrigPeer":  "",
+	})
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, u := range ip.cfg.Announce {
+		if err := ip.sendAnnounce(u, body); err != nil {
+			errs = append(errs, fmt.Errorf("announce to %s: %v", u, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (ip *IPNIPublisher) sendAnnounce(announceURL string, body []byte) error {
+	u, err := url.Parse(announceURL)
+	if err != nil {
+		return err
+	}
+	if u.Path == "" {
+		u.Path = "/announce"
+	}
+	req, err := http.NewRequest(http.MethodPut, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := ip.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// loadIPNIKey reads the ed25519 key of path, a libp2p protobuf private key,
+// and creates it if path does not exist.
+func loadIPNIKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		data := protowire.AppendVarint(protowire.AppendTag(nil, 1, protowire.VarintType), 1)
+		data = appendProtoBytes(data, 2, key)
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var keyType uint64
+	var key []byte
+	for b := data; len(b) > 0; {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, fmt.Errorf("invalid ipni key %s: %v", path, protowire.ParseError(n))
+		}
+		b = b[n:]
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			keyType, n = protowire.ConsumeVarint(b)
+		case num == 2 && typ == protowire.BytesType:
+			key, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return nil, fmt.Errorf("invalid ipni key %s: %v", path, protowire.ParseError(n))
+		}
+		b = b[n:]
+	}
+	if keyType != 1 || len(key) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("ipni key %s is not an ed25519 key", path)
+	}
+	return ed25519.PrivateKey(key), nil
+}
+
+// ed25519PublicKeyProto is the public key of key as a libp2p protobuf
+// public key.
+func ed25519PublicKeyProto(key ed25519.PrivateKey) []byte {
+	b := protowire.AppendVarint(protowire.AppendTag(nil, 1, protowire.VarintType), 1)
+	return appendProtoBytes(b, 2, key.Public().(ed25519.PublicKey))
+}
+
+// appendProtoBytes appends the length delimited protobuf field num to b.
+func appendProtoBytes(b []byte, num protowire.Number, v []byte) []byte {
+	return protowire.AppendBytes(protowire.AppendTag(b, num, protowire.BytesType), v)
+}
+
+// multiaddrProtocols are the multiaddr protocols multiaddrBytes supports,
+// size is the size of the value, -1 if it is length prefixed.
+var multiaddrProtocols = map[string]struct {
+	code uint64
+	size int
+}{
+	"ip4":     {4, 4},
+	"tcp":     {6, 2},
+	"udp":     {273, 2},
+	"ip6":     {41, 16},
+	"dns":     {53, -1},
+	"dns4":    {54, -1},
+	"dns6":    {55, -1},
+	"dnsaddr": {56, -1},
+	"p2p":     {421, -1},
+	"https":   {443, 0},
+	"tls":     {448, 0},
+	"quic-v1": {461, 0},
+	"ws":      {477, 0},
+	"wss":     {478, 0},
+	"http":    {480, 0},
+}
+
+// multiaddrBytes encodes a multiaddr string like /dns4/example.com/tcp/443/https
+// into its binary form.
+func multiaddrBytes(s string) ([]byte, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || parts[0] != "" {
+		return nil, fmt.Errorf("invalid multiaddr %q", s)
+	}
+	var b []byte
+	for i := 1; i < len(parts); i++ {
+		proto, ok := multiaddrProtocols[parts[i]]
+		if !ok {
+			return nil, fmt.Errorf("invalid multiaddr %q: unsupported protocol %q", s, parts[i])
+		}
+		b = binary.AppendUvarint(b, proto.code)
+		if proto.size == 0 {
+			continue
+		}
+		if i++; i == len(parts) || parts[i] == "" {
+			return nil, fmt.Errorf("invalid multiaddr %q: no value of %s", s, parts[i-1])
+		}
+		v := parts[i]
+		switch name := parts[i-1]; {
+		case name == "ip4" || name == "ip6":
+			ip := net.ParseIP(v)
+			if name == "ip4" {
+				ip = ip.To4()
+			}
+			if ip == nil || strings.Contains(v, ":") != (name == "ip6") {
+				return nil, fmt.Errorf("invalid multiaddr %q: invalid %s address %s", s, name, v)
+			}
+			b = append(b, ip...)
+		case proto.size == 2:
+			port, err := strconv.ParseUint(v, 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("invalid multiaddr %q: invalid port %s", s, v)
+			}
+			b = binary.BigEndian.AppendUint16(b, uint16(port))
+		case name == "p2p":
+			id, err := mh.FromB58String(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid multiaddr %q: invalid peer id %s", s, v)
+			}
+			b = binary.AppendUvarint(b, uint64(len(id)))
+			b = append(b, id...)
+		default:
+			b = binary.AppendUvarint(b, uint64(len(v)))
+			b = append(b, v...)
+		}
+	}
+	return b, nil
+}
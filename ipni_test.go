@@ -0,0 +1,163 @@
+package graphsplit
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	mh "github.com/multiformats/go-multihash"
+)
+
+func TestIPNIPublish(t *testing.T) {
+	dir := t.TempDir()
+	var blks []blocks.Block
+	for _, s := range []string{"a", "b", "c"} {
+		blks = append(blks, blocks.NewBlock([]byte(s)))
+	}
+	carFile := filepath.Join(dir, "a.car")
+	writeTestCar(t, carFile, blks)
+
+	var announced []cid.Cid
+	indexer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg struct {
+			Cid   cid.Cid
+			Addrs [][]byte
+		}
+		if r.Method != http.MethodPut || r.URL.Path != "/announce" || json.NewDecoder(r.Body).Decode(&msg) != nil || len(msg.Addrs) != 1 {
+			http.Error(w, "bad announce", http.StatusBadRequest)
+			return
+		}
+		announced = append(announced, msg.Cid)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer indexer.Close()
+	srv := httptest.NewServer(NewPieceServer(dir, WithServeToken("secret")))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+
+	ip, err := IPNICallback(dir, IPNIConfig{
+		Announce:  []string{indexer.URL},
+		Publisher: "/ip4/127.0.0.1/tcp/" + u.Port() + "/http",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	piece1 := cid.NewCidV1(cid.FilCommitmentUnsealed, mustSum(t, "piece1"))
+	piece2 := cid.NewCidV1(cid.FilCommitmentUnsealed, mustSum(t, "piece2"))
+	ad1, err := ip.Publish(carFile, piece1.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ad2, err := ip.Publish(carFile, piece2.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(announced) != 2 || announced[1] != ad2 {
+		t.Fatalf("expected announcements of %s, got %v", ad2, announced)
+	}
+
+	// fetch the chain the way an indexer does, without the token
+	fetch := func(path string) datamodel.Node {
+		resp, err := http.Get(srv.URL + "/ipni/v1/ad/" + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: %s", path, resp.Status)
+		}
+		if c, err := cid.Decode(path); err == nil && !bytes.Equal(mustSum(t, string(data)), c.Hash()) {
+			t.Fatalf("the bytes of %s do not match its hash", path)
+		}
+		nb := basicnode.Prototype.Any.NewBuilder()
+		if err := dagjson.Decode(nb, bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+		return nb.Build()
+	}
+	field := func(n datamodel.Node, key string) datamodel.Node {
+		v, err := n.LookupByString(key)
+		if err != nil {
+			t.Fatalf("no %s: %v", key, err)
+		}
+		return v
+	}
+	link := func(n datamodel.Node) cid.Cid {
+		l, err := n.AsLink()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return l.(cidlink.Link).Cid
+	}
+
+	head := fetch("head")
+	if link(field(head, "head")) != ad2 {
+		t.Fatalf("head is not %s", ad2)
+	}
+	pub, _ := field(head, "pubkey").AsBytes()
+	sig, _ := field(head, "sig").AsBytes()
+	if !ed25519.Verify(ed25519.PublicKey(pub[4:]), append(ad2.Bytes(), ipniDefaultTopic...), sig) {
+		t.Fatal("invalid head signature")
+	}
+	ad := fetch(ad2.String())
+	if link(field(ad, "PreviousID")) != ad1 {
+		t.Fatalf("previous advertisement is not %s", ad1)
+	}
+	if contextID, _ := field(ad, "ContextID").AsBytes(); !bytes.Equal(contextID, piece2.Bytes()) {
+		t.Fatalf("context id is not %s", piece2)
+	}
+	if provider, _ := field(ad, "Provider").AsString(); provider != ip.PeerID() {
+		t.Fatalf("provider is %s, expect %s", provider, ip.PeerID())
+	}
+	entries := field(fetch(link(field(ad, "Entries")).String()), "Entries")
+	if entries.Length() != int64(len(blks)) {
+		t.Fatalf("expected %d entries, got %d", len(blks), entries.Length())
+	}
+
+	// the key is reused
+	again, err := IPNICallback(dir, IPNIConfig{Announce: []string{indexer.URL}, Publisher: "/dns4/example.com/tcp/443/https"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.PeerID() != ip.PeerID() {
+		t.Fatal("expected the peer id of the existing key")
+	}
+}
+
+func TestMultiaddrBytes(t *testing.T) {
+	b, err := multiaddrBytes("/dns4/example.com/tcp/443/https")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append([]byte{54, 11}, "example.com"...)
+	want = append(want, 6, 1, 187, 187, 3)
+	if !bytes.Equal(b, want) {
+		t.Fatalf("expected %x, got %x", want, b)
+	}
+	for _, s := range []string{"dns4/example.com", "/ip4/::1", "/tcp/70000", "/udt/1", "/dns4"} {
+		if _, err := multiaddrBytes(s); err == nil {
+			t.Fatalf("expected an error for %s", s)
+		}
+	}
+}
+
+func mustSum(t *testing.T, s string) mh.Multihash {
+	h, err := mh.Sum([]byte(s), mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
@@ -0,0 +1,167 @@
+package graphsplit
+
+import (
+	"io"
+
+	"github.com/ipfs/go-cid"
+	chunker "github.com/ipfs/go-ipfs-chunker"
+	dag "github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+	ihelper "github.com/ipfs/go-unixfs/importer/helpers"
+
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+type hashedLeaf struct {
+	node ipld.Node
+	size uint64
+	err  error
+}
+
+// leafHasher reads the chunks of a file in order and hashes them on several
+// goroutines, the hashed leaves are handed out in file order.
+type leafHasher struct {
+	leaves chan chan hashedLeaf
+	stop   chan struct{}
+	next   *hashedLeaf
+}
+
+func newLeafHasher(spl chunker.Splitter, cidBuilder cid.Builder, workers int) *leafHasher {
+	lh := &leafHasher{
+		leaves: make(chan chan hashedLeaf, workers*2),
+		stop:   make(chan struct{}),
+	}
+	go func() {
+		defer close(lh.leaves)
+		throttle := make(chan struct{}, workers)
+		for {
+			data, err := spl.NextBytes()
+			if err == io.EOF {
+				return
+			}
+			res := make(chan hashedLeaf, 1)
+			select {
+			case lh.leaves <- res:
+			case <-lh.stop:
+				return
+			}
+			if err != nil {
+				res <- hashedLeaf{err: err}
+				return
+			}
+			throttle <- struct{}{}
+			go func(data []byte) {
+				defer func() { <-throttle }()
+				leaf, err := newFileLeaf(data, cidBuilder)
+				res <- hashedLeaf{node: leaf, size: uint64(len(data)), err: err}
+			}(data)
+		}
+	}()
+	return lh
+}
+
+// done reports whether all leaves have been handed out.
+func (lh *leafHasher) done() bool {
+	if lh.next == nil {
+		res, ok := <-lh.leaves
+		if !ok {
+			return true
+		}
+		leaf := <-res
+		lh.next = &leaf
+	}
+	return false
+}
+
+func (lh *leafHasher) leaf() (ipld.Node, uint64, error) {
+	if lh.done() {
+		return nil, 0, io.EOF
+	}
+	leaf := lh.next
+	lh.next = nil
+	return leaf.node, leaf.size, leaf.err
+}
+
+func (lh *leafHasher) close() {
+	close(lh.stop)
+}
+
+// newFileLeaf builds the same leaf as DagBuilderHelper.NewLeafNode without raw
+// leaves and computes its cid, which is cached by the node.
+func newFileLeaf(data []byte, cidBuilder cid.Builder) (ipld.Node, error) {
+	fsn := unixfs.NewFSNode(unixfs.TFile)
+	fsn.SetData(data)
+	fileData, err := fsn.GetBytes()
+	if err != nil {
+		return nil, err
+	}
+	nd := new(dag.ProtoNode)
+	if err := nd.SetCidBuilder(cidBuilder); err != nil {
+		return nil, err
+	}
+	nd.SetData(fileData)
+	nd.Cid()
+	return nd, nil
+}
+
+// parallelLayout builds the same balanced DAG as balanced.Layout, but the
+// leaves read from spl are hashed by workers goroutines. db is only used to
+// build the inner nodes and store the nodes, it must not read from spl.
+func parallelLayout(db *ihelper.DagBuilderHelper, spl chunker.Splitter, cidBuilder cid.Builder, workers int) (ipld.Node, error) {
+	lh := newLeafHasher(spl, cidBuilder, workers)
+	defer lh.close()
+
+	if lh.done() {
+		root, err := newFileLeaf(nil, cidBuilder)
+		if err != nil {
+			return nil, err
+		}
+		return root, db.Add(root)
+	}
+
+	root, fileSize, err := lh.leaf()
+	if err != nil {
+		return nil, err
+	}
+	for depth := 1; !lh.done(); depth++ {
+		newRoot := db.NewFSNodeOverDag(unixfs.TFile)
+		if err := newRoot.AddChild(root, fileSize, db); err != nil {
+			return nil, err
+		}
+		root, fileSize, err = fillNode(db, lh, newRoot, depth)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return root, db.Add(root)
+}
+
+func fillNode(db *ihelper.DagBuilderHelper, lh *leafHasher, node *ihelper.FSNodeOverDag, depth int) (ipld.Node, uint64, error) {
+	if node == nil {
+		node = db.NewFSNodeOverDag(unixfs.TFile)
+	}
+	for node.NumChildren() < db.Maxlinks() && !lh.done() {
+		var (
+			child     ipld.Node
+			childSize uint64
+			err       error
+		)
+		if depth == 1 {
+			child, childSize, err = lh.leaf()
+		} else {
+			child, childSize, err = fillNode(db, lh, nil, depth-1)
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if err := node.AddChild(child, childSize, db); err != nil {
+			return nil, 0, err
+		}
+	}
+	fileSize := node.FileSize()
+	nd, err := node.Commit()
+	if err != nil {
+		return nil, 0, err
+	}
+	return nd, fileSize, nil
+}
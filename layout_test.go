@@ -0,0 +1,61 @@
+package graphsplit
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	chunker "github.com/ipfs/go-ipfs-chunker"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	dag "github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs/importer/balanced"
+	ihelper "github.com/ipfs/go-unixfs/importer/helpers"
+)
+
+func TestParallelLayout(t *testing.T) {
+	cidBuilder, err := dag.PrefixForCidVersion(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newParams := func() ihelper.DagBuilderParams {
+		bs := bstore.NewBlockstore(dss.MutexWrap(datastore.NewMapDatastore()))
+		return ihelper.DagBuilderParams{
+			Maxlinks:   4,
+			CidBuilder: cidBuilder,
+			Dagserv:    dag.NewDAGService(blockservice.New(bs, offline.Exchange(bs))),
+		}
+	}
+
+	for _, size := range []int{0, 100, 256, 4 * 256, 4*256 + 1, 50000} {
+		data := make([]byte, size)
+		rand.New(rand.NewSource(int64(size))).Read(data)
+
+		params := newParams()
+		db, err := params.New(chunker.NewSizeSplitter(bytes.NewReader(data), 256))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := balanced.Layout(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		params = newParams()
+		spl := chunker.NewSizeSplitter(bytes.NewReader(data), 256)
+		db, err = params.New(spl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := parallelLayout(db, spl, cidBuilder, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Cid().Equals(want.Cid()) {
+			t.Fatalf("size %d: parallel layout %s differs from %s", size, got.Cid(), want.Cid())
+		}
+	}
+}
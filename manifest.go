@@ -0,0 +1,427 @@
+package graphsplit
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const ManifestFileName = "manifest.csv"
+
+// pieceFileExt is the extension of the padded pieces written next to the CAR
+// files, see WithPieceFiles.
+const pieceFileExt = ".piece"
+
+var (
+	commPManifestHeader = []string{
+		"payload_cid", "filename", "piece_cid", "payload_size", "piece_size", "detail", "slice_size", "batch_id",
+		"block_order", "car_dir", "sha256", "blake3", "compression", "car_size",
+		"encryption", "sources", "root_path", "piece_cid_v2", "car_file", "piece_file",
+		"unpadded_piece_size", "padded_piece_size",
+		"precompressed",
+	}
+	csvManifestHeader = []string{
+		"payload_cid", "filename", "detail", "slice_size", "batch_id",
+		"block_order", "car_dir", "sha256", "blake3", "compression", "car_size",
+		"encryption", "sources", "root_path", "car_file",
+		"precompressed",
+	}
+
+	// manifestV2Columns lead the header of version 2 manifests, followed by
+	// the remaining columns of version 1, so readers going by column name
+	// read both
+	manifestV2Columns = []string{
+		"payload_cid", "piece_cid", "piece_cid_v2", "padded_piece_size", "unpadded_piece_size", "payload_size",
+		"car_file", "car_file_size", "sha256", "url", "created_at", "files",
+	}
+)
+
+// manifestV2Header returns manifestV2Columns followed by the columns of the
+// version 1 header not among them. Piece columns are left out of manifests
+// written without commP.
+func manifestV2Header(v1 []string) []string {
+	var header []string
+	for _, col := range manifestV2Columns {
+		if strings.HasPrefix(col, "piece_") || strings.HasSuffix(col, "_piece_size") {
+			if !slices.Contains(v1, "piece_cid") {
+				continue
+			}
+		}
+		header = append(header, col)
+	}
+	for _, col := range v1 {
+		if !slices.Contains(header, col) {
+			header = append(header, col)
+		}
+	}
+	return header
+}
+
+// manifestHeader returns the header of a new manifest of the selected
+// version, v1 being the header of version 1.
+func (o *callbackOptions) manifestHeader(v1 []string) []string {
+	if o.manifestVer >= 2 {
+		return manifestV2Header(v1)
+	}
+	return v1
+}
+
+// addManifestV2Columns fills the columns version 2 adds to a manifest row of
+// the CAR file at carFilePath.
+func (o *callbackOptions) addManifestV2Columns(row map[string]string, carFilePath string, slice *GraphSlice) {
+	if o.manifestVer < 2 {
+		return
+	}
+	fi, err := os.Stat(carFilePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	files, err := json.Marshal(slice.Files)
+	if err != nil {
+		log.Fatal(err)
+	}
+	row["car_file"] = carFilePath
+	row["car_file_size"] = strconv.FormatInt(fi.Size(), 10)
+	row["created_at"] = time.Now().UTC().Format(time.RFC3339)
+	row["files"] = string(files)
+	if o.manifestURL != "" {
+		row["url"] = strings.NewReplacer(
+			"{name}", url.PathEscape(filepath.Base(carFilePath)),
+			"{payload_cid}", slice.PayloadCid,
+			"{piece_cid}", row["piece_cid"],
+		).Replace(o.manifestURL)
+	}
+}
+
+// CheckManifestVersion returns an error if manifest.csv in carDir exists
+// with a header of another version, rows are appended matching the header of
+// the existing file so the columns of the new version would be dropped.
+func CheckManifestVersion(carDir string, version int) error {
+	f, err := os.Open(filepath.Join(carDir, ManifestFileName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	header, err := csv.NewReader(f).Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if v2 := slices.Contains(header, "created_at"); v2 != (version >= 2) {
+		existing := 1
+		if v2 {
+			existing = 2
+		}
+		return fmt.Errorf("%s was written with manifest version %d, not %d", ManifestFileName, existing, version)
+	}
+	return nil
+}
+
+// manifestMu serializes manifest writes of callbacks finishing in background
+var manifestMu sync.Mutex
+
+// appendManifest appends a row to manifest.csv in carDir. A new manifest is
+// created with the given header, the row is matched to the header of an
+// existing one by column name. Columns of header missing from a manifest
+// written by an older version are added to the end of its header first, see
+// migrateManifest. A row cut off by a crash is dropped first, a row of the
+// same payload and piece cid is not appended twice and the write is synced,
+// so retried runs leave a consistent manifest.
+func appendManifest(carDir string, header []string, row map[string]string) error {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	manifestPath := filepath.Join(carDir, ManifestFileName)
+	f, err := os.OpenFile(manifestPath, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	data, err := readCompleteLines(f)
+	if err != nil {
+		return err
+	}
+	existing, records, err := parseManifestRecords(data)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		for _, record := range records {
+			if manifestKey(recordRow(existing, record)) == manifestKey(row) {
+				log.Infof("%s has a row of payload %s already, skip it", manifestPath, row["payload_cid"])
+				return nil
+			}
+		}
+		if missing := missingColumns(existing, header); len(missing) > 0 {
+			return migrateManifest(manifestPath, existing, missing, row)
+		}
+		header = existing
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+
+	csvWriter := csv.NewWriter(f)
+	csvWriter.UseCRLF = true
+	if existing == nil {
+		if err := csvWriter.Write(header); err != nil {
+			return err
+		}
+	}
+
+	record := make([]string, 0, len(header))
+	for _, col := range header {
+		record = append(record, row[col])
+	}
+	if err := csvWriter.Write(record); err != nil {
+		return err
+	}
+	csvWriter.Flush()
+	if err := csvWriter.Error(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// readCompleteLines reads the manifest f up to its last newline and drops a
+// line cut off by a crash from the file.
+func readCompleteLines(f *os.File) ([]byte, error) {
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	complete := completeLines(data)
+	if len(complete) == len(data) {
+		return data, nil
+	}
+	log.Warnf("drop the truncated last line of %s: %q", f.Name(), data[len(complete):])
+	if err := f.Truncate(int64(len(complete))); err != nil {
+		return nil, err
+	}
+	return complete, nil
+}
+
+// completeLines returns data up to its last newline.
+func completeLines(data []byte) []byte {
+	if len(data) == 0 || data[len(data)-1] == '\n' {
+		return data
+	}
+	return data[:bytes.LastIndexByte(data, '\n')+1]
+}
+
+// parseManifestRecords returns the header and the records of a manifest.csv,
+// a nil header if it is empty.
+func parseManifestRecords(data []byte) ([]string, [][]string, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil || len(records) == 0 {
+		return nil, nil, err
+	}
+	return records[0], records[1:], nil
+}
+
+func recordRow(header, record []string) ManifestRow {
+	row := make(ManifestRow, len(header))
+	for i, col := range header {
+		if i < len(record) {
+			row[col] = record[i]
+		}
+	}
+	return row
+}
+
+// manifestKey identifies the piece of a manifest row, rows with the same key
+// are duplicates.
+func manifestKey(row map[string]string) string {
+	return row["payload_cid"] + "/" + row["piece_cid"]
+}
+
+// missingColumns returns the columns of header which are not in existing.
+func missingColumns(existing, header []string) []string {
+	var missing []string
+	for _, col := range header {
+		if !slices.Contains(existing, col) {
+			missing = append(missing, col)
+		}
+	}
+	return missing
+}
+
+// migrateManifest rewrites the manifest at manifestPath with the missing
+// columns added to the end of its existing header and row appended. The
+// records are padded to the new header, the new file replaces the old one
+// once it is complete.
+func migrateManifest(manifestPath string, existing, missing []string, row map[string]string) error {
+	log.Infof("add columns %v to the header of %s", missing, manifestPath)
+	f, err := os.Open(manifestPath)
+	if err != nil {
+		return err
+	}
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	f.Close()
+	if err != nil {
+		return err
+	}
+	header := append(slices.Clip(existing), missing...)
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.UseCRLF = true
+	records[0] = header
+	for _, record := range records {
+		for len(record) < len(header) {
+			record = append(record, "")
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	record := make([]string, 0, len(header))
+	for _, col := range header {
+		record = append(record, row[col])
+	}
+	if err := w.Write(record); err != nil {
+		return err
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return writeFileAtomic(manifestPath, buf.Bytes())
+}
+
+// ManifestRow is a row of manifest.csv keyed by column name.
+type ManifestRow map[string]string
+
+// ReadManifest reads all rows of the manifest in carDir, manifest.csv or
+// else manifest.ndjson or manifest.json.
+func ReadManifest(carDir string) ([]ManifestRow, error) {
+	format, err := findManifest(carDir)
+	if err != nil {
+		return nil, err
+	}
+	if format != ManifestCSV {
+		_, rows, err := readJSONManifest(filepath.Join(carDir, format.FileName()))
+		return rows, err
+	}
+	f, err := os.Open(filepath.Join(carDir, ManifestFileName))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseManifest(f)
+}
+
+// parseManifest reads the rows of a manifest.csv read from r.
+func parseManifest(rd io.Reader) ([]ManifestRow, error) {
+	r := csv.NewReader(rd)
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var rows []ManifestRow
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		row := make(ManifestRow, len(header))
+		for i, col := range header {
+			if i < len(record) {
+				row[col] = record[i]
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// updateManifest sets the columns of the rows of payloadCid in the manifest
+// of carDir, missing columns are added to the header.
+func updateManifest(carDir, payloadCid string, columns map[string]string) error {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	format, err := findManifest(carDir)
+	if err != nil {
+		return err
+	}
+	if format != ManifestCSV {
+		return updateJSONManifest(carDir, format, payloadCid, columns)
+	}
+	manifestPath := filepath.Join(carDir, ManifestFileName)
+	f, err := os.Open(manifestPath)
+	if err != nil {
+		return err
+	}
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return fmt.Errorf("manifest %s is empty", manifestPath)
+	}
+
+	header := records[0]
+	index := make(map[string]int, len(header))
+	for i, col := range header {
+		index[col] = i
+	}
+	for col := range columns {
+		if _, ok := index[col]; !ok {
+			index[col] = len(header)
+			header = append(header, col)
+		}
+	}
+	records[0] = header
+	payloadCol := index["payload_cid"]
+	for i, record := range records[1:] {
+		for len(record) < len(header) {
+			record = append(record, "")
+		}
+		if record[payloadCol] == payloadCid {
+			for col, value := range columns {
+				record[index[col]] = value
+			}
+		}
+		records[i+1] = record
+	}
+
+	out, err := createAtomic(manifestPath)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(out)
+	w.UseCRLF = true
+	if err := w.WriteAll(records); err != nil {
+		out.Abort()
+		return err
+	}
+	return out.Commit()
+}
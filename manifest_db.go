@@ -0,0 +1,266 @@
+package graphsplit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// PieceStatusChunked is the status of a piece whose CAR file is written.
+const PieceStatusChunked = "chunked"
+
+const manifestDBSchema = `
+CREATE TABLE IF NOT EXISTS pieces (
+	payload_cid  TEXT PRIMARY KEY,
+	piece_cid    TEXT NOT NULL DEFAULT '',
+	piece_size   INTEGER NOT NULL DEFAULT 0,
+	payload_size INTEGER NOT NULL DEFAULT 0,
+	filename     TEXT NOT NULL DEFAULT '',
+	car_file     TEXT NOT NULL DEFAULT '',
+	status       TEXT NOT NULL,
+	error        TEXT NOT NULL DEFAULT '',
+	columns      TEXT NOT NULL DEFAULT '{}',
+	created_at   TEXT NOT NULL,
+	updated_at   TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS pieces_piece_cid ON pieces(piece_cid);
+CREATE TABLE IF NOT EXISTS piece_files (
+	payload_cid TEXT NOT NULL REFERENCES pieces(payload_cid) ON DELETE CASCADE,
+	path        TEXT NOT NULL,
+	file_offset INTEGER NOT NULL,
+	file_size   INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS piece_files_path ON piece_files(path);
+CREATE INDEX IF NOT EXISTS piece_files_payload_cid ON piece_files(payload_cid);
+CREATE TABLE IF NOT EXISTS deals (
+	deal_id          INTEGER PRIMARY KEY,
+	piece_cid        TEXT NOT NULL,
+	provider         TEXT NOT NULL,
+	client           TEXT NOT NULL DEFAULT '',
+	verified         INTEGER NOT NULL DEFAULT 0,
+	state            TEXT NOT NULL,
+	start_epoch      INTEGER NOT NULL DEFAULT 0,
+	end_epoch        INTEGER NOT NULL DEFAULT 0,
+	activation_epoch INTEGER NOT NULL DEFAULT -1,
+	updated_at       TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS deals_piece_cid ON deals(piece_cid);
+`
+
+// ManifestDB records the pieces of a car dir, the byte ranges of the files
+// they contain and their processing status in a SQLite database. Every
+// piece is written in one transaction, so a crash never leaves a partial
+// row like an interrupted CSV append can.
+type ManifestDB struct {
+	db *sql.DB
+}
+
+// OpenManifestDB opens or creates the database at path.
+func OpenManifestDB(path string) (*ManifestDB, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)")
+	if err != nil {
+		return nil, err
+	}
+	// sqlite allows a single writer, the callbacks of parallel slices queue
+	// on the connection instead of failing with SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(manifestDBSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create schema of %s: %w", path, err)
+	}
+	return &ManifestDB{db: db}, nil
+}
+
+func (mdb *ManifestDB) Close() error {
+	return mdb.db.Close()
+}
+
+// AddPiece records the piece of a manifest row and the files it contains,
+// replacing an earlier record of the same payload cid.
+func (mdb *ManifestDB) AddPiece(ctx context.Context, row map[string]string, files []SliceFile) error {
+	columns, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	pieceSize, _ := strconv.ParseUint(row["piece_size"], 10, 64)
+	payloadSize, _ := strconv.ParseInt(row["payload_size"], 10, 64)
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	tx, err := mdb.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+	_, err = tx.ExecContext(ctx, `INSERT INTO pieces
+		(payload_cid, piece_cid, piece_size, payload_size, filename, car_file, status, columns, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(payload_cid) DO UPDATE SET
+		piece_cid = excluded.piece_cid, piece_size = excluded.piece_size, payload_size = excluded.payload_size,
+		filename = excluded.filename, car_file = excluded.car_file, status = excluded.status, error = '',
+		columns = excluded.columns, updated_at = excluded.updated_at`,
+		row["payload_cid"], row["piece_cid"], pieceSize, payloadSize, row["filename"], row["car_file"],
+		PieceStatusChunked, string(columns), now, now)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM piece_files WHERE payload_cid = ?`, row["payload_cid"]); err != nil {
+		return err
+	}
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO piece_files (payload_cid, path, file_offset, file_size) VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, f := range files {
+		if _, err := stmt.ExecContext(ctx, row["payload_cid"], f.Path, f.Offset, f.Size); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SetStatus sets the processing status of the piece of payloadCid, e.g.
+// after it is uploaded or a deal is made, errMsg describes a failure.
+func (mdb *ManifestDB) SetStatus(ctx context.Context, payloadCid, status, errMsg string) error {
+	res, err := mdb.db.ExecContext(ctx, `UPDATE pieces SET status = ?, error = ?, updated_at = ? WHERE payload_cid = ?`,
+		status, errMsg, time.Now().UTC().Format(time.RFC3339), payloadCid)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("no piece with payload cid %s", payloadCid)
+	}
+	return nil
+}
+
+// SetDeals records the on chain deals of pieces, replacing earlier records
+// of the same deal ids.
+func (mdb *ManifestDB) SetDeals(ctx context.Context, deals []ChainDeal) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	tx, err := mdb.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO deals
+		(deal_id, piece_cid, provider, client, verified, state, start_epoch, end_epoch, activation_epoch, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(deal_id) DO UPDATE SET
+		state = excluded.state, activation_epoch = excluded.activation_epoch, updated_at = excluded.updated_at`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, d := range deals {
+		if _, err := stmt.ExecContext(ctx, d.DealID, d.PieceCid, d.Provider, d.Client, d.Verified, d.State,
+			d.StartEpoch, d.EndEpoch, d.ActivationEpoch, now); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Deals returns the recorded deals of pieceCid, all of them if pieceCid is
+// empty, ordered by deal id.
+func (mdb *ManifestDB) Deals(ctx context.Context, pieceCid string) ([]ChainDeal, error) {
+	rows, err := mdb.db.QueryContext(ctx, `SELECT deal_id, piece_cid, provider, client, verified, state, start_epoch, end_epoch, activation_epoch
+		FROM deals WHERE ? = '' OR piece_cid = ? ORDER BY deal_id`, pieceCid, pieceCid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var deals []ChainDeal
+	for rows.Next() {
+		var d ChainDeal
+		if err := rows.Scan(&d.DealID, &d.PieceCid, &d.Provider, &d.Client, &d.Verified, &d.State,
+			&d.StartEpoch, &d.EndEpoch, &d.ActivationEpoch); err != nil {
+			return nil, err
+		}
+		deals = append(deals, d)
+	}
+	return deals, rows.Err()
+}
+
+// DBPiece is a piece recorded in a ManifestDB.
+type DBPiece struct {
+	PayloadCid string      `json:"payload_cid"`
+	PieceCid   string      `json:"piece_cid"`
+	PieceSize  uint64      `json:"piece_size"`
+	CarFile    string      `json:"car_file"`
+	Status     string      `json:"status"`
+	Error      string      `json:"error,omitempty"`
+	UpdatedAt  string      `json:"updated_at"`
+	Files      []SliceFile `json:"files,omitempty"`
+}
+
+// Pieces returns the pieces with the given status, all of them if status is
+// empty, ordered by the time they were recorded.
+func (mdb *ManifestDB) Pieces(ctx context.Context, status string) ([]DBPiece, error) {
+	return mdb.queryPieces(ctx, `SELECT payload_cid, piece_cid, piece_size, car_file, status, error, updated_at
+		FROM pieces WHERE ? = '' OR status = ? ORDER BY created_at, payload_cid`, status, status)
+}
+
+// PiecesContaining returns the pieces holding a byte range of the file at
+// path, with the ranges of that file.
+func (mdb *ManifestDB) PiecesContaining(ctx context.Context, path string) ([]DBPiece, error) {
+	pieces, err := mdb.queryPieces(ctx, `SELECT DISTINCT p.payload_cid, p.piece_cid, p.piece_size, p.car_file, p.status, p.error, p.updated_at
+		FROM pieces p JOIN piece_files f ON f.payload_cid = p.payload_cid
+		WHERE f.path = ? ORDER BY p.created_at, p.payload_cid`, path)
+	if err != nil {
+		return nil, err
+	}
+	for i := range pieces {
+		rows, err := mdb.db.QueryContext(ctx, `SELECT path, file_offset, file_size FROM piece_files
+			WHERE payload_cid = ? AND path = ? ORDER BY file_offset`, pieces[i].PayloadCid, path)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var f SliceFile
+			if err := rows.Scan(&f.Path, &f.Offset, &f.Size); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			pieces[i].Files = append(pieces[i].Files, f)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return pieces, nil
+}
+
+func (mdb *ManifestDB) queryPieces(ctx context.Context, query string, args ...interface{}) ([]DBPiece, error) {
+	rows, err := mdb.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var pieces []DBPiece
+	for rows.Next() {
+		var p DBPiece
+		if err := rows.Scan(&p.PayloadCid, &p.PieceCid, &p.PieceSize, &p.CarFile, &p.Status, &p.Error, &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		pieces = append(pieces, p)
+	}
+	return pieces, rows.Err()
+}
+
+// addToManifestDB records the piece of a manifest row in the manifest
+// database, if there is one.
+func (o *callbackOptions) addToManifestDB(row map[string]string, carFilePath string, slice *GraphSlice) error {
+	if o.manifestDB == nil {
+		return nil
+	}
+	if row["car_file"] == "" {
+		row["car_file"] = carFilePath
+	}
+	return o.manifestDB.AddPiece(context.Background(), row, slice.Files)
+}
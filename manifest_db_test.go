@@ -0,0 +1,44 @@
+package graphsplit
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestManifestDB(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenManifestDB(filepath.Join(t.TempDir(), "manifest.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	row := map[string]string{"payload_cid": "bafy1", "piece_cid": "baga1", "piece_size": "127"}
+	files := []SliceFile{{Path: "data/a", Size: 10}, {Path: "data/b", Offset: 0, Size: 100}}
+	if err := db.AddPiece(ctx, row, files); err != nil {
+		t.Fatal(err)
+	}
+	row = map[string]string{"payload_cid": "bafy2", "piece_cid": "baga2", "piece_size": "127"}
+	if err := db.AddPiece(ctx, row, []SliceFile{{Path: "data/b", Offset: 100, Size: 50}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetStatus(ctx, "bafy2", "uploaded", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	pieces, err := db.PiecesContaining(ctx, "data/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pieces) != 2 || pieces[1].Status != "uploaded" || pieces[1].Files[0].Offset != 100 {
+		t.Fatalf("unexpected pieces %+v", pieces)
+	}
+	pieces, err = db.Pieces(ctx, PieceStatusChunked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pieces) != 1 || pieces[0].PieceCid != "baga1" {
+		t.Fatalf("unexpected pieces %+v", pieces)
+	}
+}
@@ -0,0 +1,259 @@
+package graphsplit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ManifestFormat is the format of the manifest of a car dir.
+type ManifestFormat string
+
+const (
+	ManifestCSV    ManifestFormat = "csv"
+	ManifestJSON   ManifestFormat = "json"
+	ManifestNDJSON ManifestFormat = "ndjson"
+)
+
+// ParseManifestFormat checks the format given on the command line, empty
+// means csv.
+func ParseManifestFormat(s string) (ManifestFormat, error) {
+	switch f := ManifestFormat(strings.ToLower(s)); f {
+	case "":
+		return ManifestCSV, nil
+	case ManifestCSV, ManifestJSON, ManifestNDJSON:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown manifest format %q, csv, json or ndjson", s)
+}
+
+// FileName returns the name of the manifest in the car dir, manifest.csv,
+// manifest.json holding an array of rows or manifest.ndjson holding a row
+// per line.
+func (f ManifestFormat) FileName() string {
+	switch f {
+	case ManifestJSON, ManifestNDJSON:
+		return "manifest." + string(f)
+	}
+	return ManifestFileName
+}
+
+// manifestFormats are the formats ReadManifest looks for, in order.
+var manifestFormats = []ManifestFormat{ManifestCSV, ManifestNDJSON, ManifestJSON}
+
+// findManifest returns the format of the manifest in carDir, a not exist
+// error if there is none.
+func findManifest(carDir string) (ManifestFormat, error) {
+	for _, f := range manifestFormats {
+		if _, err := os.Stat(filepath.Join(carDir, f.FileName())); err == nil {
+			return f, nil
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	return "", &os.PathError{Op: "open", Path: filepath.Join(carDir, ManifestFileName), Err: os.ErrNotExist}
+}
+
+// appendManifest appends a row to the manifest of carDir in the selected
+// format, a JSON row holds the columns of header in order.
+func (o *callbackOptions) appendManifest(carDir string, header []string, row map[string]string) error {
+	switch o.manifestFormat {
+	case ManifestNDJSON:
+		manifestMu.Lock()
+		defer manifestMu.Unlock()
+		f, err := os.OpenFile(filepath.Join(carDir, ManifestNDJSON.FileName()), os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		data, err := readCompleteLines(f)
+		if err != nil {
+			return err
+		}
+		_, rows, err := parseJSONManifest(data, false)
+		if err != nil {
+			return err
+		}
+		if hasManifestRow(rows, row) {
+			return nil
+		}
+		if _, err := f.Seek(0, io.SeekEnd); err != nil {
+			return err
+		}
+		if _, err := f.Write(append(marshalManifestRow(header, row), '\n')); err != nil {
+			return err
+		}
+		return f.Sync()
+	case ManifestJSON:
+		manifestMu.Lock()
+		defer manifestMu.Unlock()
+		headers, rows, err := readJSONManifest(filepath.Join(carDir, ManifestJSON.FileName()))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if hasManifestRow(rows, row) {
+			return nil
+		}
+		return writeJSONManifest(carDir, ManifestJSON, append(headers, header), append(rows, row))
+	}
+	return appendManifest(carDir, header, row)
+}
+
+// marshalManifestRow encodes the columns of header of row as a JSON object.
+func marshalManifestRow(header []string, row map[string]string) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, col := range header {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(col)
+		v, _ := json.Marshal(row[col])
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes()
+}
+
+// unmarshalManifestRow decodes a JSON object and returns its keys in order.
+func unmarshalManifestRow(data []byte) ([]string, ManifestRow, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	if t, err := d.Token(); err != nil || t != json.Delim('{') {
+		return nil, nil, fmt.Errorf("manifest row is not a JSON object")
+	}
+	var header []string
+	row := make(ManifestRow)
+	for d.More() {
+		t, err := d.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		var v string
+		if err := d.Decode(&v); err != nil {
+			return nil, nil, err
+		}
+		header = append(header, t.(string))
+		row[t.(string)] = v
+	}
+	return header, row, nil
+}
+
+// readJSONManifest reads the rows of manifest.json or manifest.ndjson at
+// path with the keys of every row in order. The last line of an interrupted
+// loop run may be cut off and is skipped.
+func readJSONManifest(path string) ([][]string, []ManifestRow, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	headers, rows, err := parseJSONManifest(data, strings.HasSuffix(path, "."+string(ManifestJSON)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	return headers, rows, nil
+}
+
+// parseJSONManifest parses a JSON array of rows, or a row per line.
+func parseJSONManifest(data []byte, array bool) ([][]string, []ManifestRow, error) {
+	var lines [][]byte
+	if array {
+		var raw []json.RawMessage
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, nil, err
+		}
+		for _, r := range raw {
+			lines = append(lines, r)
+		}
+	} else {
+		sc := bufio.NewScanner(bytes.NewReader(data))
+		sc.Buffer(make([]byte, 64<<10), 16<<20)
+		for sc.Scan() {
+			if line := bytes.TrimSpace(sc.Bytes()); len(line) > 0 {
+				lines = append(lines, append([]byte(nil), line...))
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return nil, nil, err
+		}
+	}
+	var (
+		headers [][]string
+		rows    []ManifestRow
+	)
+	for _, line := range lines {
+		header, row, err := unmarshalManifestRow(line)
+		if err != nil {
+			log.Warnf("skip invalid manifest row %q", line)
+			continue
+		}
+		headers = append(headers, header)
+		rows = append(rows, row)
+	}
+	return headers, rows, nil
+}
+
+// writeJSONManifest atomically replaces the JSON manifest of carDir.
+func writeJSONManifest(carDir string, format ManifestFormat, headers [][]string, rows []ManifestRow) error {
+	var buf bytes.Buffer
+	if format == ManifestJSON {
+		buf.WriteString("[\n")
+	}
+	for i, row := range rows {
+		buf.Write(marshalManifestRow(headers[i], row))
+		if format == ManifestJSON && i < len(rows)-1 {
+			buf.WriteByte(',')
+		}
+		buf.WriteByte('\n')
+	}
+	if format == ManifestJSON {
+		buf.WriteString("]\n")
+	}
+	out, err := createAtomic(filepath.Join(carDir, format.FileName()))
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(buf.Bytes()); err != nil {
+		out.Abort()
+		return err
+	}
+	return out.Commit()
+}
+
+// updateJSONManifest sets the columns of the rows of payloadCid in a JSON
+// manifest, missing columns are added to the end of the rows.
+func updateJSONManifest(carDir string, format ManifestFormat, payloadCid string, columns map[string]string) error {
+	headers, rows, err := readJSONManifest(filepath.Join(carDir, format.FileName()))
+	if err != nil {
+		return err
+	}
+	for i, row := range rows {
+		if row["payload_cid"] != payloadCid {
+			continue
+		}
+		for col, value := range columns {
+			if _, ok := row[col]; !ok {
+				headers[i] = append(headers[i], col)
+			}
+			row[col] = value
+		}
+	}
+	return writeJSONManifest(carDir, format, headers, rows)
+}
+
+// hasManifestRow reports whether rows hold a row of the piece of row.
+func hasManifestRow(rows []ManifestRow, row map[string]string) bool {
+	for _, r := range rows {
+		if manifestKey(r) == manifestKey(row) {
+			log.Infof("manifest has a row of payload %s already, skip it", row["payload_cid"])
+			return true
+		}
+	}
+	return false
+}
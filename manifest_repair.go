@@ -0,0 +1,168 @@
+package graphsplit
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ManifestRepair is what RepairManifest changed in a manifest.
+type ManifestRepair struct {
+	// Truncated is set if the last row was cut off by a crash
+	Truncated bool `json:"truncated"`
+	// Duplicates is the number of rows of a piece listed before
+	Duplicates int `json:"duplicates"`
+	// Removed are the payload cids of the rows without a CAR file
+	Removed []string `json:"removed,omitempty"`
+	// Added are the CAR files of the car dirs a row was added for
+	Added []string `json:"added,omitempty"`
+	// Errors are the CAR files without a row which could not be read
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Changed reports whether the manifest was, or with dryRun would be,
+// rewritten.
+func (r *ManifestRepair) Changed() bool {
+	return r.Truncated || r.Duplicates > 0 || len(r.Removed) > 0 || len(r.Added) > 0
+}
+
+// RepairManifest reconciles the manifest of carDir with the CAR files of its
+// car dirs: a truncated last row and duplicate rows are dropped, rows whose
+// CAR file is gone are removed and CAR files without a row are added with
+// their commP. The manifest is replaced atomically unless dryRun is set.
+func RepairManifest(ctx context.Context, carDir string, dryRun bool) (*ManifestRepair, error) {
+	format, err := findManifest(carDir)
+	if err != nil {
+		return nil, err
+	}
+	manifestPath := filepath.Join(carDir, format.FileName())
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	repair := &ManifestRepair{}
+	var (
+		headers [][]string
+		rows    []ManifestRow
+	)
+	if format == ManifestJSON {
+		headers, rows, err = parseJSONManifest(data, true)
+	} else {
+		complete := completeLines(data)
+		repair.Truncated = len(complete) < len(data)
+		if format == ManifestNDJSON {
+			headers, rows, err = parseJSONManifest(complete, false)
+		} else {
+			var header []string
+			var records [][]string
+			header, records, err = parseManifestRecords(complete)
+			for _, record := range records {
+				headers = append(headers, header)
+				rows = append(rows, recordRow(header, record))
+			}
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", manifestPath, err)
+	}
+
+	var (
+		keptHeaders [][]string
+		kept        []ManifestRow
+	)
+	seen := make(map[string]bool)
+	dirs := map[string]bool{carDir: true}
+	known := make(map[string]bool)
+	for i, row := range rows {
+		if seen[manifestKey(row)] {
+			repair.Duplicates++
+			continue
+		}
+		seen[manifestKey(row)] = true
+		if row["car_dir"] != "" {
+			dirs[row["car_dir"]] = true
+		}
+		path := locateCarExt(carDir, row, compressionExt(row["compression"]))
+		if path == "" {
+			repair.Removed = append(repair.Removed, row["payload_cid"])
+			continue
+		}
+		known[path] = true
+		keptHeaders = append(keptHeaders, headers[i])
+		kept = append(kept, row)
+	}
+
+	extra, err := unlistedCars(dirs, known)
+	if err != nil {
+		return nil, err
+	}
+	var header []string
+	if len(keptHeaders) > 0 {
+		header = keptHeaders[0]
+	} else {
+		header = commPManifestHeader
+	}
+	for _, p := range extra {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		ret, err := CalcCommP(ctx, p, false, false)
+		if err != nil {
+			log.Errorf("failed to add %s to the manifest: %s", p, err)
+			repair.Errors = append(repair.Errors, p)
+			continue
+		}
+		row := ManifestRow{
+			"filename":     strings.TrimSuffix(filepath.Base(p), compressionExt(CompressZstd)),
+			"piece_cid":    ret.Root.String(),
+			"payload_size": strconv.FormatInt(ret.PayloadSize, 10),
+			"piece_size":   strconv.FormatUint(uint64(ret.Size), 10),
+			"car_dir":      filepath.Dir(p),
+		}
+		if ret.PayloadCid.Defined() {
+			row["payload_cid"] = ret.PayloadCid.String()
+		}
+		if isZstdFile(p) {
+			row["compression"] = CompressZstd
+		}
+		if seen[manifestKey(row)] {
+			continue
+		}
+		seen[manifestKey(row)] = true
+		repair.Added = append(repair.Added, p)
+		keptHeaders = append(keptHeaders, header)
+		kept = append(kept, row)
+	}
+
+	if dryRun || !repair.Changed() {
+		return repair, nil
+	}
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	if format != ManifestCSV {
+		return repair, writeJSONManifest(carDir, format, keptHeaders, kept)
+	}
+	out, err := createAtomic(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	w := csv.NewWriter(out)
+	w.UseCRLF = true
+	records := [][]string{header}
+	for _, row := range kept {
+		record := make([]string, 0, len(header))
+		for _, col := range header {
+			record = append(record, row[col])
+		}
+		records = append(records, record)
+	}
+	if err := w.WriteAll(records); err != nil {
+		out.Abort()
+		return nil, err
+	}
+	return repair, out.Commit()
+}
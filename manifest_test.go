@@ -0,0 +1,155 @@
+package graphsplit
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestManifestHeaderMigration(t *testing.T) {
+	dir := t.TempDir()
+	old := "payload_cid,filename,piece_cid,payload_size,piece_size,detail\r\n" +
+		"bafyold,old.car,bagaold,100,128,{}\r\n"
+	if err := os.WriteFile(filepath.Join(dir, ManifestFileName), []byte(old), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	row := map[string]string{"payload_cid": "bafynew", "filename": "new.car", "piece_cid": "baganew", "batch_id": "1"}
+	if err := appendManifest(dir, commPManifestHeader, row); err != nil {
+		t.Fatal(err)
+	}
+	readRecords := func() [][]string {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(dir, ManifestFileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+		if err != nil {
+			t.Fatalf("expected the records to be padded to the header: %s", err)
+		}
+		return records
+	}
+	records := readRecords()
+	header := records[0]
+	if header[0] != "payload_cid" || header[5] != "detail" || len(header) != len(commPManifestHeader) {
+		t.Fatalf("expected the old columns followed by the missing ones, got %v", header)
+	}
+	if len(records) != 3 || records[1][2] != "bagaold" || records[2][slices.Index(header, "batch_id")] != "1" {
+		t.Fatalf("unexpected records %v", records)
+	}
+
+	var buf bytes.Buffer
+	n, err := ExportBatch(dir, 1, &buf)
+	if err != nil || n != 1 {
+		t.Fatalf("expected the new row in batch 1, got %d, %v", n, err)
+	}
+
+	// a manifest with all columns is appended to
+	row = map[string]string{"payload_cid": "bafynext", "piece_cid": "baganext", "batch_id": "1"}
+	if err := appendManifest(dir, commPManifestHeader, row); err != nil {
+		t.Fatal(err)
+	}
+	if records = readRecords(); len(records) != 4 {
+		t.Fatalf("expected 3 rows, got %d", len(records)-1)
+	}
+}
+
+func TestManifestV2(t *testing.T) {
+	dir := t.TempDir()
+	o := newCallbackOptions([]CallbackOption{WithManifestVersion(2)})
+	header := o.manifestHeader(commPManifestHeader)
+	if header[0] != "payload_cid" || header[len(manifestV2Columns)] != "filename" {
+		t.Fatalf("unexpected header %v", header)
+	}
+	if len(o.checksums) != 1 || o.checksums[0] != ChecksumSha256 {
+		t.Fatalf("sha256 is not computed, checksums %v", o.checksums)
+	}
+	if err := appendManifest(dir, header, map[string]string{"payload_cid": "bafy", "filename": "a", "files": `[{"path":"a","offset":0,"size":1}]`}); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := ReadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0]["filename"] != "a" || rows[0]["files"] == "" {
+		t.Fatalf("unexpected rows %v", rows)
+	}
+	if err := CheckManifestVersion(dir, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckManifestVersion(dir, 1); err == nil {
+		t.Fatal("version 1 matches a version 2 manifest")
+	}
+}
+
+func TestManifestNDJSON(t *testing.T) {
+	for _, format := range []ManifestFormat{ManifestNDJSON, ManifestJSON} {
+		dir := t.TempDir()
+		o := newCallbackOptions([]CallbackOption{WithManifestFormat(format)})
+		for _, payload := range []string{"bafy1", "bafy2"} {
+			if err := o.appendManifest(dir, csvManifestHeader, map[string]string{"payload_cid": payload, "filename": payload + ".car"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := updateManifest(dir, "bafy2", map[string]string{"upload_url": "s3://bucket/bafy2.car"}); err != nil {
+			t.Fatal(err)
+		}
+		rows, err := ReadManifest(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != 2 || rows[0]["filename"] != "bafy1.car" || rows[1]["upload_url"] != "s3://bucket/bafy2.car" {
+			t.Fatalf("%s: unexpected rows %v", format, rows)
+		}
+	}
+}
+
+func TestManifestCrashSafe(t *testing.T) {
+	dir := t.TempDir()
+	row := map[string]string{"payload_cid": "bafy1", "piece_cid": "baga1"}
+	if err := appendManifest(dir, commPManifestHeader, row); err != nil {
+		t.Fatal(err)
+	}
+	// a row cut off by a crash
+	f, err := os.OpenFile(filepath.Join(dir, ManifestFileName), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("bafy2,graph")
+	f.Close()
+	for _, r := range []map[string]string{row, {"payload_cid": "bafy3", "piece_cid": "baga3"}} {
+		if err := appendManifest(dir, commPManifestHeader, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rows, err := ReadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[1]["payload_cid"] != "bafy3" {
+		t.Fatalf("unexpected rows %v", rows)
+	}
+
+	// bafy3 has a CAR file, bafy1 has none
+	if err := os.WriteFile(filepath.Join(dir, "baga3.car"), []byte("car"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	repair, err := RepairManifest(context.Background(), dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repair.Removed) != 1 || repair.Removed[0] != "bafy1" || len(repair.Added) != 0 {
+		t.Fatalf("unexpected repair %+v", repair)
+	}
+	rows, err = ReadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0]["payload_cid"] != "bafy3" {
+		t.Fatalf("unexpected rows %v", rows)
+	}
+}
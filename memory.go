@@ -0,0 +1,86 @@
+package graphsplit
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/docker/go-units"
+)
+
+// memBudget is a byte weighted semaphore bounding the memory held by file
+// node builders running in parallel. Workers block in acquire until enough
+// budget has been released, instead of piling up blocks until the process
+// runs out of memory.
+type memBudget struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	total int64
+	used  int64
+}
+
+func newMemBudget(total int64) *memBudget {
+	mb := &memBudget{total: total}
+	mb.cond = sync.NewCond(&mb.mu)
+	return mb
+}
+
+// newChunkMemBudget derives the worker budget from the max memory of a chunk
+// run. The staging blockstore and the CAR buffer of a slice are both about
+// slice size, they are reserved up front and the rest is left to workers.
+func newChunkMemBudget(maxMemory, sliceSize int64) (*memBudget, error) {
+	if maxMemory <= 0 {
+		return nil, nil
+	}
+	reserved := 2 * sliceSize
+	if maxMemory < reserved+int64(UnixfsChunkSize) {
+		return nil, fmt.Errorf("max memory %s is too small, at least %s is needed for slice size %s",
+			units.BytesSize(float64(maxMemory)),
+			units.BytesSize(float64(reserved+int64(UnixfsChunkSize))),
+			units.BytesSize(float64(sliceSize)))
+	}
+	return newMemBudget(maxMemory - reserved), nil
+}
+
+// acquire reserves n bytes and returns the amount actually reserved, which
+// has to be passed back to release. Requests larger than the whole budget
+// are clamped so that a single big file can still be built on its own.
+func (mb *memBudget) acquire(n int64) int64 {
+	if mb == nil {
+		return 0
+	}
+	if n > mb.total {
+		n = mb.total
+	}
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	for mb.used+n > mb.total {
+		mb.cond.Wait()
+	}
+	mb.used += n
+	return n
+}
+
+func (mb *memBudget) release(n int64) {
+	if mb == nil || n == 0 {
+		return
+	}
+	mb.mu.Lock()
+	mb.used -= n
+	mb.mu.Unlock()
+	mb.cond.Broadcast()
+}
+
+// maxWorkers returns how many workers of chunk size fit into the budget.
+func (mb *memBudget) maxWorkers(parallel int) int {
+	if mb == nil {
+		return parallel
+	}
+	n := int(mb.total / int64(UnixfsChunkSize))
+	if n < 1 {
+		n = 1
+	}
+	if parallel > n {
+		return n
+	}
+	return parallel
+}
@@ -0,0 +1,68 @@
+package graphsplit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChunkMemBudget(t *testing.T) {
+	if mb, err := newChunkMemBudget(0, 1<<20); mb != nil || err != nil {
+		t.Fatalf("expected no budget without max memory, got %v, %v", mb, err)
+	}
+	if _, err := newChunkMemBudget(2<<20, 1<<20); err == nil {
+		t.Fatal("expected an error for a budget below two slices and a chunk")
+	}
+	mb, err := newChunkMemBudget(8<<20, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mb.total != 6<<20 {
+		t.Fatalf("expected two slices to be reserved, got a worker budget of %d", mb.total)
+	}
+	if n := mb.maxWorkers(16); n != 6 {
+		t.Fatalf("expected 6 workers of chunk size, got %d", n)
+	}
+	if n := mb.maxWorkers(2); n != 2 {
+		t.Fatalf("expected parallel below the budget to be kept, got %d", n)
+	}
+	if n := (*memBudget)(nil).maxWorkers(16); n != 16 {
+		t.Fatalf("expected no limit without budget, got %d", n)
+	}
+}
+
+func TestMemBudgetAcquire(t *testing.T) {
+	mb := newMemBudget(100)
+	if n := mb.acquire(1000); n != 100 {
+		t.Fatalf("expected a request above the budget to be clamped, got %d", n)
+	}
+	mb.release(100)
+
+	first := mb.acquire(60)
+	acquired := make(chan int64)
+	go func() {
+		acquired <- mb.acquire(60)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("expected the second worker to wait for the budget")
+	case <-time.After(50 * time.Millisecond):
+	}
+	mb.release(first)
+	select {
+	case n := <-acquired:
+		if n != 60 {
+			t.Fatalf("expected 60 bytes, got %d", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the release to wake up the waiting worker")
+	}
+	if mb.used != 60 {
+		t.Fatalf("expected 60 bytes in use, got %d", mb.used)
+	}
+
+	var nilBudget *memBudget
+	if n := nilBudget.acquire(1 << 40); n != 0 {
+		t.Fatalf("expected nothing reserved without budget, got %d", n)
+	}
+	nilBudget.release(0)
+}
@@ -0,0 +1,151 @@
+package graphsplit
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+	"github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+)
+
+// MergedCar describes a CAR merged from several CAR files.
+type MergedCar struct {
+	Root cid.Cid
+	// Blocks and DuplicateBlocks count the blocks written and skipped
+	// because an earlier CAR already had them
+	Blocks          int
+	DuplicateBlocks int
+}
+
+// MergeCars combines the CAR files carPaths into a single CAR at outPath. Its
+// root is a new UnixFS directory linking the root of every CAR under the name
+// of its file, blocks shared by several CAR files are written once.
+func MergeCars(ctx context.Context, carPaths []string, outPath string) (*MergedCar, error) {
+	if len(carPaths) == 0 {
+		return nil, fmt.Errorf("no car files to merge")
+	}
+	cidBuilder, err := dag.PrefixForCidVersion(1)
+	if err != nil {
+		return nil, err
+	}
+	dir := unixfs.EmptyDirNode()
+	if err := dir.SetCidBuilder(cidBuilder); err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for _, path := range carPaths {
+		root, size, err := carRootAndSize(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		name := carLinkName(path, names)
+		if err := dir.AddRawLink(name, &ipld.Link{Name: name, Size: size, Cid: root}); err != nil {
+			return nil, err
+		}
+	}
+
+	out, err := createAtomic(outPath)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(out)
+	res := &MergedCar{Root: dir.Cid()}
+	err = func() error {
+		if err := car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{res.Root}, Version: 1}, w); err != nil {
+			return err
+		}
+		if err := carutil.LdWrite(w, res.Root.Bytes(), dir.RawData()); err != nil {
+			return err
+		}
+		seen := map[cid.Cid]struct{}{res.Root: {}}
+		res.Blocks++
+		for _, path := range carPaths {
+			if err := copyCarBlocks(path, w, seen, res); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+		}
+		return w.Flush()
+	}()
+	if err != nil {
+		out.Abort()
+		return nil, err
+	}
+	return res, out.Commit()
+}
+
+// carRootAndSize returns the single root of a CAR and the size of its blocks.
+func carRootAndSize(path string) (cid.Cid, uint64, error) {
+	f, err := openCar(path)
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+	defer f.Close() //nolint:errcheck
+	cr, err := car.NewCarReader(f)
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+	if len(cr.Header.Roots) != 1 {
+		return cid.Undef, 0, fmt.Errorf("cannot merge car with %d roots", len(cr.Header.Roots))
+	}
+	var size uint64
+	for {
+		blk, err := cr.Next()
+		if err == io.EOF {
+			return cr.Header.Roots[0], size, nil
+		}
+		if err != nil {
+			return cid.Undef, 0, err
+		}
+		size += uint64(len(blk.RawData()))
+	}
+}
+
+// carLinkName names the link to a merged CAR after its file, unique in names.
+func carLinkName(path string, names map[string]bool) string {
+	base := strings.TrimSuffix(filepath.Base(path), compressionExt(CompressZstd))
+	base = strings.TrimSuffix(base, ".car")
+	name := base
+	for i := 1; names[name]; i++ {
+		name = fmt.Sprintf("%s-%d", base, i)
+	}
+	names[name] = true
+	return name
+}
+
+func copyCarBlocks(path string, w io.Writer, seen map[cid.Cid]struct{}, res *MergedCar) error {
+	f, err := openCar(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close() //nolint:errcheck
+	cr, err := car.NewCarReader(f)
+	if err != nil {
+		return err
+	}
+	for {
+		blk, err := cr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, ok := seen[blk.Cid()]; ok {
+			res.DuplicateBlocks++
+			continue
+		}
+		seen[blk.Cid()] = struct{}{}
+		if err := carutil.LdWrite(w, blk.Cid().Bytes(), blk.RawData()); err != nil {
+			return err
+		}
+		res.Blocks++
+	}
+}
@@ -0,0 +1,43 @@
+package graphsplit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipld/go-car"
+)
+
+func TestMergeCars(t *testing.T) {
+	dir := t.TempDir()
+	shared := blocks.NewBlock([]byte("shared"))
+	a := []blocks.Block{blocks.NewBlock([]byte("a")), shared}
+	b := []blocks.Block{blocks.NewBlock([]byte("b")), shared}
+	writeTestCar(t, filepath.Join(dir, "a.car"), a)
+	writeTestCar(t, filepath.Join(dir, "b.car"), b)
+
+	out := filepath.Join(dir, "merged.car")
+	res, err := MergeCars(context.Background(), []string{filepath.Join(dir, "a.car"), filepath.Join(dir, "b.car")}, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Blocks != 4 || res.DuplicateBlocks != 1 {
+		t.Fatalf("unexpected blocks %d, duplicates %d", res.Blocks, res.DuplicateBlocks)
+	}
+
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	ms := make(memStore)
+	header, err := car.LoadCar(context.Background(), ms, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(header.Roots) != 1 || header.Roots[0] != res.Root || len(ms) != 4 {
+		t.Fatalf("unexpected merged car, roots %v, %d blocks", header.Roots, len(ms))
+	}
+}
@@ -0,0 +1,189 @@
+package graphsplit
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The metrics of the process, served by MetricsHandler.
+var (
+	metricBytesChunked = newCounterVec("graphsplit_bytes_chunked_total", "Bytes of source files built into slices.", "")
+	metricFilesChunked = newCounterVec("graphsplit_files_chunked_total", "Source files, or ranges of split files, built into slices.", "")
+	metricPieces       = newCounterVec("graphsplit_pieces_total", "Slices handed to the callbacks.", "")
+	metricPieceBytes   = newCounterVec("graphsplit_piece_bytes_total", "Bytes of the CARs handed to the callbacks.", "")
+	metricCommPBytes   = newCounterVec("graphsplit_commp_bytes_total", "Bytes the piece cid was computed of.", "")
+	metricCommPSeconds = newCounterVec("graphsplit_commp_seconds_total", "Seconds spent computing piece cids.", "")
+	metricLoops        = newCounterVec("graphsplit_loop_iterations_total", "Finished iterations of loop chunking.", "")
+	metricErrors       = newCounterVec("graphsplit_errors_total", "Errors by kind: slice, file_skipped, transient_io and webhook.", "kind")
+	metricQueueDepth   = newGaugeVec("graphsplit_queue_depth", "Files waiting for or being built into the current slice, and uploads waiting or in flight.", "queue")
+	metricStageSeconds = newHistogramVec("graphsplit_stage_duration_seconds", "Duration of the stages of a slice: build, commp, write and callbacks.", "stage",
+		[]float64{0.1, 0.5, 1, 5, 10, 30, 60, 120, 300, 600, 1800})
+)
+
+// RecordLoopIteration counts a finished iteration of loop chunking.
+func RecordLoopIteration() {
+	metricLoops.add("", 1)
+}
+
+type metric interface {
+	write(w io.Writer)
+}
+
+var (
+	metricsMu       sync.Mutex
+	metricsRegistry []metric
+)
+
+func registerMetric(m metric) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	metricsRegistry = append(metricsRegistry, m)
+}
+
+// metricVec is a counter or gauge, split by the values of label if it is set.
+type metricVec struct {
+	name   string
+	help   string
+	typ    string
+	label  string
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newCounterVec(name, help, label string) *metricVec {
+	m := &metricVec{name: name, help: help, typ: "counter", label: label, values: make(map[string]float64)}
+	registerMetric(m)
+	return m
+}
+
+func newGaugeVec(name, help, label string) *metricVec {
+	m := &metricVec{name: name, help: help, typ: "gauge", label: label, values: make(map[string]float64)}
+	registerMetric(m)
+	return m
+}
+
+func (m *metricVec) add(labelValue string, delta float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[labelValue] += delta
+}
+
+func (m *metricVec) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ)
+	if m.label == "" {
+		fmt.Fprintf(w, "%s %s\n", m.name, formatMetric(m.values[""]))
+		return
+	}
+	for _, lv := range sortedKeys(m.values) {
+		fmt.Fprintf(w, "%s{%s=%q} %s\n", m.name, m.label, lv, formatMetric(m.values[lv]))
+	}
+}
+
+// histogramVec is a histogram split by the values of label.
+type histogramVec struct {
+	name    string
+	help    string
+	label   string
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogramVec(name, help, label string, buckets []float64) *histogramVec {
+	h := &histogramVec{name: name, help: help, label: label, buckets: buckets, series: make(map[string]*histogram)}
+	registerMetric(h)
+	return h
+}
+
+func (h *histogramVec) observe(labelValue string, v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[labelValue]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[labelValue] = s
+	}
+	for i, le := range h.buckets {
+		if v <= le {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+// since observes the seconds passed since start.
+func (h *histogramVec) since(labelValue string, start time.Time) {
+	h.observe(labelValue, time.Since(start).Seconds())
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, lv := range sortedKeys(h.series) {
+		s := h.series[lv]
+		for i, le := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket{%s=%q,le=%q} %d\n", h.name, h.label, lv, formatMetric(le), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s=%q,le=\"+Inf\"} %d\n", h.name, h.label, lv, s.count)
+		fmt.Fprintf(w, "%s_sum{%s=%q} %s\n", h.name, h.label, lv, formatMetric(s.sum))
+		fmt.Fprintf(w, "%s_count{%s=%q} %d\n", h.name, h.label, lv, s.count)
+	}
+}
+
+func formatMetric(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// MetricsHandler serves the metrics in the Prometheus text format.
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		metricsMu.Lock()
+		defer metricsMu.Unlock()
+		for _, m := range metricsRegistry {
+			m.write(w)
+		}
+	})
+}
+
+// ListenMetrics serves the metrics on addr, e.g. :9090, at /metrics.
+func ListenMetrics(addr string) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", MetricsHandler())
+	srv := &http.Server{Handler: mux}
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Errorf("metrics on %s: %s", addr, err)
+		}
+	}()
+	return srv, nil
+}
@@ -0,0 +1,36 @@
+package graphsplit
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	errs := newCounterVec("graphsplit_test_errors_total", "Test errors.", "kind")
+	errs.add("slice", 2)
+	errs.add("webhook", 1)
+	stage := newHistogramVec("graphsplit_test_seconds", "Test durations.", "stage", []float64{1, 10})
+	stage.observe("build", 0.5)
+	stage.observe("build", 5)
+
+	rec := httptest.NewRecorder()
+	MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	for _, line := range []string{
+		"# TYPE graphsplit_test_errors_total counter",
+		`graphsplit_test_errors_total{kind="slice"} 2`,
+		`graphsplit_test_errors_total{kind="webhook"} 1`,
+		"# TYPE graphsplit_test_seconds histogram",
+		`graphsplit_test_seconds_bucket{stage="build",le="1"} 1`,
+		`graphsplit_test_seconds_bucket{stage="build",le="10"} 2`,
+		`graphsplit_test_seconds_bucket{stage="build",le="+Inf"} 2`,
+		`graphsplit_test_seconds_sum{stage="build"} 5.5`,
+		"# TYPE graphsplit_pieces_total counter",
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("missing %q in\n%s", line, body)
+		}
+	}
+}
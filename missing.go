@@ -0,0 +1,384 @@
+package graphsplit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+	"github.com/ipld/go-car"
+)
+
+// MissingRange is a range of a restored file which could not be recovered,
+// it is zero filled.
+type MissingRange struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+// MissingFile is a file restored with WithAllowMissing which is not
+// complete. Whole files and files truncated at TruncatedAt are lost from
+// there on, their size is unknown.
+type MissingFile struct {
+	Path        string         `json:"path"`
+	Car         string         `json:"car,omitempty"`
+	Ranges      []MissingRange `json:"ranges,omitempty"`
+	Whole       bool           `json:"whole,omitempty"`
+	TruncatedAt int64          `json:"truncated_at,omitempty"`
+	Error       string         `json:"error,omitempty"`
+}
+
+// MissingReport collects the unrecoverable files and ranges of a restore.
+type MissingReport struct {
+	mu    sync.Mutex
+	roots map[string]bool
+
+	Files []MissingFile `json:"files"`
+}
+
+func NewMissingReport() *MissingReport {
+	return &MissingReport{roots: make(map[string]bool)}
+}
+
+// WithAllowMissing restores the files of incomplete or corrupt CAR files as
+// far as their blocks are present instead of failing. Missing blocks are
+// zero filled when their size is known, the unrecoverable files and ranges
+// are added to report. Merge fills missing parts of split files the same
+// way.
+func WithAllowMissing(report *MissingReport) RestoreOption {
+	return func(o *restoreOptions) {
+		o.missing = report
+	}
+}
+
+func (mr *MissingReport) add(mf MissingFile) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	mr.Files = append(mr.Files, mf)
+}
+
+// Sorted returns the missing files ordered by path.
+func (mr *MissingReport) Sorted() []MissingFile {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	files := append([]MissingFile(nil), mr.Files...)
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files
+}
+
+// WriteFile writes the report as JSON to path.
+func (mr *MissingReport) WriteFile(path string) error {
+	data, err := json.MarshalIndent(struct {
+		Files []MissingFile `json:"files"`
+	}{mr.Sorted()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// CheckManifest adds the files listed in the detail of every row of
+// manifest.csv in carDir whose payload cid was not restored.
+func (mr *MissingReport) CheckManifest(carDir string) error {
+	rows, err := ReadManifest(carDir)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		mr.mu.Lock()
+		restored := mr.roots[row["payload_cid"]]
+		mr.mu.Unlock()
+		if restored {
+			continue
+		}
+		msg := fmt.Sprintf("the CAR file of payload %s is missing", row["payload_cid"])
+		var detail []SimplestFileInfo
+		if err := json.Unmarshal([]byte(row["detail"]), &detail); err != nil || len(detail) == 0 {
+			mr.add(MissingFile{Path: row["filename"], Whole: true, Error: msg})
+			continue
+		}
+		for _, f := range detail {
+			mr.add(MissingFile{Path: f.Path, Whole: true, Error: msg})
+		}
+	}
+	return nil
+}
+
+// carRoot returns the root of the CAR file or stitch manifest at path from
+// its header.
+func carRoot(path string) (cid.Cid, error) {
+	if isStitchManifest(path) {
+		sm, err := ReadStitchManifest(path)
+		if err != nil {
+			return cid.Undef, err
+		}
+		return cid.Decode(sm.Roots[0])
+	}
+	f, err := openCar(path)
+	if err != nil {
+		return cid.Undef, err
+	}
+	defer f.Close() //nolint:errcheck
+	cr, err := car.NewCarReader(f)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if len(cr.Header.Roots) != 1 {
+		return cid.Undef, fmt.Errorf("cannot restore car with %d roots", len(cr.Header.Roots))
+	}
+	return cr.Header.Roots[0], nil
+}
+
+// partialWriter writes the DAG of one CAR file with the blocks present in bs.
+type partialWriter struct {
+	ctx     context.Context
+	bs      bstore.Blockstore
+	car     string
+	limiter *RateLimiter
+	report  *MissingReport
+}
+
+// writeTo restores the DAG below root from the blocks of bs to outputDir.
+func (mr *MissingReport) writeTo(ctx context.Context, carPath string, bs bstore.Blockstore, root cid.Cid, outputDir string, limiter *RateLimiter) error {
+	mr.mu.Lock()
+	mr.roots[root.String()] = true
+	mr.mu.Unlock()
+	pw := &partialWriter{ctx: ctx, bs: bs, car: carPath, limiter: limiter, report: mr}
+	return pw.write(root, outputDir, "")
+}
+
+// node returns the block of c decoded, nil for raw leaves and false if it is
+// missing.
+func (pw *partialWriter) node(c cid.Cid) (*merkledag.ProtoNode, []byte, bool, error) {
+	blk, err := pw.bs.Get(pw.ctx, c)
+	if ipld.IsNotFound(err) {
+		return nil, nil, false, nil
+	}
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if c.Prefix().Codec != cid.DagProtobuf {
+		return nil, blk.RawData(), true, nil
+	}
+	nd, err := merkledag.DecodeProtobuf(blk.RawData())
+	if err != nil {
+		return nil, nil, false, err
+	}
+	return nd, blk.RawData(), true, nil
+}
+
+func (pw *partialWriter) write(c cid.Cid, fpath, rel string) error {
+	nd, data, ok, err := pw.node(c)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		pw.report.add(MissingFile{Path: rel, Car: pw.car, Whole: true, Error: fmt.Sprintf("block %s is missing", c)})
+		return nil
+	}
+	if nd == nil {
+		return os.WriteFile(fpath, data, 0o644)
+	}
+	fsn, err := unixfs.FSNodeFromBytes(nd.Data())
+	if err != nil {
+		return err
+	}
+	if fsn.IsDir() {
+		if err := os.MkdirAll(fpath, 0o777); err != nil {
+			return err
+		}
+		for _, ln := range nd.Links() {
+			if err := pw.write(ln.Cid, filepath.Join(fpath, ln.Name), path.Join(rel, ln.Name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	f, err := os.Create(fpath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var ranges []MissingRange
+	if _, err := pw.writeFile(pw.limiter.Writer(f), nd, fsn, 0, &ranges); err != nil {
+		return fmt.Errorf("%s: %w", fpath, err)
+	}
+	if len(ranges) > 0 {
+		pw.report.add(MissingFile{Path: rel, Car: pw.car, Ranges: ranges})
+	}
+	return nil
+}
+
+// writeFile writes the data of the file node nd starting at offset, the
+// children which are missing are zero filled and added to ranges. It returns
+// the offset after the data.
+func (pw *partialWriter) writeFile(w io.Writer, nd *merkledag.ProtoNode, fsn *unixfs.FSNode, offset int64, ranges *[]MissingRange) (int64, error) {
+	n, err := w.Write(fsn.Data())
+	if err != nil {
+		return offset, err
+	}
+	offset += int64(n)
+	for i, ln := range nd.Links() {
+		size := int64(fsn.BlockSize(i))
+		child, data, ok, err := pw.node(ln.Cid)
+		if err != nil {
+			return offset, err
+		}
+		switch {
+		case !ok:
+			if _, err := io.CopyN(w, NullReader{}, size); err != nil {
+				return offset, err
+			}
+			addRange(ranges, offset, size)
+			offset += size
+		case child == nil:
+			if _, err := w.Write(data); err != nil {
+				return offset, err
+			}
+			offset += int64(len(data))
+		default:
+			childFsn, err := unixfs.FSNodeFromBytes(child.Data())
+			if err != nil {
+				return offset, err
+			}
+			if offset, err = pw.writeFile(w, child, childFsn, offset, ranges); err != nil {
+				return offset, err
+			}
+		}
+	}
+	return offset, nil
+}
+
+// addRange adds a missing range, merged with the previous one if adjacent.
+func addRange(ranges *[]MissingRange, offset, length int64) {
+	if n := len(*ranges); n > 0 && (*ranges)[n-1].Offset+(*ranges)[n-1].Length == offset {
+		(*ranges)[n-1].Length += length
+		return
+	}
+	*ranges = append(*ranges, MissingRange{Offset: offset, Length: length})
+}
+
+// mergeParts returns the part files of the split file fpath in order, parts
+// missing in between are nil. Their size is only known when a complete part
+// other than the first and the last one is present, all of them have the
+// same size.
+func mergeParts(fpath string) ([]*string, int64, error) {
+	matches, err := filepath.Glob(globEscape(fpath) + ".[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]")
+	if err != nil {
+		return nil, 0, err
+	}
+	last := -1
+	present := make(map[int]string)
+	for _, m := range matches {
+		i, err := strconv.Atoi(m[len(m)-8:])
+		if err != nil {
+			continue
+		}
+		present[i] = m
+		if i > last {
+			last = i
+		}
+	}
+	parts := make([]*string, last+1)
+	partSize := int64(-1)
+	for i := 0; i <= last; i++ {
+		p, ok := present[i]
+		if !ok {
+			continue
+		}
+		parts[i] = &p
+		if i > 0 && i < last && partSize < 0 {
+			if fi, err := os.Stat(p); err == nil {
+				partSize = fi.Size()
+			}
+		}
+	}
+	return parts, partSize, nil
+}
+
+// globEscape escapes the meta characters of filepath.Match in s.
+func globEscape(s string) string {
+	var out []rune
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', '\\':
+			out = append(out, '\\')
+		}
+		out = append(out, r)
+	}
+	return string(out)
+}
+
+// mergeMissing merges the parts of the split file fpath like Merge, missing
+// parts are zero filled if their size is known, otherwise the file is
+// truncated at the first of them and the following parts are kept.
+func mergeMissing(fpath, rel string, o restoreOptions) error {
+	parts, partSize, err := mergeParts(fpath)
+	if err != nil {
+		return err
+	}
+	f, err := createAtomic(fpath)
+	if err != nil {
+		return err
+	}
+	w := o.writeLimiter.Writer(f)
+	mf := MissingFile{Path: rel}
+	var offset int64
+	var merged []string
+	for i, p := range parts {
+		if p == nil {
+			if partSize < 0 {
+				mf.TruncatedAt = offset
+				mf.Error = fmt.Sprintf("part %d is missing and its size is unknown, the parts after it are kept", i)
+				break
+			}
+			if _, err := io.CopyN(w, NullReader{}, partSize); err != nil {
+				f.Abort()
+				return err
+			}
+			addRange(&mf.Ranges, offset, partSize)
+			offset += partSize
+			continue
+		}
+		n, err := copyFile(w, *p)
+		if err != nil {
+			f.Abort()
+			return err
+		}
+		offset += n
+		merged = append(merged, *p)
+	}
+	if err := f.Commit(); err != nil {
+		return err
+	}
+	for _, p := range merged {
+		os.Remove(p)
+	}
+	if len(mf.Ranges) > 0 || mf.Error != "" {
+		if mf.Error == "" {
+			mf.Error = "missing parts are zero filled"
+		}
+		o.missing.add(mf)
+	}
+	return nil
+}
+
+func copyFile(w io.Writer, path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return io.Copy(w, f)
+}
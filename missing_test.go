@@ -0,0 +1,75 @@
+package graphsplit
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	dag "github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+)
+
+func TestRestoreAllowMissing(t *testing.T) {
+	dir := t.TempDir()
+	data := make([]byte, 3<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	src := filepath.Join(dir, "f.bin")
+	if err := os.WriteFile(src, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fi, _ := os.Stat(src)
+
+	ctx := context.Background()
+	bs := bstore.NewBlockstore(dss.MutexWrap(datastore.NewMapDatastore()))
+	dagServ := dag.NewDAGService(blockservice.New(bs, offline.Exchange(bs)))
+	cidBuilder, _ := dag.PrefixForCidVersion(1)
+	fileNode, err := buildFileNode(context.Background(), Finfo{Path: src, Name: "f.bin", Info: fi}, dagServ, cidBuilder, nil, nil, 1, nil, nil, RetryPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := unixfs.EmptyDirNode()
+	root.SetCidBuilder(cidBuilder)
+	root.AddNodeLink("f.bin", fileNode)
+	blks := []blocks.Block{root}
+	keys, _ := bs.AllKeysChan(ctx)
+	for k := range keys {
+		blk, _ := bs.Get(ctx, k)
+		// lose the second MiB of the file
+		if bytes.Contains(blk.RawData(), data[1<<20:1<<20+64]) && len(blk.RawData()) < 2<<20 {
+			continue
+		}
+		blks = append(blks, blk)
+	}
+	carDir := filepath.Join(dir, "cars")
+	os.Mkdir(carDir, 0o755)
+	writeTestCar(t, filepath.Join(carDir, "a.car"), blks)
+
+	out := t.TempDir()
+	report := NewMissingReport()
+	if err := carTo(carDir, out, 1, WithAllowMissing(report)); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := os.ReadFile(filepath.Join(out, "f.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored) != len(data) || !bytes.Equal(restored[:1<<20], data[:1<<20]) || !bytes.Equal(restored[2<<20:], data[2<<20:]) {
+		t.Fatal("present blocks were not restored in place")
+	}
+	if !bytes.Equal(restored[1<<20:2<<20], make([]byte, 1<<20)) {
+		t.Fatal("missing block was not zero filled")
+	}
+	files := report.Sorted()
+	if len(files) != 1 || files[0].Path != "f.bin" || len(files[0].Ranges) != 1 || files[0].Ranges[0] != (MissingRange{Offset: 1 << 20, Length: 1 << 20}) {
+		t.Fatalf("unexpected report %+v", files)
+	}
+}
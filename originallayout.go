@@ -0,0 +1,78 @@
+package graphsplit
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+)
+
+// OriginalLayout returns where the DAG of every payload cid of manifest.csv
+// in carDir belongs, relative to datasetRoot, from the root_path column
+// chunking recorded. An empty datasetRoot is the deepest directory all root
+// paths are below.
+func OriginalLayout(carDir, datasetRoot string) (map[string]string, error) {
+	rows, err := ReadManifest(carDir)
+	if err != nil {
+		return nil, err
+	}
+	roots := make(map[string]string)
+	for _, row := range rows {
+		if row["root_path"] == "" {
+			log.Warnf("no root path recorded for payload %s, it is restored to the output directory", row["payload_cid"])
+			continue
+		}
+		roots[row["payload_cid"]] = filepath.Clean(row["root_path"])
+	}
+	if datasetRoot == "" {
+		for _, p := range roots {
+			if datasetRoot == "" {
+				datasetRoot = p
+				continue
+			}
+			for !isBelow(p, datasetRoot) {
+				datasetRoot = filepath.Dir(datasetRoot)
+			}
+		}
+	}
+	datasetRoot = filepath.Clean(datasetRoot)
+	layout := make(map[string]string, len(roots))
+	for payload, p := range roots {
+		if !isBelow(p, datasetRoot) {
+			return nil, fmt.Errorf("root path %s of payload %s is not below %s", p, payload, datasetRoot)
+		}
+		rel, err := filepath.Rel(datasetRoot, p)
+		if err != nil {
+			return nil, err
+		}
+		layout[payload] = rel
+	}
+	return layout, nil
+}
+
+// isBelow reports whether p is dir or below it.
+func isBelow(p, dir string) bool {
+	if p == dir || dir == string(filepath.Separator) || dir == "." {
+		return true
+	}
+	return strings.HasPrefix(p, dir+string(filepath.Separator))
+}
+
+// WithOriginalLayout restores the DAG of every CAR file to the directory
+// below the output directory layout maps its payload cid to, see
+// OriginalLayout.
+func WithOriginalLayout(layout map[string]string) RestoreOption {
+	return func(o *restoreOptions) {
+		o.layout = layout
+	}
+}
+
+// layoutDir returns the directory the DAG of root is restored to.
+func (o *restoreOptions) layoutDir(outputDir string, root cid.Cid) string {
+	rel, ok := o.layout[root.String()]
+	if !ok || rel == "." {
+		return outputDir
+	}
+	return filepath.Join(outputDir, rel)
+}
@@ -0,0 +1,61 @@
+package graphsplit
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+)
+
+func TestOriginalLayout(t *testing.T) {
+	carDir := t.TempDir()
+	dataset := filepath.Join(t.TempDir(), "data")
+	for payload, root := range map[string]string{
+		"bafyx":    filepath.Join(dataset, "a", "x"),
+		"bafyy":    filepath.Join(dataset, "a", "y"),
+		"bafyb":    filepath.Join(dataset, "b"),
+		"bafyold":  "",
+		"bafyroot": dataset,
+	} {
+		if err := appendManifest(carDir, csvManifestHeader, map[string]string{"payload_cid": payload, "root_path": root}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	layout, err := OriginalLayout(carDir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"bafyx":    filepath.Join("a", "x"),
+		"bafyy":    filepath.Join("a", "y"),
+		"bafyb":    "b",
+		"bafyroot": ".",
+	}
+	if len(layout) != len(want) {
+		t.Fatalf("expected %v, got %v", want, layout)
+	}
+	for payload, rel := range want {
+		if layout[payload] != rel {
+			t.Fatalf("expected %s for %s, got %q", rel, payload, layout[payload])
+		}
+	}
+
+	if _, err := OriginalLayout(carDir, filepath.Join(dataset, "a")); err == nil {
+		t.Fatal("expected an error for root paths outside the dataset root")
+	}
+
+	o := newRestoreOptions([]RestoreOption{WithOriginalLayout(map[string]string{
+		"bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi": filepath.Join("a", "x"),
+	})})
+	c, err := cid.Decode("bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir := o.layoutDir("out", c); dir != filepath.Join("out", "a", "x") {
+		t.Fatalf("unexpected layout dir %s", dir)
+	}
+	if dir := o.layoutDir("out", cid.Undef); dir != "out" {
+		t.Fatalf("expected an unknown root in the output dir, got %s", dir)
+	}
+}
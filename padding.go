@@ -0,0 +1,40 @@
+package graphsplit
+
+import (
+	"fmt"
+
+	"github.com/docker/go-units"
+	"github.com/filecoin-project/go-state-types/abi"
+)
+
+// minPieceSize and maxPieceSize are the padded sizes of the smallest piece
+// and of the biggest sector, 64GiB.
+const (
+	minPieceSize = abi.PaddedPieceSize(128)
+	maxPieceSize = abi.PaddedPieceSize(64 << 30)
+)
+
+// ValidatePaddedSize checks that a CAR padded to size bytes is exactly the
+// unpadded size of a piece fitting a sector, otherwise sealing fails later.
+// The error lists the nearest valid sizes.
+func ValidatePaddedSize(size int64) error {
+	if size > 0 && size <= int64(maxPieceSize.Unpadded()) && abi.UnpaddedPieceSize(size).Validate() == nil {
+		return nil
+	}
+	var below, above abi.PaddedPieceSize
+	for p := minPieceSize; p <= maxPieceSize; p <<= 1 {
+		if int64(p.Unpadded()) < size {
+			below = p
+		} else if above == 0 {
+			above = p
+		}
+	}
+	var nearest []string
+	for _, p := range []abi.PaddedPieceSize{below, above} {
+		if p != 0 {
+			nearest = append(nearest, fmt.Sprintf("%d (%s piece)", p.Unpadded(), units.BytesSize(float64(p))))
+		}
+	}
+	return fmt.Errorf("padded size %d is not the unpadded size of a piece of at most %s, nearest valid sizes: %v",
+		size, units.BytesSize(float64(maxPieceSize)), nearest)
+}
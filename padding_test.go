@@ -0,0 +1,21 @@
+package graphsplit
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidatePaddedSize(t *testing.T) {
+	for _, size := range []int64{127, 254, 32 << 30 / 128 * 127} {
+		if err := ValidatePaddedSize(size); err != nil {
+			t.Fatalf("%d: %s", size, err)
+		}
+	}
+	err := ValidatePaddedSize(255)
+	if err == nil || !strings.Contains(err.Error(), "254 (256B piece) 508 (512B piece)") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := ValidatePaddedSize(128 << 30); err == nil {
+		t.Fatal("piece bigger than a sector is valid")
+	}
+}
@@ -0,0 +1,471 @@
+package graphsplit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// RecoverySuffix names the recovery manifest of a parity group.
+const RecoverySuffix = ".recovery.json"
+
+const parityStripeSize = 1 << 20
+
+// RecoveryManifest describes a group of data pieces and the Reed-Solomon
+// parity pieces computed over them. Shards are the piece files zero padded
+// to ShardSize, parity pieces are CAR files holding one parity shard file.
+type RecoveryManifest struct {
+	Version      int            `json:"version"`
+	DataShards   int            `json:"data_shards"`
+	ParityShards int            `json:"parity_shards"`
+	ShardSize    int64          `json:"shard_size"`
+	Data         []RecoveryFile `json:"data"`
+	Parity       []RecoveryFile `json:"parity"`
+}
+
+// RecoveryFile is a piece of a parity group, File is relative to the
+// manifest.
+type RecoveryFile struct {
+	File     string `json:"file"`
+	Size     int64  `json:"size"`
+	PieceCid string `json:"piece_cid,omitempty"`
+}
+
+func isRecoveryManifest(path string) bool {
+	return strings.HasSuffix(path, RecoverySuffix)
+}
+
+// GenerateParity computes parityShards parity pieces over the piece files
+// dataFiles, which have to be in carDir. The parity pieces are built into CAR
+// files in carDir like data pieces, including their manifest.csv rows, and the
+// recovery manifest is written to carDir/<name>.recovery.json.
+func GenerateParity(ctx context.Context, carDir string, dataFiles []string, parityShards int, name string) (*RecoveryManifest, error) {
+	rs, err := newRSCodec(len(dataFiles), parityShards)
+	if err != nil {
+		return nil, err
+	}
+	rm := &RecoveryManifest{Version: 1, DataShards: len(dataFiles), ParityShards: parityShards}
+	var inputs []*os.File
+	defer func() {
+		for _, f := range inputs {
+			f.Close()
+		}
+	}()
+	for _, path := range dataFiles {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, f)
+		st, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		rm.Data = append(rm.Data, RecoveryFile{File: filepath.Base(path), Size: st.Size()})
+		if st.Size() > rm.ShardSize {
+			rm.ShardSize = st.Size()
+		}
+	}
+
+	// the parity shards are written to plain files first and then built into
+	// CAR files, the files are named after the group so restore recreates them
+	var outputs []*os.File
+	defer func() {
+		for _, f := range outputs {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	for i := 0; i < parityShards; i++ {
+		f, err := os.Create(filepath.Join(carDir, fmt.Sprintf("%s-parity-%d.bin", name, i+1)))
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, f)
+	}
+	data := make([][]byte, len(inputs))
+	for i := range data {
+		data[i] = make([]byte, parityStripeSize)
+	}
+	parity := make([][]byte, parityShards)
+	for i := range parity {
+		parity[i] = make([]byte, parityStripeSize)
+	}
+	for off := int64(0); off < rm.ShardSize; off += parityStripeSize {
+		n := rm.ShardSize - off
+		if n > parityStripeSize {
+			n = parityStripeSize
+		}
+		for i, f := range inputs {
+			if err := readStripe(f, data[i][:n], off); err != nil {
+				return nil, err
+			}
+		}
+		stripe := make([][]byte, len(parity))
+		for i := range parity {
+			stripe[i] = parity[i][:n]
+		}
+		rs.encode(truncateShards(data, n), stripe)
+		for i, f := range outputs {
+			if _, err := f.Write(stripe[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, f := range outputs {
+		if err := f.Sync(); err != nil {
+			return nil, err
+		}
+		file, err := buildParityCar(ctx, carDir, f.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to build parity piece of %s: %w", f.Name(), err)
+		}
+		rm.Parity = append(rm.Parity, file)
+	}
+	for i := range rm.Data {
+		if row, err := findManifestRowByFile(carDir, rm.Data[i].File); err == nil && row != nil {
+			rm.Data[i].PieceCid = row["piece_cid"]
+		}
+	}
+
+	data2, err := json.MarshalIndent(rm, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	out, err := createAtomic(filepath.Join(carDir, name+RecoverySuffix))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := out.Write(data2); err != nil {
+		out.Abort()
+		return nil, err
+	}
+	return rm, out.Commit()
+}
+
+// readStripe reads len(p) bytes at off, zeros past the end of the file.
+func readStripe(f *os.File, p []byte, off int64) error {
+	n, err := f.ReadAt(p, off)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	for i := n; i < len(p); i++ {
+		p[i] = 0
+	}
+	return nil
+}
+
+func truncateShards(shards [][]byte, n int64) [][]byte {
+	res := make([][]byte, len(shards))
+	for i, s := range shards {
+		res[i] = s[:n]
+	}
+	return res
+}
+
+// buildParityCar builds the parity shard file at path into a CAR file of
+// carDir with its piece cid, like a slice holding only that file.
+func buildParityCar(ctx context.Context, carDir, path string) (RecoveryFile, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return RecoveryFile{}, err
+	}
+	ef, err := NewExtraFile("", 0, 0, false)
+	if err != nil {
+		return RecoveryFile{}, err
+	}
+	params := &ChunkParams{
+		ParentPath: filepath.Dir(path),
+		CarDir:     carDir,
+		Cb:         CommPCallback(carDir, false, false),
+		Ef:         ef,
+		parallel:   1,
+	}
+	name := strings.TrimSuffix(filepath.Base(path), ".bin")
+	payloadCid := BuildIpldGraph(ctx, []Finfo{{Path: path, Name: filepath.Base(path), Info: st}}, name, st.Size(), params)
+	if payloadCid == "" {
+		return RecoveryFile{}, fmt.Errorf("failed to build graph of %s", path)
+	}
+	row, err := findManifestRow(carDir, payloadCid)
+	if err != nil {
+		return RecoveryFile{}, err
+	}
+	carPath := locateCar(carDir, row)
+	if carPath == "" {
+		return RecoveryFile{}, fmt.Errorf("car file of %s not found", payloadCid)
+	}
+	carSt, err := os.Stat(carPath)
+	if err != nil {
+		return RecoveryFile{}, err
+	}
+	return RecoveryFile{File: filepath.Base(carPath), Size: carSt.Size(), PieceCid: row["piece_cid"]}, nil
+}
+
+func findManifestRowByFile(carDir, file string) (ManifestRow, error) {
+	rows, err := ReadManifest(carDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		if path := locateCar(carDir, row); path != "" && filepath.Base(path) == file {
+			return row, nil
+		}
+	}
+	return nil, nil
+}
+
+// ReadRecoveryManifest loads the recovery manifest at path.
+func ReadRecoveryManifest(path string) (*RecoveryManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rm RecoveryManifest
+	if err := json.Unmarshal(data, &rm); err != nil {
+		return nil, fmt.Errorf("invalid recovery manifest %s: %w", path, err)
+	}
+	if len(rm.Data) != rm.DataShards || len(rm.Parity) != rm.ParityShards {
+		return nil, fmt.Errorf("recovery manifest %s lists %d data and %d parity pieces, expected %d and %d",
+			path, len(rm.Data), len(rm.Parity), rm.DataShards, rm.ParityShards)
+	}
+	return &rm, nil
+}
+
+// RecoverPieces reconstructs the data pieces of the recovery manifest at
+// path which are missing next to it into outDir, from any DataShards of the
+// surviving data and parity pieces. It returns the paths of the recovered
+// pieces, which are checked against their piece cid when it is known.
+func RecoverPieces(ctx context.Context, manifestPath, outDir string) ([]string, error) {
+	rm, err := ReadRecoveryManifest(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(manifestPath)
+	rs, err := newRSCodec(rm.DataShards, rm.ParityShards)
+	if err != nil {
+		return nil, err
+	}
+
+	total := rm.DataShards + rm.ParityShards
+	present := make([]bool, total)
+	inputs := make([]*os.File, total)
+	defer func() {
+		for _, f := range inputs {
+			if f != nil {
+				f.Close()
+			}
+		}
+	}()
+	available := 0
+	var missing []int
+	for i, d := range rm.Data {
+		f, err := os.Open(filepath.Join(dir, d.File))
+		if err == nil {
+			if st, err := f.Stat(); err == nil && st.Size() == d.Size {
+				inputs[i], present[i] = f, true
+				available++
+				continue
+			}
+			f.Close()
+		}
+		log.Warnf("data piece %s is missing or damaged", d.File)
+		missing = append(missing, i)
+	}
+	if len(missing) == 0 {
+		return nil, nil
+	}
+
+	tmpDir, err := os.MkdirTemp(dir, "recover-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir) //nolint:errcheck
+	for i, p := range rm.Parity {
+		if available == rm.DataShards {
+			break
+		}
+		shard, err := extractParityShard(filepath.Join(dir, p.File), filepath.Join(tmpDir, strconv.Itoa(i)))
+		if err != nil {
+			log.Warnf("parity piece %s is not usable: %s", p.File, err)
+			continue
+		}
+		f, err := os.Open(shard)
+		if err != nil {
+			return nil, err
+		}
+		inputs[rm.DataShards+i], present[rm.DataShards+i] = f, true
+		available++
+	}
+	if available < rm.DataShards {
+		return nil, fmt.Errorf("%d of %d pieces are available, at least %d are needed", available, total, rm.DataShards)
+	}
+
+	outputs := make(map[int]*atomicFile, len(missing))
+	abort := func() {
+		for _, f := range outputs {
+			f.Abort()
+		}
+	}
+	for _, i := range missing {
+		f, err := createAtomic(filepath.Join(outDir, rm.Data[i].File))
+		if err != nil {
+			abort()
+			return nil, err
+		}
+		outputs[i] = f
+	}
+	shards := make([][]byte, total)
+	for i := range shards {
+		shards[i] = make([]byte, parityStripeSize)
+	}
+	for off := int64(0); off < rm.ShardSize; off += parityStripeSize {
+		n := rm.ShardSize - off
+		if n > parityStripeSize {
+			n = parityStripeSize
+		}
+		stripe := truncateShards(shards, n)
+		for i, f := range inputs {
+			if f == nil {
+				continue
+			}
+			if err := readStripe(f, stripe[i], off); err != nil {
+				abort()
+				return nil, err
+			}
+		}
+		if err := rs.reconstruct(stripe, present); err != nil {
+			abort()
+			return nil, err
+		}
+		for i, f := range outputs {
+			// the shards are zero padded beyond the size of the piece
+			if remaining := rm.Data[i].Size - off; remaining > 0 {
+				if remaining < n {
+					stripe[i] = stripe[i][:remaining]
+				}
+				if _, err := f.Write(stripe[i]); err != nil {
+					abort()
+					return nil, err
+				}
+			}
+		}
+	}
+
+	var recovered []string
+	for _, i := range missing {
+		if err := outputs[i].Commit(); err != nil {
+			return recovered, err
+		}
+		path := filepath.Join(outDir, rm.Data[i].File)
+		if want := rm.Data[i].PieceCid; want != "" {
+			res, err := CalcCommP(ctx, path, false, false)
+			if err != nil {
+				return recovered, fmt.Errorf("failed to check recovered piece %s: %w", path, err)
+			}
+			if res.Root.String() != want {
+				return recovered, fmt.Errorf("recovered piece %s has piece cid %s, expected %s", path, res.Root, want)
+			}
+		}
+		log.Infof("recovered data piece %s", path)
+		recovered = append(recovered, path)
+	}
+	return recovered, nil
+}
+
+// extractParityShard restores the parity CAR at carPath into dir and returns
+// the path of the parity shard file it holds.
+func extractParityShard(carPath, dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	if err := carTo(carPath, dir, 1); err != nil {
+		return "", err
+	}
+	var shard string
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err == nil && !fi.IsDir() && strings.HasSuffix(path, ".bin") {
+			shard = path
+		}
+		return err
+	})
+	if err == nil && shard == "" {
+		err = fmt.Errorf("no parity shard in %s", carPath)
+	}
+	return shard, err
+}
+
+// parityFiles returns the parity pieces of the recovery manifests below root.
+func parityFiles(root string) (map[string]bool, error) {
+	files := make(map[string]bool)
+	err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() || !isRecoveryManifest(path) {
+			return err
+		}
+		rm, err := ReadRecoveryManifest(path)
+		if err != nil {
+			return err
+		}
+		for _, p := range rm.Parity {
+			files[filepath.Join(filepath.Dir(path), p.File)] = true
+		}
+		return nil
+	})
+	return files, err
+}
+
+// ParityCallback generates parity pieces for every dataShards pieces written
+// by the callback it follows, Flush covers the pieces left at the end.
+type ParityCallback struct {
+	carDir       string
+	dataShards   int
+	parityShards int
+	pending      []string
+}
+
+func NewParityCallback(carDir string, dataShards, parityShards int) (*ParityCallback, error) {
+	if _, err := newRSCodec(dataShards, parityShards); err != nil {
+		return nil, err
+	}
+	return &ParityCallback{carDir: carDir, dataShards: dataShards, parityShards: parityShards}, nil
+}
+
+func (pc *ParityCallback) OnSuccess(buf *Buffer, slice *GraphSlice) {
+	row, err := findManifestRow(pc.carDir, slice.PayloadCid)
+	if err != nil {
+		log.Fatalf("failed to read manifest: %s", err)
+	}
+	carPath := locateCar(pc.carDir, row)
+	if carPath == "" {
+		log.Fatalf("parity pieces need the CAR file of %s in %s", slice.PayloadCid, pc.carDir)
+	}
+	pc.pending = append(pc.pending, carPath)
+	if len(pc.pending) == pc.dataShards {
+		if err := pc.Flush(context.Background()); err != nil {
+			log.Fatalf("failed to generate parity pieces: %s", err)
+		}
+	}
+}
+
+func (pc *ParityCallback) OnError(err error) {
+	log.Fatal(err)
+}
+
+// Flush generates the parity pieces of the pending pieces, a smaller group
+// at the end of a run.
+func (pc *ParityCallback) Flush(ctx context.Context) error {
+	if len(pc.pending) == 0 {
+		return nil
+	}
+	first := filepath.Base(pc.pending[0])
+	name := "parity-" + strings.TrimSuffix(first, filepath.Ext(first))
+	log.Infof("generating %d parity pieces for %d pieces, recovery manifest %s%s", pc.parityShards, len(pc.pending), name, RecoverySuffix)
+	_, err := GenerateParity(ctx, pc.carDir, pc.pending, pc.parityShards, name)
+	pc.pending = nil
+	return err
+}
@@ -0,0 +1,207 @@
+package graphsplit
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// PartitionPlan assigns every file of a dataset to exactly one partition, so
+// machines chunking one partition each cover the dataset without overlap.
+type PartitionPlan struct {
+	Root       string      `json:"root"`
+	Created    time.Time   `json:"created"`
+	Partitions []Partition `json:"partitions"`
+}
+
+// Partition is a contiguous run of the files of a dataset sorted by path,
+// relative to the root of the plan. A file is never split across partitions.
+type Partition struct {
+	Index int      `json:"index"`
+	Size  int64    `json:"size"`
+	Files []string `json:"files"`
+}
+
+// PlanPartitions walks root and splits its files into n partitions of about
+// the same size. Partitions keep files of a directory together as far as the
+// sizes allow.
+func PlanPartitions(root string, n int) (*PartitionPlan, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("partitions has to be at least 1")
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	var files []Finfo
+	var total int64
+	for item := range GetFileListAsync([]string{root}) {
+		files = append(files, item)
+		total += item.Info.Size()
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files in %s", root)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+
+	plan := &PartitionPlan{Root: root, Created: time.Now().UTC()}
+	var cumu int64
+	part := Partition{}
+	for i, item := range files {
+		rel, err := filepath.Rel(root, item.Path)
+		if err != nil {
+			return nil, err
+		}
+		part.Files = append(part.Files, filepath.ToSlash(rel))
+		part.Size += item.Info.Size()
+		cumu += item.Info.Size()
+		// close the partition once it reaches its share of the total, leaving
+		// a file for each partition still to come
+		k, left := len(plan.Partitions), len(files)-i-1
+		share := float64(total) * float64(k+1) / float64(n)
+		if k < n-1 && left >= n-1-k && (float64(cumu) >= share || left == n-1-k) {
+			plan.Partitions = append(plan.Partitions, part)
+			part = Partition{Index: len(plan.Partitions)}
+		}
+	}
+	if len(part.Files) > 0 {
+		plan.Partitions = append(plan.Partitions, part)
+	}
+	return plan, nil
+}
+
+// LoadPartitionPlan reads a plan written by Save.
+func LoadPartitionPlan(path string) (*PartitionPlan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var plan PartitionPlan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("failed to parse partition plan %s: %w", path, err)
+	}
+	return &plan, nil
+}
+
+// Save writes the plan to path, replacing it atomically.
+func (plan *PartitionPlan) Save(path string) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// Partition returns the partition with index i.
+func (plan *PartitionPlan) Partition(i int) (*Partition, error) {
+	if i < 0 || i >= len(plan.Partitions) {
+		return nil, fmt.Errorf("partition %d out of range, the plan has %d partitions", i, len(plan.Partitions))
+	}
+	return &plan.Partitions[i], nil
+}
+
+// filter returns the files of p among files listed below root. Files added
+// to the dataset after the plan was made are left out, files of p which are
+// gone are an error, so a partition is never chunked only in part.
+func (p *Partition) filter(root string, files []Finfo) ([]Finfo, error) {
+	want := make(map[string]bool, len(p.Files))
+	for _, f := range p.Files {
+		want[f] = false
+	}
+	var res []Finfo
+	for _, item := range files {
+		rel, err := filepath.Rel(root, item.Path)
+		if err != nil {
+			return nil, err
+		}
+		rel = filepath.ToSlash(rel)
+		if _, ok := want[rel]; ok {
+			want[rel] = true
+			res = append(res, item)
+		}
+	}
+	var missing []string
+	for f, found := range want {
+		if !found {
+			missing = append(missing, f)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("%d files of partition %d are missing from %s, e.g. %s", len(missing), p.Index, root, missing[0])
+	}
+	if len(res) < len(files) {
+		log.Infof("partition %d: chunking %d of %d files", p.Index, len(res), len(files))
+	}
+	return res, nil
+}
+
+// graphName returns the graph name of the slices of the partition, which
+// keeps slices of different partitions apart.
+func (p *Partition) graphName(name string) string {
+	return fmt.Sprintf("%s-part%04d", name, p.Index)
+}
+
+// MergeManifests appends the manifest rows of carDirs, the car dirs of the
+// partitions of one dataset, to the manifest in outDir. Rows already in it
+// are skipped, so it can be run again as more partitions finish. It returns
+// the number of rows appended.
+func MergeManifests(outDir string, carDirs []string) (int, error) {
+	var header []string
+	seen := make(map[string]bool)
+	addColumns := func(cols []string) {
+		for _, col := range cols {
+			if !seen[col] {
+				seen[col] = true
+				header = append(header, col)
+			}
+		}
+	}
+	var rows []ManifestRow
+	for _, carDir := range carDirs {
+		dirRows, err := ReadManifest(carDir)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read the manifest of %s: %w", carDir, err)
+		}
+		if cols, err := readManifestHeader(carDir); err == nil {
+			addColumns(cols)
+		}
+		for _, row := range dirRows {
+			keys := make([]string, 0, len(row))
+			for k := range row {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			addColumns(keys)
+		}
+		rows = append(rows, dirRows...)
+	}
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		return 0, err
+	}
+	before, _ := ReadManifest(outDir)
+	for _, row := range rows {
+		if err := appendManifest(outDir, header, row); err != nil {
+			return 0, err
+		}
+	}
+	after, err := ReadManifest(outDir)
+	if err != nil {
+		return 0, err
+	}
+	return len(after) - len(before), nil
+}
+
+// readManifestHeader returns the columns of manifest.csv in carDir.
+func readManifestHeader(carDir string) ([]string, error) {
+	f, err := os.Open(filepath.Join(carDir, ManifestFileName))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return csv.NewReader(f).Read()
+}
@@ -0,0 +1,77 @@
+package graphsplit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPlanPartitions(t *testing.T) {
+	root := t.TempDir()
+	sizes := map[string]int{"a/1": 100, "a/2": 300, "b/1": 200, "b/2": 50, "c": 350}
+	for name, size := range sizes {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	plan, err := PlanPartitions(root, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Partitions) != 3 {
+		t.Fatalf("expected 3 partitions, got %d", len(plan.Partitions))
+	}
+	var all []Finfo
+	for item := range GetFileListAsync([]string{root}) {
+		all = append(all, item)
+	}
+	seen := make(map[string]int)
+	for i, p := range plan.Partitions {
+		if p.Index != i || len(p.Files) == 0 {
+			t.Fatalf("unexpected partition %d: %+v", i, p)
+		}
+		files, err := p.filter(root, all)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, item := range files {
+			seen[item.Path]++
+		}
+	}
+	if len(seen) != len(sizes) {
+		t.Fatalf("expected %d files covered, got %v", len(sizes), seen)
+	}
+	for path, n := range seen {
+		if n != 1 {
+			t.Fatalf("%s is in %d partitions", path, n)
+		}
+	}
+
+	planPath := filepath.Join(t.TempDir(), "plan.json")
+	if err := plan.Save(planPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(planPath + TmpSuffix); !os.IsNotExist(err) {
+		t.Fatalf("expected no temporary plan left, got %v", err)
+	}
+	loaded, err := LoadPartitionPlan(planPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Root != plan.Root || len(loaded.Partitions) != len(plan.Partitions) || len(loaded.Partitions[0].Files) != len(plan.Partitions[0].Files) {
+		t.Fatalf("loaded plan %+v differs from %+v", loaded, plan)
+	}
+
+	if err := os.Remove(filepath.Join(root, "c")); err != nil {
+		t.Fatal(err)
+	}
+	last := plan.Partitions[len(plan.Partitions)-1]
+	if _, err := last.filter(root, all[:0]); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Fatalf("expected missing files error, got %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package graphsplit
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+
+	"github.com/filecoin-project/go-padreader"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+)
+
+// PieceFileInfo describes a piece file, a CAR zero padded up to its piece size.
+type PieceFileInfo struct {
+	PieceCid     cid.Cid
+	PaddedSize   abi.PaddedPieceSize
+	UnpaddedSize abi.UnpaddedPieceSize
+	FileSize     int64
+	// PayloadSize is the size of the CAR in front of the zero padding
+	PayloadSize int64
+	Roots       []cid.Cid
+	// CarErr is set when the payload is not a valid CAR
+	CarErr error
+}
+
+// InspectPiece computes the piece cid of the file at path and checks the CAR
+// in front of its zero padding, every block is verified against its cid.
+func InspectPiece(path string) (*PieceFileInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	info := &PieceFileInfo{FileSize: st.Size()}
+	info.PieceCid, info.UnpaddedSize, err = generatePieceCID(abi.RegisteredSealProof_StackedDrg32GiBV1_1, f, st.Size(), runtime.NumCPU())
+	if err != nil {
+		return nil, fmt.Errorf("computing commP failed: %w", err)
+	}
+	info.PaddedSize = info.UnpaddedSize.Padded()
+	if uint64(padreader.PaddedSize(uint64(st.Size()))) != uint64(st.Size()) {
+		log.Warnf("%s is not padded, it holds %d bytes of a %d bytes piece", path, st.Size(), info.UnpaddedSize)
+	}
+
+	info.Roots, info.PayloadSize, info.CarErr = scanPaddedCar(io.NewSectionReader(f, 0, st.Size()), st.Size())
+	return info, nil
+}
+
+// PieceVerification is the result of VerifyPiece.
+type PieceVerification struct {
+	*PieceFileInfo
+	Claimed cid.Cid
+	Match   bool
+	// Mismatch says how the piece differs from the claimed piece cid
+	Mismatch string
+}
+
+// VerifyPiece computes the piece cid of the file at path, a CAR or a CAR zero
+// padded to its piece size, and compares it with claimed, a legacy piece cid
+// or a piece cid v2 whose payload size is checked too.
+func VerifyPiece(path string, claimed cid.Cid) (*PieceVerification, error) {
+	want, payloadSize := claimed, uint64(0)
+	isV2 := claimed.Type() == cid.Raw
+	if isV2 {
+		var err error
+		if want, payloadSize, err = PieceCidV1(claimed); err != nil {
+			return nil, err
+		}
+	} else if claimed.Type() != cid.FilCommitmentUnsealed {
+		return nil, fmt.Errorf("%s is not a piece cid", claimed)
+	}
+	info, err := InspectPiece(path)
+	if err != nil {
+		return nil, err
+	}
+	v := &PieceVerification{PieceFileInfo: info, Claimed: claimed, Match: true}
+	// the payload of a padded file ends where the padding of its CAR starts
+	actualPayload := info.FileSize
+	if info.CarErr == nil {
+		actualPayload = info.PayloadSize
+	}
+	switch {
+	case !info.PieceCid.Equals(want):
+		v.Match, v.Mismatch = false, fmt.Sprintf("the piece cid of the file is %s", info.PieceCid)
+	case isV2 && padreader.PaddedSize(payloadSize).Padded() != info.PaddedSize:
+		v.Match, v.Mismatch = false, fmt.Sprintf("the piece size of the file is %d, the piece cid v2 encodes %d", info.PaddedSize, padreader.PaddedSize(payloadSize).Padded())
+	case isV2 && payloadSize != uint64(actualPayload):
+		v.Match, v.Mismatch = false, fmt.Sprintf("the payload of the file has %d bytes, the piece cid v2 encodes %d", actualPayload, payloadSize)
+	}
+	return v, nil
+}
+
+// scanPaddedCar reads a CARv1 until its end or until the zero padding, a
+// section length of zero, and returns its roots and size. fileSize is the
+// size of the data in r.
+func scanPaddedCar(r io.Reader, fileSize int64) ([]cid.Cid, int64, error) {
+	br := bufio.NewReader(r)
+	h, err := car.ReadHeader(br)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid car header: %w", err)
+	}
+	size, err := car.HeaderSize(h)
+	if err != nil {
+		return h.Roots, 0, err
+	}
+	payloadSize := int64(size)
+	for {
+		data, n, err := readCarSection(br, fileSize-payloadSize)
+		if err == io.EOF {
+			return h.Roots, payloadSize, nil
+		}
+		if err != nil {
+			return h.Roots, payloadSize, fmt.Errorf("block at offset %d: %w", payloadSize, err)
+		}
+		cn, c, err := cid.CidFromBytes(data)
+		if err != nil {
+			return h.Roots, payloadSize, fmt.Errorf("invalid cid at offset %d: %w", payloadSize, err)
+		}
+		sum, err := c.Prefix().Sum(data[cn:])
+		if err != nil {
+			return h.Roots, payloadSize, err
+		}
+		if !sum.Equals(c) {
+			return h.Roots, payloadSize, fmt.Errorf("block %s at offset %d does not match its cid", c, payloadSize)
+		}
+		payloadSize += n
+	}
+}
+
+// readCarSection reads the next length prefixed section of a CARv1 from br
+// and returns it and its size with the length prefix. The length is bounded
+// by remaining, the bytes left in the CAR, so a corrupt length can't allocate
+// more than the file holds. It returns io.EOF at the end of the CAR or at a
+// length of zero, where the zero padding of a piece starts.
+func readCarSection(br *bufio.Reader, remaining int64) ([]byte, int64, error) {
+	l, err := binary.ReadUvarint(br)
+	if err == io.EOF || (err == nil && l == 0) {
+		return nil, 0, io.EOF
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	prefix := int64(uvarintSize(l))
+	if l > uint64(remaining-prefix) || remaining < prefix {
+		return nil, 0, fmt.Errorf("section length %d exceeds the %d bytes left", l, remaining-prefix)
+	}
+	data := make([]byte, l)
+	if _, err := io.ReadFull(br, data); err != nil {
+		return nil, 0, fmt.Errorf("section is truncated: %w", err)
+	}
+	return data, prefix + int64(l), nil
+}
+
+func uvarintSize(v uint64) int {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return binary.PutUvarint(buf, v)
+}
@@ -0,0 +1,76 @@
+package graphsplit
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+)
+
+func TestVerifyPiece(t *testing.T) {
+	carPath := filepath.Join(t.TempDir(), "a.car")
+	writeTestCar(t, carPath, []blocks.Block{blocks.NewBlock(bytes.Repeat([]byte{1}, 3000))})
+	ret, err := CalcCommP(context.Background(), carPath, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, err := ret.PieceCidV2()
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(stage string) {
+		for _, claimed := range []cid.Cid{ret.Root, v2} {
+			v, err := VerifyPiece(carPath, claimed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !v.Match {
+				t.Fatalf("%s: expected %s to match: %s", stage, claimed, v.Mismatch)
+			}
+		}
+	}
+	check("car")
+	// pad the CAR to its piece size
+	if _, err := CalcCommP(context.Background(), carPath, false, true); err != nil {
+		t.Fatal(err)
+	}
+	check("padded car")
+
+	other, err := PieceCidV2(ret.Root, uint64(ret.PayloadSize)-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := VerifyPiece(carPath, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Match {
+		t.Fatal("expected a piece cid v2 of another payload size to mismatch")
+	}
+}
+
+func TestScanPaddedCarCorruptLength(t *testing.T) {
+	carDir := t.TempDir()
+	rows := chunkTestTree(t, writeTestTree(t, 10<<10), &ChunkParams{ExpectSliceSize: 64 << 10, CarDir: carDir})
+	data, err := os.ReadFile(filepath.Join(carDir, rows[0]["payload_cid"]+".car"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots, size, err := scanPaddedCar(bytes.NewReader(data), int64(len(data)))
+	if err != nil || len(roots) != 1 || size != int64(len(data)) {
+		t.Fatalf("unexpected scan of the CAR: %v, %d, %v", roots, size, err)
+	}
+
+	// a section claiming a terabyte must not be allocated
+	data = binary.AppendUvarint(data, 1<<40)
+	data = append(data, "rest"...)
+	if _, _, err := scanPaddedCar(bytes.NewReader(data), int64(len(data))); err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Fatalf("expected the section length to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,176 @@
+package graphsplit
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/filecoin-project/go-padreader"
+)
+
+// PieceReader reads a piece from its CAR file, the zero padding up to the
+// piece size is computed on the fly, so a provider only has to keep the CAR
+// to serve both the payload and the padded piece.
+type PieceReader struct {
+	f           *os.File
+	fileSize    int64
+	payloadSize int64
+	pieceSize   int64
+}
+
+// OpenPieceReader opens the CAR (or already padded piece) file at path.
+// payloadSize is the size of the CAR payload, 0 means the whole file is
+// the payload.
+func OpenPieceReader(path string, payloadSize int64) (*PieceReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if payloadSize <= 0 || payloadSize > st.Size() {
+		payloadSize = st.Size()
+	}
+	pieceSize := int64(padreader.PaddedSize(uint64(payloadSize)))
+	if st.Size() > pieceSize {
+		f.Close()
+		return nil, fmt.Errorf("file %s is larger than its piece size %d", path, pieceSize)
+	}
+	return &PieceReader{
+		f:           f,
+		fileSize:    st.Size(),
+		payloadSize: payloadSize,
+		pieceSize:   pieceSize,
+	}, nil
+}
+
+// ReadAt reads the padded piece, bytes beyond the file are zeros.
+func (pr *PieceReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	if off >= pr.pieceSize {
+		return 0, io.EOF
+	}
+	want := len(p)
+	if rest := pr.pieceSize - off; int64(want) > rest {
+		want = int(rest)
+	}
+	var n int
+	if off < pr.fileSize {
+		fromFile := want
+		if rest := pr.fileSize - off; int64(fromFile) > rest {
+			fromFile = int(rest)
+		}
+		m, err := pr.f.ReadAt(p[:fromFile], off)
+		n += m
+		if err != nil && err != io.EOF {
+			return n, err
+		}
+		if m < fromFile {
+			return n, io.ErrUnexpectedEOF
+		}
+	}
+	for i := n; i < want; i++ {
+		p[i] = 0
+	}
+	n = want
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Piece returns a reader over the padded piece.
+func (pr *PieceReader) Piece() *io.SectionReader {
+	return io.NewSectionReader(pr, 0, pr.pieceSize)
+}
+
+// Payload returns a reader over the CAR payload.
+func (pr *PieceReader) Payload() *io.SectionReader {
+	return io.NewSectionReader(pr, 0, pr.payloadSize)
+}
+
+func (pr *PieceReader) PieceSize() int64 {
+	return pr.pieceSize
+}
+
+func (pr *PieceReader) PayloadSize() int64 {
+	return pr.payloadSize
+}
+
+func (pr *PieceReader) Close() error {
+	return pr.f.Close()
+}
+
+// OpenPiece locates the CAR of pieceCid in carDir, or in the car dir recorded
+// in the manifest, either named after the piece cid or after the payload cid
+// recorded in the manifest.
+func OpenPiece(carDir, pieceCid string) (*PieceReader, error) {
+	rows, err := ReadManifest(carDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	row := ManifestRow{"piece_cid": pieceCid}
+	for _, r := range rows {
+		if r["piece_cid"] == pieceCid {
+			row = r
+			break
+		}
+	}
+	if path := locateCar(carDir, row); path != "" {
+		payloadSize, _ := strconv.ParseInt(row["payload_size"], 10, 64)
+		return OpenPieceReader(path, payloadSize)
+	}
+	return nil, fmt.Errorf("piece %s: %w", pieceCid, os.ErrNotExist)
+}
+
+// locateCar returns the path of the CAR file of a manifest row, empty if it
+// is not found in carDir, the car dir of the row or their shard directories.
+func locateCar(carDir string, row ManifestRow) string {
+	return locateCarExt(carDir, row, "")
+}
+
+// locateCarExt is locateCar for CAR files named with the extension ext, like
+// the one of their compression.
+func locateCarExt(carDir string, row ManifestRow, ext string) string {
+	dirs := []string{carDir}
+	if row["car_dir"] != "" && row["car_dir"] != carDir {
+		dirs = append(dirs, row["car_dir"])
+	}
+	var names []string
+	if carFile := row["car_file"]; carFile != "" {
+		if _, err := os.Stat(carFile); err == nil {
+			return carFile
+		}
+		names = append(names, filepath.Base(carFile))
+	}
+	if row["piece_cid"] != "" {
+		names = append(names, row["piece_cid"]+".car"+ext, row["piece_cid"]+ext)
+	}
+	if row["payload_cid"] != "" {
+		names = append(names, row["payload_cid"]+".car"+ext)
+	}
+	for _, dir := range dirs {
+		for _, name := range names {
+			path := filepath.Join(dir, name)
+			if _, err := os.Stat(path); err == nil {
+				return path
+			}
+		}
+	}
+	// CAR files of a sharded car dir are in its subdirectories
+	for _, dir := range dirs {
+		for _, name := range names {
+			if path := shardedPath(dir, name); path != "" {
+				return path
+			}
+		}
+	}
+	return ""
+}
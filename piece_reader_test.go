@@ -0,0 +1,45 @@
+package graphsplit
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPieceReader(t *testing.T) {
+	payload := bytes.Repeat([]byte{1}, 1000)
+	path := filepath.Join(t.TempDir(), "test.car")
+	if err := os.WriteFile(path, payload, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	pr, err := OpenPieceReader(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.Close()
+
+	if pr.PieceSize() != 1016 {
+		t.Fatalf("unexpected piece size %d", pr.PieceSize())
+	}
+	piece, err := io.ReadAll(pr.Piece())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := append(append([]byte{}, payload...), make([]byte, 16)...)
+	if !bytes.Equal(piece, expect) {
+		t.Fatal("unexpected piece content")
+	}
+
+	// a range crossing the end of the payload
+	buf := make([]byte, 10)
+	n, err := pr.ReadAt(buf, 995)
+	if err != nil || n != 10 {
+		t.Fatalf("unexpected read %d, %v", n, err)
+	}
+	if !bytes.Equal(buf, []byte{1, 1, 1, 1, 1, 0, 0, 0, 0, 0}) {
+		t.Fatalf("unexpected range content %v", buf)
+	}
+}
@@ -0,0 +1,129 @@
+package graphsplit
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+)
+
+// PieceServerOption configures NewPieceServer.
+type PieceServerOption func(*pieceServer)
+
+type pieceServer struct {
+	carDir string
+	token  string
+}
+
+// WithServeToken requires requests to carry the bearer token, e.g. sent by
+// boost deal --http-headers "Authorization=Bearer <token>".
+func WithServeToken(token string) PieceServerOption {
+	return func(ps *pieceServer) {
+		ps.token = token
+	}
+}
+
+// NewPieceServer returns a handler serving the pieces of the manifest of
+// carDir with range request support, e.g. for storage providers fetching
+// them with the HTTP transfer of boost:
+//
+//	GET /piece/<piece-cid>    the padded piece
+//	GET /payload/<piece-cid>  the CAR payload of the piece
+//
+// and the IPNI advertisements IPNICallback published, to the indexers:
+//
+//	GET /ipni/v1/ad/head   the signed head of the advertisement chain
+//	GET /ipni/v1/ad/<cid>  an advertisement or entry chunk
+func NewPieceServer(carDir string, opts ...PieceServerOption) http.Handler {
+	ps := &pieceServer{carDir: carDir}
+	for _, opt := range opts {
+		opt(ps)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/piece/", func(w http.ResponseWriter, r *http.Request) {
+		ps.serve(w, r, strings.TrimPrefix(r.URL.Path, "/piece/"), false)
+	})
+	mux.HandleFunc("/payload/", func(w http.ResponseWriter, r *http.Request) {
+		ps.serve(w, r, strings.TrimPrefix(r.URL.Path, "/payload/"), true)
+	})
+	// the indexers send no token, advertisements are public anyway
+	mux.HandleFunc("/ipni/v1/ad/", func(w http.ResponseWriter, r *http.Request) {
+		serveIPNI(w, r, filepath.Join(carDir, "ipni"), strings.TrimPrefix(r.URL.Path, "/ipni/v1/ad/"))
+	})
+	return mux
+}
+
+func (ps *pieceServer) serve(w http.ResponseWriter, r *http.Request, pieceCid string, payload bool) {
+	if ps.token != "" && r.Header.Get("Authorization") != "Bearer "+ps.token {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	servePiece(w, r, ps.carDir, pieceCid, payload)
+}
+
+func servePiece(w http.ResponseWriter, r *http.Request, carDir, pieceCid string, payload bool) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// only accept valid cids, which also keeps the path inside carDir
+	if _, err := cid.Decode(pieceCid); err != nil {
+		http.Error(w, "invalid piece cid", http.StatusBadRequest)
+		return
+	}
+	pr, err := OpenPiece(carDir, pieceCid)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			http.Error(w, "piece not found", http.StatusNotFound)
+			return
+		}
+		log.Errorf("open piece %s: %s", pieceCid, err)
+		http.Error(w, "failed to open piece", http.StatusInternalServerError)
+		return
+	}
+	defer pr.Close()
+
+	content := pr.Piece()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if payload {
+		content = pr.Payload()
+		w.Header().Set("Content-Type", "application/vnd.ipld.car")
+	}
+	http.ServeContent(w, r, "", time.Time{}, content)
+}
+
+func serveIPNI(w http.ResponseWriter, r *http.Request, dir, ask string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	path := filepath.Join(dir, "head")
+	if ask != "head" {
+		c, err := cid.Decode(ask)
+		if err != nil {
+			http.Error(w, "invalid request: not a cid", http.StatusBadRequest)
+			return
+		}
+		path = filepath.Join(dir, "blocks", c.String())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		switch {
+		case !errors.Is(err, os.ErrNotExist):
+			log.Errorf("read %s: %s", path, err)
+			http.Error(w, "failed to read", http.StatusInternalServerError)
+		case ask == "head":
+			// nothing published yet
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "cid not found", http.StatusNotFound)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data) //nolint:errcheck
+}
@@ -0,0 +1,74 @@
+package graphsplit
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+func TestPieceServer(t *testing.T) {
+	dir := t.TempDir()
+	mh, err := multihash.Sum([]byte("piece"), multihash.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pieceCid := cid.NewCidV1(cid.Raw, mh).String()
+	manifest := "payload_cid,filename,piece_cid,payload_size,piece_size\nbafy1,a.car," + pieceCid + ",100,128\n"
+	if err := os.WriteFile(filepath.Join(dir, ManifestFileName), []byte(manifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte{1}, 100)
+	if err := os.WriteFile(filepath.Join(dir, pieceCid+".car"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(NewPieceServer(dir, WithServeToken("secret")))
+	defer srv.Close()
+
+	get := func(path, rng string, auth bool) (*http.Response, []byte) {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rng != "" {
+			req.Header.Set("Range", rng)
+		}
+		if auth {
+			req.Header.Set("Authorization", "Bearer secret")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, body
+	}
+
+	if resp, _ := get("/piece/"+pieceCid, "", false); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without token, got %s", resp.Status)
+	}
+	resp, body := get("/piece/"+pieceCid, "", true)
+	if resp.StatusCode != http.StatusOK || len(body) != 127 || !bytes.Equal(body[:100], data) || !bytes.Equal(body[100:], make([]byte, 27)) {
+		t.Fatalf("unexpected piece %s, %d bytes", resp.Status, len(body))
+	}
+	resp, body = get("/piece/"+pieceCid, "bytes=96-103", true)
+	if resp.StatusCode != http.StatusPartialContent || !bytes.Equal(body, []byte{1, 1, 1, 1, 0, 0, 0, 0}) {
+		t.Fatalf("unexpected range %s %v", resp.Status, body)
+	}
+	if resp, body = get("/payload/"+pieceCid, "", true); len(body) != 100 {
+		t.Fatalf("unexpected payload %s, %d bytes", resp.Status, len(body))
+	}
+	if resp, _ = get("/piece/"+cid.NewCidV1(cid.DagProtobuf, mh).String(), "", true); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 of an unknown piece, got %s", resp.Status)
+	}
+}
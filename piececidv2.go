@@ -0,0 +1,72 @@
+package graphsplit
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+
+	commcid "github.com/filecoin-project/go-fil-commcid"
+	"github.com/filecoin-project/go-padreader"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+// multihashPadBinTree is the fr32-sha256-trunc254-padbintree multihash of
+// piece cids v2.
+const multihashPadBinTree = 0x1011
+
+// PieceCidV2 returns the piece cid v2 (FRC-0069) of the piece with commP
+// computed over payloadSize bytes. Unlike the legacy piece cid it encodes the
+// size: its multihash digest holds the zero padding of the payload, the
+// height of the piece tree and the root.
+func PieceCidV2(commP cid.Cid, payloadSize uint64) (cid.Cid, error) {
+	root, err := commcid.CIDToDataCommitmentV1(commP)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("invalid piece cid %s: %w", commP, err)
+	}
+	size := padreader.PaddedSize(payloadSize)
+	height := bits.TrailingZeros64(uint64(size.Padded()) / 32)
+	digest := binary.AppendUvarint(nil, uint64(size)-payloadSize)
+	digest = append(digest, byte(height))
+	digest = append(digest, root...)
+	mh, err := multihash.Encode(digest, multihashPadBinTree)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return cid.NewCidV1(cid.Raw, mh), nil
+}
+
+// PieceCidV2 returns the piece cid v2 of the CAR file the piece cid was
+// computed of.
+func (ret *CommPRet) PieceCidV2() (cid.Cid, error) {
+	return PieceCidV2(ret.Root, uint64(ret.PayloadSize))
+}
+
+// PieceCidV1 returns the legacy piece cid and the payload size encoded in the
+// piece cid v2 c.
+func PieceCidV1(c cid.Cid) (cid.Cid, uint64, error) {
+	mh, err := multihash.Decode(c.Hash())
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+	if c.Type() != cid.Raw || mh.Code != multihashPadBinTree {
+		return cid.Undef, 0, fmt.Errorf("%s is not a piece cid v2", c)
+	}
+	padding, n := binary.Uvarint(mh.Digest)
+	if n <= 0 || len(mh.Digest) != n+1+32 {
+		return cid.Undef, 0, fmt.Errorf("invalid digest of piece cid v2 %s", c)
+	}
+	height := int(mh.Digest[n])
+	if height < 2 || height > 58 {
+		return cid.Undef, 0, fmt.Errorf("invalid height %d of piece cid v2 %s", height, c)
+	}
+	unpadded := uint64(32<<height) / 128 * 127
+	if padding >= unpadded {
+		return cid.Undef, 0, fmt.Errorf("invalid padding %d of piece cid v2 %s", padding, c)
+	}
+	commP, err := commcid.DataCommitmentV1ToCID(mh.Digest[n+1:])
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+	return commP, unpadded - padding, nil
+}
@@ -0,0 +1,41 @@
+package graphsplit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	commcid "github.com/filecoin-project/go-fil-commcid"
+	"github.com/multiformats/go-multihash"
+)
+
+func TestPieceCidV2(t *testing.T) {
+	var root [32]byte
+	for i := range root[:31] {
+		root[i] = byte(i)
+	}
+	commP, err := commcid.DataCommitmentV1ToCID(root[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 1000 bytes pad to a piece of 1024 bytes, 1016 unpadded, 2^5 leaves
+	v2, err := PieceCidV2(commP, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(v2.String(), "bafkzcib") {
+		t.Fatalf("unexpected piece cid v2 %s", v2)
+	}
+	mh, err := multihash.Decode(v2.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mh.Code != multihashPadBinTree {
+		t.Fatalf("expected multihash 0x1011, got %#x", mh.Code)
+	}
+	padding, n := binary.Uvarint(mh.Digest)
+	if padding != 16 || mh.Digest[n] != 5 || !bytes.Equal(mh.Digest[n+1:], root[:]) {
+		t.Fatalf("unexpected digest %x", mh.Digest)
+	}
+}
@@ -0,0 +1,31 @@
+package graphsplit
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWritePieceFile(t *testing.T) {
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte{1}, 100)
+	buf := NewBuffer(0)
+	buf.Write(data)
+
+	o := newCallbackOptions([]CallbackOption{WithPieceFiles()})
+	piecePath := filepath.Join(dir, "baga.piece")
+	if err := o.writePieceFile(piecePath, filepath.Join(dir, "bafy.car"), buf, false, o.newArtifacts()); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(piecePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 127 || !bytes.Equal(got[:100], data) || !bytes.Equal(got[100:], make([]byte, 27)) {
+		t.Fatalf("unexpected piece file of %d bytes", len(got))
+	}
+	if !isPieceFile(piecePath) {
+		t.Fatal("piece file is not skipped by restore")
+	}
+}
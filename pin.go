@@ -0,0 +1,266 @@
+package graphsplit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The ways PinCallback keeps the payload of a piece on IPFS.
+const (
+	// PinImport pushes the CAR to /api/v0/dag/import of a kubo node, which
+	// pins the root
+	PinImport = "import"
+	// PinAdd pins the root with /api/v0/pin/add of a kubo node, which fetches
+	// the blocks from the network itself
+	PinAdd = "pin"
+	// PinService requests a pin of the root from an IPFS Pinning Service API
+	PinService = "service"
+)
+
+// PinConfig is the IPFS node or pinning service the payload roots of the
+// finished pieces are pinned to.
+type PinConfig struct {
+	// API is the RPC API of a kubo node, e.g. http://127.0.0.1:5001, or the
+	// endpoint of a pinning service, e.g. https://api.pinata.cloud/psa
+	API string
+	// Mode is import, pin or service, import if empty
+	Mode string
+	// Token is sent as a bearer token
+	Token string
+	// Origins are the multiaddrs the pinning service fetches the blocks
+	// from, e.g. of a node the CARs are imported into
+	Origins []string
+}
+
+// Pinner is a callback pinning the payload root of every finished piece, so
+// the content stays retrievable from IPFS while the deals of the piece seal.
+// The result is recorded in the pin_status column of the manifest, with the
+// request id of the pinning service in the pin_request_id column. A failed
+// pin is logged and does not stop chunking.
+type Pinner struct {
+	cfg    PinConfig
+	carDir string
+	client *http.Client
+}
+
+func PinCallback(carDir string, cfg PinConfig) (*Pinner, error) {
+	if cfg.API == "" {
+		return nil, fmt.Errorf("pin api is required")
+	}
+	if _, err := url.Parse(cfg.API); err != nil {
+		return nil, fmt.Errorf("invalid pin api %q: %v", cfg.API, err)
+	}
+	if cfg.Mode == "" {
+		cfg.Mode = PinImport
+	}
+	client := &http.Client{}
+	switch cfg.Mode {
+	case PinImport, PinAdd:
+		// importing a CAR or fetching the blocks of a piece takes a while
+	case PinService:
+		client.Timeout = time.Minute
+	default:
+		return nil, fmt.Errorf("unsupported pin mode %q, expect import, pin or service", cfg.Mode)
+	}
+	cfg.API = strings.TrimSuffix(cfg.API, "/")
+	return &Pinner{cfg: cfg, carDir: carDir, client: client}, nil
+}
+
+func (p *Pinner) OnSuccess(buf *Buffer, slice *GraphSlice) {
+	row, err := findManifestRow(p.carDir, slice.PayloadCid)
+	if err != nil {
+		log.Fatalf("failed to read manifest: %s", err)
+	}
+	start := time.Now()
+	cols := map[string]string{"pin_status": "pinned"}
+	switch p.cfg.Mode {
+	case PinImport:
+		var carFile string
+		if row != nil {
+			carFile = locateCarExt(p.carDir, row, compressionExt(row["compression"]))
+		}
+		if carFile == "" {
+			log.Errorf("no CAR file of %s to import", slice.PayloadCid)
+			return
+		}
+		err = p.importCar(carFile, slice.PayloadCid)
+	case PinAdd:
+		err = p.pinAdd(slice.PayloadCid)
+	case PinService:
+		meta := map[string]string{"graph": slice.Name}
+		if row != nil && row["piece_cid"] != "" {
+			meta["piece_cid"] = row["piece_cid"]
+		}
+		var status, requestID string
+		status, requestID, err = p.requestPin(slice.PayloadCid, meta)
+		cols = map[string]string{"pin_status": status, "pin_request_id": requestID}
+	}
+	if err != nil {
+		log.Errorf("failed to pin %s: %s", slice.PayloadCid, err)
+		return
+	}
+	log.Infow("payload pinned", slice.logFields("pin_status", cols["pin_status"], "duration", time.Since(start))...)
+	if row == nil {
+		return
+	}
+	if err := updateManifest(p.carDir, slice.PayloadCid, cols); err != nil {
+		log.Fatalf("failed to record the pin of %s: %s", slice.PayloadCid, err)
+	}
+}
+
+func (p *Pinner) OnError(err error) {
+	log.Fatal(err)
+}
+
+// importCar streams the CAR to dag/import, decompressed if it is compressed.
+func (p *Pinner) importCar(carFile, root string) error {
+	f, err := openCar(carFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close() //nolint:errcheck
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		part, err := mw.CreateFormFile("file", root+".car")
+		if err == nil {
+			_, err = io.Copy(part, f)
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	body, err := p.kubo("dag/import", url.Values{"pin-roots": {"true"}}, pr, mw.FormDataContentType())
+	if err != nil {
+		return err
+	}
+	// a line per root, then the stats
+	dec := json.NewDecoder(bytes.NewReader(body))
+	for dec.More() {
+		var line struct {
+			Root *struct {
+				Cid struct {
+					Root string `json:"/"`
+				}
+				PinErrorMsg string
+			}
+		}
+		if err := dec.Decode(&line); err != nil {
+			return fmt.Errorf("dag/import: %v", err)
+		}
+		if line.Root == nil || line.Root.Cid.Root != root {
+			continue
+		}
+		if line.Root.PinErrorMsg != "" {
+			return fmt.Errorf("dag/import: %s", line.Root.PinErrorMsg)
+		}
+		return nil
+	}
+	return fmt.Errorf("dag/import: root %s not pinned", root)
+}
+
+func (p *Pinner) pinAdd(root string) error {
+	body, err := p.kubo("pin/add", url.Values{"arg": {root}, "recursive": {"true"}}, nil, "")
+	if err != nil {
+		return err
+	}
+	var out struct {
+		Pins []string
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		return fmt.Errorf("pin/add: %v", err)
+	}
+	for _, pin := range out.Pins {
+		if pin == root {
+			return nil
+		}
+	}
+	return fmt.Errorf("pin/add: root %s not pinned", root)
+}
+
+// kubo calls a command of the kubo RPC API, which takes POST only.
+func (p *Pinner) kubo(command string, query url.Values, body io.Reader, contentType string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodPost, p.cfg.API+"/api/v0/"+command+"?"+query.Encode(), body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if p.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.cfg.Token)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var kuboErr struct {
+			Message string
+		}
+		if json.Unmarshal(data, &kuboErr) == nil && kuboErr.Message != "" {
+			return nil, fmt.Errorf("%s: %s", command, kuboErr.Message)
+		}
+		return nil, fmt.Errorf("%s: %s", command, resp.Status)
+	}
+	return data, nil
+}
+
+// requestPin adds a pin of root to the pinning service, it returns the
+// status of the pin, e.g. queued, and the request id to follow it with.
+func (p *Pinner) requestPin(root string, meta map[string]string) (string, string, error) {
+	pin := map[string]interface{}{"cid": root, "name": root, "meta": meta}
+	if len(p.cfg.Origins) > 0 {
+		pin["origins"] = p.cfg.Origins
+	}
+	body, err := json.Marshal(pin)
+	if err != nil {
+		return "", "", err
+	}
+	req, err := http.NewRequest(http.MethodPost, p.cfg.API+"/pins", bytes.NewReader(body))
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.cfg.Token)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Error struct {
+				Reason  string `json:"reason"`
+				Details string `json:"details"`
+			} `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&failure) == nil && failure.Error.Reason != "" {
+			return "", "", fmt.Errorf("pinning service: %s: %s", failure.Error.Reason, failure.Error.Details)
+		}
+		return "", "", fmt.Errorf("pinning service: %s", resp.Status)
+	}
+	var status struct {
+		RequestID string `json:"requestid"`
+		Status    string `json:"status"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return "", "", fmt.Errorf("pinning service: %v", err)
+	}
+	return status.Status, status.RequestID, nil
+}
@@ -0,0 +1,82 @@
+package graphsplit
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPin(t *testing.T) {
+	dir := t.TempDir()
+	manifest := "payload_cid,filename,piece_cid,payload_size,piece_size\nbafy1,a.car,baga1,100,256\n"
+	if err := os.WriteFile(filepath.Join(dir, ManifestFileName), []byte(manifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	car := bytes.Repeat([]byte{7}, 100)
+	if err := os.WriteFile(filepath.Join(dir, "baga1.car"), car, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var imported []byte
+	var pin map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v0/dag/import":
+			f, _, err := r.FormFile("file")
+			if err != nil || r.URL.Query().Get("pin-roots") != "true" {
+				http.Error(w, `{"Message":"bad import"}`, http.StatusInternalServerError)
+				return
+			}
+			imported, _ = io.ReadAll(f)
+			w.Write([]byte(`{"Root":{"Cid":{"/":"bafy1"},"PinErrorMsg":""}}` + "\n" + `{"Stats":{"BlockCount":1}}` + "\n"))
+		case "/psa/pins":
+			json.NewDecoder(r.Body).Decode(&pin) //nolint:errcheck
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(`{"requestid":"req-1","status":"queued","pin":{"cid":"bafy1"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	cb, err := NewRegisteredCallback("ipfs-pin", dir, map[string]string{"api": srv.URL, "token": "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb.OnSuccess(nil, &GraphSlice{PayloadCid: "bafy1"})
+	if !bytes.Equal(imported, car) {
+		t.Fatalf("expected the CAR to be imported, got %d bytes", len(imported))
+	}
+	row, err := findManifestRow(dir, "bafy1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row["pin_status"] != "pinned" {
+		t.Fatalf("expected pin_status pinned, got %q", row["pin_status"])
+	}
+
+	cb, err = NewRegisteredCallback("ipfs-pin", dir, map[string]string{"api": srv.URL + "/psa", "mode": "service", "token": "secret", "origins": "/ip4/1.2.3.4/tcp/4001/p2p/12D3KooW"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb.OnSuccess(nil, &GraphSlice{Name: "g", PayloadCid: "bafy1"})
+	if pin["cid"] != "bafy1" || pin["meta"].(map[string]interface{})["piece_cid"] != "baga1" || len(pin["origins"].([]interface{})) != 1 {
+		t.Fatalf("unexpected pin request %v", pin)
+	}
+	if row, _ = findManifestRow(dir, "bafy1"); row["pin_status"] != "queued" || row["pin_request_id"] != "req-1" {
+		t.Fatalf("unexpected pin columns %v", row)
+	}
+
+	if _, err := NewRegisteredCallback("ipfs-pin", dir, map[string]string{"api": srv.URL, "mode": "mfs"}); err == nil {
+		t.Fatal("expected an error for an unknown mode")
+	}
+}
@@ -0,0 +1,163 @@
+package graphsplit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	files "github.com/ipfs/go-libipfs/files"
+	"github.com/ipfs/go-unixfs"
+)
+
+// partPlacement is the range of the merged file a part of a split file is
+// written to.
+type partPlacement struct {
+	target string
+	offset int64
+	size   int64
+}
+
+// write writes the part nd at its offset of the merged file, several parts
+// of the same file are written concurrently.
+func (pl partPlacement) write(nd files.File, limiter *RateLimiter) error {
+	f, err := os.OpenFile(pl.target, os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	n, err := io.Copy(limiter.Writer(io.NewOffsetWriter(f, pl.offset)), nd)
+	if err != nil {
+		return fmt.Errorf("%s: %w", pl.target, err)
+	}
+	if n != pl.size {
+		return fmt.Errorf("%s: part at offset %d has %d bytes, expected %d", pl.target, pl.offset, n, pl.size)
+	}
+	return nil
+}
+
+// planParts reads the sizes of the parts of split files held by cars, only
+// directory blocks and the root blocks of files are read, and creates the
+// merged files below outputDir with their final size. The returned
+// placements are keyed by the path the part would be restored to, so the
+// parts are written in place instead of being merged by Merge afterwards.
+// Compressed CAR files and stitch manifests would have to be read twice, they
+// are left to Merge like files with missing parts.
+func planParts(ctx context.Context, cars []string, outputDir string, o restoreOptions) (map[string]partPlacement, error) {
+	for _, p := range cars {
+		if isZstdFile(p) || isStitchManifest(p) {
+			return nil, nil
+		}
+	}
+	type part struct {
+		index int
+		fpath string
+		size  int64
+	}
+	split := make(map[string][]part)
+	for _, p := range cars {
+		bs := bstore.NewBlockstore(dss.MutexWrap(datastore.NewMapDatastore()))
+		cb, err := openCarBlocks(ctx, p, bs, o.readLimiter)
+		if err != nil {
+			// the offsets of the parts of an unreadable CAR file are unknown,
+			// restoring it fails and reports the error
+			log.Warnf("merge split files afterwards, %s can not be read: %s", p, err)
+			return nil, nil
+		}
+		ps := &pathSelector{ctx: ctx, bs: bs, get: cb.get}
+		found := make(map[string]cid.Cid)
+		err = ps.splitParts(cb.root, "", found)
+		for rel, c := range found {
+			if err != nil {
+				break
+			}
+			var size uint64
+			if size, err = ps.fileSize(c); err != nil {
+				break
+			}
+			fpath := filepath.Join(o.layoutDir(outputDir, cb.root), filepath.FromSlash(rel))
+			index, _ := strconv.Atoi(fpath[len(fpath)-8:])
+			target := fpath[:len(fpath)-9]
+			split[target] = append(split[target], part{index: index, fpath: fpath, size: int64(size)})
+		}
+		cb.close() //nolint:errcheck
+		if err != nil {
+			return nil, fmt.Errorf("failed to plan %s: %w", p, err)
+		}
+	}
+
+	placed := make(map[string]partPlacement)
+	for target, parts := range split {
+		sort.Slice(parts, func(i, j int) bool { return parts[i].index < parts[j].index })
+		var offset int64
+		complete := true
+		for i, pt := range parts {
+			if pt.index != i {
+				complete = false
+				break
+			}
+			offset += pt.size
+		}
+		if !complete {
+			log.Warnf("parts of %s are missing, they are merged afterwards", target)
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0o777); err != nil {
+			return nil, err
+		}
+		f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		// keeps what a resumed restore has written before
+		err = f.Truncate(offset)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		offset = 0
+		for _, pt := range parts {
+			placed[pt.fpath] = partPlacement{target: target, offset: offset, size: pt.size}
+			offset += pt.size
+		}
+	}
+	return placed, nil
+}
+
+// splitParts adds the parts of split files of the DAG below c to found by
+// their path below prefix. Raw blocks are single block files, they are not
+// read.
+func (ps *pathSelector) splitParts(c cid.Cid, prefix string, found map[string]cid.Cid) error {
+	if splitPartName.MatchString(prefix) {
+		found[prefix] = c
+		return nil
+	}
+	if c.Prefix().Codec != cid.DagProtobuf {
+		return nil
+	}
+	nd, _, err := ps.node(c)
+	if err != nil {
+		return err
+	}
+	fsn, err := unixfs.FSNodeFromBytes(nd.Data())
+	if err != nil {
+		return err
+	}
+	if !fsn.IsDir() {
+		return nil
+	}
+	for _, ln := range nd.Links() {
+		if err := ps.splitParts(ln.Cid, path.Join(prefix, ln.Name), found); err != nil {
+			return err
+		}
+	}
+	return nil
+}
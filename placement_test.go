@@ -0,0 +1,41 @@
+package graphsplit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	dag "github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+)
+
+func TestRestorePlacesParts(t *testing.T) {
+	dir := t.TempDir()
+	carDir := filepath.Join(dir, "cars")
+	os.Mkdir(carDir, 0o755)
+	for i, data := range []string{"first part, ", "second part, ", "third part"} {
+		part := dag.NodeWithData(unixfs.FilePBData([]byte(data), uint64(len(data))))
+		sub := unixfs.EmptyDirNode()
+		sub.AddNodeLink(fmt.Sprintf("big.bin.%08d", i), part)
+		root := unixfs.EmptyDirNode()
+		root.AddNodeLink("sub", sub)
+		writeTestCar(t, filepath.Join(carDir, fmt.Sprintf("%d.car", i)), []blocks.Block{root, sub, part})
+	}
+
+	out := filepath.Join(dir, "out")
+	if err := carTo(carDir, out, 3); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(out, "sub", "big.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "first part, second part, third part" {
+		t.Fatalf("unexpected content %q", got)
+	}
+	if parts, _ := filepath.Glob(filepath.Join(out, "sub", "big.bin.*")); len(parts) != 0 {
+		t.Fatalf("parts should be written in place, found %v", parts)
+	}
+}
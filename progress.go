@@ -0,0 +1,159 @@
+package graphsplit
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Progress is a snapshot of a chunk or restore run. A restore counts CAR
+// files as slices.
+type Progress struct {
+	// Op is chunk or restore
+	Op         string
+	BytesRead  int64
+	BytesTotal int64
+	FilesDone  int
+	FilesTotal int
+	// Slice is the slice being built, or the CAR file being restored
+	Slice       string
+	SlicesDone  int
+	SlicesTotal int
+	Elapsed     time.Duration
+	// ETA is 0 until it can be estimated from the bytes read so far
+	ETA time.Duration
+	// Done is set in the last report of a run
+	Done bool
+}
+
+// ProgressReporter receives the progress of a run about every second, and
+// whenever a slice is done. Reports are not concurrent.
+type ProgressReporter interface {
+	Report(p Progress)
+}
+
+// ProgressFunc adapts a function to a ProgressReporter.
+type ProgressFunc func(p Progress)
+
+func (f ProgressFunc) Report(p Progress) { f(p) }
+
+// WithRestoreProgress reports the progress of a restore to r.
+func WithRestoreProgress(r ProgressReporter) RestoreOption {
+	return func(o *restoreOptions) {
+		o.progress = newProgressTracker(r, "restore")
+	}
+}
+
+const progressInterval = time.Second
+
+// progressTracker counts the progress of a run and reports it, a nil tracker
+// counts nothing.
+type progressTracker struct {
+	reporter ProgressReporter
+	mu       sync.Mutex
+	p        Progress
+	start    time.Time
+	last     time.Time
+}
+
+func newProgressTracker(r ProgressReporter, op string) *progressTracker {
+	if r == nil {
+		return nil
+	}
+	return &progressTracker{reporter: r, p: Progress{Op: op}, start: time.Now()}
+}
+
+func (pt *progressTracker) setTotals(bytes int64, files, slices int) {
+	if pt == nil {
+		return
+	}
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	pt.p.BytesTotal, pt.p.FilesTotal, pt.p.SlicesTotal = bytes, files, slices
+	pt.report(true)
+}
+
+func (pt *progressTracker) addBytes(n int64) {
+	if pt == nil || n == 0 {
+		return
+	}
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	pt.p.BytesRead += n
+	pt.report(false)
+}
+
+func (pt *progressTracker) fileDone() {
+	if pt == nil {
+		return
+	}
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	pt.p.FilesDone++
+	pt.report(false)
+}
+
+func (pt *progressTracker) startSlice(name string) {
+	if pt == nil {
+		return
+	}
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	pt.p.Slice = name
+	pt.report(false)
+}
+
+func (pt *progressTracker) sliceDone() {
+	if pt == nil {
+		return
+	}
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	pt.p.SlicesDone++
+	pt.report(true)
+}
+
+func (pt *progressTracker) finish() {
+	if pt == nil {
+		return
+	}
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	pt.p.Done = true
+	pt.report(true)
+}
+
+// report hands the progress to the reporter if force is set or the last
+// report is older than progressInterval.
+func (pt *progressTracker) report(force bool) {
+	now := time.Now()
+	if !force && now.Sub(pt.last) < progressInterval {
+		return
+	}
+	pt.last = now
+	pt.p.Elapsed = now.Sub(pt.start)
+	pt.p.ETA = 0
+	if pt.p.BytesRead > 0 && pt.p.BytesTotal > pt.p.BytesRead && !pt.p.Done {
+		pt.p.ETA = time.Duration(float64(pt.p.Elapsed) * float64(pt.p.BytesTotal-pt.p.BytesRead) / float64(pt.p.BytesRead))
+	}
+	pt.reporter.Report(pt.p)
+}
+
+// Reader counts the bytes read from r.
+func (pt *progressTracker) Reader(r io.Reader) io.Reader {
+	if pt == nil {
+		return r
+	}
+	return &progressReader{r: r, pt: pt}
+}
+
+type progressReader struct {
+	r  io.Reader
+	pt *progressTracker
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	pr.pt.addBytes(int64(n))
+	return n, err
+}
@@ -0,0 +1,35 @@
+package graphsplit
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestProgressTracker(t *testing.T) {
+	var reports []Progress
+	pt := newProgressTracker(ProgressFunc(func(p Progress) { reports = append(reports, p) }), "chunk")
+	pt.setTotals(200, 2, 1)
+	pt.startSlice("graph.car")
+	if _, err := io.Copy(io.Discard, pt.Reader(bytes.NewReader(make([]byte, 100)))); err != nil {
+		t.Fatal(err)
+	}
+	pt.fileDone()
+	pt.sliceDone()
+	pt.finish()
+
+	last := reports[len(reports)-1]
+	if !last.Done || last.Op != "chunk" || last.BytesRead != 100 || last.BytesTotal != 200 ||
+		last.FilesDone != 1 || last.SlicesDone != 1 || last.Slice != "graph.car" || last.ETA != 0 {
+		t.Fatalf("unexpected progress %+v", last)
+	}
+	if mid := reports[len(reports)-2]; mid.Done || mid.ETA <= 0 {
+		t.Fatalf("expected an ETA before the run is done, got %+v", mid)
+	}
+
+	var nilTracker *progressTracker
+	nilTracker.fileDone()
+	if r := nilTracker.Reader(bytes.NewReader(nil)); r == nil {
+		t.Fatal("nil tracker should return the reader")
+	}
+}
@@ -0,0 +1,152 @@
+package graphsplit
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// provenanceHeader are the columns of files.csv, one row for every range of
+// a source file held by a piece.
+var provenanceHeader = []string{
+	"path", "file_size", "offset", "size", "file_cid", "graph_name", "payload_cid", "piece_cid", "car_file",
+}
+
+// WithFileProvenance records every source file, or the range of it a piece
+// holds if it is split, and the piece it landed in to files.csv in the car
+// dir, or to files.ndjson with ManifestNDJSON.
+func WithFileProvenance(format ManifestFormat) CallbackOption {
+	return func(o *callbackOptions) {
+		o.provenance = format
+	}
+}
+
+func provenanceFileName(format ManifestFormat) string {
+	if format == ManifestNDJSON {
+		return "files.ndjson"
+	}
+	return "files.csv"
+}
+
+// recordProvenance appends the rows of the files of slice, which is in the
+// CAR file at carFilePath, to the provenance file of carDir. A retried
+// slice whose rows are present already is not recorded again.
+func (o *callbackOptions) recordProvenance(carDir, carFilePath string, row map[string]string, slice *GraphSlice) error {
+	if o.provenance == "" {
+		return nil
+	}
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	f, err := os.OpenFile(filepath.Join(carDir, provenanceFileName(o.provenance)), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	data, err := readCompleteLines(f)
+	if err != nil {
+		return err
+	}
+
+	var existing []ManifestRow
+	if o.provenance == ManifestNDJSON {
+		_, existing, err = parseJSONManifest(data, false)
+	} else {
+		var header []string
+		var records [][]string
+		header, records, err = parseManifestRecords(data)
+		for _, record := range records {
+			existing = append(existing, recordRow(header, record))
+		}
+	}
+	if err != nil {
+		return err
+	}
+	for _, r := range existing {
+		if r["payload_cid"] == slice.PayloadCid && r["piece_cid"] == row["piece_cid"] {
+			return nil
+		}
+	}
+
+	var out []byte
+	if o.provenance == ManifestNDJSON {
+		for _, file := range slice.Files {
+			out = append(out, marshalManifestRow(provenanceHeader, provenanceRow(file, carFilePath, row, slice))...)
+			out = append(out, '\n')
+		}
+	} else {
+		var records [][]string
+		if len(data) == 0 {
+			records = append(records, provenanceHeader)
+		}
+		for _, file := range slice.Files {
+			pr := provenanceRow(file, carFilePath, row, slice)
+			record := make([]string, 0, len(provenanceHeader))
+			for _, col := range provenanceHeader {
+				record = append(record, pr[col])
+			}
+			records = append(records, record)
+		}
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		w.UseCRLF = true
+		if err := w.WriteAll(records); err != nil {
+			return err
+		}
+		out = buf.Bytes()
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+	if _, err := f.Write(out); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func provenanceRow(file SliceFile, carFilePath string, row map[string]string, slice *GraphSlice) map[string]string {
+	return map[string]string{
+		"path":        file.Path,
+		"file_size":   strconv.FormatInt(file.FileSize, 10),
+		"offset":      strconv.FormatInt(file.Offset, 10),
+		"size":        strconv.FormatInt(file.Size, 10),
+		"file_cid":    file.Cid,
+		"graph_name":  slice.Name,
+		"payload_cid": slice.PayloadCid,
+		"piece_cid":   row["piece_cid"],
+		"car_file":    carFilePath,
+	}
+}
+
+// ReadFileProvenance returns the rows of files.csv or files.ndjson in carDir
+// of the file at path, every piece holding a range of it, or all rows if path
+// is empty.
+func ReadFileProvenance(carDir, path string) ([]ManifestRow, error) {
+	var (
+		rows []ManifestRow
+		err  error
+	)
+	if _, statErr := os.Stat(filepath.Join(carDir, provenanceFileName(ManifestNDJSON))); statErr == nil {
+		_, rows, err = readJSONManifest(filepath.Join(carDir, provenanceFileName(ManifestNDJSON)))
+	} else {
+		var f *os.File
+		f, err = os.Open(filepath.Join(carDir, provenanceFileName(ManifestCSV)))
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		rows, err = parseManifest(f)
+	}
+	if err != nil || path == "" {
+		return rows, err
+	}
+	var matched []ManifestRow
+	for _, row := range rows {
+		if row["path"] == path {
+			matched = append(matched, row)
+		}
+	}
+	return matched, nil
+}
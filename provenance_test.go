@@ -0,0 +1,46 @@
+package graphsplit
+
+import (
+	"testing"
+)
+
+func TestFileProvenance(t *testing.T) {
+	for _, format := range []ManifestFormat{ManifestCSV, ManifestNDJSON} {
+		dir := t.TempDir()
+		o := newCallbackOptions([]CallbackOption{WithFileProvenance(format)})
+		slice := &GraphSlice{
+			Name:       "graph-1",
+			PayloadCid: "bafy1",
+			Files: []SliceFile{
+				{Path: "data/a", FileSize: 10, Size: 10, Cid: "bafya"},
+				{Path: "data/big", FileSize: 300, Size: 100, Cid: "bafybig1"},
+			},
+		}
+		row := map[string]string{"piece_cid": "baga1"}
+		// a retried slice is recorded once
+		for i := 0; i < 2; i++ {
+			if err := o.recordProvenance(dir, "bafy1.car", row, slice); err != nil {
+				t.Fatal(err)
+			}
+		}
+		slice = &GraphSlice{Name: "graph-2", PayloadCid: "bafy2", Files: []SliceFile{{Path: "data/big", FileSize: 300, Offset: 100, Size: 200, Cid: "bafybig2"}}}
+		if err := o.recordProvenance(dir, "bafy2.car", map[string]string{"piece_cid": "baga2"}, slice); err != nil {
+			t.Fatal(err)
+		}
+
+		rows, err := ReadFileProvenance(dir, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != 3 {
+			t.Fatalf("%s: unexpected rows %v", format, rows)
+		}
+		rows, err = ReadFileProvenance(dir, "data/big")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != 2 || rows[0]["piece_cid"] != "baga1" || rows[1]["offset"] != "100" || rows[1]["car_file"] != "bafy2.car" {
+			t.Fatalf("%s: unexpected rows %v", format, rows)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package graphsplit
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// RateLimiter throttles IO to a number of bytes per second with a token
+// bucket holding up to one second worth of bytes. It is safe for concurrent
+// use, so one limiter can be shared by all workers of a run. A nil limiter
+// does not limit anything.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a limiter for bytesPerSec, or nil if bytesPerSec is
+// not positive.
+func NewRateLimiter(bytesPerSec int64) *RateLimiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	return &RateLimiter{
+		rate:   float64(bytesPerSec),
+		tokens: float64(bytesPerSec),
+		last:   time.Now(),
+	}
+}
+
+// WaitN blocks until n bytes are allowed to pass.
+func (l *RateLimiter) WaitN(n int) {
+	if l == nil || n <= 0 {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// Reader wraps r so that reads are throttled by the limiter.
+func (l *RateLimiter) Reader(r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &limitedReader{r: r, l: l}
+}
+
+// Writer wraps w so that writes are throttled by the limiter.
+func (l *RateLimiter) Writer(w io.Writer) io.Writer {
+	if l == nil {
+		return w
+	}
+	return &limitedWriter{w: w, l: l}
+}
+
+type limitedReader struct {
+	r io.Reader
+	l *RateLimiter
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	lr.l.WaitN(n)
+	return n, err
+}
+
+type limitedWriter struct {
+	w io.Writer
+	l *RateLimiter
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	// write in pieces of at most one second worth of bytes, so a big write
+	// does not turn into a burst followed by a long pause
+	chunk := int(lw.l.rate)
+	if chunk < 1 {
+		chunk = 1
+	}
+	var written int
+	for len(p) > 0 {
+		n := len(p)
+		if n > chunk {
+			n = chunk
+		}
+		lw.l.WaitN(n)
+		m, err := lw.w.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,67 @@
+package graphsplit
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	if NewRateLimiter(0) != nil {
+		t.Fatal("expected no limiter without a rate")
+	}
+	var nilLimiter *RateLimiter
+	r := bytes.NewReader(nil)
+	if nilLimiter.Reader(r) != io.Reader(r) {
+		t.Fatal("expected a nil limiter to pass the reader through")
+	}
+
+	data := bytes.Repeat([]byte("graphsplit"), 30<<10)
+	l := NewRateLimiter(200 << 10)
+	start := time.Now()
+	read, err := io.ReadAll(l.Reader(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first second worth of bytes passes at once, the remaining 100KiB
+	// take half a second
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond || elapsed > 2*time.Second {
+		t.Fatalf("expected about 500ms to read 300KiB at 200KiB/s, took %s", elapsed)
+	}
+	if !bytes.Equal(read, data) {
+		t.Fatal("read data differs")
+	}
+
+	var out bytes.Buffer
+	l = NewRateLimiter(200 << 10)
+	start = time.Now()
+	n, err := l.Writer(&out).Write(data)
+	if err != nil || n != len(data) {
+		t.Fatalf("expected %d bytes written, got %d, %v", len(data), n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond || elapsed > 2*time.Second {
+		t.Fatalf("expected about 500ms to write 300KiB at 200KiB/s, took %s", elapsed)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatal("written data differs")
+	}
+}
+
+func TestChunkWithReadAndWriteRate(t *testing.T) {
+	dir := writeTestTree(t, 100<<10, 100<<10, 100<<10)
+	carDir := t.TempDir()
+	start := time.Now()
+	rows := chunkTestTree(t, dir, &ChunkParams{
+		ExpectSliceSize: 1 << 20,
+		CarDir:          carDir,
+		Cb:              CSVCallback(carDir, WithWriteRate(1<<20)),
+		ReadRate:        200 << 10,
+	})
+	if len(rows) != 1 {
+		t.Fatalf("expected a single slice, got %d", len(rows))
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Fatalf("expected reading 300KiB at 200KiB/s to take about 500ms, took %s", elapsed)
+	}
+}
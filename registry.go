@@ -0,0 +1,163 @@
+package graphsplit
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CallbackFactory creates a callback of the CAR files in carDir from the
+// options of its config entry.
+type CallbackFactory func(carDir string, options map[string]string) (GraphBuildCallback, error)
+
+var (
+	callbacksMu sync.RWMutex
+	callbacks   = make(map[string]CallbackFactory)
+)
+
+// RegisterCallback makes a callback available by name to the Callbacks of
+// the config, e.g. from the init function of a package adding an uploader.
+// It panics if the name is registered twice.
+func RegisterCallback(name string, factory CallbackFactory) {
+	callbacksMu.Lock()
+	defer callbacksMu.Unlock()
+	if factory == nil {
+		panic("graphsplit: RegisterCallback factory is nil")
+	}
+	if _, dup := callbacks[name]; dup {
+		panic("graphsplit: RegisterCallback called twice for " + name)
+	}
+	callbacks[name] = factory
+}
+
+// RegisteredCallbacks returns the sorted names of the registered callbacks.
+func RegisteredCallbacks() []string {
+	callbacksMu.RLock()
+	defer callbacksMu.RUnlock()
+	names := make([]string, 0, len(callbacks))
+	for name := range callbacks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewRegisteredCallback creates the callback registered as name. Callbacks
+// created this way follow the callback writing the CAR file and the
+// manifest, CloseCallback has to be called once chunking is done.
+func NewRegisteredCallback(name, carDir string, options map[string]string) (GraphBuildCallback, error) {
+	callbacksMu.RLock()
+	factory, ok := callbacks[name]
+	callbacksMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown callback %q, registered: %s", name, strings.Join(RegisteredCallbacks(), ", "))
+	}
+	cb, err := factory(carDir, options)
+	if err != nil {
+		return nil, fmt.Errorf("callback %s: %w", name, err)
+	}
+	return cb, nil
+}
+
+// CloseCallback waits for the background work of cb and releases it, if it
+// has a Wait or Close method.
+func CloseCallback(cb GraphBuildCallback) error {
+	if w, ok := cb.(interface{ Wait() }); ok {
+		w.Wait()
+	}
+	if c, ok := cb.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func init() {
+	RegisterCallback("http-upload", func(carDir string, options map[string]string) (GraphBuildCallback, error) {
+		cfg := HTTPUploadConfig{
+			URL:     options["url"],
+			Method:  options["method"],
+			Token:   options["token"],
+			Headers: make(map[string]string),
+		}
+		var err error
+		if cfg.Concurrency, err = intOption(options, "concurrency"); err != nil {
+			return nil, err
+		}
+		if cfg.Retries, err = intOption(options, "retries"); err != nil {
+			return nil, err
+		}
+		if v := options["padded"]; v != "" {
+			if cfg.Padded, err = strconv.ParseBool(v); err != nil {
+				return nil, fmt.Errorf("invalid padded %q", v)
+			}
+		}
+		for k, v := range options {
+			if name, ok := strings.CutPrefix(k, "header."); ok {
+				cfg.Headers[name] = v
+			}
+		}
+		return HTTPUploadCallback(carDir, cfg)
+	})
+	RegisterCallback("droplet", func(carDir string, options map[string]string) (GraphBuildCallback, error) {
+		cfg := DropletConfig{URL: options["url"], Token: options["token"], CarDir: options["car-dir"]}
+		if v := options["skip-commp"]; v != "" {
+			var err error
+			if cfg.SkipCommP, err = strconv.ParseBool(v); err != nil {
+				return nil, fmt.Errorf("invalid skip-commp %q", v)
+			}
+		}
+		return DropletCallback(carDir, cfg)
+	})
+	RegisterCallback("ipfs-pin", func(carDir string, options map[string]string) (GraphBuildCallback, error) {
+		cfg := PinConfig{API: options["api"], Mode: options["mode"], Token: options["token"]}
+		if v := options["origins"]; v != "" {
+			cfg.Origins = strings.Split(v, ",")
+		}
+		return PinCallback(carDir, cfg)
+	})
+	RegisterCallback("post-piece-hook", func(carDir string, options map[string]string) (GraphBuildCallback, error) {
+		if options["command"] == "" {
+			return nil, fmt.Errorf("command is required")
+		}
+		return &hookCallback{carDir: carDir, command: options["command"]}, nil
+	})
+}
+
+func intOption(options map[string]string, name string) (int, error) {
+	v := options[name]
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return n, nil
+}
+
+// hookCallback runs a post piece hook like WithPostPieceHook, for callbacks
+// configured after the manifest writer.
+type hookCallback struct {
+	carDir  string
+	command string
+}
+
+func (hc *hookCallback) OnSuccess(buf *Buffer, slice *GraphSlice) {
+	row, err := findManifestRow(hc.carDir, slice.PayloadCid)
+	if err != nil {
+		log.Fatalf("failed to read manifest: %s", err)
+	}
+	runPieceHook(hc.command, PieceHookInfo{
+		Car:        locateCar(hc.carDir, row),
+		PieceCid:   row["piece_cid"],
+		PayloadCid: slice.PayloadCid,
+		PieceSize:  row["piece_size"],
+	})
+}
+
+func (hc *hookCallback) OnError(err error) {
+	log.Fatal(err)
+}
@@ -0,0 +1,49 @@
+package graphsplit
+
+import (
+	"testing"
+)
+
+type countingCallback struct {
+	successes int
+	closed    bool
+}
+
+func (cc *countingCallback) OnSuccess(buf *Buffer, slice *GraphSlice) { cc.successes++ }
+func (cc *countingCallback) OnError(err error)                        {}
+func (cc *countingCallback) Close() error                             { cc.closed = true; return nil }
+
+func TestRegisterCallback(t *testing.T) {
+	var gotDir, gotOption string
+	RegisterCallback("test-counting", func(carDir string, options map[string]string) (GraphBuildCallback, error) {
+		gotDir, gotOption = carDir, options["name"]
+		return &countingCallback{}, nil
+	})
+	cb, err := NewRegisteredCallback("test-counting", "/cars", map[string]string{"name": "value"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotDir != "/cars" || gotOption != "value" {
+		t.Fatalf("unexpected factory arguments %s %s", gotDir, gotOption)
+	}
+	cb.OnSuccess(NewBuffer(0), &GraphSlice{})
+	if err := CloseCallback(cb); err != nil {
+		t.Fatal(err)
+	}
+	if c := cb.(*countingCallback); c.successes != 1 || !c.closed {
+		t.Fatalf("unexpected callback state %+v", c)
+	}
+
+	if _, err := NewRegisteredCallback("no-such-callback", "/cars", nil); err == nil {
+		t.Fatal("expected an error for an unknown callback")
+	}
+	if _, err := NewRegisteredCallback("http-upload", "/cars", map[string]string{}); err == nil {
+		t.Fatal("expected an error for an http upload without url")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic registering a name twice")
+		}
+	}()
+	RegisterCallback("test-counting", func(string, map[string]string) (GraphBuildCallback, error) { return nil, nil })
+}
@@ -0,0 +1,265 @@
+package graphsplit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// RemoteCars is a set of CAR files read from a Source, like an S3 bucket or a
+// directory URL. CAR files are fetched with ranged requests when restore gets
+// to them and are kept in a local cache directory, an interrupted fetch
+// continues where it stopped.
+type RemoteCars struct {
+	src Source
+	dir string
+	// files are the listed files by their path in dir
+	files map[string]Finfo
+}
+
+// NewRemoteCars lists the files of src and fetches the metadata files, like
+// manifest.csv and stitch manifests, to cacheDir.
+func NewRemoteCars(ctx context.Context, src Source, cacheDir string) (*RemoteCars, error) {
+	list, err := src.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", src.Root(), err)
+	}
+	rc := &RemoteCars{src: src, dir: cacheDir, files: make(map[string]Finfo)}
+	for _, item := range list {
+		rel := strings.TrimPrefix(item.Path, src.Root()+"/")
+		rc.files[filepath.Join(cacheDir, filepath.FromSlash(rel))] = item
+	}
+	for local := range rc.files {
+		if isCarDirMetadata(local) || isChecksumSidecar(local) {
+			if err := rc.fetchFile(local); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return rc, nil
+}
+
+// Dir is the cache directory, which takes the place of the car path.
+func (rc *RemoteCars) Dir() string {
+	return rc.dir
+}
+
+// cars returns the CAR files and stitch manifests to restore by their path in
+// the cache directory.
+func (rc *RemoteCars) cars() ([]string, error) {
+	paths := make([]string, 0, len(rc.files))
+	for local := range rc.files {
+		paths = append(paths, local)
+	}
+	return selectRestorable(rc.dir, paths)
+}
+
+// fetch fetches the CAR file at local, or the parts of a stitch manifest, to
+// the cache directory unless they are cached already.
+func (rc *RemoteCars) fetch(ctx context.Context, local string) error {
+	if err := rc.fetchFile(local); err != nil {
+		return err
+	}
+	if !isStitchManifest(local) {
+		return nil
+	}
+	sm, err := ReadStitchManifest(local)
+	if err != nil {
+		return err
+	}
+	for _, p := range sm.Parts {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := rc.fetchFile(filepath.Join(filepath.Dir(local), p.File)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FetchAll fetches all CAR files to the cache directory, parallel at a time.
+func (rc *RemoteCars) FetchAll(ctx context.Context, parallel int) error {
+	cars, err := rc.cars()
+	if err != nil {
+		return err
+	}
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	limitCh := make(chan struct{}, parallel)
+	for _, local := range cars {
+		limitCh <- struct{}{}
+		wg.Add(1)
+		go func(local string) {
+			defer func() {
+				<-limitCh
+				wg.Done()
+			}()
+			if err := rc.fetch(ctx, local); err != nil {
+				errOnce.Do(func() { firstErr = err })
+			}
+		}(local)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+func (rc *RemoteCars) fetchFile(local string) error {
+	item, ok := rc.files[local]
+	if !ok {
+		return fmt.Errorf("%s is not in %s", local, rc.src.Root())
+	}
+	size := item.Info.Size()
+	if fi, err := os.Stat(local); err == nil && fi.Size() == size {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(local), 0o777); err != nil {
+		return err
+	}
+	// the partial file is skipped by restores of the cache directory
+	partial := local + TmpSuffix
+	f, err := os.OpenFile(partial, os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	start, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if start > size {
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		if start, err = f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	where := item.Path
+	if l, ok := rc.src.(Locator); ok {
+		where = l.Locate(item)
+	}
+	log.Infof("fetch %s, %d of %d bytes cached", where, start, size)
+	r, err := rc.src.Open(item, start, size)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, r)
+	r.Close()
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s: %w", item.Path, err)
+	}
+	if start+n != size {
+		return fmt.Errorf("fetched %d bytes of %s, expected %d", start+n, item.Path, size)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(partial, local)
+}
+
+// WithRemoteCars restores the CAR files of rc instead of the ones below the
+// car path, every CAR file is fetched by the worker restoring it.
+func WithRemoteCars(rc *RemoteCars) RestoreOption {
+	return func(o *restoreOptions) {
+		o.remote = rc
+	}
+}
+
+// NewHTTPDirSource reads the CAR files of a CAR directory served at baseURL.
+// Directories can not be listed over HTTP, so the CAR files are the ones of
+// the rows of its manifest.csv, named by piece cid or payload cid.
+func NewHTTPDirSource(ctx context.Context, baseURL string) (*HTTPListSource, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid url %q", baseURL)
+	}
+	hs := &HTTPListSource{
+		client: http.DefaultClient,
+		urls:   make(map[string]string),
+		root:   path.Join(httpSourceRoot, u.Host, u.Path),
+	}
+	fileURL := func(name string) string {
+		fu := *u
+		fu.Path = u.Path + "/" + name
+		return fu.String()
+	}
+	// add adds name with its size, false if the server does not have it
+	add := func(name string) (bool, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, fileURL(name), nil)
+		if err != nil {
+			return false, err
+		}
+		resp, err := hs.client.Do(req)
+		if err != nil {
+			return false, err
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return false, nil
+		}
+		if resp.StatusCode != http.StatusOK || resp.ContentLength < 0 {
+			return false, fmt.Errorf("failed to get the size of %s: %s", fileURL(name), resp.Status)
+		}
+		p := path.Join(hs.root, name)
+		hs.urls[p] = fileURL(name)
+		hs.files = append(hs.files, Finfo{
+			Path: p,
+			Name: name,
+			Info: remoteFileInfo{name: name, size: resp.ContentLength},
+		})
+		return true, nil
+	}
+
+	if ok, err := add(ManifestFileName); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, fmt.Errorf("%s has no %s", baseURL, ManifestFileName)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL(ManifestFileName), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := hs.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", fileURL(ManifestFileName), resp.Status)
+	}
+	rows, err := parseManifest(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", fileURL(ManifestFileName), err)
+	}
+	for _, row := range rows {
+		ext := compressionExt(row["compression"])
+		var names []string
+		if c := row["piece_cid"]; c != "" {
+			names = append(names, c+".car"+ext, c+ext)
+		}
+		names = append(names, row["payload_cid"]+".car"+ext)
+		found := false
+		for _, name := range names {
+			if found, err = add(name); err != nil {
+				return nil, err
+			} else if found {
+				break
+			}
+		}
+		if !found {
+			log.Warnf("the CAR file of payload %s is not at %s", row["payload_cid"], baseURL)
+		}
+	}
+	return hs, nil
+}
@@ -0,0 +1,52 @@
+package graphsplit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	dag "github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+)
+
+func TestRestoreRemoteCars(t *testing.T) {
+	carDir := t.TempDir()
+	a := dag.NodeWithData(unixfs.FilePBData([]byte("remote file"), 11))
+	root := unixfs.EmptyDirNode()
+	root.AddNodeLink("a.txt", a)
+	writeTestCar(t, filepath.Join(carDir, root.Cid().String()+".car"), []blocks.Block{root, a})
+	manifest := "payload_cid,filename\n" + root.Cid().String() + ",a\n"
+	if err := os.WriteFile(filepath.Join(carDir, ManifestFileName), []byte(manifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.StripPrefix("/cars", http.FileServer(http.Dir(carDir))))
+	defer srv.Close()
+
+	ctx := context.Background()
+	src, err := NewHTTPDirSource(ctx, srv.URL+"/cars/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cacheDir := t.TempDir()
+	rc, err := NewRemoteCars(ctx, src, cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(cacheDir, ManifestFileName)); err != nil {
+		t.Fatalf("manifest should be fetched: %v", err)
+	}
+	out := t.TempDir()
+	if err := carTo(cacheDir, out, 1, WithRemoteCars(rc)); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(filepath.Join(out, "a.txt")); err != nil || string(got) != "remote file" {
+		t.Fatalf("unexpected content %q, %v", got, err)
+	}
+	if _, err := os.Stat(filepath.Join(cacheDir, root.Cid().String()+".car")); err != nil {
+		t.Fatalf("CAR file should be cached: %v", err)
+	}
+}
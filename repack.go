@@ -0,0 +1,36 @@
+package graphsplit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// Repack re-splits the payload of the CAR files at carPath, a file or a
+// directory, into new CAR files of the slice size of params. The payload is
+// unpacked into a staging directory below workDir, which is removed
+// afterwards, so the original source filesystem is not needed.
+func Repack(ctx context.Context, carPath, workDir string, params *ChunkParams, opts ...RestoreOption) error {
+	staging, err := os.MkdirTemp(workDir, "repack-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging) //nolint:errcheck
+
+	parallel := params.Parallel
+	if parallel <= 0 {
+		parallel = runtime.NumCPU()
+	}
+	log.Infof("unpacking %s to %s", carPath, staging)
+	if err := carTo(carPath, staging, parallel, append(opts, WithRestoreContext(ctx))...); err != nil {
+		return fmt.Errorf("failed to unpack %s: %w", carPath, err)
+	}
+	if err := Merge(staging, parallel, opts...); err != nil {
+		return fmt.Errorf("failed to unpack %s: %w", carPath, err)
+	}
+
+	params.TargetPath = staging
+	params.ParentPath = staging
+	return Chunk(ctx, params)
+}
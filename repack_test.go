@@ -0,0 +1,69 @@
+package graphsplit
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRepack(t *testing.T) {
+	dir := writeTestTree(t, 40<<10, 150<<10, 60<<10)
+	smallDir := t.TempDir()
+	small := chunkTestTree(t, dir, &ChunkParams{ExpectSliceSize: 64 << 10, CarDir: smallDir})
+
+	ef, err := NewExtraFile("", 0, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	largeDir := t.TempDir()
+	err = Repack(context.Background(), smallDir, t.TempDir(), &ChunkParams{
+		ExpectSliceSize: 1 << 20,
+		CarDir:          largeDir,
+		Parallel:        1,
+		Cb:              CSVCallback(largeDir),
+		Ef:              ef,
+		GraphName:       "repack",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	large, err := ReadManifest(largeDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(large) != 1 || len(small) < 4 {
+		t.Fatalf("expected %d slices to be repacked into one, got %d", len(small), len(large))
+	}
+
+	out := t.TempDir()
+	if err := carTo(largeDir, out, 1); err != nil {
+		t.Fatal(err)
+	}
+	Merge(out, 1)
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		want, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		got, err := os.ReadFile(filepath.Join(out, rel))
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s differs after the repack", rel)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
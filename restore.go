@@ -6,6 +6,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 
@@ -15,30 +16,99 @@ import (
 	dss "github.com/ipfs/go-datastore/sync"
 	bstore "github.com/ipfs/go-ipfs-blockstore"
 	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	ipld "github.com/ipfs/go-ipld-format"
 	files "github.com/ipfs/go-libipfs/files"
 	"github.com/ipfs/go-merkledag"
 	unixfile "github.com/ipfs/go-unixfs/file"
 	"github.com/ipld/go-car"
 )
 
+// RestoreOption customizes CarTo and Merge.
+type RestoreOption func(*restoreOptions)
+
+type restoreOptions struct {
+	readLimiter  *RateLimiter
+	writeLimiter *RateLimiter
+	decryptor    *Encryptor
+	paths        []string
+	state        *RestoreState
+	verify       *VerifyReport
+	missing      *MissingReport
+	remote       *RemoteCars
+	layout       map[string]string
+	// placed are the parts of split files written in place, by the path
+	// they would be restored to
+	placed   map[string]partPlacement
+	progress *progressTracker
+	ctx      context.Context
+}
+
+// WithRestoreReadRate throttles reads of CAR files to bytesPerSec.
+func WithRestoreReadRate(bytesPerSec int64) RestoreOption {
+	return func(o *restoreOptions) {
+		o.readLimiter = NewRateLimiter(bytesPerSec)
+	}
+}
+
+// WithRestoreWriteRate throttles writes of restored files to bytesPerSec.
+func WithRestoreWriteRate(bytesPerSec int64) RestoreOption {
+	return func(o *restoreOptions) {
+		o.writeLimiter = NewRateLimiter(bytesPerSec)
+	}
+}
+
+// WithRestoreContext stops a restore once ctx is done. The CAR files being
+// restored are finished, the others are left for a resumed restore.
+func WithRestoreContext(ctx context.Context) RestoreOption {
+	return func(o *restoreOptions) {
+		o.ctx = ctx
+	}
+}
+
+// WithDecryption decrypts the data of files encrypted with the key of enc.
+func WithDecryption(enc *Encryptor) RestoreOption {
+	return func(o *restoreOptions) {
+		o.decryptor = enc
+	}
+}
+
+// WithRestoreState skips the CAR files rs records as restored and records
+// the ones restored by this run.
+func WithRestoreState(rs *RestoreState) RestoreOption {
+	return func(o *restoreOptions) {
+		o.state = rs
+	}
+}
+
+func newRestoreOptions(opts []RestoreOption) restoreOptions {
+	o := restoreOptions{ctx: context.Background()}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
 func Import(ctx context.Context, path string, st car.Store) (cid.Cid, error) {
-	f, err := os.Open(path)
+	return importCar(ctx, path, st, nil)
+}
+
+func importCar(ctx context.Context, path string, st car.Store, limiter *RateLimiter) (cid.Cid, error) {
+	stat, err := os.Stat(path)
 	if err != nil {
 		return cid.Undef, err
 	}
-	defer f.Close() //nolint:errcheck
-
-	stat, err := f.Stat()
+	f, err := openCar(path)
 	if err != nil {
 		return cid.Undef, err
 	}
+	defer f.Close() //nolint:errcheck
 
-	file, err := files.NewReaderPathFile(path, f, stat)
+	file, err := files.NewReaderPathFile(path, io.NopCloser(limiter.Reader(f)), stat)
 	if err != nil {
 		return cid.Undef, err
 	}
 
-	result, err := car.LoadCar(ctx, st, file)
+	result, err := loadCar(ctx, st, file)
 	if err != nil {
 		return cid.Undef, err
 	}
@@ -51,18 +121,25 @@ func Import(ctx context.Context, path string, st car.Store) (cid.Cid, error) {
 }
 
 func NodeWriteTo(nd files.Node, fpath string) error {
+	return nodeWriteTo(nd, fpath, nil, nil, nil)
+}
+
+func nodeWriteTo(nd files.Node, fpath string, limiter *RateLimiter, dec *Encryptor, placed map[string]partPlacement) error {
 	switch nd := nd.(type) {
 	case *files.Symlink:
 		return os.Symlink(nd.Target, fpath)
 	case files.File:
+		if pl, ok := placed[fpath]; ok {
+			return pl.write(nd, limiter)
+		}
 		f, err := os.Create(fpath)
 		if err != nil {
 			return err
 		}
 		defer f.Close()
-		_, err = io.Copy(f, nd)
+		_, err = io.Copy(limiter.Writer(f), dec.DecryptReader(nd))
 		if err != nil {
-			return err
+			return fmt.Errorf("%s: %w", fpath, err)
 		}
 		return nil
 	case files.Directory:
@@ -76,7 +153,7 @@ func NodeWriteTo(nd files.Node, fpath string) error {
 		entries := nd.Entries()
 		for entries.Next() {
 			child := filepath.Join(fpath, entries.Name())
-			if err := NodeWriteTo(entries.Node(), child); err != nil {
+			if err := nodeWriteTo(entries.Node(), child, limiter, dec, placed); err != nil {
 				return err
 			}
 		}
@@ -94,52 +171,194 @@ func ExistDir(path string) bool {
 	return s.IsDir()
 }
 
-func CarTo(carPath, outputDir string, parallel int) {
+// RestoreFailure is a CAR file, or a file merged from its parts, which could
+// not be restored.
+type RestoreFailure struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+	err   error
+}
+
+// RestoreError is returned by CarTo and Merge once all files have been tried
+// if some of them failed.
+type RestoreError struct {
+	Failures []RestoreFailure
+}
+
+func (e *RestoreError) Error() string {
+	if len(e.Failures) == 1 {
+		return fmt.Sprintf("failed to restore %s: %s", e.Failures[0].Path, e.Failures[0].Error)
+	}
+	return fmt.Sprintf("failed to restore %d files, the first one %s: %s", len(e.Failures), e.Failures[0].Path, e.Failures[0].Error)
+}
+
+func (e *RestoreError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failures))
+	for _, f := range e.Failures {
+		errs = append(errs, f.err)
+	}
+	return errs
+}
+
+// restoreFailures collects the failures of concurrent restores.
+type restoreFailures struct {
+	mu       sync.Mutex
+	failures []RestoreFailure
+}
+
+func (rf *restoreFailures) add(path, msg string, err error) {
+	log.Errorf("%s: %s%s", path, msg, err)
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.failures = append(rf.failures, RestoreFailure{Path: path, Error: msg + err.Error(), err: err})
+}
+
+func (rf *restoreFailures) err() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if len(rf.failures) == 0 {
+		return nil
+	}
+	failures := append([]RestoreFailure(nil), rf.failures...)
+	sort.Slice(failures, func(i, j int) bool { return failures[i].Path < failures[j].Path })
+	return &RestoreError{Failures: failures}
+}
+
+// CarTo restores the CAR files of carPath to outputDir, parallel at a time.
+// CAR files which fail are skipped, they are returned in a *RestoreError once
+// all CAR files have been tried.
+func CarTo(carPath, outputDir string, parallel int, opts ...RestoreOption) error {
+	return carTo(carPath, outputDir, parallel, opts...)
+}
+
+func carTo(carPath, outputDir string, parallel int, opts ...RestoreOption) (err error) {
+	o := newRestoreOptions(opts)
+	restoreCtx, sp := startSpan(o.ctx, "restore", "car_path", carPath, "output_dir", outputDir)
+	defer func() { sp.End(err) }()
+	// the CAR files are restored to the end once started
 	ctx := context.Background()
 
+	var failures restoreFailures
+
+	var cars []string
+	if o.remote != nil {
+		cars, err = o.remote.cars()
+	} else {
+		cars, err = restorableCars(carPath)
+	}
+	if err != nil {
+		return err
+	}
+	if o.progress != nil {
+		var total int64
+		for _, path := range cars {
+			if fi, err := os.Stat(path); err == nil {
+				total += fi.Size()
+			}
+		}
+		o.progress.setTotals(total, 0, len(cars))
+		defer o.progress.finish()
+	}
+	// decrypted parts differ in size from the DAG, they are merged afterwards
+	// like the parts of selective and partial restores and of remote CAR
+	// files, which are only fetched by the workers
+	if len(o.paths) == 0 && o.missing == nil && o.decryptor == nil && o.remote == nil {
+		if o.placed, err = planParts(ctx, cars, outputDir, o); err != nil {
+			return err
+		}
+	}
+
 	workerCh := make(chan func())
 	go func() {
 		defer close(workerCh)
-		err := filepath.Walk(carPath, func(path string, fi os.FileInfo, err error) error {
-			if err != nil {
-				return err
+		for _, path := range cars {
+			if o.ctx.Err() != nil {
+				return
 			}
-			if fi.IsDir() {
-				return nil
+			path := path
+			load := importCar
+			if isStitchManifest(path) {
+				load = importStitched
 			}
-			// if strings.ToLower(pa.Ext(fi.Name())) != ".car" {
-			// 	log.Warn(path, ", it's not a CAR file, skip it")
-			// 	return nil
-			// }
 			workerCh <- func() {
+				if o.ctx.Err() != nil {
+					return
+				}
+				o.progress.startSlice(path)
+				_, carSpan := startSpan(restoreCtx, "restore_car", "path", path)
+				defer carSpan.End(nil)
+				defer func() {
+					if fi, err := os.Stat(path); err == nil {
+						o.progress.addBytes(fi.Size())
+					}
+					o.progress.sliceDone()
+				}()
+				if o.state.Restored(path) {
+					log.Infof("%s was restored before, skip it", path)
+					return
+				}
+				if o.remote != nil {
+					if err := o.remote.fetch(ctx, path); err != nil {
+						failures.add(path, "fetch error, ", err)
+						return
+					}
+				}
+				if len(o.paths) > 0 {
+					if err := restoreSelected(ctx, path, outputDir, o); err != nil {
+						failures.add(path, "restore error, ", err)
+					}
+					return
+				}
+				failCar := func(msg string, err error) {
+					failures.add(path, msg, err)
+					if o.verify != nil {
+						o.verify.add(VerifyResult{Path: path, Car: path, Status: VerifyCorrupt, Error: err.Error()})
+					}
+				}
 				bs2 := bstore.NewBlockstore(dss.MutexWrap(datastore.NewMapDatastore()))
 				rdag := merkledag.NewDAGService(blockservice.New(bs2, offline.Exchange(bs2)))
 				log.Info(path)
-				root, err := Import(ctx, path, bs2)
+				var store car.Store = bs2
+				vs := &verifyingStore{Store: bs2, drop: o.missing != nil}
+				if o.verify != nil || o.missing != nil {
+					store = vs
+				}
+				root, err := load(ctx, path, store, o.readLimiter)
+				if err != nil && o.missing != nil {
+					log.Warnf("%s is incomplete, restore the files whose blocks are present: %s", path, err)
+					root, err = carRoot(path)
+				}
 				if err != nil {
-					log.Error("import error, ", err)
+					if o.missing != nil {
+						o.missing.add(MissingFile{Car: path, Whole: true, Error: err.Error()})
+					}
+					failCar("import error, ", err)
 					return
 				}
-				nd, err := rdag.Get(ctx, root)
-				if err != nil {
-					log.Error("dagService.Get error, ", err)
+				outDir := o.layoutDir(outputDir, root)
+				if err := os.MkdirAll(outDir, 0o777); err != nil {
+					failCar("restore error, ", err)
 					return
 				}
-				file, err := unixfile.NewUnixfsFile(ctx, rdag, nd)
-				if err != nil {
-					log.Error("NewUnixfsFile error, ", err)
+				if o.missing != nil {
+					if err := o.missing.writeTo(ctx, path, bs2, root, outDir, o.writeLimiter); err != nil {
+						failCar("restore error, ", err)
+						return
+					}
+				} else if err := restoreRoot(ctx, rdag, root, outDir, o); err != nil {
+					failCar("restore error, ", err)
 					return
 				}
-				defer file.Close()
-				err = NodeWriteTo(file, outputDir)
-				if err != nil {
-					log.Error("NodeWriteTo error, ", err)
+				if o.verify != nil {
+					if err := o.verify.verifyFiles(ctx, path, root, bs2, vs.corrupt, outDir, o); err != nil {
+						failCar("verify error, ", err)
+						return
+					}
+				}
+				if err := o.state.Record(path); err != nil {
+					log.Errorf("failed to record restore of %s: %s", path, err)
 				}
 			}
-			return nil
-		})
-		if err != nil {
-			log.Error("Walk path failed, ", err)
 		}
 	}()
 
@@ -159,61 +378,125 @@ func CarTo(carPath, outputDir string, parallel int) {
 		}
 	}()
 	wg.Wait()
+	if err := o.ctx.Err(); err != nil {
+		return err
+	}
+	return failures.err()
+}
+
+// restoreRoot writes the DAG below root to outputDir.
+func restoreRoot(ctx context.Context, rdag ipld.DAGService, root cid.Cid, outputDir string, o restoreOptions) error {
+	nd, err := rdag.Get(ctx, root)
+	if err != nil {
+		return err
+	}
+	file, err := unixfile.NewUnixfsFile(ctx, rdag, nd)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return nodeWriteTo(file, outputDir, o.writeLimiter, o.decryptor, o.placed)
+}
+
+// restorableCars returns the CAR files and stitch manifests below carPath,
+// skipping unfinished writes, sidecars, metadata, stitched parts and parity
+// pieces.
+func restorableCars(carPath string) ([]string, error) {
+	var paths []string
+	err := filepath.Walk(carPath, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return selectRestorable(carPath, paths)
+}
+
+// selectRestorable returns the CAR files and stitch manifests among the
+// files below root.
+func selectRestorable(root string, paths []string) ([]string, error) {
+	parts, err := stitchedParts(root)
+	if err != nil {
+		return nil, err
+	}
+	// parity pieces only hold recovery data
+	parity, err := parityFiles(root)
+	if err != nil {
+		return nil, err
+	}
+	for path := range parity {
+		parts[path] = true
+	}
+
+	var cars []string
+	for _, path := range paths {
+		if isTmpFile(path) {
+			log.Warnf("%s is an unfinished write, skip it", path)
+			continue
+		}
+		if !isStitchManifest(path) && (parts[path] || isChecksumSidecar(path) || isCarDirMetadata(path) || isPieceFile(path)) {
+			continue
+		}
+		cars = append(cars, path)
+	}
+	sort.Strings(cars)
+	return cars, nil
+}
+
+// isPieceFile reports whether path is a padded copy of a CAR file.
+func isPieceFile(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), pieceFileExt)
+}
+
+// isCarDirMetadata reports whether path is one of the csv or json files kept
+// next to the CAR files, like manifest.csv.
+func isCarDirMetadata(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".csv" || ext == ".json" || ext == ".ndjson"
 }
 
-func Merge(dir string, parallel int) {
+// Merge joins the parts of the files split across CAR files below dir, which
+// a restore wrote there, parallel at a time. Files which fail are returned in
+// a *RestoreError once all files have been tried, their parts are kept.
+func Merge(dir string, parallel int, opts ...RestoreOption) error {
+	o := newRestoreOptions(opts)
+	var failures restoreFailures
 	wg := sync.WaitGroup{}
 	limitCh := make(chan struct{}, parallel)
 	mergeCh := make(chan string)
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		for {
-			select {
-			case fpath, ok := <-mergeCh:
-				if !ok {
+		for fpath := range mergeCh {
+			fpath := fpath
+			limitCh <- struct{}{}
+			wg.Add(1)
+			go func() {
+				defer func() {
+					<-limitCh
+					wg.Done()
+				}()
+				log.Info("merge to ", fpath)
+				if o.missing != nil {
+					rel, _ := filepath.Rel(dir, fpath)
+					if err := mergeMissing(fpath, filepath.ToSlash(rel), o); err != nil {
+						failures.add(fpath, "merge error, ", err)
+					}
 					return
 				}
-				limitCh <- struct{}{}
-				wg.Add(1)
-				go func() {
-					defer func() {
-						<-limitCh
-						wg.Done()
-					}()
-					log.Info("merge to ", fpath)
-					f, err := os.Create(fpath)
-					if err != nil {
-						log.Error("Create file failed, ", err)
-						return
-					}
-					defer f.Close()
-					for i := 0; ; i++ {
-						chunkPath := fmt.Sprintf("%s.%08d", fpath, i)
-						err := func(path string) error {
-							chunkF, err := os.Open(path)
-							if err != nil {
-								if os.IsExist(err) {
-									log.Error("Open file failed, ", err)
-								}
-								return err
-							}
-							defer chunkF.Close()
-							_, err = io.Copy(f, chunkF)
-							if err != nil {
-								log.Error("io.Copy failed, ", err)
-							}
-							return err
-						}(chunkPath)
-						os.Remove(chunkPath)
-						if err != nil {
-							break
-						}
-					}
-				}()
-			}
+				if err := mergeFile(fpath, o); err != nil {
+					failures.add(fpath, "merge error, ", err)
+				}
+			}()
 		}
 	}()
+	queued := make(map[string]bool)
 	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -221,18 +504,51 @@ func Merge(dir string, parallel int) {
 		if fi.IsDir() {
 			return nil
 		}
-		matched, err := filepath.Match("*.00000000", fi.Name())
-		if err != nil {
-			log.Error("filepath.Match failed, ", err)
+		if o.missing != nil {
+			// the first part may be missing too
+			if splitPartName.MatchString(path) && !queued[path[:len(path)-9]] {
+				queued[path[:len(path)-9]] = true
+				mergeCh <- path[:len(path)-9]
+			}
 			return nil
-		} else if matched {
+		}
+		if strings.HasSuffix(fi.Name(), ".00000000") {
 			mergeCh <- strings.TrimSuffix(path, ".00000000")
 		}
 		return nil
 	})
-	if err != nil {
-		log.Error("Walk path failed, ", err)
-	}
 	close(mergeCh)
 	wg.Wait()
+	if err != nil {
+		return fmt.Errorf("failed to walk %s: %w", dir, err)
+	}
+	return failures.err()
+}
+
+// mergeFile joins the parts of fpath. The parts are only removed once the
+// merged file is complete, so an interrupted merge can be run again.
+func mergeFile(fpath string, o restoreOptions) error {
+	f, err := createAtomic(fpath)
+	if err != nil {
+		return err
+	}
+	var chunkPaths []string
+	for i := 0; ; i++ {
+		chunkPath := fmt.Sprintf("%s.%08d", fpath, i)
+		if _, err := copyFile(o.writeLimiter.Writer(f), chunkPath); err != nil {
+			if os.IsNotExist(err) {
+				break
+			}
+			f.Abort()
+			return err
+		}
+		chunkPaths = append(chunkPaths, chunkPath)
+	}
+	if err := f.Commit(); err != nil {
+		return err
+	}
+	for _, chunkPath := range chunkPaths {
+		os.Remove(chunkPath)
+	}
+	return nil
 }
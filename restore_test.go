@@ -0,0 +1,34 @@
+package graphsplit
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	dag "github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+)
+
+func TestCarToFailures(t *testing.T) {
+	dir := t.TempDir()
+	a := dag.NodeWithData(unixfs.FilePBData([]byte("restored"), 8))
+	root := unixfs.EmptyDirNode()
+	root.AddNodeLink("a.txt", a)
+	writeTestCar(t, filepath.Join(dir, "good.car"), []blocks.Block{root, a})
+	bad := filepath.Join(dir, "bad.car")
+	if err := os.WriteFile(bad, []byte("not a car file"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := t.TempDir()
+	err := CarTo(dir, out, 2)
+	var re *RestoreError
+	if !errors.As(err, &re) || len(re.Failures) != 1 || re.Failures[0].Path != bad {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if got, err := os.ReadFile(filepath.Join(out, "a.txt")); err != nil || string(got) != "restored" {
+		t.Fatalf("the good CAR file should be restored: %q, %v", got, err)
+	}
+}
@@ -0,0 +1,87 @@
+package graphsplit
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// RestoreStateFileName is kept in the output directory, it is hidden so the
+// restored files can be chunked again without it.
+const RestoreStateFileName = ".restore-state.json"
+
+// RestoredCar is the state of a CAR file at the time it was restored.
+type RestoredCar struct {
+	Size       int64     `json:"size"`
+	ModTime    time.Time `json:"mod_time"`
+	RestoredAt time.Time `json:"restored_at"`
+}
+
+// RestoreState records which CAR files have been restored completely, so an
+// interrupted restore continues with the remaining ones.
+type RestoreState struct {
+	mu   sync.Mutex
+	path string
+
+	Cars map[string]*RestoredCar `json:"cars"`
+}
+
+// OpenRestoreState loads the restore state of outputDir.
+func OpenRestoreState(outputDir string) (*RestoreState, error) {
+	rs := &RestoreState{
+		path: filepath.Join(outputDir, RestoreStateFileName),
+		Cars: make(map[string]*RestoredCar),
+	}
+	data, err := os.ReadFile(rs.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return rs, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, rs); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+// Restored reports whether the CAR file at path was restored and has not
+// changed since.
+func (rs *RestoreState) Restored(path string) bool {
+	if rs == nil {
+		return false
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rc, ok := rs.Cars[path]
+	return ok && rc.Size == fi.Size() && rc.ModTime.Equal(fi.ModTime())
+}
+
+// Record records that the CAR file at path has been restored completely.
+func (rs *RestoreState) Record(path string) error {
+	if rs == nil {
+		return nil
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.Cars[path] = &RestoredCar{Size: fi.Size(), ModTime: fi.ModTime(), RestoredAt: time.Now()}
+	return rs.save()
+}
+
+func (rs *RestoreState) save() error {
+	data, err := json.MarshalIndent(rs, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(rs.path, data)
+}
@@ -0,0 +1,67 @@
+package graphsplit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRestoreStateResume(t *testing.T) {
+	carDir := t.TempDir()
+	rows := chunkTestTree(t, writeTestTree(t, 40<<10, 50<<10, 60<<10), &ChunkParams{ExpectSliceSize: 64 << 10, CarDir: carDir})
+	if len(rows) < 2 {
+		t.Fatalf("expected several CAR files, got %d", len(rows))
+	}
+	out := t.TempDir()
+	rs, err := OpenRestoreState(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := carTo(carDir, out, 1, WithRestoreState(rs)); err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err = OpenRestoreState(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	carPaths := make([]string, 0, len(rows))
+	for _, row := range rows {
+		carPath := filepath.Join(carDir, row["payload_cid"]+".car")
+		if !rs.Restored(carPath) {
+			t.Fatalf("expected %s to be recorded as restored", carPath)
+		}
+		carPaths = append(carPaths, carPath)
+	}
+
+	// a resumed restore skips the restored CAR files
+	restoredAt := make(map[string]time.Time)
+	for path, rc := range rs.Cars {
+		restoredAt[path] = rc.RestoredAt
+	}
+	if err := carTo(carDir, out, 1, WithRestoreState(rs)); err != nil {
+		t.Fatal(err)
+	}
+	rs, err = OpenRestoreState(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, rc := range rs.Cars {
+		if !rc.RestoredAt.Equal(restoredAt[path]) {
+			t.Fatalf("expected %s not to be restored again", path)
+		}
+	}
+
+	// a changed CAR file is restored again
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(carPaths[0], later, later); err != nil {
+		t.Fatal(err)
+	}
+	if rs.Restored(carPaths[0]) || !rs.Restored(carPaths[1]) {
+		t.Fatal("expected only the changed CAR file to need a restore")
+	}
+	if _, err := os.Stat(filepath.Join(out, RestoreStateFileName+TmpSuffix)); !os.IsNotExist(err) {
+		t.Fatal("expected the state to be written atomically")
+	}
+}
@@ -0,0 +1,67 @@
+package graphsplit
+
+import (
+	"context"
+	"strconv"
+	"sync"
+)
+
+// SliceResult describes a slice built by ChunkSlices.
+type SliceResult struct {
+	Name       string `json:"name"`
+	PayloadCid string `json:"payload_cid"`
+	// PieceCid and PieceSize are empty without a commP callback
+	PieceCid  string `json:"piece_cid,omitempty"`
+	PieceSize uint64 `json:"piece_size,omitempty"`
+	// CarPath is empty if no CAR file was written
+	CarPath string      `json:"car_path,omitempty"`
+	Files   []SliceFile `json:"files"`
+	// Size is the size of the CAR
+	Size int64 `json:"size"`
+}
+
+// resultCallback collects the results of the slices handled by next.
+type resultCallback struct {
+	next    GraphBuildCallback
+	carDir  string
+	mu      sync.Mutex
+	results []SliceResult
+}
+
+func (rc *resultCallback) OnSuccess(buf *Buffer, slice *GraphSlice) {
+	res := SliceResult{
+		Name:       slice.Name,
+		PayloadCid: slice.PayloadCid,
+		Files:      slice.Files,
+		Size:       int64(buf.Len()),
+	}
+	rc.next.OnSuccess(buf, slice)
+
+	row, err := findManifestRow(rc.carDir, slice.PayloadCid)
+	if err != nil {
+		log.Warnf("failed to read manifest: %s", err)
+	}
+	if row != nil {
+		res.PieceCid = row["piece_cid"]
+		res.PieceSize, _ = strconv.ParseUint(row["piece_size"], 10, 64)
+		res.CarPath = locateCarExt(rc.carDir, row, compressionExt(row["compression"]))
+	}
+	rc.mu.Lock()
+	rc.results = append(rc.results, res)
+	rc.mu.Unlock()
+}
+
+func (rc *resultCallback) OnError(err error) {
+	rc.next.OnError(err)
+}
+
+// ChunkSlices runs Chunk and returns the slices it built in order, the pieces
+// and CAR files are read from the manifest written by the callback of params.
+// The results built before an error are returned with it.
+func ChunkSlices(ctx context.Context, params *ChunkParams) ([]SliceResult, error) {
+	rc := &resultCallback{next: params.Cb, carDir: params.CarDir}
+	params.Cb = rc
+	defer func() { params.Cb = rc.next }()
+	err := Chunk(ctx, params)
+	return rc.results, err
+}
@@ -0,0 +1,23 @@
+package graphsplit
+
+import (
+	"testing"
+)
+
+func TestResultCallback(t *testing.T) {
+	next := &manifestRowCallback{carDir: t.TempDir()}
+	rc := &resultCallback{next: next, carDir: next.carDir}
+	buf := NewBuffer(0)
+	buf.Write(make([]byte, 1500))
+	files := []SliceFile{{Path: "dir/a", Size: 1000}}
+	rc.OnSuccess(buf, &GraphSlice{Name: "graph.car", PayloadCid: "bafytest", Files: files})
+
+	if len(rc.results) != 1 {
+		t.Fatalf("expected a result, got %d", len(rc.results))
+	}
+	res := rc.results[0]
+	if res.Name != "graph.car" || res.PayloadCid != "bafytest" || res.PieceCid != "baga-test" || res.PieceSize != 2048 ||
+		res.Size != 1500 || len(res.Files) != 1 || res.CarPath != "" {
+		t.Fatalf("unexpected result %+v", res)
+	}
+}
@@ -0,0 +1,112 @@
+package graphsplit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"time"
+)
+
+// DefaultRetryBackoff is the wait before the first retry of a RetryPolicy
+// without Backoff.
+const DefaultRetryBackoff = time.Second
+
+// RetryPolicy retries operations failing with transient IO errors, like NFS
+// hiccups, with exponential backoff. The zero policy doesn't retry.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts of an operation, 0 or 1 means
+	// it is not retried
+	MaxAttempts int
+	// Backoff is the wait before the first retry, it doubles with every retry
+	Backoff time.Duration
+}
+
+// retries returns the number of retries after the first attempt.
+func (rp RetryPolicy) retries() int {
+	if rp.MaxAttempts <= 1 {
+		return 0
+	}
+	return rp.MaxAttempts - 1
+}
+
+// wait returns the wait before retry number n, from 1.
+func (rp RetryPolicy) wait(n int) time.Duration {
+	backoff := rp.Backoff
+	if backoff <= 0 {
+		backoff = DefaultRetryBackoff
+	}
+	return backoff << (n - 1)
+}
+
+// do runs fn until it succeeds, fails with an error which is not transient
+// or runs out of attempts.
+func (rp RetryPolicy) do(ctx context.Context, what string, fn func() error) error {
+	err := fn()
+	for n := 1; n <= rp.retries() && err != nil && isTransient(err); n++ {
+		wait := rp.wait(n)
+		metricErrors.add("transient_io", 1)
+		log.Warnf("%s failed: %s, retrying in %s", what, err, wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		err = fn()
+	}
+	return err
+}
+
+// isTransient reports whether err is an IO error which may go away when the
+// operation is retried.
+func isTransient(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	for _, errno := range []syscall.Errno{syscall.EIO, syscall.EAGAIN, syscall.EINTR, syscall.ESTALE, syscall.ETIMEDOUT, syscall.ECONNRESET} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryReader retries reads of r failing with transient errors, r has to
+// keep its offset on a failed read like files do.
+type retryReader struct {
+	ctx  context.Context
+	r    io.Reader
+	rp   RetryPolicy
+	what string
+}
+
+func (rp RetryPolicy) reader(ctx context.Context, r io.Reader, what string) io.Reader {
+	if rp.retries() == 0 {
+		return r
+	}
+	return &retryReader{ctx: ctx, r: r, rp: rp, what: what}
+}
+
+func (rr *retryReader) Read(p []byte) (int, error) {
+	var (
+		n       int
+		readErr error
+	)
+	err := rr.rp.do(rr.ctx, "read of "+rr.what, func() error {
+		n, readErr = rr.r.Read(p)
+		if n == 0 && readErr != io.EOF {
+			return readErr
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if n > 0 && readErr != io.EOF {
+		// a failure after some bytes shows up again on the next read
+		readErr = nil
+	}
+	return n, readErr
+}
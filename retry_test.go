@@ -0,0 +1,77 @@
+package graphsplit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	ctx := context.Background()
+	rp := RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}
+	eio := &os.PathError{Op: "read", Path: "/nfs/a", Err: syscall.EIO}
+
+	attempts := 0
+	err := rp.do(ctx, "read", func() error {
+		attempts++
+		if attempts < 3 {
+			return eio
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Fatalf("expected success on the third attempt, got %v after %d", err, attempts)
+	}
+
+	attempts = 0
+	err = rp.do(ctx, "read", func() error {
+		attempts++
+		return eio
+	})
+	if !errors.Is(err, syscall.EIO) || attempts != 3 {
+		t.Fatalf("expected EIO after 3 attempts, got %v after %d", err, attempts)
+	}
+
+	attempts = 0
+	err = rp.do(ctx, "read", func() error {
+		attempts++
+		return os.ErrPermission
+	})
+	if !errors.Is(err, os.ErrPermission) || attempts != 1 {
+		t.Fatalf("expected no retry of a permanent error, got %v after %d", err, attempts)
+	}
+
+	if (RetryPolicy{}).retries() != 0 || rp.wait(3) != 4*time.Millisecond {
+		t.Fatal("unexpected retries or backoff")
+	}
+}
+
+type flakyReader struct {
+	r     io.Reader
+	fails int
+}
+
+func (fr *flakyReader) Read(p []byte) (int, error) {
+	if fr.fails > 0 {
+		fr.fails--
+		return 0, syscall.ESTALE
+	}
+	return fr.r.Read(p)
+}
+
+func TestRetryReader(t *testing.T) {
+	rp := RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}
+	data, err := io.ReadAll(rp.reader(context.Background(), &flakyReader{r: strings.NewReader("graphsplit"), fails: 2}, "a"))
+	if err != nil || string(data) != "graphsplit" {
+		t.Fatalf("expected the data after retries, got %q %v", data, err)
+	}
+	_, err = io.ReadAll(rp.reader(context.Background(), &flakyReader{r: strings.NewReader("graphsplit"), fails: 3}, "a"))
+	if !errors.Is(err, syscall.ESTALE) {
+		t.Fatalf("expected ESTALE, got %v", err)
+	}
+}
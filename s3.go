@@ -0,0 +1,319 @@
+package graphsplit
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultS3PartSize = 64 << 20
+
+// S3Config locates an S3 compatible bucket, objects are addressed path style
+// so MinIO works without DNS setup.
+type S3Config struct {
+	// Endpoint is the base URL of the service, e.g. https://s3.amazonaws.com
+	Endpoint        string
+	Region          string
+	Bucket          string
+	Prefix          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// PartSize is the size of multipart upload parts, objects smaller than
+	// a part are uploaded with a single request
+	PartSize int64
+	// Retries is the number of times a failed request is retried
+	Retries int
+	// RetryBackoff is the wait before the first retry, it doubles with every
+	// retry, 0 means DefaultRetryBackoff
+	RetryBackoff time.Duration
+}
+
+// S3ConfigFromEnv returns a config for bucket/prefix with the credentials of
+// the usual AWS environment variables.
+func S3ConfigFromEnv(target, endpoint, region string) S3Config {
+	bucket, prefix, _ := strings.Cut(strings.Trim(target, "/"), "/")
+	return S3Config{
+		Endpoint:        strings.TrimSuffix(endpoint, "/"),
+		Region:          region,
+		Bucket:          bucket,
+		Prefix:          prefix,
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		PartSize:        defaultS3PartSize,
+		Retries:         3,
+	}
+}
+
+// S3Client uploads objects with AWS signature version 4.
+type S3Client struct {
+	cfg    S3Config
+	client *http.Client
+	now    func() time.Time
+}
+
+func NewS3Client(cfg S3Config) (*S3Client, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3 bucket is required")
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3.amazonaws.com"
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.PartSize <= 0 {
+		cfg.PartSize = defaultS3PartSize
+	}
+	return &S3Client{cfg: cfg, client: http.DefaultClient, now: time.Now}, nil
+}
+
+// ObjectURL returns the URL of the object stored under name.
+func (sc *S3Client) ObjectURL(name string) string {
+	return sc.cfg.Endpoint + sc.objectPath(name)
+}
+
+func (sc *S3Client) objectPath(name string) string {
+	key := name
+	if sc.cfg.Prefix != "" {
+		key = sc.cfg.Prefix + "/" + name
+	}
+	return "/" + sc.cfg.Bucket + "/" + key
+}
+
+// Upload stores size bytes of r under name, with a multipart upload when it
+// is bigger than a part, and returns the object URL.
+func (sc *S3Client) Upload(name string, r io.Reader, size int64) (string, error) {
+	objectPath := sc.objectPath(name)
+	if size <= sc.cfg.PartSize {
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return "", err
+		}
+		if _, err := sc.do(http.MethodPut, objectPath, nil, data); err != nil {
+			return "", err
+		}
+		return sc.ObjectURL(name), nil
+	}
+
+	resp, err := sc.do(http.MethodPost, objectPath, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return "", err
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.Unmarshal(resp.body, &initiated); err != nil {
+		return "", fmt.Errorf("invalid initiate multipart upload response: %w", err)
+	}
+	uploadID := initiated.UploadID
+
+	type part struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+	var parts []part
+	data := make([]byte, sc.cfg.PartSize)
+	for remaining := size; remaining > 0; {
+		n := sc.cfg.PartSize
+		if remaining < n {
+			n = remaining
+		}
+		if _, err := io.ReadFull(r, data[:n]); err != nil {
+			sc.abort(objectPath, uploadID)
+			return "", err
+		}
+		number := len(parts) + 1
+		resp, err := sc.do(http.MethodPut, objectPath, url.Values{
+			"partNumber": {strconv.Itoa(number)},
+			"uploadId":   {uploadID},
+		}, data[:n])
+		if err != nil {
+			sc.abort(objectPath, uploadID)
+			return "", err
+		}
+		parts = append(parts, part{PartNumber: number, ETag: resp.header.Get("ETag")})
+		remaining -= n
+	}
+
+	complete, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return "", err
+	}
+	resp, err = sc.do(http.MethodPost, objectPath, url.Values{"uploadId": {uploadID}}, complete)
+	if err != nil {
+		sc.abort(objectPath, uploadID)
+		return "", err
+	}
+	// the completion may fail after the status line was sent
+	if bytes.Contains(resp.body, []byte("<Error>")) {
+		sc.abort(objectPath, uploadID)
+		return "", fmt.Errorf("complete multipart upload of %s: %s", name, resp.body)
+	}
+	return sc.ObjectURL(name), nil
+}
+
+func (sc *S3Client) abort(objectPath, uploadID string) {
+	if _, err := sc.do(http.MethodDelete, objectPath, url.Values{"uploadId": {uploadID}}, nil); err != nil {
+		log.Warnf("failed to abort multipart upload %s of %s: %s", uploadID, objectPath, err)
+	}
+}
+
+type s3Response struct {
+	header http.Header
+	body   []byte
+}
+
+// do sends a signed request, retrying on network errors, throttling and
+// server errors.
+func (sc *S3Client) do(method, objectPath string, query url.Values, body []byte) (*s3Response, error) {
+	return sc.doWithHeader(method, objectPath, query, nil, body)
+}
+
+func (sc *S3Client) doWithHeader(method, objectPath string, query url.Values, header http.Header, body []byte) (*s3Response, error) {
+	var lastErr error
+	for attempt := 0; attempt <= sc.cfg.Retries; attempt++ {
+		if attempt > 0 {
+			wait := RetryPolicy{Backoff: sc.cfg.RetryBackoff}.wait(attempt)
+			log.Warnf("%s %s failed: %s, retrying in %s", method, objectPath, lastErr, wait)
+			time.Sleep(wait)
+		}
+		req, err := http.NewRequest(method, sc.cfg.Endpoint+objectPath, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.URL.RawPath = uriEncode(req.URL.Path, false)
+		req.URL.RawQuery = canonicalQuery(query)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		sc.sign(req, body)
+		resp, err := sc.client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		respBody, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			lastErr = fmt.Errorf("%s: %s", resp.Status, respBody)
+			continue
+		}
+		if resp.StatusCode >= 300 {
+			return nil, fmt.Errorf("%s %s: %s: %s", method, objectPath, resp.Status, respBody)
+		}
+		return &s3Response{header: resp.Header, body: respBody}, nil
+	}
+	return nil, lastErr
+}
+
+// sign adds an AWS signature version 4 authorization to req.
+func (sc *S3Client) sign(req *http.Request, body []byte) {
+	now := sc.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	if sc.cfg.SessionToken != "" {
+		req.Header.Set("x-amz-security-token", sc.cfg.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + sc.cfg.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+sc.cfg.SecretAccessKey), date)
+	key = hmacSHA256(key, sc.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sc.cfg.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, uriEncode(k, true)+"="+uriEncode(query.Get(k), true))
+	}
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode encodes s as required by signature version 4, slashes are kept
+// unless encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
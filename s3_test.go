@@ -0,0 +1,122 @@
+package graphsplit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestS3MultipartUpload(t *testing.T) {
+	parts := make(map[string][]byte)
+	var completed bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") {
+			t.Errorf("request is not signed: %q", r.Header.Get("Authorization"))
+		}
+		if r.URL.Path != "/bucket/prefix/test.car" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		q := r.URL.Query()
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case r.Method == http.MethodPost && q.Has("uploads"):
+			fmt.Fprint(w, "<InitiateMultipartUploadResult><UploadId>up1</UploadId></InitiateMultipartUploadResult>")
+		case r.Method == http.MethodPut && q.Get("uploadId") == "up1":
+			parts[q.Get("partNumber")] = body
+			w.Header().Set("ETag", `"etag"`)
+		case r.Method == http.MethodPost && q.Get("uploadId") == "up1":
+			completed = true
+		default:
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	client, err := NewS3Client(S3Config{
+		Endpoint:        srv.URL,
+		Bucket:          "bucket",
+		Prefix:          "prefix",
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+		PartSize:        10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("0123456789abc"), 2)
+	url, err := client.Upload("test.car", bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != srv.URL+"/bucket/prefix/test.car" {
+		t.Fatalf("unexpected url %s", url)
+	}
+	if !completed || len(parts) != 3 {
+		t.Fatalf("expected 3 completed parts, got %d, completed %v", len(parts), completed)
+	}
+	if got := append(append(parts["1"], parts["2"]...), parts["3"]...); !bytes.Equal(got, data) {
+		t.Fatalf("uploaded data differs")
+	}
+}
+
+func TestS3Source(t *testing.T) {
+	content := []byte("0123456789abcdefghij")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/bucket" && r.URL.Query().Get("list-type") == "2":
+			if r.URL.Query().Get("prefix") != "data/" {
+				t.Errorf("unexpected prefix %q", r.URL.Query().Get("prefix"))
+			}
+			fmt.Fprint(w, `<ListBucketResult><Contents><Key>data/dir/a.txt</Key><Size>20</Size>`+
+				`<LastModified>2024-01-02T03:04:05.000Z</LastModified></Contents>`+
+				`<Contents><Key>data/.hidden</Key><Size>1</Size></Contents></ListBucketResult>`)
+		case r.URL.Path == "/bucket/data/dir/a.txt":
+			var start, end int
+			if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err != nil {
+				http.Error(w, "range required", http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(content[start : end+1])
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	client, err := NewS3Client(S3Config{Endpoint: srv.URL, Bucket: "bucket", Prefix: "data"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := NewS3Source(client, 3, 2)
+	if src.Root() != "bucket/data" {
+		t.Fatalf("unexpected root %s", src.Root())
+	}
+	files, err := src.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Path != "bucket/data/dir/a.txt" || files[0].Info.Size() != 20 {
+		t.Fatalf("unexpected files %+v", files)
+	}
+
+	item := files[0]
+	item.SeekStart, item.SeekEnd = 5, 14
+	r, err := openItem(item, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content[5:15]) {
+		t.Fatalf("unexpected content %q", got)
+	}
+}
@@ -0,0 +1,177 @@
+package graphsplit
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// ParseS3URL splits an s3://bucket/prefix URL, ok is false for other paths.
+func ParseS3URL(u string) (target string, ok bool) {
+	if !strings.HasPrefix(u, "s3://") {
+		return "", false
+	}
+	return strings.Trim(strings.TrimPrefix(u, "s3://"), "/"), true
+}
+
+// S3Source reads the objects below the prefix of the bucket of an S3 client,
+// objects are streamed with ranged GETs.
+type S3Source struct {
+	client *S3Client
+	// RangeSize is the size of the ranges objects are fetched in
+	RangeSize int64
+	// Concurrency is the number of ranges of an object fetched ahead
+	Concurrency int
+}
+
+func NewS3Source(client *S3Client, rangeSize int64, concurrency int) *S3Source {
+	if rangeSize <= 0 {
+		rangeSize = 16 << 20
+	}
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	return &S3Source{client: client, RangeSize: rangeSize, Concurrency: concurrency}
+}
+
+// Root is bucket/prefix, the paths of objects are bucket/key.
+func (s *S3Source) Root() string {
+	return path.Join(s.client.cfg.Bucket, s.client.cfg.Prefix)
+}
+
+func (s *S3Source) List(ctx context.Context) ([]Finfo, error) {
+	prefix := s.client.cfg.Prefix
+	if prefix != "" {
+		prefix += "/"
+	}
+	var (
+		files []Finfo
+		token string
+	)
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.client.do(http.MethodGet, "/"+s.client.cfg.Bucket, query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		if err := xml.Unmarshal(resp.body, &result); err != nil {
+			return nil, fmt.Errorf("invalid list objects response: %w", err)
+		}
+		for _, obj := range result.Contents {
+			name := path.Base(obj.Key)
+			// directory markers and hidden files, like the local walk skips them
+			if strings.HasSuffix(obj.Key, "/") || strings.HasPrefix(name, ".") {
+				continue
+			}
+			files = append(files, Finfo{
+				Path: path.Join(s.client.cfg.Bucket, obj.Key),
+				Name: name,
+				Info: remoteFileInfo{name: name, size: obj.Size, modTime: obj.LastModified},
+			})
+		}
+		if !result.IsTruncated || ctx.Err() != nil {
+			return files, ctx.Err()
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *S3Source) Open(item Finfo, start, end int64) (io.ReadCloser, error) {
+	objectPath := "/" + item.Path
+	fetch := func(off, n int64) ([]byte, error) {
+		header := http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", off, off+n-1)}}
+		resp, err := s.client.doWithHeader(http.MethodGet, objectPath, nil, header, nil)
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(resp.body)) != n {
+			return nil, fmt.Errorf("ranged get of %s returned %d bytes, expected %d", item.Path, len(resp.body), n)
+		}
+		return resp.body, nil
+	}
+	return newRangeReader(start, end, s.RangeSize, s.Concurrency, fetch), nil
+}
+
+type fetchedRange struct {
+	data []byte
+	err  error
+}
+
+// rangeReader reads [start, end) in ranges fetched by up to concurrency
+// goroutines ahead of the reader, in order.
+type rangeReader struct {
+	ranges chan chan fetchedRange
+	stop   chan struct{}
+	cur    *bytes.Reader
+}
+
+func newRangeReader(start, end, rangeSize int64, concurrency int, fetch func(off, n int64) ([]byte, error)) *rangeReader {
+	rr := &rangeReader{
+		ranges: make(chan chan fetchedRange, concurrency),
+		stop:   make(chan struct{}),
+	}
+	go func() {
+		defer close(rr.ranges)
+		for off := start; off < end; off += rangeSize {
+			n := end - off
+			if n > rangeSize {
+				n = rangeSize
+			}
+			res := make(chan fetchedRange, 1)
+			select {
+			case rr.ranges <- res:
+			case <-rr.stop:
+				return
+			}
+			go func(off, n int64) {
+				data, err := fetch(off, n)
+				res <- fetchedRange{data: data, err: err}
+			}(off, n)
+		}
+	}()
+	return rr
+}
+
+func (rr *rangeReader) Read(p []byte) (int, error) {
+	for rr.cur == nil || rr.cur.Len() == 0 {
+		res, ok := <-rr.ranges
+		if !ok {
+			return 0, io.EOF
+		}
+		fr := <-res
+		if fr.err != nil {
+			return 0, fr.err
+		}
+		rr.cur = bytes.NewReader(fr.data)
+	}
+	return rr.cur.Read(p)
+}
+
+func (rr *rangeReader) Close() error {
+	close(rr.stop)
+	return nil
+}
+
+// Locate returns the s3:// URL of an object.
+func (s *S3Source) Locate(item Finfo) string {
+	return "s3://" + item.Path
+}
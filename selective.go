@@ -0,0 +1,542 @@
+package graphsplit
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	files "github.com/ipfs/go-libipfs/files"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+	unixfile "github.com/ipfs/go-unixfs/file"
+	"github.com/ipld/go-car"
+)
+
+// WithRestorePaths restores only the files and directories at paths, which
+// are relative to the restored output directory. Parts of files split across
+// CAR files are matched too and merged by Merge as usual.
+func WithRestorePaths(paths ...string) RestoreOption {
+	return func(o *restoreOptions) {
+		for _, p := range paths {
+			if p = strings.Trim(path.Clean("/"+p), "/"); p != "" {
+				o.paths = append(o.paths, p)
+			}
+		}
+	}
+}
+
+// carSection is the position of the data of a block in a CAR file.
+type carSection struct {
+	offset int64
+	size   int64
+}
+
+// carIndex reads single blocks of an uncompressed CAR file, indexing reads
+// only the section headers and skips the block data.
+type carIndex struct {
+	f        *os.File
+	roots    []cid.Cid
+	sections map[cid.Cid]carSection
+}
+
+func openCarIndex(carPath string) (*carIndex, error) {
+	f, err := os.Open(carPath)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := indexCar(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to index %s: %w", carPath, err)
+	}
+	return idx, nil
+}
+
+func indexCar(f *os.File) (*carIndex, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	h, err := car.ReadHeader(bufio.NewReader(io.NewSectionReader(f, 0, st.Size())))
+	if err != nil {
+		return nil, err
+	}
+	hsize, err := car.HeaderSize(h)
+	if err != nil {
+		return nil, err
+	}
+	idx := &carIndex{f: f, roots: h.Roots, sections: make(map[cid.Cid]carSection)}
+	buf := make([]byte, binary.MaxVarintLen64+128)
+	for pos := int64(hsize); pos < st.Size(); {
+		n, err := f.ReadAt(buf, pos)
+		if n == 0 && err != nil {
+			return nil, err
+		}
+		l, vn := binary.Uvarint(buf[:n])
+		if vn <= 0 {
+			return nil, fmt.Errorf("invalid section length at offset %d", pos)
+		}
+		// zero padding
+		if l == 0 {
+			break
+		}
+		cn, c, err := cid.CidFromBytes(buf[vn:n])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cid at offset %d: %w", pos, err)
+		}
+		idx.sections[c] = carSection{offset: pos + int64(vn+cn), size: int64(l) - int64(cn)}
+		pos += int64(vn) + int64(l)
+	}
+	return idx, nil
+}
+
+func (idx *carIndex) get(c cid.Cid) ([]byte, error) {
+	sec, ok := idx.sections[c]
+	if !ok {
+		return nil, fmt.Errorf("block %s not found", c)
+	}
+	data := make([]byte, sec.size)
+	if _, err := idx.f.ReadAt(data, sec.offset); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (idx *carIndex) Close() error {
+	return idx.f.Close()
+}
+
+// splitPartName matches the names of the parts of a file split across slices.
+var splitPartName = regexp.MustCompile(`\.\d{8}$`)
+
+// pathSelector copies the blocks below the selected paths of a DAG into bs,
+// reading blocks through get.
+type pathSelector struct {
+	ctx context.Context
+	bs  bstore.Blockstore
+	get func(cid.Cid) ([]byte, error)
+}
+
+func (ps *pathSelector) node(c cid.Cid) (*merkledag.ProtoNode, []byte, error) {
+	data, err := ps.get(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	if c.Prefix().Codec != cid.DagProtobuf {
+		return nil, data, nil
+	}
+	nd, err := merkledag.DecodeProtobuf(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode %s: %w", c, err)
+	}
+	return nd, data, nil
+}
+
+// find returns the cids of p below root by their path, several for a file
+// whose parts are held by this DAG.
+func (ps *pathSelector) find(root cid.Cid, p string) (map[string]cid.Cid, error) {
+	segs := strings.Split(p, "/")
+	cur := root
+	for i, seg := range segs {
+		nd, _, err := ps.node(cur)
+		if err != nil {
+			return nil, err
+		}
+		if nd == nil {
+			return nil, nil
+		}
+		last := i == len(segs)-1
+		found := make(map[string]cid.Cid)
+		for _, ln := range nd.Links() {
+			if ln.Name == seg || last && splitPartName.MatchString(ln.Name) && ln.Name[:len(ln.Name)-9] == seg {
+				found[path.Join(path.Join(segs[:i]...), ln.Name)] = ln.Cid
+			}
+		}
+		if last || len(found) == 0 {
+			return found, nil
+		}
+		cur = found[path.Join(segs[:i+1]...)]
+	}
+	return nil, nil
+}
+
+// copy puts the DAG below c into the blockstore.
+func (ps *pathSelector) copy(c cid.Cid) error {
+	if has, err := ps.bs.Has(ps.ctx, c); err != nil || has {
+		return err
+	}
+	nd, data, err := ps.node(c)
+	if err != nil {
+		return err
+	}
+	blk, err := blocks.NewBlockWithCid(data, c)
+	if err != nil {
+		return err
+	}
+	if err := ps.bs.Put(ps.ctx, blk); err != nil {
+		return err
+	}
+	if nd == nil {
+		return nil
+	}
+	for _, ln := range nd.Links() {
+		if err := ps.copy(ln.Cid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// carBlocks reads the blocks of one CAR file. Uncompressed CAR files are
+// indexed, compressed ones and stitch manifests are loaded into the
+// blockstore first.
+type carBlocks struct {
+	root  cid.Cid
+	get   func(cid.Cid) ([]byte, error)
+	has   func(cid.Cid) bool
+	close func() error
+}
+
+func openCarBlocks(ctx context.Context, carPath string, bs bstore.Blockstore, limiter *RateLimiter) (*carBlocks, error) {
+	if isStitchManifest(carPath) || isZstdFile(carPath) {
+		load := importCar
+		if isStitchManifest(carPath) {
+			load = importStitched
+		}
+		root, err := load(ctx, carPath, bs, limiter)
+		if err != nil {
+			return nil, err
+		}
+		return &carBlocks{
+			root: root,
+			get: func(c cid.Cid) ([]byte, error) {
+				blk, err := bs.Get(ctx, c)
+				if err != nil {
+					return nil, err
+				}
+				return blk.RawData(), nil
+			},
+			has: func(c cid.Cid) bool {
+				has, _ := bs.Has(ctx, c)
+				return has
+			},
+			close: func() error { return nil },
+		}, nil
+	}
+	idx, err := openCarIndex(carPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(idx.roots) != 1 {
+		idx.Close()
+		return nil, fmt.Errorf("cannot restore car with %d roots", len(idx.roots))
+	}
+	return &carBlocks{
+		root: idx.roots[0],
+		get: func(c cid.Cid) ([]byte, error) {
+			data, err := idx.get(c)
+			if err == nil {
+				limiter.WaitN(len(data))
+			}
+			return data, err
+		},
+		has: func(c cid.Cid) bool {
+			_, ok := idx.sections[c]
+			return ok
+		},
+		close: idx.Close,
+	}, nil
+}
+
+// subgraphNode copies the DAG below c into the blockstore of ps and returns
+// it as a file or directory.
+func subgraphNode(ctx context.Context, ps *pathSelector, c cid.Cid) (files.Node, error) {
+	if err := ps.copy(c); err != nil {
+		return nil, err
+	}
+	rdag := merkledag.NewDAGService(blockservice.New(ps.bs, offline.Exchange(ps.bs)))
+	nd, err := rdag.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return unixfile.NewUnixfsFile(ctx, rdag, nd)
+}
+
+// writeSubgraph writes the DAG below c to out, "-" writes a file to stdout.
+func writeSubgraph(ctx context.Context, ps *pathSelector, c cid.Cid, out string, o restoreOptions) error {
+	if out == "-" {
+		return writeFileTo(ctx, ps, c, os.Stdout, o)
+	}
+	file, err := subgraphNode(ctx, ps, c)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return nodeWriteTo(file, out, o.writeLimiter, o.decryptor, nil)
+}
+
+// writeFileTo writes the content of the file c to w.
+func writeFileTo(ctx context.Context, ps *pathSelector, c cid.Cid, w io.Writer, o restoreOptions) error {
+	file, err := subgraphNode(ctx, ps, c)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	f, ok := file.(files.File)
+	if !ok {
+		return fmt.Errorf("%s is a directory, it can only be restored to a directory", c)
+	}
+	_, err = io.Copy(o.writeLimiter.Writer(w), o.decryptor.DecryptReader(f))
+	return err
+}
+
+// RestoreCid finds the CAR file below carPath holding c, a payload cid or
+// the cid of a file or directory inside one, and writes the DAG below c to
+// outputPath, "-" writes a file to stdout.
+func RestoreCid(ctx context.Context, carPath string, c cid.Cid, outputPath string, opts ...RestoreOption) error {
+	o := newRestoreOptions(opts)
+	cars, err := restorableCars(carPath)
+	if err != nil {
+		return err
+	}
+	for _, p := range cars {
+		bs := bstore.NewBlockstore(dss.MutexWrap(datastore.NewMapDatastore()))
+		cb, err := openCarBlocks(ctx, p, bs, o.readLimiter)
+		if err != nil {
+			log.Warnf("skip %s: %s", p, err)
+			continue
+		}
+		if !cb.has(c) {
+			cb.close() //nolint:errcheck
+			continue
+		}
+		log.Infof("found %s in %s", c, p)
+		err = writeSubgraph(ctx, &pathSelector{ctx: ctx, bs: bs, get: cb.get}, c, outputPath, o)
+		cb.close() //nolint:errcheck
+		return err
+	}
+	return fmt.Errorf("%s not found in %s", c, carPath)
+}
+
+// restoreSelected writes the selected paths held by the CAR at carPath to
+// outputDir, only the blocks of the directories on the way and of the
+// selected files are read from indexed CAR files.
+func restoreSelected(ctx context.Context, carPath, outputDir string, o restoreOptions) error {
+	bs := bstore.NewBlockstore(dss.MutexWrap(datastore.NewMapDatastore()))
+	cb, err := openCarBlocks(ctx, carPath, bs, o.readLimiter)
+	if err != nil {
+		return err
+	}
+	defer cb.close() //nolint:errcheck
+
+	ps := &pathSelector{ctx: ctx, bs: bs, get: cb.get}
+	for _, p := range o.paths {
+		found, err := ps.find(cb.root, p)
+		if err != nil {
+			return err
+		}
+		for rel, c := range found {
+			log.Infof("restore %s from %s", rel, carPath)
+			out := filepath.Join(outputDir, filepath.FromSlash(rel))
+			if err := os.MkdirAll(filepath.Dir(out), 0o777); err != nil {
+				return err
+			}
+			if err := writeSubgraph(ctx, ps, c, out, o); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// files adds the files of the DAG below c to found by their path below
+// prefix.
+func (ps *pathSelector) files(c cid.Cid, prefix string, found map[string]cid.Cid) error {
+	nd, _, err := ps.node(c)
+	if err != nil {
+		return err
+	}
+	if nd != nil {
+		fsn, err := unixfs.FSNodeFromBytes(nd.Data())
+		if err != nil {
+			return err
+		}
+		if fsn.IsDir() {
+			for _, ln := range nd.Links() {
+				if err := ps.files(ln.Cid, path.Join(prefix, ln.Name), found); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	found[prefix] = c
+	return nil
+}
+
+// filePart is a file, or a part of a split file, held by a CAR file.
+type filePart struct {
+	car  string
+	path string
+	cid  cid.Cid
+	// part is the index of the part, -1 for a file which is not split
+	part int
+}
+
+// RestoreFileTo writes the single file held by the CAR files below carPath
+// to w, the parts of a split file in order. With WithRestorePaths the paths
+// have to select one file.
+func RestoreFileTo(ctx context.Context, carPath string, w io.Writer, opts ...RestoreOption) error {
+	o := newRestoreOptions(opts)
+	cars, err := restorableCars(carPath)
+	if err != nil {
+		return err
+	}
+	var parts []filePart
+	for _, p := range cars {
+		bs := bstore.NewBlockstore(dss.MutexWrap(datastore.NewMapDatastore()))
+		cb, err := openCarBlocks(ctx, p, bs, o.readLimiter)
+		if err != nil {
+			return err
+		}
+		found, err := selectFiles(&pathSelector{ctx: ctx, bs: bs, get: cb.get}, cb.root, o.paths)
+		cb.close() //nolint:errcheck
+		if err != nil {
+			return err
+		}
+		for rel, c := range found {
+			fp := filePart{car: p, path: rel, cid: c, part: -1}
+			if splitPartName.MatchString(rel) {
+				fp.path = rel[:len(rel)-9]
+				fp.part, _ = strconv.Atoi(rel[len(rel)-8:])
+			}
+			parts = append(parts, fp)
+		}
+	}
+	if len(parts) == 0 {
+		return fmt.Errorf("no file found in %s", carPath)
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].part < parts[j].part })
+	for i, fp := range parts {
+		if fp.path != parts[0].path || i > 0 && fp.part == parts[i-1].part {
+			return fmt.Errorf("%s holds more than one file, e.g. %s and %s, select one with a path", carPath, parts[0].path, fp.path)
+		}
+	}
+
+	for _, fp := range parts {
+		bs := bstore.NewBlockstore(dss.MutexWrap(datastore.NewMapDatastore()))
+		cb, err := openCarBlocks(ctx, fp.car, bs, o.readLimiter)
+		if err != nil {
+			return err
+		}
+		err = writeFileTo(ctx, &pathSelector{ctx: ctx, bs: bs, get: cb.get}, fp.cid, w, o)
+		cb.close() //nolint:errcheck
+		if err != nil {
+			return fmt.Errorf("failed to restore %s from %s: %w", fp.path, fp.car, err)
+		}
+	}
+	return nil
+}
+
+// selectFiles returns the files below root by their path, only the ones
+// below paths if there are any.
+func selectFiles(ps *pathSelector, root cid.Cid, paths []string) (map[string]cid.Cid, error) {
+	found := make(map[string]cid.Cid)
+	if len(paths) == 0 {
+		return found, ps.files(root, "", found)
+	}
+	for _, p := range paths {
+		selected, err := ps.find(root, p)
+		if err != nil {
+			return nil, err
+		}
+		for rel, c := range selected {
+			if err := ps.files(c, rel, found); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return found, nil
+}
+
+// RestoreEntry is a file, or a part of a split file, held by a CAR file.
+type RestoreEntry struct {
+	Path string `json:"path"`
+	Size uint64 `json:"size"`
+	Cid  string `json:"cid"`
+	// Car is the CAR file or stitch manifest holding the file
+	Car string `json:"car"`
+}
+
+// ListRestore returns the files restore would write from the CAR files below
+// carPath without extracting them, only the ones below the selected paths
+// with WithRestorePaths.
+func ListRestore(ctx context.Context, carPath string, opts ...RestoreOption) ([]RestoreEntry, error) {
+	o := newRestoreOptions(opts)
+	cars, err := restorableCars(carPath)
+	if err != nil {
+		return nil, err
+	}
+	var entries []RestoreEntry
+	for _, p := range cars {
+		bs := bstore.NewBlockstore(dss.MutexWrap(datastore.NewMapDatastore()))
+		cb, err := openCarBlocks(ctx, p, bs, o.readLimiter)
+		if err != nil {
+			return nil, err
+		}
+		ps := &pathSelector{ctx: ctx, bs: bs, get: cb.get}
+		found, err := selectFiles(ps, cb.root, o.paths)
+		if err == nil {
+			for rel, c := range found {
+				var size uint64
+				if size, err = ps.fileSize(c); err != nil {
+					break
+				}
+				entries = append(entries, RestoreEntry{Path: rel, Size: size, Cid: c.String(), Car: p})
+			}
+		}
+		cb.close() //nolint:errcheck
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", p, err)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Car != entries[j].Car {
+			return entries[i].Car < entries[j].Car
+		}
+		return entries[i].Path < entries[j].Path
+	})
+	return entries, nil
+}
+
+// fileSize returns the size of the file c from its root block.
+func (ps *pathSelector) fileSize(c cid.Cid) (uint64, error) {
+	nd, data, err := ps.node(c)
+	if err != nil {
+		return 0, err
+	}
+	if nd == nil {
+		return uint64(len(data)), nil
+	}
+	fsn, err := unixfs.FSNodeFromBytes(nd.Data())
+	if err != nil {
+		return 0, err
+	}
+	return fsn.FileSize(), nil
+}
@@ -0,0 +1,84 @@
+package graphsplit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	dag "github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+)
+
+func TestRestorePaths(t *testing.T) {
+	dir := t.TempDir()
+	file := func(data string) *dag.ProtoNode {
+		return dag.NodeWithData(unixfs.FilePBData([]byte(data), uint64(len(data))))
+	}
+	a, skip, part := file("keep me"), file("skip me"), file("second part")
+	sub := unixfs.EmptyDirNode()
+	sub.AddNodeLink("a.txt", a)
+	root := unixfs.EmptyDirNode()
+	root.AddNodeLink("keep", sub)
+	root.AddNodeLink("skip.txt", skip)
+	root.AddNodeLink("big.bin.00000001", part)
+	carPath := filepath.Join(dir, "test.car")
+	writeTestCar(t, carPath, []blocks.Block{root, sub, a, skip, part})
+
+	out := filepath.Join(dir, "out")
+	if err := os.Mkdir(out, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := carTo(carPath, out, 1, WithRestorePaths("/keep", "big.bin")); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"keep/a.txt": "keep me", "big.bin.00000001": "second part"} {
+		got, err := os.ReadFile(filepath.Join(out, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Fatalf("unexpected content of %s: %q", name, got)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(out, "skip.txt")); !os.IsNotExist(err) {
+		t.Fatalf("skip.txt should not be restored: %v", err)
+	}
+
+	byCid := filepath.Join(out, a.Cid().String())
+	if err := RestoreCid(context.Background(), carPath, a.Cid(), byCid); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(byCid); err != nil || string(got) != "keep me" {
+		t.Fatalf("unexpected content restored by cid: %q, %v", got, err)
+	}
+}
+
+func TestRestoreFileTo(t *testing.T) {
+	dir := t.TempDir()
+	for i, data := range []string{"first part, ", "second part"} {
+		part := dag.NodeWithData(unixfs.FilePBData([]byte(data), uint64(len(data))))
+		root := unixfs.EmptyDirNode()
+		root.AddNodeLink(fmt.Sprintf("big.bin.%08d", i), part)
+		writeTestCar(t, filepath.Join(dir, fmt.Sprintf("%d.car", i)), []blocks.Block{root, part})
+	}
+
+	var buf bytes.Buffer
+	if err := RestoreFileTo(context.Background(), dir, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "first part, second part" {
+		t.Fatalf("unexpected content %q", buf.String())
+	}
+
+	entries, err := ListRestore(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Path != "big.bin.00000000" || entries[0].Size != 12 || entries[1].Size != 11 {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+}
@@ -0,0 +1,80 @@
+package graphsplit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ShardKey picks the subdirectory of the car dir a CAR file is written to.
+type ShardKey string
+
+const (
+	// ShardByCid uses the next to last characters of the piece cid, or the
+	// payload cid without commP, like the flatfs datastore of IPFS
+	ShardByCid ShardKey = "cid"
+	// ShardByIndex puts every 1000 consecutive slices in a directory
+	ShardByIndex ShardKey = "index"
+)
+
+// slicesPerIndexShard is the number of slices in a directory with ShardByIndex.
+const slicesPerIndexShard = 1000
+
+// ParseShardKey checks the shard key given on the command line, empty means
+// cid.
+func ParseShardKey(s string) (ShardKey, error) {
+	switch k := ShardKey(strings.ToLower(s)); k {
+	case "":
+		return ShardByCid, nil
+	case ShardByCid, ShardByIndex:
+		return k, nil
+	}
+	return "", fmt.Errorf("unknown shard key %q, cid or index", s)
+}
+
+// WithOutputSharding writes the CAR files to subdirectories of the car dir
+// named with width characters of key, so no directory holds more than a few
+// thousand files. A width of 0 keeps the car dir flat.
+func WithOutputSharding(width int, key ShardKey) CallbackOption {
+	return func(o *callbackOptions) {
+		o.shardWidth = width
+		o.shardKey = key
+	}
+}
+
+// shardName returns the subdirectory of the CAR with cid c of slice index.
+func shardName(width int, key ShardKey, c string, index int) string {
+	if key == ShardByIndex {
+		if index > 0 {
+			index--
+		}
+		return fmt.Sprintf("%0*d", width, index/slicesPerIndexShard)
+	}
+	// the leading characters of cids are the same for all of them
+	c = strings.Repeat("_", width+1) + c
+	return c[len(c)-width-1 : len(c)-1]
+}
+
+// shardDir returns the directory below carDir to write the CAR with cid c of
+// slice index to and creates it.
+func (o *callbackOptions) shardDir(carDir, c string, index int) string {
+	if o.shardWidth <= 0 {
+		return carDir
+	}
+	dir := filepath.Join(carDir, shardName(o.shardWidth, o.shardKey, c, index))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.Fatalf("failed to create shard dir: %s", err)
+	}
+	return dir
+}
+
+// shardedPath returns the path of name in a shard directory of dir, empty if
+// there is none.
+func shardedPath(dir, name string) string {
+	matches, err := filepath.Glob(filepath.Join(globEscape(dir), "*", globEscape(name)))
+	if err != nil || len(matches) == 0 {
+		return ""
+	}
+	return matches[0]
+}
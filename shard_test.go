@@ -0,0 +1,56 @@
+package graphsplit
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+)
+
+func TestOutputSharding(t *testing.T) {
+	if name := shardName(2, ShardByCid, "baga6ea4seaqxyz", 7); name != "xy" {
+		t.Fatalf("unexpected cid shard %s", name)
+	}
+	if name := shardName(3, ShardByIndex, "", 1000); name != "000" {
+		t.Fatalf("unexpected index shard %s", name)
+	}
+	if name := shardName(3, ShardByIndex, "", 1001); name != "001" {
+		t.Fatalf("unexpected index shard %s", name)
+	}
+
+	dir := t.TempDir()
+	blk := blocks.NewBlock(bytes.Repeat([]byte("sharded"), 200))
+	carPath := filepath.Join(dir, blk.Cid().String()+".car")
+	writeTestCar(t, carPath, []blocks.Block{blk})
+	res, err := CalcCommP(context.Background(), carPath, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := callbackOptions{}
+	WithOutputSharding(2, ShardByCid)(&o)
+	shard := o.shardDir(dir, res.Root.String(), 1)
+	if err := os.Rename(carPath, filepath.Join(shard, res.Root.String()+".car")); err != nil {
+		t.Fatal(err)
+	}
+	row := map[string]string{
+		"payload_cid":  blk.Cid().String(),
+		"piece_cid":    res.Root.String(),
+		"payload_size": strconv.FormatInt(res.PayloadSize, 10),
+		"piece_size":   strconv.FormatUint(uint64(res.Size), 10),
+	}
+	if err := appendManifest(dir, []string{"payload_cid", "piece_cid", "payload_size", "piece_size"}, row); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := VerifyCarDir(context.Background(), dir, -1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Results) != 1 || report.Results[0].Status != VerifyOK || filepath.Dir(report.Results[0].Path) != shard {
+		t.Fatalf("unexpected results %+v", report.Results)
+	}
+}
@@ -0,0 +1,125 @@
+package graphsplit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/go-units"
+)
+
+// SliceSizeStateFileName keeps the runs of the increment and list slice size
+// strategies in the car dir.
+const SliceSizeStateFileName = "slice-size-state.json"
+
+// The slice size strategies.
+const (
+	// SliceSizeFixed uses the slice size for every run
+	SliceSizeFixed = "fixed"
+	// SliceSizeIncrement adds a byte to the slice size with every run
+	SliceSizeIncrement = "increment"
+	// SliceSizeList uses the sizes of a list in turn, one per run
+	SliceSizeList = "list"
+	// SliceSizeJitter picks the size of every slice within slice size ±N
+	SliceSizeJitter = "jitter"
+)
+
+// SliceSizeStrategy picks the slice size of a chunk run, every iteration of
+// loop chunking is a run.
+type SliceSizeStrategy struct {
+	Kind string
+	// Size is the fixed size, the size of the first increment run and the
+	// middle of jitter
+	Size   int64
+	Sizes  []int64
+	Jitter int64
+}
+
+// ParseSliceSizeStrategy parses strategy, fixed (or empty), increment, list
+// with the sizes, or jitter±N like jitter±1GiB, also written jitter+-1GiB.
+func ParseSliceSizeStrategy(strategy string, size int64, sizes []string) (*SliceSizeStrategy, error) {
+	s := &SliceSizeStrategy{Kind: strategy, Size: size}
+	switch {
+	case strategy == "" || strategy == SliceSizeFixed:
+		s.Kind = SliceSizeFixed
+	case strategy == SliceSizeIncrement:
+	case strategy == SliceSizeList:
+		if len(sizes) == 0 {
+			return nil, fmt.Errorf("slice size strategy list needs SliceSizes")
+		}
+		for _, v := range sizes {
+			n, err := units.RAMInBytes(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid slice size %q: %v", v, err)
+			}
+			if n <= 0 {
+				return nil, fmt.Errorf("invalid slice size %q", v)
+			}
+			s.Sizes = append(s.Sizes, n)
+		}
+	case strings.HasPrefix(strategy, SliceSizeJitter):
+		n := strings.TrimPrefix(strategy, SliceSizeJitter)
+		if !strings.HasPrefix(n, "±") && !strings.HasPrefix(n, "+-") {
+			return nil, fmt.Errorf("invalid slice size strategy %q, expect jitter±N, e.g. jitter±1GiB", strategy)
+		}
+		n = strings.TrimPrefix(strings.TrimPrefix(n, "±"), "+-")
+		jitter, err := units.RAMInBytes(n)
+		if err != nil {
+			return nil, fmt.Errorf("invalid slice size strategy %q: %v", strategy, err)
+		}
+		if jitter <= 0 || jitter >= size {
+			return nil, fmt.Errorf("jitter of slice size strategy %q has to be greater than 0 and less than the slice size", strategy)
+		}
+		s.Kind, s.Jitter = SliceSizeJitter, jitter
+	default:
+		return nil, fmt.Errorf("unknown slice size strategy %q, expect fixed, increment, list or jitter±N", strategy)
+	}
+	if s.Kind != SliceSizeList && size <= 0 {
+		return nil, fmt.Errorf("slice size has been set as %d", size)
+	}
+	return s, nil
+}
+
+type sliceSizeState struct {
+	Strategy string `json:"strategy"`
+	// Runs is the number of runs started with the strategy
+	Runs int64 `json:"runs"`
+}
+
+// Next returns the smallest and the largest slice size of the next run in
+// carDir. Increment and list record the run in SliceSizeStateFileName.
+func (s *SliceSizeStrategy) Next(carDir string) (int64, int64, error) {
+	switch s.Kind {
+	case SliceSizeFixed:
+		return s.Size, s.Size, nil
+	case SliceSizeJitter:
+		return s.Size - s.Jitter, s.Size + s.Jitter, nil
+	}
+	path := filepath.Join(carDir, SliceSizeStateFileName)
+	var state sliceSizeState
+	if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &state); err != nil {
+			return 0, 0, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return 0, 0, err
+	}
+	if state.Strategy != s.Kind {
+		state = sliceSizeState{Strategy: s.Kind}
+	}
+	size := s.Size + state.Runs
+	if s.Kind == SliceSizeList {
+		size = s.Sizes[state.Runs%int64(len(s.Sizes))]
+	}
+	state.Runs++
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return 0, 0, err
+	}
+	return size, size, nil
+}
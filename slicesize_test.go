@@ -0,0 +1,71 @@
+package graphsplit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSliceSizeStrategy(t *testing.T) {
+	dir := t.TempDir()
+	next := func(s *SliceSizeStrategy) (int64, int64) {
+		min, max, err := s.Next(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return min, max
+	}
+
+	fixed, err := ParseSliceSizeStrategy("", 1000, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if min, max := next(fixed); min != 1000 || max != 1000 {
+			t.Fatalf("expected fixed 1000, got %d-%d", min, max)
+		}
+	}
+
+	inc, err := ParseSliceSizeStrategy("increment", 1000, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for want := int64(1000); want < 1003; want++ {
+		if min, _ := next(inc); min != want {
+			t.Fatalf("expected %d, got %d", want, min)
+		}
+	}
+
+	list, err := ParseSliceSizeStrategy("list", 0, []string{"1KiB", "2KiB"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []int64{1024, 2048, 1024} {
+		if min, max := next(list); min != want || max != want {
+			t.Fatalf("expected %d, got %d-%d", want, min, max)
+		}
+	}
+	state := filepath.Join(dir, SliceSizeStateFileName)
+	if _, err := os.Stat(state); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(state + TmpSuffix); !os.IsNotExist(err) {
+		t.Fatalf("expected no temporary state left, got %v", err)
+	}
+
+	for _, strategy := range []string{"jitter±100", "jitter+-100"} {
+		jitter, err := ParseSliceSizeStrategy(strategy, 1000, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if min, max := next(jitter); min != 900 || max != 1100 {
+			t.Fatalf("expected 900-1100 for %s, got %d-%d", strategy, min, max)
+		}
+	}
+
+	for _, strategy := range []string{"jitter100", "jitter±1000", "list", "random"} {
+		if _, err := ParseSliceSizeStrategy(strategy, 1000, nil); err == nil {
+			t.Fatalf("expected an error for %q", strategy)
+		}
+	}
+}
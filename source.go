@@ -0,0 +1,91 @@
+package graphsplit
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+)
+
+// Source provides the files to chunk when they are not read from the local
+// filesystem, like the objects of a bucket.
+type Source interface {
+	// Root is the path the paths of the listed files are below, it takes
+	// the place of the parent path
+	Root() string
+	List(ctx context.Context) ([]Finfo, error)
+	// Open reads the bytes [start, end) of the file
+	Open(item Finfo, start, end int64) (io.ReadCloser, error)
+}
+
+// remoteFileInfo is the os.FileInfo of a file listed by a Source.
+type remoteFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (fi remoteFileInfo) Name() string       { return fi.name }
+func (fi remoteFileInfo) Size() int64        { return fi.size }
+func (fi remoteFileInfo) Mode() os.FileMode  { return 0o444 }
+func (fi remoteFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi remoteFileInfo) IsDir() bool        { return false }
+func (fi remoteFileInfo) Sys() interface{}   { return nil }
+
+// openItem opens the part of the file item covers, from src if it is set and
+// from the local filesystem otherwise.
+func openItem(item Finfo, src Source) (io.ReadCloser, error) {
+	if item.member != nil {
+		start, end := item.byteRange()
+		return item.member.open(start, end)
+	}
+	if src == nil {
+		f, err := os.Open(item.Path)
+		if err != nil {
+			return nil, err
+		}
+		if item.SeekStart > 0 || item.SeekEnd > 0 {
+			return struct {
+				io.Reader
+				io.Closer
+			}{&fileSlice{r: f, start: item.SeekStart, end: item.SeekEnd, fileSize: item.Info.Size()}, f}, nil
+		}
+		return f, nil
+	}
+	start, end := item.byteRange()
+	return src.Open(item, start, end)
+}
+
+// byteRange returns the bytes [start, end) of the file item covers.
+func (fi Finfo) byteRange() (int64, int64) {
+	start, end := int64(0), fi.Info.Size()
+	if fi.SeekStart > 0 || fi.SeekEnd > 0 {
+		start = fi.SeekStart
+		// SeekEnd is inclusive, 0 means the end of the file
+		if fi.SeekEnd > 0 {
+			end = fi.SeekEnd + 1
+		}
+	}
+	return start, end
+}
+
+// Locator is implemented by sources which can tell where a file was read
+// from, the locations are recorded in the manifest.
+type Locator interface {
+	Locate(item Finfo) string
+}
+
+// sourceLocations returns the distinct locations of the files read from src.
+func sourceLocations(src Source, files []Finfo) []string {
+	locator, ok := src.(Locator)
+	if !ok {
+		return nil
+	}
+	var locations []string
+	for _, item := range files {
+		if loc := locator.Locate(item); loc != "" {
+			locations = appendUnique(locations, loc)
+		}
+	}
+	return locations
+}
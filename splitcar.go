@@ -0,0 +1,228 @@
+package graphsplit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+)
+
+// StitchSuffix names the stitch manifest of a split CAR.
+const StitchSuffix = ".stitch.json"
+
+// StitchManifest lists the parts a CAR was split into, restore loads all of
+// them to reassemble the DAG of Roots.
+type StitchManifest struct {
+	Version int          `json:"version"`
+	Source  string       `json:"source"`
+	Roots   []string     `json:"roots"`
+	Parts   []StitchPart `json:"parts"`
+}
+
+// StitchPart is a sub CAR of a split CAR, File is relative to the manifest.
+type StitchPart struct {
+	File        string `json:"file"`
+	Blocks      int    `json:"blocks"`
+	PieceCid    string `json:"piece_cid"`
+	PieceSize   uint64 `json:"piece_size"`
+	PayloadSize int64  `json:"payload_size"`
+}
+
+// isStitchManifest reports whether path is the stitch manifest of a split CAR.
+func isStitchManifest(path string) bool {
+	return strings.HasSuffix(path, StitchSuffix)
+}
+
+// SplitCar divides the CAR at carPath at block boundaries into sub CARs of at
+// most maxSize bytes in outDir, all with the roots of the original, computes
+// the piece cid of each part and writes a stitch manifest next to them.
+func SplitCar(ctx context.Context, carPath, outDir string, maxSize int64) (*StitchManifest, error) {
+	f, err := openCar(carPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() //nolint:errcheck
+
+	cr, err := car.NewCarReader(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("not a car file: %w", err)
+	}
+	header := &car.CarHeader{Roots: cr.Header.Roots, Version: 1}
+	headerSize, err := car.HeaderSize(header)
+	if err != nil {
+		return nil, err
+	}
+	if int64(headerSize) >= maxSize {
+		return nil, fmt.Errorf("part size %d is too small for the car header", maxSize)
+	}
+
+	base := strings.TrimSuffix(filepath.Base(carPath), compressionExt(CompressZstd))
+	base = strings.TrimSuffix(base, ".car")
+	sm := &StitchManifest{Version: 1, Source: filepath.Base(carPath)}
+	for _, root := range cr.Header.Roots {
+		sm.Roots = append(sm.Roots, root.String())
+	}
+
+	var (
+		part     *atomicFile
+		w        *bufio.Writer
+		partSize int64
+	)
+	finish := func() error {
+		if part == nil {
+			return nil
+		}
+		if err := w.Flush(); err != nil {
+			part.Abort()
+			return err
+		}
+		if err := part.Commit(); err != nil {
+			return err
+		}
+		part = nil
+		return nil
+	}
+	for {
+		blk, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if part != nil {
+				part.Abort()
+			}
+			return nil, err
+		}
+		size := int64(carutil.LdSize(blk.Cid().Bytes(), blk.RawData()))
+		if int64(headerSize)+size > maxSize {
+			if part != nil {
+				part.Abort()
+			}
+			return nil, fmt.Errorf("block %s of %d bytes does not fit in a part of %d bytes", blk.Cid(), size, maxSize)
+		}
+		if part == nil || partSize+size > maxSize {
+			if err := finish(); err != nil {
+				return nil, err
+			}
+			name := fmt.Sprintf("%s-part-%03d.car", base, len(sm.Parts)+1)
+			if part, err = createAtomic(filepath.Join(outDir, name)); err != nil {
+				return nil, err
+			}
+			w = bufio.NewWriter(part)
+			if err := car.WriteHeader(header, w); err != nil {
+				part.Abort()
+				return nil, err
+			}
+			partSize = int64(headerSize)
+			sm.Parts = append(sm.Parts, StitchPart{File: name})
+		}
+		if err := carutil.LdWrite(w, blk.Cid().Bytes(), blk.RawData()); err != nil {
+			part.Abort()
+			return nil, err
+		}
+		partSize += size
+		sm.Parts[len(sm.Parts)-1].Blocks++
+	}
+	if err := finish(); err != nil {
+		return nil, err
+	}
+
+	for i := range sm.Parts {
+		p := &sm.Parts[i]
+		res, err := CalcCommP(ctx, filepath.Join(outDir, p.File), false, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to calculate piece cid of %s: %w", p.File, err)
+		}
+		p.PieceCid = res.Root.String()
+		p.PieceSize = uint64(res.Size.Padded())
+		p.PayloadSize = res.PayloadSize
+		log.Infof("%s: piece cid %s, piece size %d, %d blocks", p.File, p.PieceCid, p.PieceSize, p.Blocks)
+	}
+
+	data, err := json.MarshalIndent(sm, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	out, err := createAtomic(filepath.Join(outDir, base+StitchSuffix))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := out.Write(data); err != nil {
+		out.Abort()
+		return nil, err
+	}
+	return sm, out.Commit()
+}
+
+// ReadStitchManifest loads the stitch manifest at path.
+func ReadStitchManifest(path string) (*StitchManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sm StitchManifest
+	if err := json.Unmarshal(data, &sm); err != nil {
+		return nil, fmt.Errorf("invalid stitch manifest %s: %w", path, err)
+	}
+	if len(sm.Roots) != 1 {
+		return nil, fmt.Errorf("cannot restore a stitched car with %d roots", len(sm.Roots))
+	}
+	return &sm, nil
+}
+
+// importStitched loads every part of the stitch manifest at path into st and
+// returns the root of the original CAR.
+func importStitched(ctx context.Context, path string, st car.Store, limiter *RateLimiter) (cid.Cid, error) {
+	sm, err := ReadStitchManifest(path)
+	if err != nil {
+		return cid.Undef, err
+	}
+	root, err := cid.Decode(sm.Roots[0])
+	if err != nil {
+		return cid.Undef, err
+	}
+	for _, p := range sm.Parts {
+		if err := loadCarBlocks(ctx, filepath.Join(filepath.Dir(path), p.File), st, limiter); err != nil {
+			return cid.Undef, fmt.Errorf("failed to load part %s: %w", p.File, err)
+		}
+	}
+	return root, nil
+}
+
+func loadCarBlocks(ctx context.Context, path string, st car.Store, limiter *RateLimiter) error {
+	f, err := openCar(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close() //nolint:errcheck
+	_, err = loadCar(ctx, st, limiter.Reader(f))
+	return err
+}
+
+// stitchedParts returns the part files of the stitch manifests below root,
+// restore reads them through their manifest instead of on their own.
+func stitchedParts(root string) (map[string]bool, error) {
+	parts := make(map[string]bool)
+	err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() || !isStitchManifest(path) {
+			return err
+		}
+		sm, err := ReadStitchManifest(path)
+		if err != nil {
+			return err
+		}
+		for _, p := range sm.Parts {
+			parts[filepath.Join(filepath.Dir(path), p.File)] = true
+		}
+		return nil
+	})
+	return parts, err
+}
@@ -0,0 +1,81 @@
+package graphsplit
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+)
+
+type memStore map[cid.Cid][]byte
+
+func (ms memStore) Put(_ context.Context, blk blocks.Block) error {
+	ms[blk.Cid()] = blk.RawData()
+	return nil
+}
+
+// writeTestCar writes blks to a CAR at path with the first block as root.
+func writeTestCar(t *testing.T, path string, blks []blocks.Block) {
+	var buf bytes.Buffer
+	if err := car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{blks[0].Cid()}, Version: 1}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, blk := range blks {
+		if err := carutil.LdWrite(&buf, blk.Cid().Bytes(), blk.RawData()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSplitCar(t *testing.T) {
+	dir := t.TempDir()
+	var blks []blocks.Block
+	for i := 0; i < 10; i++ {
+		blks = append(blks, blocks.NewBlock(bytes.Repeat([]byte{byte(i)}, 1000)))
+	}
+	carPath := filepath.Join(dir, "big.car")
+	writeTestCar(t, carPath, blks)
+
+	ctx := context.Background()
+	sm, err := SplitCar(ctx, carPath, dir, 3500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sm.Parts) != 4 {
+		t.Fatalf("expected 4 parts, got %d", len(sm.Parts))
+	}
+	for _, p := range sm.Parts {
+		st, err := os.Stat(filepath.Join(dir, p.File))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.Size() > 3500 || p.PieceCid == "" {
+			t.Fatalf("unexpected part %+v of %d bytes", p, st.Size())
+		}
+	}
+
+	ms := make(memStore)
+	root, err := importStitched(ctx, filepath.Join(dir, "big"+StitchSuffix), ms, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root != blks[0].Cid() || len(ms) != len(blks) {
+		t.Fatalf("unexpected root %s or %d blocks", root, len(ms))
+	}
+	parts, err := stitchedParts(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 4 || parts[carPath] {
+		t.Fatalf("unexpected stitched parts %v", parts)
+	}
+}
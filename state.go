@@ -0,0 +1,190 @@
+package graphsplit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const PackStateFileName = "pack-state.json"
+
+// PackedFile is the state of a source file at the time it was packed.
+type PackedFile struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	// Sha256 is only recorded when checksums are enabled
+	Sha256 string   `json:"sha256,omitempty"`
+	Pieces []string `json:"pieces"`
+	// Partial is set while only leading parts of the file have been packed
+	Partial bool `json:"partial,omitempty"`
+}
+
+// PackedPiece is the state of a produced piece, identified by payload cid.
+type PackedPiece struct {
+	CreatedAt time.Time `json:"created_at"`
+	// Stale is set once a file of the piece has changed in the source
+	Stale      bool     `json:"stale,omitempty"`
+	StaleFiles []string `json:"stale_files,omitempty"`
+	// Replaces lists the stale pieces holding older versions of its files
+	Replaces []string `json:"replaces,omitempty"`
+}
+
+// PackState records which source files have been packed into which pieces,
+// so incremental runs only chunk new and changed files.
+type PackState struct {
+	mu       sync.Mutex
+	path     string
+	checksum bool
+
+	Files  map[string]*PackedFile  `json:"files"`
+	Pieces map[string]*PackedPiece `json:"pieces"`
+	// Lineage holds the previous pieces of changed files, by path, until the
+	// new version is packed, so a crash in between does not lose them
+	Lineage map[string][]string `json:"lineage,omitempty"`
+}
+
+// OpenPackState loads pack-state.json of carDir. With checksum, files whose
+// size or modification time changed are only considered changed when their
+// content does.
+func OpenPackState(carDir string, checksum bool) (*PackState, error) {
+	ps := &PackState{
+		path:     filepath.Join(carDir, PackStateFileName),
+		checksum: checksum,
+		Files:    make(map[string]*PackedFile),
+		Pieces:   make(map[string]*PackedPiece),
+		Lineage:  make(map[string][]string),
+	}
+	data, err := os.ReadFile(ps.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ps, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, ps); err != nil {
+		return nil, err
+	}
+	if ps.Lineage == nil {
+		ps.Lineage = make(map[string][]string)
+	}
+	return ps, nil
+}
+
+// Pending returns the files which are new or changed since they were packed,
+// the pieces holding older versions of changed files are flagged as stale.
+func (ps *PackState) Pending(files []Finfo) ([]Finfo, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	var pending []Finfo
+	for _, item := range files {
+		packed, ok := ps.Files[item.Path]
+		if !ok {
+			pending = append(pending, item)
+			continue
+		}
+		unchanged := packed.Size == item.Info.Size() && packed.ModTime.Equal(item.Info.ModTime())
+		if unchanged && !packed.Partial {
+			continue
+		}
+		if !packed.Partial && ps.checksum && packed.Sha256 != "" {
+			sum, err := fileSha256(item.Path)
+			if err != nil {
+				return nil, err
+			}
+			if sum == packed.Sha256 {
+				packed.Size = item.Info.Size()
+				packed.ModTime = item.Info.ModTime()
+				continue
+			}
+		}
+		if packed.Partial {
+			log.Infof("%s was partially packed into %v, packing it again", item.Path, packed.Pieces)
+		} else {
+			log.Infof("%s changed since it was packed into %v", item.Path, packed.Pieces)
+		}
+		for _, piece := range packed.Pieces {
+			if pp, ok := ps.Pieces[piece]; ok {
+				pp.Stale = true
+				pp.StaleFiles = appendUnique(pp.StaleFiles, item.Path)
+			}
+		}
+		for _, piece := range packed.Pieces {
+			ps.Lineage[item.Path] = appendUnique(ps.Lineage[item.Path], piece)
+		}
+		delete(ps.Files, item.Path)
+		pending = append(pending, item)
+	}
+	return pending, ps.save()
+}
+
+// RecordSlice records that the files of a slice were packed into the piece.
+// Parts of a file packed into several slices accumulate their pieces.
+func (ps *PackState) RecordSlice(payloadCid string, files []Finfo) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	piece := &PackedPiece{CreatedAt: time.Now()}
+	for _, item := range files {
+		packed, ok := ps.Files[item.Path]
+		if !ok {
+			packed = &PackedFile{
+				Size:    item.Info.Size(),
+				ModTime: item.Info.ModTime(),
+			}
+			if ps.checksum {
+				sum, err := fileSha256(item.Path)
+				if err != nil {
+					return err
+				}
+				packed.Sha256 = sum
+			}
+			ps.Files[item.Path] = packed
+		}
+		packed.Pieces = appendUnique(packed.Pieces, payloadCid)
+		packed.Partial = item.SeekEnd > 0 && item.SeekEnd < item.Info.Size()-1
+		for _, old := range ps.Lineage[item.Path] {
+			piece.Replaces = appendUnique(piece.Replaces, old)
+		}
+		if !packed.Partial {
+			delete(ps.Lineage, item.Path)
+		}
+	}
+	ps.Pieces[payloadCid] = piece
+	return ps.save()
+}
+
+func (ps *PackState) save() error {
+	data, err := json.MarshalIndent(ps, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(ps.path, data)
+}
+
+func fileSha256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
@@ -0,0 +1,86 @@
+package graphsplit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIncrementalChunk(t *testing.T) {
+	dir := writeTestTree(t, 10<<10, 20<<10, 30<<10)
+	carDir := t.TempDir()
+	chunk := func(checksum bool) []ManifestRow {
+		state, err := OpenPackState(carDir, checksum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return chunkTestTree(t, dir, &ChunkParams{ExpectSliceSize: 1 << 20, CarDir: carDir, State: state})
+	}
+
+	rows := chunk(false)
+	if len(rows) != 1 {
+		t.Fatalf("expected a slice of all files, got %d", len(rows))
+	}
+	first := rows[0]["payload_cid"]
+	if rows = chunk(false); len(rows) != 1 {
+		t.Fatalf("expected no new slice without changes, got %d rows", len(rows))
+	}
+
+	changed := filepath.Join(dir, "sub1", "file1")
+	if err := os.WriteFile(changed, []byte("new content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// a crash after the changed file was found keeps its lineage
+	state, err := OpenPackState(carDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []Finfo
+	for item := range GetFileListAsync([]string{dir}) {
+		files = append(files, item)
+	}
+	if _, err := state.Pending(files); err != nil {
+		t.Fatal(err)
+	}
+	if state, err = OpenPackState(carDir, false); err != nil {
+		t.Fatal(err)
+	}
+	if old := state.Lineage[changed]; len(old) != 1 || old[0] != first {
+		t.Fatalf("expected the lineage of %s to be saved, got %v", changed, state.Lineage)
+	}
+	rows = chunk(false)
+	if len(rows) != 2 {
+		t.Fatalf("expected a new slice of the changed file, got %d rows", len(rows))
+	}
+	second := rows[1]["payload_cid"]
+	if state, err = OpenPackState(carDir, false); err != nil {
+		t.Fatal(err)
+	}
+	if old := state.Pieces[first]; !old.Stale || len(old.StaleFiles) != 1 || old.StaleFiles[0] != changed {
+		t.Fatalf("expected %s to be stale because of %s, got %+v", first, changed, old)
+	}
+	if piece := state.Pieces[second]; len(piece.Replaces) != 1 || piece.Replaces[0] != first {
+		t.Fatalf("expected %s to replace %s, got %+v", second, first, piece)
+	}
+	if packed := state.Files[changed]; len(packed.Pieces) != 1 || packed.Pieces[0] != second {
+		t.Fatalf("expected %s to be packed into %s, got %+v", changed, second, packed)
+	}
+	if len(state.Lineage) != 0 {
+		t.Fatalf("expected the lineage to be dropped once the file is packed, got %v", state.Lineage)
+	}
+
+	// with checksums a touched file with the same content is not chunked again
+	carDir = t.TempDir()
+	if rows = chunk(true); len(rows) != 1 {
+		t.Fatalf("expected a slice of all files, got %d", len(rows))
+	}
+	touched := filepath.Join(dir, "sub0", "file0")
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(touched, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if rows = chunk(true); len(rows) != 1 {
+		t.Fatalf("expected no new slice for a touched file, got %d rows", len(rows))
+	}
+}
@@ -0,0 +1,125 @@
+package graphsplit
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"time"
+)
+
+// streamRoot is the parent path of the file read from ChunkParams.Stream.
+const streamRoot = "stdin"
+
+// streamSource reads consecutive byte ranges of a stream, every slice holds
+// one file with the next range.
+type streamSource struct {
+	r      *bufio.Reader
+	name   string
+	offset int64
+}
+
+func newStreamSource(r io.Reader, name string) *streamSource {
+	return &streamSource{r: bufio.NewReaderSize(r, int(UnixfsChunkSize)), name: name}
+}
+
+func (s *streamSource) Root() string {
+	return streamRoot
+}
+
+func (s *streamSource) List(ctx context.Context) ([]Finfo, error) {
+	return nil, fmt.Errorf("a stream can not be listed")
+}
+
+func (s *streamSource) Open(item Finfo, start, end int64) (io.ReadCloser, error) {
+	if start != s.offset {
+		return nil, fmt.Errorf("stream is at offset %d, can not read from %d", s.offset, start)
+	}
+	return io.NopCloser(&streamPart{s: s, r: io.LimitReader(s.r, end-start)}), nil
+}
+
+// Locate returns the byte range of the stream item was built from, the last
+// range ends where the stream ended.
+func (s *streamSource) Locate(item Finfo) string {
+	end := item.SeekEnd
+	if end >= s.offset {
+		end = s.offset - 1
+	}
+	return fmt.Sprintf("%s:%d-%d", streamRoot, item.SeekStart, end)
+}
+
+// next returns the item of the next range of at most size bytes, false once
+// the stream is exhausted.
+func (s *streamSource) next(size int64, count int) (Finfo, bool, error) {
+	if _, err := s.r.Peek(1); err == io.EOF {
+		return Finfo{}, false, nil
+	} else if err != nil {
+		return Finfo{}, false, err
+	}
+	return Finfo{
+		Path:      path.Join(streamRoot, s.name),
+		Name:      fmt.Sprintf("%s.%08d", s.name, count),
+		Info:      remoteFileInfo{name: s.name, size: s.offset + size, modTime: time.Now()},
+		SeekStart: s.offset,
+		SeekEnd:   s.offset + size - 1,
+	}, true, nil
+}
+
+type streamPart struct {
+	s *streamSource
+	r io.Reader
+}
+
+func (p *streamPart) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.s.offset += int64(n)
+	return n, err
+}
+
+// chunkStream builds slices from consecutive ranges of params.Stream until
+// it ends, the ranges are recorded as the sources of the slices.
+func chunkStream(ctx context.Context, params *ChunkParams) error {
+	if params.Source != nil || params.State != nil || params.DedupExtra || (params.Ef != nil && len(params.Ef.files) > 0) {
+		return fmt.Errorf("stream input can not be combined with a source, incremental state or extra files")
+	}
+	src := newStreamSource(params.Stream, params.GraphName)
+	params.Source = src
+	params.TargetPath = streamRoot
+	params.ParentPath = streamRoot
+	params.parallel = 1
+	defer func() {
+		params.Source = nil
+	}()
+
+	for count := 0; ; count++ {
+		if err := params.Control.checkpoint(); err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		sliceSize := params.pickSliceSize()
+		if err := params.checkFreeSpace(sliceSize); err != nil {
+			return err
+		}
+		item, ok, err := src.next(sliceSize, count)
+		if err != nil {
+			return fmt.Errorf("failed to read stream: %w", err)
+		}
+		if !ok {
+			log.Infof("stream ended after %d bytes in %d slices", src.offset, count)
+			return nil
+		}
+		graphName := fmt.Sprintf("%s-part-%d.car", params.GraphName, count+1)
+		params.sliceIndex = count + 1
+		if BuildIpldGraph(ctx, []Finfo{item}, graphName, sliceSize, params) == "" {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			return fmt.Errorf("failed to build slice %s", graphName)
+		}
+		log.Infof("%s: %s", graphName, src.Locate(item))
+		log.Infof("=================")
+	}
+}
@@ -0,0 +1,46 @@
+package graphsplit
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestStreamSourceRanges(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 25)
+	src := newStreamSource(bytes.NewReader(data), "data")
+
+	var got []byte
+	var locations []string
+	for count := 0; ; count++ {
+		item, ok, err := src.next(100, count)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+		r, err := openItem(item, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, part...)
+		locations = append(locations, src.Locate(item))
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("stream ranges do not add up to the stream")
+	}
+	want := []string{"stdin:0-99", "stdin:100-199", "stdin:200-249"}
+	if len(locations) != len(want) {
+		t.Fatalf("unexpected locations %v", locations)
+	}
+	for i := range want {
+		if locations[i] != want[i] {
+			t.Fatalf("unexpected locations %v", locations)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package graphsplit
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TracingConfig exports the spans of chunk, commP, the callbacks and restore
+// to an OpenTelemetry collector with OTLP over HTTP in JSON.
+type TracingConfig struct {
+	// Endpoint is the base URL of the collector, e.g. http://localhost:4318,
+	// spans are posted to /v1/traces below it
+	Endpoint string
+	// ServiceName is the service.name of the spans, graphsplit if empty
+	ServiceName string
+	// Headers are sent with every export, e.g. for authentication
+	Headers map[string]string
+}
+
+const (
+	traceBatchSize     = 512
+	traceFlushInterval = 5 * time.Second
+)
+
+// tracer batches finished spans and exports them, there is one while tracing
+// is enabled.
+type tracer struct {
+	cfg    TracingConfig
+	client *http.Client
+	mu     sync.Mutex
+	spans  []*span
+	flush  chan struct{}
+	done   chan struct{}
+}
+
+var (
+	tracerMu     sync.RWMutex
+	activeTracer *tracer
+)
+
+// EnableTracing exports spans to the collector of cfg until the returned
+// shutdown function is called, which exports the spans still pending.
+func EnableTracing(cfg TracingConfig) (func(ctx context.Context) error, error) {
+	if cfg.Endpoint == "" {
+		return nil, fmt.Errorf("tracing endpoint is required")
+	}
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = "graphsplit"
+	}
+	cfg.Endpoint = strings.TrimSuffix(cfg.Endpoint, "/")
+	t := &tracer{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+		flush:  make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	tracerMu.Lock()
+	activeTracer = t
+	tracerMu.Unlock()
+
+	stop := make(chan struct{})
+	go t.run(stop)
+	return func(ctx context.Context) error {
+		tracerMu.Lock()
+		if activeTracer == t {
+			activeTracer = nil
+		}
+		tracerMu.Unlock()
+		close(stop)
+		select {
+		case <-t.done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}, nil
+}
+
+func (t *tracer) run(stop chan struct{}) {
+	defer close(t.done)
+	ticker := time.NewTicker(traceFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.flush:
+		case <-stop:
+			t.export()
+			return
+		}
+		t.export()
+	}
+}
+
+func (t *tracer) finish(s *span) {
+	t.mu.Lock()
+	t.spans = append(t.spans, s)
+	full := len(t.spans) >= traceBatchSize
+	t.mu.Unlock()
+	if full {
+		select {
+		case t.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// export posts the pending spans, failures are only logged.
+func (t *tracer) export() {
+	t.mu.Lock()
+	spans := t.spans
+	t.spans = nil
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+	body, err := json.Marshal(t.request(spans))
+	if err != nil {
+		log.Warnf("failed to encode spans: %s", err)
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, t.cfg.Endpoint+"/v1/traces", bytes.NewReader(body))
+	if err != nil {
+		log.Warnf("failed to export spans: %s", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		log.Warnf("failed to export %d spans: %s", len(spans), err)
+		return
+	}
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Warnf("collector rejected %d spans: %s: %s", len(spans), resp.Status, respBody)
+	}
+}
+
+// request returns the OTLP JSON export request of spans.
+func (t *tracer) request(spans []*span) map[string]interface{} {
+	otlpSpans := make([]map[string]interface{}, 0, len(spans))
+	for _, s := range spans {
+		otlpSpans = append(otlpSpans, s.otlp())
+	}
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes(map[string]interface{}{"service.name": t.cfg.ServiceName}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": "github.com/filedrive-team/go-graphsplit"},
+				"spans": otlpSpans,
+			}},
+		}},
+	}
+}
+
+// span is a timed operation of a trace, a nil span records nothing.
+type span struct {
+	tracer  *tracer
+	traceID [16]byte
+	spanID  [8]byte
+	parent  [8]byte
+	name    string
+	start   time.Time
+	end     time.Time
+	mu      sync.Mutex
+	attrs   map[string]interface{}
+	err     error
+}
+
+type spanKey struct{}
+
+// startSpan starts a span named name, a child of the span of ctx if there is
+// one, and returns a context holding it. attrs are key value pairs.
+func startSpan(ctx context.Context, name string, attrs ...interface{}) (context.Context, *span) {
+	tracerMu.RLock()
+	t := activeTracer
+	tracerMu.RUnlock()
+	if t == nil {
+		return ctx, nil
+	}
+	s := &span{tracer: t, name: name, start: time.Now(), attrs: make(map[string]interface{})}
+	if parent, ok := ctx.Value(spanKey{}).(*span); ok && parent != nil {
+		s.traceID, s.parent = parent.traceID, parent.spanID
+	} else {
+		rand.Read(s.traceID[:]) //nolint:errcheck
+	}
+	rand.Read(s.spanID[:]) //nolint:errcheck
+	s.set(attrs...)
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// set adds the key value pairs of attrs to the span.
+func (s *span) set(attrs ...interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i+1 < len(attrs); i += 2 {
+		s.attrs[fmt.Sprint(attrs[i])] = attrs[i+1]
+	}
+}
+
+// End finishes the span, failed with err if it is not nil.
+func (s *span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.end = time.Now()
+	s.err = err
+	s.mu.Unlock()
+	s.tracer.finish(s)
+}
+
+func (s *span) otlp() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := map[string]interface{}{
+		"traceId":           hex.EncodeToString(s.traceID[:]),
+		"spanId":            hex.EncodeToString(s.spanID[:]),
+		"name":              s.name,
+		"kind":              1, // internal
+		"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+		"attributes":        otlpAttributes(s.attrs),
+	}
+	if s.parent != [8]byte{} {
+		out["parentSpanId"] = hex.EncodeToString(s.parent[:])
+	}
+	if s.err != nil {
+		out["status"] = map[string]interface{}{"code": 2, "message": s.err.Error()}
+	}
+	return out
+}
+
+func otlpAttributes(attrs map[string]interface{}) []interface{} {
+	out := make([]interface{}, 0, len(attrs))
+	for _, k := range sortedKeys(attrs) {
+		var value map[string]interface{}
+		switch v := attrs[k].(type) {
+		case int:
+			value = map[string]interface{}{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		case uint64:
+			value = map[string]interface{}{"intValue": strconv.FormatUint(v, 10)}
+		case bool:
+			value = map[string]interface{}{"boolValue": v}
+		case float64:
+			value = map[string]interface{}{"doubleValue": v}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		out = append(out, map[string]interface{}{"key": k, "value": value})
+	}
+	return out
+}
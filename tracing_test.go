@@ -0,0 +1,76 @@
+package graphsplit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestTracing(t *testing.T) {
+	var mu sync.Mutex
+	var spans []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Authorization") != "Bearer t" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var req struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []map[string]interface{}
+				}
+			}
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}))
+	defer srv.Close()
+
+	shutdown, err := EnableTracing(TracingConfig{Endpoint: srv.URL + "/", Headers: map[string]string{"Authorization": "Bearer t"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, parent := startSpan(context.Background(), "slice", "slice", 1)
+	_, child := startSpan(ctx, "commp")
+	child.set("car_size", int64(42))
+	child.End(errors.New("boom"))
+	parent.End(nil)
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, s := startSpan(context.Background(), "after"); s != nil {
+		t.Fatal("expected no span after shutdown")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 exported spans, got %d", len(spans))
+	}
+	c, p := spans[0], spans[1]
+	if c["name"] != "commp" || p["name"] != "slice" {
+		t.Fatalf("unexpected span names %v %v", c["name"], p["name"])
+	}
+	if c["traceId"] != p["traceId"] || c["parentSpanId"] != p["spanId"] {
+		t.Fatalf("commp is not a child of slice: %v %v", c, p)
+	}
+	if _, ok := p["parentSpanId"]; ok {
+		t.Fatal("expected slice to be a root span")
+	}
+	if status, _ := c["status"].(map[string]interface{}); status["message"] != "boom" {
+		t.Fatalf("expected the error in the status, got %v", c["status"])
+	}
+}
@@ -0,0 +1,85 @@
+package graphsplit
+
+import (
+	"runtime"
+	"sort"
+)
+
+type storageKind int
+
+const (
+	storageUnknown storageKind = iota
+	storageRotational
+	storageSolidState
+)
+
+func (k storageKind) String() string {
+	switch k {
+	case storageRotational:
+		return "hdd"
+	case storageSolidState:
+		return "ssd"
+	default:
+		return "unknown"
+	}
+}
+
+// ParallelHint holds worker counts picked by TuneParallel.
+type ParallelHint struct {
+	// Build is the number of goroutines building file nodes
+	Build int
+}
+
+// TuneParallel picks worker counts from the number of CPUs, the kind of
+// storage holding path and the size distribution of files. Rotational disks
+// get few workers since concurrent readers turn sequential reads into seeks,
+// solid state storage gets a worker per CPU as hashing becomes the bottleneck.
+func TuneParallel(path string, files []Finfo) ParallelHint {
+	cpun := runtime.NumCPU()
+	kind := probeStorage(path)
+	median := medianFileSize(files)
+	hint := tuneParallel(cpun, kind, median)
+	log.Infof("parallel tuning: cpus %d, storage %s, median file size %d, build workers %d",
+		cpun, kind, median, hint.Build)
+	return hint
+}
+
+func tuneParallel(cpun int, kind storageKind, median int64) ParallelHint {
+	var build int
+	switch kind {
+	case storageRotational:
+		// big files are read sequentially, small files benefit from the disk
+		// reordering a few outstanding requests
+		build = 2
+		if median < 8<<20 {
+			build = 4
+		}
+	case storageSolidState:
+		build = cpun
+	default:
+		build = cpun / 2
+	}
+	return ParallelHint{Build: clampWorkers(build, cpun)}
+}
+
+func medianFileSize(files []Finfo) int64 {
+	if len(files) == 0 {
+		return 0
+	}
+	sizes := make([]int64, 0, len(files))
+	for _, f := range files {
+		sizes = append(sizes, f.Info.Size())
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+	return sizes[len(sizes)/2]
+}
+
+func clampWorkers(n, max int) int {
+	if n > max {
+		n = max
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
@@ -0,0 +1,35 @@
+package graphsplit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// probeStorage finds the block device holding path through sysfs and reads
+// whether the kernel flags it as rotational.
+func probeStorage(path string) storageKind {
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return storageUnknown
+	}
+	dev, err := filepath.EvalSymlinks(fmt.Sprintf("/sys/dev/block/%d:%d", unix.Major(uint64(st.Dev)), unix.Minor(uint64(st.Dev))))
+	if err != nil {
+		return storageUnknown
+	}
+	// partitions keep the queue attributes in the parent device
+	for _, dir := range []string{dev, filepath.Dir(dev)} {
+		data, err := os.ReadFile(filepath.Join(dir, "queue", "rotational"))
+		if err != nil {
+			continue
+		}
+		if strings.TrimSpace(string(data)) == "1" {
+			return storageRotational
+		}
+		return storageSolidState
+	}
+	return storageUnknown
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package graphsplit
+
+func probeStorage(path string) storageKind {
+	return storageUnknown
+}
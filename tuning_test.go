@@ -0,0 +1,47 @@
+package graphsplit
+
+import (
+	"testing"
+)
+
+func TestTuneParallel(t *testing.T) {
+	cases := []struct {
+		cpun   int
+		kind   storageKind
+		median int64
+		build  int
+	}{
+		{16, storageRotational, 64 << 20, 2},
+		{16, storageRotational, 1 << 20, 4},
+		{16, storageSolidState, 1 << 20, 16},
+		{16, storageUnknown, 1 << 20, 8},
+		{1, storageUnknown, 1 << 20, 1},
+		{2, storageRotational, 1 << 20, 2},
+	}
+	for _, c := range cases {
+		hint := tuneParallel(c.cpun, c.kind, c.median)
+		if hint.Build != c.build {
+			t.Errorf("%d cpus, %s, median %d: expected %d build workers, got %+v",
+				c.cpun, c.kind, c.median, c.build, hint)
+		}
+	}
+
+	hint := TuneParallel(t.TempDir(), nil)
+	if hint.Build < 1 {
+		t.Fatalf("expected at least one worker, got %+v", hint)
+	}
+}
+
+func TestMedianFileSize(t *testing.T) {
+	dir := writeTestTree(t, 10, 30, 20)
+	var files []Finfo
+	for item := range GetFileListAsync([]string{dir}) {
+		files = append(files, item)
+	}
+	if m := medianFileSize(files); m != 20 {
+		t.Fatalf("expected a median of 20, got %d", m)
+	}
+	if m := medianFileSize(nil); m != 0 {
+		t.Fatalf("expected 0 without files, got %d", m)
+	}
+}
@@ -0,0 +1,276 @@
+package graphsplit
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-padreader"
+)
+
+type s3UploadCallback struct {
+	client            *S3Client
+	carDir            string
+	padded            bool
+	deleteAfterUpload bool
+}
+
+// S3UploadCallback uploads every finished CAR, or its padded piece, to S3 and
+// records the object URL in the upload_url column of the manifest of carDir.
+// It has to follow the callback writing the CAR file and the manifest.
+func S3UploadCallback(carDir string, client *S3Client, padded, deleteAfterUpload bool) GraphBuildCallback {
+	return &s3UploadCallback{client: client, carDir: carDir, padded: padded, deleteAfterUpload: deleteAfterUpload}
+}
+
+// findManifestRow returns the manifest row of payloadCid, nil if there is no
+// such row or no manifest at all, in which case no CAR file was written either.
+func findManifestRow(carDir, payloadCid string) (ManifestRow, error) {
+	rows, err := ReadManifest(carDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var row ManifestRow
+	for _, r := range rows {
+		if r["payload_cid"] == payloadCid {
+			row = r
+		}
+	}
+	return row, nil
+}
+
+func (uc *s3UploadCallback) OnSuccess(buf *Buffer, slice *GraphSlice) {
+	row, err := findManifestRow(uc.carDir, slice.PayloadCid)
+	if err != nil {
+		log.Fatalf("failed to read manifest: %s", err)
+	}
+	carPath := locateCar(uc.carDir, row)
+	name := slice.PayloadCid + ".car"
+	if carPath != "" {
+		name = filepath.Base(carPath)
+	}
+
+	var r io.Reader = buf
+	size := int64(buf.Len())
+	if uc.padded {
+		pieceSize := int64(padreader.PaddedSize(uint64(size)))
+		r = io.MultiReader(buf, io.LimitReader(NullReader{}, pieceSize-size))
+		size = pieceSize
+	}
+	log.Infof("start to upload %s", name)
+	uploadStart := time.Now()
+	_, sp := startSpan(slice.context(), "upload", "name", name, "size", size)
+	url, err := uc.client.Upload(name, r, size)
+	sp.End(err)
+	if err != nil {
+		log.Fatalf("failed to upload %s: %s", name, err)
+	}
+	log.Infow("uploaded", slice.logFields("url", url, "duration", time.Since(uploadStart))...)
+
+	if row != nil {
+		if err := updateManifest(uc.carDir, slice.PayloadCid, map[string]string{"upload_url": url}); err != nil {
+			log.Fatalf("failed to record upload url of %s: %s", name, err)
+		}
+	}
+	if uc.deleteAfterUpload && carPath != "" {
+		if err := os.Remove(carPath); err != nil {
+			log.Errorf("failed to delete %s after upload: %s", carPath, err)
+		}
+	}
+}
+
+func (uc *s3UploadCallback) OnError(err error) {
+	log.Fatal(err)
+}
+
+// HTTPUploadConfig describes where and how finished pieces are sent over HTTP.
+type HTTPUploadConfig struct {
+	// URL is a template, {name}, {payload_cid}, {piece_cid} and {piece_size}
+	// are replaced by path escaped values
+	URL string
+	// Method is PUT or POST, PUT if empty
+	Method string
+	// Headers are sent with every request, their values are templated like URL
+	Headers map[string]string
+	// Token is sent as a bearer token
+	Token string
+	// Concurrency is the number of uploads in flight, 1 if not positive
+	Concurrency int
+	// Retries is the number of times a failed upload is retried
+	Retries int
+	// Padded uploads the padded piece instead of the CAR
+	Padded bool
+}
+
+// HTTPUploader is a callback sending every finished CAR, or its padded piece,
+// to a templated URL. Uploads run in the background, Wait blocks until they
+// are done. The URL is recorded in the upload_url column of the manifest.
+type HTTPUploader struct {
+	cfg      HTTPUploadConfig
+	carDir   string
+	client   *http.Client
+	throttle chan struct{}
+	wg       sync.WaitGroup
+}
+
+// HTTPUploadCallback has to follow the callback writing the CAR file and the
+// manifest.
+func HTTPUploadCallback(carDir string, cfg HTTPUploadConfig) (*HTTPUploader, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("upload url is required")
+	}
+	if cfg.Method == "" {
+		cfg.Method = http.MethodPut
+	}
+	cfg.Method = strings.ToUpper(cfg.Method)
+	if cfg.Method != http.MethodPut && cfg.Method != http.MethodPost {
+		return nil, fmt.Errorf("unsupported upload method %s", cfg.Method)
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 1
+	}
+	return &HTTPUploader{
+		cfg:      cfg,
+		carDir:   carDir,
+		client:   http.DefaultClient,
+		throttle: make(chan struct{}, cfg.Concurrency),
+	}, nil
+}
+
+func (hu *HTTPUploader) OnSuccess(buf *Buffer, slice *GraphSlice) {
+	row, err := findManifestRow(hu.carDir, slice.PayloadCid)
+	if err != nil {
+		log.Fatalf("failed to read manifest: %s", err)
+	}
+	carPath := locateCar(hu.carDir, row)
+	name := slice.PayloadCid + ".car"
+	if carPath != "" {
+		name = filepath.Base(carPath)
+	}
+	// the buffer is reused by the next slice, keep a copy if there is no file
+	var data []byte
+	if carPath == "" {
+		data = append([]byte(nil), buf.Bytes()...)
+	}
+	target := hu.expand(hu.cfg.URL, name, slice.PayloadCid, row, url.PathEscape)
+
+	metricQueueDepth.add("upload", 1)
+	hu.throttle <- struct{}{}
+	hu.wg.Add(1)
+	go func() {
+		defer func() {
+			<-hu.throttle
+			metricQueueDepth.add("upload", -1)
+			hu.wg.Done()
+		}()
+		log.Infof("start to upload %s to %s", name, target)
+		uploadStart := time.Now()
+		_, sp := startSpan(slice.context(), "upload", "name", name, "url", target)
+		err := hu.upload(target, name, slice.PayloadCid, row, carPath, data)
+		sp.End(err)
+		if err != nil {
+			log.Fatalf("failed to upload %s: %s", name, err)
+		}
+		log.Infow("uploaded", slice.logFields("url", target, "duration", time.Since(uploadStart))...)
+		if row != nil {
+			if err := updateManifest(hu.carDir, slice.PayloadCid, map[string]string{"upload_url": target}); err != nil {
+				log.Fatalf("failed to record upload url of %s: %s", name, err)
+			}
+		}
+	}()
+}
+
+func (hu *HTTPUploader) OnError(err error) {
+	log.Fatal(err)
+}
+
+// Wait blocks until all started uploads are done.
+func (hu *HTTPUploader) Wait() {
+	hu.wg.Wait()
+}
+
+func (hu *HTTPUploader) expand(template, name, payloadCid string, row ManifestRow, escape func(string) string) string {
+	return strings.NewReplacer(
+		"{name}", escape(name),
+		"{payload_cid}", escape(payloadCid),
+		"{piece_cid}", escape(row["piece_cid"]),
+		"{piece_size}", escape(row["piece_size"]),
+	).Replace(template)
+}
+
+// upload sends the CAR at carPath, or data if there is no file, retrying on
+// network errors, 429 and server errors.
+func (hu *HTTPUploader) upload(target, name, payloadCid string, row ManifestRow, carPath string, data []byte) error {
+	open := func() (io.ReadCloser, int64, error) {
+		if carPath == "" {
+			var r io.Reader = bytes.NewReader(data)
+			size := int64(len(data))
+			if hu.cfg.Padded {
+				pieceSize := int64(padreader.PaddedSize(uint64(size)))
+				r = io.MultiReader(r, io.LimitReader(NullReader{}, pieceSize-size))
+				size = pieceSize
+			}
+			return io.NopCloser(r), size, nil
+		}
+		pr, err := OpenPieceReader(carPath, 0)
+		if err != nil {
+			return nil, 0, err
+		}
+		r := pr.Payload()
+		if hu.cfg.Padded {
+			r = pr.Piece()
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{r, pr}, r.Size(), nil
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= hu.cfg.Retries; attempt++ {
+		if attempt > 0 {
+			wait := time.Duration(1<<(attempt-1)) * time.Second
+			log.Warnf("upload of %s failed: %s, retrying in %s", name, lastErr, wait)
+			time.Sleep(wait)
+		}
+		body, size, err := open()
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequest(hu.cfg.Method, target, body)
+		if err != nil {
+			body.Close()
+			return err
+		}
+		req.ContentLength = size
+		req.Header.Set("Content-Type", "application/octet-stream")
+		for k, v := range hu.cfg.Headers {
+			req.Header.Set(k, hu.expand(v, name, payloadCid, row, func(s string) string { return s }))
+		}
+		if hu.cfg.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+hu.cfg.Token)
+		}
+		resp, err := hu.client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			lastErr = fmt.Errorf("%s: %s", resp.Status, respBody)
+			continue
+		}
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("%s %s: %s: %s", hu.cfg.Method, target, resp.Status, respBody)
+		}
+		return nil
+	}
+	return lastErr
+}
@@ -0,0 +1,62 @@
+package graphsplit
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestHTTPUploadRetries(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		attempts int
+		got      []byte
+		header   http.Header
+		path     string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts == 1 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		got, _ = io.ReadAll(r.Body)
+		header = r.Header
+		path = r.URL.Path
+	}))
+	defer srv.Close()
+
+	hu, err := HTTPUploadCallback(t.TempDir(), HTTPUploadConfig{
+		URL:     srv.URL + "/pieces/{payload_cid}",
+		Headers: map[string]string{"X-Name": "{name}"},
+		Token:   "secret",
+		Retries: 1,
+		Padded:  true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte{1}, 100)
+	buf := NewBuffer(0)
+	buf.Write(data)
+	hu.OnSuccess(buf, &GraphSlice{PayloadCid: "bafytest"})
+	hu.Wait()
+
+	if attempts != 2 {
+		t.Fatalf("expected a retry, got %d attempts", attempts)
+	}
+	if path != "/pieces/bafytest" {
+		t.Fatalf("unexpected path %s", path)
+	}
+	if header.Get("Authorization") != "Bearer secret" || header.Get("X-Name") != "bafytest.car" {
+		t.Fatalf("unexpected headers %v", header)
+	}
+	if len(got) != 127 || !bytes.Equal(got[:100], data) || !bytes.Equal(got[100:], make([]byte, 27)) {
+		t.Fatalf("unexpected padded body of %d bytes", len(got))
+	}
+}
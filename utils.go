@@ -48,6 +48,17 @@ type Finfo struct {
 	Info      os.FileInfo
 	SeekStart int64
 	SeekEnd   int64
+
+	// member is set for files read from inside an archive
+	member *archiveMember
+}
+
+// partSize returns the number of bytes of the file covered by this item.
+func (fi Finfo) partSize() int64 {
+	if fi.SeekStart > 0 || fi.SeekEnd > 0 {
+		return fi.SeekEnd - fi.SeekStart + 1
+	}
+	return fi.Info.Size()
 }
 
 type SimpleFileInfo struct {
@@ -138,39 +149,88 @@ func (b *FSBuilder) getNodeByLink(ln *ipld.Link) (fn fsNode, err error) {
 	return
 }
 
+// BuildIpldGraph builds a graph slice from fileList and hands it to the
+// callback of params, it returns the payload cid, empty on failure.
 func BuildIpldGraph(ctx context.Context,
 	fileList []Finfo,
 	graphName string,
+	sliceSize int64,
 	params *ChunkParams,
-) {
+) string {
 	start := time.Now()
 	defer func() {
-		log.Infof("BuildIpldGraph took: %v", time.Since(start))
+		log.Infow("BuildIpldGraph took", "graph", graphName, "slice", params.sliceIndex, "duration", time.Since(start))
 	}()
-	buf, payloadCid, fsDetail, err := buildIpldGraph(ctx, fileList, params.ParentPath, params.Parallel,
-		params.ExpectSliceSize, params.Ef, params.SkipFilename)
+	params.progress.startSlice(graphName)
+	defer params.progress.sliceDone()
+	ctx, sp := startSpan(ctx, "slice", "graph_name", graphName, "index", params.sliceIndex)
+	var sliceErr error
+	defer func() { sp.End(sliceErr) }()
+	buildCtx, buildSpan := startSpan(ctx, "dag_build", "files", len(fileList))
+	buf, payloadCid, fsDetail, files, err := buildIpldGraph(buildCtx, fileList, graphName, sliceSize, params)
+	buildSpan.End(err)
+	sliceErr = err
+	if err != nil && ctx.Err() != nil {
+		// nothing of the slice was written yet
+		log.Warnf("building %s aborted: %s", graphName, err)
+		return ""
+	}
 	if err != nil {
+		metricErrors.add("slice", 1)
 		// log.Fatal(err)
 		params.Cb.OnError(err)
-		return
-	}
-	params.Cb.OnSuccess(buf, graphName, payloadCid, fsDetail)
+		return ""
+	}
+	metricStageSeconds.since("build", start)
+	log.Infow("slice built", "graph", graphName, "slice", params.sliceIndex, "payload_cid", payloadCid,
+		"files", len(files), "car_size", buf.Len(), "duration", time.Since(start))
+	for _, f := range files {
+		metricBytesChunked.add("", float64(f.Size))
+	}
+	metricFilesChunked.add("", float64(len(files)))
+	metricPieceBytes.add("", float64(buf.Len()))
+	sp.set("payload_cid", payloadCid, "car_size", buf.Len())
+	callbackStart := time.Now()
+	defer func() {
+		metricStageSeconds.since("callbacks", callbackStart)
+		metricPieces.add("", 1)
+	}()
+	params.Cb.OnSuccess(buf, &GraphSlice{
+		Name:       graphName,
+		PayloadCid: payloadCid,
+		FsDetail:   fsDetail,
+		SliceSize:  sliceSize,
+		BlockOrder: params.BlockOrder,
+		Encryption: params.Encryptor.ID(),
+		Sources:    sourceLocations(params.Source, fileList),
+		RootPath:   params.rootPath(fileList),
+		Files:      files,
+		Started:    start,
+		Index:      params.sliceIndex,
+		ctx:        ctx,
+	})
+	return payloadCid
 }
 
 func buildIpldGraph(ctx context.Context,
 	fileList []Finfo,
-	parentPath string,
-	parallel int,
+	graphName string,
 	sliceSize int64,
-	ef *ExtraFile,
-	skipFilename bool,
-) (*Buffer, string, string, error) {
-	bs2 := bstore.NewBlockstore(dss.MutexWrap(datastore.NewMapDatastore()))
+	params *ChunkParams,
+) (*Buffer, string, string, []SliceFile, error) {
+	parentPath := params.ParentPath
+	parallel := params.parallel
+	ef := params.Ef
+	skipFilename := params.SkipFilename
+	budget := params.budget
+	readLimiter := NewRateLimiter(params.ReadRate)
+
+	bs2 := newOrderedBlockstore(bstore.NewBlockstore(dss.MutexWrap(datastore.NewMapDatastore())))
 	dagServ := dag.NewDAGService(blockservice.New(bs2, offline.Exchange(bs2)))
 
 	cidBuilder, err := dag.PrefixForCidVersion(1)
 	if err != nil {
-		return nil, "", "", err
+		return nil, "", "", nil, err
 	}
 	fileNodeMap := make(map[string]*dag.ProtoNode)
 	dirNodeMap := make(map[string]*dag.ProtoNode)
@@ -188,36 +248,74 @@ func buildIpldGraph(ctx context.Context,
 	if parallel > cpun {
 		parallel = cpun
 	}
+	parallel = budget.maxWorkers(parallel)
 	pchan := make(chan struct{}, parallel)
 	wg := sync.WaitGroup{}
 	lock := sync.Mutex{}
-	sfis := make([]SimpleFileInfo, 0, len(fileList))
+	var buildErr error
 	for i, item := range fileList {
-		sfis = append(sfis, SimpleFileInfo{item.Path, item.SeekStart, item.SeekEnd})
 		wg.Add(1)
+		metricQueueDepth.add("build", 1)
 		go func(i int, item Finfo) {
 			defer func() {
 				<-pchan
+				metricQueueDepth.add("build", -1)
 				wg.Done()
 			}()
 			pchan <- struct{}{}
-			fileNode, err := BuildFileNode(item, dagServ, cidBuilder)
-			if err != nil {
-				log.Warn(err)
+			params.Control.waitResume()
+			if ctx.Err() != nil {
 				return
 			}
-			fn, ok := fileNode.(*dag.ProtoNode)
-			if !ok {
-				log.Warn("file node should be *dag.ProtoNode")
+			reserved := budget.acquire(item.partSize())
+			defer budget.release(reserved)
+			// extra files are added to every slice, they are not progress
+			progress := params.progress
+			if ef != nil && ef.path != "" && strings.HasPrefix(item.Path, ef.path) {
+				progress = nil
+			}
+			fileNode, err := params.fileErrors.build(ctx, item.Path, graphName, func() (ipld.Node, error) {
+				return buildFileNode(ctx, item, dagServ, cidBuilder, readLimiter, progress, params.HashWorkers, params.Encryptor, params.Source, params.Retry)
+			})
+			if err == nil && fileNode == nil {
+				// skipped
 				return
 			}
+			fn, ok := fileNode.(*dag.ProtoNode)
+			if err == nil && !ok {
+				err = fmt.Errorf("file node of %s should be *dag.ProtoNode", item.Path)
+			}
 			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				if buildErr == nil {
+					buildErr = fmt.Errorf("failed to read %s: %w", item.Path, err)
+				}
+				return
+			}
+			progress.fileDone()
 			fileNodeMap[item.Path] = fn
-			lock.Unlock()
 			// log.Infof("path: %s, file node: %s", item.Path, fileNode)
 		}(i, item)
 	}
 	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, "", "", nil, err
+	}
+	if buildErr != nil {
+		return nil, "", "", nil, buildErr
+	}
+	// files without node were skipped
+	built := make([]Finfo, 0, len(fileList))
+	sfis := make([]SimpleFileInfo, 0, len(fileList))
+	for _, item := range fileList {
+		if _, ok := fileNodeMap[item.Path]; ok {
+			built = append(built, item)
+			sfis = append(sfis, SimpleFileInfo{item.Path, item.SeekStart, item.SeekEnd})
+		}
+	}
+	skipped := len(built) < len(fileList)
+	fileList = built
 
 	// build dir tree
 	for _, item := range fileList {
@@ -290,7 +388,7 @@ func buildIpldGraph(ctx context.Context,
 			if isLinked(parentNode, dir) {
 				parentNode, err = parentNode.UpdateNodeLink(dir, dirNode)
 				if err != nil {
-					return nil, "", "", err
+					return nil, "", "", nil, err
 				}
 				dirNodeMap[parentKey] = parentNode
 			} else {
@@ -311,11 +409,22 @@ func buildIpldGraph(ctx context.Context,
 	genCarStartTime := time.Now()
 	// car
 	buf := NewBuffer(int(sliceSize))
-	selector := allSelector()
-	sc := car.NewSelectiveCar(ctx, bs2, []car.Dag{{Root: rootNode.Cid(), Selector: selector}})
-	err = sc.Write(buf)
+	if params.BlockOrder == BlockOrderStream {
+		var keep map[cid.Cid]struct{}
+		if skipped {
+			// skipped files leave the blocks read before they failed behind
+			if keep, err = reachableBlocks(ctx, dagServ, rootNode.Cid()); err != nil {
+				return nil, "", "", nil, err
+			}
+		}
+		err = bs2.writeCar(ctx, rootNode.Cid(), buf, keep)
+	} else {
+		selector := allSelector()
+		sc := car.NewSelectiveCar(ctx, bs2, []car.Dag{{Root: rootNode.Cid(), Selector: selector}})
+		err = sc.Write(buf)
+	}
 	if err != nil {
-		return nil, "", "", err
+		return nil, "", "", nil, err
 	}
 	log.Infof("generate car file completed, time elapsed: %s", time.Since(genCarStartTime))
 
@@ -342,7 +451,7 @@ func buildIpldGraph(ctx context.Context,
 
 	fileInfo, err := json.Marshal(infos)
 	if err != nil {
-		return nil, "", "", err
+		return nil, "", "", nil, err
 	}
 	log.Info("++++++++++++ finished to build ipld +++++++++++++")
 
@@ -365,11 +474,21 @@ func buildIpldGraph(ctx context.Context,
 
 		fileInfo, err = json.Marshal(list)
 		if err != nil {
-			return nil, "", "", err
+			return nil, "", "", nil, err
 		}
 	}
 
-	return buf, rootNode.Cid().String(), string(fileInfo), nil
+	files := make([]SliceFile, 0, len(fileList))
+	for _, item := range fileList {
+		files = append(files, SliceFile{
+			Path:     item.Path,
+			FileSize: item.Info.Size(),
+			Offset:   item.SeekStart,
+			Size:     item.partSize(),
+			Cid:      fileNodeMap[item.Path].Cid().String(),
+		})
+	}
+	return buf, rootNode.Cid().String(), string(fileInfo), files, nil
 }
 
 func allSelector() ipldprime.Node {
@@ -449,22 +568,20 @@ func (fs *fileSlice) Read(p []byte) (n int, err error) {
 }
 
 func BuildFileNode(item Finfo, bufDs ipld.DAGService, cidBuilder cid.Builder) (node ipld.Node, err error) {
-	var r io.Reader
-	f, err := os.Open(item.Path)
-	if err != nil {
-		return nil, err
-	}
-	r = f
+	return buildFileNode(context.Background(), item, bufDs, cidBuilder, nil, nil, 1, nil, nil, RetryPolicy{})
+}
 
+func buildFileNode(ctx context.Context, item Finfo, bufDs ipld.DAGService, cidBuilder cid.Builder, limiter *RateLimiter, progress *progressTracker, hashWorkers int, enc *Encryptor, src Source, retry RetryPolicy) (node ipld.Node, err error) {
 	// read all data of item
-	if item.SeekStart > 0 || item.SeekEnd > 0 {
-		r = &fileSlice{
-			r:        f,
-			start:    item.SeekStart,
-			end:      item.SeekEnd,
-			fileSize: item.Info.Size(),
-		}
+	var r io.ReadCloser
+	err = retry.do(ctx, "open of "+item.Path, func() (err error) {
+		r, err = openItem(item, src)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
+	defer r.Close()
 
 	params := ihelper.DagBuilderParams{
 		Maxlinks:   UnixfsLinksPerLevel,
@@ -473,17 +590,36 @@ func BuildFileNode(item Finfo, bufDs ipld.DAGService, cidBuilder cid.Builder) (n
 		Dagserv:    bufDs,
 		NoCopy:     false,
 	}
-	db, err := params.New(chunker.NewSizeSplitter(r, int64(UnixfsChunkSize)))
+	spl := chunker.NewSizeSplitter(enc.EncryptReader(limiter.Reader(progress.Reader(&ctxReader{ctx: ctx, r: retry.reader(ctx, r, item.Path)}))), int64(UnixfsChunkSize))
+	db, err := params.New(spl)
 	if err != nil {
 		return nil, err
 	}
-	node, err = balanced.Layout(db)
+	if hashWorkers > 1 {
+		node, err = parallelLayout(db, spl, cidBuilder, hashWorkers)
+	} else {
+		node, err = balanced.Layout(db)
+	}
 	if err != nil {
 		return nil, err
 	}
 	return
 }
 
+// ctxReader fails reads once ctx is done, so a canceled run stops in the
+// middle of a large file.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *ctxReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
+
 func GenGraphName(graphName string, sliceCount, sliceTotal int) string {
 	if sliceTotal == 1 {
 		return fmt.Sprintf("%s.car", graphName)
@@ -511,6 +647,18 @@ func GetGraphCount(args []string, sliceSize int64) int {
 	return int(count)
 }
 
+// graphCount is GetGraphCount for files that have already been listed.
+func graphCount(files []Finfo, sliceSize int64) int {
+	var totalSize int64 = 0
+	for _, f := range files {
+		totalSize += f.Info.Size()
+	}
+	if totalSize == 0 {
+		return 0
+	}
+	return int(totalSize/sliceSize) + 1
+}
+
 func GetFileListAsync(args []string) chan Finfo {
 	fichan := make(chan Finfo, 1)
 	go func() {
@@ -0,0 +1,225 @@
+package graphsplit
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+)
+
+type VerifyStatus string
+
+const (
+	VerifyOK      VerifyStatus = "ok"
+	VerifyCorrupt VerifyStatus = "corrupt"
+	VerifyMissing VerifyStatus = "missing"
+)
+
+// VerifyResult is the verification result of a restored file, or of a CAR
+// file or manifest row when the error concerns it as a whole.
+type VerifyResult struct {
+	Path   string       `json:"path"`
+	Cid    string       `json:"cid"`
+	Car    string       `json:"car,omitempty"`
+	Status VerifyStatus `json:"status"`
+	Error  string       `json:"error,omitempty"`
+}
+
+// VerifyReport collects the results of a restore with WithVerify.
+type VerifyReport struct {
+	mu sync.Mutex
+	// roots are the payload cids of the restored CAR files
+	roots map[string]bool
+
+	Results []VerifyResult `json:"results"`
+}
+
+func NewVerifyReport() *VerifyReport {
+	return &VerifyReport{roots: make(map[string]bool)}
+}
+
+// WithVerify checks the multihash of every block read from the CAR files and
+// rebuilds the DAG of every restored file to compare its cid, the results
+// are added to report.
+func WithVerify(report *VerifyReport) RestoreOption {
+	return func(o *restoreOptions) {
+		o.verify = report
+	}
+}
+
+func (vr *VerifyReport) add(res VerifyResult) {
+	vr.mu.Lock()
+	defer vr.mu.Unlock()
+	vr.Results = append(vr.Results, res)
+}
+
+// Failed returns the results which are not ok, ordered by path.
+func (vr *VerifyReport) Failed() []VerifyResult {
+	vr.mu.Lock()
+	defer vr.mu.Unlock()
+	var failed []VerifyResult
+	for _, res := range vr.Results {
+		if res.Status != VerifyOK {
+			failed = append(failed, res)
+		}
+	}
+	sort.Slice(failed, func(i, j int) bool { return failed[i].Path < failed[j].Path })
+	return failed
+}
+
+// CheckManifest adds a missing result for every row of manifest.csv in
+// carDir whose payload cid was not restored.
+func (vr *VerifyReport) CheckManifest(carDir string) error {
+	rows, err := ReadManifest(carDir)
+	if err != nil {
+		return err
+	}
+	vr.mu.Lock()
+	defer vr.mu.Unlock()
+	for _, row := range rows {
+		if !vr.roots[row["payload_cid"]] {
+			vr.Results = append(vr.Results, VerifyResult{
+				Path:   row["filename"],
+				Cid:    row["payload_cid"],
+				Status: VerifyMissing,
+				Error:  "no CAR file holds the payload cid of this manifest row",
+			})
+		}
+	}
+	return nil
+}
+
+// verifyingStore checks the blocks loaded from a CAR file against their cid,
+// corrupt blocks are stored anyway so the files holding them are restored
+// and reported, unless drop is set and they are treated as missing.
+type verifyingStore struct {
+	car.Store
+	corrupt int
+	drop    bool
+}
+
+// loadCar is car.LoadCar, except that the blocks are put into a
+// verifyingStore unchecked, go-car rejects the whole CAR at the first block
+// which does not match its cid.
+func loadCar(ctx context.Context, st car.Store, r io.Reader) (*car.CarHeader, error) {
+	if _, ok := st.(*verifyingStore); !ok {
+		return car.LoadCar(ctx, st, r)
+	}
+	br := bufio.NewReader(r)
+	header, err := car.ReadHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		c, data, err := carutil.ReadNode(br)
+		if err == io.EOF {
+			return header, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		blk, err := blocks.NewBlockWithCid(data, c)
+		if err != nil {
+			return nil, err
+		}
+		if err := st.Put(ctx, blk); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (vs *verifyingStore) Put(ctx context.Context, blk blocks.Block) error {
+	sum, err := blk.Cid().Prefix().Sum(blk.RawData())
+	if err != nil || !sum.Equals(blk.Cid()) {
+		log.Errorf("block %s does not match its cid", blk.Cid())
+		vs.corrupt++
+		if vs.drop {
+			return nil
+		}
+	}
+	return vs.Store.Put(ctx, blk)
+}
+
+// verifyFiles compares the cid of every file restored from the DAG below
+// root with the cid of the same file rebuilt from the restored bytes.
+// Decrypted files differ from the DAG, they only fail with corrupt blocks.
+func (vr *VerifyReport) verifyFiles(ctx context.Context, carPath string, root cid.Cid, bs bstore.Blockstore, corruptBlocks int, outputDir string, o restoreOptions) error {
+	vr.mu.Lock()
+	vr.roots[root.String()] = true
+	vr.mu.Unlock()
+
+	ps := &pathSelector{ctx: ctx, bs: bs, get: func(c cid.Cid) ([]byte, error) {
+		blk, err := bs.Get(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		return blk.RawData(), nil
+	}}
+	found := make(map[string]cid.Cid)
+	if err := ps.files(root, "", found); err != nil {
+		return err
+	}
+	for rel, c := range found {
+		res := VerifyResult{Path: rel, Cid: c.String(), Car: carPath, Status: VerifyOK}
+		if corruptBlocks > 0 && o.decryptor != nil {
+			res.Status = VerifyCorrupt
+			res.Error = fmt.Sprintf("%d corrupt blocks in %s", corruptBlocks, carPath)
+		} else if o.decryptor == nil {
+			restored, err := restoredFileCid(filepath.Join(outputDir, filepath.FromSlash(rel)), o.placed)
+			switch {
+			case os.IsNotExist(err):
+				res.Status = VerifyMissing
+				res.Error = err.Error()
+			case err != nil:
+				res.Status = VerifyCorrupt
+				res.Error = err.Error()
+			case !restored.Equals(c):
+				res.Status = VerifyCorrupt
+				res.Error = fmt.Sprintf("restored file has cid %s", restored)
+			}
+		}
+		vr.add(res)
+	}
+	return nil
+}
+
+// restoredFileCid builds the DAG of the file at path like chunking does and
+// returns its cid, the blocks are discarded. Parts written in place are read
+// from their range of the merged file.
+func restoredFileCid(path string, placed map[string]partPlacement) (cid.Cid, error) {
+	item := Finfo{Path: path}
+	if pl, ok := placed[path]; ok {
+		item = Finfo{Path: pl.target, SeekStart: pl.offset, SeekEnd: pl.offset + pl.size - 1}
+	}
+	fi, err := os.Stat(item.Path)
+	if err != nil {
+		return cid.Undef, err
+	}
+	item.Name = fi.Name()
+	item.Info = fi
+	cidBuilder, err := merkledag.PrefixForCidVersion(1)
+	if err != nil {
+		return cid.Undef, err
+	}
+	bs := bstore.NewBlockstore(datastore.NewNullDatastore())
+	dagServ := merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs)))
+	nd, err := buildFileNode(context.Background(), item, dagServ, cidBuilder, nil, nil, 1, nil, nil, RetryPolicy{})
+	if err != nil {
+		return cid.Undef, err
+	}
+	return nd.Cid(), nil
+}
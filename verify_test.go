@@ -0,0 +1,81 @@
+package graphsplit
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	dag "github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+)
+
+func TestRestoreVerify(t *testing.T) {
+	dir := t.TempDir()
+	data := make([]byte, 3<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	src := filepath.Join(dir, "f.bin")
+	if err := os.WriteFile(src, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fi, _ := os.Stat(src)
+
+	ctx := context.Background()
+	bs := bstore.NewBlockstore(dss.MutexWrap(datastore.NewMapDatastore()))
+	dagServ := dag.NewDAGService(blockservice.New(bs, offline.Exchange(bs)))
+	cidBuilder, _ := dag.PrefixForCidVersion(1)
+	fileNode, err := buildFileNode(context.Background(), Finfo{Path: src, Name: "f.bin", Info: fi}, dagServ, cidBuilder, nil, nil, 1, nil, nil, RetryPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := unixfs.EmptyDirNode()
+	root.SetCidBuilder(cidBuilder)
+	root.AddNodeLink("f.bin", fileNode)
+	blks := []blocks.Block{root}
+	// the blockstore keys blocks by multihash, walk the DAG for their CIDs
+	walk := []cid.Cid{fileNode.Cid()}
+	for len(walk) > 0 {
+		nd, err := dagServ.Get(ctx, walk[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		walk = walk[1:]
+		blks = append(blks, nd)
+		for _, l := range nd.Links() {
+			walk = append(walk, l.Cid)
+		}
+	}
+	carDir := filepath.Join(dir, "cars")
+	os.Mkdir(carDir, 0o755)
+	carPath := filepath.Join(carDir, "a.car")
+	writeTestCar(t, carPath, blks)
+
+	restore := func() *VerifyReport {
+		out := t.TempDir()
+		report := NewVerifyReport()
+		carTo(carDir, out, 1, WithVerify(report)) //nolint:errcheck
+		return report
+	}
+	if report := restore(); len(report.Results) != 1 || len(report.Failed()) != 0 {
+		t.Fatalf("unexpected results %+v", report.Results)
+	}
+
+	// corrupt a byte of the file data inside the CAR
+	carData, _ := os.ReadFile(carPath)
+	i := bytes.Index(carData, data[2<<20:2<<20+64])
+	carData[i+10] ^= 0xff
+	os.WriteFile(carPath, carData, 0o644)
+	failed := restore().Failed()
+	if len(failed) != 1 || failed[0].Status != VerifyCorrupt || failed[0].Path != "f.bin" {
+		t.Fatalf("unexpected failures %+v", failed)
+	}
+}
@@ -0,0 +1,160 @@
+package graphsplit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	WebhookPieceCompleted = "piece_completed"
+	WebhookError          = "error"
+)
+
+// WebhookEvent is the JSON body posted to the webhook after every slice.
+type WebhookEvent struct {
+	Event       string    `json:"event"`
+	Time        time.Time `json:"time"`
+	GraphName   string    `json:"graph_name,omitempty"`
+	PayloadCid  string    `json:"payload_cid,omitempty"`
+	PieceCid    string    `json:"piece_cid,omitempty"`
+	PieceSize   uint64    `json:"piece_size,omitempty"`
+	PayloadSize int64     `json:"payload_size,omitempty"`
+	CarFile     string    `json:"car_file,omitempty"`
+	CarFileSize int64     `json:"car_file_size,omitempty"`
+	// BatchID is the batch of the piece in the manifest, see ExportBatch
+	BatchID int `json:"batch_id,omitempty"`
+	// Duration is the time in seconds from the start of the slice to the
+	// end of the callbacks before the webhook
+	Duration float64 `json:"duration,omitempty"`
+	Error    string  `json:"error,omitempty"`
+	// Text is a one line summary, it is what Slack incoming webhooks show
+	Text string `json:"text"`
+}
+
+type WebhookConfig struct {
+	URL string
+	// Headers are sent with every request
+	Headers map[string]string
+	// Retries is the number of times a failed post is retried
+	Retries int
+	// Timeout of a single post, 10s if not positive
+	Timeout time.Duration
+}
+
+type webhookCallback struct {
+	next   GraphBuildCallback
+	carDir string
+	cfg    WebhookConfig
+	client *http.Client
+}
+
+// WebhookCallback wraps next, it posts a WebhookEvent once next handled a
+// slice, and before next handles an error since the built-in callbacks exit
+// the process on errors. A failed post is logged and does not stop chunking.
+func WebhookCallback(next GraphBuildCallback, carDir string, cfg WebhookConfig) (GraphBuildCallback, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("webhook url is required")
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	return &webhookCallback{
+		next:   next,
+		carDir: carDir,
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+	}, nil
+}
+
+func (wc *webhookCallback) OnSuccess(buf *Buffer, slice *GraphSlice) {
+	wc.next.OnSuccess(buf, slice)
+
+	ev := &WebhookEvent{
+		Event:      WebhookPieceCompleted,
+		Time:       time.Now().UTC(),
+		GraphName:  slice.Name,
+		PayloadCid: slice.PayloadCid,
+	}
+	if !slice.Started.IsZero() {
+		ev.Duration = time.Since(slice.Started).Seconds()
+	}
+	row, err := findManifestRow(wc.carDir, slice.PayloadCid)
+	if err != nil {
+		log.Warnf("failed to read manifest for webhook: %s", err)
+	}
+	if row != nil {
+		ev.PieceCid = row["piece_cid"]
+		ev.PieceSize, _ = strconv.ParseUint(row["piece_size"], 10, 64)
+		ev.PayloadSize, _ = strconv.ParseInt(row["payload_size"], 10, 64)
+		ev.BatchID, _ = strconv.Atoi(row["batch_id"])
+		if carPath := locateCar(wc.carDir, row); carPath != "" {
+			ev.CarFile = carPath
+			if fi, err := os.Stat(carPath); err == nil {
+				ev.CarFileSize = fi.Size()
+			}
+		}
+	}
+	ev.Text = fmt.Sprintf("graphsplit: %s finished, payload %s, piece %s (%d bytes) in %.1fs",
+		slice.Name, slice.PayloadCid, ev.PieceCid, ev.PieceSize, ev.Duration)
+	wc.post(ev)
+}
+
+func (wc *webhookCallback) OnError(err error) {
+	wc.post(&WebhookEvent{
+		Event: WebhookError,
+		Time:  time.Now().UTC(),
+		Error: err.Error(),
+		Text:  fmt.Sprintf("graphsplit: failed to build a slice: %s", err),
+	})
+	wc.next.OnError(err)
+}
+
+// post sends ev, retrying on network errors, 429 and server errors.
+func (wc *webhookCallback) post(ev *WebhookEvent) {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		log.Warnf("failed to encode webhook event: %s", err)
+		return
+	}
+	var lastErr error
+	for attempt := 0; attempt <= wc.cfg.Retries; attempt++ {
+		if attempt > 0 {
+			wait := time.Duration(1<<(attempt-1)) * time.Second
+			log.Warnf("webhook failed: %s, retrying in %s", lastErr, wait)
+			time.Sleep(wait)
+		}
+		req, err := http.NewRequest(http.MethodPost, wc.cfg.URL, bytes.NewReader(body))
+		if err != nil {
+			log.Warnf("failed to post webhook: %s", err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range wc.cfg.Headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := wc.client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			lastErr = fmt.Errorf("%s: %s", resp.Status, respBody)
+			continue
+		}
+		if resp.StatusCode >= 300 {
+			metricErrors.add("webhook", 1)
+			log.Warnf("webhook %s rejected %s event: %s: %s", wc.cfg.URL, ev.Event, resp.Status, respBody)
+		}
+		return
+	}
+	metricErrors.add("webhook", 1)
+	log.Warnf("failed to post %s event to webhook: %s", ev.Event, lastErr)
+}
@@ -0,0 +1,72 @@
+package graphsplit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type manifestRowCallback struct {
+	carDir string
+	errs   []error
+}
+
+func (mc *manifestRowCallback) OnSuccess(buf *Buffer, slice *GraphSlice) {
+	row := map[string]string{"payload_cid": slice.PayloadCid, "piece_cid": "baga-test", "piece_size": "2048", "payload_size": "1500", "batch_id": "3"}
+	if err := appendManifest(mc.carDir, []string{"payload_cid", "piece_cid", "piece_size", "payload_size", "batch_id"}, row); err != nil {
+		panic(err)
+	}
+}
+
+func (mc *manifestRowCallback) OnError(err error) {
+	mc.errs = append(mc.errs, err)
+}
+
+func TestWebhookCallback(t *testing.T) {
+	var (
+		events   []WebhookEvent
+		attempts int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get("X-Token") != "secret" {
+			t.Errorf("missing header")
+		}
+		var ev WebhookEvent
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			t.Error(err)
+		}
+		events = append(events, ev)
+	}))
+	defer srv.Close()
+
+	next := &manifestRowCallback{carDir: t.TempDir()}
+	cb, err := WebhookCallback(next, next.carDir, WebhookConfig{
+		URL:     srv.URL,
+		Headers: map[string]string{"X-Token": "secret"},
+		Retries: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb.OnSuccess(NewBuffer(0), &GraphSlice{Name: "graph-1", PayloadCid: "bafytest", Started: time.Now().Add(-time.Second)})
+	cb.OnError(errors.New("disk full"))
+
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	ev := events[0]
+	if ev.Event != WebhookPieceCompleted || ev.PieceCid != "baga-test" || ev.PieceSize != 2048 || ev.PayloadSize != 1500 || ev.BatchID != 3 || ev.Duration < 1 {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+	if events[1].Event != WebhookError || events[1].Error != "disk full" || len(next.errs) != 1 {
+		t.Fatalf("unexpected error event %+v", events[1])
+	}
+}